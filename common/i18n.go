@@ -0,0 +1,363 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LocaleAuto = "auto" // 按请求的 Accept-Language 选择
+	LocaleEN   = "en"
+	LocaleZH   = "zh"
+)
+
+var defaultLocale atomic.Value
+
+func init() {
+	defaultLocale.Store(LocaleAuto)
+}
+
+// SetDefaultLocale 设置面向客户端错误信息的语言，auto 表示按 Accept-Language 选择
+func SetDefaultLocale(locale string) {
+	switch locale {
+	case LocaleEN, LocaleZH:
+	default:
+		locale = LocaleAuto
+	}
+	defaultLocale.Store(locale)
+}
+
+// DefaultLocale 获取当前配置的语言
+func DefaultLocale() string {
+	return defaultLocale.Load().(string)
+}
+
+// RequestLocale 确定本次请求使用的语言：配置固定语言时直接使用，否则解析 Accept-Language
+func RequestLocale(c *gin.Context) string {
+	if locale := DefaultLocale(); locale != LocaleAuto {
+		return locale
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		switch {
+		case strings.HasPrefix(tag, "zh"):
+			return LocaleZH
+		case strings.HasPrefix(tag, "en"):
+			return LocaleEN
+		}
+	}
+	return LocaleEN
+}
+
+// zhMessages 英文错误信息到中文的映射，键为错误信息中 ": " 之前的固定部分
+var zhMessages = map[string]string{
+	"Invalid request body":                                    "请求体格式错误",
+	"Invalid ID format":                                       "ID 格式错误",
+	"Invalid provider_id format":                              "provider_id 格式错误",
+	"Invalid model_id format":                                 "model_id 格式错误",
+	"Invalid model_provider_id format":                        "model_provider_id 格式错误",
+	"Invalid days parameter":                                  "days 参数错误",
+	"Invalid page parameter":                                  "page 参数错误",
+	"Invalid hours parameter":                                 "hours 参数错误",
+	"Invalid interval parameter":                              "interval 参数错误",
+	"Invalid source parameter":                                "source 参数错误",
+	"Invalid shadow percent":                                  "影子流量比例必须在 1 到 100 之间",
+	"Shadow association not found for model":                  "影子关联不存在或不属于该模型",
+	"Invalid tolerance":                                       "tolerance 参数错误",
+	"Invalid provider type":                                   "供应商类型错误",
+	"Invalid style":                                           "请求格式类型错误",
+	"Invalid pattern":                                         "正则表达式错误",
+	"Invalid locale":                                          "语言设置错误",
+	"Invalid config format":                                   "配置格式错误",
+	"No IDs provided":                                         "未提供 ID",
+	"Database error":                                          "数据库错误",
+	"Provider not found":                                      "供应商不存在",
+	"Provider already exists":                                 "供应商已存在",
+	"Route not found":                                         "接口不存在",
+	"Model not found":                                         "模型不存在",
+	"ModelWithProvider not found":                             "模型供应商关联不存在",
+	"Model-provider association not found":                    "模型供应商关联不存在",
+	"Log not found":                                           "日志不存在",
+	"ChatIO not found":                                        "输入输出记录不存在",
+	"No drain has been started":                               "该关联尚未开始排空",
+	"Association is not draining":                             "该关联不在排空中",
+	"No replay is running":                                    "当前没有正在运行的回放",
+	"API key not found":                                       "API Key 不存在",
+	"API key has expired":                                     "API Key 已过期",
+	"WebSocket upgrade required":                              "需要 WebSocket 升级请求",
+	"Model not allowed for this API key":                      "该 API Key 无权访问此模型",
+	"API key request rate limit exceeded":                     "API Key 请求频率超出限制",
+	"API key token rate limit exceeded":                       "API Key token 用量超出每分钟限制",
+	"Model request rate limit exceeded":                       "模型请求频率超出限制",
+	"Model token rate limit exceeded":                         "模型 token 用量超出每分钟限制",
+	"Request queue timeout":                                   "请求排队超时",
+	"rpm and tpm must not be negative":                        "rpm 和 tpm 不能为负数",
+	"snapshot_interval must not be negative":                  "snapshot_interval 不能为负数",
+	"API key token quota exceeded":                            "API Key token 配额已用尽",
+	"API key cost quota exceeded":                             "API Key 费用配额已用尽",
+	"Invalid response rules":                                  "无效的响应后处理规则",
+	"Price must not be negative":                              "单价不能为负数",
+	"Pricing not found":                                       "定价不存在",
+	"Quota must not be negative":                              "配额不能为负数",
+	"Invalid date format, expected YYYY-MM-DD":                "日期格式错误，应为 YYYY-MM-DD",
+	"Invalid quota period":                                    "无效的配额周期",
+	"Invalid log level":                                       "无效的日志级别",
+	"Invalid priority class":                                  "无效的优先级",
+	"Invalid fallback model":                                  "无效的备用模型",
+	"Fallback model not found":                                "备用模型不存在",
+	"Invalid alias":                                           "无效的别名",
+	"Alias already used by model":                             "别名已被其他模型使用",
+	"Invalid sticky session ttl":                              "无效的会话粘滞有效期",
+	"Invalid routing strategy":                                "无效的供应商选择策略",
+	"Invalid stream heartbeat interval":                       "无效的流式心跳间隔",
+	"Invalid timeouts":                                        "无效的超时设置",
+	"Provider name and model name are required":               "供应商名称与模型名称不能为空",
+	"Invalid model settings":                                  "无效的模型设置",
+	"Invalid provider config":                                 "无效的供应商配置",
+	"Instance is already configured":                          "实例已完成初始化",
+	"Invalid health check pacing":                             "健康检测限速设置无效",
+	"Invalid health check failure policy":                     "健康检测失败处理设置无效",
+	"Invalid settings":                                        "设置无效",
+	"Incident title is required":                              "故障标题不能为空",
+	"Provider already has an open incident":                   "该供应商已有未关闭的故障",
+	"Provider has no open incident":                           "该供应商没有未关闭的故障",
+	"Invalid provider_id":                                     "无效的 provider_id",
+	"Message batch not found":                                 "消息批处理不存在",
+	"Message batch is still in progress":                      "消息批处理尚未结束",
+	"Invalid number of batch requests":                        "批处理请求数量无效",
+	"Batch custom_id must be unique and non-empty":            "批处理请求的 custom_id 不能为空且不能重复",
+	"Invalid batch request params":                            "无效的批处理请求参数",
+	"Streaming is not supported in batch requests":            "批处理请求不支持流式输出",
+	"Invalid min_turns parameter":                             "min_turns 参数错误",
+	"Invalid sort parameter":                                  "sort 参数错误",
+	"Conversation not found":                                  "会话不存在",
+	"Invalid limit parameter":                                 "无效的 limit 参数",
+	"API key is no longer valid":                              "API Key 已失效",
+	"Invalid quarantine settings":                             "无效的请求隔离设置",
+	"Invalid request queue settings":                          "无效的请求排队设置",
+	"Invalid files settings":                                  "无效的文件上传设置",
+	"File not found":                                          "文件不存在",
+	"File exceeds the upload size limit":                      "文件超出上传大小限制",
+	"Invalid file upload":                                     "无效的文件上传",
+	"No provider available for files":                         "没有支持文件接口的可用供应商",
+	"Files request failed":                                    "文件请求失败",
+	"Auth failure not found":                                  "密钥失效记录不存在",
+	"Quarantine entry not found":                              "隔离记录不存在",
+	"request quarantined after repeated failures":             "请求多次被所有供应商拒绝，已暂时隔离",
+	"invalid desired state":                                   "无效的期望状态",
+	"Invalid redaction rules":                                 "无效的日志脱敏规则",
+	"Invalid request rewrites":                                "无效的请求改写规则",
+	"Invalid context length":                                  "无效的上下文长度",
+	"Invalid probes":                                          "无效的探测项",
+	"Invalid health check config":                             "无效的健康检测配置",
+	"Invalid image limits":                                    "无效的图片上限",
+	"tpm_limit must not be negative":                          "tpm_limit 不能为负数",
+	"Invalid summarize settings":                              "上下文压缩设置无效",
+	"Invalid max input tokens":                                "无效的输入 token 上限",
+	"Invalid retry policy":                                    "无效的重试策略",
+	"Invalid log sample rate":                                 "无效的日志采样率",
+	"Invalid api_key_id":                                      "无效的 api_key_id",
+	"User agent rule not found":                               "用户代理规则不存在",
+	"Notification not found":                                  "通知渠道不存在",
+	"Invalid notification":                                    "无效的通知渠道",
+	"Invalid notification settings":                           "无效的通知设置",
+	"Invalid email settings":                                  "无效的邮件告警设置",
+	"No relabel job has been started":                         "尚未启动过重新归一化任务",
+	"No replay has been started":                              "尚未启动过回放",
+	"No health check batch has been started":                  "尚未运行过批量健康检测",
+	"Billing file contains no records":                        "账单文件中没有记录",
+	"Missing billing file":                                    "缺少账单文件",
+	"Invalid billing file":                                    "账单文件格式错误",
+	"Failed to open billing file":                             "打开账单文件失败",
+	"Authorization header is missing":                         "缺少 Authorization 请求头",
+	"Invalid authorization header":                            "Authorization 请求头格式错误",
+	"Invalid token":                                           "令牌无效",
+	"Read-only token cannot modify resources":                 "只读令牌不能修改资源",
+	"Read-only token cannot access this endpoint":             "只读令牌不能访问该接口",
+	"User is not allowed to access llmio":                     "该用户无权访问 llmio",
+	"OIDC login is not enabled":                               "未启用 OIDC 登录",
+	"Invalid or expired login state":                          "登录状态无效或已过期",
+	"OIDC login failed":                                       "OIDC 登录失败",
+	"Provider returned non-200 status code":                   "供应商返回了非 200 状态码",
+	"Failed to connect to provider":                           "连接供应商失败",
+	"Authorization header or x-api-key header is missing":     "缺少 Authorization 或 x-api-key 请求头",
+	"Invalid page_size parameter (must be between 1 and 100)": "page_size 参数错误（需在 1 到 100 之间）",
+	"Invalid limit parameter (must be between 1 and 50)":      "limit 参数错误（需在 1 到 50 之间）",
+	"model_id query parameter is required":                    "缺少 model_id 查询参数",
+	"model_provider_id query parameter is required":           "缺少 model_provider_id 查询参数",
+	"provider_id, model_name and provider_model query parameters are required": "缺少 provider_id、model_name 或 provider_model 查询参数",
+	"burn_rate_threshold must be greater than 0":                               "burn_rate_threshold 必须大于 0",
+	"timeout_seconds must not be negative":                                     "timeout_seconds 不能为负数",
+	"long_context_tokens must not be negative":                                 "long_context_tokens 不能为负数",
+	"poll_interval must not be negative":                                       "poll_interval 不能为负数",
+	"model is empty":                                                           "模型名不能为空",
+	"input is empty":                                                           "input 不能为空",
+	"retry time out":                                                           "重试超时",
+	"maximum retry attempts reached":                                           "已达到最大重试次数",
+	"a replay is already running":                                              "已有回放正在运行",
+	"association is already draining":                                          "该关联已在排空中",
+	"a relabel job is already running":                                         "已有重新归一化任务正在运行",
+	"a health check batch is already running":                                  "已有一轮批量健康检测正在运行",
+}
+
+// zhActions "Failed to <action>" 中 action 的中文，组合为 "<动作>失败"
+var zhActions = map[string]string{
+	"update settings":                             "更新设置",
+	"get settings":                                "获取设置",
+	"get models":                                  "获取模型",
+	"create model":                                "创建模型",
+	"update model":                                "更新模型",
+	"delete model":                                "删除模型",
+	"delete models":                               "批量删除模型",
+	"retrieve updated model":                      "获取更新后的模型",
+	"create provider":                             "创建供应商",
+	"update provider":                             "更新供应商",
+	"delete provider":                             "删除供应商",
+	"retrieve provider":                           "获取供应商",
+	"retrieve updated provider":                   "获取更新后的供应商",
+	"create model-provider association":           "创建模型供应商关联",
+	"update model-provider association":           "更新模型供应商关联",
+	"delete model-provider association":           "删除模型供应商关联",
+	"delete model-provider associations":          "批量删除模型供应商关联",
+	"retrieve model-provider association":         "获取模型供应商关联",
+	"retrieve updated model-provider association": "获取更新后的模型供应商关联",
+	"update status":                               "更新状态",
+	"enable associations":                         "启用关联",
+	"reset weights":                               "重置权重",
+	"reset priorities":                            "重置优先级",
+	"query logs":                                  "查询日志",
+	"count logs":                                  "统计日志",
+	"delete log":                                  "删除日志",
+	"delete logs":                                 "批量删除日志",
+	"clear logs":                                  "清空日志",
+	"retrieve chat log":                           "获取请求日志",
+	"query user agents":                           "查询用户代理",
+	"query api keys":                              "查询 API Key",
+	"create api key":                              "创建 API Key",
+	"update api key":                              "更新 API Key",
+	"delete api key":                              "删除 API Key",
+	"query rate limits":                           "查询限流配置",
+	"update rate limit":                           "更新限流配置",
+	"export config":                               "导出配置",
+	"import config":                               "导入配置",
+	"apply desired state":                         "应用期望状态",
+	"query leader lease":                          "查询主节点租约",
+	"import catalog":                              "导入模型目录",
+	"create message batch":                        "创建消息批处理",
+	"query message batches":                       "查询消息批处理",
+	"query files":                                 "查询文件",
+	"cancel message batch":                        "取消消息批处理",
+	"delete message batch":                        "删除消息批处理",
+	"run setup":                                   "执行初始化",
+	"open incident":                               "确认故障",
+	"close incident":                              "关闭故障",
+	"query incidents":                             "查询故障记录",
+	"query pricing":                               "查询定价",
+	"update pricing":                              "更新定价",
+	"delete pricing":                              "删除定价",
+	"query auth failures":                         "查询密钥失效记录",
+	"reset provider state":                        "重置供应商状态",
+	"clear auth failure":                          "解除密钥失效隔离",
+	"query conversations":                         "查询会话统计",
+	"query spend":                                 "查询花费",
+	"query cache metrics":                         "查询缓存命中率",
+	"query metrics rollups":                       "查询聚合指标",
+	"query latency metrics":                       "查询时延分布",
+	"query error metrics":                         "查询错误分类统计",
+	"probe model-provider association":            "探测模型能力",
+	"query usage":                                 "查询用量",
+	"update quota":                                "更新配额",
+	"reset quota":                                 "重置配额",
+	"retrieve api key":                            "获取 API Key",
+	"query user agent rules":                      "查询用户代理规则",
+	"create user agent rule":                      "创建用户代理规则",
+	"update user agent rule":                      "更新用户代理规则",
+	"delete user agent rule":                      "删除用户代理规则",
+	"retrieve user agent rule":                    "获取用户代理规则",
+	"reload user agent rules":                     "加载用户代理规则",
+	"query notifications":                         "查询通知渠道",
+	"create notification":                         "创建通知渠道",
+	"update notification":                         "更新通知渠道",
+	"delete notification":                         "删除通知渠道",
+	"retrieve notification":                       "获取通知渠道",
+	"send notification":                           "发送通知",
+	"send email":                                  "发送邮件",
+	"count requests":                              "统计请求数",
+	"sum tokens":                                  "统计 token 数",
+	"count cancelled requests":                    "统计取消的请求数",
+	"update fallbacks":                            "更新备用模型",
+	"update aliases":                              "更新模型别名",
+	"update shadow":                               "更新影子流量",
+	"query shadow stats":                          "查询影子流量指标",
+	"query duplicate responses":                   "查询重复响应",
+	"query empty responses":                       "查询空响应",
+	"count tokens":                                "统计 token 数",
+	"run health check":                            "执行健康检测",
+	"retrieve health check logs":                  "获取健康检测日志",
+	"query health check logs":                     "查询健康检测日志",
+	"count health check logs":                     "统计健康检测日志",
+	"clear health check logs":                     "清空健康检测日志",
+	"read res body":                               "读取响应体",
+	"import billing records":                      "导入账单记录",
+	"query billing records":                       "查询账单记录",
+	"reconcile billing":                           "账单对账",
+	"compute slo":                                 "计算 SLO",
+	"poll status pages":                           "轮询状态页",
+	"compute suggestions":                         "计算权重建议",
+	"apply suggestions":                           "应用权重建议",
+}
+
+// zhPatterns 含动态内容的错误信息
+var zhPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`^Model: (.+) already exists$`), "模型 $1 已存在"},
+	{regexp.MustCompile(`^not found model (.+)$`), "模型 $1 不存在"},
+	{regexp.MustCompile(`^not provider for model (.+)$`), "模型 $1 没有可用的供应商"},
+	{regexp.MustCompile(`^no logs found on (.+)$`), "$1 没有请求日志"},
+}
+
+// Localize 将面向客户端的错误信息翻译为指定语言，未收录的信息原样返回；
+// 形如 "固定前缀: 详情" 的信息只翻译前缀，详情保持原文便于排查
+func Localize(locale, message string) string {
+	if locale != LocaleZH || message == "" {
+		return message
+	}
+	prefix, detail, hasDetail := strings.Cut(message, ": ")
+	if translated, ok := translateZH(prefix); ok {
+		if hasDetail {
+			return translated + "：" + detail
+		}
+		return translated
+	}
+	if translated, ok := translateZH(message); ok {
+		return translated
+	}
+	return message
+}
+
+func translateZH(message string) (string, bool) {
+	if translated, ok := zhMessages[message]; ok {
+		return translated, true
+	}
+	if action, ok := strings.CutPrefix(message, "Failed to "); ok {
+		if translated, ok := zhActions[action]; ok {
+			return translated + "失败", true
+		}
+	}
+	for _, pattern := range zhPatterns {
+		if pattern.re.MatchString(message) {
+			return pattern.re.ReplaceAllString(message, pattern.repl), true
+		}
+	}
+	return "", false
+}
+
+// localize 按请求语言翻译错误信息
+func localize(c *gin.Context, message string) string {
+	return Localize(RequestLocale(c), message)
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		locale  string
+		message string
+		want    string
+	}{
+		{name: "exact message", locale: LocaleZH, message: "Model not found", want: "模型不存在"},
+		{name: "prefix with detail", locale: LocaleZH, message: "Invalid request body: unexpected EOF", want: "请求体格式错误：unexpected EOF"},
+		{name: "failed action", locale: LocaleZH, message: "Failed to create model", want: "创建模型失败"},
+		{name: "failed action with detail", locale: LocaleZH, message: "Failed to delete log: record locked", want: "删除日志失败：record locked"},
+		{name: "pattern", locale: LocaleZH, message: "not provider for model gpt-4o", want: "模型 gpt-4o 没有可用的供应商"},
+		{name: "pattern containing separator", locale: LocaleZH, message: "Model: gpt-4o already exists", want: "模型 gpt-4o 已存在"},
+		{name: "unknown action", locale: LocaleZH, message: "Failed to launch rocket", want: "Failed to launch rocket"},
+		{name: "unknown message", locale: LocaleZH, message: "something odd", want: "something odd"},
+		{name: "english", locale: LocaleEN, message: "Model not found", want: "Model not found"},
+		{name: "empty", locale: LocaleZH, message: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Localize(tt.locale, tt.message); got != tt.want {
+				t.Errorf("Localize(%s, %q) = %q, want %q", tt.locale, tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	t.Cleanup(func() { SetDefaultLocale(LocaleAuto) })
+
+	tests := []struct {
+		name           string
+		defaultLocale  string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "chinese header", defaultLocale: LocaleAuto, acceptLanguage: "zh-CN,zh;q=0.9,en;q=0.8", want: LocaleZH},
+		{name: "english first", defaultLocale: LocaleAuto, acceptLanguage: "en-US,zh;q=0.5", want: LocaleEN},
+		{name: "skips unsupported tags", defaultLocale: LocaleAuto, acceptLanguage: "fr-FR, ZH-TW;q=0.8", want: LocaleZH},
+		{name: "missing header", defaultLocale: LocaleAuto, acceptLanguage: "", want: LocaleEN},
+		{name: "fixed locale wins", defaultLocale: LocaleZH, acceptLanguage: "en-US", want: LocaleZH},
+		{name: "invalid setting falls back to auto", defaultLocale: "fr", acceptLanguage: "zh", want: LocaleZH},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDefaultLocale(tt.defaultLocale)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/", nil)
+			if tt.acceptLanguage != "" {
+				c.Request.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if got := RequestLocale(c); got != tt.want {
+				t.Errorf("RequestLocale = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
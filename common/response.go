@@ -41,7 +41,7 @@ func SuccessWithMessage(c *gin.Context, message string, data any) {
 func Error(c *gin.Context, code int, message string) {
 	c.JSON(http.StatusOK, Response{
 		Code:    code,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
 
@@ -49,7 +49,7 @@ func Error(c *gin.Context, code int, message string) {
 func ErrorWithHttpStatus(c *gin.Context, httpStatus int, code int, message string) {
 	c.JSON(httpStatus, Response{
 		Code:    code,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
 
@@ -57,8 +57,8 @@ func ErrorWithHttpStatus(c *gin.Context, httpStatus int, code int, message strin
 func InternalServerError(c *gin.Context, message string) {
 	c.JSON(http.StatusInternalServerError, Response{
 		Code:    500,
-		Error:   message,
-		Message: message,
+		Error:   message, // 保留原文便于排查
+		Message: localize(c, message),
 	})
 }
 
@@ -66,7 +66,7 @@ func InternalServerError(c *gin.Context, message string) {
 func BadRequest(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, Response{
 		Code:    http.StatusBadRequest,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
 
@@ -74,7 +74,7 @@ func BadRequest(c *gin.Context, message string) {
 func NotFound(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, Response{
 		Code:    404,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
 
@@ -82,7 +82,7 @@ func NotFound(c *gin.Context, message string) {
 func Unauthorized(c *gin.Context, message string) {
 	c.JSON(http.StatusUnauthorized, Response{
 		Code:    401,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
 
@@ -90,7 +90,7 @@ func Unauthorized(c *gin.Context, message string) {
 func Forbidden(c *gin.Context, message string) {
 	c.JSON(http.StatusForbidden, Response{
 		Code:    403,
-		Message: message,
+		Message: localize(c, message),
 	})
 }
  
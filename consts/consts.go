@@ -6,5 +6,12 @@ const (
 	StyleOpenAI    Style = "openai"
 	StyleOpenAIRes Style = "openai-res"
 	StyleAnthropic Style = "anthropic"
+	StyleGemini    Style = "gemini" // 仅作为供应商类型，客户端请求经格式转换后转发
+	StyleOllama    Style = "ollama" // 仅作为供应商类型，Ollama 等本地推理服务，请求格式与 openai 一致
+
+	// StyleOpenAIEmbeddings 仅作为客户端格式使用，由支持 embeddings 的供应商直接透传
+	StyleOpenAIEmbeddings Style = "openai-embeddings"
+	// StyleOpenAIRealtime 仅作为客户端格式使用，WebSocket 会话透传给支持 Realtime API 的供应商
+	StyleOpenAIRealtime Style = "openai-realtime"
 )
  
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdvisorSettingsRequest 权重建议自动应用设置
+type AdvisorSettingsRequest struct {
+	AutoApply bool `json:"auto_apply"`
+	Interval  int  `json:"interval"` // 小时
+}
+
+// GetWeightSuggestions 获取权重/优先级调整建议（days 为统计天数，默认 7）
+func GetWeightSuggestions(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	suggestions, err := service.SuggestWeights(c.Request.Context(), days)
+	if err != nil {
+		common.InternalServerError(c, "Failed to compute suggestions: "+err.Error())
+		return
+	}
+	common.Success(c, suggestions)
+}
+
+// ApplyWeightSuggestions 立即计算并应用权重建议
+func ApplyWeightSuggestions(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	ctx := c.Request.Context()
+	suggestions, err := service.SuggestWeights(ctx, days)
+	if err != nil {
+		common.InternalServerError(c, "Failed to compute suggestions: "+err.Error())
+		return
+	}
+	applied, err := service.ApplyWeightSuggestions(ctx, suggestions)
+	if err != nil {
+		common.InternalServerError(c, "Failed to apply suggestions: "+err.Error())
+		return
+	}
+	common.Success(c, map[string]any{
+		"applied":     applied,
+		"suggestions": suggestions,
+	})
+}
+
+// GetAdvisorSettings 获取权重建议自动应用设置
+func GetAdvisorSettings(c *gin.Context) {
+	autoApply, interval := service.GetAdvisorSettings(c.Request.Context())
+	common.Success(c, AdvisorSettingsRequest{
+		AutoApply: autoApply,
+		Interval:  interval,
+	})
+}
+
+// UpdateAdvisorSettings 更新权重建议自动应用设置
+func UpdateAdvisorSettings(c *gin.Context) {
+	var req AdvisorSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Interval < 1 {
+		req.Interval = 24
+	}
+
+	ctx := c.Request.Context()
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyWeightAdvisorAutoApply)).
+		Update(ctx, "value", strconv.FormatBool(req.AutoApply)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyWeightAdvisorInterval)).
+		Update(ctx, "value", strconv.Itoa(req.Interval)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+
+	common.Success(c, req)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AliasRequest 模型别名设置请求结构
+type AliasRequest struct {
+	Aliases []string `json:"aliases"` // 完全相同、末尾 * 前缀或含 * / ? 的通配符
+}
+
+// AliasResponse 模型的别名
+type AliasResponse struct {
+	ID      uint     `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// GetModelAliases 获取模型的别名
+func GetModelAliases(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	common.Success(c, aliasResponse(model))
+}
+
+// UpdateModelAliases 设置模型的别名，别名不能与已有模型同名、不能重复，也不能已被其他模型使用
+func UpdateModelAliases(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	var req AliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	others, err := gorm.G[models.Model](models.DB).Select("id", "name", "aliases").Where("id != ?", model.ID).Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	owners := make(map[string]string)
+	for _, other := range others {
+		owners[other.Name] = other.Name
+		for _, alias := range other.Aliases {
+			owners[alias] = other.Name
+		}
+	}
+	seen := make(map[string]bool, len(req.Aliases))
+	for _, alias := range req.Aliases {
+		if err := service.ValidateAliasPattern(alias); err != nil || alias == model.Name || seen[alias] {
+			common.BadRequest(c, "Invalid alias: "+alias)
+			return
+		}
+		seen[alias] = true
+		if owner, ok := owners[alias]; ok {
+			common.BadRequest(c, "Alias already used by model: "+owner)
+			return
+		}
+	}
+
+	model.Aliases = req.Aliases
+	if err := models.DB.WithContext(ctx).Model(&model).Select("aliases").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update aliases: "+err.Error())
+		return
+	}
+	common.Success(c, aliasResponse(model))
+}
+
+// DeleteModelAliases 清空模型的别名
+func DeleteModelAliases(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	model.Aliases = nil
+	if err := models.DB.WithContext(c.Request.Context()).Model(&model).Select("aliases").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update aliases: "+err.Error())
+		return
+	}
+	common.Success(c, aliasResponse(model))
+}
+
+func aliasResponse(model models.Model) AliasResponse {
+	aliases := model.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+	return AliasResponse{ID: model.ID, Name: model.Name, Aliases: aliases}
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 
 	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/middleware"
 	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/providers"
 	"github.com/atopos31/llmio/service"
@@ -23,6 +24,14 @@ type ProviderRequest struct {
 	Config  string `json:"config"`
 	Console string `json:"console"`
 	Proxy   string `json:"proxy"`
+
+	StatusPage     string `json:"status_page"`
+	StatusPagePath string `json:"status_page_path"`
+
+	ImageMaxDimension int `json:"image_max_dimension"`
+	ImageMaxBytes     int `json:"image_max_bytes"`
+
+	TPMLimit int `json:"tpm_limit"`
 }
 
 // ModelRequest represents the request body for creating/updating a model
@@ -32,6 +41,44 @@ type ModelRequest struct {
 	MaxRetry int    `json:"max_retry"`
 	TimeOut  int    `json:"time_out"`
 	IOLog    bool   `json:"io_log"`
+
+	ConnectTimeout    int `json:"connect_timeout"`     // 连接超时（秒），0 表示默认 10 秒
+	FirstTokenTimeout int `json:"first_token_timeout"` // 首字超时（秒），0 表示沿用 time_out
+	StreamIdleTimeout int `json:"stream_idle_timeout"` // 流空闲超时（秒），0 表示默认 300 秒
+
+	MaxOutputTokens int `json:"max_output_tokens"`
+	MaxOutputBytes  int `json:"max_output_bytes"`
+
+	ToolAuditWebhook string `json:"tool_audit_webhook"`
+
+	SLOFirstTokenMs int     `json:"slo_first_token_ms"`
+	SLOTarget       float64 `json:"slo_target"`
+	SLOWindowHours  int     `json:"slo_window_hours"`
+
+	ResponseRules *models.ResponseRules `json:"response_rules"` // 为空时不修改，传入 {} 清空规则
+
+	LogLevel string `json:"log_level"` // none、metadata、prompts、full、raw，为空时不修改
+
+	LogSampleRate int `json:"log_sample_rate"` // 成功请求每 N 个记录 1 个，0 时不修改，1 表示全部记录
+
+	RoutingStrategy string `json:"routing_strategy"` // weight、cost、latency，为空时不修改
+
+	StreamHeartbeatSeconds int `json:"stream_heartbeat_seconds"` // 等待上游首个字节期间的 SSE 心跳间隔（秒），0 表示不发送
+
+	StickySession    bool `json:"sticky_session"`     // 会话粘滞
+	StickySessionTTL int  `json:"sticky_session_ttl"` // 会话粘滞有效期（秒），0 时不修改
+
+	RetryBackoffMs    int `json:"retry_backoff_ms"`     // 首次重试前的退避时间（毫秒）
+	RetryBackoffMaxMs int `json:"retry_backoff_max_ms"` // 单次退避上限（毫秒）
+	RetryMaxElapsedMs int `json:"retry_max_elapsed_ms"` // 允许重试的最长时间（毫秒）
+	RetryBudget       int `json:"retry_budget"`         // 每分钟允许的重试次数
+
+	SummarizeThreshold int    `json:"summarize_threshold"` // 估算输入 token 超过该值时压缩较早的对话
+	SummarizeModel     string `json:"summarize_model"`     // 生成摘要使用的模型
+	SummarizeKeep      int    `json:"summarize_keep"`      // 至少保留的最近消息数
+
+	MaxInputTokens       int  `json:"max_input_tokens"`       // 输入 token 上限，超出时转发前拒绝，0 表示不限制
+	PreflightCountTokens bool `json:"preflight_count_tokens"` // Anthropic 格式请求先调用上游 count_tokens 统计
 }
 
 // ModelWithProviderRequest represents the request body for creating/updating a model-provider association
@@ -42,10 +89,17 @@ type ModelWithProviderRequest struct {
 	ToolCall         bool              `json:"tool_call"`
 	StructuredOutput bool              `json:"structured_output"`
 	Image            bool              `json:"image"`
+	PromptCache      bool              `json:"prompt_cache"`
 	WithHeader       bool              `json:"with_header"`
 	CustomerHeaders  map[string]string `json:"customer_headers"`
 	Weight           int               `json:"weight"`
 	Priority         int               `json:"priority"`
+
+	ContextLength *int `json:"context_length"` // 上下文窗口 token 数，0 表示使用导入的元数据，为空时不修改
+
+	RequestRewrites []models.RequestRewrite `json:"request_rewrites"` // 请求改写规则，为空时不修改，传入 [] 清空规则
+
+	HealthCheck *models.HealthCheckConfig `json:"health_check"` // 健康检测配置，为空时不修改，传入 {} 恢复默认
 }
 
 // ModelProviderStatusRequest represents the request body for updating provider status
@@ -84,7 +138,7 @@ func GetProviders(c *gin.Context) {
 		return
 	}
 
-	common.Success(c, providers)
+	common.Success(c, redactProviders(providers...))
 }
 
 func GetProviderModels(c *gin.Context) {
@@ -122,6 +176,16 @@ func GetProviderModels(c *gin.Context) {
 	common.Success(c, models)
 }
 
+// redactProviders 返回配置中密钥字段已脱敏的供应商副本
+func redactProviders(list ...models.Provider) []models.Provider {
+	redacted := make([]models.Provider, len(list))
+	for i, provider := range list {
+		provider.Config = models.RedactConfigSecrets(provider.Config)
+		redacted[i] = provider
+	}
+	return redacted
+}
+
 func dropCustomModels(config string) (string, error) {
 	var parsed map[string]any
 	if err := json.Unmarshal([]byte(config), &parsed); err != nil {
@@ -144,6 +208,14 @@ func CreateProvider(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if req.ImageMaxDimension < 0 || req.ImageMaxBytes < 0 {
+		common.BadRequest(c, "Invalid image limits")
+		return
+	}
+	if req.TPMLimit < 0 {
+		common.BadRequest(c, "tpm_limit must not be negative")
+		return
+	}
 
 	// Check if provider exists
 	count, err := gorm.G[models.Provider](models.DB).Where("name = ?", req.Name).Count(c.Request.Context(), "id")
@@ -163,6 +235,14 @@ func CreateProvider(c *gin.Context) {
 		Config:  req.Config,
 		Console: req.Console,
 		Proxy:   req.Proxy,
+
+		StatusPage:     req.StatusPage,
+		StatusPagePath: req.StatusPagePath,
+
+		ImageMaxDimension: req.ImageMaxDimension,
+		ImageMaxBytes:     req.ImageMaxBytes,
+
+		TPMLimit: req.TPMLimit,
 	}
 
 	if err := gorm.G[models.Provider](models.DB).Create(c.Request.Context(), &provider); err != nil {
@@ -170,7 +250,7 @@ func CreateProvider(c *gin.Context) {
 		return
 	}
 
-	common.Success(c, provider)
+	common.Success(c, redactProviders(provider)[0])
 }
 
 // UpdateProvider 更新提供商
@@ -187,9 +267,18 @@ func UpdateProvider(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if req.ImageMaxDimension < 0 || req.ImageMaxBytes < 0 {
+		common.BadRequest(c, "Invalid image limits")
+		return
+	}
+	if req.TPMLimit < 0 {
+		common.BadRequest(c, "tpm_limit must not be negative")
+		return
+	}
 
 	// Check if provider exists
-	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context()); err != nil {
+	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			common.NotFound(c, "Provider not found")
 			return
@@ -198,6 +287,9 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
+	// 客户端提交的是接口返回的脱敏配置时保留原密钥
+	req.Config = models.RestoreConfigSecrets(req.Config, provider.Config)
+
 	// Update fields
 	updates := models.Provider{
 		Name:    req.Name,
@@ -205,6 +297,14 @@ func UpdateProvider(c *gin.Context) {
 		Config:  req.Config,
 		Console: req.Console,
 		Proxy:   req.Proxy,
+
+		StatusPage:     req.StatusPage,
+		StatusPagePath: req.StatusPagePath,
+
+		ImageMaxDimension: req.ImageMaxDimension,
+		ImageMaxBytes:     req.ImageMaxBytes,
+
+		TPMLimit: req.TPMLimit,
 	}
 
 	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).Updates(c.Request.Context(), updates); err != nil {
@@ -212,6 +312,14 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
+	// 更换密钥或地址后重置关联的衰减与隔离状态
+	if service.ProviderConfigChanged(provider.Config, req.Config) {
+		if _, err := service.ResetProviderState(c.Request.Context(), provider.ID); err != nil {
+			common.InternalServerError(c, "Failed to reset provider state: "+err.Error())
+			return
+		}
+	}
+
 	// Get updated provider
 	updatedProvider, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(c.Request.Context())
 	if err != nil {
@@ -219,7 +327,7 @@ func UpdateProvider(c *gin.Context) {
 		return
 	}
 
-	common.Success(c, updatedProvider)
+	common.Success(c, redactProviders(updatedProvider)[0])
 }
 
 // DeleteProvider 删除提供商
@@ -258,6 +366,11 @@ func GetModels(c *gin.Context) {
 		common.InternalServerError(c, err.Error())
 		return
 	}
+	if middleware.ReadOnly(c) {
+		for i := range modelsList {
+			modelsList[i].ToolAuditWebhook = models.RedactSecret(modelsList[i].ToolAuditWebhook)
+		}
+	}
 
 	common.Success(c, modelsList)
 }
@@ -269,6 +382,46 @@ func CreateModel(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if err := service.ValidateResponseRules(req.ResponseRules); err != nil {
+		common.BadRequest(c, "Invalid response rules: "+err.Error())
+		return
+	}
+	if !service.ValidLogLevel(req.LogLevel) {
+		common.BadRequest(c, "Invalid log level")
+		return
+	}
+	if req.LogSampleRate < 0 {
+		common.BadRequest(c, "Invalid log sample rate")
+		return
+	}
+	if !service.ValidRoutingStrategy(req.RoutingStrategy) {
+		common.BadRequest(c, "Invalid routing strategy")
+		return
+	}
+	if req.StreamHeartbeatSeconds < 0 {
+		common.BadRequest(c, "Invalid stream heartbeat interval")
+		return
+	}
+	if req.ConnectTimeout < 0 || req.FirstTokenTimeout < 0 || req.StreamIdleTimeout < 0 {
+		common.BadRequest(c, "Invalid timeouts")
+		return
+	}
+	if req.StickySessionTTL < 0 {
+		common.BadRequest(c, "Invalid sticky session ttl")
+		return
+	}
+	if req.RetryBackoffMs < 0 || req.RetryBackoffMaxMs < 0 || req.RetryMaxElapsedMs < 0 || req.RetryBudget < 0 {
+		common.BadRequest(c, "Invalid retry policy")
+		return
+	}
+	if req.SummarizeThreshold < 0 || req.SummarizeKeep < 0 || (req.SummarizeModel != "" && req.SummarizeModel == req.Name) {
+		common.BadRequest(c, "Invalid summarize settings")
+		return
+	}
+	if req.MaxInputTokens < 0 {
+		common.BadRequest(c, "Invalid max input tokens")
+		return
+	}
 
 	// Check if model exists
 	count, err := gorm.G[models.Model](models.DB).Where("name = ?", req.Name).Count(c.Request.Context(), "id")
@@ -287,6 +440,44 @@ func CreateModel(c *gin.Context) {
 		MaxRetry: req.MaxRetry,
 		TimeOut:  req.TimeOut,
 		IOLog:    &req.IOLog,
+
+		ConnectTimeout:    req.ConnectTimeout,
+		FirstTokenTimeout: req.FirstTokenTimeout,
+		StreamIdleTimeout: req.StreamIdleTimeout,
+
+		MaxOutputTokens: req.MaxOutputTokens,
+		MaxOutputBytes:  req.MaxOutputBytes,
+
+		ToolAuditWebhook: req.ToolAuditWebhook,
+
+		SLOFirstTokenMs: req.SLOFirstTokenMs,
+		SLOTarget:       req.SLOTarget,
+		SLOWindowHours:  req.SLOWindowHours,
+
+		ResponseRules: req.ResponseRules,
+
+		LogLevel: req.LogLevel,
+
+		LogSampleRate: req.LogSampleRate,
+
+		RoutingStrategy: req.RoutingStrategy,
+
+		StreamHeartbeatSeconds: req.StreamHeartbeatSeconds,
+
+		StickySession:    &req.StickySession,
+		StickySessionTTL: req.StickySessionTTL,
+
+		RetryBackoffMs:    req.RetryBackoffMs,
+		RetryBackoffMaxMs: req.RetryBackoffMaxMs,
+		RetryMaxElapsedMs: req.RetryMaxElapsedMs,
+		RetryBudget:       req.RetryBudget,
+
+		SummarizeThreshold: req.SummarizeThreshold,
+		SummarizeModel:     req.SummarizeModel,
+		SummarizeKeep:      req.SummarizeKeep,
+
+		MaxInputTokens:       req.MaxInputTokens,
+		PreflightCountTokens: &req.PreflightCountTokens,
 	}
 
 	if err := gorm.G[models.Model](models.DB).Create(c.Request.Context(), &model); err != nil {
@@ -311,6 +502,46 @@ func UpdateModel(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if err := service.ValidateResponseRules(req.ResponseRules); err != nil {
+		common.BadRequest(c, "Invalid response rules: "+err.Error())
+		return
+	}
+	if !service.ValidLogLevel(req.LogLevel) {
+		common.BadRequest(c, "Invalid log level")
+		return
+	}
+	if req.LogSampleRate < 0 {
+		common.BadRequest(c, "Invalid log sample rate")
+		return
+	}
+	if !service.ValidRoutingStrategy(req.RoutingStrategy) {
+		common.BadRequest(c, "Invalid routing strategy")
+		return
+	}
+	if req.StreamHeartbeatSeconds < 0 {
+		common.BadRequest(c, "Invalid stream heartbeat interval")
+		return
+	}
+	if req.ConnectTimeout < 0 || req.FirstTokenTimeout < 0 || req.StreamIdleTimeout < 0 {
+		common.BadRequest(c, "Invalid timeouts")
+		return
+	}
+	if req.StickySessionTTL < 0 {
+		common.BadRequest(c, "Invalid sticky session ttl")
+		return
+	}
+	if req.RetryBackoffMs < 0 || req.RetryBackoffMaxMs < 0 || req.RetryMaxElapsedMs < 0 || req.RetryBudget < 0 {
+		common.BadRequest(c, "Invalid retry policy")
+		return
+	}
+	if req.SummarizeThreshold < 0 || req.SummarizeKeep < 0 || (req.SummarizeModel != "" && req.SummarizeModel == req.Name) {
+		common.BadRequest(c, "Invalid summarize settings")
+		return
+	}
+	if req.MaxInputTokens < 0 {
+		common.BadRequest(c, "Invalid max input tokens")
+		return
+	}
 
 	// Check if model exists
 	_, err = gorm.G[models.Model](models.DB).Where("id = ?", id).First(c.Request.Context())
@@ -330,6 +561,44 @@ func UpdateModel(c *gin.Context) {
 		MaxRetry: req.MaxRetry,
 		TimeOut:  req.TimeOut,
 		IOLog:    &req.IOLog,
+
+		ConnectTimeout:    req.ConnectTimeout,
+		FirstTokenTimeout: req.FirstTokenTimeout,
+		StreamIdleTimeout: req.StreamIdleTimeout,
+
+		MaxOutputTokens: req.MaxOutputTokens,
+		MaxOutputBytes:  req.MaxOutputBytes,
+
+		ToolAuditWebhook: req.ToolAuditWebhook,
+
+		SLOFirstTokenMs: req.SLOFirstTokenMs,
+		SLOTarget:       req.SLOTarget,
+		SLOWindowHours:  req.SLOWindowHours,
+
+		ResponseRules: req.ResponseRules,
+
+		LogLevel: req.LogLevel,
+
+		LogSampleRate: req.LogSampleRate,
+
+		RoutingStrategy: req.RoutingStrategy,
+
+		StreamHeartbeatSeconds: req.StreamHeartbeatSeconds,
+
+		StickySession:    &req.StickySession,
+		StickySessionTTL: req.StickySessionTTL,
+
+		RetryBackoffMs:    req.RetryBackoffMs,
+		RetryBackoffMaxMs: req.RetryBackoffMaxMs,
+		RetryMaxElapsedMs: req.RetryMaxElapsedMs,
+		RetryBudget:       req.RetryBudget,
+
+		SummarizeThreshold: req.SummarizeThreshold,
+		SummarizeModel:     req.SummarizeModel,
+		SummarizeKeep:      req.SummarizeKeep,
+
+		MaxInputTokens:       req.MaxInputTokens,
+		PreflightCountTokens: &req.PreflightCountTokens,
 	}
 
 	if _, err := gorm.G[models.Model](models.DB).Where("id = ?", id).Updates(c.Request.Context(), updates); err != nil {
@@ -428,6 +697,13 @@ var template = []ProviderTemplate{
 			"version": "2023-06-01"
 		}`,
 	},
+	{
+		Type: "gemini",
+		Template: `{
+			"base_url": "https://generativelanguage.googleapis.com/v1beta",
+			"api_key": "YOUR_API_KEY"
+		}`,
+	},
 }
 
 func GetProviderTemplates(c *gin.Context) {
@@ -486,6 +762,7 @@ func GetModelProviderStatus(c *gin.Context) {
 		Where("provider_name = ?", provider.Name).
 		Where("provider_model = ?", providerModel).
 		Where("name = ?", modelName).
+		Where("status <> ?", "cancelled").
 		Limit(10).
 		Order("created_at DESC").
 		Find(c.Request.Context())
@@ -553,6 +830,18 @@ func CreateModelProvider(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if req.ContextLength != nil && *req.ContextLength < 0 {
+		common.BadRequest(c, "Invalid context length")
+		return
+	}
+	if err := service.ValidateRequestRewrites(req.RequestRewrites); err != nil {
+		common.BadRequest(c, "Invalid request rewrites: "+err.Error())
+		return
+	}
+	if err := service.ValidateHealthCheckConfig(req.HealthCheck); err != nil {
+		common.BadRequest(c, "Invalid health check config: "+err.Error())
+		return
+	}
 
 	customerHeaders := req.CustomerHeaders
 	if customerHeaders == nil {
@@ -572,11 +861,17 @@ func CreateModelProvider(c *gin.Context) {
 		ToolCall:         &req.ToolCall,
 		StructuredOutput: &req.StructuredOutput,
 		Image:            &req.Image,
+		PromptCache:      &req.PromptCache,
 		WithHeader:       &req.WithHeader,
 		CustomerHeaders:  customerHeaders,
 		Weight:           req.Weight,
 		Priority:         priority,
 	}
+	if req.ContextLength != nil {
+		modelProvider.ContextLength = *req.ContextLength
+	}
+	modelProvider.RequestRewrites = req.RequestRewrites
+	modelProvider.HealthCheck = req.HealthCheck
 
 	defaultStatus := true
 	modelProvider.Status = &defaultStatus
@@ -604,6 +899,18 @@ func UpdateModelProvider(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	if req.ContextLength != nil && *req.ContextLength < 0 {
+		common.BadRequest(c, "Invalid context length")
+		return
+	}
+	if err := service.ValidateRequestRewrites(req.RequestRewrites); err != nil {
+		common.BadRequest(c, "Invalid request rewrites: "+err.Error())
+		return
+	}
+	if err := service.ValidateHealthCheckConfig(req.HealthCheck); err != nil {
+		common.BadRequest(c, "Invalid health check config: "+err.Error())
+		return
+	}
 	slog.Info("UpdateModelProvider", "req", req)
 
 	customerHeaders := req.CustomerHeaders
@@ -630,6 +937,7 @@ func UpdateModelProvider(c *gin.Context) {
 		ToolCall:         &req.ToolCall,
 		StructuredOutput: &req.StructuredOutput,
 		Image:            &req.Image,
+		PromptCache:      &req.PromptCache,
 		WithHeader:       &req.WithHeader,
 		CustomerHeaders:  customerHeaders,
 		Weight:           req.Weight,
@@ -640,6 +948,26 @@ func UpdateModelProvider(c *gin.Context) {
 		common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
 		return
 	}
+	// 上下文窗口允许清零，单独更新
+	if req.ContextLength != nil {
+		if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Update(c.Request.Context(), "context_length", *req.ContextLength); err != nil {
+			common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
+			return
+		}
+	}
+	// 改写规则允许清空，单独更新
+	if req.RequestRewrites != nil {
+		if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Select("request_rewrites").Updates(c.Request.Context(), models.ModelWithProvider{RequestRewrites: req.RequestRewrites}); err != nil {
+			common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
+			return
+		}
+	}
+	if req.HealthCheck != nil {
+		if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Select("health_check").Updates(c.Request.Context(), models.ModelWithProvider{HealthCheck: req.HealthCheck}); err != nil {
+			common.InternalServerError(c, "Failed to update model-provider association: "+err.Error())
+			return
+		}
+	}
 
 	// Get updated model-provider association
 	updatedModelProvider, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(c.Request.Context())
@@ -686,6 +1014,11 @@ func UpdateModelProviderStatus(c *gin.Context) {
 		return
 	}
 
+	// 手动启用时结束进行中的排空，避免排空完成后又被禁用
+	if status {
+		service.CancelDrain(uint(id))
+	}
+
 	existing.Status = &status
 	common.Success(c, existing)
 }
@@ -773,6 +1106,10 @@ func GetRequestLogs(c *gin.Context) {
 	status := c.Query("status")
 	style := c.Query("style")
 	userAgent := c.Query("user_agent")
+	apiKeyID := c.Query("api_key_id")
+	responseHash := c.Query("response_hash")
+	headerFailover := c.Query("header_failover")
+	requestID := c.Query("request_id")
 
 	// 构建查询条件
 	query := models.DB.Model(&models.ChatLog{})
@@ -797,6 +1134,23 @@ func GetRequestLogs(c *gin.Context) {
 		query = query.Where("user_agent = ?", userAgent)
 	}
 
+	if apiKeyID != "" {
+		query = query.Where("api_key_id = ?", apiKeyID)
+	}
+
+	if responseHash != "" {
+		query = query.Where("response_hash = ?", responseHash)
+	}
+
+	if requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+
+	// 只看此前发生过响应头后故障转移的尝试
+	if headerFailover == "true" {
+		query = query.Where("header_failovers > 0")
+	}
+
 	// 获取总数
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
@@ -904,28 +1258,16 @@ type SettingsResponse struct {
 	LogRetentionCount               int  `json:"log_retention_count"`
 	CountHealthCheckAsSuccess       bool `json:"count_health_check_as_success"`
 	CountHealthCheckAsFailure       bool `json:"count_health_check_as_failure"`
+
+	PromptCacheRouting    string `json:"prompt_cache_routing"`     // 请求带有 cache_control 时的路由方式：off、strip、prefer
+	PromptCacheAutoInject bool   `json:"prompt_cache_auto_inject"` // 为发往支持提示缓存的 Anthropic 关联的请求自动添加缓存断点
+	EmptyStreamHandling   string `json:"empty_stream_handling"`    // 流式响应没有任何内容时的处理方式：off、error、failover
+
+	AdaptiveBalancing bool `json:"adaptive_balancing"` // 按近期首字时延与 TPS 自动缩放关联权重
 }
 
 // UpdateSettingsRequest 更新设置请求结构
-type UpdateSettingsRequest struct {
-	StrictCapabilityMatch           bool `json:"strict_capability_match"`
-	AutoWeightDecay                 bool `json:"auto_weight_decay"`
-	AutoWeightDecayDefault          int  `json:"auto_weight_decay_default"`
-	AutoWeightDecayStep             int  `json:"auto_weight_decay_step"`
-	AutoSuccessIncrease             bool `json:"auto_success_increase"`
-	AutoWeightIncreaseStep          int  `json:"auto_weight_increase_step"`
-	AutoWeightIncreaseMax           int  `json:"auto_weight_increase_max"`
-	AutoPriorityDecay               bool `json:"auto_priority_decay"`
-	AutoPriorityDecayDefault        int  `json:"auto_priority_decay_default"`
-	AutoPriorityDecayStep           int  `json:"auto_priority_decay_step"`
-	AutoPriorityDecayThreshold      int  `json:"auto_priority_decay_threshold"`
-	AutoPriorityDecayDisableEnabled bool `json:"auto_priority_decay_disable_enabled"`
-	AutoPriorityIncreaseStep        int  `json:"auto_priority_increase_step"`
-	AutoPriorityIncreaseMax         int  `json:"auto_priority_increase_max"`
-	LogRetentionCount               int  `json:"log_retention_count"`
-	CountHealthCheckAsSuccess       bool `json:"count_health_check_as_success"`
-	CountHealthCheckAsFailure       bool `json:"count_health_check_as_failure"`
-}
+type UpdateSettingsRequest = service.GeneralSettings
 
 // GetSettings 获取所有设置
 func GetSettings(c *gin.Context) {
@@ -954,6 +1296,10 @@ func GetSettings(c *gin.Context) {
 		AutoPriorityIncreaseMax:         100,
 		CountHealthCheckAsSuccess:       true,
 		CountHealthCheckAsFailure:       false,
+		PromptCacheRouting:              service.PromptCacheRoutingOff,
+		PromptCacheAutoInject:           false,
+		EmptyStreamHandling:             service.EmptyStreamFailover,
+		AdaptiveBalancing:               false,
 	}
 
 	for _, setting := range settings {
@@ -1012,13 +1358,21 @@ func GetSettings(c *gin.Context) {
 			response.CountHealthCheckAsSuccess = setting.Value == "true"
 		case models.SettingKeyHealthCheckCountAsFailure:
 			response.CountHealthCheckAsFailure = setting.Value == "true"
+		case models.SettingKeyPromptCacheRouting:
+			response.PromptCacheRouting = setting.Value
+		case models.SettingKeyPromptCacheAutoInject:
+			response.PromptCacheAutoInject = setting.Value == "true"
+		case models.SettingKeyEmptyStreamHandling:
+			response.EmptyStreamHandling = setting.Value
+		case models.SettingKeyAdaptiveBalancing:
+			response.AdaptiveBalancing = setting.Value == "true"
 		}
 	}
 
 	common.Success(c, response)
 }
 
-// UpdateSettings 更新设置
+// UpdateSettings 更新设置，校验不通过时不写入任何设置
 func UpdateSettings(c *gin.Context) {
 	var req UpdateSettingsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1026,176 +1380,22 @@ func UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// 更新严格能力匹配设置
-	strictValue := "false"
-	if req.StrictCapabilityMatch {
-		strictValue = "true"
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyStrictCapabilityMatch).
-		Update(ctx, "value", strictValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动权重衰减开关
-	autoWeightDecayValue := "false"
-	if req.AutoWeightDecay {
-		autoWeightDecayValue = "true"
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecay).
-		Update(ctx, "value", autoWeightDecayValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动权重衰减默认值
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecayDefault).
-		Update(ctx, "value", strconv.Itoa(req.AutoWeightDecayDefault)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动权重衰减步长
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecayStep).
-		Update(ctx, "value", strconv.Itoa(req.AutoWeightDecayStep)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+	service.NormalizeSettings(&req)
+	if validation := service.ValidateSettings(req); !validation.Valid {
+		common.BadRequest(c, "Invalid settings: "+validation.Errors[0].Message)
 		return
 	}
 
-	if req.AutoSuccessIncrease {
-		if req.AutoWeightIncreaseStep < 1 {
-			req.AutoWeightIncreaseStep = 1
-		}
-		if req.AutoWeightIncreaseMax < 1 {
-			req.AutoWeightIncreaseMax = 100
+	values := req.Values()
+	// 所有设置在同一事务中写入，避免部分生效
+	if err := models.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		for key, value := range values {
+			if _, err := gorm.G[models.Setting](tx).Where(models.ByKey(key)).Update(c.Request.Context(), "value", value); err != nil {
+				return err
+			}
 		}
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoSuccessIncrease).
-		Update(ctx, "value", strconv.FormatBool(req.AutoSuccessIncrease)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightIncreaseStep).
-		Update(ctx, "value", strconv.Itoa(req.AutoWeightIncreaseStep)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightIncreaseMax).
-		Update(ctx, "value", strconv.Itoa(req.AutoWeightIncreaseMax)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动优先级衰减开关
-	autoPriorityDecayValue := "false"
-	if req.AutoPriorityDecay {
-		autoPriorityDecayValue = "true"
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecay).
-		Update(ctx, "value", autoPriorityDecayValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动优先级衰减默认值
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayDefault).
-		Update(ctx, "value", strconv.Itoa(req.AutoPriorityDecayDefault)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动优先级衰减步长
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayStep).
-		Update(ctx, "value", strconv.Itoa(req.AutoPriorityDecayStep)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动优先级衰减阈值
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayThreshold).
-		Update(ctx, "value", strconv.Itoa(req.AutoPriorityDecayThreshold)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新自动优先级衰减禁用开关
-	autoPriorityDecayDisableEnabledValue := "false"
-	if req.AutoPriorityDecayDisableEnabled {
-		autoPriorityDecayDisableEnabledValue = "true"
-	}
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayDisableEnabled).
-		Update(ctx, "value", autoPriorityDecayDisableEnabledValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	if req.AutoPriorityIncreaseStep < 1 {
-		req.AutoPriorityIncreaseStep = 1
-	}
-	if req.AutoPriorityIncreaseMax < 0 {
-		req.AutoPriorityIncreaseMax = 100
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityIncreaseStep).
-		Update(ctx, "value", strconv.Itoa(req.AutoPriorityIncreaseStep)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityIncreaseMax).
-		Update(ctx, "value", strconv.Itoa(req.AutoPriorityIncreaseMax)); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	countHealthCheckValue := "false"
-	if req.CountHealthCheckAsSuccess {
-		countHealthCheckValue = "true"
-	}
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsSuccess).
-		Update(ctx, "value", countHealthCheckValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	countHealthCheckFailureValue := "false"
-	if req.CountHealthCheckAsFailure {
-		countHealthCheckFailureValue = "true"
-	}
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsFailure).
-		Update(ctx, "value", countHealthCheckFailureValue); err != nil {
-		common.InternalServerError(c, "Failed to update settings: "+err.Error())
-		return
-	}
-
-	// 更新日志保留条数设置
-	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyLogRetentionCount).
-		Update(ctx, "value", strconv.Itoa(req.LogRetentionCount)); err != nil {
+		return nil
+	}); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
 	}
@@ -1277,7 +1477,7 @@ func ResetModelWeights(c *gin.Context) {
 	// 获取默认权重值
 	defaultWeight := 100
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecayDefault).
+		Where(models.ByKey(models.SettingKeyAutoWeightDecayDefault)).
 		First(ctx)
 	if err == nil {
 		if val, err := strconv.Atoi(setting.Value); err == nil {
@@ -1394,7 +1594,7 @@ func EnableAllAssociations(c *gin.Context) {
 // GetStrictCapabilityMatch 获取严格能力匹配设置
 func GetStrictCapabilityMatch(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyStrictCapabilityMatch).
+		Where(models.ByKey(models.SettingKeyStrictCapabilityMatch)).
 		First(ctx)
 	if err != nil {
 		return true // 默认开启
@@ -1405,7 +1605,7 @@ func GetStrictCapabilityMatch(ctx context.Context) bool {
 // getAutoPriorityDecayDefault 获取自动优先级衰减默认值
 func getAutoPriorityDecayDefault(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayDefault).
+		Where(models.ByKey(models.SettingKeyAutoPriorityDecayDefault)).
 		First(ctx)
 	if err != nil {
 		return 100 // 默认优先级100
@@ -1503,6 +1703,9 @@ type HealthCheckSettingsResponse struct {
 	LogRetentionCount       int  `json:"log_retention_count"`
 	CountHealthCheckSuccess bool `json:"count_health_check_as_success"`
 	CountHealthCheckFailure bool `json:"count_health_check_as_failure"`
+
+	service.HealthCheckPacing
+	service.HealthCheckFailurePolicy
 }
 
 // UpdateHealthCheckSettingsRequest 更新健康检测设置请求结构
@@ -1515,6 +1718,19 @@ type UpdateHealthCheckSettingsRequest struct {
 	LogRetentionCount       int  `json:"log_retention_count"`
 	CountHealthCheckSuccess bool `json:"count_health_check_as_success"`
 	CountHealthCheckFailure bool `json:"count_health_check_as_failure"`
+
+	// 限速与批量并发设置，为空表示不修改
+	MaxQPS              *int `json:"max_qps"`
+	ProviderSpacing     *int `json:"provider_spacing_ms"`
+	Jitter              *int `json:"jitter_ms"`
+	Concurrency         *int `json:"concurrency"`
+	ProviderConcurrency *int `json:"provider_concurrency"`
+	BatchTimeout        *int `json:"batch_timeout_seconds"`
+	ScheduleJitter      *int `json:"schedule_jitter_percent"`
+
+	// 连续失败达到阈值时的处理方式，为空表示不修改
+	FailureAction    *string `json:"failure_action"`
+	QuarantineWeight *int    `json:"quarantine_weight_percent"`
 }
 
 // GetHealthCheckSettings 获取健康检测设置
@@ -1523,14 +1739,16 @@ func GetHealthCheckSettings(c *gin.Context) {
 	enabled, interval, failureThreshold, failureDisableEnabled, autoEnable, logRetentionCount, countAsSuccess, countAsFailure := service.GetHealthCheckSettings(ctx)
 
 	response := HealthCheckSettingsResponse{
-		Enabled:                 enabled,
-		Interval:                interval,
-		FailureThreshold:        failureThreshold,
-		FailureDisableEnabled:   failureDisableEnabled,
-		AutoEnable:              autoEnable,
-		LogRetentionCount:       logRetentionCount,
-		CountHealthCheckSuccess: countAsSuccess,
-		CountHealthCheckFailure: countAsFailure,
+		Enabled:                  enabled,
+		Interval:                 interval,
+		FailureThreshold:         failureThreshold,
+		FailureDisableEnabled:    failureDisableEnabled,
+		AutoEnable:               autoEnable,
+		LogRetentionCount:        logRetentionCount,
+		CountHealthCheckSuccess:  countAsSuccess,
+		CountHealthCheckFailure:  countAsFailure,
+		HealthCheckPacing:        service.GetHealthCheckPacing(ctx),
+		HealthCheckFailurePolicy: service.GetHealthCheckFailurePolicy(ctx),
 	}
 
 	common.Success(c, response)
@@ -1543,8 +1761,41 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		common.BadRequest(c, "Invalid request body: "+err.Error())
 		return
 	}
+	pacing := []struct {
+		key   string
+		value *int
+	}{
+		{models.SettingKeyHealthCheckMaxQPS, req.MaxQPS},
+		{models.SettingKeyHealthCheckProviderSpacing, req.ProviderSpacing},
+		{models.SettingKeyHealthCheckJitter, req.Jitter},
+		{models.SettingKeyHealthCheckConcurrency, req.Concurrency},
+		{models.SettingKeyHealthCheckProviderConcurrency, req.ProviderConcurrency},
+		{models.SettingKeyHealthCheckBatchTimeout, req.BatchTimeout},
+		{models.SettingKeyHealthCheckScheduleJitter, req.ScheduleJitter},
+	}
+	for _, item := range pacing {
+		if item.value != nil && *item.value < 0 {
+			common.BadRequest(c, "Invalid health check pacing: "+item.key+" must not be negative")
+			return
+		}
+	}
+	if req.ScheduleJitter != nil && *req.ScheduleJitter > 100 {
+		common.BadRequest(c, "Invalid health check pacing: "+models.SettingKeyHealthCheckScheduleJitter+" must not exceed 100")
+		return
+	}
 
 	ctx := c.Request.Context()
+	policy := service.GetHealthCheckFailurePolicy(ctx)
+	if req.FailureAction != nil {
+		policy.FailureAction = *req.FailureAction
+	}
+	if req.QuarantineWeight != nil {
+		policy.QuarantineWeight = *req.QuarantineWeight
+	}
+	if err := service.ValidateHealthCheckFailurePolicy(policy); err != nil {
+		common.BadRequest(c, "Invalid health check failure policy: "+err.Error())
+		return
+	}
 
 	// 更新启用状态
 	enabledValue := "false"
@@ -1552,7 +1803,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		enabledValue = "true"
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckEnabled).
+		Where(models.ByKey(models.SettingKeyHealthCheckEnabled)).
 		Update(ctx, "value", enabledValue); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1563,7 +1814,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		req.Interval = 60
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckInterval).
+		Where(models.ByKey(models.SettingKeyHealthCheckInterval)).
 		Update(ctx, "value", strconv.Itoa(req.Interval)); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1574,7 +1825,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		req.FailureThreshold = 3
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckFailureThreshold).
+		Where(models.ByKey(models.SettingKeyHealthCheckFailureThreshold)).
 		Update(ctx, "value", strconv.Itoa(req.FailureThreshold)); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1586,7 +1837,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		failureDisableEnabledValue = "true"
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckFailureDisableEnabled).
+		Where(models.ByKey(models.SettingKeyHealthCheckFailureDisableEnabled)).
 		Update(ctx, "value", failureDisableEnabledValue); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1598,7 +1849,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		autoEnableValue = "true"
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckAutoEnable).
+		Where(models.ByKey(models.SettingKeyHealthCheckAutoEnable)).
 		Update(ctx, "value", autoEnableValue); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1609,7 +1860,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		req.LogRetentionCount = 0
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckLogRetentionCount).
+		Where(models.ByKey(models.SettingKeyHealthCheckLogRetentionCount)).
 		Update(ctx, "value", strconv.Itoa(req.LogRetentionCount)); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1620,7 +1871,7 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		countHealthCheckSuccess = "true"
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsSuccess).
+		Where(models.ByKey(models.SettingKeyHealthCheckCountAsSuccess)).
 		Update(ctx, "value", countHealthCheckSuccess); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
@@ -1631,12 +1882,38 @@ func UpdateHealthCheckSettings(c *gin.Context) {
 		countHealthCheckFailure = "true"
 	}
 	if _, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsFailure).
+		Where(models.ByKey(models.SettingKeyHealthCheckCountAsFailure)).
 		Update(ctx, "value", countHealthCheckFailure); err != nil {
 		common.InternalServerError(c, "Failed to update settings: "+err.Error())
 		return
 	}
 
+	// 更新限速设置
+	for _, item := range pacing {
+		if item.value == nil {
+			continue
+		}
+		if _, err := gorm.G[models.Setting](models.DB).
+			Where(models.ByKey(item.key)).
+			Update(ctx, "value", strconv.Itoa(*item.value)); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+
+	// 更新连续失败的处理方式
+	for key, value := range map[string]string{
+		models.SettingKeyHealthCheckFailureAction:    policy.FailureAction,
+		models.SettingKeyHealthCheckQuarantineWeight: strconv.Itoa(policy.QuarantineWeight),
+	} {
+		if _, err := gorm.G[models.Setting](models.DB).
+			Where(models.ByKey(key)).
+			Update(ctx, "value", value); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+
 	// 重启健康检测服务
 	go service.GetHealthChecker().Restart(context.Background())
 
@@ -1760,27 +2037,24 @@ func RunHealthCheck(c *gin.Context) {
 	common.Success(c, log)
 }
 
-// RunHealthCheckAll 手动运行所有模型提供商的健康检测
+// RunHealthCheckAll 手动运行所有模型提供商的健康检测，在后台按并发设置批量检测并返回本轮进度
 func RunHealthCheckAll(c *gin.Context) {
-	go func() {
-		checker := service.GetHealthChecker()
-		ctx := context.Background()
-
-		// 获取所有模型提供商关联
-		modelProviders, err := gorm.G[models.ModelWithProvider](models.DB).Find(ctx)
-		if err != nil {
-			slog.Error("failed to get model providers for health check", "error", err)
-			return
-		}
-
-		for _, mp := range modelProviders {
-			checker.CheckSingle(ctx, mp.ID)
-		}
-	}()
+	batch, err := service.GetHealthChecker().StartBatch(c.Request.Context(), service.HealthCheckTriggerManual)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	common.Success(c, batch)
+}
 
-	common.Success(c, map[string]string{
-		"message": "Health check started for all model providers",
-	})
+// GetHealthCheckBatch 获取当前或最近一轮批量健康检测的进度
+func GetHealthCheckBatch(c *gin.Context) {
+	batch, ok := service.GetHealthChecker().BatchStatus()
+	if !ok {
+		common.NotFound(c, "No health check batch has been started")
+		return
+	}
+	common.Success(c, batch)
 }
 
 // ClearAllLogs 清空所有日志
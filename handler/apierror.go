@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/consts"
+	"github.com/gin-gonic/gin"
+)
+
+// writeAPIError 按客户端请求的接口格式返回错误，Anthropic 与 OpenAI SDK 可按原生错误类型处理；code 只用于 OpenAI 格式
+func writeAPIError(c *gin.Context, style string, status int, errType, code, message string) {
+	if style == consts.StyleAnthropic {
+		c.JSON(status, gin.H{"type": "error", "error": gin.H{"type": errType, "message": message}})
+		return
+	}
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": errType, "param": nil, "code": code}})
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APIKeyRequest API Key 创建/更新请求结构
+type APIKeyRequest struct {
+	Label         string     `json:"label" binding:"required"`
+	AllowedModels []string   `json:"allowed_models"` // 为空表示允许全部模型，支持通配符
+	ExpiresAt     *time.Time `json:"expires_at"`     // 为空表示永不过期
+	LogLevel      string     `json:"log_level"`      // 日志详细级别，为空表示沿用模型配置
+	PriorityClass string     `json:"priority_class"` // interactive、batch，为空表示由 X-LLMIO-Priority 请求头决定
+}
+
+// CreateAPIKeyResponse 创建 API Key 的响应，明文 key 只在创建时返回一次
+type CreateAPIKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// GetAPIKeys 获取 API Key 列表
+func GetAPIKeys(c *gin.Context) {
+	keys, err := gorm.G[models.APIKey](models.DB).Order("id DESC").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query api keys: "+err.Error())
+		return
+	}
+	common.Success(c, keys)
+}
+
+// CreateAPIKey 创建 API Key
+func CreateAPIKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if !service.ValidLogLevel(req.LogLevel) {
+		common.BadRequest(c, "Invalid log level")
+		return
+	}
+	if !service.ValidPriorityClass(req.PriorityClass) {
+		common.BadRequest(c, "Invalid priority class")
+		return
+	}
+
+	plain, hash, prefix, err := service.GenerateAPIKey()
+	if err != nil {
+		common.InternalServerError(c, "Failed to create api key: "+err.Error())
+		return
+	}
+	key := models.APIKey{
+		Label:         req.Label,
+		KeyHash:       hash,
+		KeyPrefix:     prefix,
+		AllowedModels: req.AllowedModels,
+		ExpiresAt:     req.ExpiresAt,
+		LogLevel:      req.LogLevel,
+		PriorityClass: req.PriorityClass,
+	}
+	if err := gorm.G[models.APIKey](models.DB).Create(c.Request.Context(), &key); err != nil {
+		common.InternalServerError(c, "Failed to create api key: "+err.Error())
+		return
+	}
+	common.Success(c, CreateAPIKeyResponse{APIKey: key, Key: plain})
+}
+
+// UpdateAPIKey 更新 API Key 的标签、模型白名单、过期时间、日志级别与优先级，密钥本身不可修改
+func UpdateAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if !service.ValidLogLevel(req.LogLevel) {
+		common.BadRequest(c, "Invalid log level")
+		return
+	}
+	if !service.ValidPriorityClass(req.PriorityClass) {
+		common.BadRequest(c, "Invalid priority class")
+		return
+	}
+
+	ctx := c.Request.Context()
+	key, err := gorm.G[models.APIKey](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.NotFound(c, "API key not found")
+		return
+	}
+	key.Label = req.Label
+	key.AllowedModels = req.AllowedModels
+	key.ExpiresAt = req.ExpiresAt
+	key.LogLevel = req.LogLevel
+	key.PriorityClass = req.PriorityClass
+	// Select 全部字段，清空白名单、过期时间、日志级别或优先级时同样生效
+	if err := models.DB.WithContext(ctx).Select("label", "allowed_models", "expires_at", "log_level", "priority_class", "updated_at").Save(&key).Error; err != nil {
+		common.InternalServerError(c, "Failed to update api key: "+err.Error())
+		return
+	}
+	common.Success(c, key)
+}
+
+// DeleteAPIKey 删除 API Key，历史日志中的 api_key_id 保留
+func DeleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	result, err := gorm.G[models.APIKey](models.DB).Where("id = ?", id).Delete(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to delete api key: "+err.Error())
+		return
+	}
+	if result == 0 {
+		common.NotFound(c, "API key not found")
+		return
+	}
+	common.Success(c, nil)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ApplyDesiredState 按期望状态文档声明式地同步供应商、模型与关联，?dry_run=true 时只返回变更计划
+func ApplyDesiredState(c *gin.Context) {
+	var req service.DesiredState
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	plan, err := service.ApplyDesiredState(c.Request.Context(), req, c.Query("dry_run") == "true")
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidDesiredState) {
+			common.BadRequest(c, err.Error())
+			return
+		}
+		common.InternalServerError(c, "Failed to apply desired state: "+err.Error())
+		return
+	}
+	common.Success(c, plan)
+}
+
+// ExportConfig 导出供应商、模型、关联与设置，?mask_secrets=true 或使用只读令牌时供应商密钥以掩码导出
+func ExportConfig(c *gin.Context) {
+	bundle, err := service.ExportConfig(c.Request.Context(), c.Query("mask_secrets") == "true" || middleware.ReadOnly(c))
+	if err != nil {
+		common.InternalServerError(c, "Failed to export config: "+err.Error())
+		return
+	}
+	common.Success(c, bundle)
+}
+
+// ImportConfig 按导出包同步配置，?dry_run=true 时只返回变更计划
+func ImportConfig(c *gin.Context) {
+	var req service.ConfigBundle
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	plan, err := service.ImportConfig(c.Request.Context(), req, c.Query("dry_run") == "true")
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidDesiredState) {
+			common.BadRequest(c, err.Error())
+			return
+		}
+		common.InternalServerError(c, "Failed to import config: "+err.Error())
+		return
+	}
+	common.Success(c, plan)
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+func TestApplyDesiredState(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	kept := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, "http://127.0.0.1")
+	stale := testutil.SeedProvider(t, "stale", consts.StyleOpenAI, "http://127.0.0.1")
+	chat := testutil.SeedModel(t, "chat")
+	testutil.SeedAssociation(t, chat, kept, "gpt-4o", 7, 100)
+	testutil.SeedAssociation(t, chat, stale, "gpt-4o", 100, 1)
+
+	router := newTestRouter()
+	router.POST("/api/apply", ApplyDesiredState)
+	apply := func(query, body string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/apply"+query, strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	// 供应商配置使用接口返回的脱敏值，不视为变更
+	state := `{
+		"providers":[{"name":"openai","type":"openai","config":"{\"api_key\":\"test****-key\",\"base_url\":\"http://127.0.0.1\"}"}],
+		"models":[{"name":"chat","max_retry":5,"time_out":10},{"name":"embed","max_retry":3,"time_out":10}],
+		"associations":[
+			{"model":"chat","provider":"openai","provider_model":"gpt-4o","tool_call":true,"structured_output":true,"image":true,"with_header":true,"weight":3},
+			{"model":"embed","provider":"openai","provider_model":"text-embedding-3-small"}]}`
+	plan := apply("?dry_run=true", state)
+	want := []string{
+		"delete provider stale",
+		"update model chat max_retry",
+		"create model embed",
+		"update association chat/openai/gpt-4o with_header,weight",
+		"create association embed/openai/text-embedding-3-small",
+		"delete association chat/stale/gpt-4o",
+	}
+	var got []string
+	for _, change := range gjson.Get(plan, "data.changes").Array() {
+		entry := change.Get("action").String() + " " + change.Get("kind").String() + " " + change.Get("name").String()
+		if fields := change.Get("fields").Array(); len(fields) > 0 {
+			names := make([]string, len(fields))
+			for i, f := range fields {
+				names[i] = f.String()
+			}
+			entry += " " + strings.Join(names, ",")
+		}
+		got = append(got, entry)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") || gjson.Get(plan, "data.applied").Bool() {
+		t.Fatalf("dry run plan = %s", plan)
+	}
+	if count, _ := gorm.G[models.Provider](models.DB).Count(ctx, "id"); count != 2 {
+		t.Fatalf("dry run wrote changes, providers = %d", count)
+	}
+
+	if result := apply("", state); !gjson.Get(result, "data.applied").Bool() || len(gjson.Get(result, "data.changes").Array()) != len(want) {
+		t.Fatalf("apply = %s", result)
+	}
+	provider, err := gorm.G[models.Provider](models.DB).Where("name = ?", "openai").First(ctx)
+	if err != nil || gjson.Get(provider.Config, "api_key").String() != testutil.TestAPIKey {
+		t.Fatalf("provider = %+v, %v", provider, err)
+	}
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Order("id").Find(ctx)
+	if err != nil || len(associations) != 2 {
+		t.Fatalf("associations = %+v, %v", associations, err)
+	}
+	if a := associations[0]; !*a.WithHeader || a.Weight != 3 || a.Priority != 7 {
+		t.Fatalf("updated association = %+v", a)
+	}
+	if a := associations[1]; a.Weight != 1 || a.Priority != 100 || !*a.Status {
+		t.Fatalf("created association = %+v", a)
+	}
+
+	// 再次应用相同文档没有变更
+	if result := apply("", state); len(gjson.Get(result, "data.changes").Array()) != 0 {
+		t.Fatalf("second apply = %s", result)
+	}
+
+	if result := apply("", `{"associations":[{"model":"missing","provider":"openai","provider_model":"x"}]}`); gjson.Get(result, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("invalid state = %s", result)
+	}
+}
+
+func TestConfigExportImport(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, "http://127.0.0.1")
+	chat := testutil.SeedModel(t, "chat", func(m *models.Model) { m.ToolAuditWebhook = "https://hooks.example.com/audit/XXXX" })
+	testutil.SeedAssociation(t, chat, provider, "gpt-4o", 7, 3)
+	shadow := testutil.SeedAssociation(t, chat, provider, "gpt-4o-mini", 1, 0)
+	// 限流与影子流量随模型导出，影子关联以名称引用
+	if err := models.DB.Model(&chat).Updates(map[string]any{"rpm": 60, "tpm": 1000, "shadow_model_provider_id": shadow.ID, "shadow_percent": 10}).Error; err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]string{
+		models.SettingKeySMTPPassword:    "smtp-password-1234",
+		models.SettingKeySLOAlertWebhook: "https://hooks.example.com/services/T000/B000/XXXX",
+	}
+	for key, value := range secrets {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := newTestRouter()
+	router.GET("/api/config/export", ExportConfig)
+	router.POST("/api/config/import", ImportConfig)
+	do := func(method, path, body string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	masked := do(http.MethodGet, "/api/config/export?mask_secrets=true", "")
+	if key := gjson.Get(gjson.Get(masked, "data.providers.0.config").String(), "api_key").String(); key == testutil.TestAPIKey || key == "" {
+		t.Fatalf("Expected masked api key, got %q", key)
+	}
+	for key, value := range secrets {
+		if strings.Contains(masked, value) || gjson.Get(masked, "data.settings."+key).String() == "" {
+			t.Fatalf("Expected masked %s, got %s", key, gjson.Get(masked, "data.settings").Raw)
+		}
+	}
+	exported := do(http.MethodGet, "/api/config/export", "")
+	bundle := gjson.Get(exported, "data")
+	if bundle.Get("version").Int() != 1 || bundle.Get("associations.0.provider_model").String() != "gpt-4o" || bundle.Get("associations.0.priority").Int() != 7 {
+		t.Fatalf("export = %s", exported)
+	}
+	if model := bundle.Get("models.0"); model.Get("rpm").Int() != 60 || model.Get("tpm").Int() != 1000 ||
+		model.Get("shadow_provider").String() != "openai" || model.Get("shadow_provider_model").String() != "gpt-4o-mini" || model.Get("shadow_percent").Int() != 10 {
+		t.Fatalf("exported model = %s", model.Raw)
+	}
+	if bundle.Get("settings."+models.SettingKeyLogRetentionCount).String() == "" {
+		t.Fatalf("Expected settings in export, got %s", bundle.Get("settings").Raw)
+	}
+
+	// 重复导入导出的包不产生变更，脱敏的导出包同样保留原密钥
+	for _, data := range []string{bundle.Raw, gjson.Get(masked, "data").Raw} {
+		if plan := do(http.MethodPost, "/api/config/import", data); len(gjson.Get(plan, "data.changes").Array()) != 0 || !gjson.Get(plan, "data.applied").Bool() {
+			t.Fatalf("re-import plan = %s", plan)
+		}
+	}
+
+	// 在空实例上恢复
+	testutil.SetupDB(t)
+	changed, err := sjson.Set(bundle.Raw, "settings."+models.SettingKeyLogRetentionCount, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := do(http.MethodPost, "/api/config/import?dry_run=true", changed); len(gjson.Get(plan, "data.changes").Array()) != 5+len(secrets) {
+		t.Fatalf("dry run plan = %s", plan)
+	}
+	if count, _ := gorm.G[models.Provider](models.DB).Count(ctx, "id"); count != 0 {
+		t.Fatalf("dry run wrote changes, providers = %d", count)
+	}
+	if plan := do(http.MethodPost, "/api/config/import", changed); !gjson.Get(plan, "data.applied").Bool() {
+		t.Fatalf("import = %s", plan)
+	}
+	restored, err := gorm.G[models.Provider](models.DB).Where("name = ?", "openai").First(ctx)
+	if err != nil || gjson.Get(restored.Config, "api_key").String() != testutil.TestAPIKey {
+		t.Fatalf("provider = %+v, %v", restored, err)
+	}
+	model, err := gorm.G[models.Model](models.DB).Where("name = ?", "chat").First(ctx)
+	if err != nil || model.RPM != 60 || model.TPM != 1000 || model.ShadowPercent != 10 {
+		t.Fatalf("model = %+v, %v", model, err)
+	}
+	if mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", model.ShadowModelProviderID).First(ctx); err != nil || mp.ProviderModel != "gpt-4o-mini" {
+		t.Fatalf("shadow association = %+v, %v", mp, err)
+	}
+	setting, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyLogRetentionCount).First(ctx)
+	if err != nil || setting.Value != "42" {
+		t.Fatalf("setting = %+v, %v", setting, err)
+	}
+
+	unknown, _ := sjson.Set(bundle.Raw, "settings.unknown_setting", "1")
+	if result := do(http.MethodPost, "/api/config/import", unknown); gjson.Get(result, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("Expected unknown setting to be rejected, got %s", result)
+	}
+	// 导入的设置按各设置接口的规则校验
+	for key, value := range map[string]string{
+		models.SettingKeyRequestMaxConcurrent:       "-1",
+		models.SettingKeyAutoPriorityDecay:          "yes",
+		models.SettingKeyHealthCheckScheduleJitter:  "150",
+		models.SettingKeyHealthCheckFailureAction:   "delete",
+		models.SettingKeySMTPHost:                   "smtp.example.com",
+		models.SettingKeyPromptCacheRouting:         "always",
+		models.SettingKeyAutoPriorityDecayThreshold: "100",
+	} {
+		invalid, _ := sjson.Set(bundle.Raw, "settings."+key, value)
+		if key == models.SettingKeyAutoPriorityDecayThreshold {
+			invalid, _ = sjson.Set(invalid, "settings."+models.SettingKeyAutoPriorityDecay, "true")
+		}
+		if result := do(http.MethodPost, "/api/config/import", invalid); gjson.Get(result, "code").Int() != http.StatusBadRequest {
+			t.Fatalf("Expected %s=%s to be rejected, got %s", key, value, result)
+		}
+	}
+	undeclared, _ := sjson.Set(bundle.Raw, "models.0.shadow_provider_model", "missing")
+	if result := do(http.MethodPost, "/api/config/import", undeclared); gjson.Get(result, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("Expected undeclared shadow association to be rejected, got %s", result)
+	}
+	unsupported, _ := sjson.Set(bundle.Raw, "version", 2)
+	if result := do(http.MethodPost, "/api/config/import", unsupported); gjson.Get(result, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("Expected unsupported version to be rejected, got %s", result)
+	}
+}
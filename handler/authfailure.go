@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuthFailureSettingsRequest 密钥失效通知设置
+type AuthFailureSettingsRequest struct {
+	Webhook string `json:"webhook"` // 关联因 401/403 被隔离时 POST 通知的地址，为空表示不通知
+}
+
+// GetAuthFailures 获取密钥失效通知设置与因 401/403 被隔离的关联
+func GetAuthFailures(c *gin.Context) {
+	ctx := c.Request.Context()
+	entries, err := service.ListAuthFailures(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query auth failures: "+err.Error())
+		return
+	}
+	webhook := service.GetKeyInvalidWebhook(ctx)
+	if middleware.ReadOnly(c) {
+		webhook = models.RedactSecret(webhook)
+	}
+	common.Success(c, gin.H{
+		"webhook": webhook,
+		"entries": entries,
+	})
+}
+
+// UpdateAuthFailureSettings 更新密钥失效通知 webhook
+func UpdateAuthFailureSettings(c *gin.Context) {
+	var req AuthFailureSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyKeyInvalidWebhook)).Update(c.Request.Context(), "value", req.Webhook); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	common.Success(c, req)
+}
+
+// ClearAuthFailure 手动解除关联的密钥失效隔离
+func ClearAuthFailure(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	cleared, err := service.ClearAuthFailure(c.Request.Context(), uint(id))
+	if err != nil {
+		common.InternalServerError(c, "Failed to clear auth failure: "+err.Error())
+		return
+	}
+	if !cleared {
+		common.NotFound(c, "Auth failure not found")
+		return
+	}
+	common.Success(c, nil)
+}
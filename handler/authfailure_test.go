@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestAuthFailureQuarantine(t *testing.T) {
+	testutil.SetupDB(t)
+	webhook := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, `{}`))
+	dead := testutil.NewUpstream(t, testutil.JSON(http.StatusUnauthorized, `{"error":{"message":"invalid api key"}}`))
+	live := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-4o", "ok", 10, 5)))
+
+	ctx := context.Background()
+	if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyKeyInvalidWebhook).Update(ctx, "value", webhook.URL); err != nil {
+		t.Fatal(err)
+	}
+	chat := testutil.SeedModel(t, "chat")
+	deadProvider := testutil.SeedProvider(t, "dead", consts.StyleOpenAI, dead.URL)
+	association := testutil.SeedAssociation(t, chat, deadProvider, "gpt-4o", 200, 1)
+	testutil.SeedAssociation(t, chat, testutil.SeedProvider(t, "live", consts.StyleOpenAI, live.URL), "gpt-4o", 100, 1)
+
+	router := newTestRouter()
+	router.GET("/api/auth-failures", GetAuthFailures)
+	router.DELETE("/api/auth-failures/:id", ClearAuthFailure)
+	send := func() {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"chat","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("chat status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+
+	send()
+	send()
+	if got := len(dead.Requests()); got != 1 {
+		t.Fatalf("dead provider requests = %d, want 1", got)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth-failures", nil))
+	entry := gjson.Get(w.Body.String(), "data.entries.0")
+	if entry.Get("model_with_provider_id").Uint() != uint64(association.ID) || !entry.Get("active").Bool() || entry.Get("provider").String() != "dead" {
+		t.Fatalf("auth failures = %s", w.Body.String())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(webhook.Requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := webhook.Requests()
+	if len(events) != 1 || gjson.GetBytes(events[0].Body, "event").String() != "key_invalid" || gjson.GetBytes(events[0].Body, "status_code").Int() != 401 {
+		t.Fatalf("webhook events = %+v", events)
+	}
+
+	// 更换密钥后关联重新参与路由
+	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", deadProvider.ID).Update(ctx, "config", `{"base_url":"`+dead.URL+`","api_key":"rotated"}`); err != nil {
+		t.Fatal(err)
+	}
+	send()
+	if got := len(dead.Requests()); got != 2 {
+		t.Fatalf("dead provider requests after config change = %d, want 2", got)
+	}
+
+	path := "/api/auth-failures/" + strconv.Itoa(int(association.ID))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, path, nil))
+	if gjson.Get(w.Body.String(), "code").Int() != http.StatusOK {
+		t.Fatalf("clear = %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, path, nil))
+	if gjson.Get(w.Body.String(), "code").Int() != http.StatusNotFound {
+		t.Fatalf("clear twice = %s", w.Body.String())
+	}
+}
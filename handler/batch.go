@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const (
+	batchMaxRequests  = 100000 // 与 Anthropic 一致的单个批处理请求数上限
+	batchDefaultLimit = 20
+	batchMaxLimit     = 1000
+	batchPathSuffix   = "/v1/messages/batches"
+)
+
+// batchForwardHeaders 创建批处理时保存、执行各请求时透传的请求头
+var batchForwardHeaders = []string{"anthropic-version", "anthropic-beta", "User-Agent"}
+
+// MessageBatchCounts 批处理各状态的请求数
+type MessageBatchCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// MessageBatchResponse Anthropic Message Batch 对象
+type MessageBatchResponse struct {
+	ID                string             `json:"id"`
+	Type              string             `json:"type"`
+	ProcessingStatus  string             `json:"processing_status"`
+	RequestCounts     MessageBatchCounts `json:"request_counts"`
+	CreatedAt         time.Time          `json:"created_at"`
+	ExpiresAt         time.Time          `json:"expires_at"`
+	EndedAt           *time.Time         `json:"ended_at"`
+	CancelInitiatedAt *time.Time         `json:"cancel_initiated_at"`
+	ArchivedAt        *time.Time         `json:"archived_at"`
+	ResultsURL        *string            `json:"results_url"`
+}
+
+// batchResponse 转换为 Anthropic 格式，结束后提供结果地址
+func batchResponse(c *gin.Context, batch models.MessageBatch) MessageBatchResponse {
+	response := MessageBatchResponse{
+		ID:               batch.BatchID,
+		Type:             "message_batch",
+		ProcessingStatus: batch.Status,
+		RequestCounts: MessageBatchCounts{
+			Processing: batch.Processing,
+			Succeeded:  batch.Succeeded,
+			Errored:    batch.Errored,
+			Canceled:   batch.Canceled,
+			Expired:    batch.Expired,
+		},
+		CreatedAt:         batch.CreatedAt,
+		ExpiresAt:         batch.ExpiresAt,
+		EndedAt:           batch.EndedAt,
+		CancelInitiatedAt: batch.CancelInitiatedAt,
+	}
+	if batch.Status == service.BatchStatusEnded {
+		url := batchBaseURL(c) + "/" + batch.BatchID + "/results"
+		response.ResultsURL = &url
+	}
+	return response
+}
+
+// batchBaseURL 根据当前请求推导 /v1/messages/batches 的绝对地址，兼容 BASE_PATH 与反向代理
+func batchBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	path := c.Request.URL.Path
+	if i := strings.Index(path, batchPathSuffix); i >= 0 {
+		path = path[:i]
+	}
+	return scheme + "://" + c.Request.Host + path + batchPathSuffix
+}
+
+// batchAPIKeyID 当前请求的 API Key，批处理只对创建者可见
+func batchAPIKeyID(c *gin.Context) uint {
+	if apiKey := middleware.APIKeyFromContext(c); apiKey != nil {
+		return apiKey.ID
+	}
+	return 0
+}
+
+// findMessageBatch 按路径参数查找当前 API Key 创建的批处理，未找到时写入 404
+func findMessageBatch(c *gin.Context) (models.MessageBatch, bool) {
+	batch, err := gorm.G[models.MessageBatch](models.DB).
+		Where("batch_id = ? AND api_key_id = ?", c.Param("id"), batchAPIKeyID(c)).First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ErrorWithHttpStatus(c, http.StatusNotFound, http.StatusNotFound, "Message batch not found")
+		} else {
+			common.InternalServerError(c, "Database error: "+err.Error())
+		}
+		return batch, false
+	}
+	return batch, true
+}
+
+// CreateMessageBatch 创建 Anthropic 消息批处理，各请求在后台经常规路由链路执行
+func CreateMessageBatch(c *gin.Context) {
+	var req struct {
+		Requests []service.MessageBatchRequest `json:"requests"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Requests) == 0 || len(req.Requests) > batchMaxRequests {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid number of batch requests")
+		return
+	}
+	apiKey := middleware.APIKeyFromContext(c)
+	seen := make(map[string]bool, len(req.Requests))
+	for _, request := range req.Requests {
+		if request.CustomID == "" || seen[request.CustomID] {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Batch custom_id must be unique and non-empty")
+			return
+		}
+		seen[request.CustomID] = true
+		params := gjson.ParseBytes(request.Params)
+		if !params.IsObject() || params.Get("model").String() == "" {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid batch request params: "+request.CustomID)
+			return
+		}
+		if params.Get("stream").Bool() {
+			common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Streaming is not supported in batch requests")
+			return
+		}
+		if apiKey != nil && !apiKey.AllowsModel(params.Get("model").String()) {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Model not allowed for this API key")
+			return
+		}
+	}
+
+	header := http.Header{}
+	for _, key := range batchForwardHeaders {
+		if value := c.GetHeader(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+	batch, err := service.CreateMessageBatch(c.Request.Context(), batchAPIKeyID(c), header, req.Requests, RunBatchItem)
+	if err != nil {
+		common.InternalServerError(c, "Failed to create message batch: "+err.Error())
+		return
+	}
+	common.SuccessRaw(c, batchResponse(c, *batch))
+}
+
+// ListMessageBatches 列出当前 API Key 的批处理，按创建时间倒序，after_id 用于翻页
+func ListMessageBatches(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(batchDefaultLimit)))
+	if err != nil || limit <= 0 || limit > batchMaxLimit {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid limit parameter")
+		return
+	}
+	ctx := c.Request.Context()
+	query := models.DB.WithContext(ctx).Model(&models.MessageBatch{}).Where("api_key_id = ?", batchAPIKeyID(c))
+	if afterID := c.Query("after_id"); afterID != "" {
+		after, err := gorm.G[models.MessageBatch](models.DB).Where("batch_id = ?", afterID).First(ctx)
+		if err != nil {
+			common.ErrorWithHttpStatus(c, http.StatusNotFound, http.StatusNotFound, "Message batch not found")
+			return
+		}
+		query = query.Where("id < ?", after.ID)
+	}
+	var batches []models.MessageBatch
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&batches).Error; err != nil {
+		common.InternalServerError(c, "Failed to query message batches: "+err.Error())
+		return
+	}
+
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]MessageBatchResponse, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batchResponse(c, batch))
+	}
+	var firstID, lastID *string
+	if len(data) > 0 {
+		firstID, lastID = &data[0].ID, &data[len(data)-1].ID
+	}
+	common.SuccessRaw(c, gin.H{"data": data, "has_more": hasMore, "first_id": firstID, "last_id": lastID})
+}
+
+// GetMessageBatch 查询批处理状态
+func GetMessageBatch(c *gin.Context) {
+	batch, ok := findMessageBatch(c)
+	if !ok {
+		return
+	}
+	common.SuccessRaw(c, batchResponse(c, batch))
+}
+
+// GetMessageBatchResults 以 JSONL 返回已结束批处理的结果，每行一个请求
+func GetMessageBatchResults(c *gin.Context) {
+	batch, ok := findMessageBatch(c)
+	if !ok {
+		return
+	}
+	if batch.Status != service.BatchStatusEnded {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Message batch is still in progress")
+		return
+	}
+
+	c.Header("Content-Type", "application/x-jsonl")
+	c.Status(http.StatusOK)
+	var lastID uint
+	for {
+		items, err := gorm.G[models.MessageBatchItem](models.DB).
+			Where("batch_id = ? AND id > ?", batch.ID, lastID).Order("id ASC").Limit(batchDefaultLimit * 5).Find(c.Request.Context())
+		if err != nil || len(items) == 0 {
+			return
+		}
+		lastID = items[len(items)-1].ID
+		for _, item := range items {
+			line, err := service.BatchResultLine(item)
+			if err != nil {
+				continue
+			}
+			c.Writer.Write(append(line, '\n'))
+		}
+		c.Writer.Flush()
+	}
+}
+
+// CancelMessageBatch 取消批处理，已完成的请求结果保留
+func CancelMessageBatch(c *gin.Context) {
+	batch, ok := findMessageBatch(c)
+	if !ok {
+		return
+	}
+	if err := service.CancelMessageBatch(c.Request.Context(), &batch); err != nil {
+		common.InternalServerError(c, "Failed to cancel message batch: "+err.Error())
+		return
+	}
+	batch, ok = findMessageBatch(c)
+	if !ok {
+		return
+	}
+	common.SuccessRaw(c, batchResponse(c, batch))
+}
+
+// DeleteMessageBatch 删除已结束的批处理及其结果
+func DeleteMessageBatch(c *gin.Context) {
+	batch, ok := findMessageBatch(c)
+	if !ok {
+		return
+	}
+	if batch.Status != service.BatchStatusEnded {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Message batch is still in progress")
+		return
+	}
+	if err := service.DeleteMessageBatch(c.Request.Context(), batch); err != nil {
+		common.InternalServerError(c, "Failed to delete message batch: "+err.Error())
+		return
+	}
+	common.SuccessRaw(c, gin.H{"id": batch.BatchID, "type": "message_batch_deleted"})
+}
+
+// batchResponseWriter 收集批处理单个请求的响应
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header { return w.header }
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+// RunBatchItem 以批处理创建者的身份将单个请求交给 /v1/messages 链路处理，复用路由、限流、配额与日志
+func RunBatchItem(ctx context.Context, batch models.MessageBatch, params []byte) (int, []byte) {
+	writer := &batchResponseWriter{header: http.Header{}}
+	c, _ := gin.CreateTestContext(writer)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(params))
+	if err != nil {
+		return http.StatusInternalServerError, batchError(http.StatusInternalServerError, err.Error())
+	}
+	for key, values := range batch.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// 批处理中的请求按 batch 优先级排队，名额紧张时让位于交互式请求
+	req.Header.Set(service.PriorityHeader, service.PriorityBatch)
+	c.Request = req
+
+	if batch.APIKeyID != 0 {
+		apiKey, err := gorm.G[models.APIKey](models.DB).Where("id = ?", batch.APIKeyID).First(ctx)
+		if err != nil || apiKey.Expired() {
+			return http.StatusUnauthorized, batchError(http.StatusUnauthorized, "API key is no longer valid")
+		}
+		middleware.SetAPIKey(c, &apiKey)
+	}
+	Messages(c)
+
+	status := writer.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// 业务错误以 200 状态码返回时按响应中的 code 判断
+	if code := gjson.GetBytes(writer.body.Bytes(), "code"); status == http.StatusOK && code.Exists() && code.Int() != http.StatusOK {
+		status = int(code.Int())
+	}
+	return status, writer.body.Bytes()
+}
+
+// batchError 构造与 common.Response 一致的错误响应体
+func batchError(status int, message string) []byte {
+	body, _ := json.Marshal(common.Response{Code: status, Message: message})
+	return body
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestMessageBatches(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "hello", 10, 5)))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+
+	router := gin.New()
+	router.POST("/v1/messages/batches", CreateMessageBatch)
+	router.GET("/v1/messages/batches", ListMessageBatches)
+	router.GET("/v1/messages/batches/:id", GetMessageBatch)
+	router.GET("/v1/messages/batches/:id/results", GetMessageBatchResults)
+	router.DELETE("/v1/messages/batches/:id", DeleteMessageBatch)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/v1/messages/batches", `{"requests":[{"custom_id":"a","params":{"model":"test-model","stream":true}}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("stream request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w := do(http.MethodPost, "/v1/messages/batches", `{"requests":[
+		{"custom_id":"ok","params":{"model":"test-model","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}},
+		{"custom_id":"missing","params":{"model":"unknown-model","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	id := gjson.Get(w.Body.String(), "id").String()
+	if !strings.HasPrefix(id, "msgbatch_") || gjson.Get(w.Body.String(), "request_counts.processing").Int() != 2 {
+		t.Fatalf("unexpected batch: %s", w.Body.String())
+	}
+
+	var batch string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		batch = do(http.MethodGet, "/v1/messages/batches/"+id, "").Body.String()
+		if gjson.Get(batch, "processing_status").String() == "ended" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch did not end: %s", batch)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if gjson.Get(batch, "request_counts.succeeded").Int() != 1 || gjson.Get(batch, "request_counts.errored").Int() != 1 ||
+		!strings.HasSuffix(gjson.Get(batch, "results_url").String(), "/v1/messages/batches/"+id+"/results") {
+		t.Fatalf("unexpected ended batch: %s", batch)
+	}
+	if got := do(http.MethodGet, "/v1/messages/batches", "").Body.String(); gjson.Get(got, "data.#").Int() != 1 || gjson.Get(got, "first_id").String() != id {
+		t.Fatalf("unexpected list: %s", got)
+	}
+
+	w = do(http.MethodGet, "/v1/messages/batches/"+id+"/results", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-jsonl" {
+		t.Fatalf("results: status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	results := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		if !json.Valid([]byte(line)) {
+			t.Fatalf("invalid result line: %s", line)
+		}
+		results[gjson.Get(line, "custom_id").String()] = line
+	}
+	if got := results["ok"]; gjson.Get(got, "result.type").String() != "succeeded" || gjson.Get(got, "result.message.content.0.text").String() != "hello" {
+		t.Errorf("ok result = %s", got)
+	}
+	if got := results["missing"]; gjson.Get(got, "result.type").String() != "errored" || gjson.Get(got, "result.error.type").String() != "error" {
+		t.Errorf("missing result = %s", got)
+	}
+	if len(upstream.Requests()) != 1 {
+		t.Errorf("upstream requests = %d, want 1", len(upstream.Requests()))
+	}
+
+	if w := do(http.MethodDelete, "/v1/messages/batches/"+id, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/v1/messages/batches/"+id, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: status = %d", w.Code)
+	}
+	testutil.WaitForLogs(t, 1)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const defaultBillingTolerance = 0.05
+
+// ImportBilling 导入供应商账单 CSV（multipart 字段 file，可选 provider 指定默认供应商）
+func ImportBilling(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		common.BadRequest(c, "Missing billing file: "+err.Error())
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		common.BadRequest(c, "Failed to open billing file: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	records, err := service.ParseBillingCSV(file, c.PostForm("provider"), fileHeader.Filename)
+	if err != nil {
+		common.BadRequest(c, "Invalid billing file: "+err.Error())
+		return
+	}
+	if len(records) == 0 {
+		common.BadRequest(c, "Billing file contains no records")
+		return
+	}
+	if err := service.ImportBillingRecords(c.Request.Context(), records); err != nil {
+		common.InternalServerError(c, "Failed to import billing records: "+err.Error())
+		return
+	}
+
+	common.Success(c, map[string]any{
+		"imported": len(records),
+	})
+}
+
+// GetBillingRecords 获取已导入的账单记录（支持 provider、month 筛选）
+func GetBillingRecords(c *gin.Context) {
+	query := gorm.G[models.BillingRecord](models.DB).Where("1 = 1")
+	if provider := c.Query("provider"); provider != "" {
+		query = query.Where("provider_name = ?", provider)
+	}
+	if month := c.Query("month"); month != "" {
+		query = query.Where("month = ?", month)
+	}
+	records, err := query.Order("month DESC, provider_name, provider_model").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query billing records: "+err.Error())
+		return
+	}
+	common.Success(c, records)
+}
+
+// ReconcileBilling 对比账单与日志用量，输出偏差报告（tolerance 为相对偏差，默认 0.05）
+func ReconcileBilling(c *gin.Context) {
+	tolerance := defaultBillingTolerance
+	if raw := c.Query("tolerance"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 {
+			common.BadRequest(c, "Invalid tolerance")
+			return
+		}
+		tolerance = value
+	}
+
+	report, err := service.ReconcileBilling(c.Request.Context(), c.Query("month"), c.Query("provider"), tolerance)
+	if err != nil {
+		common.InternalServerError(c, "Failed to reconcile billing: "+err.Error())
+		return
+	}
+	common.Success(c, report)
+}
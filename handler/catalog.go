@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// CatalogImportRequest 模型目录导入请求
+type CatalogImportRequest struct {
+	URL              string `json:"url"`               // OpenRouter 风格目录地址，为空时使用 OpenRouter
+	ProviderID       uint   `json:"provider_id"`       // 只处理该供应商的关联，0 表示全部
+	ImportPricing    bool   `json:"import_pricing"`    // 同时写入定价
+	OverwritePricing bool   `json:"overwrite_pricing"` // 覆盖已有定价
+}
+
+// ImportCatalog 从模型目录导入上下文长度、模态与定价，按供应商模型名匹配关联
+func ImportCatalog(c *gin.Context) {
+	var req CatalogImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	result, err := service.ImportCatalog(c.Request.Context(), service.CatalogImportOptions{
+		URL:            req.URL,
+		ProviderID:     req.ProviderID,
+		ImportPricing:  req.ImportPricing,
+		OverwritePrice: req.OverwritePricing,
+	})
+	if err != nil {
+		common.InternalServerError(c, "Failed to import catalog: "+err.Error())
+		return
+	}
+	common.Success(c, result)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const testCatalog = `{"data":[
+	{"id":"anthropic/claude-sonnet-4","context_length":200000,"architecture":{"input_modalities":["text","image"],"output_modalities":["text"]},
+	 "pricing":{"prompt":"0.000003","completion":"0.000015","input_cache_read":"0.0000003"},"top_provider":{"context_length":200000,"max_completion_tokens":64000}},
+	{"id":"openai/gpt-4o-mini","context_length":128000,"architecture":{"input_modalities":["text"],"output_modalities":["text"]},
+	 "pricing":{"prompt":"0.00000015","completion":"0.0000006"},"top_provider":{"context_length":128000,"max_completion_tokens":16384}}
+]}`
+
+func TestImportCatalog(t *testing.T) {
+	testutil.SetupDB(t)
+	catalog := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testCatalog))
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, "{}"))
+
+	model := testutil.SeedModel(t, "catalog-model")
+	provider := testutil.SeedProvider(t, "catalog-provider", consts.StyleOpenAI, upstream.URL)
+	sonnet := testutil.SeedAssociation(t, model, provider, "claude-sonnet-4", 100, 1)
+	mini := testutil.SeedAssociation(t, model, provider, "openai/GPT-4o-mini", 100, 1)
+	testutil.SeedAssociation(t, model, provider, "unknown-model", 100, 1)
+
+	ctx := context.Background()
+	existing := models.Pricing{ModelWithProviderID: mini.ID, InputPrice: 1, OutputPrice: 2}
+	if err := gorm.G[models.Pricing](models.DB).Create(ctx, &existing); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/catalog/import", ImportCatalog)
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"url":%q,"provider_id":%d,"import_pricing":true}`, catalog.URL, provider.ID)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/catalog/import", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	result := gjson.Get(w.Body.String(), "data")
+	if result.Get("matched").Int() != 2 || result.Get("pricing_updated").Int() != 1 || result.Get("unmatched").Raw != `["unknown-model"]` {
+		t.Fatalf("unexpected result %s", result.Raw)
+	}
+
+	association, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", sonnet.ID).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta := association.Metadata; meta == nil || meta.CatalogID != "anthropic/claude-sonnet-4" || meta.ContextLength != 200000 || meta.MaxOutputTokens != 64000 || len(meta.InputModalities) != 2 {
+		t.Errorf("unexpected metadata %+v", association.Metadata)
+	}
+	pricing, err := gorm.G[models.Pricing](models.DB).Where("model_with_provider_id = ?", sonnet.ID).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pricing.InputPrice != 3 || pricing.OutputPrice != 15 {
+		t.Errorf("Expected imported pricing 3/15, got %v/%v", pricing.InputPrice, pricing.OutputPrice)
+	}
+	kept, err := gorm.G[models.Pricing](models.DB).Where("model_with_provider_id = ?", mini.ID).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.InputPrice != 1 {
+		t.Errorf("Expected existing pricing to be kept without overwrite, got %v", kept.InputPrice)
+	}
+}
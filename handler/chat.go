@@ -1,17 +1,24 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/atopos31/llmio/common"
 	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/middleware"
 	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/providers"
 	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 	"gorm.io/gorm"
 )
 
@@ -23,8 +30,12 @@ func ModelsHandler(c *gin.Context) {
 		return
 	}
 
+	apiKey := middleware.APIKeyFromContext(c)
 	models := make([]providers.Model, 0)
 	for _, llmModel := range llmModels {
+		if apiKey != nil && !apiKey.AllowsModel(llmModel.Name) {
+			continue
+		}
 		models = append(models, providers.Model{
 			ID:      llmModel.Name,
 			Object:  "model",
@@ -51,6 +62,67 @@ func Messages(c *gin.Context) {
 	chatHandler(c, service.BeforerAnthropic, service.ProcesserAnthropic, consts.StyleAnthropic)
 }
 
+// EmbeddingsHandler OpenAI 兼容 embeddings 接口，与对话接口共用权重/优先级选择与日志记录
+func EmbeddingsHandler(c *gin.Context) {
+	chatHandler(c, service.BeforerEmbeddings, service.ProcesserEmbeddings, consts.StyleOpenAIEmbeddings)
+}
+
+// CountTokensHandler Anthropic count_tokens 接口，响应头 X-Token-Source 标明结果来自上游还是本地估算
+func CountTokensHandler(c *gin.Context) {
+	reqBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	c.Request.Body.Close()
+
+	if apiKey := middleware.APIKeyFromContext(c); apiKey != nil && !apiKey.AllowsModel(gjson.GetBytes(reqBody, "model").String()) {
+		common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Model not allowed for this API key")
+		return
+	}
+
+	tokens, source, err := service.CountTokens(c.Request.Context(), reqBody, c.Request.Header)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	c.Header("X-Token-Source", source)
+	common.SuccessRaw(c, gin.H{"input_tokens": tokens})
+}
+
+// CompletionsHandler 兼容旧版 /v1/completions：prompt 转换为 chat 请求走统一链路，响应再转换回 text_completion 格式
+func CompletionsHandler(c *gin.Context) {
+	reqBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	c.Request.Body.Close()
+
+	chatBody, err := service.CompletionsToChat(reqBody)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	proxyChat(c, chatBody, service.BeforerOpenAI, service.ProcesserOpenAI, consts.StyleOpenAI, func(body io.Reader, stream bool) (io.Reader, error) {
+		if stream {
+			return service.ChatStreamToCompletions(body), nil
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		converted, err := service.ChatToCompletions(data)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(converted), nil
+	})
+}
+
+// bodyConverter 在响应写回客户端前对响应体做格式转换
+type bodyConverter func(body io.Reader, stream bool) (io.Reader, error)
+
 func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor service.Processer, style string) {
 	// 读取原始请求体
 	reqBody, err := io.ReadAll(c.Request.Body)
@@ -59,48 +131,209 @@ func chatHandler(c *gin.Context, preProcessor service.Beforer, postProcessor ser
 		return
 	}
 	c.Request.Body.Close()
+	proxyChat(c, reqBody, preProcessor, postProcessor, style, nil)
+}
+
+func proxyChat(c *gin.Context, reqBody []byte, preProcessor service.Beforer, postProcessor service.Processer, style string, convert bodyConverter) {
 	// 预处理、提取模型参数
 	before, err := preProcessor(reqBody)
 	if err != nil {
 		common.InternalServerError(c, err.Error())
 		return
 	}
+	// 请求头或模型名后缀指定供应商时跳过负载均衡，白名单按去掉后缀的模型名校验
+	if err := service.PinProvider(c.Request.Context(), before, c.GetHeader(service.PinProviderHeader)); err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	// 不存在的模型名按别名路由到配置的模型
+	if err := service.ResolveModelAlias(c.Request.Context(), before); err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	// 校验 API Key 的模型白名单
+	var apiKeyID uint
+	rateLimit := service.RateLimitTarget{Model: before.Model}
+	if apiKey := middleware.APIKeyFromContext(c); apiKey != nil {
+		if !apiKey.AllowsModel(before.Model) {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Model not allowed for this API key")
+			return
+		}
+		if quotaErr := service.CheckQuota(apiKey); quotaErr != nil {
+			if quotaErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+			}
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, quotaErr.Message())
+			return
+		}
+		apiKeyID = apiKey.ID
+		rateLimit.APIKeyID, rateLimit.KeyRPM, rateLimit.KeyTPM = apiKey.ID, apiKey.RPM, apiKey.TPM
+	}
 	// 按模型获取可用 provider
-	ctx := c.Request.Context()
+	// 客户端断开时取消上游请求；上游请求随 ctx 结束，写回失败时也需主动取消
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	// 输入超过模型配置的阈值时，先经由摘要模型压缩较早的对话
+	service.CompressContext(ctx, style, before, summarizeViaPipeline)
+	// 输入超过模型配置的 token 上限时不转发，按客户端接口格式返回 400
+	if budgetErr := service.CheckInputBudget(ctx, style, before, c.Request.Header); budgetErr != nil {
+		writeAPIError(c, style, http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", budgetErr.Error())
+		return
+	}
 	providersWithMeta, err := service.ProvidersWithMetaBymodelsName(ctx, style, *before)
 	if err != nil {
 		common.InternalServerError(c, err.Error())
 		return
 	}
 
+	// 按 API Key 与模型限流；并发名额已满或被限流时排队等待，interactive 请求先于 batch 请求放行，名额在响应写完后归还
+	rateLimit.ModelRPM, rateLimit.ModelTPM = providersWithMeta.RPM, providersWithMeta.TPM
+	admit := func() *service.RateLimitError { return service.GetRateLimiter().Allow(rateLimit) }
+	release, err := service.GetRequestScheduler().Acquire(ctx, service.ResolvePriorityClass(middleware.APIKeyFromContext(c), c.GetHeader(service.PriorityHeader)), admit)
+	if err != nil {
+		var limitErr *service.RateLimitError
+		if errors.As(err, &limitErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, limitErr.Message())
+		} else if errors.Is(err, service.ErrRequestQueueTimeout) {
+			c.Header("Retry-After", "1")
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, "Request queue timeout")
+		}
+		return
+	}
+	defer release()
+
+	// API Key 配置的日志级别优先于模型配置
+	providersWithMeta.LogLevel = service.ResolveLogLevel(providersWithMeta.LogLevel, middleware.APIKeyFromContext(c))
+	providersWithMeta.RawCapture = service.NewRawCapture(providersWithMeta.LogLevel)
+	providersWithMeta.ResponseHasher = service.NewResponseHasher()
+	providersWithMeta.LogSample = service.NewLogSample(before.Model, providersWithMeta.LogSampleRate)
+	providersWithMeta.TPMReservation = service.NewTPMReservation()
+
 	startReq := time.Now()
+	// 等待上游首个字节期间按模型配置发送 SSE 心跳
+	hb := startHeartbeat(c, before.Stream, providersWithMeta.Heartbeat)
 	// 调用负载均衡后的 provider 并转发
 	res, logId, err := service.BalanceChat(ctx, startReq, style, *before, *providersWithMeta, models.ReqMeta{
 		Header:    c.Request.Header,
 		RemoteIP:  c.ClientIP(),
-		UserAgent: c.Request.UserAgent(),
+		UserAgent: service.NormalizeUserAgent(ctx, c.Request.UserAgent()),
+		APIKeyID:  apiKeyID,
 	})
+	// 已发送过心跳时响应头已经写出，错误改以 SSE 事件返回
+	streaming := hb.stop()
 	if err != nil {
+		if streaming {
+			writeStreamError(c, err.Error())
+			return
+		}
+		var quarantineErr *service.QuarantineError
+		if errors.As(err, &quarantineErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(quarantineErr.Until).Seconds()))))
+		}
 		common.InternalServerError(c, err.Error())
 		return
 	}
 	defer res.Body.Close()
 
+	// 输出看门狗：超出模型配置的输出上限时中断响应，错误经由管道记录到日志
+	watched := service.NewOutputWatchdog(res.Body, before.Model, int64(providersWithMeta.MaxOutputTokens), int64(providersWithMeta.MaxOutputBytes))
+
 	pr, pw := io.Pipe()
-	tee := io.TeeReader(res.Body, pw)
-	// 异步处理输出并记录 tokens
-	go service.RecordLog(context.Background(), startReq, pr, postProcessor, logId, *before, providersWithMeta.IOLog)
+	var body io.Reader = io.TeeReader(watched, pw)
+	// 异步处理输出并记录 tokens，沿用请求 ctx 中的请求 ID 与 trace，但不随请求取消
+	go service.RecordLog(context.WithoutCancel(ctx), startReq, pr, postProcessor, logId, *before, providersWithMeta.LogLevel, providersWithMeta.RawCapture, providersWithMeta.ResponseHasher, providersWithMeta.LogSample, providersWithMeta.ToolAuditWebhook, rateLimit, providersWithMeta.TPMReservation)
+
+	// 模型级响应后处理在协议转换之前执行，规则按客户端请求的格式匹配
+	if post := service.NewResponsePostProcessor(providersWithMeta.ResponseRules, style, *before); post != nil {
+		convert = chainConverters(post.Apply, convert)
+	}
 
-	writeHeader(c, before.Stream, res.Header)
-	if _, err := io.Copy(c.Writer, tee); err != nil {
+	header := res.Header
+	if convert != nil {
+		if body, err = convert(body, before.Stream); err != nil {
+			pw.CloseWithError(err)
+			if streaming {
+				writeStreamError(c, err.Error())
+				return
+			}
+			common.InternalServerError(c, err.Error())
+			return
+		}
+		// 转换后长度变化，不能沿用上游的 Content-Length
+		header = header.Clone()
+		header.Del("Content-Length")
+	}
+
+	writeHeader(c, before.Stream, header)
+	out := &clientWriter{Writer: c.Writer}
+	if _, err := io.Copy(out, hb.wrap(body, out)); err != nil {
+		// 客户端中途断开：立即取消上游请求，日志记为 cancelled
+		if out.err != nil || c.Request.Context().Err() != nil {
+			slog.Info("client disconnected", "model", before.Model, "log_id", logId, "error", err)
+			cancel()
+			pw.CloseWithError(service.ErrClientCancelled)
+			if closer, ok := body.(io.Closer); ok {
+				closer.Close()
+			}
+			return
+		}
+		var limitErr *service.OutputLimitError
+		if errors.As(err, &limitErr) {
+			slog.Warn("output watchdog triggered", "model", before.Model, "log_id", logId, "error", err)
+		}
 		pw.CloseWithError(err)
-		common.InternalServerError(c, err.Error())
+		// 转换器在独立协程中写管道，客户端断开后关闭读端让其退出
+		if closer, ok := body.(io.Closer); ok {
+			closer.Close()
+		}
+		writeCopyError(c, before.Stream, err)
 		return
 	}
 
 	pw.Close()
 }
 
+// writeCopyError 转发响应体途中出错：响应头已写出时不能再返回 JSON，流式响应追加 SSE error 事件，
+// 非流式响应只能中断；响应尚未写出时按 JSON 返回
+func writeCopyError(c *gin.Context, stream bool, err error) {
+	if !c.Writer.Written() {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	if stream {
+		writeStreamError(c, err.Error())
+	}
+}
+
+// clientWriter 记录写回客户端时的错误，用于区分客户端断开与上游错误
+type clientWriter struct {
+	io.Writer
+	err error
+}
+
+func (w *clientWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// chainConverters 依次执行 first 与 next，next 可为空
+func chainConverters(first, next bodyConverter) bodyConverter {
+	if next == nil {
+		return first
+	}
+	return func(body io.Reader, stream bool) (io.Reader, error) {
+		converted, err := first(body, stream)
+		if err != nil {
+			return nil, err
+		}
+		return next(converted, stream)
+	}
+}
+
 func writeHeader(c *gin.Context, stream bool, header http.Header) {
 	for k, values := range header {
 		for _, value := range values {
@@ -0,0 +1,1286 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// upstreamSpec 一个假上游及其关联配置
+type upstreamSpec struct {
+	name         string
+	providerType string
+	priority     int
+	handler      http.HandlerFunc
+}
+
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/v1/models", ModelsHandler)
+	router.POST("/v1/chat/completions", ChatCompletionsHandler)
+	router.POST("/v1/messages", Messages)
+	router.POST("/v1/responses", ResponsesHandler)
+	return router
+}
+
+var responseRules = &models.ResponseRules{
+	Replacements:           []models.ResponseReplacement{{Pattern: `(?m)^\[relay\].*\n`}},
+	TrimTrailingWhitespace: true,
+	EnforceStop:            true,
+}
+
+func withResponseRules(rules *models.ResponseRules) testutil.ModelOption {
+	return func(m *models.Model) { m.ResponseRules = rules }
+}
+
+func withLogLevel(level string) testutil.ModelOption {
+	return func(m *models.Model) { m.LogLevel = level }
+}
+
+func TestChatPipeline(t *testing.T) {
+	const (
+		modelName     = "test-model"
+		upstreamModel = "upstream-model"
+	)
+	openAIBody := `{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`
+	openAIStreamBody := `{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	anthropicBody := `{"model":"test-model","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		seedModel  bool
+		modelOpts  []testutil.ModelOption
+		upstreams  []upstreamSpec
+		wantStatus int
+		wantLogs   int
+		check      func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog)
+	}{
+		{
+			name:      "openai passthrough",
+			path:      "/v1/chat/completions",
+			body:      openAIBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "hello", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if got := gjson.Get(body, "choices.0.message.content").String(); got != "hello" {
+					t.Errorf("content = %q, want hello", got)
+				}
+				reqs := upstreams[0].Requests()
+				if len(reqs) != 1 || reqs[0].Path != "/chat/completions" {
+					t.Fatalf("upstream requests = %+v", reqs)
+				}
+				if got := gjson.GetBytes(reqs[0].Body, "model").String(); got != upstreamModel {
+					t.Errorf("upstream model = %q, want %q", got, upstreamModel)
+				}
+				if got := reqs[0].Header.Get("Authorization"); got != "Bearer "+testutil.TestAPIKey {
+					t.Errorf("upstream authorization = %q", got)
+				}
+				if logs[0].Status != "success" || logs[0].TotalTokens != 15 {
+					t.Errorf("log = status %q tokens %d, want success 15", logs[0].Status, logs[0].TotalTokens)
+				}
+				if sum := sha256.Sum256([]byte(body)); logs[0].ResponseHash != hex.EncodeToString(sum[:]) || logs[0].ResponseSize != int64(len(body)) {
+					t.Errorf("response hash = %q size %d, want hash of client body", logs[0].ResponseHash, logs[0].ResponseSize)
+				}
+			},
+		},
+		{
+			name:      "openai stream",
+			path:      "/v1/chat/completions",
+			body:      openAIStreamBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.SSE(testutil.OpenAIChatStream(upstreamModel, 10, 2, "hel", "lo")...)},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if !strings.Contains(body, `"content":"hel"`) || !strings.HasSuffix(strings.TrimSpace(body), "data: [DONE]") {
+					t.Errorf("unexpected stream body: %s", body)
+				}
+				if got := gjson.GetBytes(upstreams[0].Requests()[0].Body, "stream_options.include_usage").Bool(); !got {
+					t.Error("stream_options.include_usage not injected")
+				}
+				if logs[0].TotalTokens != 12 {
+					t.Errorf("log tokens = %d, want 12", logs[0].TotalTokens)
+				}
+			},
+		},
+		{
+			name:      "ollama stream normalized",
+			path:      "/v1/chat/completions",
+			body:      openAIStreamBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "local", providerType: consts.StyleOllama, priority: 100, handler: testutil.SSE(
+					`{"id":"1","object":"chat.completion.chunk","model":"upstream-model","choices":[{"index":0,"delta":{"role":"assistant","reasoning":"think"},"finish_reason":null}]}`,
+					`{"id":"1","object":"chat.completion.chunk","model":"upstream-model","choices":[{"index":0,"delta":{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":null}]}`,
+					`{"id":"1","object":"chat.completion.chunk","model":"upstream-model","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+					"[DONE]",
+				)},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				for _, want := range []string{`"reasoning_content":"think"`, `"arguments":"{}"},"index":0}`, `"finish_reason":"tool_calls"`} {
+					if !strings.Contains(body, want) {
+						t.Errorf("stream body missing %s: %s", want, body)
+					}
+				}
+				if strings.Contains(body, `"reasoning":`) {
+					t.Errorf("non-standard reasoning field not rewritten: %s", body)
+				}
+				if reqs := upstreams[0].Requests(); len(reqs) != 1 || reqs[0].Path != "/v1/chat/completions" {
+					t.Fatalf("upstream requests = %+v", reqs)
+				}
+			},
+		},
+		{
+			name:      "anthropic client converted to openai provider",
+			path:      "/v1/messages",
+			body:      anthropicBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "hello", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if got := gjson.Get(body, "type").String(); got != "message" {
+					t.Errorf("type = %q, want message: %s", got, body)
+				}
+				if got := gjson.Get(body, "content.0.text").String(); got != "hello" {
+					t.Errorf("content text = %q, want hello", got)
+				}
+				reqs := upstreams[0].Requests()
+				if len(reqs) != 1 || reqs[0].Path != "/chat/completions" {
+					t.Fatalf("upstream requests = %+v", reqs)
+				}
+				if got := gjson.GetBytes(reqs[0].Body, "messages.0.content").String(); !strings.Contains(got, "hi") {
+					t.Errorf("converted messages = %s", gjson.GetBytes(reqs[0].Body, "messages").Raw)
+				}
+			},
+		},
+		{
+			name:      "retry falls back to lower priority",
+			path:      "/v1/chat/completions",
+			body:      openAIBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 200, handler: testutil.JSON(http.StatusInternalServerError, `{"error":{"message":"boom"}}`)},
+				{name: "backup", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "from backup", 1, 1))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   2,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if got := gjson.Get(body, "choices.0.message.content").String(); got != "from backup" {
+					t.Errorf("content = %q, want from backup", got)
+				}
+				if len(upstreams[0].Requests()) != 1 || len(upstreams[1].Requests()) != 1 {
+					t.Errorf("requests primary=%d backup=%d, want 1 each", len(upstreams[0].Requests()), len(upstreams[1].Requests()))
+				}
+				if logs[0].ProviderName != "primary" || logs[0].Status != "error" || !strings.Contains(logs[0].Error, "500") {
+					t.Errorf("first log = %+v", logs[0])
+				}
+				if logs[1].ProviderName != "backup" || logs[1].Status != "success" || logs[1].Retry != 1 {
+					t.Errorf("second log = %+v", logs[1])
+				}
+			},
+		},
+		{
+			name:      "all upstreams fail",
+			path:      "/v1/chat/completions",
+			body:      openAIBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusBadGateway, `bad gateway`)},
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if len(upstreams[0].Requests()) != 1 {
+					t.Errorf("upstream requests = %d, want 1", len(upstreams[0].Requests()))
+				}
+				if logs[0].Status != "error" {
+					t.Errorf("log status = %q, want error", logs[0].Status)
+				}
+			},
+		},
+		{
+			name:      "response rules enforce stop and strip banner",
+			path:      "/v1/chat/completions",
+			body:      `{"model":"test-model","stop":["END"],"messages":[{"role":"user","content":"hi"}]}`,
+			seedModel: true,
+			modelOpts: []testutil.ModelOption{withResponseRules(responseRules)},
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "Hello\n[relay] ad\nworld  END ignored", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if got := gjson.Get(body, "choices.0.message.content").String(); got != "Hello\nworld" {
+					t.Errorf("content = %q, want %q", got, "Hello\nworld")
+				}
+				if got := gjson.Get(body, "choices.0.finish_reason").String(); got != "stop" {
+					t.Errorf("finish_reason = %q, want stop", got)
+				}
+			},
+		},
+		{
+			name:      "response rules rewrite stream",
+			path:      "/v1/chat/completions",
+			body:      `{"model":"test-model","stream":true,"stop":"END","messages":[{"role":"user","content":"hi"}]}`,
+			seedModel: true,
+			modelOpts: []testutil.ModelOption{withResponseRules(responseRules)},
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.SSE(testutil.OpenAIChatStream(upstreamModel, 10, 2, "Hel", "lo\n[rel", "ay] ad\nwor", "ld  E", "ND ignored")...)},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				var content strings.Builder
+				for _, line := range strings.Split(body, "\n") {
+					if data, ok := strings.CutPrefix(line, "data: "); ok {
+						content.WriteString(gjson.Get(data, "choices.0.delta.content").String())
+					}
+				}
+				if content.String() != "Hello\nworld" {
+					t.Errorf("streamed content = %q, want %q", content.String(), "Hello\nworld")
+				}
+				if logs[0].TotalTokens != 12 {
+					t.Errorf("log tokens = %d, want 12", logs[0].TotalTokens)
+				}
+			},
+		},
+		{
+			name:      "responses client converted to openai stream",
+			path:      "/v1/responses",
+			body:      `{"model":"test-model","stream":true,"input":"hi"}`,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.SSE(testutil.OpenAIChatStream(upstreamModel, 10, 2, "hel", "lo")...)},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if got := gjson.GetBytes(upstreams[0].Requests()[0].Body, "messages.0.content").String(); got != "hi" {
+					t.Errorf("upstream message = %q, want hi", got)
+				}
+				var deltas strings.Builder
+				var completed string
+				for _, line := range strings.Split(body, "\n") {
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok {
+						continue
+					}
+					switch gjson.Get(data, "type").String() {
+					case "response.output_text.delta":
+						deltas.WriteString(gjson.Get(data, "delta").String())
+					case "response.completed":
+						completed = data
+					}
+				}
+				if deltas.String() != "hello" {
+					t.Errorf("streamed deltas = %q, want hello", deltas.String())
+				}
+				if got := gjson.Get(completed, "response.output.0.content.0.text").String(); got != "hello" {
+					t.Errorf("completed output = %q, want hello: %s", got, completed)
+				}
+				if logs[0].TotalTokens != 12 {
+					t.Errorf("log tokens = %d, want 12", logs[0].TotalTokens)
+				}
+			},
+		},
+		{
+			name:      "openai client converted to responses provider",
+			path:      "/v1/chat/completions",
+			body:      openAIStreamBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAIRes, priority: 100, handler: testutil.SSE(
+					`{"type":"response.created","response":{"id":"resp_1","model":"upstream-model","output":[]}}`,
+					`{"type":"response.output_item.added","output_index":0,"item":{"type":"message","id":"msg_1"}}`,
+					`{"type":"response.output_text.delta","output_index":0,"delta":"hel"}`,
+					`{"type":"response.output_text.delta","output_index":0,"delta":"lo"}`,
+					`{"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":10,"output_tokens":2,"total_tokens":12}}}`,
+				)},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				reqs := upstreams[0].Requests()
+				if reqs[0].Path != "/responses" || gjson.GetBytes(reqs[0].Body, "input.0.content").String() != "hi" {
+					t.Errorf("upstream request = %s %s", reqs[0].Path, reqs[0].Body)
+				}
+				if !strings.Contains(body, `"content":"hel"`) || !strings.HasSuffix(strings.TrimSpace(body), "data: [DONE]") {
+					t.Errorf("unexpected stream body: %s", body)
+				}
+				if logs[0].TotalTokens != 12 {
+					t.Errorf("log tokens = %d, want 12", logs[0].TotalTokens)
+				}
+			},
+		},
+		{
+			name:      "prompts log level records input only",
+			path:      "/v1/chat/completions",
+			body:      openAIBody,
+			seedModel: true,
+			modelOpts: []testutil.ModelOption{withLogLevel(models.LogLevelPrompts)},
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "hello", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if logs[0].LogLevel != models.LogLevelPrompts || !logs[0].ChatIO {
+					t.Errorf("log level = %q chat io %v", logs[0].LogLevel, logs[0].ChatIO)
+				}
+				chatIO := testutil.WaitForChatIO(t, logs[0].ID)
+				if chatIO == nil {
+					t.Fatal("chat io not recorded")
+				}
+				if chatIO.Input != openAIBody || chatIO.OfString != "" || chatIO.RawRequest != "" {
+					t.Errorf("chat io = %+v, want input only", chatIO)
+				}
+			},
+		},
+		{
+			name:      "raw log level captures upstream bodies",
+			path:      "/v1/messages",
+			body:      anthropicBody,
+			seedModel: true,
+			modelOpts: []testutil.ModelOption{withLogLevel(models.LogLevelRaw)},
+			upstreams: []upstreamSpec{
+				{name: "primary", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "hello", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   1,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				chatIO := testutil.WaitForChatIO(t, logs[0].ID)
+				if chatIO == nil {
+					t.Fatal("chat io not recorded")
+				}
+				if chatIO.Input != anthropicBody || chatIO.OfString == "" {
+					t.Errorf("chat io = %+v, want input and output", chatIO)
+				}
+				if chatIO.RawRequest != string(upstreams[0].Requests()[0].Body) {
+					t.Errorf("raw request = %q, want upstream request body", chatIO.RawRequest)
+				}
+				if got := gjson.Get(chatIO.RawResponse, "choices.0.message.content").String(); got != "hello" {
+					t.Errorf("raw response = %q, want upstream openai body", chatIO.RawResponse)
+				}
+			},
+		},
+		{
+			name:      "response transform failure fails over with one log per attempt",
+			path:      "/v1/messages",
+			body:      anthropicBody,
+			seedModel: true,
+			upstreams: []upstreamSpec{
+				{name: "broken", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.JSON(http.StatusOK, `not json`)},
+				{name: "backup", providerType: consts.StyleOpenAI, priority: 50, handler: testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse(upstreamModel, "hello", 10, 5))},
+			},
+			wantStatus: http.StatusOK,
+			wantLogs:   2,
+			check: func(t *testing.T, body string, upstreams []*testutil.Upstream, logs []models.ChatLog) {
+				if len(logs) != 2 || logs[0].ProviderName != "broken" || logs[0].Status != "error" || !strings.HasPrefix(logs[0].Error, "transform response error") {
+					t.Fatalf("logs = %+v", logs)
+				}
+				if logs[1].ProviderName != "backup" || logs[1].Status != "success" {
+					t.Errorf("backup log = %+v", logs[1])
+				}
+			},
+		},
+		{
+			name:       "unknown model",
+			path:       "/v1/chat/completions",
+			body:       openAIBody,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.SetupDB(t)
+			var upstreams []*testutil.Upstream
+			if tt.seedModel {
+				model := testutil.SeedModel(t, modelName, tt.modelOpts...)
+				for _, spec := range tt.upstreams {
+					upstream := testutil.NewUpstream(t, spec.handler)
+					provider := testutil.SeedProvider(t, spec.name, spec.providerType, upstream.URL)
+					testutil.SeedAssociation(t, model, provider, upstreamModel, spec.priority, 1)
+					upstreams = append(upstreams, upstream)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			newTestRouter().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.check == nil {
+				return
+			}
+			logs := testutil.WaitForLogs(t, tt.wantLogs)
+			if len(logs) != tt.wantLogs {
+				t.Fatalf("logs = %d, want %d", len(logs), tt.wantLogs)
+			}
+			tt.check(t, w.Body.String(), upstreams, logs)
+		})
+	}
+}
+
+// disconnectingRecorder 首次写入响应体后取消请求，模拟客户端中途断开
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	disconnect context.CancelFunc
+}
+
+func (r *disconnectingRecorder) Write(p []byte) (int, error) {
+	r.disconnect()
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestChatClientDisconnect(t *testing.T) {
+	testutil.SetupDB(t)
+	upstreamDone := make(chan struct{})
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "data: %s\n\n", testutil.OpenAIChatStream("upstream-model", 10, 2, "hel")[0])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(upstreamDone)
+	})
+	model := testutil.SeedModel(t, "test-model")
+	provider := testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL)
+	testutil.SeedAssociation(t, model, provider, "upstream-model", 100, 1)
+
+	// 客户端收到首个 chunk 后断开
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder(), disconnect: cancel}
+	body := `{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	newTestRouter().ServeHTTP(w, req)
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+	logs := testutil.WaitForLogs(t, 1)
+	if len(logs) != 1 || logs[0].Status != "cancelled" {
+		t.Fatalf("logs = %+v, want one cancelled log", logs)
+	}
+}
+
+func TestChatLogSampling(t *testing.T) {
+	testutil.SetupDB(t)
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "fail") {
+			http.Error(w, "upstream error", http.StatusInternalServerError)
+			return
+		}
+		testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "hello", 10, 5))(w, r)
+	})
+	model := testutil.SeedModel(t, "sampled-model", func(m *models.Model) { m.LogSampleRate = 3 })
+	provider := testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL)
+	testutil.SeedAssociation(t, model, provider, "upstream-model", 100, 1)
+
+	send := func(content string) int {
+		body := `{"model":"sampled-model","messages":[{"role":"user","content":"` + content + `"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w.Code
+	}
+	for range 3 {
+		if code := send("hi"); code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", code)
+		}
+	}
+	// 失败请求不论是否命中采样都要记录
+	for range 2 {
+		send("fail")
+	}
+
+	logs := testutil.WaitForLogs(t, 3)
+	var successes, failures int
+	for _, log := range logs {
+		switch log.Status {
+		case "success":
+			successes++
+			if log.SampleWeight != 3 {
+				t.Errorf("sampled log weight = %d, want 3", log.SampleWeight)
+			}
+		case "error":
+			failures++
+			if log.SampleWeight != 1 {
+				t.Errorf("error log weight = %d, want 1", log.SampleWeight)
+			}
+		}
+	}
+	if successes != 1 || failures != 2 {
+		t.Fatalf("logs = %d success %d error, want 1 and 2", successes, failures)
+	}
+
+	// 未采样的请求仍计入用量统计
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		records, err := gorm.G[models.UsageRecord](models.DB).Where("model = ?", "sampled-model").Find(context.Background())
+		if err != nil {
+			t.Fatalf("query usage: %v", err)
+		}
+		if len(records) == 1 && records[0].Requests == 3 && records[0].TotalTokens == 45 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage records = %+v, want 3 requests 45 tokens", records)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChatModelFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		primaryDown bool // 主模型没有可用供应商
+		wantLogs    int
+	}{
+		{name: "primary providers fail", wantLogs: 2},
+		{name: "primary providers disabled", primaryDown: true, wantLogs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.SetupDB(t)
+			backupUpstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("backup-upstream", "hello", 10, 5)))
+			backup := testutil.SeedModel(t, "backup-model")
+			testutil.SeedAssociation(t, backup, testutil.SeedProvider(t, "backup", consts.StyleOpenAI, backupUpstream.URL), "backup-upstream", 100, 1)
+
+			primary := testutil.SeedModel(t, "test-model", func(m *models.Model) { m.Fallbacks = []string{"missing-model", "backup-model"} })
+			if !tt.primaryDown {
+				primaryUpstream := testutil.NewUpstream(t, testutil.JSON(http.StatusInternalServerError, `{"error":"boom"}`))
+				testutil.SeedAssociation(t, primary, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, primaryUpstream.URL), "upstream-model", 100, 1)
+			}
+
+			body := `{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			newTestRouter().ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+			}
+			if got := backupUpstream.Requests(); len(got) != 1 || gjson.GetBytes(got[0].Body, "model").String() != "backup-upstream" {
+				t.Fatalf("backup upstream requests = %+v", got)
+			}
+
+			logs := testutil.WaitForLogs(t, tt.wantLogs)
+			if len(logs) != tt.wantLogs {
+				t.Fatalf("logs = %d, want %d", len(logs), tt.wantLogs)
+			}
+			served := logs[len(logs)-1]
+			if served.Status != "success" || served.Name != "test-model" || served.ServedModel != "backup-model" {
+				t.Errorf("served log = status %q name %q served %q", served.Status, served.Name, served.ServedModel)
+			}
+			if !tt.primaryDown && (logs[0].Status != "error" || logs[0].ServedModel != "test-model") {
+				t.Errorf("primary log = status %q served %q", logs[0].Status, logs[0].ServedModel)
+			}
+		})
+	}
+}
+
+func TestChatRequestID(t *testing.T) {
+	testutil.SetupDB(t)
+	primaryUpstream := testutil.NewUpstream(t, testutil.JSON(http.StatusInternalServerError, `{"error":"boom"}`))
+	backupUpstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "hello", 10, 5)))
+	model := testutil.SeedModel(t, "test-model")
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, primaryUpstream.URL), "upstream-model", 200, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "backup", consts.StyleOpenAI, backupUpstream.URL), "upstream-model", 100, 1)
+
+	router := newTestRouter()
+	router.Use(middleware.RequestID())
+	router.POST("/rid/v1/chat/completions", ChatCompletionsHandler)
+	send := func(requestID string) string {
+		body := `{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/rid/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set(service.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+		}
+		return w.Header().Get(service.RequestIDHeader)
+	}
+
+	if got := send("client-req-1"); got != "client-req-1" {
+		t.Errorf("response request id = %q, want client-req-1", got)
+	}
+	// 供应商重试的每次上游请求与每条日志都携带同一请求 ID
+	for _, upstream := range []*testutil.Upstream{primaryUpstream, backupUpstream} {
+		if got := upstream.Requests(); len(got) != 1 || got[0].Header.Get(service.RequestIDHeader) != "client-req-1" {
+			t.Errorf("upstream requests = %+v", got)
+		}
+	}
+	logs := testutil.WaitForLogs(t, 2)
+	for _, log := range logs {
+		if log.RequestID != "client-req-1" {
+			t.Errorf("log %d request id = %q, want client-req-1", log.ID, log.RequestID)
+		}
+	}
+
+	// 非法的请求 ID 被替换为新生成的 ID
+	if got := send("bad id"); len(got) != 32 || got == "client-req-1" {
+		t.Errorf("generated request id = %q", got)
+	}
+}
+
+func TestChatStickySession(t *testing.T) {
+	testutil.SetupDB(t)
+	sticky := true
+	model := testutil.SeedModel(t, "test-model", func(m *models.Model) { m.StickySession = &sticky })
+	var upstreams []*testutil.Upstream
+	for _, name := range []string{"first", "second"} {
+		upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "hello", 10, 5)))
+		testutil.SeedAssociation(t, model, testutil.SeedProvider(t, name, consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+		upstreams = append(upstreams, upstream)
+	}
+
+	send := func(header, content string) {
+		body := `{"model":"test-model","messages":[{"role":"user","content":"` + content + `"},{"role":"assistant","content":"ok"},{"role":"user","content":"next"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set("X-Session-ID", header)
+		}
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+		}
+	}
+	// 同一会话头与同一首条用户消息各自固定到一个供应商
+	for range 8 {
+		send("session-a", "hi")
+	}
+	for range 8 {
+		send("", "sticky by first message")
+	}
+	testutil.WaitForLogs(t, 16)
+
+	first, second := len(upstreams[0].Requests()), len(upstreams[1].Requests())
+	if first+second != 16 || first%8 != 0 {
+		t.Errorf("upstream requests = %d / %d, want each session on a single provider", first, second)
+	}
+}
+
+func TestModelsHandler(t *testing.T) {
+	testutil.SetupDB(t)
+	testutil.SeedModel(t, "model-a")
+	testutil.SeedModel(t, "model-b")
+
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+	ids := gjson.Get(w.Body.String(), "data.#.id").Array()
+	if len(ids) != 2 || ids[0].String() != "model-a" || ids[1].String() != "model-b" {
+		t.Errorf("model ids = %v", ids)
+	}
+}
+
+func TestChatContextLengthRouting(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	small := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "small", 10, 5)))
+	large := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "large", 10, 5)))
+	smallAssociation := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "small", consts.StyleOpenAI, small.URL), "upstream-model", 200, 1)
+	largeAssociation := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "large", consts.StyleOpenAI, large.URL), "upstream-model", 100, 1)
+	ctx := context.Background()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", smallAssociation.ID).Update(ctx, "context_length", 100); err != nil {
+		t.Fatal(err)
+	}
+	// 未手动配置时使用目录导入的上下文长度
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", largeAssociation.ID).
+		Updates(ctx, models.ModelWithProvider{Metadata: &models.ModelMetadata{ContextLength: 1000}}); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(content string, maxTokens int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model":"test-model","max_tokens":%d,"messages":[{"role":"user","content":%q}]}`, maxTokens, content)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("hi", 10); w.Code != http.StatusOK || len(small.Requests()) != 1 {
+		t.Fatalf("short request: status = %d, small requests = %d", w.Code, len(small.Requests()))
+	}
+	if w := send(strings.Repeat("hello world ", 100), 10); w.Code != http.StatusOK || len(large.Requests()) != 1 || len(small.Requests()) != 1 {
+		t.Fatalf("long request: status = %d, small = %d, large = %d", w.Code, len(small.Requests()), len(large.Requests()))
+	}
+	if w := send("hi", 2000); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "context length") {
+		t.Fatalf("oversized request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	testutil.WaitForLogs(t, 2)
+}
+
+func TestChatRequestQuarantine(t *testing.T) {
+	testutil.SetupDB(t)
+	t.Cleanup(func() {
+		for _, entry := range service.QuarantineEntries() {
+			service.ReleaseQuarantine(entry.Fingerprint)
+		}
+	})
+	if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyRequestQuarantineThreshold).Update(context.Background(), "value", "2"); err != nil {
+		t.Fatal(err)
+	}
+	model := testutil.SeedModel(t, "test-model")
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusBadRequest, `{"error":{"message":"content policy violation"}}`))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+
+	send := func(content string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model":"test-model","messages":[{"role":"user","content":%q}]}`, content)
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	for range 2 {
+		if w := send("bad prompt"); w.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+	if got := len(upstream.Requests()); got != 2 {
+		t.Fatalf("upstream requests = %d, want 2", got)
+	}
+
+	w := send("bad prompt")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "content policy violation") || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("quarantined response: status = %d, retry-after = %q, body = %s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if got := len(upstream.Requests()); got != 2 {
+		t.Fatalf("quarantined request reached upstream, requests = %d", got)
+	}
+
+	send("another prompt")
+	if got := len(upstream.Requests()); got != 3 {
+		t.Fatalf("different body should reach upstream, requests = %d", got)
+	}
+	entries := service.QuarantineEntries()
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	quarantined := entries[len(entries)-1]
+	if quarantined.Failures != 2 || quarantined.Hits != 1 || quarantined.Until.IsZero() {
+		t.Errorf("quarantined entry = %+v", quarantined)
+	}
+	testutil.WaitForLogs(t, 3)
+}
+
+func TestChatPromptCacheRouting(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	plain := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "plain", 10, 5)))
+	cached := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "cached", 10, 5)))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "plain", consts.StyleOpenAI, plain.URL), "upstream-model", 200, 1)
+	cachedAssociation := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "cached", consts.StyleOpenAI, cached.URL), "upstream-model", 100, 1)
+	ctx := context.Background()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", cachedAssociation.ID).Update(ctx, "prompt_cache", true); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(routing string) {
+		t.Helper()
+		if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyPromptCacheRouting).Update(ctx, "value", routing); err != nil {
+			t.Fatal(err)
+		}
+		body := `{"model":"test-model","messages":[{"role":"user","content":[{"type":"text","text":"long prompt","cache_control":{"type":"ephemeral"}}]}]}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body: %s", routing, w.Code, w.Body.String())
+		}
+	}
+	lastBody := func(upstream *testutil.Upstream) gjson.Result {
+		requests := upstream.Requests()
+		return gjson.GetBytes(requests[len(requests)-1].Body, "messages.0.content.0")
+	}
+
+	send(service.PromptCacheRoutingOff)
+	if len(plain.Requests()) != 1 || !lastBody(plain).Get("cache_control").Exists() {
+		t.Fatalf("off: expected cache_control to be forwarded unchanged, plain requests = %d", len(plain.Requests()))
+	}
+	send(service.PromptCacheRoutingStrip)
+	if len(plain.Requests()) != 2 || lastBody(plain).Get("cache_control").Exists() || lastBody(plain).Get("text").String() != "long prompt" {
+		t.Fatalf("strip: expected cache_control to be removed, got %s", lastBody(plain).Raw)
+	}
+	send(service.PromptCacheRoutingPrefer)
+	if len(cached.Requests()) != 1 || !lastBody(cached).Get("cache_control").Exists() {
+		t.Fatalf("prefer: expected cache-capable association, cached requests = %d", len(cached.Requests()))
+	}
+	testutil.WaitForLogs(t, 3)
+}
+
+func TestChatPromptCacheMetrics(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hello"}],"stop_reason":"end_turn",`+
+		`"usage":{"input_tokens":20,"cache_read_input_tokens":70,"cache_creation_input_tokens":10,"output_tokens":5}}`))
+	association := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "claude", consts.StyleAnthropic, upstream.URL), "claude", 100, 1)
+	ctx := context.Background()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).Update(ctx, "prompt_cache", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyPromptCacheAutoInject).Update(ctx, "value", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter()
+	router.GET("/api/metrics/cache", CacheMetrics)
+	body := `{"model":"test-model","messages":[{"role":"system","content":"long instructions"},{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+	}
+	// 输入 token 与 OpenAI 一致包含缓存读写部分
+	if usage := gjson.Get(w.Body.String(), "usage"); usage.Get("prompt_tokens").Int() != 100 || usage.Get("prompt_tokens_details.cached_tokens").Int() != 70 {
+		t.Errorf("usage = %s", usage.Raw)
+	}
+	sent := upstream.Requests()[0].Body
+	if gjson.GetBytes(sent, "system.0.cache_control.type").String() != "ephemeral" || gjson.GetBytes(sent, "messages.0.content.0.cache_control.type").String() != "ephemeral" {
+		t.Errorf("Expected cache breakpoints to be injected, got %s", sent)
+	}
+
+	logs := testutil.WaitForLogs(t, 1)
+	if details := logs[0].PromptTokensDetails; logs[0].PromptTokens != 100 || details.CachedTokens != 70 || details.CacheCreationTokens != 10 {
+		t.Errorf("log usage = %+v", logs[0].Usage)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/metrics/cache", nil))
+	metric := gjson.Get(w.Body.String(), "data.0")
+	if metric.Get("provider_name").String() != "claude" || metric.Get("hit_rate").Float() != 0.7 || metric.Get("cache_creation_tokens").Int() != 10 {
+		t.Errorf("cache metrics = %s", w.Body.String())
+	}
+}
+
+func TestChatEmptyStream(t *testing.T) {
+	streamBody := `{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	empty := testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 0)...)
+	tests := []struct {
+		mode      string
+		upstreams []upstreamSpec
+		check     func(t *testing.T, body string, logs []models.ChatLog)
+	}{
+		{
+			mode: service.EmptyStreamFailover,
+			upstreams: []upstreamSpec{
+				{name: "empty", providerType: consts.StyleOpenAI, priority: 200, handler: empty},
+				{name: "backup", providerType: consts.StyleOpenAI, priority: 100, handler: testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 2, "hel", "lo")...)},
+			},
+			check: func(t *testing.T, body string, logs []models.ChatLog) {
+				if !strings.Contains(body, `"content":"hel"`) {
+					t.Errorf("Expected backup stream to be forwarded, got %s", body)
+				}
+				if len(logs) != 2 || logs[0].Status != "error" || logs[0].Error != service.ErrEmptyStream.Error() || logs[1].Status != "success" {
+					t.Errorf("Expected empty stream to fail over, got %+v", logs)
+				}
+			},
+		},
+		{
+			mode:      service.EmptyStreamError,
+			upstreams: []upstreamSpec{{name: "empty", providerType: consts.StyleOpenAI, priority: 100, handler: empty}},
+			check: func(t *testing.T, body string, logs []models.ChatLog) {
+				if !strings.Contains(body, "[DONE]") {
+					t.Errorf("Expected empty stream to be forwarded, got %s", body)
+				}
+				if len(logs) != 1 || logs[0].Status != "error" || logs[0].Error != service.ErrEmptyStream.Error() {
+					t.Errorf("Expected empty stream to be logged as error, got %+v", logs)
+				}
+			},
+		},
+		{
+			mode:      service.EmptyStreamOff,
+			upstreams: []upstreamSpec{{name: "empty", providerType: consts.StyleOpenAI, priority: 100, handler: empty}},
+			check: func(t *testing.T, body string, logs []models.ChatLog) {
+				if len(logs) != 1 || logs[0].Status != "success" {
+					t.Errorf("Expected empty stream to be logged as success, got %+v", logs)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			testutil.SetupDB(t)
+			if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyEmptyStreamHandling).Update(context.Background(), "value", tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			model := testutil.SeedModel(t, "test-model")
+			for _, spec := range tt.upstreams {
+				upstream := testutil.NewUpstream(t, spec.handler)
+				testutil.SeedAssociation(t, model, testutil.SeedProvider(t, spec.name, spec.providerType, upstream.URL), "upstream-model", spec.priority, 1)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(streamBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			newTestRouter().ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body: %s", w.Code, w.Body.String())
+			}
+			tt.check(t, w.Body.String(), testutil.WaitForLogs(t, len(tt.upstreams)))
+		})
+	}
+}
+
+func TestChatProviderPinning(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	upstreams := map[string]*testutil.Upstream{}
+	for name, priority := range map[string]int{"primary": 200, "debug": 100} {
+		upstreams[name] = testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", name, 10, 2)))
+		testutil.SeedAssociation(t, model, testutil.SeedProvider(t, name, consts.StyleOpenAI, upstreams[name].URL), "upstream-model", priority, 1)
+	}
+
+	send := func(body, pinned string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if pinned != "" {
+			req.Header.Set(service.PinProviderHeader, pinned)
+		}
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(`{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`, "debug"); !strings.Contains(w.Body.String(), `"content":"debug"`) {
+		t.Errorf("Expected header to pin the lower priority provider, got %s", w.Body.String())
+	}
+	if w := send(`{"model":"test-model@debug","messages":[{"role":"user","content":"hi"}]}`, ""); !strings.Contains(w.Body.String(), `"content":"debug"`) {
+		t.Errorf("Expected model suffix to pin the provider, got %s", w.Body.String())
+	}
+	if got := len(upstreams["primary"].Requests()); got != 0 {
+		t.Errorf("Expected pinned requests to skip the balancer, primary received %d", got)
+	}
+	if got := upstreams["debug"].Requests(); len(got) != 2 || gjson.GetBytes(got[1].Body, "model").String() != "upstream-model" {
+		t.Errorf("Expected suffix to be removed before forwarding, got %+v", got)
+	}
+	logs := testutil.WaitForLogs(t, 2)
+	if logs[1].Name != "test-model" || logs[1].ProviderName != "debug" {
+		t.Errorf("Expected pinned request to be logged under the model, got %+v", logs[1])
+	}
+
+	if w := send(`{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`, "missing"); !strings.Contains(w.Body.String(), "provider missing is not available") {
+		t.Errorf("Expected unknown pinned provider to fail, got %s", w.Body.String())
+	}
+}
+
+func TestChatShadowTraffic(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	primary := testutil.NewUpstream(t, testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 2, "primary")...))
+	shadow := testutil.NewUpstream(t, testutil.SSE(testutil.OpenAIChatStream("candidate-model", 10, 4, "shadow")...))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, primary.URL), "upstream-model", 100, 1)
+	candidate := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "candidate", consts.StyleOpenAI, shadow.URL), "candidate-model", 100, 1)
+	// 影子关联处于禁用状态，不参与正常负载均衡
+	if err := models.DB.Model(&candidate).Update("status", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := models.DB.Model(&model).Updates(map[string]any{"shadow_model_provider_id": candidate.ID, "shadow_percent": 100}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "shadow") {
+		t.Fatalf("Expected the primary response only, got %d %s", w.Code, w.Body.String())
+	}
+
+	var shadowLog models.ShadowLog
+	deadline := time.Now().Add(2 * time.Second)
+	for models.DB.First(&shadowLog).Error != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a shadow log to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if shadowLog.Status != "success" || shadowLog.ProviderName != "candidate" || shadowLog.CompletionTokens != 4 {
+		t.Errorf("Expected shadow metrics to be recorded, got %+v", shadowLog)
+	}
+	if got := shadow.Requests(); len(got) != 1 || gjson.GetBytes(got[0].Body, "model").String() != "candidate-model" {
+		t.Errorf("Expected the shadow association to receive the mirrored request, got %+v", got)
+	}
+	if logs := testutil.WaitForLogs(t, 1); logs[0].ProviderName != "primary" {
+		t.Errorf("Expected only the primary request in chat logs, got %+v", logs)
+	}
+}
+
+func TestChatStreamHeartbeat(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model", func(m *models.Model) { m.StreamHeartbeatSeconds = 1 })
+	var fail, lateBody atomic.Bool
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if lateBody.Load() {
+			// 先返回响应头，首个 token 迟迟不到
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+		}
+		time.Sleep(1300 * time.Millisecond)
+		if fail.Load() {
+			http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 2, "slow")...)(w, r)
+	})
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "slow", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	w := send()
+	body := w.Body.String()
+	if !strings.HasPrefix(body, ": ping\n\n") || !strings.Contains(body, `"content":"slow"`) {
+		t.Errorf("Expected heartbeat before the upstream stream, got %q", body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Expected stream headers with heartbeat, got %q", got)
+	}
+
+	fail.Store(true)
+	w = send()
+	body = w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(body, ": ping\n\n") || !strings.Contains(body, "event: error\ndata: ") {
+		t.Errorf("Expected failure after heartbeat to be sent as an SSE error event, got %d %q", w.Code, body)
+	}
+
+	// 不等待流内容时，收到响应头后继续发送心跳直到首个字节到达
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeyEmptyStreamHandling)).Update("value", service.EmptyStreamOff).Error; err != nil {
+		t.Fatal(err)
+	}
+	fail.Store(false)
+	lateBody.Store(true)
+	body = send().Body.String()
+	if !strings.HasPrefix(body, ": ping\n\n") || !strings.Contains(body, `"content":"slow"`) {
+		t.Errorf("Expected heartbeat while waiting for the first byte, got %q", body)
+	}
+}
+
+func TestChatOutputWatchdogStream(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model", func(m *models.Model) { m.MaxOutputBytes = 200 })
+	deltas := make([]string, 20)
+	for i := range deltas {
+		deltas[i] = strings.Repeat("x", 20)
+	}
+	upstream := testutil.NewUpstream(t, testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 20, deltas...)...))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "runaway", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+
+	// 流已开始后触发看门狗，错误以 SSE error 事件追加，不能再写入 JSON 错误体
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected the stream to have started, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, "event: error\ndata: ") || strings.Contains(body, `"code":500`) {
+		t.Errorf("Expected the watchdog error as a trailing SSE event, got %q", body)
+	}
+}
+
+func TestChatStreamTimeouts(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model", func(m *models.Model) { m.FirstTokenTimeout, m.StreamIdleTimeout = 1, 1 })
+	events := testutil.OpenAIChatStream("upstream-model", 10, 2, "hello", " world")
+	stall := func(w http.ResponseWriter, r *http.Request, sent int) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events[:sent] {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	}
+	stalled := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) { stall(w, r, 0) })
+	fast := testutil.NewUpstream(t, testutil.SSE(events...))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "stalled", consts.StyleOpenAI, stalled.URL), "upstream-model", 100, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "fast", consts.StyleOpenAI, fast.URL), "upstream-model", 50, 1)
+
+	send := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"`+name+`","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	// 首字超时：尚未向客户端写入数据，切换到下一个关联
+	if body := send("test-model").Body.String(); !strings.Contains(body, `"content":" world"`) {
+		t.Errorf("Expected failover after first token timeout, got %q", body)
+	}
+	logs := testutil.WaitForLogs(t, 2)
+	if len(logs) != 2 || logs[0].ProviderName != "stalled" || !strings.Contains(logs[0].Error, service.ErrFirstTokenTimeout.Error()) || logs[1].ProviderName != "fast" || logs[1].Status != "success" {
+		t.Fatalf("logs = %+v, want first token timeout then success", logs)
+	}
+
+	// 流空闲超时：已输出部分内容后上游停滞，中断流并记为失败
+	idleModel := testutil.SeedModel(t, "idle-model", func(m *models.Model) { m.StreamIdleTimeout = 1 })
+	idle := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) { stall(w, r, 1) })
+	testutil.SeedAssociation(t, idleModel, testutil.SeedProvider(t, "idle", consts.StyleOpenAI, idle.URL), "upstream-model", 100, 1)
+	start := time.Now()
+	if body := send("idle-model").Body.String(); !strings.Contains(body, `"content":"hello"`) || strings.Contains(body, `"content":" world"`) {
+		t.Errorf("Expected stream to stop after the first chunk, got %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > 2500*time.Millisecond {
+		t.Errorf("Expected idle stream to be aborted after about 1s, took %v", elapsed)
+	}
+	logs = testutil.WaitForLogs(t, 3)
+	if len(logs) != 3 || logs[2].Status != "error" || !strings.Contains(logs[2].Error, service.ErrStreamIdleTimeout.Error()) {
+		t.Fatalf("logs = %+v, want stream idle timeout error", logs[2:])
+	}
+}
+
+func TestChatStreamFailoverAfterHeaders(t *testing.T) {
+	testutil.SetupDB(t)
+	// 不切换空流时出错的流同样切换关联
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeyEmptyStreamHandling)).Update("value", service.EmptyStreamOff).Error; err != nil {
+		t.Fatal(err)
+	}
+	model := testutil.SeedModel(t, "test-model")
+	broken := testutil.NewUpstream(t, testutil.SSE(
+		`{"id":"chatcmpl-test","object":"chat.completion.chunk","model":"upstream-model","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+		`{"error":{"message":"upstream overloaded","type":"server_error"}}`,
+	))
+	healthy := testutil.NewUpstream(t, testutil.SSE(testutil.OpenAIChatStream("upstream-model", 10, 2, "rescued")...))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "broken", consts.StyleOpenAI, broken.URL), "upstream-model", 100, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "healthy", consts.StyleOpenAI, healthy.URL), "upstream-model", 50, 1)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"test-model","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, `"content":"rescued"`) || strings.Contains(body, "upstream overloaded") {
+		t.Errorf("Expected the healthy provider's stream only, got %q", body)
+	}
+
+	logs := testutil.WaitForLogs(t, 2)
+	if len(logs) != 2 {
+		t.Fatalf("logs = %d, want 2", len(logs))
+	}
+	if logs[0].ProviderName != "broken" || logs[0].Status != "error" || !strings.Contains(logs[0].Error, "upstream overloaded") || logs[0].HeaderFailovers != 0 {
+		t.Errorf("first log = %+v, want stream error event", logs[0])
+	}
+	if logs[1].ProviderName != "healthy" || logs[1].Status != "success" || logs[1].HeaderFailovers != 1 {
+		t.Errorf("second log = %+v, want rescued success with one header failover", logs[1])
+	}
+}
+
+func TestChatInputBudget(t *testing.T) {
+	testutil.SetupDB(t)
+	limited := testutil.SeedModel(t, "limited", func(m *models.Model) { m.MaxInputTokens = 50 })
+	counted := testutil.SeedModel(t, "counted", func(m *models.Model) {
+		enabled := true
+		m.MaxInputTokens, m.PreflightCountTokens = 50, &enabled
+	})
+	openai := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "ok", 10, 5)))
+	testutil.SeedAssociation(t, limited, testutil.SeedProvider(t, "openai", consts.StyleOpenAI, openai.URL), "upstream-model", 100, 1)
+	// 上游 count_tokens 统计的 token 数远大于本地估算
+	anthropic := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/count_tokens") {
+			testutil.JSON(http.StatusOK, `{"input_tokens":500}`)(w, r)
+			return
+		}
+		testutil.JSON(http.StatusOK, `{"id":"msg_1","type":"message","role":"assistant","model":"upstream-model","content":[{"type":"text","text":"ok"}],`+
+			`"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":5}}`)(w, r)
+	})
+	testutil.SeedAssociation(t, counted, testutil.SeedProvider(t, "anthropic", consts.StyleAnthropic, anthropic.URL+"/v1"), "upstream-model", 100, 1)
+
+	send := func(path, model, content string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model":%q,"max_tokens":10,"messages":[{"role":"user","content":%q}]}`, model, content)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("/v1/chat/completions", "limited", "hi"); w.Code != http.StatusOK || len(openai.Requests()) != 1 {
+		t.Fatalf("short request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	// 超出上限的请求不转发，按客户端格式返回 400
+	long := strings.Repeat("hello world ", 100)
+	w := send("/v1/chat/completions", "limited", long)
+	if w.Code != http.StatusBadRequest || gjson.Get(w.Body.String(), "error.code").String() != "context_length_exceeded" ||
+		!strings.Contains(gjson.Get(w.Body.String(), "error.message").String(), "> 50 maximum") || len(openai.Requests()) != 1 {
+		t.Fatalf("openai oversized request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w = send("/v1/messages", "limited", long)
+	if w.Code != http.StatusBadRequest || gjson.Get(w.Body.String(), "type").String() != "error" ||
+		gjson.Get(w.Body.String(), "error.type").String() != "invalid_request_error" {
+		t.Fatalf("anthropic oversized request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 开启 preflight_count_tokens 时以上游 count_tokens 的结果判断
+	w = send("/v1/messages", "counted", "hi")
+	if w.Code != http.StatusBadRequest || !strings.Contains(gjson.Get(w.Body.String(), "error.message").String(), "500 tokens > 50 maximum") {
+		t.Fatalf("counted request: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if requests := anthropic.Requests(); len(requests) != 1 || !strings.HasSuffix(requests[0].Path, "/count_tokens") {
+		t.Fatalf("anthropic requests = %+v", requests)
+	}
+	testutil.WaitForLogs(t, 1)
+}
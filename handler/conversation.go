@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetConversations 会话级统计：轮数、累计 token、上下文增长与使用过的供应商，
+// 支持 days（默认 7）、min_turns、limit（默认 50）与 sort（last_seen、turns、tokens、growth、context）
+func GetConversations(c *gin.Context) {
+	query := service.ConversationQuery{Sort: c.DefaultQuery("sort", "last_seen")}
+	var err error
+	if query.Days, err = strconv.Atoi(c.DefaultQuery("days", "7")); err != nil || query.Days < 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	if query.MinTurns, err = strconv.ParseInt(c.DefaultQuery("min_turns", "1"), 10, 64); err != nil || query.MinTurns < 0 {
+		common.BadRequest(c, "Invalid min_turns parameter")
+		return
+	}
+	if query.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50")); err != nil || query.Limit < 1 {
+		common.BadRequest(c, "Invalid limit parameter")
+		return
+	}
+	if !slices.Contains(service.ConversationSorts, query.Sort) {
+		common.BadRequest(c, "Invalid sort parameter")
+		return
+	}
+
+	stats, err := service.GetConversationStats(c.Request.Context(), query)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query conversations: "+err.Error())
+		return
+	}
+	common.Success(c, stats)
+}
+
+// GetConversation 会话中每轮请求的模型、供应商与 token 数
+func GetConversation(c *gin.Context) {
+	turns, err := service.GetConversationTurns(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		common.InternalServerError(c, "Failed to query conversations: "+err.Error())
+		return
+	}
+	if len(turns) == 0 {
+		common.NotFound(c, "Conversation not found")
+		return
+	}
+	common.Success(c, turns)
+}
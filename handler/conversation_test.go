@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestConversations(t *testing.T) {
+	testutil.SetupDB(t)
+	var calls atomic.Int32
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		prompt := 100 * int(calls.Add(1))
+		testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-4o", "ok", prompt, 10))(w, r)
+	})
+	association := testutil.SeedAssociation(t, testutil.SeedModel(t, "chat"), testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL), "gpt-4o", 100, 1)
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).Update(context.Background(), "context_length", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter()
+	router.GET("/api/conversations", GetConversations)
+	router.GET("/api/conversations/:id", GetConversation)
+	chat := func(session, first string) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"chat","messages":[{"role":"user","content":"`+first+`"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.Header.Set("X-Session-ID", session)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("chat status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+	chat("s1", "hello")
+	chat("s1", "hello")
+	chat("", "another conversation")
+	chat("s1", "hello")
+	testutil.WaitForLogs(t, 4)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Body.String()
+	}
+	got := get("/api/conversations?sort=turns")
+	if gjson.Get(got, "data.#").Int() != 2 {
+		t.Fatalf("conversations = %s", got)
+	}
+	top := gjson.Get(got, "data.0")
+	// s1 三轮输入 token 依次为 100、200、400
+	if top.Get("session_id").String() != "s1" || top.Get("turns").Int() != 3 || top.Get("prompt_tokens").Int() != 700 ||
+		top.Get("context_growth").Float() != 150 || top.Get("context_usage").Float() != 0.4 || top.Get("providers.0").String() != "openai" {
+		t.Fatalf("top conversation = %s", top.Raw)
+	}
+	if !strings.HasPrefix(gjson.Get(got, "data.1.session_id").String(), "msg:") {
+		t.Fatalf("message-derived session = %s", got)
+	}
+
+	if got := get("/api/conversations?min_turns=2"); gjson.Get(got, "data.#").Int() != 1 {
+		t.Fatalf("min_turns filter = %s", got)
+	}
+	if got := get("/api/conversations?sort=bogus"); gjson.Get(got, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("invalid sort = %s", got)
+	}
+	if got := get("/api/conversations/s1"); gjson.Get(got, "data.#").Int() != 3 || gjson.Get(got, "data.2.prompt_tokens").Int() != 400 {
+		t.Fatalf("conversation turns = %s", got)
+	}
+	if got := get("/api/conversations/missing"); gjson.Get(got, "code").Int() != http.StatusNotFound {
+		t.Fatalf("missing conversation = %s", got)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DrainRequest 排空请求，timeout_seconds 为等待进行中请求结束的最长时间
+type DrainRequest struct {
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// DrainModelProvider 排空模型提供商关联：立即停止分配新请求，等待进行中的请求结束后禁用
+func DrainModelProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	var req DrainRequest
+	// 请求体可选，未提供时使用默认超时
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.BadRequest(c, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+	if req.TimeoutSeconds < 0 {
+		common.BadRequest(c, "timeout_seconds must not be negative")
+		return
+	}
+
+	status, err := service.StartDrain(c.Request.Context(), uint(id), time.Duration(req.TimeoutSeconds)*time.Second)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model-provider association not found")
+			return
+		}
+		common.BadRequest(c, err.Error())
+		return
+	}
+	common.Success(c, status)
+}
+
+// GetModelProviderDrain 获取关联当前或最近一次排空的进度
+func GetModelProviderDrain(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	status, ok := service.GetDrainStatus(uint(id))
+	if !ok {
+		common.NotFound(c, "No drain has been started")
+		return
+	}
+	common.Success(c, status)
+}
+
+// CancelModelProviderDrain 取消排空，关联恢复参与选择
+func CancelModelProviderDrain(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	if !service.CancelDrain(uint(id)) {
+		common.BadRequest(c, "Association is not draining")
+		return
+	}
+	status, _ := service.GetDrainStatus(uint(id))
+	common.Success(c, status)
+}
+
+// GetModelProviderDrains 获取所有关联的排空记录
+func GetModelProviderDrains(c *gin.Context) {
+	common.Success(c, service.ListDrains())
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FallbackRequest 备用模型链设置请求结构
+type FallbackRequest struct {
+	Fallbacks []string `json:"fallbacks"` // 按顺序尝试的备用模型名称
+}
+
+// FallbackResponse 模型的备用模型链
+type FallbackResponse struct {
+	ID        uint     `json:"id"`
+	Name      string   `json:"name"`
+	Fallbacks []string `json:"fallbacks"`
+}
+
+// GetModelFallbacks 获取模型的备用模型链
+func GetModelFallbacks(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	common.Success(c, fallbackResponse(model))
+}
+
+// UpdateModelFallbacks 设置模型的备用模型链，备用模型必须存在且不能包含自身或重复
+func UpdateModelFallbacks(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	var req FallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	seen := make(map[string]bool, len(req.Fallbacks))
+	for _, name := range req.Fallbacks {
+		if name == model.Name || seen[name] {
+			common.BadRequest(c, "Invalid fallback model: "+name)
+			return
+		}
+		seen[name] = true
+		count, err := gorm.G[models.Model](models.DB).Where("name = ?", name).Count(ctx, "id")
+		if err != nil {
+			common.InternalServerError(c, "Database error: "+err.Error())
+			return
+		}
+		if count == 0 {
+			common.BadRequest(c, "Fallback model not found: "+name)
+			return
+		}
+	}
+
+	model.Fallbacks = req.Fallbacks
+	if err := models.DB.WithContext(ctx).Model(&model).Select("fallbacks").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update fallbacks: "+err.Error())
+		return
+	}
+	common.Success(c, fallbackResponse(model))
+}
+
+// DeleteModelFallbacks 清空模型的备用模型链
+func DeleteModelFallbacks(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	model.Fallbacks = nil
+	if err := models.DB.WithContext(c.Request.Context()).Model(&model).Select("fallbacks").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update fallbacks: "+err.Error())
+		return
+	}
+	common.Success(c, fallbackResponse(model))
+}
+
+func findModelByParam(c *gin.Context) (models.Model, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return models.Model{}, false
+	}
+	model, err := gorm.G[models.Model](models.DB).Where("id = ?", id).First(c.Request.Context())
+	if err != nil {
+		common.NotFound(c, "Model not found")
+		return models.Model{}, false
+	}
+	return model, true
+}
+
+func fallbackResponse(model models.Model) FallbackResponse {
+	fallbacks := model.Fallbacks
+	if fallbacks == nil {
+		fallbacks = []string{}
+	}
+	return FallbackResponse{ID: model.ID, Name: model.Name, Fallbacks: fallbacks}
+}
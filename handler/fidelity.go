@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// FidelityMetricsResponse 格式转换保真度统计
+type FidelityMetricsResponse struct {
+	Since time.Time              `json:"since"` // 统计起始时间：进程启动或上次重置
+	Stats []service.FidelityStat `json:"stats"`
+}
+
+// FidelityMetrics 获取各 (客户端, 上游) 格式转换路径上丢失字段、无法解析的流式数据块与格式回退的次数
+func FidelityMetrics(c *gin.Context) {
+	since, stats := service.FidelityStats()
+	common.Success(c, FidelityMetricsResponse{Since: since, Stats: stats})
+}
+
+// ResetFidelity 清空格式转换保真度统计
+func ResetFidelity(c *gin.Context) {
+	service.ResetFidelityStats()
+	common.Success(c, nil)
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	filesDefaultLimit = 10000 // 与 OpenAI 一致的列表默认条数
+	filesMaxLimit     = 10000
+)
+
+// findUploadedFile 按路径参数查找当前 API Key 上传的文件，未找到时写入 404
+func findUploadedFile(c *gin.Context) (models.UploadedFile, bool) {
+	file, err := gorm.G[models.UploadedFile](models.DB).
+		Where("file_id = ? AND api_key_id = ?", c.Param("id"), batchAPIKeyID(c)).First(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ErrorWithHttpStatus(c, http.StatusNotFound, http.StatusNotFound, "File not found")
+		} else {
+			common.InternalServerError(c, "Database error: "+err.Error())
+		}
+		return file, false
+	}
+	return file, true
+}
+
+// writeFilesResult 写入上游响应，请求上游失败时返回 502
+func writeFilesResult(c *gin.Context, status int, data []byte, err error) {
+	if err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadGateway, http.StatusBadGateway, "Files request failed: "+err.Error())
+		return
+	}
+	c.Data(status, "application/json", data)
+}
+
+// CreateFile 将 multipart 上传原样转发到供应商并保存文件 ID 映射；
+// 供应商由 X-LLMIO-Provider 请求头指定，未指定时使用文件设置中的默认供应商
+func CreateFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := int64(service.GetFilesSettings(ctx).MaxUploadSize) << 20
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, limit))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			common.ErrorWithHttpStatus(c, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "File exceeds the upload size limit")
+			return
+		}
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	contentType := c.GetHeader("Content-Type")
+	upload, err := service.ParseFileUpload(contentType, body)
+	if err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid file upload: "+err.Error())
+		return
+	}
+
+	status, data, err := service.UploadFile(ctx, batchAPIKeyID(c), c.GetHeader(service.PinProviderHeader), contentType, body, upload)
+	if errors.Is(err, service.ErrFilesProviderUnavailable) {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "No provider available for files")
+		return
+	}
+	writeFilesResult(c, status, data, err)
+}
+
+// ListFiles 列出当前 API Key 上传的文件，按创建时间倒序，支持 purpose 筛选与 after 翻页
+func ListFiles(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(filesDefaultLimit)))
+	if err != nil || limit <= 0 || limit > filesMaxLimit {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "Invalid limit parameter")
+		return
+	}
+	ctx := c.Request.Context()
+	apiKeyID := batchAPIKeyID(c)
+	query := models.DB.WithContext(ctx).Model(&models.UploadedFile{}).Where("api_key_id = ?", apiKeyID)
+	if purpose := c.Query("purpose"); purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if after := c.Query("after"); after != "" {
+		// 翻页游标只在当前 API Key 的文件中查找，避免探测其他 Key 的文件 ID
+		file, err := gorm.G[models.UploadedFile](models.DB).Where("file_id = ? AND api_key_id = ?", after, apiKeyID).First(ctx)
+		if err != nil {
+			common.ErrorWithHttpStatus(c, http.StatusNotFound, http.StatusNotFound, "File not found")
+			return
+		}
+		query = query.Where("id < ?", file.ID)
+	}
+	var files []models.UploadedFile
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&files).Error; err != nil {
+		common.InternalServerError(c, "Failed to query files: "+err.Error())
+		return
+	}
+
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	data := make([]map[string]any, 0, len(files))
+	for _, file := range files {
+		data = append(data, service.FileObject(file))
+	}
+	common.SuccessRaw(c, gin.H{"object": "list", "data": data, "has_more": hasMore})
+}
+
+// GetFile 从上游查询文件信息
+func GetFile(c *gin.Context) {
+	file, ok := findUploadedFile(c)
+	if !ok {
+		return
+	}
+	status, data, err := service.RetrieveFile(c.Request.Context(), file)
+	writeFilesResult(c, status, data, err)
+}
+
+// GetFileContent 以流式转发上游文件内容
+func GetFileContent(c *gin.Context) {
+	file, ok := findUploadedFile(c)
+	if !ok {
+		return
+	}
+	res, err := service.FileContent(c.Request.Context(), file)
+	if err != nil {
+		writeFilesResult(c, 0, nil, err)
+		return
+	}
+	defer res.Body.Close()
+	extraHeaders := map[string]string{}
+	if disposition := res.Header.Get("Content-Disposition"); disposition != "" {
+		extraHeaders["Content-Disposition"] = disposition
+	}
+	c.DataFromReader(res.StatusCode, res.ContentLength, res.Header.Get("Content-Type"), res.Body, extraHeaders)
+}
+
+// DeleteFile 删除上游文件及文件 ID 映射
+func DeleteFile(c *gin.Context) {
+	file, ok := findUploadedFile(c)
+	if !ok {
+		return
+	}
+	status, data, err := service.DeleteFile(c.Request.Context(), file)
+	writeFilesResult(c, status, data, err)
+}
+
+// GetFilesSettings 获取文件上传大小上限与默认供应商
+func GetFilesSettings(c *gin.Context) {
+	common.Success(c, service.GetFilesSettings(c.Request.Context()))
+}
+
+// UpdateFilesSettings 更新文件上传大小上限与默认供应商
+func UpdateFilesSettings(c *gin.Context) {
+	var req service.FilesSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.MaxUploadSize <= 0 {
+		common.BadRequest(c, "Invalid files settings")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.Provider != "" {
+		if _, err := gorm.G[models.Provider](models.DB).Where("name = ?", req.Provider).First(ctx); err != nil {
+			common.NotFound(c, "Provider not found")
+			return
+		}
+	}
+	for key, value := range map[string]string{
+		models.SettingKeyFilesMaxUploadSize: strconv.Itoa(req.MaxUploadSize),
+		models.SettingKeyFilesProvider:      req.Provider,
+	} {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", value); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+	common.Success(c, req)
+}
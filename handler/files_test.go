@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestFiles(t *testing.T) {
+	testutil.SetupDB(t)
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/files":
+			testutil.JSON(http.StatusOK, `{"id":"file-up","object":"file","bytes":5,"filename":"a.txt","purpose":"assistants"}`)(w, r)
+		case r.Method == http.MethodGet && r.URL.Path == "/files/file-up":
+			testutil.JSON(http.StatusOK, `{"id":"file-up","object":"file","bytes":5}`)(w, r)
+		case r.Method == http.MethodGet && r.URL.Path == "/files/file-up/content":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		case r.Method == http.MethodDelete && r.URL.Path == "/files/file-up":
+			testutil.JSON(http.StatusOK, `{"id":"file-up","object":"file","deleted":true}`)(w, r)
+		default:
+			testutil.JSON(http.StatusNotFound, `{"error":{"message":"not found"}}`)(w, r)
+		}
+	})
+	testutil.SeedProvider(t, "claude", consts.StyleAnthropic, upstream.URL)
+	testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL)
+
+	router := gin.New()
+	router.POST("/v1/files", CreateFile)
+	router.GET("/v1/files", ListFiles)
+	router.GET("/v1/files/:id", GetFile)
+	router.GET("/v1/files/:id/content", GetFileContent)
+	router.DELETE("/v1/files/:id", DeleteFile)
+	upload := func(content []byte, provider string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("purpose", "assistants")
+		part, _ := writer.CreateFormFile("file", "a.txt")
+		part.Write(content)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/v1/files", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if provider != "" {
+			req.Header.Set(service.PinProviderHeader, provider)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := upload([]byte("hello"), "claude"); w.Code != http.StatusBadRequest {
+		t.Fatalf("anthropic provider: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyFilesMaxUploadSize)).Update(t.Context(), "value", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if w := upload(bytes.Repeat([]byte("a"), 2<<20), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized upload: status = %d", w.Code)
+	}
+
+	// 未指定供应商时跳过不支持文件接口的 anthropic 供应商
+	w := upload([]byte("hello"), "")
+	id := gjson.Get(w.Body.String(), "id").String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(id, "file-llmio-") {
+		t.Fatalf("upload: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	requests := upstream.Requests()
+	if last := requests[len(requests)-1]; last.Header.Get("Authorization") != "Bearer "+testutil.TestAPIKey ||
+		!strings.HasPrefix(last.Header.Get("Content-Type"), "multipart/form-data; boundary=") || !bytes.Contains(last.Body, []byte("hello")) {
+		t.Fatalf("upstream upload = %+v", last)
+	}
+
+	if got := do(http.MethodGet, "/v1/files?purpose=assistants").Body.String(); gjson.Get(got, "data.#").Int() != 1 ||
+		gjson.Get(got, "data.0.id").String() != id || gjson.Get(got, "data.0.filename").String() != "a.txt" {
+		t.Fatalf("list = %s", got)
+	}
+	if got := do(http.MethodGet, "/v1/files/"+id).Body.String(); gjson.Get(got, "id").String() != id {
+		t.Fatalf("retrieve = %s", got)
+	}
+	if w := do(http.MethodGet, "/v1/files/"+id+"/content"); w.Body.String() != "hello" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("content = %q", w.Body.String())
+	}
+	if w := do(http.MethodGet, "/v1/files/file-up"); w.Code != http.StatusNotFound {
+		t.Fatalf("upstream id should not resolve: status = %d", w.Code)
+	}
+	if got := do(http.MethodDelete, "/v1/files/"+id).Body.String(); !gjson.Get(got, "deleted").Bool() || gjson.Get(got, "id").String() != id {
+		t.Fatalf("delete = %s", got)
+	}
+	if w := do(http.MethodGet, "/v1/files/"+id); w.Code != http.StatusNotFound {
+		t.Fatalf("deleted file: status = %d", w.Code)
+	}
+}
+
+func TestListFilesCursorScopedToAPIKey(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := t.Context()
+	other := models.UploadedFile{FileID: "file-llmio-other", UpstreamFileID: "file-a", APIKeyID: 2, Purpose: "assistants"}
+	own := models.UploadedFile{FileID: "file-llmio-own", UpstreamFileID: "file-b", APIKeyID: 1, Purpose: "assistants"}
+	for _, file := range []*models.UploadedFile{&own, &other} {
+		if err := gorm.G[models.UploadedFile](models.DB).Create(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/v1/files", func(c *gin.Context) {
+		middleware.SetAPIKey(c, &models.APIKey{Model: gorm.Model{ID: 1}})
+		ListFiles(c)
+	})
+	list := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/files"+query, nil))
+		return w
+	}
+
+	if got := list("").Body.String(); gjson.Get(got, "data.#").Int() != 1 || gjson.Get(got, "data.0.id").String() != own.FileID {
+		t.Fatalf("list = %s", got)
+	}
+	// 其他 Key 的文件 ID 不能作为翻页游标
+	if w := list("?after=" + other.FileID); w.Code != http.StatusNotFound {
+		t.Fatalf("cross-key cursor: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := list("?after=" + own.FileID); w.Code != http.StatusOK || gjson.Get(w.Body.String(), "data.#").Int() != 0 {
+		t.Fatalf("own cursor: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
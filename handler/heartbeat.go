@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ssePing SSE 注释行，客户端解析事件时忽略
+var ssePing = []byte(": ping\n\n")
+
+// heartbeat 流式请求等待上游首个字节期间定期向客户端写入 SSE 注释，避免中间代理因连接空闲断开；
+// 选择供应商与重试期间由后台协程写出，收到上游响应后改由读取响应体的协程写出，两者不会同时写入
+type heartbeat struct {
+	c        *gin.Context
+	interval time.Duration
+	quit     chan struct{}
+	done     chan struct{}
+	started  bool // 已向客户端写出响应头
+}
+
+// startHeartbeat 非流式请求或 interval 为 0 时返回 nil
+func startHeartbeat(c *gin.Context, stream bool, interval time.Duration) *heartbeat {
+	if !stream || interval <= 0 {
+		return nil
+	}
+	h := &heartbeat{c: c, interval: interval, quit: make(chan struct{}), done: make(chan struct{})}
+	go h.run()
+	return h
+}
+
+func (h *heartbeat) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C:
+			if err := h.ping(h.c.Writer); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ping 写出一次心跳，首次写出前先发送流式响应头
+func (h *heartbeat) ping(w io.Writer) error {
+	if !h.started {
+		writeHeader(h.c, true, nil)
+		h.started = true
+	}
+	if _, err := w.Write(ssePing); err != nil {
+		return err
+	}
+	h.c.Writer.Flush()
+	return nil
+}
+
+// stop 停止后台心跳并等待协程退出，返回是否已向客户端写出响应头
+func (h *heartbeat) stop() bool {
+	if h == nil {
+		return false
+	}
+	close(h.quit)
+	<-h.done
+	return h.started
+}
+
+// wrap 在读取到上游响应体的首个字节前继续向 out 写出心跳，调用前响应头必须已经写出
+func (h *heartbeat) wrap(body io.Reader, out io.Writer) io.Reader {
+	if h == nil {
+		return body
+	}
+	h.started = true
+	return &heartbeatReader{h: h, body: body, out: out}
+}
+
+type heartbeatRead struct {
+	data []byte
+	err  error
+}
+
+// heartbeatReader 首次读取在后台协程中等待上游数据，等待期间在当前协程写出心跳，之后直接读取上游
+type heartbeatReader struct {
+	h        *heartbeat
+	body     io.Reader
+	out      io.Writer
+	result   chan heartbeatRead
+	received bool
+	pending  []byte
+	err      error
+}
+
+func (r *heartbeatReader) Read(p []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	if r.received {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return r.body.Read(p)
+	}
+	if r.result == nil {
+		r.result = make(chan heartbeatRead, 1)
+		go func() {
+			buf := make([]byte, 32*1024)
+			n, err := r.body.Read(buf)
+			r.result <- heartbeatRead{data: buf[:n], err: err}
+		}()
+	}
+	ticker := time.NewTicker(r.h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case res := <-r.result:
+			r.received = true
+			n := copy(p, res.data)
+			r.pending = res.data[n:]
+			if len(r.pending) == 0 {
+				return n, res.err
+			}
+			r.err = res.err
+			return n, nil
+		case <-ticker.C:
+			if err := r.h.ping(r.out); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// writeStreamError 已经开始流式响应后以 SSE error 事件返回错误，格式兼容 OpenAI 与 Anthropic 客户端
+func writeStreamError(c *gin.Context, message string) {
+	data, _ := json.Marshal(gin.H{"type": "error", "error": gin.H{"type": "api_error", "message": message}})
+	fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+	c.Writer.Flush()
+}
@@ -1,21 +1,22 @@
 package handler
 
 import (
-	"database/sql"
 	"strconv"
 	"time"
 
 	"github.com/atopos31/llmio/common"
 	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type MetricsRes struct {
-	Reqs   int64 `json:"reqs"`
-	Tokens int64 `json:"tokens"`
+	Reqs      int64 `json:"reqs"`
+	Tokens    int64 `json:"tokens"`
+	Cancelled int64 `json:"cancelled"` // 客户端中途断开的请求数
 }
 
+// Metrics 最近 days 天的请求数、token 数与取消数，读取小时级汇总表
 func Metrics(c *gin.Context) {
 	days, err := strconv.Atoi(c.Param("days"))
 	if err != nil {
@@ -25,21 +26,16 @@ func Metrics(c *gin.Context) {
 
 	now := time.Now()
 	year, month, day := now.Date()
-	chain := gorm.G[models.ChatLog](models.DB).Where("created_at >= ?", time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days))
-
-	reqs, err := chain.Count(c.Request.Context(), "id")
+	// 汇总按采样权重还原了实际请求量
+	totals, err := service.RollupTotals(c.Request.Context(), time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days))
 	if err != nil {
 		common.InternalServerError(c, "Failed to count requests: "+err.Error())
 		return
 	}
-	var tokens sql.NullInt64
-	if err := chain.Select("sum(total_tokens) as tokens").Scan(c.Request.Context(), &tokens); err != nil {
-		common.InternalServerError(c, "Failed to sum tokens: "+err.Error())
-		return
-	}
 	common.Success(c, MetricsRes{
-		Reqs:   reqs,
-		Tokens: tokens.Int64,
+		Reqs:      totals.Requests,
+		Tokens:    totals.TotalTokens,
+		Cancelled: totals.Cancelled,
 	})
 }
 
@@ -50,7 +46,8 @@ type Count struct {
 
 func Counts(c *gin.Context) {
 	results := make([]Count, 0)
-	if err := models.DB.Raw("SELECT name as model,COUNT(*) as calls FROM `chat_logs` WHERE `chat_logs`.`deleted_at` IS NULL  GROUP BY `name` ORDER BY `calls` DESC").Scan(&results).Error; err != nil {
+	// 汇总表在日志清理后仍保留历史调用量
+	if err := models.DB.Model(&models.MetricsRollup{}).Select("model, SUM(requests) AS calls").Group("model").Order("calls DESC").Scan(&results).Error; err != nil {
 		common.InternalServerError(c, err.Error())
 	}
 	const topN = 5
@@ -68,4 +65,74 @@ func Counts(c *gin.Context) {
 
 	common.Success(c, results)
 }
- 
+
+// MetricsRollups 最近 days 天按小时或按天的模型-供应商聚合指标，用于长时间范围的仪表盘
+func MetricsRollups(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	interval := c.DefaultQuery("interval", service.RollupIntervalDay)
+	if interval != service.RollupIntervalDay && interval != service.RollupIntervalHour {
+		common.BadRequest(c, "Invalid interval parameter")
+		return
+	}
+	now := time.Now()
+	year, month, day := now.Date()
+	points, err := service.QueryRollups(c.Request.Context(), service.RollupQuery{
+		Since:    time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days),
+		Interval: interval,
+		Model:    c.Query("model"),
+		Provider: c.Query("provider"),
+	})
+	if err != nil {
+		common.InternalServerError(c, "Failed to query metrics rollups: "+err.Error())
+		return
+	}
+	common.Success(c, points)
+}
+
+// ErrorMetrics 最近 hours 小时内按错误分类与供应商统计的错误次数与错误率
+func ErrorMetrics(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours <= 0 {
+		common.BadRequest(c, "Invalid hours parameter")
+		return
+	}
+	breakdown, err := service.GetErrorBreakdown(c.Request.Context(), service.ErrorQuery{
+		Since:    time.Now().Add(-time.Duration(hours) * time.Hour),
+		Model:    c.Query("model"),
+		Provider: c.Query("provider"),
+	})
+	if err != nil {
+		common.InternalServerError(c, "Failed to query error metrics: "+err.Error())
+		return
+	}
+	common.Success(c, breakdown)
+}
+
+// LatencyMetrics 最近 hours 小时内各模型-供应商成功请求的首字时延与总耗时百分位
+func LatencyMetrics(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours <= 0 {
+		common.BadRequest(c, "Invalid hours parameter")
+		return
+	}
+	source := c.DefaultQuery("source", service.LatencySourceLogs)
+	if source != service.LatencySourceLogs && source != service.LatencySourceRollups {
+		common.BadRequest(c, "Invalid source parameter")
+		return
+	}
+	metrics, err := service.GetLatencyMetrics(c.Request.Context(), service.LatencyQuery{
+		Since:    time.Now().Add(-time.Duration(hours) * time.Hour),
+		Source:   source,
+		Model:    c.Query("model"),
+		Provider: c.Query("provider"),
+	})
+	if err != nil {
+		common.InternalServerError(c, "Failed to query latency metrics: "+err.Error())
+		return
+	}
+	common.Success(c, metrics)
+}
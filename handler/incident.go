@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// IncidentRequest 确认供应商故障的请求
+type IncidentRequest struct {
+	Title string `json:"title"`
+	Note  string `json:"note"`
+}
+
+// OpenProviderIncident 确认供应商正在发生的已知故障，关闭前冻结其关联的自动权重与优先级衰减
+func OpenProviderIncident(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req IncidentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Title == "" {
+		common.BadRequest(c, "Incident title is required")
+		return
+	}
+
+	incident, err := service.OpenIncident(c.Request.Context(), uint(id), req.Title, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			common.NotFound(c, "Provider not found")
+		case errors.Is(err, service.ErrIncidentOpen):
+			common.BadRequest(c, "Provider already has an open incident")
+		default:
+			common.InternalServerError(c, "Failed to open incident: "+err.Error())
+		}
+		return
+	}
+	common.Success(c, incident)
+}
+
+// CloseProviderIncident 关闭供应商当前的故障，恢复自动衰减
+func CloseProviderIncident(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	incident, err := service.CloseIncident(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrNoOpenIncident) {
+			common.NotFound(c, "Provider has no open incident")
+			return
+		}
+		common.InternalServerError(c, "Failed to close incident: "+err.Error())
+		return
+	}
+	common.Success(c, incident)
+}
+
+// GetIncidents 查询故障记录，provider_id 按供应商过滤，open=true 只返回未关闭的
+func GetIncidents(c *gin.Context) {
+	var providerID uint64
+	if raw := c.Query("provider_id"); raw != "" {
+		var err error
+		if providerID, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			common.BadRequest(c, "Invalid provider_id")
+			return
+		}
+	}
+	incidents, err := service.ListIncidents(c.Request.Context(), uint(providerID), c.Query("open") == "true")
+	if err != nil {
+		common.InternalServerError(c, "Failed to query incidents: "+err.Error())
+		return
+	}
+	common.Success(c, incidents)
+}
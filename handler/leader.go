@@ -0,0 +1,17 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetLeaderStatus 获取本实例的主节点选举状态与当前租约持有者
+func GetLeaderStatus(c *gin.Context) {
+	status, err := service.GetLeaderElector().Status(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query leader lease: "+err.Error())
+		return
+	}
+	common.Success(c, status)
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// LocaleSettingRequest 错误信息语言设置
+type LocaleSettingRequest struct {
+	Locale string `json:"locale"` // auto, en, zh
+}
+
+// GetLocaleSetting 获取面向客户端错误信息的语言设置
+func GetLocaleSetting(c *gin.Context) {
+	common.Success(c, LocaleSettingRequest{Locale: common.DefaultLocale()})
+}
+
+// UpdateLocaleSetting 更新面向客户端错误信息的语言设置，立即生效
+func UpdateLocaleSetting(c *gin.Context) {
+	var req LocaleSettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	switch req.Locale {
+	case common.LocaleAuto, common.LocaleEN, common.LocaleZH:
+	default:
+		common.BadRequest(c, "Invalid locale")
+		return
+	}
+
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyAPIErrorLocale)).
+		Update(c.Request.Context(), "value", req.Locale); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	common.SetDefaultLocale(req.Locale)
+	common.Success(c, req)
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/gin-gonic/gin"
+)
+
+// 未匹配路由的处理方式
+const (
+	NotFoundModeSPA    = "spa"    // 非接口路径的 GET 请求一律返回前端入口页，由前端路由处理
+	NotFoundModeStrict = "strict" // 带扩展名的路径（如 /.env、/wp-login.php）直接返回 404，其余同 spa
+)
+
+var notFoundPage = []byte("<!doctype html><html><head><meta charset=\"utf-8\"><title>404 Not Found</title></head><body><h1>404 Not Found</h1></body></html>")
+
+// NormalizeNotFoundMode 规范化 404 处理方式，未知值按 spa 处理
+func NormalizeNotFoundMode(mode string) string {
+	if strings.EqualFold(strings.TrimSpace(mode), NotFoundModeStrict) {
+		return NotFoundModeStrict
+	}
+	return NotFoundModeSPA
+}
+
+// NoRouteHandler 未匹配路由的统一处理：basePath 下的 /api 与 /v1 返回 JSON 错误，
+// 其余 GET 请求按 mode 返回前端入口页 index，index 为空（不托管 WebUI）或不满足条件时返回 404 页面
+func NoRouteHandler(basePath, mode string, index []byte) gin.HandlerFunc {
+	indexETag := contentETag(index)
+	return func(c *gin.Context) {
+		reqPath, ok := strings.CutPrefix(c.Request.URL.Path, basePath)
+		if ok && reqPath == "" {
+			reqPath = "/"
+		}
+		if ok && strings.HasPrefix(reqPath, "/") {
+			if isAPIPath(reqPath) {
+				common.ErrorWithHttpStatus(c, http.StatusNotFound, http.StatusNotFound, "Route not found")
+				return
+			}
+			if index != nil && servesIndex(c.Request.Method, reqPath, mode) {
+				serveIndex(c, index, indexETag)
+				return
+			}
+		}
+		c.Data(http.StatusNotFound, "text/html; charset=utf-8", notFoundPage)
+	}
+}
+
+func isAPIPath(reqPath string) bool {
+	for _, prefix := range []string{"/api", "/v1"} {
+		if reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func servesIndex(method, reqPath, mode string) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	return mode != NotFoundModeStrict || path.Ext(reqPath) == ""
+}
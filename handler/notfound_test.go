@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNoRouteHandler(t *testing.T) {
+	index := []byte("<html>index</html>")
+	tests := []struct {
+		name       string
+		basePath   string
+		mode       string
+		index      []byte
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "api path returns json", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/api/unknown", wantStatus: http.StatusNotFound, wantBody: `"code":404`},
+		{name: "v1 post returns json", mode: NotFoundModeSPA, index: index, method: http.MethodPost, path: "/v1/unknown", wantStatus: http.StatusNotFound, wantBody: `"code":404`},
+		{name: "spa route serves index", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/providers", wantStatus: http.StatusOK, wantBody: "index"},
+		{name: "spa mode serves index for files", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/wp-login.php", wantStatus: http.StatusOK, wantBody: "index"},
+		{name: "strict mode rejects files", mode: NotFoundModeStrict, index: index, method: http.MethodGet, path: "/wp-login.php", wantStatus: http.StatusNotFound, wantBody: "404 Not Found"},
+		{name: "strict mode serves routes", mode: NotFoundModeStrict, index: index, method: http.MethodGet, path: "/logs", wantStatus: http.StatusOK, wantBody: "index"},
+		{name: "non-get is 404", mode: NotFoundModeSPA, index: index, method: http.MethodPost, path: "/providers", wantStatus: http.StatusNotFound, wantBody: "404 Not Found"},
+		{name: "no webui is 404", mode: NotFoundModeSPA, method: http.MethodGet, path: "/providers", wantStatus: http.StatusNotFound, wantBody: "404 Not Found"},
+		{name: "base path api returns json", basePath: "/llmio", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/llmio/api/unknown", wantStatus: http.StatusNotFound, wantBody: `"code":404`},
+		{name: "base path root serves index", basePath: "/llmio", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/llmio", wantStatus: http.StatusOK, wantBody: "index"},
+		{name: "outside base path is 404", basePath: "/llmio", mode: NotFoundModeSPA, index: index, method: http.MethodGet, path: "/llmiox/providers", wantStatus: http.StatusNotFound, wantBody: "404 Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.NoRoute(NoRouteHandler(tt.basePath, tt.mode, tt.index))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d body = %s, want %d containing %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWebUICacheHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	assets := fstest.MapFS{"index-abc123.js": &fstest.MapFile{Data: []byte("console.log(1)")}}
+	router := gin.New()
+	router.Group("/assets", AssetCache(assets)).StaticFS("/", http.FS(assets))
+	router.NoRoute(NoRouteHandler("", NotFoundModeSPA, []byte("<html>index</html>")))
+
+	tests := []struct {
+		name      string
+		path      string
+		wantCache string
+	}{
+		{name: "hashed asset", path: "/assets/index-abc123.js", wantCache: assetCacheControl},
+		{name: "index page", path: "/providers", wantCache: "no-cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			etag := w.Header().Get("ETag")
+			if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != tt.wantCache {
+				t.Fatalf("status = %d etag = %q cache = %q", w.Code, etag, w.Header().Get("Cache-Control"))
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("If-None-Match", etag)
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusNotModified {
+				t.Errorf("conditional status = %d, want 304", w.Code)
+			}
+		})
+	}
+}
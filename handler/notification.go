@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// NotificationRequest 通知渠道请求结构
+type NotificationRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	Type    string   `json:"type" binding:"required"` // generic, slack, telegram, feishu, dingtalk
+	URL     string   `json:"url" binding:"required"`
+	ChatID  string   `json:"chat_id"`
+	Events  []string `json:"events"` // provider_auto_disabled, health_check_failing, error_rate_spike，为空表示全部
+	Enabled bool     `json:"enabled"`
+}
+
+// NotificationSettingsRequest 错误率通知设置
+type NotificationSettingsRequest struct {
+	ErrorRateThreshold   float64 `json:"error_rate_threshold"`    // 百分比，0 表示不检测
+	ErrorRateMinRequests int     `json:"error_rate_min_requests"` // 5 分钟内至少多少个请求才参与检测
+}
+
+func (r NotificationRequest) notification() models.Notification {
+	return models.Notification{
+		Name:    r.Name,
+		Type:    r.Type,
+		URL:     r.URL,
+		ChatID:  r.ChatID,
+		Events:  r.Events,
+		Enabled: r.Enabled,
+	}
+}
+
+// GetNotifications 获取通知渠道列表
+func GetNotifications(c *gin.Context) {
+	notifications, err := gorm.G[models.Notification](models.DB).Order("id ASC").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query notifications: "+err.Error())
+		return
+	}
+	common.Success(c, notifications)
+}
+
+// CreateNotification 创建通知渠道
+func CreateNotification(c *gin.Context) {
+	var req NotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	notification := req.notification()
+	if err := service.ValidateNotification(notification); err != nil {
+		common.BadRequest(c, "Invalid notification: "+err.Error())
+		return
+	}
+	if err := gorm.G[models.Notification](models.DB).Create(c.Request.Context(), &notification); err != nil {
+		common.InternalServerError(c, "Failed to create notification: "+err.Error())
+		return
+	}
+	common.Success(c, notification)
+}
+
+// UpdateNotification 更新通知渠道
+func UpdateNotification(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req NotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	notification := req.notification()
+	if err := service.ValidateNotification(notification); err != nil {
+		common.BadRequest(c, "Invalid notification: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	// 指定列更新，避免 enabled 为 false、events 为空时被忽略
+	result := models.DB.WithContext(ctx).Model(&models.Notification{}).Where("id = ?", id).
+		Select("name", "type", "url", "chat_id", "events", "enabled").
+		Updates(&notification)
+	if result.Error != nil {
+		common.InternalServerError(c, "Failed to update notification: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, "Notification not found")
+		return
+	}
+
+	notification, err = gorm.G[models.Notification](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to retrieve notification: "+err.Error())
+		return
+	}
+	common.Success(c, notification)
+}
+
+// DeleteNotification 删除通知渠道
+func DeleteNotification(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	result, err := gorm.G[models.Notification](models.DB).Where("id = ?", id).Delete(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to delete notification: "+err.Error())
+		return
+	}
+	if result == 0 {
+		common.NotFound(c, "Notification not found")
+		return
+	}
+	common.Success(c, nil)
+}
+
+// TestNotification 向指定渠道同步发送一条测试消息，未启用的渠道同样发送
+func TestNotification(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	ctx := c.Request.Context()
+	notification, err := gorm.G[models.Notification](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.NotFound(c, "Notification not found")
+		return
+	}
+	event := service.NotificationEvent{
+		Event:   service.NotifyEventTest,
+		Title:   "Test notification",
+		Message: "This is a test notification from llmio channel " + notification.Name,
+	}
+	if err := service.SendNotification(ctx, notification, event); err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadGateway, http.StatusBadGateway, "Failed to send notification: "+err.Error())
+		return
+	}
+	common.Success(c, nil)
+}
+
+// GetNotificationSettings 获取错误率通知设置
+func GetNotificationSettings(c *gin.Context) {
+	threshold, minRequests := service.GetNotificationSettings(c.Request.Context())
+	common.Success(c, NotificationSettingsRequest{
+		ErrorRateThreshold:   threshold,
+		ErrorRateMinRequests: minRequests,
+	})
+}
+
+// UpdateNotificationSettings 更新错误率通知设置
+func UpdateNotificationSettings(c *gin.Context) {
+	var req NotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.ErrorRateThreshold < 0 || req.ErrorRateThreshold > 100 || req.ErrorRateMinRequests < 0 {
+		common.BadRequest(c, "Invalid notification settings")
+		return
+	}
+
+	ctx := c.Request.Context()
+	values := map[string]string{
+		models.SettingKeyNotifyErrorRateThreshold:   strconv.FormatFloat(req.ErrorRateThreshold, 'f', -1, 64),
+		models.SettingKeyNotifyErrorRateMinRequests: strconv.Itoa(req.ErrorRateMinRequests),
+	}
+	for key, value := range values {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", value); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+	common.Success(c, req)
+}
+
+// GetEmailSettings 获取邮件告警设置，不返回密码
+func GetEmailSettings(c *gin.Context) {
+	settings, err := service.GetEmailSettings(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to get settings: "+err.Error())
+		return
+	}
+	settings.Password = ""
+	common.Success(c, settings)
+}
+
+// UpdateEmailSettings 更新邮件告警设置，password 为空时保留原密码
+func UpdateEmailSettings(c *gin.Context) {
+	var req service.EmailSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if err := service.ValidateEmailSettings(req); err != nil {
+		common.BadRequest(c, "Invalid email settings: "+err.Error())
+		return
+	}
+	if err := service.SaveEmailSettings(c.Request.Context(), req); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	req.Password = ""
+	common.Success(c, req)
+}
+
+// TestEmail 按当前设置同步发送一封测试邮件，不受限流影响
+func TestEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+	settings, err := service.GetEmailSettings(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to get settings: "+err.Error())
+		return
+	}
+	if settings.Host == "" || len(settings.To) == 0 {
+		common.BadRequest(c, "Invalid email settings: smtp host and recipients are required")
+		return
+	}
+	event := service.NotificationEvent{
+		Event:   service.NotifyEventTest,
+		Title:   "Test notification",
+		Message: "This is a test email alert from llmio",
+	}
+	if err := service.SendEmailAlert(ctx, settings, event); err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadGateway, http.StatusBadGateway, "Failed to send email: "+err.Error())
+		return
+	}
+	common.Success(c, nil)
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionResponse 当前浏览器的登录状态
+type SessionResponse struct {
+	OIDCEnabled   bool       `json:"oidc_enabled"`
+	Authenticated bool       `json:"authenticated"`
+	Email         string     `json:"email,omitempty"`
+	Name          string     `json:"name,omitempty"`
+	ReadOnly      bool       `json:"read_only"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// OIDCLogin 跳转到 IdP 登录，?redirect= 为登录后返回的站内路径
+func OIDCLogin(base string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oidc := service.GetOIDCProvider()
+		if oidc == nil {
+			common.NotFound(c, "OIDC login is not enabled")
+			return
+		}
+		authURL, state := oidc.BeginLogin(loginRedirect(c.Query("redirect"), base))
+		setAuthCookie(c, oidc, base, service.OIDCStateCookie, state, int((10 * time.Minute).Seconds()))
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback 处理 IdP 回调：校验 state、换取并校验 ID Token，签发会话 cookie 后跳回 WebUI
+func OIDCCallback(base string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oidc := service.GetOIDCProvider()
+		if oidc == nil {
+			common.NotFound(c, "OIDC login is not enabled")
+			return
+		}
+		stateCookie, _ := c.Cookie(service.OIDCStateCookie)
+		setAuthCookie(c, oidc, base, service.OIDCStateCookie, "", -1)
+		if errCode := c.Query("error"); errCode != "" {
+			common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "OIDC login failed: "+errCode+" "+c.Query("error_description"))
+			return
+		}
+
+		session, cookie, redirect, err := oidc.FinishLogin(c.Request.Context(), stateCookie, c.Query("state"), c.Query("code"))
+		switch {
+		case errors.Is(err, service.ErrOIDCInvalidState):
+			common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Invalid or expired login state")
+			return
+		case errors.Is(err, service.ErrOIDCForbidden):
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "User is not allowed to access llmio")
+			return
+		case err != nil:
+			slog.Warn("oidc login failed", "error", err)
+			common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "OIDC login failed: "+err.Error())
+			return
+		}
+		slog.Info("oidc login", "sub", session.Subject, "email", session.Email, "read_only", session.ReadOnly)
+		setAuthCookie(c, oidc, base, service.OIDCSessionCookie, cookie, int(oidc.SessionTTL().Seconds()))
+		c.Redirect(http.StatusFound, redirect)
+	}
+}
+
+// Logout 清除 OIDC 会话 cookie
+func Logout(base string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if oidc := service.GetOIDCProvider(); oidc != nil {
+			setAuthCookie(c, oidc, base, service.OIDCSessionCookie, "", -1)
+		}
+		common.Success(c, nil)
+	}
+}
+
+// GetSession 获取当前浏览器的 OIDC 登录状态，供 WebUI 判断是否显示 SSO 登录
+func GetSession(c *gin.Context) {
+	oidc := service.GetOIDCProvider()
+	res := SessionResponse{OIDCEnabled: oidc != nil}
+	if oidc != nil {
+		if cookie, err := c.Cookie(service.OIDCSessionCookie); err == nil {
+			if session, err := oidc.ParseSession(cookie); err == nil {
+				expiresAt := time.Unix(session.ExpiresAt, 0)
+				res.Authenticated = true
+				res.Email = session.Email
+				res.Name = session.Name
+				res.ReadOnly = session.ReadOnly
+				res.ExpiresAt = &expiresAt
+			}
+		}
+	}
+	common.Success(c, res)
+}
+
+// loginRedirect 只允许跳回站内路径，避免开放重定向
+func loginRedirect(redirect, base string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return base + "/"
+	}
+	if base != "" && redirect != base && !strings.HasPrefix(redirect, base+"/") {
+		return base + redirect
+	}
+	return redirect
+}
+
+func setAuthCookie(c *gin.Context, oidc *service.OIDCProvider, base, name, value string, maxAge int) {
+	path := base
+	if path == "" {
+		path = "/"
+	}
+	// Lax 阻止跨站表单携带会话 cookie 发起修改请求
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, path, "", oidc.SecureCookies(), true)
+}
@@ -0,0 +1,505 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// openAPIOperation 接口文档元数据；未登记的路由仍会生成路径、路径参数与通用响应
+type openAPIOperation struct {
+	Summary  string
+	Query    []string
+	Request  any // JSON 请求体类型的零值
+	Response any // /api 接口为 data 字段的类型，/v1 接口为完整响应体
+	Form     []string
+}
+
+// openAPIOperations 以处理函数名登记接口说明与请求、响应类型
+var openAPIOperations = map[string]openAPIOperation{
+	// /v1 推理接口
+	"ModelsHandler":          {Summary: "List models available to the API key"},
+	"ChatCompletionsHandler": {Summary: "OpenAI chat completions"},
+	"CompletionsHandler":     {Summary: "OpenAI legacy completions"},
+	"ResponsesHandler":       {Summary: "OpenAI responses"},
+	"EmbeddingsHandler":      {Summary: "OpenAI embeddings"},
+	"RealtimeHandler":        {Summary: "OpenAI realtime WebSocket passthrough", Query: []string{"model"}},
+	"CreateFile":             {Summary: "Upload a file to a provider", Form: []string{"file", "purpose"}},
+	"ListFiles":              {Summary: "List uploaded files", Query: []string{"purpose", "after", "limit"}},
+	"GetFile":                {Summary: "Get an uploaded file"},
+	"GetFileContent":         {Summary: "Download an uploaded file's content"},
+	"DeleteFile":             {Summary: "Delete an uploaded file"},
+	"Messages":               {Summary: "Anthropic messages"},
+	"CountTokensHandler":     {Summary: "Count input tokens of an Anthropic messages request"},
+	"CreateMessageBatch":     {Summary: "Create an Anthropic message batch", Response: MessageBatchResponse{}},
+	"ListMessageBatches":     {Summary: "List message batches", Query: []string{"after_id", "limit"}},
+	"GetMessageBatch":        {Summary: "Get a message batch", Response: MessageBatchResponse{}},
+	"GetMessageBatchResults": {Summary: "Stream message batch results as JSONL"},
+	"CancelMessageBatch":     {Summary: "Cancel a message batch", Response: MessageBatchResponse{}},
+	"DeleteMessageBatch":     {Summary: "Delete a finished message batch"},
+
+	// 统计与会话
+	"Metrics":           {Summary: "Request and token metrics for the last N days", Response: MetricsRes{}},
+	"Counts":            {Summary: "Per-model request counts", Response: []Count{}},
+	"MetricsRollups":    {Summary: "Hourly or daily metrics rollups per model and provider", Query: []string{"days", "interval", "model", "provider"}, Response: []service.RollupPoint{}},
+	"SLOMetrics":        {Summary: "SLO compliance per model"},
+	"LatencyMetrics":    {Summary: "First-chunk and total latency percentiles per model and provider", Query: []string{"hours", "source", "model", "provider"}, Response: []service.LatencyMetric{}},
+	"ErrorMetrics":      {Summary: "Error counts and rates per error category and provider", Query: []string{"hours", "model", "provider"}, Response: service.ErrorBreakdown{}},
+	"SpendMetrics":      {Summary: "Spend per model and provider", Query: []string{"days"}},
+	"CacheMetrics":      {Summary: "Prompt cache hit rate per provider", Query: []string{"days"}, Response: []service.CacheMetric{}},
+	"FidelityMetrics":   {Summary: "Conversion fidelity issues per client and provider format", Response: FidelityMetricsResponse{}},
+	"ResetFidelity":     {Summary: "Reset conversion fidelity counters"},
+	"GetConversations":  {Summary: "Conversation analytics", Query: []string{"days", "min_turns", "limit", "sort"}, Response: []service.ConversationStat{}},
+	"GetConversation":   {Summary: "Turns of a conversation", Response: []service.ConversationTurn{}},
+	"Tokenize":          {Summary: "Tokenize text with a model's tokenizer", Request: TokenizeRequest{}, Response: TokenizeResponse{}},
+	"PlaygroundChat":    {Summary: "Send a playground request through the gateway", Query: []string{"style"}},
+	"GetSetupStatus":    {Summary: "First-run setup status", Response: SetupStatus{}},
+	"Setup":             {Summary: "Bootstrap provider, model, association and API key", Request: SetupRequest{}, Response: SetupResponse{}},
+	"StartReplay":       {Summary: "Start replaying logged traffic", Request: service.ReplayOptions{}},
+	"GetReplay":         {Summary: "Replay progress"},
+	"CancelReplay":      {Summary: "Cancel the running replay"},
+	"ImportBilling":     {Summary: "Import a provider billing CSV", Form: []string{"file", "provider"}},
+	"GetBillingRecords": {Summary: "Imported billing records", Query: []string{"month", "provider"}},
+	"ReconcileBilling":  {Summary: "Compare billing records with logged usage", Query: []string{"month", "provider", "tolerance"}},
+	"ApplyDesiredState": {Summary: "Sync providers, models and associations to a desired-state document", Query: []string{"dry_run"}, Request: service.DesiredState{}, Response: service.ApplyPlan{}},
+	"ExportConfig":      {Summary: "Export providers, models, associations and settings", Query: []string{"mask_secrets"}, Response: service.ConfigBundle{}},
+	"ImportConfig":      {Summary: "Sync configuration to an exported bundle", Query: []string{"dry_run"}, Request: service.ConfigBundle{}, Response: service.ApplyPlan{}},
+	"GetLeaderStatus":   {Summary: "Leader election status of this instance", Response: service.LeaderStatus{}},
+
+	// 供应商
+	"GetProviderTemplates":  {Summary: "Provider config templates", Response: []ProviderTemplate{}},
+	"GetProviders":          {Summary: "List providers", Query: []string{"name", "type"}, Response: []models.Provider{}},
+	"GetProviderModels":     {Summary: "List models offered by a provider", Query: []string{"source"}},
+	"CreateProvider":        {Summary: "Create a provider", Request: ProviderRequest{}, Response: models.Provider{}},
+	"UpdateProvider":        {Summary: "Update a provider", Request: ProviderRequest{}, Response: models.Provider{}},
+	"DeleteProvider":        {Summary: "Delete a provider and its associations"},
+	"OpenProviderIncident":  {Summary: "Acknowledge a provider incident", Request: IncidentRequest{}, Response: models.ProviderIncident{}},
+	"CloseProviderIncident": {Summary: "Close a provider incident"},
+	"GetIncidents":          {Summary: "List provider incidents", Query: []string{"open", "provider_id"}, Response: []models.ProviderIncident{}},
+
+	// 模型
+	"GetModels":            {Summary: "List models", Response: []models.Model{}},
+	"CreateModel":          {Summary: "Create a model", Request: ModelRequest{}, Response: models.Model{}},
+	"UpdateModel":          {Summary: "Update a model", Request: ModelRequest{}, Response: models.Model{}},
+	"BatchDeleteModels":    {Summary: "Delete models", Request: BatchDeleteModelsRequest{}},
+	"DeleteModel":          {Summary: "Delete a model"},
+	"GetModelFallbacks":    {Summary: "Get a model's fallback chain", Response: FallbackResponse{}},
+	"UpdateModelFallbacks": {Summary: "Set a model's fallback chain", Request: FallbackRequest{}, Response: FallbackResponse{}},
+	"DeleteModelFallbacks": {Summary: "Clear a model's fallback chain"},
+	"GetModelAliases":      {Summary: "Get a model's alias patterns", Response: AliasResponse{}},
+	"UpdateModelAliases":   {Summary: "Set a model's alias patterns", Request: AliasRequest{}, Response: AliasResponse{}},
+	"DeleteModelAliases":   {Summary: "Clear a model's alias patterns"},
+	"GetModelShadow":       {Summary: "Get a model's shadow traffic config and metrics", Query: []string{"hours"}, Response: ShadowResponse{}},
+	"UpdateModelShadow":    {Summary: "Mirror a share of a model's requests to a shadow association", Request: ShadowRequest{}, Response: ShadowResponse{}},
+	"DeleteModelShadow":    {Summary: "Stop mirroring shadow traffic"},
+
+	// 模型-供应商关联
+	"GetModelProviders":            {Summary: "List associations of a model", Query: []string{"model_id"}, Response: []models.ModelWithProvider{}},
+	"GetModelProviderStatus":       {Summary: "Recent request status of an association", Query: []string{"provider_id", "model_name", "provider_model"}, Response: []bool{}},
+	"GetModelProviderHealthStatus": {Summary: "Recent health check status of an association", Query: []string{"model_provider_id", "limit"}, Response: []bool{}},
+	"CreateModelProvider":          {Summary: "Create an association", Request: ModelWithProviderRequest{}, Response: models.ModelWithProvider{}},
+	"UpdateModelProvider":          {Summary: "Update an association", Request: ModelWithProviderRequest{}, Response: models.ModelWithProvider{}},
+	"UpdateModelProviderStatus":    {Summary: "Enable or disable an association", Request: ModelProviderStatusRequest{}},
+	"GetModelProviderDrains":       {Summary: "List association drains"},
+	"DrainModelProvider":           {Summary: "Drain an association", Request: DrainRequest{}},
+	"GetModelProviderDrain":        {Summary: "Drain progress of an association"},
+	"CancelModelProviderDrain":     {Summary: "Cancel an association drain"},
+	"ProbeModelProvider":           {Summary: "Probe association capabilities and fill in its capability flags", Request: ProbeRequest{}, Response: service.ProbeReport{}},
+	"BatchDeleteModelProviders":    {Summary: "Delete associations", Request: BatchDeleteModelProvidersRequest{}},
+	"DeleteModelProvider":          {Summary: "Delete an association"},
+
+	// 日志
+	"GetRequestLogs":        {Summary: "Query request logs", Query: []string{"page", "page_size", "name", "provider_name", "status", "style", "user_agent", "api_key_id", "response_hash", "header_failover", "request_id"}},
+	"GetChatIO":             {Summary: "Captured input and output of a request", Response: models.ChatIO{}},
+	"GetLogsByResponseHash": {Summary: "Logs with the given upstream response hash", Response: []models.ChatLog{}},
+	"GetDuplicateResponses": {Summary: "Upstream responses returned more than once", Query: []string{"days"}},
+	"BatchDeleteLogs":       {Summary: "Delete logs", Request: BatchDeleteLogsRequest{}},
+	"ClearAllLogs":          {Summary: "Delete all logs"},
+	"DeleteLog":             {Summary: "Delete a log"},
+	"GetUserAgents":         {Summary: "Distinct client user agents", Response: []string{}},
+
+	// User-Agent 归一化
+	"GetUserAgentRules":     {Summary: "List user agent rules", Response: []models.UserAgentRule{}},
+	"CreateUserAgentRule":   {Summary: "Create a user agent rule", Request: UserAgentRuleRequest{}, Response: models.UserAgentRule{}},
+	"UpdateUserAgentRule":   {Summary: "Update a user agent rule", Request: UserAgentRuleRequest{}, Response: models.UserAgentRule{}},
+	"DeleteUserAgentRule":   {Summary: "Delete a user agent rule"},
+	"StartUserAgentRelabel": {Summary: "Relabel logged user agents with the current rules"},
+	"GetUserAgentRelabel":   {Summary: "Relabel progress"},
+
+	// API Key、限流与隔离
+	"GetAPIKeys":                {Summary: "List API keys", Response: []models.APIKey{}},
+	"CreateAPIKey":              {Summary: "Create an API key", Request: APIKeyRequest{}, Response: CreateAPIKeyResponse{}},
+	"UpdateAPIKey":              {Summary: "Update an API key", Request: APIKeyRequest{}, Response: models.APIKey{}},
+	"DeleteAPIKey":              {Summary: "Delete an API key"},
+	"GetRateLimits":             {Summary: "Rate limits and current usage", Response: []RateLimitEntry{}},
+	"UpdateModelRateLimit":      {Summary: "Set a model's RPM and TPM", Request: RateLimitRequest{}},
+	"UpdateAPIKeyRateLimit":     {Summary: "Set an API key's RPM and TPM", Request: RateLimitRequest{}},
+	"UpdateRateLimitSettings":   {Summary: "Update rate limit settings", Request: RateLimitSettingsRequest{}},
+	"GetQuarantine":             {Summary: "Request quarantine settings and entries"},
+	"UpdateQuarantineSettings":  {Summary: "Update request quarantine settings", Request: QuarantineSettingsRequest{}},
+	"ReleaseQuarantine":         {Summary: "Release a quarantined request fingerprint"},
+	"GetAuthFailures":           {Summary: "Associations quarantined after upstream 401/403"},
+	"UpdateAuthFailureSettings": {Summary: "Update the key invalid webhook", Request: AuthFailureSettingsRequest{}},
+	"ClearAuthFailure":          {Summary: "Clear an association's key invalid quarantine"},
+	"GetRedaction":              {Summary: "Log redaction rules and built-in defaults"},
+	"UpdateRedaction":           {Summary: "Update log redaction rules", Request: RedactionSettingsRequest{}},
+
+	// 请求优先级与排队
+	"GetRequestQueue":            {Summary: "Request queue settings and per-priority counts"},
+	"UpdateRequestQueueSettings": {Summary: "Update request concurrency and queue settings", Request: service.RequestQueueSettings{}},
+
+	// 文件上传
+	"GetFilesSettings":    {Summary: "File upload settings", Response: service.FilesSettings{}},
+	"UpdateFilesSettings": {Summary: "Update file upload size limit and default provider", Request: service.FilesSettings{}},
+
+	// 定价、目录与用量
+	"GetPricings":   {Summary: "List association pricing", Response: []models.Pricing{}},
+	"UpsertPricing": {Summary: "Set an association's pricing", Request: PricingRequest{}, Response: models.Pricing{}},
+	"DeletePricing": {Summary: "Delete an association's pricing"},
+	"ImportCatalog": {Summary: "Import model metadata from a catalog", Request: CatalogImportRequest{}},
+	"GetUsage":      {Summary: "Usage statistics", Query: []string{"start", "end", "group_by", "api_key_id", "model", "provider_name"}},
+	"GetQuotas":     {Summary: "API key quotas and current usage"},
+	"UpdateQuota":   {Summary: "Set an API key's quota", Request: QuotaRequest{}},
+	"ResetQuota":    {Summary: "Reset an API key's usage for the current period"},
+
+	// 系统配置与设置
+	"GetSystemConfig":       {Summary: "Smart routing configuration"},
+	"UpdateSystemConfig":    {Summary: "Update smart routing configuration", Request: SystemConfigRequest{}},
+	"GetSettings":           {Summary: "Get settings", Response: SettingsResponse{}},
+	"UpdateSettings":        {Summary: "Update settings", Request: UpdateSettingsRequest{}, Response: SettingsResponse{}},
+	"ValidateSettings":      {Summary: "Validate settings without saving", Request: UpdateSettingsRequest{}, Response: service.SettingsValidation{}},
+	"ResetModelWeights":     {Summary: "Reset association weights to the default", Request: ResetModelWeightsRequest{}},
+	"ResetModelPriorities":  {Summary: "Reset association priorities to the default", Request: ResetModelPrioritiesRequest{}},
+	"EnableAllAssociations": {Summary: "Enable all associations", Request: EnableAllAssociationsRequest{}},
+	"GetLocaleSetting":      {Summary: "Get the API error locale"},
+	"UpdateLocaleSetting":   {Summary: "Set the API error locale", Request: LocaleSettingRequest{}},
+
+	// 权重建议与 SLO
+	"GetWeightSuggestions":   {Summary: "Suggested association weights", Query: []string{"days"}},
+	"ApplyWeightSuggestions": {Summary: "Apply suggested weights", Query: []string{"days"}},
+	"GetAdvisorSettings":     {Summary: "Weight advisor settings"},
+	"UpdateAdvisorSettings":  {Summary: "Update weight advisor settings", Request: AdvisorSettingsRequest{}},
+	"GetSLOSettings":         {Summary: "SLO alert settings"},
+	"UpdateSLOSettings":      {Summary: "Update SLO alert settings", Request: SLOSettingsRequest{}},
+
+	// 通知
+	"GetNotifications":           {Summary: "List notification channels", Response: []models.Notification{}},
+	"CreateNotification":         {Summary: "Create a notification channel", Request: NotificationRequest{}, Response: models.Notification{}},
+	"UpdateNotification":         {Summary: "Update a notification channel", Request: NotificationRequest{}, Response: models.Notification{}},
+	"DeleteNotification":         {Summary: "Delete a notification channel"},
+	"TestNotification":           {Summary: "Send a test message to a notification channel"},
+	"GetNotificationSettings":    {Summary: "Error rate notification settings", Response: NotificationSettingsRequest{}},
+	"UpdateNotificationSettings": {Summary: "Update error rate notification settings", Request: NotificationSettingsRequest{}},
+	"GetEmailSettings":           {Summary: "SMTP email alert settings (password omitted)", Response: service.EmailSettings{}},
+	"UpdateEmailSettings":        {Summary: "Update SMTP email alert settings; an empty password keeps the current one", Request: service.EmailSettings{}, Response: service.EmailSettings{}},
+	"TestEmail":                  {Summary: "Send a test email with the current SMTP settings"},
+
+	// 健康检测
+	"GetHealthCheckSettings":     {Summary: "Health check settings", Response: HealthCheckSettingsResponse{}},
+	"UpdateHealthCheckSettings":  {Summary: "Update health check settings", Request: UpdateHealthCheckSettingsRequest{}},
+	"GetHealthCheckLogs":         {Summary: "Query health check logs", Query: []string{"page", "page_size", "model_provider_id", "model_name", "provider_name", "status"}},
+	"ClearHealthCheckLogs":       {Summary: "Delete all health check logs"},
+	"RunHealthCheck":             {Summary: "Check an association now", Response: models.HealthCheckLog{}},
+	"RunHealthCheckAll":          {Summary: "Start a background batch checking all associations", Response: service.HealthCheckBatch{}},
+	"GetHealthCheckBatch":        {Summary: "Progress of the current or last health check batch", Response: service.HealthCheckBatch{}},
+	"GetProviderStatusPages":     {Summary: "Provider status page states"},
+	"RefreshProviderStatusPages": {Summary: "Poll provider status pages now"},
+	"UpdateStatusPageSettings":   {Summary: "Update status page polling settings", Request: StatusPageSettingsRequest{}},
+	"ProviderTestHandler":        {Summary: "Send a test request to an association"},
+	"TestReactHandler":           {Summary: "Run a tool-calling test against an association"},
+
+	"OpenAPISpec": {Summary: "This OpenAPI document"},
+}
+
+var openAPIEngines struct {
+	sync.RWMutex
+	base    string
+	engines []*gin.Engine
+}
+
+// RegisterOpenAPIEngines 登记需要写入文档的路由，推理接口与管理接口分开监听时两者都需要传入
+func RegisterOpenAPIEngines(base string, engines ...*gin.Engine) {
+	openAPIEngines.Lock()
+	defer openAPIEngines.Unlock()
+	openAPIEngines.base, openAPIEngines.engines = base, engines
+}
+
+// OpenAPISpec 输出管理 API 与 /v1 接口的 OpenAPI 3 文档，供外部工具生成客户端
+func OpenAPISpec(c *gin.Context) {
+	openAPIEngines.RLock()
+	base, engines := openAPIEngines.base, openAPIEngines.engines
+	openAPIEngines.RUnlock()
+
+	var routes gin.RoutesInfo
+	for _, engine := range engines {
+		routes = append(routes, engine.Routes()...)
+	}
+	common.SuccessRaw(c, BuildOpenAPISpec(base, routes))
+}
+
+// BuildOpenAPISpec 根据已注册的路由生成 OpenAPI 文档，只包含 /api 与 /v1 下的接口
+func BuildOpenAPISpec(base string, routes gin.RoutesInfo) map[string]any {
+	g := &openAPIGenerator{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+	seen := map[string]bool{}
+	for _, route := range routes {
+		p := strings.TrimPrefix(route.Path, base)
+		if !strings.HasPrefix(p, "/api/") && !strings.HasPrefix(p, "/v1/") {
+			continue
+		}
+		// 同一进程内推理接口与管理接口共用路由时避免重复
+		key := route.Method + " " + p
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		p, params := openAPIPath(p)
+		if paths[p] == nil {
+			paths[p] = map[string]any{}
+		}
+		paths[p][strings.ToLower(route.Method)] = g.operation(route.Method, p, handlerName(route.Handler), params)
+	}
+
+	servers := []map[string]string{{"url": base + "/"}}
+	if base == "" {
+		servers = []map[string]string{{"url": "/"}}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "llmio",
+			"description": "Management API (/api) and OpenAI / Anthropic compatible inference API (/v1) with llmio extensions.",
+			"version":     "1.0.0",
+		},
+		"servers": servers,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer", "description": "TOKEN, READONLY_TOKEN (GET under /api only) or an API key created under /api/keys (inference only)"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "x-api-key", "description": "Anthropic style key header, accepted by /v1/messages endpoints"},
+			},
+		},
+	}
+}
+
+// openAPIPath 将 gin 路径参数 :id / *path 转换为 {id}
+func openAPIPath(p string) (string, []string) {
+	segments := strings.Split(p, "/")
+	var params []string
+	for i, segment := range segments {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// handlerName 从 gin 记录的函数全名中取出处理函数名
+func handlerName(full string) string {
+	name := path.Base(full)
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+type openAPIGenerator struct {
+	schemas map[string]any
+}
+
+func (g *openAPIGenerator) operation(method, p, name string, params []string) map[string]any {
+	meta := openAPIOperations[name]
+	inference := strings.HasPrefix(p, "/v1/")
+	tag := "inference"
+	if !inference {
+		tag = strings.Split(strings.TrimPrefix(p, "/api/"), "/")[0]
+	}
+	op := map[string]any{
+		"operationId": strings.ToLower(method) + strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(p, "/", "_"), "{", ""), "}", ""),
+		"tags":        []string{tag},
+		"security":    []map[string][]string{{"bearer": {}}},
+	}
+	if meta.Summary != "" {
+		op["summary"] = meta.Summary
+	}
+	if name != "" {
+		op["x-llmio-handler"] = name
+	}
+
+	parameters := make([]map[string]any, 0, len(params)+len(meta.Query))
+	for _, param := range params {
+		parameters = append(parameters, map[string]any{"name": param, "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+	}
+	for _, param := range meta.Query {
+		parameters = append(parameters, map[string]any{"name": param, "in": "query", "schema": map[string]string{"type": "string"}})
+	}
+	if inference {
+		// llmio 扩展：会话粘滞与会话统计使用的会话标识
+		parameters = append(parameters, map[string]any{"name": "X-Session-ID", "in": "header", "description": "llmio extension: conversation identifier for sticky routing and conversation analytics", "schema": map[string]string{"type": "string"}})
+		if strings.HasPrefix(p, "/v1/messages") || p == "/v1/count_tokens" {
+			op["security"] = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+		}
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	switch {
+	case meta.Request != nil:
+		op["requestBody"] = map[string]any{"required": true, "content": map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(meta.Request))}}}
+	case len(meta.Form) > 0:
+		properties := map[string]any{}
+		for _, field := range meta.Form {
+			properties[field] = map[string]string{"type": "string"}
+		}
+		// 文件字段以二进制上传
+		if _, ok := properties["file"]; ok {
+			properties["file"] = map[string]string{"type": "string", "format": "binary"}
+		}
+		op["requestBody"] = map[string]any{"required": true, "content": map[string]any{"multipart/form-data": map[string]any{"schema": map[string]any{"type": "object", "properties": properties}}}}
+	case inference && (method == http.MethodPost):
+		op["requestBody"] = map[string]any{"required": true, "content": map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object", "description": "Request body of the upstream API this endpoint is compatible with"}}}}
+	}
+
+	op["responses"] = g.responses(meta, inference)
+	return op
+}
+
+func (g *openAPIGenerator) responses(meta openAPIOperation, inference bool) map[string]any {
+	var data map[string]any
+	if meta.Response != nil {
+		data = g.schema(reflect.TypeOf(meta.Response))
+	}
+	if inference {
+		ok := map[string]any{"description": "Upstream compatible response; streaming requests return text/event-stream"}
+		if data != nil {
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": data}}
+		}
+		return map[string]any{
+			"200": ok,
+			"429": map[string]any{"description": "Rate limited or quota exceeded; see Retry-After", "content": map[string]any{"application/json": map[string]any{"schema": g.envelope(nil)}}},
+			"500": map[string]any{"description": "All upstream providers failed", "content": map[string]any{"application/json": map[string]any{"schema": g.envelope(nil)}}},
+		}
+	}
+	return map[string]any{
+		"200": map[string]any{
+			"description": "Unified response; business errors are reported in code (400, 404) with HTTP 200",
+			"content":     map[string]any{"application/json": map[string]any{"schema": g.envelope(data)}},
+		},
+		"401": map[string]any{"description": "Missing or invalid admin token"},
+		"403": map[string]any{"description": "READONLY_TOKEN used for a mutation or an admin-only endpoint"},
+		"500": map[string]any{"description": "Internal error", "content": map[string]any{"application/json": map[string]any{"schema": g.envelope(nil)}}},
+	}
+}
+
+// envelope common.Response 包装的响应
+func (g *openAPIGenerator) envelope(data map[string]any) map[string]any {
+	if data == nil {
+		return g.schema(reflect.TypeOf(common.Response{}))
+	}
+	return map[string]any{"allOf": []any{
+		g.schema(reflect.TypeOf(common.Response{})),
+		map[string]any{"type": "object", "properties": map[string]any{"data": data}},
+	}}
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	deletedAtType   = reflect.TypeOf(gorm.DeletedAt{})
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schema 按 encoding/json 的规则反射生成 JSON Schema，具名结构体放入 components
+func (g *openAPIGenerator) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case deletedAtType:
+		return map[string]any{"type": "string", "format": "date-time", "nullable": true}
+	case rawMessageType:
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(jsonMarshalType) || reflect.PointerTo(t).Implements(jsonMarshalType) {
+			return map[string]any{}
+		}
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			// 先占位，避免自引用类型无限递归
+			g.schemas[name] = map[string]any{}
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object 展开结构体字段，匿名嵌入且无 json 标签的结构体字段提升到上层
+func (g *openAPIGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			ft := field.Type
+			if field.Anonymous && name == "" {
+				if ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					collect(ft)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schema(field.Type)
+		}
+	}
+	collect(t)
+	return map[string]any{"type": "object", "properties": properties}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	group := router.Group("/llmio")
+	group.POST("/v1/messages", Messages)
+	group.GET("/api/providers", GetProviders)
+	group.PUT("/api/providers/:id", UpdateProvider)
+	group.POST("/api/billing/import", ImportBilling)
+	group.GET("/api/openapi.json", OpenAPISpec)
+	router.GET("/health", func(c *gin.Context) {})
+	RegisterOpenAPIEngines("/llmio", router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/llmio/api/openapi.json", nil))
+	spec := w.Body.String()
+	if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) || gjson.Get(spec, "openapi").String() != "3.0.3" {
+		t.Fatalf("spec = %s", spec)
+	}
+	if gjson.Get(spec, "servers.0.url").String() != "/llmio/" || gjson.Get(spec, "paths./health").Exists() {
+		t.Fatalf("servers or paths = %s", spec)
+	}
+
+	update := gjson.Get(spec, `paths./api/providers/{id}.put`)
+	if update.Get("parameters.0.name").String() != "id" || update.Get("parameters.0.in").String() != "path" ||
+		update.Get("requestBody.content.application/json.schema.$ref").String() != "#/components/schemas/handler.ProviderRequest" ||
+		update.Get("tags.0").String() != "providers" || update.Get("x-llmio-handler").String() != "UpdateProvider" {
+		t.Fatalf("update provider = %s", update.Raw)
+	}
+	// 响应包装在 common.Response 的 data 中，模型的 gorm.Model 字段提升到上层
+	if update.Get("responses.200.content.application/json.schema.allOf.1.properties.data.$ref").String() != "#/components/schemas/models.Provider" {
+		t.Fatalf("update provider response = %s", update.Get("responses").Raw)
+	}
+	provider := gjson.Get(spec, "components.schemas.models\\.Provider.properties")
+	if provider.Get("ID.type").String() != "integer" || provider.Get("CreatedAt.format").String() != "date-time" || provider.Get("Config.type").String() != "string" {
+		t.Fatalf("provider schema = %s", provider.Raw)
+	}
+	if gjson.Get(spec, "components.schemas.handler\\.ProviderRequest.properties.image_max_bytes.type").String() != "integer" {
+		t.Fatalf("provider request schema = %s", gjson.Get(spec, "components.schemas").Raw)
+	}
+	if gjson.Get(spec, `paths./api/providers.get.parameters.#(name=="type").in`).String() != "query" {
+		t.Fatalf("query parameters = %s", gjson.Get(spec, "paths./api/providers.get").Raw)
+	}
+	if gjson.Get(spec, `paths./api/billing/import.post.requestBody.content.multipart/form-data.schema.properties.file.format`).String() != "binary" {
+		t.Fatalf("billing import = %s", gjson.Get(spec, "paths./api/billing/import").Raw)
+	}
+
+	messages := gjson.Get(spec, `paths./v1/messages.post`)
+	if messages.Get(`parameters.#(name=="X-Session-ID").in`).String() != "header" || messages.Get("security.#").Int() != 2 || !messages.Get("responses.429").Exists() {
+		t.Fatalf("messages = %s", messages.Raw)
+	}
+}
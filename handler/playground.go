@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"io"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// PlaygroundChat 管理面调试接口：使用管理员令牌鉴权，请求体与 /v1 对应接口一致，style 查询参数选择格式（默认 openai）
+// 直接复用推理链路，流式请求以 SSE 返回给 WebUI，浏览器侧无需持有推理令牌
+func PlaygroundChat(c *gin.Context) {
+	var (
+		pre  service.Beforer
+		post service.Processer
+	)
+	style := c.DefaultQuery("style", consts.StyleOpenAI)
+	switch style {
+	case consts.StyleOpenAI:
+		pre, post = service.BeforerOpenAI, service.ProcesserOpenAI
+	case consts.StyleOpenAIRes:
+		pre, post = service.BeforerOpenAIRes, service.ProcesserOpenAiRes
+	case consts.StyleAnthropic:
+		pre, post = service.BeforerAnthropic, service.ProcesserAnthropic
+	default:
+		common.BadRequest(c, "Invalid style")
+		return
+	}
+
+	reqBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	c.Request.Body.Close()
+	proxyChat(c, reqBody, pre, post, style, nil)
+}
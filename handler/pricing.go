@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PricingRequest 关联定价请求结构，单价为每百万 token
+type PricingRequest struct {
+	InputPrice  float64 `json:"input_price"`
+	OutputPrice float64 `json:"output_price"`
+	CachedPrice float64 `json:"cached_price"`
+}
+
+// GetPricings 获取所有关联的定价
+func GetPricings(c *gin.Context) {
+	pricings, err := gorm.G[models.Pricing](models.DB).Order("model_with_provider_id ASC").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query pricing: "+err.Error())
+		return
+	}
+	common.Success(c, pricings)
+}
+
+// UpsertPricing 设置模型-供应商关联的定价，已存在则覆盖
+func UpsertPricing(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req PricingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.InputPrice < 0 || req.OutputPrice < 0 || req.CachedPrice < 0 {
+		common.BadRequest(c, "Price must not be negative")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx); err != nil {
+		common.NotFound(c, "Model-provider association not found")
+		return
+	}
+
+	pricing := models.Pricing{
+		ModelWithProviderID: uint(id),
+		InputPrice:          req.InputPrice,
+		OutputPrice:         req.OutputPrice,
+		CachedPrice:         req.CachedPrice,
+	}
+	if err := models.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "model_with_provider_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"input_price", "output_price", "cached_price", "updated_at"}),
+	}).Create(&pricing).Error; err != nil {
+		common.InternalServerError(c, "Failed to update pricing: "+err.Error())
+		return
+	}
+
+	pricing, err = gorm.G[models.Pricing](models.DB).Where("model_with_provider_id = ?", id).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query pricing: "+err.Error())
+		return
+	}
+	common.Success(c, pricing)
+}
+
+// DeletePricing 删除关联的定价，之后的请求不再计算费用
+func DeletePricing(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	// 硬删除，便于之后重新创建同一关联的定价
+	result := models.DB.WithContext(c.Request.Context()).Unscoped().Where("model_with_provider_id = ?", id).Delete(&models.Pricing{})
+	if result.Error != nil {
+		common.InternalServerError(c, "Failed to delete pricing: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, "Pricing not found")
+		return
+	}
+	common.Success(c, nil)
+}
+
+// SpendMetrics 最近 days 天按天、供应商、模型汇总的花费
+func SpendMetrics(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	metrics, err := service.GetSpendMetrics(c.Request.Context(), days)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query spend: "+err.Error())
+		return
+	}
+	common.Success(c, metrics)
+}
+
+// CacheMetrics 最近 days 天按供应商汇总的提示缓存命中率
+func CacheMetrics(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 0 {
+		common.BadRequest(c, "Invalid days parameter")
+		return
+	}
+	metrics, err := service.GetCacheMetrics(c.Request.Context(), days)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query cache metrics: "+err.Error())
+		return
+	}
+	common.Success(c, metrics)
+}
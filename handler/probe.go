@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ProbeRequest 能力探测请求，probes 为空表示全部探测项，dry_run 时只报告结果不更新关联
+type ProbeRequest struct {
+	Probes            []string `json:"probes"`
+	DryRun            bool     `json:"dry_run"`
+	LongContextTokens int      `json:"long_context_tokens"`
+}
+
+// ProbeModelProvider 对关联执行能力探测，并按结果填写工具调用、结构化输出与视觉能力
+func ProbeModelProvider(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	var req ProbeRequest
+	// 请求体可选，未提供时执行全部探测项
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.BadRequest(c, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+	if err := service.ValidateProbeNames(req.Probes); err != nil {
+		common.BadRequest(c, "Invalid probes: "+err.Error())
+		return
+	}
+	if req.LongContextTokens < 0 {
+		common.BadRequest(c, "long_context_tokens must not be negative")
+		return
+	}
+
+	report, err := service.ProbeModelProvider(c.Request.Context(), uint(id), service.ProbeOptions{
+		Probes:            req.Probes,
+		Apply:             !req.DryRun,
+		LongContextTokens: req.LongContextTokens,
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFound(c, "Model-provider association not found")
+			return
+		}
+		common.InternalServerError(c, "Failed to probe model-provider association: "+err.Error())
+		return
+	}
+	common.Success(c, report)
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestUpdateProviderConfigResetsState(t *testing.T) {
+	testutil.SetupDB(t)
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-4o", "ok", 1, 1)))
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL)
+	association := testutil.SeedAssociation(t, testutil.SeedModel(t, "chat"), provider, "gpt-4o", 3, 1)
+	ctx := context.Background()
+	now := time.Now()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).Updates(ctx, models.ModelWithProvider{AuthFailedAt: &now, AuthFailedConfig: "stale"}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newTestRouter()
+	router.PUT("/api/providers/:id", UpdateProvider)
+	update := func(config string) {
+		body := `{"name":"openai","type":"openai","config":` + strconv.Quote(config) + `}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/providers/"+strconv.Itoa(int(provider.ID)), strings.NewReader(body)))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":200`) {
+			t.Fatalf("update provider = %s", w.Body.String())
+		}
+	}
+
+	// 仅格式不同的配置不触发重置
+	update(" " + provider.Config + " ")
+	if mp, _ := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).First(ctx); mp.Priority != 3 || mp.AuthFailedAt == nil {
+		t.Fatalf("association reset without config change: %+v", mp)
+	}
+
+	update(`{"base_url":"` + upstream.URL + `","api_key":"rotated"}`)
+	mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mp.Weight != 5 || mp.Priority != 100 || mp.AuthFailedAt != nil || mp.AuthFailedConfig != "" {
+		t.Fatalf("association after config change = %+v", mp)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		logs, err := gorm.G[models.HealthCheckLog](models.DB).Where("model_provider_id = ?", association.ID).Find(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) == 1 && logs[0].Status == "success" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health check logs = %+v", logs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := upstream.Requests(); len(got) != 1 || got[0].Header.Get("Authorization") != "Bearer rotated" {
+		t.Fatalf("health check requests = %+v", got)
+	}
+}
+
+func TestProviderKeysEncryptedAtRest(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, "http://127.0.0.1")
+	rawConfig := func() string {
+		var config string
+		if err := models.DB.Model(&models.Provider{}).Select("config").Where("id = ?", provider.ID).Scan(&config).Error; err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+	if !strings.Contains(rawConfig(), testutil.TestAPIKey) {
+		t.Fatalf("plaintext config before migration = %s", rawConfig())
+	}
+
+	t.Setenv(models.MasterKeyEnv, "test-master-key")
+	if count, err := models.EncryptProviderConfigs(ctx); err != nil || count != 1 {
+		t.Fatalf("encrypt = %d, %v", count, err)
+	}
+	if raw := rawConfig(); strings.Contains(raw, testutil.TestAPIKey) || !strings.Contains(raw, `"api_key":"enc:v1:`) {
+		t.Fatalf("stored config = %s", raw)
+	}
+	if count, err := models.EncryptProviderConfigs(ctx); err != nil || count != 0 {
+		t.Fatalf("second encrypt = %d, %v", count, err)
+	}
+
+	router := newTestRouter()
+	router.GET("/api/providers", GetProviders)
+	router.PUT("/api/providers/:id", UpdateProvider)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/providers", nil))
+	listed := gjson.Get(w.Body.String(), "data.0.Config").String()
+	if strings.Contains(listed, testutil.TestAPIKey) || gjson.Get(listed, "api_key").String() != "test****-key" {
+		t.Fatalf("listed config = %s", listed)
+	}
+
+	// 提交脱敏后的配置不会覆盖原密钥
+	body := `{"name":"openai","type":"openai","config":` + strconv.Quote(listed) + `}`
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/providers/"+strconv.Itoa(int(provider.ID)), strings.NewReader(body)))
+	if !strings.Contains(w.Body.String(), `"code":200`) {
+		t.Fatalf("update provider = %s", w.Body.String())
+	}
+	stored, err := gorm.G[models.Provider](models.DB).Where("id = ?", provider.ID).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.Get(stored.Config, "api_key").String() != testutil.TestAPIKey || strings.Contains(rawConfig(), testutil.TestAPIKey) {
+		t.Fatalf("config after update = %s, raw = %s", stored.Config, rawConfig())
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// QuarantineSettingsRequest 请求隔离设置
+type QuarantineSettingsRequest struct {
+	Threshold       int `json:"threshold"`        // 同一请求被所有供应商拒绝多少次后隔离，0 表示关闭
+	CooldownSeconds int `json:"cooldown_seconds"` // 隔离冷却时间（秒）
+}
+
+// GetQuarantine 获取请求隔离设置与当前的失败记录
+func GetQuarantine(c *gin.Context) {
+	threshold, cooldown := service.GetRequestQuarantineSettings(c.Request.Context())
+	common.Success(c, gin.H{
+		"threshold":        threshold,
+		"cooldown_seconds": int(cooldown.Seconds()),
+		"entries":          service.QuarantineEntries(),
+	})
+}
+
+// UpdateQuarantineSettings 更新请求隔离的阈值与冷却时间
+func UpdateQuarantineSettings(c *gin.Context) {
+	var req QuarantineSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Threshold < 0 || req.CooldownSeconds <= 0 {
+		common.BadRequest(c, "Invalid quarantine settings")
+		return
+	}
+
+	ctx := c.Request.Context()
+	for key, value := range map[string]int{
+		models.SettingKeyRequestQuarantineThreshold: req.Threshold,
+		models.SettingKeyRequestQuarantineCooldown:  req.CooldownSeconds,
+	} {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", strconv.Itoa(value)); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+	common.Success(c, req)
+}
+
+// ReleaseQuarantine 解除指定请求指纹的隔离
+func ReleaseQuarantine(c *gin.Context) {
+	if !service.ReleaseQuarantine(c.Param("fingerprint")) {
+		common.NotFound(c, "Quarantine entry not found")
+		return
+	}
+	common.Success(c, nil)
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RateLimitRequest 限流配置请求结构，0 表示不限制
+type RateLimitRequest struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+// RateLimitSettingsRequest 限流全局设置
+type RateLimitSettingsRequest struct {
+	SnapshotInterval int `json:"snapshot_interval"` // 秒，0 表示不持久化
+}
+
+// RateLimitEntry 已配置限流的模型或 API Key
+type RateLimitEntry struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	RPM  int    `json:"rpm"`
+	TPM  int    `json:"tpm"`
+}
+
+// ProviderTPMEntry 已配置 TPM 上限的供应商及最近一分钟的用量
+type ProviderTPMEntry struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	TPMLimit int    `json:"tpm_limit"`
+	Used     int    `json:"used"`
+}
+
+// GetRateLimits 获取已配置的限流及当前分钟窗口的用量
+func GetRateLimits(c *gin.Context) {
+	ctx := c.Request.Context()
+	modelList, err := gorm.G[models.Model](models.DB).Where("rpm > 0 OR tpm > 0").Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query rate limits: "+err.Error())
+		return
+	}
+	keyList, err := gorm.G[models.APIKey](models.DB).Where("rpm > 0 OR tpm > 0").Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query rate limits: "+err.Error())
+		return
+	}
+	providerList, err := gorm.G[models.Provider](models.DB).Where("tpm_limit > 0").Find(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to query rate limits: "+err.Error())
+		return
+	}
+
+	modelLimits := make([]RateLimitEntry, 0, len(modelList))
+	for _, model := range modelList {
+		modelLimits = append(modelLimits, RateLimitEntry{ID: model.ID, Name: model.Name, RPM: model.RPM, TPM: model.TPM})
+	}
+	keyLimits := make([]RateLimitEntry, 0, len(keyList))
+	for _, key := range keyList {
+		keyLimits = append(keyLimits, RateLimitEntry{ID: key.ID, Name: key.Label, RPM: key.RPM, TPM: key.TPM})
+	}
+	// 供应商 TPM 为本实例最近一分钟发送的用量，含进行中请求的估算预占
+	providerUsage := service.ProviderTPMUsage()
+	providerLimits := make([]ProviderTPMEntry, 0, len(providerList))
+	for _, provider := range providerList {
+		providerLimits = append(providerLimits, ProviderTPMEntry{ID: provider.ID, Name: provider.Name, TPMLimit: provider.TPMLimit, Used: providerUsage[provider.ID]})
+	}
+	common.Success(c, gin.H{
+		"snapshot_interval": service.GetRateLimitSnapshotInterval(ctx),
+		"models":            modelLimits,
+		"keys":              keyLimits,
+		"providers":         providerLimits,
+		"usage":             service.GetRateLimiter().Usage(),
+	})
+}
+
+// UpdateModelRateLimit 设置模型的 RPM / TPM
+func UpdateModelRateLimit(c *gin.Context) {
+	updateRateLimit[models.Model](c, "Model not found")
+}
+
+// UpdateAPIKeyRateLimit 设置 API Key 的 RPM / TPM
+func UpdateAPIKeyRateLimit(c *gin.Context) {
+	updateRateLimit[models.APIKey](c, "API key not found")
+}
+
+func updateRateLimit[T any](c *gin.Context, notFound string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req RateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.RPM < 0 || req.TPM < 0 {
+		common.BadRequest(c, "rpm and tpm must not be negative")
+		return
+	}
+
+	// 使用 map 更新，避免设置为 0（取消限流）时被忽略
+	result := models.DB.WithContext(c.Request.Context()).Model(new(T)).Where("id = ?", id).Updates(map[string]any{
+		"rpm": req.RPM,
+		"tpm": req.TPM,
+	})
+	if result.Error != nil {
+		common.InternalServerError(c, "Failed to update rate limit: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, notFound)
+		return
+	}
+	common.Success(c, req)
+}
+
+// UpdateRateLimitSettings 更新限流计数快照间隔
+func UpdateRateLimitSettings(c *gin.Context) {
+	var req RateLimitSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.SnapshotInterval < 0 {
+		common.BadRequest(c, "snapshot_interval must not be negative")
+		return
+	}
+
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyRateLimitSnapshotInterval)).
+		Update(c.Request.Context(), "value", strconv.Itoa(req.SnapshotInterval)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	common.Success(c, req)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestReadOnlyTokenRedactsWebhooks(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := t.Context()
+	const (
+		sloWebhook   = "https://hooks.example.com/slo/secret-path"
+		keyWebhook   = "https://hooks.example.com/keys/secret-path"
+		auditWebhook = "https://hooks.example.com/audit/secret-path"
+	)
+	for key, value := range map[string]string{
+		models.SettingKeySLOAlertWebhook:   sloWebhook,
+		models.SettingKeyKeyInvalidWebhook: keyWebhook,
+	} {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testutil.SeedModel(t, "chat", func(m *models.Model) { m.ToolAuditWebhook = auditWebhook })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api", middleware.AuthAdmin("admin", "viewer"))
+	api.GET("/slo/settings", GetSLOSettings)
+	api.GET("/auth-failures", GetAuthFailures)
+	api.GET("/models", GetModels)
+	api.GET("/config/export", ExportConfig)
+	api.GET("/providers/models/:id", middleware.AdminOnly, GetProviderModels)
+	do := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		path, field, webhook string
+	}{
+		{"/api/slo/settings", "data.alert_webhook", sloWebhook},
+		{"/api/auth-failures", "data.webhook", keyWebhook},
+		{"/api/models", "data.0.ToolAuditWebhook", auditWebhook},
+		{"/api/config/export", "data.models.0.tool_audit_webhook", auditWebhook},
+	}
+	for _, tt := range tests {
+		if got := gjson.Get(do(tt.path, "admin").Body.String(), tt.field).String(); got != tt.webhook {
+			t.Errorf("admin %s: %s = %q, want %q", tt.path, tt.field, got, tt.webhook)
+		}
+		body := do(tt.path, "viewer").Body.String()
+		if strings.Contains(body, "secret-path") || gjson.Get(body, tt.field).String() == "" {
+			t.Errorf("viewer %s: expected masked %s, got %s", tt.path, tt.field, body)
+		}
+	}
+
+	// 查询上游模型列表会使用供应商密钥请求上游，只读令牌不可访问
+	if w := do("/api/providers/models/1", "viewer"); w.Code != http.StatusForbidden {
+		t.Fatalf("viewer provider models: status = %d", w.Code)
+	}
+}
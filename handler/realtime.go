@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// RealtimeHandler OpenAI Realtime API 的 WebSocket 透传：按模型的权重与优先级选择支持 Realtime 的供应商，
+// 完成上游握手后再与客户端握手，会话结束时记录时长与 response.done 中的 token 用量
+func RealtimeHandler(c *gin.Context) {
+	key := c.GetHeader("Sec-WebSocket-Key")
+	if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") || key == "" {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "WebSocket upgrade required")
+		return
+	}
+	model := c.Query("model")
+	if model == "" {
+		common.ErrorWithHttpStatus(c, http.StatusBadRequest, http.StatusBadRequest, "model is empty")
+		return
+	}
+
+	var apiKeyID uint
+	rateLimit := service.RateLimitTarget{Model: model}
+	if apiKey := middleware.APIKeyFromContext(c); apiKey != nil {
+		if !apiKey.AllowsModel(model) {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Model not allowed for this API key")
+			return
+		}
+		if quotaErr := service.CheckQuota(apiKey); quotaErr != nil {
+			if quotaErr.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+			}
+			common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, quotaErr.Message())
+			return
+		}
+		apiKeyID = apiKey.ID
+		rateLimit.APIKeyID, rateLimit.KeyRPM, rateLimit.KeyTPM = apiKey.ID, apiKey.RPM, apiKey.TPM
+	}
+
+	ctx := c.Request.Context()
+	before := service.Before{Model: model, Stream: true}
+	providersWithMeta, err := service.ProvidersWithMetaBymodelsName(ctx, consts.StyleOpenAIRealtime, before)
+	if err != nil {
+		common.InternalServerError(c, err.Error())
+		return
+	}
+	rateLimit.ModelRPM, rateLimit.ModelTPM = providersWithMeta.RPM, providersWithMeta.TPM
+	if limitErr := service.GetRateLimiter().Allow(rateLimit); limitErr != nil {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter.Seconds()))))
+		common.ErrorWithHttpStatus(c, http.StatusTooManyRequests, http.StatusTooManyRequests, limitErr.Message())
+		return
+	}
+	providersWithMeta.LogLevel = service.ResolveLogLevel(providersWithMeta.LogLevel, middleware.APIKeyFromContext(c))
+
+	session, err := service.DialRealtime(ctx, before, *providersWithMeta, models.ReqMeta{
+		Header:    c.Request.Header,
+		RemoteIP:  c.ClientIP(),
+		UserAgent: service.NormalizeUserAgent(ctx, c.Request.UserAgent()),
+		APIKeyID:  apiKeyID,
+	}, rateLimit)
+	if err != nil {
+		common.ErrorWithHttpStatus(c, http.StatusBadGateway, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		slog.Error("hijack realtime connection error", "error", err)
+		session.Close()
+		return
+	}
+	handshake := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + service.WSAcceptKey(key) + "\r\n"
+	if session.Protocol != "" {
+		handshake += "Sec-WebSocket-Protocol: " + session.Protocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(handshake + "\r\n")); err != nil {
+		slog.Info("realtime client disconnected during handshake", "model", model, "error", err)
+	}
+	session.Relay(conn, rw.Reader)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/service"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+// writeTestFrame 写入一个文本帧，mask 为真时按客户端要求加掩码
+func writeTestFrame(t *testing.T, w io.Writer, payload string, mask bool) {
+	t.Helper()
+	head := []byte{0x81, byte(len(payload))}
+	if len(payload) >= 126 {
+		head = []byte{0x81, 126, 0, 0}
+		binary.BigEndian.PutUint16(head[2:], uint16(len(payload)))
+	}
+	data := []byte(payload)
+	if mask {
+		head[1] |= 0x80
+		key := []byte{1, 2, 3, 4}
+		head = append(head, key...)
+		for i := range data {
+			data[i] ^= key[i%4]
+		}
+	}
+	if _, err := w.Write(append(head, data...)); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+// readTestFrame 读取一个不带掩码的文本帧
+func readTestFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			t.Fatalf("read frame length: %v", err)
+		}
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("read frame payload: %v", err)
+	}
+	return string(payload)
+}
+
+func TestRealtimeHandler(t *testing.T) {
+	testutil.SetupDB(t)
+	failing := testutil.NewUpstream(t, testutil.JSON(http.StatusServiceUnavailable, `{"error":"busy"}`))
+	realtime := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("model") != "gpt-realtime" || r.Header.Get("Authorization") != "Bearer "+testutil.TestAPIKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+			service.WSAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		writeTestFrame(t, rw, `{"type":"session.created"}`, false)
+		rw.Flush()
+
+		// 客户端帧带掩码，先读出头部确认收到再返回用量
+		head := make([]byte, 6)
+		if _, err := io.ReadFull(rw, head); err != nil {
+			t.Errorf("read client frame: %v", err)
+			return
+		}
+		io.CopyN(io.Discard, rw, int64(head[1]&0x7f))
+		writeTestFrame(t, rw, `{"type":"response.done","response":{"usage":{"total_tokens":30,"input_tokens":20,"output_tokens":10,"input_token_details":{"cached_tokens":5,"audio_tokens":8}}}}`, false)
+		rw.Flush()
+	})
+
+	model := testutil.SeedModel(t, "realtime")
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "failing", consts.StyleOpenAI, failing.URL), "gpt-realtime", 100, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "unsupported", consts.StyleAnthropic, failing.URL), "claude", 100, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "realtime", consts.StyleOpenAI, realtime.URL), "gpt-realtime", 50, 1)
+
+	router := gin.New()
+	router.GET("/v1/realtime", RealtimeHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// 非升级请求直接拒绝
+	res, err := http.Get(server.URL + "/v1/realtime?model=realtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain GET status = %d, want 400", res.StatusCode)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET /v1/realtime?model=realtime HTTP/1.1\r\nHost: llmio\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+key+"\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	res, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != service.WSAcceptKey(key) {
+		t.Fatalf("handshake status = %d, accept = %q", res.StatusCode, res.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	if got := readTestFrame(t, reader); got != `{"type":"session.created"}` {
+		t.Fatalf("first event = %s", got)
+	}
+	writeTestFrame(t, conn, `{"type":"response.create"}`, true)
+	if got := readTestFrame(t, reader); !strings.Contains(got, "response.done") {
+		t.Fatalf("second event = %s", got)
+	}
+
+	logs := testutil.WaitForLogs(t, 2)
+	if len(logs) != 2 || logs[0].Status != "error" || logs[0].ProviderName != "failing" {
+		t.Fatalf("logs = %+v, want failed handshake first", logs)
+	}
+	session := logs[1]
+	if session.ProviderName != "realtime" || session.Style != consts.StyleOpenAIRealtime || session.TotalTokens != 30 ||
+		session.PromptTokens != 20 || session.CompletionTokens != 10 || session.PromptTokensDetails.CachedTokens != 5 {
+		t.Fatalf("session log = %+v", session)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RedactionSettingsRequest 日志脱敏设置
+type RedactionSettingsRequest struct {
+	Rules []models.RedactionRule `json:"rules"` // 为空表示不脱敏
+}
+
+// GetRedaction 获取日志脱敏规则与内置默认规则
+func GetRedaction(c *gin.Context) {
+	common.Success(c, gin.H{
+		"rules":    service.GetRedactionRules(c.Request.Context()),
+		"defaults": models.DefaultRedactionRules,
+	})
+}
+
+// UpdateRedaction 更新日志脱敏规则
+func UpdateRedaction(c *gin.Context) {
+	var req RedactionSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Rules == nil {
+		req.Rules = []models.RedactionRule{}
+	}
+	if err := service.ValidateRedactionRules(req.Rules); err != nil {
+		common.BadRequest(c, "Invalid redaction rules: "+err.Error())
+		return
+	}
+	value, err := json.Marshal(req.Rules)
+	if err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyLogRedactionRules)).Update(c.Request.Context(), "value", string(value)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	common.Success(c, req)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// StartReplay 启动流量回放：按压缩时间重放某天的请求日志到内置 mock 上游，用于容量评估与配置验证
+func StartReplay(c *gin.Context) {
+	var opts service.ReplayOptions
+	if err := c.ShouldBindJSON(&opts); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	report, err := service.StartReplay(c.Request.Context(), opts)
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	common.Success(c, report)
+}
+
+// GetReplay 获取当前或最近一次流量回放的报告
+func GetReplay(c *gin.Context) {
+	report, ok := service.GetReplay()
+	if !ok {
+		common.NotFound(c, "No replay has been started")
+		return
+	}
+	common.Success(c, report)
+}
+
+// CancelReplay 取消正在运行的流量回放
+func CancelReplay(c *gin.Context) {
+	if !service.CancelReplay() {
+		common.BadRequest(c, "No replay is running")
+		return
+	}
+	common.Success(c, nil)
+}
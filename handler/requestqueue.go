@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetRequestQueue 获取请求并发与排队设置及各优先级当前的进行中、排队中请求数
+func GetRequestQueue(c *gin.Context) {
+	common.Success(c, gin.H{
+		"settings": service.GetRequestQueueSettings(c.Request.Context()),
+		"status":   service.GetRequestScheduler().Status(),
+	})
+}
+
+// UpdateRequestQueueSettings 更新请求并发上限与排队超时
+func UpdateRequestQueueSettings(c *gin.Context) {
+	var req service.RequestQueueSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.MaxConcurrent < 0 || req.BatchMaxConcurrent < 0 || req.QueueTimeout < 0 {
+		common.BadRequest(c, "Invalid request queue settings")
+		return
+	}
+
+	ctx := c.Request.Context()
+	for key, value := range map[string]int{
+		models.SettingKeyRequestMaxConcurrent:      req.MaxConcurrent,
+		models.SettingKeyRequestBatchMaxConcurrent: req.BatchMaxConcurrent,
+		models.SettingKeyRequestQueueTimeout:       req.QueueTimeout,
+	} {
+		if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).Update(ctx, "value", strconv.Itoa(value)); err != nil {
+			common.InternalServerError(c, "Failed to update settings: "+err.Error())
+			return
+		}
+	}
+	// 上限调整后立即按新设置放行排队的请求，而不是等到下一个名额归还
+	service.GetRequestScheduler().UpdateSettings(req)
+	common.Success(c, req)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DuplicateResponse 摘要相同的一组成功响应
+type DuplicateResponse struct {
+	ResponseHash string  `json:"response_hash"`
+	ResponseSize int64   `json:"response_size"`
+	Count        int64   `json:"count"`
+	FirstLogID   uint    `json:"first_log_id"`
+	LastLogID    uint    `json:"last_log_id"`
+	TotalTokens  int64   `json:"total_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// EmptyResponseStats 没有任何输出 token 却记为成功的响应统计
+type EmptyResponseStats struct {
+	Count       int64   `json:"count"`
+	TotalTokens int64   `json:"total_tokens"`
+	Cost        float64 `json:"cost"`
+}
+
+// GetLogsByResponseHash 按上游响应摘要查询日志，用于向供应商举证实际返回的内容
+func GetLogsByResponseHash(c *gin.Context) {
+	logs, err := gorm.G[models.ChatLog](models.DB).
+		Where("response_hash = ?", c.Param("hash")).
+		Order("id DESC").
+		Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query logs: "+err.Error())
+		return
+	}
+	common.Success(c, logs)
+}
+
+// GetDuplicateResponses 统计最近 days 天（默认 7）内摘要重复的成功响应与空响应，用于发现被重复计费的结果
+func GetDuplicateResponses(c *gin.Context) {
+	days := 7
+	if daysStr := c.Query("days"); daysStr != "" {
+		parsed, err := strconv.Atoi(daysStr)
+		if err != nil || parsed < 1 {
+			common.BadRequest(c, "Invalid days parameter")
+			return
+		}
+		days = parsed
+	}
+	ctx := c.Request.Context()
+	since := time.Now().AddDate(0, 0, -days)
+
+	duplicates := make([]DuplicateResponse, 0)
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("response_hash, MAX(response_size) AS response_size, COUNT(*) AS count, MIN(id) AS first_log_id, MAX(id) AS last_log_id, SUM(total_tokens) AS total_tokens, SUM(cost) AS cost").
+		Where("created_at >= ? AND status = ? AND response_hash <> ''", since, "success").
+		Group("response_hash").
+		Having("COUNT(*) > 1").
+		Order("count DESC").
+		Scan(&duplicates).Error; err != nil {
+		common.InternalServerError(c, "Failed to query duplicate responses: "+err.Error())
+		return
+	}
+
+	var empty EmptyResponseStats
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(total_tokens), 0) AS total_tokens, COALESCE(SUM(cost), 0) AS cost").
+		Where("created_at >= ? AND status = ? AND completion_tokens = 0", since, "success").
+		Scan(&empty).Error; err != nil {
+		common.InternalServerError(c, "Failed to query empty responses: "+err.Error())
+		return
+	}
+
+	common.Success(c, gin.H{
+		"duplicates": duplicates,
+		"empty":      empty,
+	})
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateSettings 校验设置但不写入，返回错误与警告
+func ValidateSettings(c *gin.Context) {
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	service.NormalizeSettings(&req)
+	common.Success(c, service.ValidateSettings(req))
+}
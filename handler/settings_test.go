@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestValidateSettings(t *testing.T) {
+	testutil.SetupDB(t)
+	router := gin.New()
+	router.PUT("/api/settings", UpdateSettings)
+	router.POST("/api/settings/validate", ValidateSettings)
+	do := func(method, path, body string) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	const valid = `{"auto_weight_decay_default":100,"auto_weight_decay_step":1,"auto_success_increase":true,"auto_weight_increase_max":50,
+		"auto_priority_decay_default":100,"auto_priority_decay_step":1,"auto_priority_decay_threshold":90,"auto_priority_decay_disable_enabled":true,"log_retention_count":100}`
+	got := do(http.MethodPost, "/api/settings/validate", valid)
+	if !gjson.Get(got, "data.valid").Bool() || gjson.Get(got, "data.warnings.0.field").String() != "auto_weight_increase_max" {
+		t.Fatalf("validate valid payload = %s", got)
+	}
+
+	const invalid = `{"auto_weight_decay_default":100,"auto_weight_decay_step":1,"auto_weight_decay":true,
+		"auto_priority_decay":true,"auto_priority_decay_default":80,"auto_priority_decay_step":1,"auto_priority_decay_threshold":90,"auto_priority_decay_disable_enabled":true,"log_retention_count":100}`
+	got = do(http.MethodPost, "/api/settings/validate", invalid)
+	if gjson.Get(got, "data.valid").Bool() || gjson.Get(got, "data.errors.0.field").String() != "auto_priority_decay_threshold" {
+		t.Fatalf("validate invalid payload = %s", got)
+	}
+
+	// 校验失败时不写入任何设置
+	if got = do(http.MethodPut, "/api/settings", invalid); gjson.Get(got, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("update invalid payload = %s", got)
+	}
+	setting, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyAutoWeightDecay).First(context.Background())
+	if err != nil || setting.Value != "false" {
+		t.Fatalf("auto_weight_decay = %q, err = %v, want unchanged false", setting.Value, err)
+	}
+
+	if got = do(http.MethodPut, "/api/settings", valid); gjson.Get(got, "code").Int() != http.StatusOK || gjson.Get(got, "data.auto_weight_increase_max").Int() != 50 {
+		t.Fatalf("update valid payload = %s", got)
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	setupDefaultMaxRetry = 3
+	setupDefaultTimeOut  = 60
+)
+
+var errAlreadyConfigured = errors.New("instance is already configured")
+
+// SetupStatus 实例的初始化状态
+type SetupStatus struct {
+	Configured      bool     `json:"configured"`       // 已创建供应商与模型
+	TokenConfigured bool     `json:"token_configured"` // 已通过 TOKEN 环境变量设置主令牌
+	Providers       int64    `json:"providers"`
+	Models          int64    `json:"models"`
+	APIKeys         int64    `json:"api_keys"`
+	Pending         []string `json:"pending"` // 尚未完成的初始化步骤
+}
+
+// SetupAPIKeyRequest 初始化时创建的 API Key
+type SetupAPIKeyRequest struct {
+	Label         string   `json:"label"`
+	AllowedModels []string `json:"allowed_models"`
+}
+
+// SetupModelRequest 初始化时创建的模型
+type SetupModelRequest struct {
+	Name     string `json:"name"`
+	Remark   string `json:"remark"`
+	MaxRetry int    `json:"max_retry"` // 为 0 时使用默认值 3
+	TimeOut  int    `json:"time_out"`  // 为 0 时使用默认值 60 秒
+}
+
+// SetupRequest 首次初始化请求：第一个供应商、第一个模型及其关联，可选创建 API Key
+type SetupRequest struct {
+	APIKey        *SetupAPIKeyRequest `json:"api_key"`
+	Provider      ProviderRequest     `json:"provider"`
+	Model         SetupModelRequest   `json:"model"`
+	ProviderModel string              `json:"provider_model"` // 供应商侧模型名，为空时与模型名相同
+
+	ToolCall         bool `json:"tool_call"`
+	StructuredOutput bool `json:"structured_output"`
+	Image            bool `json:"image"`
+	PromptCache      bool `json:"prompt_cache"`
+}
+
+// SetupResponse 初始化结果，API Key 明文只在此返回一次
+type SetupResponse struct {
+	Provider    models.Provider          `json:"provider"`
+	Model       models.Model             `json:"model"`
+	Association models.ModelWithProvider `json:"association"`
+	APIKey      *CreateAPIKeyResponse    `json:"api_key,omitempty"`
+}
+
+// setupStatus 统计供应商、模型与 API Key 数量
+func setupStatus(ctx context.Context) (*SetupStatus, error) {
+	status := &SetupStatus{TokenConfigured: os.Getenv("TOKEN") != ""}
+	var err error
+	if status.Providers, err = gorm.G[models.Provider](models.DB).Count(ctx, "id"); err != nil {
+		return nil, err
+	}
+	if status.Models, err = gorm.G[models.Model](models.DB).Count(ctx, "id"); err != nil {
+		return nil, err
+	}
+	if status.APIKeys, err = gorm.G[models.APIKey](models.DB).Count(ctx, "id"); err != nil {
+		return nil, err
+	}
+	status.Configured = status.Providers > 0 && status.Models > 0
+
+	status.Pending = []string{}
+	if !status.TokenConfigured {
+		status.Pending = append(status.Pending, "token")
+	}
+	if status.Providers == 0 {
+		status.Pending = append(status.Pending, "provider")
+	}
+	if status.Models == 0 {
+		status.Pending = append(status.Pending, "model")
+	}
+	if !status.TokenConfigured && status.APIKeys == 0 {
+		status.Pending = append(status.Pending, "api_key")
+	}
+	return status, nil
+}
+
+// GetSetupStatus 查询实例是否尚未初始化，供自动化部署判断是否需要执行初始化
+func GetSetupStatus(c *gin.Context) {
+	status, err := setupStatus(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	common.Success(c, status)
+}
+
+// Setup 在一个事务中创建第一个供应商、模型、关联与可选的 API Key，仅允许在没有供应商和模型时调用
+func Setup(c *gin.Context) {
+	var req SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	req.Model.Name = strings.TrimSpace(req.Model.Name)
+	if req.Provider.Name == "" || req.Model.Name == "" {
+		common.BadRequest(c, "Provider name and model name are required")
+		return
+	}
+	if req.Model.MaxRetry < 0 || req.Model.TimeOut < 0 {
+		common.BadRequest(c, "Invalid model settings")
+		return
+	}
+	if req.Provider.ImageMaxDimension < 0 || req.Provider.ImageMaxBytes < 0 {
+		common.BadRequest(c, "Invalid image limits")
+		return
+	}
+	if req.Provider.TPMLimit < 0 {
+		common.BadRequest(c, "tpm_limit must not be negative")
+		return
+	}
+	// 提前校验供应商类型与配置，避免写入无法使用的供应商
+	if _, err := providers.New(req.Provider.Type, req.Provider.Config, req.Provider.Proxy); err != nil {
+		common.BadRequest(c, "Invalid provider config: "+err.Error())
+		return
+	}
+	if req.ProviderModel == "" {
+		req.ProviderModel = req.Model.Name
+	}
+	if req.Model.MaxRetry == 0 {
+		req.Model.MaxRetry = setupDefaultMaxRetry
+	}
+	if req.Model.TimeOut == 0 {
+		req.Model.TimeOut = setupDefaultTimeOut
+	}
+
+	ctx := c.Request.Context()
+	ioLog, enabled := false, true
+	response := SetupResponse{
+		Provider: models.Provider{
+			Name:    req.Provider.Name,
+			Type:    req.Provider.Type,
+			Config:  req.Provider.Config,
+			Console: req.Provider.Console,
+			Proxy:   req.Provider.Proxy,
+
+			StatusPage:     req.Provider.StatusPage,
+			StatusPagePath: req.Provider.StatusPagePath,
+
+			ImageMaxDimension: req.Provider.ImageMaxDimension,
+			ImageMaxBytes:     req.Provider.ImageMaxBytes,
+
+			TPMLimit: req.Provider.TPMLimit,
+		},
+		Model: models.Model{
+			Name:     req.Model.Name,
+			Remark:   req.Model.Remark,
+			MaxRetry: req.Model.MaxRetry,
+			TimeOut:  req.Model.TimeOut,
+			IOLog:    &ioLog,
+		},
+	}
+	if req.APIKey != nil {
+		plain, hash, prefix, err := service.GenerateAPIKey()
+		if err != nil {
+			common.InternalServerError(c, "Failed to run setup: "+err.Error())
+			return
+		}
+		label := req.APIKey.Label
+		if label == "" {
+			label = "admin"
+		}
+		response.APIKey = &CreateAPIKeyResponse{Key: plain, APIKey: models.APIKey{
+			Label:         label,
+			KeyHash:       hash,
+			KeyPrefix:     prefix,
+			AllowedModels: req.APIKey.AllowedModels,
+		}}
+	}
+
+	priority := getAutoPriorityDecayDefault(ctx)
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 在事务内检查，避免并发初始化重复创建
+		var providerCount, modelCount int64
+		if err := tx.Model(&models.Provider{}).Count(&providerCount).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Model{}).Count(&modelCount).Error; err != nil {
+			return err
+		}
+		if providerCount > 0 || modelCount > 0 {
+			return errAlreadyConfigured
+		}
+
+		if err := tx.Create(&response.Provider).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&response.Model).Error; err != nil {
+			return err
+		}
+		response.Association = models.ModelWithProvider{
+			ModelID:          response.Model.ID,
+			ProviderID:       response.Provider.ID,
+			ProviderModel:    req.ProviderModel,
+			ToolCall:         &req.ToolCall,
+			StructuredOutput: &req.StructuredOutput,
+			Image:            &req.Image,
+			PromptCache:      &req.PromptCache,
+			WithHeader:       new(bool),
+			CustomerHeaders:  map[string]string{},
+			Weight:           1,
+			Priority:         priority,
+			Status:           &enabled,
+		}
+		if err := tx.Create(&response.Association).Error; err != nil {
+			return err
+		}
+		if response.APIKey != nil {
+			return tx.Create(&response.APIKey.APIKey).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errAlreadyConfigured) {
+		common.BadRequest(c, "Instance is already configured")
+		return
+	}
+	if err != nil {
+		common.InternalServerError(c, "Failed to run setup: "+err.Error())
+		return
+	}
+	response.Provider = redactProviders(response.Provider)[0]
+	common.Success(c, response)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestSetup(t *testing.T) {
+	testutil.SetupDB(t)
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-4o-mini", "hello", 10, 5)))
+	router := newTestRouter()
+	router.GET("/api/setup", GetSetupStatus)
+	router.POST("/api/setup", Setup)
+	do := func(method, path, body string) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if got := do(http.MethodGet, "/api/setup", ""); gjson.Get(got, "data.configured").Bool() || gjson.Get(got, "data.pending.#").Int() != 4 {
+		t.Fatalf("initial status = %s", got)
+	}
+	if got := do(http.MethodPost, "/api/setup", `{"provider":{"name":"p","type":"unknown","config":"{}"},"model":{"name":"chat"}}`); gjson.Get(got, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("invalid provider type = %s", got)
+	}
+
+	body := `{"api_key":{},"provider":{"name":"openai","type":"openai","config":"{\"base_url\":\"` + upstream.URL + `\",\"api_key\":\"k\"}"},
+		"model":{"name":"chat"},"provider_model":"gpt-4o-mini"}`
+	got := do(http.MethodPost, "/api/setup", body)
+	if gjson.Get(got, "code").Int() != http.StatusOK || !strings.HasPrefix(gjson.Get(got, "data.api_key.key").String(), "sk-llmio-") ||
+		gjson.Get(got, "data.association.ProviderModel").String() != "gpt-4o-mini" {
+		t.Fatalf("setup = %s", got)
+	}
+
+	if got := do(http.MethodPost, "/api/setup", body); gjson.Get(got, "code").Int() != http.StatusBadRequest {
+		t.Fatalf("second setup = %s", got)
+	}
+	if got := do(http.MethodGet, "/api/setup", ""); !gjson.Get(got, "data.configured").Bool() || gjson.Get(got, "data.api_keys").Int() != 1 {
+		t.Fatalf("status after setup = %s", got)
+	}
+
+	// 初始化后的模型可直接用于推理
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"chat","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || gjson.Get(w.Body.String(), "choices.0.message.content").String() != "hello" {
+		t.Fatalf("chat after setup: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	testutil.WaitForLogs(t, 1)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ShadowRequest 影子流量设置请求结构
+type ShadowRequest struct {
+	ModelProviderID uint `json:"model_provider_id"` // 影子关联，必须属于该模型，可以是禁用或权重为 0 的关联
+	Percent         int  `json:"percent"`           // 镜像的请求百分比（1-100）
+}
+
+// ShadowResponse 模型的影子流量配置与最近的指标对比
+type ShadowResponse struct {
+	ID              uint                 `json:"id"`
+	Name            string               `json:"name"`
+	ModelProviderID uint                 `json:"model_provider_id"`
+	Percent         int                  `json:"percent"`
+	Stats           *service.ShadowStats `json:"stats,omitempty"`
+}
+
+// GetModelShadow 获取模型的影子流量配置，配置了影子关联时附带最近 hours 小时（默认 24）影子请求与主请求的指标对比
+func GetModelShadow(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours <= 0 {
+		common.BadRequest(c, "Invalid hours parameter")
+		return
+	}
+	response := shadowResponse(model)
+	if model.ShadowModelProviderID != 0 {
+		stats, err := service.GetShadowStats(c.Request.Context(), model.Name, model.ShadowModelProviderID, time.Now().Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			common.InternalServerError(c, "Failed to query shadow stats: "+err.Error())
+			return
+		}
+		response.Stats = stats
+	}
+	common.Success(c, response)
+}
+
+// UpdateModelShadow 设置模型的影子关联与镜像比例
+func UpdateModelShadow(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	var req ShadowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.Percent < 1 || req.Percent > 100 {
+		common.BadRequest(c, "Invalid shadow percent")
+		return
+	}
+
+	ctx := c.Request.Context()
+	count, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ? AND model_id = ?", req.ModelProviderID, model.ID).Count(ctx, "id")
+	if err != nil {
+		common.InternalServerError(c, "Database error: "+err.Error())
+		return
+	}
+	if count == 0 {
+		common.BadRequest(c, "Shadow association not found for model")
+		return
+	}
+
+	model.ShadowModelProviderID, model.ShadowPercent = req.ModelProviderID, req.Percent
+	if err := models.DB.WithContext(ctx).Model(&model).Select("shadow_model_provider_id", "shadow_percent").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update shadow: "+err.Error())
+		return
+	}
+	common.Success(c, shadowResponse(model))
+}
+
+// DeleteModelShadow 停止镜像影子流量，已记录的影子日志保留
+func DeleteModelShadow(c *gin.Context) {
+	model, ok := findModelByParam(c)
+	if !ok {
+		return
+	}
+	model.ShadowModelProviderID, model.ShadowPercent = 0, 0
+	if err := models.DB.WithContext(c.Request.Context()).Model(&model).Select("shadow_model_provider_id", "shadow_percent").Updates(&model).Error; err != nil {
+		common.InternalServerError(c, "Failed to update shadow: "+err.Error())
+		return
+	}
+	common.Success(c, shadowResponse(model))
+}
+
+func shadowResponse(model models.Model) ShadowResponse {
+	return ShadowResponse{ID: model.ID, Name: model.Name, ModelProviderID: model.ShadowModelProviderID, Percent: model.ShadowPercent}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/middleware"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SLOSettingsRequest SLO 告警设置
+type SLOSettingsRequest struct {
+	AlertWebhook      string  `json:"alert_webhook"`
+	BurnRateThreshold float64 `json:"burn_rate_threshold"`
+}
+
+// SLOMetrics 获取各模型 SLO 达标情况与错误预算燃烧率
+func SLOMetrics(c *gin.Context) {
+	statuses, err := service.GetSLOStatuses(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to compute slo: "+err.Error())
+		return
+	}
+	common.Success(c, statuses)
+}
+
+// GetSLOSettings 获取 SLO 告警设置
+func GetSLOSettings(c *gin.Context) {
+	webhook, threshold := service.GetSLOSettings(c.Request.Context())
+	if middleware.ReadOnly(c) {
+		webhook = models.RedactSecret(webhook)
+	}
+	common.Success(c, SLOSettingsRequest{
+		AlertWebhook:      webhook,
+		BurnRateThreshold: threshold,
+	})
+}
+
+// UpdateSLOSettings 更新 SLO 告警设置
+func UpdateSLOSettings(c *gin.Context) {
+	var req SLOSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.BurnRateThreshold <= 0 {
+		common.BadRequest(c, "burn_rate_threshold must be greater than 0")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeySLOAlertWebhook)).
+		Update(ctx, "value", req.AlertWebhook); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeySLOBurnRateThreshold)).
+		Update(ctx, "value", strconv.FormatFloat(req.BurnRateThreshold, 'f', -1, 64)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+
+	common.Success(c, req)
+}
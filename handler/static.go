@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// assetCacheControl 构建产物文件名带内容哈希，可长期缓存
+const assetCacheControl = "public, max-age=31536000, immutable"
+
+// contentETag 按内容计算强 ETag
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// AssetCache 为静态资源设置 ETag 与长期缓存头，ETag 在启动时按文件内容预先计算；
+// 条件请求由后续的文件服务根据 ETag 返回 304
+func AssetCache(fsys fs.FS) gin.HandlerFunc {
+	etags := make(map[string]string)
+	if err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		etags["/"+name] = contentETag(data)
+		return nil
+	}); err != nil {
+		slog.Error("failed to hash webui assets", "error", err)
+	}
+	return func(c *gin.Context) {
+		if etag, ok := etags["/"+strings.TrimPrefix(c.Param("filepath"), "/")]; ok {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", assetCacheControl)
+		}
+		c.Next()
+	}
+}
+
+// serveIndex 返回前端入口页，要求浏览器每次校验，保证升级后立即生效
+func serveIndex(c *gin.Context, index []byte, etag string) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StatusPageSettingsRequest 状态页轮询设置
+type StatusPageSettingsRequest struct {
+	PollInterval int `json:"poll_interval"` // 分钟，0 表示不轮询
+}
+
+// GetProviderStatusPages 获取各供应商状态页的最近一次轮询结果
+func GetProviderStatusPages(c *gin.Context) {
+	common.Success(c, gin.H{
+		"poll_interval": service.GetStatusPagePollInterval(c.Request.Context()),
+		"providers":     service.GetStatusPageMonitor().Statuses(),
+	})
+}
+
+// RefreshProviderStatusPages 立即轮询所有供应商状态页
+func RefreshProviderStatusPages(c *gin.Context) {
+	monitor := service.GetStatusPageMonitor()
+	if err := monitor.Poll(c.Request.Context()); err != nil {
+		common.InternalServerError(c, "Failed to poll status pages: "+err.Error())
+		return
+	}
+	common.Success(c, monitor.Statuses())
+}
+
+// UpdateStatusPageSettings 更新状态页轮询间隔
+func UpdateStatusPageSettings(c *gin.Context) {
+	var req StatusPageSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.PollInterval < 0 {
+		common.BadRequest(c, "poll_interval must not be negative")
+		return
+	}
+
+	if _, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyStatusPagePollInterval)).
+		Update(c.Request.Context(), "value", strconv.Itoa(req.PollInterval)); err != nil {
+		common.InternalServerError(c, "Failed to update settings: "+err.Error())
+		return
+	}
+	common.Success(c, req)
+}
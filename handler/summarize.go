@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// summarizeViaPipeline 经由 /v1/chat/completions 链路调用摘要模型，复用路由、重试与日志；
+// 摘要请求不绑定调用方的 API Key，避免受其模型白名单限制
+func summarizeViaPipeline(ctx context.Context, body []byte) (string, error) {
+	writer := &batchResponseWriter{header: http.Header{}}
+	c, _ := gin.CreateTestContext(writer)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "llmio-summarizer")
+	c.Request = req
+	ChatCompletionsHandler(c)
+
+	response := writer.body.Bytes()
+	if writer.status != 0 && writer.status != http.StatusOK {
+		return "", fmt.Errorf("status: %d, body: %s", writer.status, string(response))
+	}
+	if code := gjson.GetBytes(response, "code"); code.Exists() && code.Int() != http.StatusOK {
+		return "", errors.New(gjson.GetBytes(response, "message").String())
+	}
+	return gjson.GetBytes(response, "choices.0.message.content").String(), nil
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestSummarizeContext(t *testing.T) {
+	testutil.SetupDB(t)
+	cheap := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("mini", "user asked about go", 50, 5)))
+	main := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("big", "answer", 10, 5)))
+
+	ioLog := true
+	chat := testutil.SeedModel(t, "chat", func(m *models.Model) {
+		m.IOLog = &ioLog
+		m.SummarizeThreshold = 20
+		m.SummarizeModel = "cheap"
+		m.SummarizeKeep = 2
+	})
+	testutil.SeedAssociation(t, chat, testutil.SeedProvider(t, "main", consts.StyleOpenAI, main.URL), "big", 100, 1)
+	testutil.SeedAssociation(t, testutil.SeedModel(t, "cheap"), testutil.SeedProvider(t, "cheap", consts.StyleOpenAI, cheap.URL), "mini", 100, 1)
+
+	body := `{"model":"chat","messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":"tell me everything about the go programming language and its history"},
+		{"role":"assistant","content":"go was created at google in 2007 by griesemer, pike and thompson"},
+		{"role":"user","content":"and generics?"},
+		{"role":"assistant","content":"added in go 1.18"},
+		{"role":"user","content":"thanks"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || gjson.Get(w.Body.String(), "choices.0.message.content").String() != "answer" {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 摘要请求只包含较早的一问一答
+	cheapRequests := cheap.Requests()
+	if len(cheapRequests) != 1 || !strings.Contains(string(cheapRequests[0].Body), "history") || strings.Contains(string(cheapRequests[0].Body), "generics") {
+		t.Fatalf("summarize requests = %+v", cheapRequests)
+	}
+	sent := gjson.GetBytes(main.Requests()[0].Body, "messages")
+	if sent.Get("#").Int() != 5 || !strings.Contains(sent.Get("1.content").String(), "user asked about go") || sent.Get("2.content").String() != "and generics?" {
+		t.Fatalf("forwarded messages = %s", sent.Raw)
+	}
+
+	var mainLog models.ChatLog
+	for _, log := range testutil.WaitForLogs(t, 2) {
+		if log.Name == "chat" {
+			mainLog = log
+		}
+	}
+	chatIO := testutil.WaitForChatIO(t, mainLog.ID)
+	if chatIO == nil || chatIO.Summary == nil || chatIO.Summary.Messages != 2 || chatIO.Summary.Model != "cheap" ||
+		!strings.Contains(chatIO.Summary.Replaced, "griesemer") || chatIO.Summary.Summary != "user asked about go" {
+		t.Fatalf("chat io = %+v", chatIO)
+	}
+}
@@ -34,6 +34,15 @@ const (
         ]
     }`
 
+	testGemini = `{
+        "contents": [
+            {
+                "role": "user",
+                "parts": [{"text": "Write a one-sentence bedtime story about a unicorn."}]
+            }
+        ]
+    }`
+
 	testOpenAIRes = `{
         "model": "gpt-5-nano",
         "input": "Write a one-sentence bedtime story about a unicorn."
@@ -81,13 +90,15 @@ func ProviderTestHandler(c *gin.Context) {
 	slog.Info("Testing provider", "proxy", proxyURL, "provider", chatModel.Name)
 	client := providers.GetClientWithProxy(time.Second*time.Duration(60), proxyURL)
 	var testBody []byte
-	switch chatModel.Type {
+	switch providers.WireStyle(chatModel.Type) {
 	case consts.StyleOpenAI:
 		testBody = []byte(testOpenAI)
 	case consts.StyleAnthropic:
 		testBody = []byte(testAnthropic)
 	case consts.StyleOpenAIRes:
 		testBody = []byte(testOpenAIRes)
+	case consts.StyleGemini:
+		testBody = []byte(testGemini)
 	default:
 		common.BadRequest(c, "Invalid provider type")
 		return
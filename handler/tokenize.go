@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/service"
+	"github.com/atopos31/llmio/tokenizer"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenizeRequest 分词请求，text 与 body 二选一；body 为完整的聊天请求体
+type TokenizeRequest struct {
+	Model    string          `json:"model"`
+	Text     string          `json:"text"`
+	Encoding string          `json:"encoding"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// TokenizeResponse 分词结果
+type TokenizeResponse struct {
+	Model    string `json:"model"`
+	Encoding string `json:"encoding"`
+	Tokens   int    `json:"tokens"`
+}
+
+// Tokenize 统计文本或聊天请求体的 token 数
+func Tokenize(c *gin.Context) {
+	var req TokenizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if len(req.Body) > 0 {
+		tokens, err := service.EstimatePromptTokens(req.Model, req.Body)
+		if err != nil {
+			common.InternalServerError(c, "Failed to count tokens: "+err.Error())
+			return
+		}
+		common.Success(c, TokenizeResponse{
+			Model:    req.Model,
+			Encoding: tokenizer.EncodingForModel(req.Model),
+			Tokens:   tokens,
+		})
+		return
+	}
+
+	tokens, encoding, err := tokenizer.Count(req.Model, req.Encoding, req.Text)
+	if err != nil {
+		common.BadRequest(c, "Failed to count tokens: "+err.Error())
+		return
+	}
+	common.Success(c, TokenizeResponse{
+		Model:    req.Model,
+		Encoding: encoding,
+		Tokens:   tokens,
+	})
+}
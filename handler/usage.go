@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// QuotaRequest API Key 配额设置请求结构，0 表示不限制
+type QuotaRequest struct {
+	TokenQuota int64   `json:"token_quota"`
+	CostQuota  float64 `json:"cost_quota"`
+	Period     string  `json:"period"` // daily / monthly / 空
+}
+
+// GetUsage 查询按天聚合的用量，支持 start、end（2006-01-02）、api_key_id、model、provider_name 筛选与 group_by 分组
+func GetUsage(c *gin.Context) {
+	filter := service.UsageFilter{
+		Start:        c.Query("start"),
+		End:          c.Query("end"),
+		Model:        c.Query("model"),
+		ProviderName: c.Query("provider_name"),
+	}
+	for _, date := range []string{filter.Start, filter.End} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			common.BadRequest(c, "Invalid date format, expected YYYY-MM-DD")
+			return
+		}
+	}
+	if apiKeyID := c.Query("api_key_id"); apiKeyID != "" {
+		id, err := strconv.ParseUint(apiKeyID, 10, 64)
+		if err != nil {
+			common.BadRequest(c, "Invalid api_key_id")
+			return
+		}
+		filter.APIKeyID = uint(id)
+	}
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		filter.GroupBy = strings.Split(groupBy, ",")
+	}
+
+	records, total, err := service.QueryUsage(c.Request.Context(), filter)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid group_by") {
+			common.BadRequest(c, err.Error())
+			return
+		}
+		common.InternalServerError(c, "Failed to query usage: "+err.Error())
+		return
+	}
+	common.Success(c, gin.H{
+		"records": records,
+		"total":   total,
+	})
+}
+
+// GetQuotas 获取所有 API Key 的配额与当前周期已用量
+func GetQuotas(c *gin.Context) {
+	keys, err := gorm.G[models.APIKey](models.DB).Order("id DESC").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query api keys: "+err.Error())
+		return
+	}
+	common.Success(c, keys)
+}
+
+// UpdateQuota 设置 API Key 的 token / 费用配额与周期
+func UpdateQuota(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.TokenQuota < 0 || req.CostQuota < 0 {
+		common.BadRequest(c, "Quota must not be negative")
+		return
+	}
+	if !service.ValidQuotaPeriod(req.Period) {
+		common.BadRequest(c, "Invalid quota period")
+		return
+	}
+
+	ctx := c.Request.Context()
+	// 使用 map 更新，避免设置为 0（取消配额）时被忽略
+	result := models.DB.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
+		"token_quota":  req.TokenQuota,
+		"cost_quota":   req.CostQuota,
+		"quota_period": req.Period,
+	})
+	if result.Error != nil {
+		common.InternalServerError(c, "Failed to update quota: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, "API key not found")
+		return
+	}
+
+	key, err := gorm.G[models.APIKey](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to retrieve api key: "+err.Error())
+		return
+	}
+	common.Success(c, key)
+}
+
+// ResetQuota 清零 API Key 当前周期的已用量，用量统计记录保留
+func ResetQuota(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	rows, err := service.ResetQuota(c.Request.Context(), uint(id))
+	if err != nil {
+		common.InternalServerError(c, "Failed to reset quota: "+err.Error())
+		return
+	}
+	if rows == 0 {
+		common.NotFound(c, "API key not found")
+		return
+	}
+	common.Success(c, nil)
+}
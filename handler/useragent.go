@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UserAgentRuleRequest 用户代理归一化规则请求结构
+type UserAgentRuleRequest struct {
+	Pattern string `json:"pattern" binding:"required"`
+	Label   string `json:"label" binding:"required"`
+	Sort    int    `json:"sort"`
+}
+
+// GetUserAgentRules 获取用户代理归一化规则列表
+func GetUserAgentRules(c *gin.Context) {
+	rules, err := gorm.G[models.UserAgentRule](models.DB).Order("sort ASC, id ASC").Find(c.Request.Context())
+	if err != nil {
+		common.InternalServerError(c, "Failed to query user agent rules: "+err.Error())
+		return
+	}
+	common.Success(c, rules)
+}
+
+// CreateUserAgentRule 创建用户代理归一化规则
+func CreateUserAgentRule(c *gin.Context) {
+	var req UserAgentRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := regexp.Compile(req.Pattern); err != nil {
+		common.BadRequest(c, "Invalid pattern: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	rule := models.UserAgentRule{
+		Pattern: req.Pattern,
+		Label:   req.Label,
+		Sort:    req.Sort,
+	}
+	if err := gorm.G[models.UserAgentRule](models.DB).Create(ctx, &rule); err != nil {
+		common.InternalServerError(c, "Failed to create user agent rule: "+err.Error())
+		return
+	}
+	if err := service.ReloadUserAgentRules(ctx); err != nil {
+		common.InternalServerError(c, "Failed to reload user agent rules: "+err.Error())
+		return
+	}
+	common.Success(c, rule)
+}
+
+// UpdateUserAgentRule 更新用户代理归一化规则
+func UpdateUserAgentRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+	var req UserAgentRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if _, err := regexp.Compile(req.Pattern); err != nil {
+		common.BadRequest(c, "Invalid pattern: "+err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	// 使用 map 更新，避免 sort 为 0 时被忽略
+	result := models.DB.WithContext(ctx).Model(&models.UserAgentRule{}).Where("id = ?", id).Updates(map[string]any{
+		"pattern": req.Pattern,
+		"label":   req.Label,
+		"sort":    req.Sort,
+	})
+	if result.Error != nil {
+		common.InternalServerError(c, "Failed to update user agent rule: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFound(c, "User agent rule not found")
+		return
+	}
+	if err := service.ReloadUserAgentRules(ctx); err != nil {
+		common.InternalServerError(c, "Failed to reload user agent rules: "+err.Error())
+		return
+	}
+
+	rule, err := gorm.G[models.UserAgentRule](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to retrieve user agent rule: "+err.Error())
+		return
+	}
+	common.Success(c, rule)
+}
+
+// DeleteUserAgentRule 删除用户代理归一化规则
+func DeleteUserAgentRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		common.BadRequest(c, "Invalid ID format")
+		return
+	}
+
+	ctx := c.Request.Context()
+	result, err := gorm.G[models.UserAgentRule](models.DB).Where("id = ?", id).Delete(ctx)
+	if err != nil {
+		common.InternalServerError(c, "Failed to delete user agent rule: "+err.Error())
+		return
+	}
+	if result == 0 {
+		common.NotFound(c, "User agent rule not found")
+		return
+	}
+	if err := service.ReloadUserAgentRules(ctx); err != nil {
+		common.InternalServerError(c, "Failed to reload user agent rules: "+err.Error())
+		return
+	}
+	common.Success(c, nil)
+}
+
+// StartUserAgentRelabel 按当前规则在后台重新归一化历史日志中的用户代理
+func StartUserAgentRelabel(c *gin.Context) {
+	status, err := service.StartUserAgentRelabel(c.Request.Context())
+	if err != nil {
+		common.BadRequest(c, err.Error())
+		return
+	}
+	common.Success(c, status)
+}
+
+// GetUserAgentRelabel 获取重新归一化任务的进度
+func GetUserAgentRelabel(c *gin.Context) {
+	status, ok := service.GetUserAgentRelabel()
+	if !ok {
+		common.NotFound(c, "No relabel job has been started")
+		return
+	}
+	common.Success(c, status)
+}
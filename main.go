@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	_ "time/tzdata"
 
+	"github.com/atopos31/llmio/common"
 	"github.com/atopos31/llmio/handler"
 	"github.com/atopos31/llmio/middleware"
 	"github.com/atopos31/llmio/models"
@@ -21,19 +24,167 @@ import (
 )
 
 func init() {
+	// 默认输出 JSON 结构化日志，LOG_FORMAT=text 时输出文本
+	slog.SetDefault(slog.New(logHandler()))
 	ctx := context.Background()
 	models.Init(ctx, "./db/llmio.db")
+	// llmio encrypt-keys：使用 LLMIO_MASTER_KEY 加密已有的明文密钥后退出
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-keys" {
+		encryptKeys(ctx)
+	}
+	// 设置 REDIS_URL 时多个实例共享限流、会话粘滞、请求隔离与重试预算状态
+	if err := service.InitRedis(ctx); err != nil {
+		slog.Error("failed to init redis", "error", err)
+		os.Exit(1)
+	}
+	// 设置 OTEL_EXPORTER_OTLP_ENDPOINT 时导出链路追踪，配置无效时拒绝启动
+	if err := service.InitTracing(ctx); err != nil {
+		slog.Error("failed to init tracing", "error", err)
+		os.Exit(1)
+	}
+	// 设置 OIDC_ISSUER 时启用 OIDC 登录，发现文档读取失败时拒绝启动
+	if err := service.InitOIDC(ctx); err != nil {
+		slog.Error("failed to init oidc", "error", err)
+		os.Exit(1)
+	}
+	// 按 LLMIO_CONFIG（默认 llmio.yaml）声明的供应商、模型、关联与设置同步数据库
+	if err := service.ReconcileConfigFile(ctx); err != nil {
+		slog.Error("failed to reconcile config file", "error", err)
+		os.Exit(1)
+	}
 	slog.Info("TZ", "time.Local", time.Local.String())
+	common.SetDefaultLocale(service.GetAPIErrorLocale(ctx))
 
+	// 多实例共用数据库时，以下单例后台任务只在竞选成功的主节点上运行
+	leader := service.GetLeaderElector()
+	go leader.Start(ctx)
 	// 启动健康检测服务
-	go service.GetHealthChecker().Start(ctx)
+	go leader.RunAsLeader(ctx, "health-check", service.GetHealthChecker().Supervise)
+	// 启动 SLO 评估
+	go leader.RunAsLeader(ctx, "slo-monitor", service.GetSLOMonitor().Start)
+	// 启动请求指标的小时级汇总
+	go leader.RunAsLeader(ctx, "metrics-rollup", service.StartMetricsRollup)
+	// 为历史错误日志补充错误分类
+	go leader.RunAsLeader(ctx, "error-category-backfill", service.BackfillErrorCategories)
+	// 启动错误率通知评估
+	go leader.RunAsLeader(ctx, "error-rate-monitor", service.GetErrorRateMonitor().Start)
+	// 启动权重建议定时应用
+	go leader.RunAsLeader(ctx, "weight-advisor", service.StartWeightAdvisor)
+	// 启动供应商状态页轮询
+	go service.GetStatusPageMonitor().Start(ctx)
+	// 启动限流计数快照
+	go service.GetRateLimiter().Start(ctx)
+	// 继续执行重启前未完成的消息批处理，批处理不随主节点切换而取消
+	go leader.RunAsLeader(ctx, "resume-message-batches", func(context.Context) {
+		service.ResumeMessageBatches(ctx, handler.RunBatchItem)
+	})
 }
 
+// playgroundPath 调试接口需要流式输出，不经过 gzip 压缩
+const playgroundPath = "/api/playground/"
+
 func main() {
-	router := gin.Default()
+	router := newEngine()
+	// 所有路由、静态资源与 WebUI 入口页都挂在 BASE_PATH 之下
+	base := basePath()
+
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{base + "/v1/", base + playgroundPath})))
+
+	registerV1(router.Group(base))
+
+	// 设置 ADMIN_ADDR 后，管理 API 与 WebUI 单独监听，/v1 推理接口可对外暴露而管理面仅在内网可达
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		registerAPI(router.Group(base))
+		registerAuth(router.Group(base), base)
+		setwebui(router, base)
+		handler.RegisterOpenAPIEngines(base, router)
+	} else {
+		admin := newEngine()
+		admin.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{base + playgroundPath})))
+		registerAPI(admin.Group(base))
+		registerAuth(admin.Group(base), base)
+		setwebui(admin, base)
+		handler.RegisterOpenAPIEngines(base, router, admin)
+		router.NoRoute(handler.NoRouteHandler(base, notFoundMode(), nil))
+		go func() {
+			if err := runOn(admin, adminAddr); err != nil {
+				slog.Error("admin server exited", "addr", adminAddr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if err := runOn(router, listenAddr()); err != nil {
+		slog.Error("server exited", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newEngine 创建 gin 引擎，以请求 ID 与 slog 访问日志替代 gin 默认的文本日志，启用链路追踪时为每个请求创建根 span
+func newEngine() *gin.Engine {
+	engine := gin.New()
+	engine.Use(middleware.RequestID(), middleware.Tracing(), middleware.AccessLog(), gin.Recovery())
+	return engine
+}
+
+// logHandler 按 LOG_FORMAT 选择日志格式，json（默认）或 text
+func logHandler() slog.Handler {
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		return slog.NewTextHandler(os.Stderr, nil)
+	}
+	return slog.NewJSONHandler(os.Stderr, nil)
+}
+
+// encryptKeys 迁移命令：加密数据库中仍为明文的供应商密钥
+func encryptKeys(ctx context.Context) {
+	count, err := models.EncryptProviderConfigs(ctx)
+	if err != nil {
+		slog.Error("failed to encrypt provider keys", "encrypted", count, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("provider keys encrypted", "encrypted", count)
+	os.Exit(0)
+}
+
+// listenAddr 获取推理接口监听地址，LISTEN_ADDR 优先于 PORT
+func listenAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":7070"
+}
+
+// basePath 反向代理部署时的路径前缀，如 /llmio，通过 BASE_PATH 设置
+func basePath() string {
+	prefix := strings.TrimRight(strings.TrimSpace(os.Getenv("BASE_PATH")), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
+
+// notFoundMode 未匹配路由的处理方式，通过 NOT_FOUND_MODE 设置为 spa（默认）或 strict
+func notFoundMode() string {
+	return handler.NormalizeNotFoundMode(os.Getenv("NOT_FOUND_MODE"))
+}
 
-	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/v1/"})))
+// runOn 在 TCP 地址或 unix:/path/to.sock 形式的 unix socket 上启动服务
+func runOn(r *gin.Engine, addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// 清理上次异常退出残留的 socket 文件
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.RunUnix(path)
+	}
+	return r.Run(addr)
+}
 
+func registerV1(router gin.IRouter) {
 	authOpenAI := middleware.Auth(os.Getenv("TOKEN"))
 	authAnthropic := middleware.AuthAnthropic(os.Getenv("TOKEN"))
 
@@ -41,22 +192,80 @@ func main() {
 	v1.GET("/models", authOpenAI, handler.ModelsHandler)
 
 	v1.POST("/chat/completions", authOpenAI, handler.ChatCompletionsHandler)
+	v1.POST("/completions", authOpenAI, handler.CompletionsHandler)
 	v1.POST("/responses", authOpenAI, handler.ResponsesHandler)
+	v1.POST("/embeddings", authOpenAI, handler.EmbeddingsHandler)
+	v1.GET("/realtime", authOpenAI, handler.RealtimeHandler)
+	v1.POST("/files", authOpenAI, handler.CreateFile)
+	v1.GET("/files", authOpenAI, handler.ListFiles)
+	v1.GET("/files/:id", authOpenAI, handler.GetFile)
+	v1.GET("/files/:id/content", authOpenAI, handler.GetFileContent)
+	v1.DELETE("/files/:id", authOpenAI, handler.DeleteFile)
 	v1.POST("/messages", authAnthropic, handler.Messages)
-	// TODO
-	v1.POST("/count_tokens", authAnthropic)
+	v1.POST("/count_tokens", authAnthropic, handler.CountTokensHandler)
+	v1.POST("/messages/count_tokens", authAnthropic, handler.CountTokensHandler)
+	v1.POST("/messages/batches", authAnthropic, handler.CreateMessageBatch)
+	v1.GET("/messages/batches", authAnthropic, handler.ListMessageBatches)
+	v1.GET("/messages/batches/:id", authAnthropic, handler.GetMessageBatch)
+	v1.GET("/messages/batches/:id/results", authAnthropic, handler.GetMessageBatchResults)
+	v1.POST("/messages/batches/:id/cancel", authAnthropic, handler.CancelMessageBatch)
+	v1.DELETE("/messages/batches/:id", authAnthropic, handler.DeleteMessageBatch)
+}
 
+// registerAuth OIDC 登录接口，不经过管理接口认证
+func registerAuth(router gin.IRouter, base string) {
+	auth := router.Group("/auth")
+	auth.GET("/oidc/login", handler.OIDCLogin(base))
+	auth.GET("/oidc/callback", handler.OIDCCallback(base))
+	auth.POST("/logout", handler.Logout(base))
+	auth.GET("/session", handler.GetSession)
+}
+
+func registerAPI(router gin.IRouter) {
 	api := router.Group("/api")
-	api.Use(middleware.Auth(os.Getenv("TOKEN")))
+	api.Use(middleware.AuthAdmin(os.Getenv("TOKEN"), os.Getenv("READONLY_TOKEN")))
 	api.GET("/metrics/use/:days", handler.Metrics)
 	api.GET("/metrics/counts", handler.Counts)
+	api.GET("/metrics/rollups", handler.MetricsRollups)
+	api.GET("/metrics/slo", handler.SLOMetrics)
+	api.GET("/metrics/latency", handler.LatencyMetrics)
+	api.GET("/metrics/errors", handler.ErrorMetrics)
+	api.GET("/metrics/spend", handler.SpendMetrics)
+	api.GET("/metrics/cache", handler.CacheMetrics)
+	api.GET("/metrics/fidelity", handler.FidelityMetrics)
+	api.DELETE("/metrics/fidelity", handler.ResetFidelity)
+	api.GET("/conversations", handler.GetConversations)
+	api.GET("/conversations/:id", handler.GetConversation)
+	api.POST("/tokenize", handler.Tokenize)
+	api.POST("/playground/chat", handler.PlaygroundChat)
+	// First-run setup
+	api.GET("/setup", handler.GetSetupStatus)
+	api.POST("/setup", handler.Setup)
+	// Traffic replay
+	api.POST("/replay", handler.StartReplay)
+	api.GET("/replay", handler.GetReplay)
+	api.DELETE("/replay", handler.CancelReplay)
+	// Billing reconciliation
+	api.POST("/billing/import", handler.ImportBilling)
+	api.GET("/billing/records", handler.GetBillingRecords)
+	api.GET("/billing/reconcile", handler.ReconcileBilling)
+	// Declarative apply
+	api.POST("/apply", handler.ApplyDesiredState)
+	// Config export / import
+	api.GET("/config/export", handler.ExportConfig)
+	api.POST("/config/import", handler.ImportConfig)
+	// Leader election
+	api.GET("/leader", handler.GetLeaderStatus)
 	// Provider management
 	api.GET("/providers/template", handler.GetProviderTemplates)
 	api.GET("/providers", handler.GetProviders)
-	api.GET("/providers/models/:id", handler.GetProviderModels)
+	api.GET("/providers/models/:id", middleware.AdminOnly, handler.GetProviderModels)
 	api.POST("/providers", handler.CreateProvider)
 	api.PUT("/providers/:id", handler.UpdateProvider)
 	api.DELETE("/providers/:id", handler.DeleteProvider)
+	api.POST("/providers/:id/incident", handler.OpenProviderIncident)
+	api.DELETE("/providers/:id/incident", handler.CloseProviderIncident)
+	api.GET("/incidents", handler.GetIncidents)
 
 	// Model management
 	api.GET("/models", handler.GetModels)
@@ -64,6 +273,15 @@ func main() {
 	api.PUT("/models/:id", handler.UpdateModel)
 	api.DELETE("/models/batch", handler.BatchDeleteModels)
 	api.DELETE("/models/:id", handler.DeleteModel)
+	api.GET("/models/:id/fallbacks", handler.GetModelFallbacks)
+	api.PUT("/models/:id/fallbacks", handler.UpdateModelFallbacks)
+	api.DELETE("/models/:id/fallbacks", handler.DeleteModelFallbacks)
+	api.GET("/models/:id/aliases", handler.GetModelAliases)
+	api.PUT("/models/:id/aliases", handler.UpdateModelAliases)
+	api.DELETE("/models/:id/aliases", handler.DeleteModelAliases)
+	api.GET("/models/:id/shadow", handler.GetModelShadow)
+	api.PUT("/models/:id/shadow", handler.UpdateModelShadow)
+	api.DELETE("/models/:id/shadow", handler.DeleteModelShadow)
 
 	// Model-provider association management
 	api.GET("/model-providers", handler.GetModelProviders)
@@ -72,17 +290,80 @@ func main() {
 	api.POST("/model-providers", handler.CreateModelProvider)
 	api.PUT("/model-providers/:id", handler.UpdateModelProvider)
 	api.PATCH("/model-providers/:id/status", handler.UpdateModelProviderStatus)
+	api.GET("/model-providers/drains", handler.GetModelProviderDrains)
+	api.POST("/model-providers/:id/drain", handler.DrainModelProvider)
+	api.GET("/model-providers/:id/drain", handler.GetModelProviderDrain)
+	api.DELETE("/model-providers/:id/drain", handler.CancelModelProviderDrain)
+	api.POST("/model-providers/:id/probe", handler.ProbeModelProvider)
 	api.DELETE("/model-providers/batch", handler.BatchDeleteModelProviders)
 	api.DELETE("/model-providers/:id", handler.DeleteModelProvider)
 
 	// System status and monitoring
 	api.GET("/logs", handler.GetRequestLogs)
 	api.GET("/logs/:id/chat-io", handler.GetChatIO)
+	api.GET("/logs/hash/:hash", handler.GetLogsByResponseHash)
+	api.GET("/logs/duplicates", handler.GetDuplicateResponses)
 	api.DELETE("/logs/batch", handler.BatchDeleteLogs)
 	api.DELETE("/logs/clear", handler.ClearAllLogs)
 	api.DELETE("/logs/:id", handler.DeleteLog)
 	api.GET("/user-agents", handler.GetUserAgents)
 
+	// User agent normalization
+	api.GET("/user-agent-rules", handler.GetUserAgentRules)
+	api.POST("/user-agent-rules", handler.CreateUserAgentRule)
+	api.PUT("/user-agent-rules/:id", handler.UpdateUserAgentRule)
+	api.DELETE("/user-agent-rules/:id", handler.DeleteUserAgentRule)
+	api.POST("/user-agent-rules/relabel", handler.StartUserAgentRelabel)
+	api.GET("/user-agent-rules/relabel", handler.GetUserAgentRelabel)
+
+	// API key management
+	api.GET("/keys", handler.GetAPIKeys)
+	api.POST("/keys", handler.CreateAPIKey)
+	api.PUT("/keys/:id", handler.UpdateAPIKey)
+	api.DELETE("/keys/:id", handler.DeleteAPIKey)
+
+	// Rate limiting
+	api.GET("/rate-limits", handler.GetRateLimits)
+	api.PUT("/rate-limits/models/:id", handler.UpdateModelRateLimit)
+	api.PUT("/rate-limits/keys/:id", handler.UpdateAPIKeyRateLimit)
+	api.PUT("/rate-limits/settings", handler.UpdateRateLimitSettings)
+
+	// Request priority queue
+	api.GET("/request-queue", handler.GetRequestQueue)
+	api.PUT("/request-queue/settings", handler.UpdateRequestQueueSettings)
+
+	// File uploads
+	api.GET("/files/settings", handler.GetFilesSettings)
+	api.PUT("/files/settings", handler.UpdateFilesSettings)
+
+	// Request quarantine
+	api.GET("/quarantine", handler.GetQuarantine)
+	api.PUT("/quarantine/settings", handler.UpdateQuarantineSettings)
+	api.DELETE("/quarantine/:fingerprint", handler.ReleaseQuarantine)
+
+	// Auth failures
+	api.GET("/auth-failures", handler.GetAuthFailures)
+	api.PUT("/auth-failures/settings", handler.UpdateAuthFailureSettings)
+	api.DELETE("/auth-failures/:id", handler.ClearAuthFailure)
+
+	// Log redaction
+	api.GET("/redaction", handler.GetRedaction)
+	api.PUT("/redaction", handler.UpdateRedaction)
+
+	// Pricing
+	api.GET("/pricing", handler.GetPricings)
+	api.PUT("/pricing/:id", handler.UpsertPricing)
+	api.DELETE("/pricing/:id", handler.DeletePricing)
+
+	// Model catalog import
+	api.POST("/catalog/import", handler.ImportCatalog)
+
+	// Usage accounting and quotas
+	api.GET("/usage", handler.GetUsage)
+	api.GET("/usage/quotas", handler.GetQuotas)
+	api.PUT("/usage/quotas/:id", handler.UpdateQuota)
+	api.POST("/usage/quotas/:id/reset", handler.ResetQuota)
+
 	// System configuration
 	api.GET("/config", handler.GetSystemConfig)
 	api.PUT("/config", handler.UpdateSystemConfig)
@@ -90,9 +371,34 @@ func main() {
 	// Settings
 	api.GET("/settings", handler.GetSettings)
 	api.PUT("/settings", handler.UpdateSettings)
+	api.POST("/settings/validate", handler.ValidateSettings)
 	api.POST("/settings/reset-weights", handler.ResetModelWeights)
 	api.POST("/settings/reset-priorities", handler.ResetModelPriorities)
 	api.POST("/settings/enable-all-associations", handler.EnableAllAssociations)
+	api.GET("/settings/locale", handler.GetLocaleSetting)
+	api.PUT("/settings/locale", handler.UpdateLocaleSetting)
+
+	// Weight advisor
+	api.GET("/advisor/weights", handler.GetWeightSuggestions)
+	api.POST("/advisor/weights/apply", handler.ApplyWeightSuggestions)
+	api.GET("/advisor/settings", handler.GetAdvisorSettings)
+	api.PUT("/advisor/settings", handler.UpdateAdvisorSettings)
+
+	// Notifications
+	api.GET("/notifications", middleware.AdminOnly, handler.GetNotifications)
+	api.POST("/notifications", handler.CreateNotification)
+	api.GET("/notifications/settings", handler.GetNotificationSettings)
+	api.PUT("/notifications/settings", handler.UpdateNotificationSettings)
+	api.GET("/notifications/email", handler.GetEmailSettings)
+	api.PUT("/notifications/email", handler.UpdateEmailSettings)
+	api.POST("/notifications/email/test", handler.TestEmail)
+	api.PUT("/notifications/:id", handler.UpdateNotification)
+	api.DELETE("/notifications/:id", handler.DeleteNotification)
+	api.POST("/notifications/:id/test", handler.TestNotification)
+
+	// SLO alerting
+	api.GET("/slo/settings", handler.GetSLOSettings)
+	api.PUT("/slo/settings", handler.UpdateSLOSettings)
 
 	// Health check management
 	api.GET("/health-check/settings", handler.GetHealthCheckSettings)
@@ -101,13 +407,18 @@ func main() {
 	api.DELETE("/health-check/logs", handler.ClearHealthCheckLogs)
 	api.POST("/health-check/run/:id", handler.RunHealthCheck)
 	api.POST("/health-check/run-all", handler.RunHealthCheckAll)
+	api.GET("/health-check/batch", handler.GetHealthCheckBatch)
+	api.GET("/health-check/status-pages", handler.GetProviderStatusPages)
+	api.POST("/health-check/status-pages/refresh", handler.RefreshProviderStatusPages)
+	api.PUT("/health-check/status-pages/settings", handler.UpdateStatusPageSettings)
 
 	// Provider connectivity test
-	api.GET("/test/:id", handler.ProviderTestHandler)
-	api.GET("/test/react/:id", handler.TestReactHandler)
+	api.GET("/test/:id", middleware.AdminOnly, handler.ProviderTestHandler)
+	api.GET("/test/react/:id", middleware.AdminOnly, handler.TestReactHandler)
+
+	// OpenAPI document
+	api.GET("/openapi.json", handler.OpenAPISpec)
 
-	setwebui(router)
-	router.Run(":7070")
 }
 
 //go:embed webui/dist
@@ -116,19 +427,23 @@ var distFiles embed.FS
 //go:embed webui/dist/index.html
 var indexHTML []byte
 
-func setwebui(r *gin.Engine) {
+func setwebui(r *gin.Engine, base string) {
 	subFS, err := fs.Sub(distFiles, "webui/dist/assets")
 	if err != nil {
 		panic(err)
 	}
 
-	r.StaticFS("/assets", http.FS(subFS))
+	r.Group(base+"/assets", handler.AssetCache(subFS)).StaticFS("/", http.FS(subFS))
 
-	r.NoRoute(func(c *gin.Context) {
-		if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") && !strings.HasPrefix(c.Request.URL.Path, "/v1/") {
-			c.Data(http.StatusOK, "text/html; charset=utf-8", indexHTML)
-			return
-		}
-		c.Data(http.StatusNotFound, "text/html; charset=utf-8", []byte("404 Not Found"))
-	})
+	r.NoRoute(handler.NoRouteHandler(base, notFoundMode(), webuiIndex(base)))
+}
+
+// webuiIndex 子路径部署时改写入口页中的资源地址，并注入前端使用的路径前缀
+func webuiIndex(base string) []byte {
+	if base == "" {
+		return indexHTML
+	}
+	index := bytes.ReplaceAll(indexHTML, []byte(`"/assets/`), []byte(`"`+base+`/assets/`))
+	script := []byte(`<script>window.__LLMIO_BASE_PATH__=` + strconv.Quote(base) + `</script></head>`)
+	return bytes.Replace(index, []byte("</head>"), script, 1)
 }
@@ -1,17 +1,124 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/service"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+// apiKeyContextKey 通过数据库 API Key 认证后，key 信息保存在 gin.Context 中的键名
+const apiKeyContextKey = "llmio_api_key"
+
+// APIKeyFromContext 获取当前请求使用的数据库 API Key，使用主 TOKEN 或未开启认证时返回 nil
+func APIKeyFromContext(c *gin.Context) *models.APIKey {
+	value, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return nil
+	}
+	key, _ := value.(*models.APIKey)
+	return key
+}
+
+// SetAPIKey 将数据库 API Key 保存到 gin.Context，供后续处理校验模型白名单、配额与限流
+func SetAPIKey(c *gin.Context, apiKey *models.APIKey) {
+	c.Set(apiKeyContextKey, apiKey)
+}
+
+// readOnlyContextKey 使用只读令牌访问管理接口时在 gin.Context 中设置的键名
+const readOnlyContextKey = "llmio_read_only"
+
+// ReadOnly 当前管理请求是否使用只读令牌认证
+func ReadOnly(c *gin.Context) bool {
+	return c.GetBool(readOnlyContextKey)
+}
+
+// AuthAdmin 管理接口认证，接受主 TOKEN；readOnlyToken 非空时也接受只读令牌，只读令牌只能发起 GET / HEAD 请求。
+// 只设置了只读令牌时同样要求认证，此时管理接口只能只读访问。
+// 启用 OIDC 后同时接受登录会话 cookie 与 IdP 签发的 Bearer JWT，OIDC_READONLY_EMAILS 中的用户按只读令牌处理
+func AuthAdmin(token, readOnlyToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		oidc := service.GetOIDCProvider()
+		// 不设置任何令牌且未启用 OIDC，则不进行验证
+		if token == "" && readOnlyToken == "" && oidc == nil {
+			return
+		}
+		readOnly, ok := authenticateAdmin(c, token, readOnlyToken, oidc)
+		if !ok {
+			c.Abort()
+			return
+		}
+		if !readOnly {
+			return
+		}
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Read-only token cannot modify resources")
+			c.Abort()
+			return
+		}
+		c.Set(readOnlyContextKey, true)
+	}
+}
+
+// authenticateAdmin 校验管理接口凭据并返回是否只读，失败时已写入错误响应
+func authenticateAdmin(c *gin.Context, token, readOnlyToken string, oidc *service.OIDCProvider) (readOnly bool, ok bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		// WebUI 通过 OIDC 登录后只携带会话 cookie
+		if oidc != nil {
+			if cookie, err := c.Cookie(service.OIDCSessionCookie); err == nil {
+				if session, err := oidc.ParseSession(cookie); err == nil {
+					return session.ReadOnly, true
+				}
+			}
+		}
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Authorization header is missing")
+		return false, false
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if !(len(parts) == 2 && parts[0] == "Bearer") {
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Invalid authorization header")
+		return false, false
+	}
+
+	tokenString := parts[1]
+	switch {
+	case token != "" && tokenString == token:
+		return false, true
+	case readOnlyToken != "" && tokenString == readOnlyToken:
+		return true, true
+	case oidc != nil && strings.Count(tokenString, ".") == 2:
+		_, readOnly, err := oidc.VerifyBearer(c.Request.Context(), tokenString)
+		if errors.Is(err, service.ErrOIDCForbidden) {
+			common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "User is not allowed to access llmio")
+			return false, false
+		}
+		if err == nil {
+			return readOnly, true
+		}
+	}
+	common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Invalid token")
+	return false, false
+}
+
+// AdminOnly 拒绝只读令牌访问的 GET 接口，用于会向上游发起请求或返回密钥的接口
+func AdminOnly(c *gin.Context) {
+	if ReadOnly(c) {
+		common.ErrorWithHttpStatus(c, http.StatusForbidden, http.StatusForbidden, "Read-only token cannot access this endpoint")
+		c.Abort()
+	}
+}
+
+// Auth 推理接口认证，接受主 TOKEN 或数据库中的 API Key
 func Auth(token string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 不设置token，则不进行验证
-		if token == "" {
+		if skipAuth(c, token) {
 			return
 		}
 		authHeader := c.GetHeader("Authorization")
@@ -28,19 +135,13 @@ func Auth(token string) gin.HandlerFunc {
 			return
 		}
 
-		tokenString := parts[1]
-		if tokenString != token {
-			common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Invalid token")
-			c.Abort()
-			return
-		}
+		verifyKey(c, token, parts[1])
 	}
 }
 
 func AuthAnthropic(koken string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 不设置token，则不进行验证
-		if koken == "" {
+		if skipAuth(c, koken) {
 			return
 		}
 
@@ -48,7 +149,8 @@ func AuthAnthropic(koken string) gin.HandlerFunc {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" {
 			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) == 2 && parts[0] == "Bearer" && parts[1] == koken {
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				verifyKey(c, koken, parts[1])
 				return
 			}
 		}
@@ -60,10 +162,38 @@ func AuthAnthropic(koken string) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if xApiKey != koken {
+		verifyKey(c, koken, xApiKey)
+	}
+}
+
+// skipAuth 未设置主 TOKEN 且没有创建任何 API Key 时不进行验证
+func skipAuth(c *gin.Context, token string) bool {
+	if token != "" {
+		return false
+	}
+	exists, err := service.HasAPIKeys(c.Request.Context())
+	return err == nil && !exists
+}
+
+// verifyKey 校验主 TOKEN 或数据库 API Key，失败时中断请求
+func verifyKey(c *gin.Context, token string, key string) {
+	if token != "" && key == token {
+		return
+	}
+	apiKey, err := service.LookupAPIKey(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "Invalid token")
-			c.Abort()
-			return
+		} else {
+			common.InternalServerError(c, err.Error())
 		}
+		c.Abort()
+		return
+	}
+	if apiKey.Expired() {
+		common.ErrorWithHttpStatus(c, http.StatusUnauthorized, http.StatusUnauthorized, "API key has expired")
+		c.Abort()
+		return
 	}
+	SetAPIKey(c, apiKey)
 }
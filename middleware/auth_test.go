@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthAdminReadOnlyToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api := r.Group("/api", AuthAdmin("admin", "viewer"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/models", ok)
+	api.POST("/models", ok)
+	api.GET("/test/:id", AdminOnly, ok)
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/api/models", "admin", http.StatusOK},
+		{http.MethodPost, "/api/models", "admin", http.StatusOK},
+		{http.MethodGet, "/api/test/1", "admin", http.StatusOK},
+		{http.MethodGet, "/api/models", "viewer", http.StatusOK},
+		{http.MethodPost, "/api/models", "viewer", http.StatusForbidden},
+		{http.MethodGet, "/api/test/1", "viewer", http.StatusForbidden},
+		{http.MethodGet, "/api/models", "other", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %s: status = %d, want %d", tt.method, tt.path, tt.token, w.Code, tt.want)
+		}
+	}
+}
+
+func TestAuthAdminReadOnlyTokenWithoutToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// 只设置只读令牌时不能跳过认证
+	api := r.Group("/api", AuthAdmin("", "viewer"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/models", ok)
+	api.POST("/models", ok)
+
+	tests := []struct {
+		method, token string
+		want          int
+	}{
+		{http.MethodGet, "viewer", http.StatusOK},
+		{http.MethodPost, "viewer", http.StatusForbidden},
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodGet, "other", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/models", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s with %q: status = %d, want %d", tt.method, tt.token, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRequestIDLength 客户端传入的请求 ID 最大长度，超出或含非法字符时重新生成
+const maxRequestIDLength = 128
+
+// RequestID 沿用客户端传入的 X-Request-Id 或生成新的请求 ID，写入响应头与请求 context
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(service.RequestIDHeader)
+		if !validRequestID(id) {
+			id = service.NewRequestID()
+		}
+		c.Header(service.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(service.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// validRequestID 只接受可安全写入日志与请求头的字符
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog 使用 slog 输出结构化访问日志，替代 gin 默认的文本日志；5xx 记为 error，4xx 记为 warn
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", service.RequestIDFromContext(c.Request.Context())),
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if traceID := service.TraceIDFromContext(c.Request.Context()); traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", traceID))
+		}
+		if apiKey := APIKeyFromContext(c); apiKey != nil {
+			attrs = append(attrs, slog.Uint64("api_key_id", uint64(apiKey.ID)))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "http request", attrs...)
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/atopos31/llmio/service"
+	"github.com/gin-gonic/gin"
+)
+
+// Tracing 为每个请求创建服务端根 span，沿用客户端传入的 traceparent；未启用链路追踪时不做任何事
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := service.ExtractTraceparent(c.Request.Context(), c.GetHeader(service.TraceparentHeader))
+		// 以路由模板命名，避免路径参数导致 span 名称过多
+		name := c.Request.Method
+		if route := c.FullPath(); route != "" {
+			name += " " + route
+		}
+		ctx, span := service.StartSpan(ctx, name, service.SpanKindServer,
+			"http.request.method", c.Request.Method,
+			"http.route", c.FullPath(),
+			"url.path", c.Request.URL.Path,
+			"client.address", c.ClientIP(),
+			"user_agent.original", c.Request.UserAgent(),
+		)
+		if span == nil {
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes("http.response.status_code", status)
+		if apiKey := APIKeyFromContext(c); apiKey != nil {
+			span.SetAttributes("llmio.api_key_id", apiKey.ID)
+		}
+		if status >= 500 {
+			span.RecordError(fmt.Errorf("status: %d", status))
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// SettingEnvPrefix 设置项环境变量前缀，如 LLMIO_SETTING_HEALTH_CHECK_ENABLED=true
+	SettingEnvPrefix = "LLMIO_SETTING_"
+	// SettingEnvModeKey 环境变量生效方式：override（默认，每次启动覆盖数据库）或 seed（仅在数据库缺失该项时写入）
+	SettingEnvModeKey = "LLMIO_SETTINGS_MODE"
+
+	SettingEnvModeOverride = "override"
+	SettingEnvModeSeed     = "seed"
+)
+
+// settingEnvMode 获取环境变量设置的生效方式
+func settingEnvMode() string {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(SettingEnvModeKey)))
+	if mode == SettingEnvModeSeed {
+		return SettingEnvModeSeed
+	}
+	return SettingEnvModeOverride
+}
+
+// SettingEnvOverrides 解析所有 LLMIO_SETTING_* 环境变量，返回 设置键 -> 值
+func SettingEnvOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok {
+			continue
+		}
+		suffix, ok := strings.CutPrefix(name, SettingEnvPrefix)
+		if !ok || suffix == "" {
+			continue
+		}
+		overrides[strings.ToLower(suffix)] = value
+	}
+	return overrides
+}
+
+// SettingValidator 对完整的设置键值执行与设置接口一致的规范化与校验，原地更新规范化后的值；
+// 由 service 包注册，为 nil 时环境变量中的设置只校验键名
+var SettingValidator func(values map[string]string) error
+
+// applySettingEnvOverrides 将环境变量中的设置写入数据库；未知的键与未通过校验的值记录警告后跳过
+// 优先级：override 模式下 环境变量 > 数据库 > 内置默认值；seed 模式下 数据库 > 环境变量 > 内置默认值
+func applySettingEnvOverrides(ctx context.Context) {
+	overrides := SettingEnvOverrides()
+	if len(overrides) == 0 {
+		return
+	}
+	mode := settingEnvMode()
+
+	// 校验基于 内置默认值 + 数据库中的值，逐项叠加环境变量
+	merged := make(map[string]string)
+	for _, setting := range defaultSettings() {
+		merged[setting.Key] = setting.Value
+	}
+	stored, err := gorm.G[Setting](DB).Find(ctx)
+	if err != nil {
+		panic(err)
+	}
+	current := make(map[string]string, len(stored))
+	for _, setting := range stored {
+		current[setting.Key] = setting.Value
+		if _, ok := merged[setting.Key]; ok {
+			merged[setting.Key] = setting.Value
+		}
+	}
+
+	keys := slices.Sorted(maps.Keys(overrides))
+	for _, key := range keys {
+		if _, ok := merged[key]; !ok {
+			slog.Warn("unknown setting in env, ignored", "env", SettingEnvPrefix+strings.ToUpper(key))
+			continue
+		}
+		existing, exists := current[key]
+		if exists && mode == SettingEnvModeSeed {
+			continue
+		}
+		value := overrides[key]
+		if SettingValidator != nil {
+			candidate := maps.Clone(merged)
+			candidate[key] = value
+			if err := SettingValidator(candidate); err != nil {
+				slog.Warn("invalid setting in env, ignored", "env", SettingEnvPrefix+strings.ToUpper(key), "error", err)
+				continue
+			}
+			value = candidate[key]
+		}
+		merged[key] = value
+		if !exists {
+			if err := gorm.G[Setting](DB).Create(ctx, &Setting{Key: key, Value: value}); err != nil {
+				panic(err)
+			}
+			slog.Info("setting seeded from env", "key", key, "mode", mode)
+			continue
+		}
+		if existing == value {
+			continue
+		}
+		if _, err := gorm.G[Setting](DB).Where(ByKey(key)).Update(ctx, "value", value); err != nil {
+			panic(err)
+		}
+		slog.Info("setting overridden from env", "key", key, "mode", mode)
+	}
+}
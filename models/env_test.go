@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestApplySettingEnvOverrides(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/env.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&Setting{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() { DB = previous })
+
+	previousValidator := SettingValidator
+	SettingValidator = func(values map[string]string) error {
+		// 校验时可见完整的设置，包括未写入数据库的内置默认值
+		if _, ok := values[SettingKeySMTPMaxPerHour]; !ok {
+			return fmt.Errorf("missing %s", SettingKeySMTPMaxPerHour)
+		}
+		values[SettingKeyHealthCheckEnabled] = strings.ToLower(values[SettingKeyHealthCheckEnabled])
+		if v := values[SettingKeyHealthCheckEnabled]; v != "true" && v != "false" {
+			return fmt.Errorf("%s must be true or false", SettingKeyHealthCheckEnabled)
+		}
+		return nil
+	}
+	t.Cleanup(func() { SettingValidator = previousValidator })
+
+	ctx := context.Background()
+	value := func(key string) string {
+		t.Helper()
+		settings, err := gorm.G[Setting](DB).Where(ByKey(key)).Find(ctx)
+		if err != nil {
+			t.Fatalf("find %s: %v", key, err)
+		}
+		if len(settings) != 1 {
+			t.Fatalf("expected one %s setting, got %d", key, len(settings))
+		}
+		return settings[0].Value
+	}
+	if err := gorm.G[Setting](DB).Create(ctx, &Setting{Key: SettingKeyLogRetentionCount, Value: "1000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		mode      string
+		enabled   string // 环境变量中的 health_check_enabled，首次运行时数据库中没有该项
+		retention string // 期望的 log_retention_count，数据库中已有该项
+		want      string // 期望的 health_check_enabled
+	}{
+		{name: "seed writes missing and keeps stored values", mode: "seed", enabled: "true", retention: "1000", want: "true"},
+		{name: "seed keeps values written earlier", mode: "seed", enabled: "false", retention: "1000", want: "true"},
+		{name: "override replaces stored values", mode: "", enabled: "FALSE", retention: "500", want: "false"},
+		{name: "invalid values are skipped", mode: "", enabled: "maybe", retention: "500", want: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(SettingEnvModeKey, tt.mode)
+			t.Setenv(SettingEnvPrefix+"LOG_RETENTION_COUNT", "500")
+			t.Setenv(SettingEnvPrefix+"HEALTH_CHECK_ENABLED", tt.enabled)
+			t.Setenv(SettingEnvPrefix+"HEALTH_CHECK_ENABLE", "true")
+			applySettingEnvOverrides(ctx)
+			if count, err := gorm.G[Setting](DB).Where(ByKey("health_check_enable")).Count(ctx, "id"); err != nil || count != 0 {
+				t.Errorf("unknown setting written: count = %d, err = %v", count, err)
+			}
+			if got := value(SettingKeyLogRetentionCount); got != tt.retention {
+				t.Errorf("%s = %s, want %s", SettingKeyLogRetentionCount, got, tt.retention)
+			}
+			if got := value(SettingKeyHealthCheckEnabled); got != tt.want {
+				t.Errorf("%s = %s, want %s", SettingKeyHealthCheckEnabled, got, tt.want)
+			}
+		})
+	}
+}
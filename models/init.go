@@ -2,20 +2,31 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 
-	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
+// Init 连接数据库并完成初始化，设置 DATABASE_URL 时按连接串选择驱动，否则使用 path 处的 SQLite 文件
 func Init(ctx context.Context, path string) {
-	if err := ensureDBFile(path); err != nil {
+	dsn := os.Getenv(DatabaseURLEnv)
+	if dsn == "" {
+		dsn = path
+	}
+	dialector, err := OpenDialector(dsn)
+	if err != nil {
 		panic(err)
 	}
-	db, err := gorm.Open(sqlite.Open(path))
+	InitDB(ctx, dialector)
+}
+
+// InitDB 使用指定的数据库驱动完成迁移与默认设置初始化，测试中可传入内存 SQLite
+func InitDB(ctx context.Context, dialector gorm.Dialector) {
+	db, err := gorm.Open(dialector)
 	if err != nil {
 		panic(err)
 	}
@@ -28,6 +39,20 @@ func Init(ctx context.Context, path string) {
 		&ChatIO{},
 		&Setting{},
 		&HealthCheckLog{},
+		&BillingRecord{},
+		&UserAgentRule{},
+		&APIKey{},
+		&RateLimitCounter{},
+		&UsageRecord{},
+		&Pricing{},
+		&MessageBatch{},
+		&MessageBatchItem{},
+		&ProviderIncident{},
+		&LeaderLease{},
+		&ShadowLog{},
+		&Notification{},
+		&MetricsRollup{},
+		&UploadedFile{},
 	); err != nil {
 		panic(err)
 	}
@@ -40,15 +65,17 @@ func Init(ctx context.Context, path string) {
 	}); err != nil {
 		panic(err)
 	}
+	// 应用环境变量中的设置（需在默认设置之前，以便 seed 模式能替换内置默认值）
+	applySettingEnvOverrides(ctx)
 	// 初始化默认设置
 	initDefaultSettings(ctx)
 	// 初始化优先级字段
 	initPriorityField(ctx)
 }
 
-// initDefaultSettings 初始化默认设置
-func initDefaultSettings(ctx context.Context) {
-	defaultSettings := []Setting{
+// defaultSettings 内置默认设置，也是允许通过环境变量设置的全部键
+func defaultSettings() []Setting {
+	return []Setting{
 		{Key: SettingKeyStrictCapabilityMatch, Value: "false"},   // 默认关闭严格能力匹配
 		{Key: SettingKeyAutoWeightDecay, Value: "false"},         // 默认关闭自动权重衰减
 		{Key: SettingKeyAutoWeightDecayDefault, Value: "5"},      // 默认权重值5
@@ -63,20 +90,73 @@ func initDefaultSettings(ctx context.Context) {
 		{Key: SettingKeyAutoPriorityIncreaseStep, Value: "1"},    // 默认每次成功增加1
 		{Key: SettingKeyAutoPriorityIncreaseMax, Value: "100"},   // 默认优先级上限100
 		{Key: SettingKeyLogRetentionCount, Value: "100"},         // 默认保留100条日志，0表示不限制
+		{Key: SettingKeyAPIErrorLocale, Value: "auto"},           // 默认按 Accept-Language 选择错误信息语言
 		// 健康检测相关默认设置
-		{Key: SettingKeyHealthCheckEnabled, Value: "false"},                // 默认关闭健康检测
-		{Key: SettingKeyHealthCheckInterval, Value: "60"},                  // 默认检测间隔60分钟
-		{Key: SettingKeyHealthCheckFailureThreshold, Value: "3"},           // 默认失败3次后禁用
-		{Key: SettingKeyHealthCheckFailureDisableEnabled, Value: "true"},   // 默认启用失败自动禁用功能
-		{Key: SettingKeyHealthCheckAutoEnable, Value: "false"},             // 默认检测成功不自动启用
-		{Key: SettingKeyHealthCheckLogRetentionCount, Value: "100"},        // 默认保留100条健康检测日志，0 表示不限制
-		{Key: SettingKeyHealthCheckCountAsSuccess, Value: "true"},          // 默认健康检测成功计入成功调用
-		{Key: SettingKeyHealthCheckCountAsFailure, Value: "false"},         // 默认健康检测失败不计入失败调用
+		{Key: SettingKeyHealthCheckEnabled, Value: "false"},              // 默认关闭健康检测
+		{Key: SettingKeyHealthCheckInterval, Value: "60"},                // 默认检测间隔60分钟
+		{Key: SettingKeyHealthCheckFailureThreshold, Value: "3"},         // 默认失败3次后禁用
+		{Key: SettingKeyHealthCheckFailureDisableEnabled, Value: "true"}, // 默认启用失败自动禁用功能
+		{Key: SettingKeyHealthCheckAutoEnable, Value: "false"},           // 默认检测成功不自动启用
+		{Key: SettingKeyHealthCheckLogRetentionCount, Value: "100"},      // 默认保留100条健康检测日志，0 表示不限制
+		{Key: SettingKeyHealthCheckCountAsSuccess, Value: "true"},        // 默认健康检测成功计入成功调用
+		{Key: SettingKeyHealthCheckCountAsFailure, Value: "false"},       // 默认健康检测失败不计入失败调用
+		{Key: SettingKeyHealthCheckMaxQPS, Value: "2"},                   // 默认全局每秒最多 2 次检测
+		{Key: SettingKeyHealthCheckProviderSpacing, Value: "1000"},       // 默认同一供应商至少间隔 1 秒
+		{Key: SettingKeyHealthCheckJitter, Value: "500"},                 // 默认追加最多 500 毫秒随机延迟
+		{Key: SettingKeyHealthCheckConcurrency, Value: "10"},             // 默认同时进行 10 个检测
+		{Key: SettingKeyHealthCheckProviderConcurrency, Value: "2"},      // 默认同一供应商同时进行 2 个检测
+		{Key: SettingKeyHealthCheckBatchTimeout, Value: "1800"},          // 默认一轮批量检测最长 30 分钟
+		{Key: SettingKeyHealthCheckScheduleJitter, Value: "10"},          // 默认检测间隔上下浮动 10%
+		{Key: SettingKeyHealthCheckFailureAction, Value: "disable"},      // 默认连续失败后禁用关联
+		{Key: SettingKeyHealthCheckQuarantineWeight, Value: "10"},        // 默认降权至原权重的 10%
+		// SLO 告警相关默认设置
+		{Key: SettingKeySLOAlertWebhook, Value: ""},          // 默认不发送 SLO 告警
+		{Key: SettingKeySLOBurnRateThreshold, Value: "14.4"}, // 默认燃烧率阈值 14.4（1 小时内消耗 30 天预算的 2%）
+		// 权重建议相关默认设置
+		{Key: SettingKeyWeightAdvisorAutoApply, Value: "false"}, // 默认不自动应用权重建议
+		{Key: SettingKeyWeightAdvisorInterval, Value: "24"},     // 默认每 24 小时应用一次
+		{Key: SettingKeyStatusPagePollInterval, Value: "5"},     // 默认每 5 分钟轮询一次供应商状态页
+		{Key: SettingKeyRateLimitSnapshotInterval, Value: "30"}, // 默认每 30 秒保存一次限流计数
+		// 请求排队相关默认设置
+		{Key: SettingKeyRequestMaxConcurrent, Value: "0"},      // 默认不限制并发，不排队
+		{Key: SettingKeyRequestBatchMaxConcurrent, Value: "0"}, // 默认 batch 请求只受总上限限制
+		{Key: SettingKeyRequestQueueTimeout, Value: "30"},      // 默认最多排队 30 秒
+		// 文件上传相关默认设置
+		{Key: SettingKeyFilesMaxUploadSize, Value: "32"}, // 默认单个上传请求不超过 32 MB
+		{Key: SettingKeyFilesProvider, Value: ""},        // 默认使用第一个支持文件接口的供应商
+		// 请求隔离相关默认设置
+		{Key: SettingKeyRequestQuarantineThreshold, Value: "0"},  // 默认关闭请求隔离
+		{Key: SettingKeyRequestQuarantineCooldown, Value: "600"}, // 默认隔离 10 分钟
+		{Key: SettingKeyKeyInvalidWebhook, Value: ""},            // 默认不发送密钥失效通知
+		// 日志脱敏默认设置
+		{Key: SettingKeyLogRedactionRules, Value: defaultRedactionRulesJSON()}, // 默认去除类似 Authorization 的密钥
+		{Key: SettingKeyPromptCacheRouting, Value: "off"},                      // 默认原样转发 cache_control
+		{Key: SettingKeyPromptCacheAutoInject, Value: "false"},                 // 默认不自动添加缓存断点
+		{Key: SettingKeyEmptyStreamHandling, Value: "failover"},                // 默认将空流式响应记为错误并切换关联
+		{Key: SettingKeyAdaptiveBalancing, Value: "false"},                     // 默认不按时延自动调整权重
+		// 通知相关默认设置
+		{Key: SettingKeyNotifyErrorRateThreshold, Value: "50"},   // 默认 5 分钟内错误率超过 50% 时通知
+		{Key: SettingKeyNotifyErrorRateMinRequests, Value: "20"}, // 默认至少 20 个请求才参与检测
+		// 邮件告警默认设置
+		{Key: SettingKeySMTPHost, Value: ""},                  // 默认不发送邮件告警
+		{Key: SettingKeySMTPPort, Value: "587"},               // 默认使用 587 端口
+		{Key: SettingKeySMTPUsername, Value: ""},              // 默认不认证
+		{Key: SettingKeySMTPPassword, Value: ""},              // 默认无密码
+		{Key: SettingKeySMTPFrom, Value: ""},                  // 默认未设置发件人
+		{Key: SettingKeySMTPTo, Value: ""},                    // 默认未设置收件人
+		{Key: SettingKeySMTPEvents, Value: defaultSMTPEvents}, // 默认只发送健康检测与配额相关告警
+		{Key: SettingKeySMTPSubjectTemplate, Value: ""},       // 默认使用内置标题模板
+		{Key: SettingKeySMTPBodyTemplate, Value: ""},          // 默认使用内置正文模板
+		{Key: SettingKeySMTPCooldown, Value: "30"},            // 默认同一告警 30 分钟内只发送一次
+		{Key: SettingKeySMTPMaxPerHour, Value: "20"},          // 默认每小时最多发送 20 封
 	}
+}
 
-	for _, setting := range defaultSettings {
+// initDefaultSettings 初始化默认设置
+func initDefaultSettings(ctx context.Context) {
+	for _, setting := range defaultSettings() {
 		// 如果设置不存在则创建
-		count, err := gorm.G[Setting](DB).Where("key = ?", setting.Key).Count(ctx, "id")
+		count, err := gorm.G[Setting](DB).Where(ByKey(setting.Key)).Count(ctx, "id")
 		if err != nil {
 			panic(err)
 		}
@@ -88,6 +168,18 @@ func initDefaultSettings(ctx context.Context) {
 	}
 }
 
+// defaultSMTPEvents 默认发送邮件的通知事件
+const defaultSMTPEvents = "health_check_failing,provider_auto_disabled,quota_exhausted"
+
+// defaultRedactionRulesJSON 默认脱敏规则的设置值
+func defaultRedactionRulesJSON() string {
+	value, err := json.Marshal(DefaultRedactionRules)
+	if err != nil {
+		panic(err)
+	}
+	return string(value)
+}
+
 // initPriorityField 初始化优先级字段，为现有记录设置默认优先级
 func initPriorityField(ctx context.Context) {
 	// 为 priority 为 0 的记录设置默认优先级 100
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/json"
 	"net/http"
+	"path"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,9 +13,17 @@ type Provider struct {
 	gorm.Model
 	Name    string
 	Type    string
-	Config  string
+	Config  string `gorm:"serializer:secret"` // 密钥字段在设置 LLMIO_MASTER_KEY 后加密存储
 	Console string // 控制台地址
 	Proxy   string // 代理地址
+
+	StatusPage     string // 状态页地址：statuspage.io 站点（如 https://status.openai.com）或自定义 JSON 接口
+	StatusPagePath string // 自定义 JSON 中状态字段的 gjson 路径，为空时按 statuspage.io 格式解析
+
+	ImageMaxDimension int // 图片最长边像素上限，超出时转发前自动缩放，0 表示不限制
+	ImageMaxBytes     int // 单张图片字节上限，超出时转发前重新压缩，0 表示不限制
+
+	TPMLimit int // 供应商每分钟 token 上限，接近上限时大请求提前切换到其他供应商或延后发送，0 表示不限制
 }
 
 type AnthropicConfig struct {
@@ -29,6 +39,100 @@ type Model struct {
 	MaxRetry int   // 重试次数限制
 	TimeOut  int   // 超时时间 单位秒
 	IOLog    *bool // 是否记录IO
+
+	ConnectTimeout    int // 建立连接与 TLS 握手的超时（秒），0 表示默认 10 秒
+	FirstTokenTimeout int // 自发送请求起等待响应头与流式首个数据块的超时（秒），0 表示沿用 TimeOut
+	StreamIdleTimeout int // 流式响应两个数据块之间的最长间隔（秒），超出后中断流，0 表示默认 300 秒
+
+	MaxOutputTokens int // 单次响应输出 token 上限，超出后中断流，0 表示不限制
+	MaxOutputBytes  int // 单次响应输出字节上限，超出后中断流，0 表示不限制
+
+	ToolAuditWebhook string // 工具调用审计 webhook，非空时异步推送响应中的工具调用（参数已脱敏）
+
+	SLOFirstTokenMs int     // 首字时延 SLO 阈值（毫秒），0 表示不启用 SLO
+	SLOTarget       float64 // SLO 目标达标率（百分比），如 95 表示 95% 的请求需达标
+	SLOWindowHours  int     // SLO 统计窗口（小时），0 表示默认 24 小时
+
+	RPM int // 每分钟请求数上限，0 表示不限制
+	TPM int // 每分钟 token 数上限，0 表示不限制
+
+	ResponseRules *ResponseRules `gorm:"serializer:json"` // 返回给客户端前的响应改写规则
+
+	LogLevel string // 日志详细级别，为空时按 IOLog 取 full 或 metadata
+
+	LogSampleRate int // 成功请求日志采样率，每 N 个请求完整记录 1 个，失败请求全部记录；0 或 1 表示全部记录
+
+	Fallbacks []string `gorm:"serializer:json"` // 按顺序尝试的备用模型，主模型的供应商全部失败或不可用时切换
+	Aliases   []string `gorm:"serializer:json"` // 别名，支持末尾 * 前缀与 * / ? 通配符，不存在的模型名按别名路由到该模型
+
+	ShadowModelProviderID uint // 影子流量的目标关联，可以是禁用或权重为 0 的关联，0 表示不镜像
+	ShadowPercent         int  // 异步镜像到影子关联的请求百分比（1-100），响应丢弃，只记录指标
+
+	StreamHeartbeatSeconds int // 流式请求等待上游首个字节期间每隔 N 秒向客户端发送 `: ping` 注释，0 表示不发送
+
+	RoutingStrategy string // 供应商选择策略：为空或 weight 按优先级与权重，cost 优先定价最低，latency 优先近期首字时延 p95 最低
+
+	StickySession    *bool // 会话粘滞：同一会话的后续请求优先路由到之前服务过的供应商
+	StickySessionTTL int   // 会话粘滞有效期（秒），0 表示默认 30 分钟
+
+	RetryBackoffMs    int // 首次重试前的退避时间（毫秒），之后按指数增长并加随机抖动，0 表示立即重试
+	RetryBackoffMaxMs int // 单次退避上限（毫秒），0 表示不限制
+	RetryMaxElapsedMs int // 自首次尝试起允许重试的最长时间（毫秒），0 表示只受 TimeOut 限制
+	RetryBudget       int // 每分钟允许的重试次数，超出后直接返回失败，0 表示不限制
+
+	SummarizeThreshold int    // 估算输入 token 超过该值时将较早的对话压缩为摘要，0 表示不启用
+	SummarizeModel     string // 生成摘要使用的模型，经由 llmio 自身路由，为空表示不启用
+	SummarizeKeep      int    // 压缩时至少保留的最近消息数，0 表示默认 4
+
+	MaxInputTokens       int   // 单次请求输入 token 上限，转发前估算，超出时直接返回 400，0 表示不限制
+	PreflightCountTokens *bool // 是否对 Anthropic 格式请求先调用上游 count_tokens 统计输入 token，不可用时回退到本地估算
+}
+
+// 日志详细级别，由低到高
+const (
+	LogLevelNone     = "none"     // 只保留用量与状态，不记录来源 IP、User-Agent 与输入输出
+	LogLevelMetadata = "metadata" // 记录请求元数据，不记录输入输出
+	LogLevelPrompts  = "prompts"  // 额外记录客户端请求体
+	LogLevelFull     = "full"     // 记录完整输入输出
+	LogLevelRaw      = "raw"      // 在完整输入输出基础上记录发往上游的请求体与上游原始响应
+)
+
+// ResponseRules 模型级响应后处理规则，流式与非流式输出均生效
+type ResponseRules struct {
+	Replacements           []ResponseReplacement `json:"replacements"`             // 按顺序执行的正则替换，如去除中转站注入的水印
+	TrimTrailingWhitespace bool                  `json:"trim_trailing_whitespace"` // 去除输出末尾的空白
+	EnforceStop            bool                  `json:"enforce_stop"`             // 上游忽略客户端的 stop / stop_sequences 时在网关截断
+}
+
+// ResponseReplacement 正则替换规则，Replacement 可用 $1 引用捕获组
+type ResponseReplacement struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Empty 是否没有任何需要执行的规则
+func (r *ResponseRules) Empty() bool {
+	return r == nil || (len(r.Replacements) == 0 && !r.TrimTrailingWhitespace && !r.EnforceStop)
+}
+
+// RequestRewrite 发往上游前对请求执行的改写规则，Path 使用 gjson/sjson 路径语法
+type RequestRewrite struct {
+	Op    string          `json:"op"`              // set、delete、rename 改写请求体；set_header、delete_header 改写请求头
+	Path  string          `json:"path"`            // 请求体路径或请求头名称
+	To    string          `json:"to,omitempty"`    // rename 的目标路径
+	Value json.RawMessage `json:"value,omitempty"` // set 的 JSON 值，set_header 时为字符串
+}
+
+// HealthCheckConfig 关联的健康检测配置，字段为零值时使用默认值
+type HealthCheckConfig struct {
+	Prompt    string `json:"prompt,omitempty"`     // 检测提示词，默认 "ping"
+	MaxTokens int    `json:"max_tokens,omitempty"` // 输出 token 上限，默认 1，配置 Expect 时默认 16
+	Expect    string `json:"expect,omitempty"`     // 响应内容应包含的子串（不区分大小写），为空时只检查状态码
+	Timeout   int    `json:"timeout,omitempty"`    // 超时秒数，默认 30
+	Interval  int    `json:"interval,omitempty"`   // 定时检测间隔（分钟），默认使用全局检测间隔
+
+	Stream            bool `json:"stream,omitempty"`              // 以流式请求检测，要求首个数据块按时到达且流正常结束
+	FirstChunkTimeout int  `json:"first_chunk_timeout,omitempty"` // 流式检测等待首个数据块的秒数，默认 10
 }
 
 type ModelWithProvider struct {
@@ -39,11 +143,50 @@ type ModelWithProvider struct {
 	ToolCall         *bool             // 能否接受带有工具调用的请求
 	StructuredOutput *bool             // 能否接受带有结构化输出的请求
 	Image            *bool             // 能否接受带有图片的请求(视觉)
+	PromptCache      *bool             // 能否接受带有 cache_control 的请求(提示缓存)
 	WithHeader       *bool             // 是否透传header
 	Status           *bool             // 是否启用
 	CustomerHeaders  map[string]string `gorm:"serializer:json"` // 自定义headers
 	Weight           int
 	Priority         int // 优先级，值越高越优先选择
+
+	Metadata *ModelMetadata `gorm:"serializer:json"` // 从模型目录导入的元数据
+
+	ContextLength int // 上下文窗口 token 数，超出的请求不再路由到该关联；0 时使用导入的元数据
+
+	RequestRewrites []RequestRewrite `gorm:"serializer:json"` // 按顺序执行的请求改写规则，如重命名字段或追加 enable_thinking
+
+	HealthCheck *HealthCheckConfig `gorm:"serializer:json"` // 健康检测配置，为空时使用默认提示词与超时
+
+	AuthFailedAt     *time.Time // 上游返回 401/403 的时间，为空表示未隔离
+	AuthFailedConfig string     // 隔离时供应商配置与自定义请求头的摘要，配置变更后自动解除隔离
+
+	QuarantinedWeight int // 健康检测连续失败降权前的原权重，检测恢复后还原，0 表示未降权
+}
+
+// MaxContextLength 关联的上下文窗口，手动配置优先于导入的元数据，0 表示未知
+func (mp ModelWithProvider) MaxContextLength() int {
+	if mp.ContextLength > 0 {
+		return mp.ContextLength
+	}
+	if mp.Metadata != nil {
+		return mp.Metadata.ContextLength
+	}
+	return 0
+}
+
+// ModelMetadata 从 OpenRouter 风格模型目录导入的供应商模型元数据，单价为每百万 token
+type ModelMetadata struct {
+	CatalogID        string    `json:"catalog_id"`        // 匹配到的目录模型 ID
+	ContextLength    int       `json:"context_length"`    // 上下文窗口 token 数
+	MaxOutputTokens  int       `json:"max_output_tokens"` // 单次输出 token 上限
+	InputModalities  []string  `json:"input_modalities"`  // 如 text、image、file
+	OutputModalities []string  `json:"output_modalities"` // 如 text、image
+	InputPrice       float64   `json:"input_price"`
+	OutputPrice      float64   `json:"output_price"`
+	CachedPrice      float64   `json:"cached_price"`
+	Source           string    `json:"source"` // 目录地址
+	ImportedAt       time.Time `json:"imported_at"`
 }
 
 type ChatLog struct {
@@ -51,18 +194,34 @@ type ChatLog struct {
 	Name          string `gorm:"index"`
 	ProviderModel string `gorm:"index"`
 	ProviderName  string `gorm:"index"`
-	Status        string `gorm:"index"` // error or success
+	Status        string `gorm:"index"` // error, success or cancelled
 	Style         string // 类型
 	UserAgent     string `gorm:"index"` // 用户代理
 	RemoteIP      string // 访问ip
 	ChatIO        bool   // 是否开启IO记录
+	APIKeyID      uint   `gorm:"index"` // 发起请求的 API Key，0 表示使用 TOKEN 或未鉴权
+
+	ModelWithProviderID uint   `gorm:"index"` // 命中的模型-供应商关联
+	LogLevel            string // 本次请求生效的日志详细级别
+
+	ResponseHash string `gorm:"index"` // 上游原始响应的 SHA-256，用于核对账单与识别重复响应
+	ResponseSize int64  // 上游原始响应字节数
+	SampleWeight int    `gorm:"default:1"` // 采样记录代表的请求数，聚合统计按此加权
+	ServedModel  string `gorm:"index"`     // 实际提供服务的模型，触发模型级故障转移时为备用模型
+	SessionID    string `gorm:"index"`     // 会话标识（X-Session-ID 或首条用户消息摘要），用于会话级统计
+	RequestID    string `gorm:"index"`     // 请求 ID（X-Request-Id），同一次请求的重试日志共用
+
+	HeaderFailovers int // 本次尝试前已返回响应头、但在输出内容前出错而切换关联的次数，成功日志大于 0 表示由故障转移挽救
 
 	Error          string        // if status is error, this field will be set
+	ErrorCategory  string        `gorm:"index"` // 错误分类：auth、rate_limit、timeout、context_length、content_filter、network、5xx 或 other
 	Retry          int           // 重试次数
+	RetryDelay     time.Duration // 本次尝试前的退避等待时间
 	ProxyTime      time.Duration // 代理耗时
 	FirstChunkTime time.Duration // 首个chunk耗时
 	ChunkTime      time.Duration // chunk耗时
 	Tps            float64
+	Cost           float64 // 按定价表计算的费用
 	Usage
 }
 
@@ -82,6 +241,8 @@ type Usage struct {
 type PromptTokensDetails struct {
 	CachedTokens int64 `json:"cached_tokens"`
 	AudioTokens  int64 `json:"audio_tokens"`
+
+	CacheCreationTokens int64 `json:"cache_creation_tokens"` // 写入提示缓存的输入 token 数（Anthropic cache_creation_input_tokens）
 }
 
 type ChatIO struct {
@@ -89,6 +250,19 @@ type ChatIO struct {
 	LogId uint
 	Input string
 	OutputUnion
+
+	RawRequest  string // 发往上游的请求体（格式转换后），仅 raw 级别记录
+	RawResponse string // 上游原始响应（格式转换前），仅 raw 级别记录
+
+	Summary *ContextSummary `gorm:"serializer:json"` // 上下文压缩记录，为空表示未压缩
+}
+
+// ContextSummary 上下文压缩记录：被替换的原始消息与摘要模型生成的摘要
+type ContextSummary struct {
+	Model    string `json:"model"`    // 生成摘要的模型
+	Messages int    `json:"messages"` // 被替换的消息数
+	Replaced string `json:"replaced"` // 被替换的原始消息（JSON 数组）
+	Summary  string `json:"summary"`
 }
 
 type OutputUnion struct {
@@ -100,12 +274,13 @@ type ReqMeta struct {
 	UserAgent string `gorm:"index"` // 用户代理
 	RemoteIP  string // 访问ip
 	Header    http.Header
+	APIKeyID  uint // 发起请求的 API Key
 }
 
 // Setting 系统设置
 type Setting struct {
 	gorm.Model
-	Key   string `gorm:"uniqueIndex"` // 设置键名
+	Key   string `gorm:"uniqueIndex;size:191"` // 设置键名
 	Value string // 设置值
 }
 
@@ -118,28 +293,198 @@ const (
 	SettingKeyAutoWeightIncreaseStep = "auto_weight_increase_step" // 自动权重增加步长（每次成功增加的权重）
 	SettingKeyAutoWeightIncreaseMax  = "auto_weight_increase_max"  // 自动权重增加的上限
 
-	SettingKeyAutoPriorityDecay                = "auto_priority_decay"                  // 自动优先级衰减开关
-	SettingKeyAutoPriorityDecayDefault         = "auto_priority_decay_default"         // 自动优先级衰减默认优先级
-	SettingKeyAutoPriorityDecayStep            = "auto_priority_decay_step"            // 自动优先级衰减步长（每次失败减少的优先级）
-	SettingKeyAutoPriorityDecayThreshold       = "auto_priority_decay_threshold"       // 自动优先级衰减阈值（达到此值自动禁用）
-	SettingKeyAutoPriorityDecayDisableEnabled  = "auto_priority_decay_disable_enabled" // 是否启用自动禁用功能（达到阈值时禁用）
-	SettingKeyAutoPriorityIncreaseStep         = "auto_priority_increase_step"         // 自动优先级增加步长（每次成功增加的优先级）
-	SettingKeyAutoPriorityIncreaseMax          = "auto_priority_increase_max"          // 自动优先级增加的上限
-	SettingKeyAutoSuccessIncrease              = "auto_success_increase"               // 成功调用后是否执行自增
+	SettingKeyAutoPriorityDecay               = "auto_priority_decay"                 // 自动优先级衰减开关
+	SettingKeyAutoPriorityDecayDefault        = "auto_priority_decay_default"         // 自动优先级衰减默认优先级
+	SettingKeyAutoPriorityDecayStep           = "auto_priority_decay_step"            // 自动优先级衰减步长（每次失败减少的优先级）
+	SettingKeyAutoPriorityDecayThreshold      = "auto_priority_decay_threshold"       // 自动优先级衰减阈值（达到此值自动禁用）
+	SettingKeyAutoPriorityDecayDisableEnabled = "auto_priority_decay_disable_enabled" // 是否启用自动禁用功能（达到阈值时禁用）
+	SettingKeyAutoPriorityIncreaseStep        = "auto_priority_increase_step"         // 自动优先级增加步长（每次成功增加的优先级）
+	SettingKeyAutoPriorityIncreaseMax         = "auto_priority_increase_max"          // 自动优先级增加的上限
+	SettingKeyAutoSuccessIncrease             = "auto_success_increase"               // 成功调用后是否执行自增
 
 	SettingKeyLogRetentionCount = "log_retention_count" // 日志保留条数，0表示不限制
 
+	SettingKeyAPIErrorLocale = "api_error_locale" // 面向客户端错误信息的语言：auto（按 Accept-Language）、en、zh
+
 	// 模型健康检测相关设置
-	SettingKeyHealthCheckEnabled                 = "health_check_enabled"                   // 健康检测总开关
-	SettingKeyHealthCheckInterval                = "health_check_interval"                  // 健康检测间隔（分钟）
-	SettingKeyHealthCheckFailureThreshold        = "health_check_failure_threshold"         // 失败次数阈值（超过此值自动禁用）
-	SettingKeyHealthCheckFailureDisableEnabled   = "health_check_failure_disable_enabled"   // 是否启用失败自动禁用功能
-	SettingKeyHealthCheckAutoEnable              = "health_check_auto_enable"               // 检测成功后是否自动启用
-	SettingKeyHealthCheckLogRetentionCount       = "health_check_log_retention_count"       // 健康检测日志保留条数，0表示不限制
-	SettingKeyHealthCheckCountAsSuccess          = "health_check_count_as_success"          // 健康检测成功是否计入成功调用
-	SettingKeyHealthCheckCountAsFailure          = "health_check_count_as_failure"          // 健康检测失败是否计入失败调用（触发衰减）
+	SettingKeyHealthCheckEnabled               = "health_check_enabled"                 // 健康检测总开关
+	SettingKeyHealthCheckInterval              = "health_check_interval"                // 健康检测间隔（分钟）
+	SettingKeyHealthCheckFailureThreshold      = "health_check_failure_threshold"       // 失败次数阈值（超过此值自动禁用）
+	SettingKeyHealthCheckFailureDisableEnabled = "health_check_failure_disable_enabled" // 是否启用失败自动禁用功能
+	SettingKeyHealthCheckAutoEnable            = "health_check_auto_enable"             // 检测成功后是否自动启用
+	SettingKeyHealthCheckLogRetentionCount     = "health_check_log_retention_count"     // 健康检测日志保留条数，0表示不限制
+	SettingKeyHealthCheckCountAsSuccess        = "health_check_count_as_success"        // 健康检测成功是否计入成功调用
+	SettingKeyHealthCheckCountAsFailure        = "health_check_count_as_failure"        // 健康检测失败是否计入失败调用（触发衰减）
+	SettingKeyHealthCheckMaxQPS                = "health_check_max_qps"                 // 全局健康检测每秒请求数上限，0 表示不限制
+	SettingKeyHealthCheckProviderSpacing       = "health_check_provider_spacing"        // 同一供应商相邻两次检测的最小间隔（毫秒）
+	SettingKeyHealthCheckJitter                = "health_check_jitter"                  // 每次检测在间隔之外追加的随机延迟上限（毫秒）
+	SettingKeyHealthCheckConcurrency           = "health_check_concurrency"             // 批量检测同时进行的检测数
+	SettingKeyHealthCheckProviderConcurrency   = "health_check_provider_concurrency"    // 批量检测中同一供应商同时进行的检测数，0 表示不限制
+	SettingKeyHealthCheckBatchTimeout          = "health_check_batch_timeout"           // 一轮批量检测的最长时间（秒），超时后未开始的检测跳过，0 表示不限制
+	SettingKeyHealthCheckScheduleJitter        = "health_check_schedule_jitter"         // 定时检测的到期时间在检测间隔上随机浮动的比例（百分比，0-100）
+	SettingKeyHealthCheckFailureAction         = "health_check_failure_action"          // 连续失败达到阈值时的处理方式：disable 禁用关联，quarantine 降低权重
+	SettingKeyHealthCheckQuarantineWeight      = "health_check_quarantine_weight"       // quarantine 模式下降权后保留原权重的百分比（1-100）
+
+	// SLO 告警相关设置
+	SettingKeySLOAlertWebhook      = "slo_alert_webhook"       // SLO 告警 webhook，为空表示不告警
+	SettingKeySLOBurnRateThreshold = "slo_burn_rate_threshold" // 错误预算燃烧率告警阈值（长短窗口同时超过时告警）
+
+	// 权重建议相关设置
+	SettingKeyWeightAdvisorAutoApply = "weight_advisor_auto_apply" // 是否定期自动应用权重建议
+	SettingKeyWeightAdvisorInterval  = "weight_advisor_interval"   // 自动应用间隔（小时）
+
+	SettingKeyStatusPagePollInterval = "status_page_poll_interval" // 供应商状态页轮询间隔（分钟），0 表示不轮询
+
+	SettingKeyRateLimitSnapshotInterval = "rate_limit_snapshot_interval" // 限流计数写入数据库的间隔（秒），0 表示不持久化
+
+	SettingKeyRequestMaxConcurrent      = "request_max_concurrent"       // 同时转发的请求数上限，0 表示不限制
+	SettingKeyRequestBatchMaxConcurrent = "request_batch_max_concurrent" // batch 优先级请求同时转发的上限，0 表示只受总上限限制
+	SettingKeyRequestQueueTimeout       = "request_queue_timeout"        // 名额已满时排队等待的最长时间（秒）
+
+	SettingKeyFilesMaxUploadSize = "files_max_upload_size" // /v1/files 单个上传请求的大小上限（MB）
+	SettingKeyFilesProvider      = "files_provider"        // 未通过请求头指定时文件上传使用的供应商名称，为空表示第一个支持文件接口的供应商
+
+	SettingKeyRequestQuarantineThreshold = "request_quarantine_threshold" // 同一请求被所有供应商拒绝多少次后隔离，0 表示关闭
+	SettingKeyRequestQuarantineCooldown  = "request_quarantine_cooldown"  // 请求隔离的冷却时间（秒）
+
+	SettingKeyKeyInvalidWebhook = "key_invalid_webhook" // 上游返回 401/403 隔离关联时通知的 webhook，为空表示不通知
+
+	SettingKeyLogRedactionRules = "log_redaction_rules" // 写入 ChatIO 前执行的脱敏规则（JSON 数组）
+
+	SettingKeyPromptCacheRouting    = "prompt_cache_routing"     // 请求带有 cache_control 时的路由方式：off、strip、prefer
+	SettingKeyPromptCacheAutoInject = "prompt_cache_auto_inject" // 是否为发往支持提示缓存的 Anthropic 关联的请求自动添加缓存断点
+
+	SettingKeyEmptyStreamHandling = "empty_stream_handling" // 流式响应没有任何内容时的处理方式：off、error、failover
+
+	SettingKeyAdaptiveBalancing = "adaptive_balancing" // 是否按近期首字时延与 TPS 自动缩放关联权重
+
+	// 通知相关设置
+	SettingKeyNotifyErrorRateThreshold   = "notify_error_rate_threshold"    // 模型 5 分钟内错误率超过该百分比时通知，0 表示不检测
+	SettingKeyNotifyErrorRateMinRequests = "notify_error_rate_min_requests" // 参与错误率检测的最少请求数
+
+	// 邮件告警相关设置
+	SettingKeySMTPHost            = "smtp_host"             // SMTP 服务器地址，为空表示不发送邮件告警
+	SettingKeySMTPPort            = "smtp_port"             // SMTP 端口，465 使用隐式 TLS，其余端口在服务器支持时使用 STARTTLS
+	SettingKeySMTPUsername        = "smtp_username"         // SMTP 用户名，为空表示不认证
+	SettingKeySMTPPassword        = "smtp_password"         // SMTP 密码
+	SettingKeySMTPFrom            = "smtp_from"             // 发件人地址
+	SettingKeySMTPTo              = "smtp_to"               // 收件人地址，逗号分隔
+	SettingKeySMTPEvents          = "smtp_events"           // 发送邮件的通知事件，逗号分隔，为空表示全部
+	SettingKeySMTPSubjectTemplate = "smtp_subject_template" // 邮件标题模板（text/template），为空使用内置模板
+	SettingKeySMTPBodyTemplate    = "smtp_body_template"    // 邮件正文模板（text/template），为空使用内置模板
+	SettingKeySMTPCooldown        = "smtp_cooldown"         // 同一告警两次发送的最小间隔（分钟）
+	SettingKeySMTPMaxPerHour      = "smtp_max_per_hour"     // 每小时最多发送的告警邮件数，0 表示不限制
 )
 
+// RedactionRule 日志脱敏规则：Pattern 按正则替换全部文本，Path 将 JSON 中匹配路径的值整体替换，
+// 路径按 . 分隔，* 匹配任意对象键或数组下标
+type RedactionRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Replacement string `json:"replacement"` // 为空时使用 [REDACTED]，正则规则可用 $1 引用捕获组
+}
+
+// DefaultRedactionRules 默认脱敏规则：去除 Bearer 令牌、常见 API Key 格式与请求体中的密钥字段
+var DefaultRedactionRules = []RedactionRule{
+	{Name: "bearer", Pattern: `(?i)\b(bearer)\s+[a-z0-9._~+/=-]{8,}`, Replacement: "$1 [REDACTED]"},
+	{Name: "api_key", Pattern: `\b(?:sk|pk|rk|ak)-[A-Za-z0-9_-]{16,}`},
+	{Name: "key_field", Pattern: `(?i)("(?:authorization|x-api-key|api[_-]?key|access[_-]?token|secret)"\s*:\s*)"[^"]*"`, Replacement: `$1"[REDACTED]"`},
+}
+
+// RateLimitCounter 限流计数快照，重启后恢复当前分钟窗口内的计数
+type RateLimitCounter struct {
+	Key      string `gorm:"primaryKey"` // key:<id> 或 model:<name>
+	Minute   int64  // 计数窗口起点（Unix 分钟）
+	Requests int64
+	Tokens   int64
+}
+
+// BillingRecord 供应商账单/用量导入记录，用于与本地日志对账
+type BillingRecord struct {
+	gorm.Model
+	ProviderName     string  `gorm:"index" json:"provider_name"` // 对应 Provider.Name
+	Month            string  `gorm:"index" json:"month"`         // 账期，格式 2006-01
+	ProviderModel    string  `json:"provider_model"`             // 账单中的模型名，可为空
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	Source           string  `json:"source"` // 导入文件名
+}
+
+// APIKey 推理接口的访问密钥，仅保存哈希，明文只在创建时返回一次
+type APIKey struct {
+	gorm.Model
+	Label         string     `json:"label"`
+	KeyHash       string     `gorm:"uniqueIndex;size:191" json:"-"`
+	KeyPrefix     string     `json:"key_prefix"`                            // 密钥前缀，用于在列表中辨认
+	AllowedModels []string   `gorm:"serializer:json" json:"allowed_models"` // 允许访问的模型，支持通配符，为空表示不限制
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`                  // 过期时间，为空表示永不过期
+	RPM           int        `json:"rpm"`                                   // 每分钟请求数上限，0 表示不限制
+	TPM           int        `json:"tpm"`                                   // 每分钟 token 数上限，0 表示不限制
+
+	TokenQuota  int64   `json:"token_quota"`  // 配额周期内的 token 上限，0 表示不限制
+	CostQuota   float64 `json:"cost_quota"`   // 配额周期内的费用上限，0 表示不限制
+	QuotaPeriod string  `json:"quota_period"` // 配额周期：daily、monthly，为空表示累计到手动重置
+	QuotaWindow string  `json:"quota_window"` // 已用量所属的周期，如 2006-01-02 / 2006-01
+	UsedTokens  int64   `json:"used_tokens"`  // 当前周期已用 token
+	UsedCost    float64 `json:"used_cost"`    // 当前周期已用费用
+
+	LogLevel      string `json:"log_level"`      // 日志详细级别，非空时覆盖模型配置
+	PriorityClass string `json:"priority_class"` // 优先级：interactive、batch，为空表示由请求头决定
+}
+
+// Expired 密钥是否已过期
+func (k APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// AllowsModel 密钥是否允许访问该模型
+func (k APIKey) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 {
+		return true
+	}
+	for _, pattern := range k.AllowedModels {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Pricing 模型-供应商关联的 token 单价（每百万 token）
+type Pricing struct {
+	gorm.Model
+	ModelWithProviderID uint    `gorm:"uniqueIndex" json:"model_provider_id"`
+	InputPrice          float64 `json:"input_price"`  // 输入 token 单价
+	OutputPrice         float64 `json:"output_price"` // 输出 token 单价
+	CachedPrice         float64 `json:"cached_price"` // 命中缓存的输入 token 单价，0 表示按输入单价计算
+}
+
+// UsageRecord 按天聚合的用量，维度为 API Key / 模型 / 供应商
+type UsageRecord struct {
+	ID               uint    `gorm:"primarykey" json:"id"`
+	Date             string  `gorm:"uniqueIndex:idx_usage_dim;size:10" json:"date"` // 2006-01-02
+	APIKeyID         uint    `gorm:"uniqueIndex:idx_usage_dim" json:"api_key_id"`
+	Model            string  `gorm:"uniqueIndex:idx_usage_dim;size:191" json:"model"`
+	ProviderName     string  `gorm:"uniqueIndex:idx_usage_dim;size:191" json:"provider_name"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"` // 按定价表计算，未配置定价时按最近一期账单单价估算
+}
+
+// UserAgentRule 用户代理归一化规则，写入日志前按 Sort 升序匹配，命中第一条即替换为 Label
+type UserAgentRule struct {
+	gorm.Model
+	Pattern string `json:"pattern"` // 正则表达式
+	Label   string `json:"label"`   // 归一化后的标签，可用 $1 引用捕获组
+	Sort    int    `json:"sort"`    // 匹配顺序，值越小越先匹配
+}
+
 // HealthCheckLog 模型健康检测日志
 type HealthCheckLog struct {
 	gorm.Model
@@ -152,3 +497,117 @@ type HealthCheckLog struct {
 	ResponseTime    int64     `json:"response_time"`                  // 响应时间（毫秒）
 	CheckedAt       time.Time `gorm:"index" json:"checked_at"`        // 检测时间
 }
+
+// Notification 通知渠道，关联被自动禁用、健康检测连续失败或错误率突增时推送
+type Notification struct {
+	gorm.Model
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`                          // generic, slack, telegram, feishu, dingtalk
+	URL     string   `json:"url"`                           // webhook 地址，telegram 为 https://api.telegram.org/bot<token>/sendMessage
+	ChatID  string   `json:"chat_id"`                       // telegram 的 chat_id
+	Events  []string `gorm:"serializer:json" json:"events"` // 订阅的事件，为空表示全部
+	Enabled bool     `json:"enabled"`
+}
+
+// ShadowLog 影子流量请求的结果，响应内容丢弃，只记录状态、时延与用量
+type ShadowLog struct {
+	gorm.Model
+	ModelName       string        `gorm:"index" json:"model_name"`
+	ModelProviderID uint          `gorm:"index" json:"model_provider_id"`
+	ProviderName    string        `json:"provider_name"`
+	ProviderModel   string        `json:"provider_model"`
+	Style           string        `json:"style"`
+	Stream          bool          `json:"stream"`
+	Status          string        `gorm:"index" json:"status"` // success, error
+	StatusCode      int           `json:"status_code"`
+	Error           string        `json:"error,omitempty"`
+	FirstChunkTime  time.Duration `json:"first_chunk_time"`
+	Duration        time.Duration `json:"duration"`
+	Tps             float64       `json:"tps"`
+	Usage
+}
+
+// MessageBatch Anthropic Message Batches 批处理任务，各请求异步经常规路由链路执行
+type MessageBatch struct {
+	gorm.Model
+	BatchID           string      `gorm:"uniqueIndex;size:191"` // 对外 ID，msgbatch_ 前缀
+	APIKeyID          uint        `gorm:"index"`                // 创建批处理的 API Key，0 表示使用 TOKEN 或未鉴权
+	Header            http.Header `gorm:"serializer:json"`      // 创建时的 anthropic-* 与 User-Agent 请求头，执行各请求时透传
+	Status            string      `gorm:"index"`                // in_progress, canceling, ended
+	Processing        int
+	Succeeded         int
+	Errored           int
+	Canceled          int
+	Expired           int
+	ExpiresAt         time.Time
+	CancelInitiatedAt *time.Time
+	EndedAt           *time.Time
+}
+
+// MessageBatchItem 批处理中的单个请求及其结果
+type MessageBatchItem struct {
+	gorm.Model
+	BatchID  uint   `gorm:"index"`
+	CustomID string // 调用方指定的请求 ID
+	Params   string // 请求参数，即 /v1/messages 的请求体
+	Status   string `gorm:"index"` // processing, succeeded, errored, canceled, expired
+	Result   string // 结果 JSON：成功时为消息对象，失败时为错误对象
+}
+
+// UploadedFile 经 /v1/files 上传到供应商的文件，对外使用 llmio 的文件 ID，转发时换成上游文件 ID
+type UploadedFile struct {
+	gorm.Model
+	FileID         string `gorm:"uniqueIndex;size:191"` // 对外 ID，file-llmio- 前缀
+	UpstreamFileID string // 供应商返回的文件 ID
+	ProviderID     uint   `gorm:"index"`
+	APIKeyID       uint   `gorm:"index"` // 上传文件的 API Key，0 表示使用 TOKEN 或未鉴权
+	Filename       string
+	Purpose        string
+	Bytes          int64
+}
+
+// ProviderIncident 人工确认的供应商故障，未关闭期间冻结该供应商关联的自动权重与优先级衰减
+type ProviderIncident struct {
+	gorm.Model
+	ProviderID uint   `gorm:"index"`
+	Title      string // 故障标题，如上游状态页的事件名
+	Note       string
+	ClosedAt   *time.Time `gorm:"index"` // 为空表示故障仍在进行中
+}
+
+// LeaderLease 多实例部署时的主节点租约，持有者定期续约，过期后其他实例可接管
+type LeaderLease struct {
+	Name      string    `gorm:"primaryKey;size:191"`
+	Holder    string    // 持有租约的实例 ID
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// MetricsRollup 按小时聚合的请求指标，维度为模型 / 供应商 / 供应商模型，由后台任务从请求日志汇总，日志被清理后仍保留
+type MetricsRollup struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	Hour          time.Time `gorm:"uniqueIndex:idx_rollup_dim" json:"hour"` // 小时起点
+	Model         string    `gorm:"uniqueIndex:idx_rollup_dim;size:191" json:"model"`
+	ProviderName  string    `gorm:"uniqueIndex:idx_rollup_dim;size:191" json:"provider_name"`
+	ProviderModel string    `gorm:"uniqueIndex:idx_rollup_dim;size:191" json:"provider_model"`
+	RollupStats   `gorm:"embedded"`
+}
+
+// RollupStats 一组请求的聚合指标；时延按成功请求统计（毫秒），FirstChunk 为首字时间，Total 为首字与输出耗时之和
+type RollupStats struct {
+	Requests         int64   `json:"requests"` // 按采样权重还原的请求数，含重试
+	Errors           int64   `json:"errors"`
+	Cancelled        int64   `json:"cancelled"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	LatencySamples   int64   `json:"latency_samples"`
+	FirstChunkAvg    float64 `json:"first_chunk_avg"`
+	FirstChunkP50    float64 `json:"first_chunk_p50"`
+	FirstChunkP90    float64 `json:"first_chunk_p90"`
+	FirstChunkP99    float64 `json:"first_chunk_p99"`
+	TotalAvg         float64 `json:"total_avg"`
+	TotalP50         float64 `json:"total_p50"`
+	TotalP90         float64 `json:"total_p90"`
+	TotalP99         float64 `json:"total_p99"`
+}
@@ -0,0 +1,354 @@
+package models
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// MasterKeyEnv 加密供应商密钥的主密钥环境变量：32 字节的 base64 编码，或任意口令（取 SHA-256）
+	MasterKeyEnv = "LLMIO_MASTER_KEY"
+
+	// encryptedSecretPrefix 已加密字段的前缀，格式为 enc:v1:<主密钥加密的数据密钥>:<数据密钥加密的明文>
+	encryptedSecretPrefix = "enc:v1:"
+
+	// secretMask 接口返回时密钥中间部分的掩码
+	secretMask = "****"
+)
+
+// secretConfigKeys 供应商配置中视为密钥的字段，任意层级生效（如 signing.secret）
+var secretConfigKeys = map[string]bool{"api_key": true, "secret": true}
+
+// secretSettingKeys 视为密钥的设置项，脱敏导出时替换为掩码
+var secretSettingKeys = map[string]bool{
+	SettingKeySMTPPassword:      true,
+	SettingKeySLOAlertWebhook:   true,
+	SettingKeyKeyInvalidWebhook: true,
+}
+
+// ErrMasterKeyMissing 数据库中存在加密的密钥但未设置主密钥
+var ErrMasterKeyMissing = errors.New(MasterKeyEnv + " is not set but provider config contains encrypted secrets")
+
+func init() {
+	schema.RegisterSerializer("secret", secretSerializer{})
+}
+
+// secretSerializer 写入数据库前加密配置中的密钥字段，读取时透明解密；未设置主密钥时按明文存储
+type secretSerializer struct{}
+
+func (secretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("unsupported config value type %T", dbValue)
+	}
+	decrypted, err := DecryptConfigSecrets(value)
+	if err != nil {
+		return err
+	}
+	return field.Set(ctx, dst, decrypted)
+}
+
+func (secretSerializer) Value(_ context.Context, _ *schema.Field, _ reflect.Value, fieldValue any) (any, error) {
+	value, _ := fieldValue.(string)
+	key := masterKey()
+	if key == nil {
+		return value, nil
+	}
+	return transformConfigSecrets(value, func(secret string) (string, error) {
+		if strings.HasPrefix(secret, encryptedSecretPrefix) {
+			return secret, nil
+		}
+		return encryptSecret(key, secret)
+	})
+}
+
+// masterKey 读取主密钥，未设置时返回 nil
+func masterKey() []byte {
+	raw := strings.TrimSpace(os.Getenv(MasterKeyEnv))
+	if raw == "" {
+		return nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(raw); err == nil && len(decoded) == 32 {
+		return decoded
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+// MasterKeyConfigured 是否设置了主密钥
+func MasterKeyConfigured() bool {
+	return masterKey() != nil
+}
+
+// DecryptConfigSecrets 解密配置中的密钥字段，明文字段原样保留
+func DecryptConfigSecrets(config string) (string, error) {
+	if !strings.Contains(config, encryptedSecretPrefix) {
+		return config, nil
+	}
+	key := masterKey()
+	if key == nil {
+		return "", ErrMasterKeyMissing
+	}
+	return transformConfigSecrets(config, func(secret string) (string, error) {
+		if !strings.HasPrefix(secret, encryptedSecretPrefix) {
+			return secret, nil
+		}
+		return decryptSecret(key, secret)
+	})
+}
+
+// RedactConfigSecrets 将配置中的密钥字段替换为掩码，仅保留首尾各 4 个字符用于辨认
+func RedactConfigSecrets(config string) string {
+	redacted, err := transformConfigSecrets(config, func(secret string) (string, error) {
+		return maskSecret(secret), nil
+	})
+	if err != nil {
+		return config
+	}
+	return redacted
+}
+
+// RestoreConfigSecrets 将新配置中仍为掩码的密钥字段还原为原配置中的值，使客户端提交脱敏后的配置时不会覆盖密钥
+func RestoreConfigSecrets(config, previous string) string {
+	if !gjson.Valid(config) || !gjson.Valid(previous) {
+		return config
+	}
+	for _, path := range secretPaths(gjson.Parse(config), "") {
+		old := gjson.Get(previous, path)
+		if old.Type != gjson.String || old.String() == "" {
+			continue
+		}
+		if current := gjson.Get(config, path).String(); current != old.String() && current == maskSecret(old.String()) {
+			if restored, err := sjson.Set(config, path, old.String()); err == nil {
+				config = restored
+			}
+		}
+	}
+	return config
+}
+
+// IsSecretSetting 设置项是否包含密码、webhook 地址等凭据
+func IsSecretSetting(key string) bool {
+	return secretSettingKeys[key]
+}
+
+// RedactSetting 将密钥类设置项的非空值替换为掩码，其他设置项原样返回
+func RedactSetting(key, value string) string {
+	if !secretSettingKeys[key] {
+		return value
+	}
+	return RedactSecret(value)
+}
+
+// RestoreSetting 密钥类设置项的新值仍为原值的掩码时还原为原值，使导入脱敏后的配置不会覆盖密钥
+func RestoreSetting(key, value, previous string) string {
+	if !secretSettingKeys[key] {
+		return value
+	}
+	return RestoreSecret(value, previous)
+}
+
+// RedactSecret 将非空的密码或 webhook 地址替换为掩码，仅保留首尾各 4 个字符用于辨认
+func RedactSecret(secret string) string {
+	if secret == "" {
+		return secret
+	}
+	return maskSecret(secret)
+}
+
+// RestoreSecret 新值仍为原值的掩码时还原为原值
+func RestoreSecret(value, previous string) string {
+	if previous != "" && value != previous && value == maskSecret(previous) {
+		return previous
+	}
+	return value
+}
+
+func maskSecret(secret string) string {
+	if len(secret) <= 12 {
+		return secretMask
+	}
+	return secret[:4] + secretMask + secret[len(secret)-4:]
+}
+
+// transformConfigSecrets 对配置 JSON 中非空的密钥字段逐个执行 fn，其余内容与格式保持不变；非 JSON 时原样返回
+func transformConfigSecrets(config string, fn func(string) (string, error)) (string, error) {
+	if !gjson.Valid(config) {
+		return config, nil
+	}
+	for _, path := range secretPaths(gjson.Parse(config), "") {
+		secret := gjson.Get(config, path).String()
+		if secret == "" {
+			continue
+		}
+		out, err := fn(secret)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		if out == secret {
+			continue
+		}
+		if config, err = sjson.Set(config, path, out); err != nil {
+			return "", err
+		}
+	}
+	return config, nil
+}
+
+// secretPaths 列出配置中所有字符串类型密钥字段的 gjson 路径
+func secretPaths(value gjson.Result, prefix string) []string {
+	var paths []string
+	join := func(key string) string {
+		key = gjsonEscaper.Replace(key)
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	if value.IsArray() {
+		for i, item := range value.Array() {
+			paths = append(paths, secretPaths(item, join(strconv.Itoa(i)))...)
+		}
+	} else if value.IsObject() {
+		value.ForEach(func(key, item gjson.Result) bool {
+			if secretConfigKeys[key.String()] && item.Type == gjson.String {
+				paths = append(paths, join(key.String()))
+			} else {
+				paths = append(paths, secretPaths(item, join(key.String()))...)
+			}
+			return true
+		})
+	}
+	return paths
+}
+
+// gjsonEscaper 转义 gjson/sjson 路径中的特殊字符
+var gjsonEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`, "!", `\!`, "=", `\=`, "<", `\<`, ">", `\>`, "%", `\%`)
+
+// encryptSecret 信封加密：随机数据密钥以 AES-GCM 加密明文，主密钥再加密数据密钥
+func encryptSecret(key []byte, plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := sealGCM(key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return encryptedSecretPrefix + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(key []byte, value string) (string, error) {
+	wrappedB64, sealedB64, ok := strings.Cut(strings.TrimPrefix(value, encryptedSecretPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted secret")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(wrappedB64)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(sealedB64)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := openGCM(key, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key, wrong %s?: %w", MasterKeyEnv, err)
+	}
+	plaintext, err := openGCM(dataKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// sealGCM 输出 nonce 与密文拼接的结果
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openGCM(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptProviderConfigs 使用主密钥加密数据库中仍为明文的供应商密钥，返回加密的供应商数量
+func EncryptProviderConfigs(ctx context.Context) (int, error) {
+	if !MasterKeyConfigured() {
+		return 0, errors.New(MasterKeyEnv + " is not set")
+	}
+	var rows []struct {
+		ID     uint
+		Config string
+	}
+	// 不经过序列化器读取原始值，以区分明文与已加密的字段
+	if err := DB.WithContext(ctx).Model(&Provider{}).Select("id", "config").Scan(&rows).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, row := range rows {
+		plaintext := false
+		if _, err := transformConfigSecrets(row.Config, func(secret string) (string, error) {
+			plaintext = plaintext || !strings.HasPrefix(secret, encryptedSecretPrefix)
+			return secret, nil
+		}); err != nil || !plaintext {
+			continue
+		}
+		config, err := DecryptConfigSecrets(row.Config)
+		if err != nil {
+			return count, fmt.Errorf("provider %d: %w", row.ID, err)
+		}
+		if _, err := gorm.G[Provider](DB).Where("id = ?", row.ID).Updates(ctx, Provider{Config: config}); err != nil {
+			return count, fmt.Errorf("provider %d: %w", row.ID, err)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -18,6 +18,8 @@ type Anthropic struct {
 	Beta         string   `json:"beta"`
 	CustomModels []string `json:"custom_models"`
 	Proxy        string   `json:"proxy"`
+
+	Signing *RequestSigning `json:"signing"` // 请求签名，为空表示不签名
 }
 
 func (a *Anthropic) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
@@ -36,6 +38,19 @@ func (a *Anthropic) BuildReq(ctx context.Context, header http.Header, model stri
 	req.Header.Set("x-api-key", a.APIKey)
 	req.Header.Set("anthropic-version", a.Version)
 	req.Header.Set("anthropic-beta", a.Beta)
+	if err := a.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// BuildCountTokensReq 构建 /messages/count_tokens 请求
+func (a *Anthropic) BuildCountTokensReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	req, err := a.BuildReq(ctx, header, model, rawBody)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path += "/count_tokens"
 	return req, nil
 }
 
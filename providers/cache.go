@@ -66,12 +66,23 @@ func GetClient(responseHeaderTimeout time.Duration) *http.Client {
 // GetClientWithProxy returns an http.Client with the specified responseHeaderTimeout and proxy.
 // This creates a new client each time and does not use caching.
 func GetClientWithProxy(responseHeaderTimeout time.Duration, proxyURL string) *http.Client {
+	return newProxyClient(dialer, 10*time.Second, responseHeaderTimeout, proxyURL)
+}
+
+// GetClientWithTimeouts returns an http.Client whose TCP connect and TLS handshake are each bounded by
+// connectTimeout, and whose response headers must arrive within responseHeaderTimeout.
+// A zero timeout means no limit. This creates a new client each time and does not use caching.
+func GetClientWithTimeouts(connectTimeout, responseHeaderTimeout time.Duration, proxyURL string) *http.Client {
+	return newProxyClient(&net.Dialer{Timeout: connectTimeout, KeepAlive: dialer.KeepAlive}, connectTimeout, responseHeaderTimeout, proxyURL)
+}
+
+func newProxyClient(dialer *net.Dialer, tlsHandshakeTimeout, responseHeaderTimeout time.Duration, proxyURL string) *http.Client {
 	transport := &http.Transport{
 		DialContext:           dialer.DialContext,
 		ForceAttemptHTTP2:     false, // 禁用强制HTTP/2，让系统自动协商，避免HTTP/2超时问题
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		ResponseHeaderTimeout: responseHeaderTimeout,
 		DisableKeepAlives:     false, // 保持连接复用以提高性能
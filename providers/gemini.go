@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Gemini Google Gemini generateContent 接口
+type Gemini struct {
+	BaseURL      string   `json:"base_url"` // 如 https://generativelanguage.googleapis.com/v1beta
+	APIKey       string   `json:"api_key"`
+	CustomModels []string `json:"custom_models"`
+	Proxy        string   `json:"proxy"`
+
+	Signing *RequestSigning `json:"signing"` // 请求签名，为空表示不签名
+}
+
+// BuildReq 请求体为 Gemini 原生格式，模型名与是否流式放在 URL 上；
+// 请求体中的 stream 字段（由格式转换写入）只用于选择接口，发送前移除
+func (g *Gemini) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	stream := gjson.GetBytes(rawBody, "stream").Bool()
+	body, err := sjson.DeleteBytes(rawBody, "stream")
+	if err != nil {
+		return nil, err
+	}
+	if body, err = sjson.DeleteBytes(body, "model"); err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent", g.BaseURL, url.PathEscape(model))
+	if stream {
+		endpoint = fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", g.BaseURL, url.PathEscape(model))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", g.APIKey)
+
+	if err := g.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+type geminiModelList struct {
+	Models []struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"models"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (g *Gemini) Models(ctx context.Context) ([]Model, error) {
+	if len(g.CustomModels) > 0 {
+		return buildCustomModels(g.CustomModels), nil
+	}
+
+	// 使用带代理的客户端
+	client := GetClientWithProxy(30*time.Second, g.Proxy)
+	now := time.Now().Unix()
+	models := make([]Model, 0)
+	pageToken := ""
+	for {
+		endpoint := fmt.Sprintf("%s/models?pageSize=1000", g.BaseURL)
+		if pageToken != "" {
+			endpoint += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-goog-api-key", g.APIKey)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("status code: %d", res.StatusCode)
+		}
+
+		var modelList geminiModelList
+		err = json.NewDecoder(res.Body).Decode(&modelList)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range modelList.Models {
+			models = append(models, Model{
+				ID:      strings.TrimPrefix(model.Name, "models/"),
+				Object:  "model",
+				Created: now,
+				OwnedBy: "google",
+			})
+		}
+		if modelList.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = modelList.NextPageToken
+	}
+}
+
+func (g *Gemini) GetProxy() string {
+	return g.Proxy
+}
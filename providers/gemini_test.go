@@ -0,0 +1,81 @@
+package providers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestGemini(t *testing.T) {
+	var gotKeys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKeys = append(gotKeys, r.Header.Get("x-goog-api-key"))
+		if r.URL.Path != "/v1beta/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("pageToken") == "" {
+			w.Write([]byte(`{"models":[{"name":"models/gemini-2.5-pro"}],"nextPageToken":"p2"}`))
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"models/gemini-2.5-flash"}]}`))
+	}))
+	defer server.Close()
+
+	provider, err := New("gemini", `{"base_url":"`+server.URL+`/v1beta","api_key":"g-key"}`, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	models, err := provider.Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models failed: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "gemini-2.5-pro" || models[1].ID != "gemini-2.5-flash" || models[0].OwnedBy != "google" {
+		t.Fatalf("models = %+v", models)
+	}
+	if len(gotKeys) != 2 || gotKeys[0] != "g-key" || gotKeys[1] != "g-key" {
+		t.Errorf("api keys = %v", gotKeys)
+	}
+
+	tests := []struct {
+		name  string
+		model string
+		body  string
+		url   string
+	}{
+		{name: "generate", model: "gemini-2.5-pro", body: `{"model":"gemini-2.5-pro","stream":false,"contents":[]}`, url: server.URL + "/v1beta/models/gemini-2.5-pro:generateContent"},
+		{name: "stream", model: "gemini-2.5-pro", body: `{"model":"gemini-2.5-pro","stream":true,"contents":[]}`, url: server.URL + "/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse"},
+		{name: "escaped model", model: "tuned/model", body: `{"contents":[]}`, url: server.URL + "/v1beta/models/tuned%2Fmodel:generateContent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := provider.BuildReq(context.Background(), nil, tt.model, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("BuildReq failed: %v", err)
+			}
+			if req.URL.String() != tt.url {
+				t.Errorf("url = %s, want %s", req.URL, tt.url)
+			}
+			if req.Header.Get("x-goog-api-key") != "g-key" || req.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("headers = %v", req.Header)
+			}
+			body, _ := io.ReadAll(req.Body)
+			if gjson.GetBytes(body, "model").Exists() || gjson.GetBytes(body, "stream").Exists() || !gjson.GetBytes(body, "contents").Exists() {
+				t.Errorf("body = %s", body)
+			}
+		})
+	}
+
+	custom, err := New("gemini", `{"custom_models":["gemini-exp"]}`, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if models, err := custom.Models(context.Background()); err != nil || len(models) != 1 || models[0].ID != "gemini-exp" {
+		t.Errorf("custom models = %+v, err = %v", models, err)
+	}
+}
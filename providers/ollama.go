@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/sjson"
+)
+
+// DefaultOllamaBaseURL Ollama 默认监听地址
+const DefaultOllamaBaseURL = "http://localhost:11434"
+
+// Ollama 本地推理服务，通过 OpenAI 兼容的 /v1 接口转发，api_key 为空时不发送认证头
+type Ollama struct {
+	BaseURL      string   `json:"base_url"` // 服务根地址，可带或不带 /v1
+	APIKey       string   `json:"api_key"`  // 可选，经反向代理加了认证时填写
+	CustomModels []string `json:"custom_models"`
+	Proxy        string   `json:"proxy"`
+
+	Signing *RequestSigning `json:"signing"` // 请求签名，为空表示不签名
+}
+
+// rootURL 去掉 /v1 后缀的服务根地址，/api/tags 等原生接口挂在根路径下
+func (o *Ollama) rootURL() string {
+	base := strings.TrimRight(o.BaseURL, "/")
+	if base == "" {
+		return DefaultOllamaBaseURL
+	}
+	return strings.TrimSuffix(base, "/v1")
+}
+
+// setAuth 仅在配置了 api_key 时设置认证头
+func (o *Ollama) setAuth(req *http.Request) {
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+	}
+}
+
+func (o *Ollama) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	return o.buildReq(ctx, header, model, rawBody, "/v1/chat/completions")
+}
+
+// BuildEmbeddingsReq 构建 embeddings 请求
+func (o *Ollama) BuildEmbeddingsReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	return o.buildReq(ctx, header, model, rawBody, "/v1/embeddings")
+}
+
+func (o *Ollama) buildReq(ctx context.Context, header http.Header, model string, rawBody []byte, path string) (*http.Request, error) {
+	body, err := sjson.SetBytes(rawBody, "model", model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.rootURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Set("Content-Type", "application/json")
+	o.setAuth(req)
+
+	if err := o.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ollamaTags /api/tags 响应
+type ollamaTags struct {
+	Models []struct {
+		Name       string    `json:"name"`
+		Model      string    `json:"model"`
+		ModifiedAt time.Time `json:"modified_at"`
+	} `json:"models"`
+}
+
+// Models 通过原生 /api/tags 接口列出本地已拉取的模型
+func (o *Ollama) Models(ctx context.Context) ([]Model, error) {
+	if len(o.CustomModels) > 0 {
+		return buildCustomModels(o.CustomModels), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", o.rootURL()+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	o.setAuth(req)
+
+	client := GetClientWithProxy(30*time.Second, o.Proxy)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+
+	var tags ollamaTags
+	if err := json.NewDecoder(res.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	models := make([]Model, 0, len(tags.Models))
+	for _, tag := range tags.Models {
+		id := tag.Model
+		if id == "" {
+			id = tag.Name
+		}
+		models = append(models, Model{ID: id, Object: "model", Created: tag.ModifiedAt.Unix(), OwnedBy: "ollama"})
+	}
+	return models, nil
+}
+
+func (o *Ollama) GetProxy() string {
+	return o.Proxy
+}
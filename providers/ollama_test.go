@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllama(t *testing.T) {
+	var gotAuth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"models":[{"name":"llama3.2:latest","model":"llama3.2:latest","modified_at":"2025-01-02T03:04:05Z"},{"name":"qwen3:8b"}]}`))
+	}))
+	defer server.Close()
+
+	// base_url 带 /v1 时原生接口仍挂在根路径下
+	provider, err := New("ollama", `{"base_url":"`+server.URL+`/v1/"}`, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	models, err := provider.Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models failed: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "llama3.2:latest" || models[1].ID != "qwen3:8b" || models[0].OwnedBy != "ollama" {
+		t.Fatalf("models = %+v", models)
+	}
+
+	req, err := provider.BuildReq(context.Background(), nil, "llama3.2:latest", []byte(`{"messages":[]}`))
+	if err != nil {
+		t.Fatalf("BuildReq failed: %v", err)
+	}
+	if req.URL.String() != server.URL+"/v1/chat/completions" {
+		t.Errorf("url = %s", req.URL)
+	}
+	if req.Header.Get("Authorization") != "" || gotAuth[0] != "" {
+		t.Errorf("expected no authorization header without api_key, got %q / %q", req.Header.Get("Authorization"), gotAuth[0])
+	}
+
+	withKey, err := New("ollama", `{"api_key":"k"}`, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	req, err = withKey.BuildReq(context.Background(), nil, "m", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("BuildReq failed: %v", err)
+	}
+	if req.URL.String() != DefaultOllamaBaseURL+"/v1/chat/completions" || req.Header.Get("Authorization") != "Bearer k" {
+		t.Errorf("url = %s, authorization = %q", req.URL, req.Header.Get("Authorization"))
+	}
+}
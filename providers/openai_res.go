@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/tidwall/sjson"
@@ -17,6 +19,8 @@ type OpenAIRes struct {
 	APIKey       string   `json:"api_key"`
 	CustomModels []string `json:"custom_models"`
 	Proxy        string   `json:"proxy"`
+
+	Signing *RequestSigning `json:"signing"` // 请求签名，为空表示不签名
 }
 
 func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
@@ -34,6 +38,72 @@ func (o *OpenAIRes) BuildReq(ctx context.Context, header http.Header, model stri
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
 
+	if err := o.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// BuildEmbeddingsReq 构建 embeddings 请求，与对话接口共用 base_url
+func (o *OpenAIRes) BuildEmbeddingsReq(ctx context.Context, header http.Header, model string, rawBody []byte) (*http.Request, error) {
+	body, err := sjson.SetBytes(rawBody, "model", model)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/embeddings", o.BaseURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+
+	if err := o.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// BuildRealtimeReq 构建 Realtime API 的 WebSocket 握手请求，与 Responses API 共用 base_url
+func (o *OpenAIRes) BuildRealtimeReq(ctx context.Context, header http.Header, model string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/realtime?model=%s", o.BaseURL, url.QueryEscape(model)), nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+	if req.Header.Get("OpenAI-Beta") == "" {
+		req.Header.Set("OpenAI-Beta", "realtime=v1")
+	}
+
+	if err := o.Signing.Sign(req, nil); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// BuildFilesReq 构建 files 接口请求，上传时保留调用方的 multipart Content-Type
+func (o *OpenAIRes) BuildFilesReq(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/files%s", o.BaseURL, path), reader)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+
+	if err := o.Signing.Sign(req, body); err != nil {
+		return nil, err
+	}
 	return req, nil
 }
 
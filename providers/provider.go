@@ -29,6 +29,34 @@ type Provider interface {
 	GetProxy() string
 }
 
+// TokenCounter 支持上游 token 计数接口的供应商
+type TokenCounter interface {
+	BuildCountTokensReq(ctx context.Context, header http.Header, model string, rawData []byte) (*http.Request, error)
+}
+
+// Embedder 支持 OpenAI 兼容 embeddings 接口的供应商
+type Embedder interface {
+	BuildEmbeddingsReq(ctx context.Context, header http.Header, model string, rawData []byte) (*http.Request, error)
+}
+
+// Filer 支持 OpenAI 兼容 files 接口的供应商，path 为 /files 之后的部分（如 /file-abc/content），body 原样转发
+type Filer interface {
+	BuildFilesReq(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Request, error)
+}
+
+// Realtimer 支持 OpenAI Realtime API 的供应商，返回 WebSocket 握手请求（http/https 地址），握手头由调用方补充
+type Realtimer interface {
+	BuildRealtimeReq(ctx context.Context, header http.Header, model string) (*http.Request, error)
+}
+
+// WireStyle 返回供应商类型实际使用的请求格式，ollama 使用 OpenAI 兼容接口
+func WireStyle(providerType string) string {
+	if providerType == consts.StyleOllama {
+		return consts.StyleOpenAI
+	}
+	return providerType
+}
+
 func buildCustomModels(custom []string) []Model {
 	now := time.Now().Unix()
 	models := make([]Model, 0, len(custom))
@@ -60,6 +88,9 @@ func New(Type, providerConfig, proxy string) (Provider, error) {
 			openai.Proxy = proxy
 		}
 
+		if err := openai.Signing.validate(); err != nil {
+			return nil, err
+		}
 		return &openai, nil
 	case consts.StyleOpenAIRes:
 		var openaiRes OpenAIRes
@@ -70,6 +101,9 @@ func New(Type, providerConfig, proxy string) (Provider, error) {
 			openaiRes.Proxy = proxy
 		}
 
+		if err := openaiRes.Signing.validate(); err != nil {
+			return nil, err
+		}
 		return &openaiRes, nil
 	case consts.StyleAnthropic:
 		var anthropic Anthropic
@@ -79,7 +113,34 @@ func New(Type, providerConfig, proxy string) (Provider, error) {
 		if proxy != "" {
 			anthropic.Proxy = proxy
 		}
+		if err := anthropic.Signing.validate(); err != nil {
+			return nil, err
+		}
 		return &anthropic, nil
+	case consts.StyleGemini:
+		var gemini Gemini
+		if err := json.Unmarshal([]byte(providerConfig), &gemini); err != nil {
+			return nil, errors.New("invalid gemini config")
+		}
+		if proxy != "" {
+			gemini.Proxy = proxy
+		}
+		if err := gemini.Signing.validate(); err != nil {
+			return nil, err
+		}
+		return &gemini, nil
+	case consts.StyleOllama:
+		var ollama Ollama
+		if err := json.Unmarshal([]byte(providerConfig), &ollama); err != nil {
+			return nil, errors.New("invalid ollama config")
+		}
+		if proxy != "" {
+			ollama.Proxy = proxy
+		}
+		if err := ollama.Signing.validate(); err != nil {
+			return nil, err
+		}
+		return &ollama, nil
 	default:
 		return nil, errors.New("unknown provider")
 	}
@@ -0,0 +1,39 @@
+package providers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestRequestSigning(t *testing.T) {
+	provider, err := New("openai", `{"base_url":"http://relay","api_key":"k","signing":{"secret":"s3cret","header":"X-Relay-Signature","prefix":"sha256=","timestamp_header":"X-Relay-Timestamp"}}`, "")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	req, err := provider.BuildReq(context.Background(), nil, "gpt-4", []byte(`{"messages":[]}`))
+	if err != nil {
+		t.Fatalf("BuildReq failed: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	timestamp := req.Header.Get("X-Relay-Timestamp")
+	if timestamp == "" {
+		t.Fatal("Expected timestamp header to be set")
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want, got := "sha256="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Relay-Signature"); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+
+	if _, err := New("anthropic", `{"signing":{"secret":"s","algorithm":"md5"}}`, ""); err == nil {
+		t.Error("Expected unsupported algorithm to be rejected")
+	}
+	if _, err := New("gemini", `{"signing":{"algorithm":"sha512"}}`, ""); err == nil {
+		t.Error("Expected empty secret to be rejected")
+	}
+}
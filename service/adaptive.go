@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	adaptiveAlpha      = 0.2 // EWMA 平滑系数，越大越偏向最近的请求
+	adaptiveMinSamples = 5   // 样本少于该数量的关联不调整权重
+	adaptiveMinFactor  = 0.1 // 最慢的关联至少保留原权重的 10%
+	adaptiveWarmup     = 20  // 首次使用关联时从日志中读取的最近成功请求数
+	adaptiveScale      = 100 // 调整前将权重放大的倍数，保证原权重为 1 时也能按比例分配
+)
+
+// adaptiveStat 单个关联的首字时延（毫秒）与 TPS 的 EWMA
+type adaptiveStat struct {
+	latency    float64
+	tps        float64
+	samples    int
+	tpsSamples int
+}
+
+func (s *adaptiveStat) observe(firstChunk time.Duration, tps float64) {
+	latency := float64(firstChunk) / float64(time.Millisecond)
+	if s.samples == 0 {
+		s.latency = latency
+	} else {
+		s.latency += adaptiveAlpha * (latency - s.latency)
+	}
+	s.samples++
+	if tps > 0 {
+		if s.tpsSamples == 0 {
+			s.tps = tps
+		} else {
+			s.tps += adaptiveAlpha * (tps - s.tps)
+		}
+		s.tpsSamples++
+	}
+}
+
+// adaptiveBalancer 按各关联近期的首字时延与 TPS 自动缩放权重，统计只保存在内存中，
+// 进程启动后首次使用关联时从日志中读取最近的成功请求预热
+type adaptiveBalancer struct {
+	mu     sync.Mutex
+	stats  map[uint]*adaptiveStat
+	warmed map[uint]bool
+}
+
+var adaptiveBalance = newAdaptiveBalancer()
+
+func newAdaptiveBalancer() *adaptiveBalancer {
+	return &adaptiveBalancer{stats: make(map[uint]*adaptiveStat), warmed: make(map[uint]bool)}
+}
+
+// observe 记录一次成功请求的首字时延与 TPS
+func (b *adaptiveBalancer) observe(log models.ChatLog) {
+	if log.ModelWithProviderID == 0 || log.Status != "success" || log.FirstChunkTime <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stat(log.ModelWithProviderID).observe(log.FirstChunkTime, log.Tps)
+}
+
+func (b *adaptiveBalancer) stat(id uint) *adaptiveStat {
+	stat, ok := b.stats[id]
+	if !ok {
+		stat = &adaptiveStat{}
+		b.stats[id] = stat
+	}
+	return stat
+}
+
+// warmup 从日志中读取尚未预热的关联最近的成功请求，按时间顺序计入 EWMA
+func (b *adaptiveBalancer) warmup(ctx context.Context, ids []uint) error {
+	b.mu.Lock()
+	var cold []uint
+	for _, id := range ids {
+		if !b.warmed[id] {
+			cold = append(cold, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, id := range cold {
+		logs, err := gorm.G[models.ChatLog](models.DB).
+			Select("model_with_provider_id", "status", "first_chunk_time", "tps").
+			Where("model_with_provider_id = ? AND status = ? AND first_chunk_time > 0", id, "success").
+			Order("id DESC").Limit(adaptiveWarmup).Find(ctx)
+		if err != nil {
+			return err
+		}
+		slices.Reverse(logs)
+		b.mu.Lock()
+		if !b.warmed[id] {
+			b.warmed[id] = true
+			// 预热前已记录的请求也已写入日志，以日志为准重新计算
+			stat := &adaptiveStat{}
+			for _, log := range logs {
+				stat.observe(log.FirstChunkTime, log.Tps)
+			}
+			b.stats[id] = stat
+		}
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// adjust 按首字时延与 TPS 缩放权重：以候选中最快的关联为基准，时延越高、TPS 越低权重越小，
+// 缩放系数为时延比与 TPS 比的乘积且不低于 adaptiveMinFactor；样本不足的关联按系数 1 处理
+func (b *adaptiveBalancer) adjust(weightItems map[uint]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bestLatency, bestTPS := math.MaxFloat64, 0.0
+	for id := range weightItems {
+		stat, ok := b.stats[id]
+		if !ok || stat.samples < adaptiveMinSamples {
+			continue
+		}
+		bestLatency = min(bestLatency, stat.latency)
+		if stat.tpsSamples >= adaptiveMinSamples {
+			bestTPS = max(bestTPS, stat.tps)
+		}
+	}
+	if bestLatency == math.MaxFloat64 {
+		return
+	}
+	for id, weight := range weightItems {
+		factor := 1.0
+		if stat, ok := b.stats[id]; ok && stat.samples >= adaptiveMinSamples {
+			if stat.latency > 0 {
+				factor *= bestLatency / stat.latency
+			}
+			if bestTPS > 0 && stat.tpsSamples >= adaptiveMinSamples {
+				factor *= stat.tps / bestTPS
+			}
+		}
+		factor = max(factor, adaptiveMinFactor)
+		weightItems[id] = max(int(math.Round(float64(weight*adaptiveScale)*factor)), 1)
+	}
+}
+
+// applyAdaptiveBalancing 开启自适应负载均衡时按近期时延与 TPS 缩放候选权重
+func applyAdaptiveBalancing(ctx context.Context, weightItems map[uint]int) error {
+	if len(weightItems) < 2 || !getAdaptiveBalancing(ctx) {
+		return nil
+	}
+	ids := make([]uint, 0, len(weightItems))
+	for id := range weightItems {
+		ids = append(ids, id)
+	}
+	if err := adaptiveBalance.warmup(ctx, ids); err != nil {
+		return err
+	}
+	adaptiveBalance.adjust(weightItems)
+	return nil
+}
+
+// getAdaptiveBalancing 获取自适应负载均衡开关
+func getAdaptiveBalancing(ctx context.Context) bool {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyAdaptiveBalancing)).First(ctx)
+	if err != nil {
+		return false
+	}
+	return setting.Value == "true"
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestAdaptiveBalancerAdjust(t *testing.T) {
+	b := newAdaptiveBalancer()
+	for range adaptiveMinSamples {
+		b.observe(models.ChatLog{ModelWithProviderID: 1, Status: "success", FirstChunkTime: 200 * time.Millisecond, Tps: 50})
+		b.observe(models.ChatLog{ModelWithProviderID: 2, Status: "success", FirstChunkTime: 800 * time.Millisecond, Tps: 50})
+		b.observe(models.ChatLog{ModelWithProviderID: 3, Status: "success", FirstChunkTime: 200 * time.Millisecond, Tps: 25})
+		b.observe(models.ChatLog{ModelWithProviderID: 4, Status: "error", FirstChunkTime: time.Minute})
+	}
+	weights := map[uint]int{1: 1, 2: 1, 3: 1, 4: 1}
+	b.adjust(weights)
+	want := map[uint]int{1: 100, 2: 25, 3: 50, 4: 100}
+	for id, weight := range want {
+		if weights[id] != weight {
+			t.Errorf("weights = %v, want %v", weights, want)
+			break
+		}
+	}
+
+	// 极慢的关联保留最低比例的权重
+	for range 20 {
+		b.observe(models.ChatLog{ModelWithProviderID: 2, Status: "success", FirstChunkTime: time.Minute, Tps: 1})
+	}
+	weights = map[uint]int{1: 2, 2: 2}
+	b.adjust(weights)
+	if weights[1] != 200 || weights[2] != 20 {
+		t.Errorf("weights = %v, want min factor applied", weights)
+	}
+}
+
+func TestAdaptiveBalancingWarmup(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	adaptiveBalance = newAdaptiveBalancer()
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeyAdaptiveBalancing)).Update("value", "true").Error; err != nil {
+		t.Fatal(err)
+	}
+	var logs []models.ChatLog
+	for range adaptiveMinSamples {
+		logs = append(logs,
+			models.ChatLog{Status: "success", ModelWithProviderID: 1, FirstChunkTime: 100 * time.Millisecond},
+			models.ChatLog{Status: "success", ModelWithProviderID: 2, FirstChunkTime: 400 * time.Millisecond},
+		)
+	}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	weights := map[uint]int{1: 3, 2: 3}
+	if err := applyAdaptiveBalancing(ctx, weights); err != nil {
+		t.Fatal(err)
+	}
+	if weights[1] != 300 || weights[2] != 75 {
+		t.Errorf("weights = %v, want scaled by latency", weights)
+	}
+}
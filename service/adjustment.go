@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"strconv"
 
@@ -94,7 +95,7 @@ func applyPriorityIncreaseByID(ctx context.Context, modelProviderID uint) {
 // getAutoWeightIncreaseStep 获取自动权重增加步长
 func getAutoWeightIncreaseStep(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightIncreaseStep).
+		Where(models.ByKey(models.SettingKeyAutoWeightIncreaseStep)).
 		First(ctx)
 	if err != nil {
 		return 1
@@ -109,7 +110,7 @@ func getAutoWeightIncreaseStep(ctx context.Context) int {
 // getAutoWeightIncreaseMax 获取自动权重增加上限
 func getAutoWeightIncreaseMax(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightIncreaseMax).
+		Where(models.ByKey(models.SettingKeyAutoWeightIncreaseMax)).
 		First(ctx)
 	if err != nil {
 		return 100
@@ -124,7 +125,7 @@ func getAutoWeightIncreaseMax(ctx context.Context) int {
 // getAutoPriorityIncreaseStep 获取自动优先级增加步长
 func getAutoPriorityIncreaseStep(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityIncreaseStep).
+		Where(models.ByKey(models.SettingKeyAutoPriorityIncreaseStep)).
 		First(ctx)
 	if err != nil {
 		return 1
@@ -139,7 +140,7 @@ func getAutoPriorityIncreaseStep(ctx context.Context) int {
 // getAutoPriorityIncreaseMax 获取自动优先级增加上限
 func getAutoPriorityIncreaseMax(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityIncreaseMax).
+		Where(models.ByKey(models.SettingKeyAutoPriorityIncreaseMax)).
 		First(ctx)
 	if err != nil {
 		return 100
@@ -154,7 +155,7 @@ func getAutoPriorityIncreaseMax(ctx context.Context) int {
 // getAutoSuccessIncrease 获取成功自增开关
 func getAutoSuccessIncrease(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoSuccessIncrease).
+		Where(models.ByKey(models.SettingKeyAutoSuccessIncrease)).
 		First(ctx)
 	if err != nil {
 		return true
@@ -173,6 +174,10 @@ func applyWeightDecayByModelProviderID(ctx context.Context, modelProviderID uint
 	if err != nil {
 		return
 	}
+	if decayFrozen(ctx, mp.ProviderID) {
+		slog.Info("weight decay skipped during provider incident", "provider", providerName, "model", providerModel, "id", modelProviderID)
+		return
+	}
 
 	newWeight := mp.Weight - decayStep
 	if newWeight < 1 {
@@ -206,6 +211,11 @@ func applyPriorityDecayByModelProviderID(ctx context.Context, modelProviderID ui
 	if err != nil {
 		return
 	}
+	// 已确认的故障期间不衰减，也不自动禁用
+	if decayFrozen(ctx, mp.ProviderID) {
+		slog.Info("priority decay skipped during provider incident", "provider", providerName, "model", providerModel, "id", modelProviderID)
+		return
+	}
 
 	newPriority := mp.Priority - decayStep
 	if newPriority < 0 {
@@ -233,6 +243,9 @@ func applyPriorityDecayByModelProviderID(ctx context.Context, modelProviderID ui
 			slog.Error("auto disable model provider error", "error", err, "id", modelProviderID)
 		} else {
 			slog.Warn("model provider auto disabled due to low priority", "provider", providerName, "model", providerModel, "priority", newPriority, "threshold", threshold)
+			if mp.Status == nil || *mp.Status {
+				notifyAutoDisabled(ctx, modelProviderID, "", providerName, providerModel, fmt.Sprintf("priority decayed to %d (threshold %d)", newPriority, threshold))
+			}
 		}
 	}
 }
@@ -240,7 +253,7 @@ func applyPriorityDecayByModelProviderID(ctx context.Context, modelProviderID ui
 // shouldCountHealthCheckSuccess 健康检测成功是否计入成功调用
 func shouldCountHealthCheckSuccess(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsSuccess).
+		Where(models.ByKey(models.SettingKeyHealthCheckCountAsSuccess)).
 		First(ctx)
 	if err != nil {
 		return true
@@ -251,7 +264,7 @@ func shouldCountHealthCheckSuccess(ctx context.Context) bool {
 // shouldCountHealthCheckFailure 健康检测失败是否计入失败调用
 func shouldCountHealthCheckFailure(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckCountAsFailure).
+		Where(models.ByKey(models.SettingKeyHealthCheckCountAsFailure)).
 		First(ctx)
 	if err != nil {
 		return false
@@ -262,7 +275,7 @@ func shouldCountHealthCheckFailure(ctx context.Context) bool {
 // getAutoPriorityDecayDisableEnabled 获取自动优先级衰减禁用开关
 func getAutoPriorityDecayDisableEnabled(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayDisableEnabled).
+		Where(models.ByKey(models.SettingKeyAutoPriorityDecayDisableEnabled)).
 		First(ctx)
 	if err != nil {
 		return true // 默认启用自动禁用功能
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAdvisorDays     = 7
+	defaultAdvisorInterval = 24 // 小时
+	advisorMinSamples      = 20 // 样本数不足时不给出调整建议
+	advisorMaxWeight       = 100
+	advisorHealthyRate     = 0.9 // 成功率低于此值视为降级
+	advisorPriorityPenalty = 10  // 降级关联建议降低的优先级
+	advisorCheckInterval   = time.Hour
+)
+
+// AssociationStats 单个模型-供应商关联在统计窗口内的表现
+type AssociationStats struct {
+	Requests        int64   `json:"requests"`
+	SuccessRate     float64 `json:"success_rate"`
+	AvgFirstChunkMs float64 `json:"avg_first_chunk_ms"`
+	PricePerMillion float64 `json:"price_per_million,omitempty"` // 取自最近一期账单，未导入账单时为 0
+}
+
+// WeightSuggestion 权重/优先级调整建议
+type WeightSuggestion struct {
+	ModelWithProviderID uint             `json:"model_provider_id"`
+	Model               string           `json:"model"`
+	Provider            string           `json:"provider"`
+	ProviderModel       string           `json:"provider_model"`
+	Stats               AssociationStats `json:"stats"`
+	CurrentWeight       int              `json:"current_weight"`
+	SuggestedWeight     int              `json:"suggested_weight"`
+	CurrentPriority     int              `json:"current_priority"`
+	SuggestedPriority   int              `json:"suggested_priority"`
+	Reasons             []string         `json:"reasons"`
+}
+
+// Changed 建议是否与当前配置不同
+func (s WeightSuggestion) Changed() bool {
+	return s.SuggestedWeight != s.CurrentWeight || s.SuggestedPriority != s.CurrentPriority
+}
+
+type associationRow struct {
+	Requests      int64
+	Successes     int64
+	AvgFirstChunk float64
+}
+
+// SuggestWeights 基于最近 days 天的成功率、首字时延与账单单价为每个启用的关联给出权重与优先级建议
+func SuggestWeights(ctx context.Context, days int) ([]WeightSuggestion, error) {
+	if days <= 0 {
+		days = defaultAdvisorDays
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	llmModels, err := gorm.G[models.Model](models.DB).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providerList, err := gorm.G[models.Provider](models.DB).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providerMap := make(map[uint]models.Provider, len(providerList))
+	for _, provider := range providerList {
+		providerMap[provider.ID] = provider
+	}
+	prices, err := latestProviderPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]WeightSuggestion, 0)
+	for _, model := range llmModels {
+		associations, err := gorm.G[models.ModelWithProvider](models.DB).
+			Where("model_id = ? AND status = ?", model.ID, true).
+			Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		group := make([]WeightSuggestion, 0, len(associations))
+		for _, mp := range associations {
+			provider, ok := providerMap[mp.ProviderID]
+			if !ok {
+				continue
+			}
+			var row associationRow
+			if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+				Select("COALESCE(SUM(sample_weight), 0) AS requests, "+
+					"COALESCE(SUM(CASE WHEN status = 'success' THEN sample_weight ELSE 0 END), 0) AS successes, "+
+					"COALESCE(AVG(CASE WHEN status = 'success' THEN first_chunk_time END), 0) AS avg_first_chunk").
+				Where("name = ? AND provider_name = ? AND provider_model = ?", model.Name, provider.Name, mp.ProviderModel).
+				Where("created_at >= ?", since).
+				Where("status <> ?", "cancelled").
+				Scan(&row).Error; err != nil {
+				return nil, err
+			}
+
+			stats := AssociationStats{
+				Requests:        row.Requests,
+				AvgFirstChunkMs: row.AvgFirstChunk / float64(time.Millisecond),
+				PricePerMillion: prices[provider.Name],
+			}
+			if row.Requests > 0 {
+				stats.SuccessRate = float64(row.Successes) / float64(row.Requests)
+			}
+			group = append(group, WeightSuggestion{
+				ModelWithProviderID: mp.ID,
+				Model:               model.Name,
+				Provider:            provider.Name,
+				ProviderModel:       mp.ProviderModel,
+				Stats:               stats,
+				CurrentWeight:       mp.Weight,
+				SuggestedWeight:     mp.Weight,
+				CurrentPriority:     mp.Priority,
+				SuggestedPriority:   mp.Priority,
+				Reasons:             []string{},
+			})
+		}
+		suggestions = append(suggestions, scoreAssociations(group, days)...)
+	}
+	return suggestions, nil
+}
+
+// scoreAssociations 在同一模型的关联之间比较表现：
+// 得分 = 成功率² × (最低时延/自身时延) × (最低单价/自身单价)，权重按得分线性映射到 1~100；
+// 成功率低于阈值的关联建议降低优先级，让健康的关联优先承接流量；不会主动提升优先级，以免打乱手动设置的备用梯队
+func scoreAssociations(group []WeightSuggestion, days int) []WeightSuggestion {
+	minLatency, minPrice := math.MaxFloat64, math.MaxFloat64
+	maxPriority := 0
+	for _, s := range group {
+		maxPriority = max(maxPriority, s.CurrentPriority)
+		if s.Stats.Requests < advisorMinSamples {
+			continue
+		}
+		if s.Stats.AvgFirstChunkMs > 0 {
+			minLatency = min(minLatency, s.Stats.AvgFirstChunkMs)
+		}
+		if s.Stats.PricePerMillion > 0 {
+			minPrice = min(minPrice, s.Stats.PricePerMillion)
+		}
+	}
+
+	scores := make([]float64, len(group))
+	maxScore := 0.0
+	for i, s := range group {
+		if s.Stats.Requests < advisorMinSamples {
+			continue
+		}
+		score := s.Stats.SuccessRate * s.Stats.SuccessRate
+		if s.Stats.AvgFirstChunkMs > 0 && minLatency < math.MaxFloat64 {
+			score *= minLatency / s.Stats.AvgFirstChunkMs
+		}
+		if s.Stats.PricePerMillion > 0 && minPrice < math.MaxFloat64 {
+			score *= minPrice / s.Stats.PricePerMillion
+		}
+		scores[i] = score
+		maxScore = max(maxScore, score)
+	}
+
+	for i := range group {
+		s := &group[i]
+		if s.Stats.Requests < advisorMinSamples {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("only %d requests in the last %d days, keeping current settings", s.Stats.Requests, days))
+			continue
+		}
+
+		s.Reasons = append(s.Reasons, fmt.Sprintf("success rate %.1f%%, avg first chunk %.0fms", s.Stats.SuccessRate*100, s.Stats.AvgFirstChunkMs))
+		if s.Stats.PricePerMillion > 0 {
+			s.Reasons = append(s.Reasons, fmt.Sprintf("billed price %.4f per 1M tokens", s.Stats.PricePerMillion))
+		}
+
+		if maxScore > 0 {
+			s.SuggestedWeight = max(1, int(math.Round(scores[i]/maxScore*advisorMaxWeight)))
+		} else {
+			s.SuggestedWeight = 1
+		}
+		if scores[i] == maxScore && maxScore > 0 {
+			s.Reasons = append(s.Reasons, "best score among providers of this model")
+		}
+
+		if s.Stats.SuccessRate < advisorHealthyRate {
+			s.SuggestedPriority = max(0, min(s.CurrentPriority, maxPriority-advisorPriorityPenalty))
+			s.Reasons = append(s.Reasons, fmt.Sprintf("success rate below %.0f%%, lowering priority", advisorHealthyRate*100))
+		}
+	}
+	return group
+}
+
+// latestProviderPrices 取每个供应商最近一期账单的有效单价（每百万 token）
+func latestProviderPrices(ctx context.Context) (map[string]float64, error) {
+	records, err := gorm.G[models.BillingRecord](models.DB).Order("month DESC").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]string)
+	costs := make(map[string]float64)
+	tokens := make(map[string]int64)
+	for _, record := range records {
+		month, ok := latest[record.ProviderName]
+		if !ok {
+			latest[record.ProviderName] = record.Month
+			month = record.Month
+		}
+		if record.Month != month {
+			continue
+		}
+		costs[record.ProviderName] += record.Cost
+		tokens[record.ProviderName] += record.TotalTokens
+	}
+	prices := make(map[string]float64, len(costs))
+	for name, cost := range costs {
+		prices[name] = pricePerMillion(cost, tokens[name])
+	}
+	return prices, nil
+}
+
+// ApplyWeightSuggestions 应用有变化的建议，返回更新的关联数
+func ApplyWeightSuggestions(ctx context.Context, suggestions []WeightSuggestion) (int, error) {
+	applied := 0
+	for _, s := range suggestions {
+		if !s.Changed() {
+			continue
+		}
+		// 使用 map 更新，避免优先级为 0 时被忽略
+		if err := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).
+			Where("id = ?", s.ModelWithProviderID).
+			Updates(map[string]any{"weight": s.SuggestedWeight, "priority": s.SuggestedPriority}).Error; err != nil {
+			return applied, err
+		}
+		slog.Info("weight suggestion applied", "id", s.ModelWithProviderID, "model", s.Model, "provider", s.Provider,
+			"weight", s.SuggestedWeight, "priority", s.SuggestedPriority, "reasons", strings.Join(s.Reasons, "; "))
+		applied++
+	}
+	return applied, nil
+}
+
+// StartWeightAdvisor 启用自动应用时按设置的间隔定期应用权重建议
+func StartWeightAdvisor(ctx context.Context) {
+	ticker := time.NewTicker(advisorCheckInterval)
+	defer ticker.Stop()
+	var lastRun time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			autoApply, interval := GetAdvisorSettings(ctx)
+			if !autoApply || time.Since(lastRun) < time.Duration(interval)*time.Hour {
+				continue
+			}
+			lastRun = time.Now()
+			suggestions, err := SuggestWeights(ctx, defaultAdvisorDays)
+			if err != nil {
+				slog.Error("weight advisor error", "error", err)
+				continue
+			}
+			applied, err := ApplyWeightSuggestions(ctx, suggestions)
+			if err != nil {
+				slog.Error("weight advisor apply error", "error", err)
+			}
+			slog.Info("weight advisor run", "applied", applied)
+		}
+	}
+}
+
+// GetAdvisorSettings 获取权重建议的自动应用设置
+func GetAdvisorSettings(ctx context.Context) (autoApply bool, intervalHours int) {
+	intervalHours = defaultAdvisorInterval
+	settings, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyWeightAdvisorAutoApply, models.SettingKeyWeightAdvisorInterval)).
+		Find(ctx)
+	if err != nil {
+		return false, intervalHours
+	}
+	for _, setting := range settings {
+		switch setting.Key {
+		case models.SettingKeyWeightAdvisorAutoApply:
+			autoApply = setting.Value == "true"
+		case models.SettingKeyWeightAdvisorInterval:
+			if val, err := strconv.Atoi(setting.Value); err == nil && val > 0 {
+				intervalHours = val
+			}
+		}
+	}
+	return autoApply, intervalHours
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestScoreAssociations(t *testing.T) {
+	suggestion := func(requests int64, successRate, latencyMs, price float64) WeightSuggestion {
+		return WeightSuggestion{
+			Stats:             AssociationStats{Requests: requests, SuccessRate: successRate, AvgFirstChunkMs: latencyMs, PricePerMillion: price},
+			CurrentWeight:     50,
+			SuggestedWeight:   50,
+			CurrentPriority:   100,
+			SuggestedPriority: 100,
+			Reasons:           []string{},
+		}
+	}
+	tests := []struct {
+		name       string
+		group      []WeightSuggestion
+		weights    []int
+		priorities []int
+	}{
+		{
+			name:       "latency and success rate",
+			group:      []WeightSuggestion{suggestion(100, 1, 100, 0), suggestion(100, 0.5, 200, 0)},
+			weights:    []int{100, 13},
+			priorities: []int{100, 90},
+		},
+		{
+			name:       "cheaper provider wins",
+			group:      []WeightSuggestion{suggestion(100, 1, 100, 2), suggestion(100, 1, 100, 1)},
+			weights:    []int{50, 100},
+			priorities: []int{100, 100},
+		},
+		{
+			name:       "too few samples keep current settings",
+			group:      []WeightSuggestion{suggestion(100, 1, 100, 0), suggestion(advisorMinSamples-1, 0, 0, 0)},
+			weights:    []int{100, 50},
+			priorities: []int{100, 100},
+		},
+		{
+			name:       "all failing",
+			group:      []WeightSuggestion{suggestion(100, 0, 0, 0)},
+			weights:    []int{1},
+			priorities: []int{90},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := scoreAssociations(tt.group, 7)
+			for i, s := range result {
+				if s.SuggestedWeight != tt.weights[i] || s.SuggestedPriority != tt.priorities[i] {
+					t.Errorf("[%d] weight = %d, priority = %d, want %d, %d (reasons %v)", i, s.SuggestedWeight, s.SuggestedPriority, tt.weights[i], tt.priorities[i], s.Reasons)
+				}
+				if len(s.Reasons) == 0 {
+					t.Errorf("[%d] missing reasons", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestWeights(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	model := testutil.SeedModel(t, "advisor-model")
+	fast := testutil.SeedProvider(t, "fast", consts.StyleOpenAI, "http://127.0.0.1:1")
+	flaky := testutil.SeedProvider(t, "flaky", consts.StyleOpenAI, "http://127.0.0.1:1")
+	fastAssoc := testutil.SeedAssociation(t, model, fast, "m-fast", 100, 1)
+	flakyAssoc := testutil.SeedAssociation(t, model, flaky, "m-flaky", 100, 1)
+
+	var logs []models.ChatLog
+	for range 10 {
+		// 采样记录按 SampleWeight 计入请求数
+		logs = append(logs, models.ChatLog{Name: model.Name, ProviderName: fast.Name, ProviderModel: "m-fast", Status: "success", SampleWeight: 2, FirstChunkTime: 100 * time.Millisecond})
+	}
+	for i := range 20 {
+		status := "error"
+		if i%2 == 0 {
+			status = "success"
+		}
+		logs = append(logs, models.ChatLog{Name: model.Name, ProviderName: flaky.Name, ProviderModel: "m-flaky", Status: status, SampleWeight: 1, FirstChunkTime: 200 * time.Millisecond})
+	}
+	logs = append(logs, models.ChatLog{Name: model.Name, ProviderName: flaky.Name, ProviderModel: "m-flaky", Status: "cancelled", SampleWeight: 1})
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatalf("seed logs: %v", err)
+	}
+	billing := []models.BillingRecord{
+		{ProviderName: fast.Name, Month: "2026-09", TotalTokens: 1_000_000, Cost: 1},
+		{ProviderName: fast.Name, Month: "2026-08", TotalTokens: 1_000_000, Cost: 100},
+	}
+	if err := models.DB.Create(&billing).Error; err != nil {
+		t.Fatalf("seed billing: %v", err)
+	}
+
+	suggestions, err := SuggestWeights(ctx, 7)
+	if err != nil {
+		t.Fatalf("SuggestWeights failed: %v", err)
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("suggestions = %+v", suggestions)
+	}
+	byProvider := make(map[string]WeightSuggestion)
+	for _, s := range suggestions {
+		byProvider[s.Provider] = s
+	}
+	if s := byProvider[fast.Name]; s.Stats != (AssociationStats{Requests: 20, SuccessRate: 1, AvgFirstChunkMs: 100, PricePerMillion: 1}) || s.SuggestedWeight != 100 || s.SuggestedPriority != 100 {
+		t.Errorf("fast = %+v", s)
+	}
+	if s := byProvider[flaky.Name]; s.Stats.Requests != 20 || s.Stats.SuccessRate != 0.5 || s.SuggestedWeight != 13 || s.SuggestedPriority != 90 {
+		t.Errorf("flaky = %+v", s)
+	}
+
+	applied, err := ApplyWeightSuggestions(ctx, suggestions)
+	if err != nil || applied != 2 {
+		t.Fatalf("applied = %d, err = %v", applied, err)
+	}
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Order("id").Find(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{associations[0].Weight, associations[0].Priority, associations[1].Weight, associations[1].Priority}
+	if associations[0].ID != fastAssoc.ID || associations[1].ID != flakyAssoc.ID || !slices.Equal(got, []int{100, 100, 13, 90}) {
+		t.Errorf("associations after apply = %v", got)
+	}
+	if applied, err := ApplyWeightSuggestions(ctx, []WeightSuggestion{{CurrentWeight: 1, SuggestedWeight: 1}}); err != nil || applied != 0 {
+		t.Errorf("unchanged suggestion applied = %d, err = %v", applied, err)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/redis/go-redis/v9"
+	"github.com/tidwall/gjson"
+)
+
+// SessionHeader 客户端指定会话标识的请求头，未提供时按首条用户消息识别会话
+const SessionHeader = "X-Session-ID"
+
+// defaultStickySessionTTL 模型未配置时会话粘滞的有效期
+const defaultStickySessionTTL = 30 * time.Minute
+
+// maxAffinityEntries 粘滞缓存条目上限，超出时先清理过期条目
+const maxAffinityEntries = 100000
+
+type affinityEntry struct {
+	id        uint
+	expiresAt time.Time
+}
+
+// affinityCache 会话到模型-供应商关联的映射，带 TTL；配置 Redis 时保存在 Redis 中，由各实例共享
+type affinityCache struct {
+	mu      sync.Mutex
+	entries map[string]affinityEntry
+}
+
+var sessionAffinity = &affinityCache{entries: make(map[string]affinityEntry)}
+
+func (c *affinityCache) get(key string) (uint, bool) {
+	if redisClient != nil {
+		id, err := redisClient.Get(context.Background(), redisKey("affinity", key)).Uint64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			slog.Error("redis session affinity lookup failed", "error", err)
+		}
+		return uint(id), err == nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return 0, false
+	}
+	return entry.id, true
+}
+
+func (c *affinityCache) set(key string, id uint, ttl time.Duration) {
+	if redisClient != nil {
+		if err := redisClient.Set(context.Background(), redisKey("affinity", key), strconv.FormatUint(uint64(id), 10), ttl).Err(); err != nil {
+			slog.Error("redis session affinity update failed", "error", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxAffinityEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// 仍然超限时放弃写入，等待条目过期
+		if len(c.entries) >= maxAffinityEntries {
+			return
+		}
+	}
+	c.entries[key] = affinityEntry{id: id, expiresAt: now.Add(ttl)}
+}
+
+// stickySessionTTL 模型配置的粘滞有效期（秒），0 使用默认值
+func stickySessionTTL(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultStickySessionTTL
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// sessionID 识别请求所属会话：优先使用 X-Session-ID，否则取首条用户消息的摘要，无法识别时返回空
+func sessionID(style string, before Before, header http.Header) string {
+	if session := header.Get(SessionHeader); session != "" {
+		return session
+	}
+	first := firstUserMessage(style, before.raw)
+	if first == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(first))
+	return "msg:" + hex.EncodeToString(sum[:])
+}
+
+// sessionAffinityKey 计算会话粘滞的缓存键，会话无法识别时返回空
+func sessionAffinityKey(model, session string) string {
+	if session == "" {
+		return ""
+	}
+	return model + "\x00" + session
+}
+
+// firstUserMessage 提取首条用户消息的原始 JSON
+func firstUserMessage(style string, raw []byte) string {
+	switch style {
+	case consts.StyleOpenAI, consts.StyleAnthropic:
+		return gjson.GetBytes(raw, `messages.#(role=="user").content`).Raw
+	case consts.StyleOpenAIRes:
+		input := gjson.GetBytes(raw, "input")
+		if input.Type == gjson.String {
+			return input.Raw
+		}
+		return input.Get(`#(role=="user").content`).Raw
+	}
+	return ""
+}
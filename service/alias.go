@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// 别名匹配优先级，数值越大越优先；同一优先级中更长的别名优先
+const (
+	aliasMatchGlob   = iota + 1 // 含 * 或 ? 的通配符，如 claude-3-5-*-latest
+	aliasMatchPrefix            // 只在末尾带 *，如 gpt-4o*
+	aliasMatchExact             // 不含通配符
+)
+
+// ValidateAliasPattern 校验别名：不能为空、不能只有通配符、不能包含空白字符
+func ValidateAliasPattern(pattern string) error {
+	if pattern == "" || strings.Trim(pattern, "*?") == "" {
+		return fmt.Errorf("alias %q must contain a literal part", pattern)
+	}
+	if strings.ContainsAny(pattern, " \t\r\n") {
+		return fmt.Errorf("alias %q must not contain whitespace", pattern)
+	}
+	return nil
+}
+
+// matchAlias 返回别名匹配模型名时的优先级，不匹配时返回 0
+func matchAlias(pattern, name string) int {
+	if !strings.ContainsAny(pattern, "*?") {
+		if pattern == name {
+			return aliasMatchExact
+		}
+		return 0
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?") {
+		if strings.HasPrefix(name, prefix) {
+			return aliasMatchPrefix
+		}
+		return 0
+	}
+	if globMatch(pattern, name) {
+		return aliasMatchGlob
+	}
+	return 0
+}
+
+// globMatch * 匹配任意个字符（包括 /），? 匹配单个字符
+func globMatch(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	pi, ni := 0, 0
+	star, mark := -1, 0
+	for ni < len(n) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == n[ni]):
+			pi++
+			ni++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, ni
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			ni = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// FindModelByAlias 按别名查找模型，优先级为完全相同 > 前缀 > 通配符，同一优先级中更长的别名优先，仍相同时取先创建的模型
+func FindModelByAlias(ctx context.Context, name string) (*models.Model, error) {
+	candidates, err := gorm.G[models.Model](models.DB).Select("id", "name", "aliases").Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var best *models.Model
+	bestRank, bestLen := 0, 0
+	for i, model := range candidates {
+		for _, pattern := range model.Aliases {
+			rank := matchAlias(pattern, name)
+			if rank == 0 {
+				continue
+			}
+			if rank > bestRank || (rank == bestRank && len(pattern) > bestLen) {
+				best, bestRank, bestLen = &candidates[i], rank, len(pattern)
+			}
+		}
+	}
+	if best == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return best, nil
+}
+
+// ResolveModelAlias 请求的模型名不存在时按别名路由到配置的模型，改写 before 中的模型名与请求体
+func ResolveModelAlias(ctx context.Context, before *Before) error {
+	count, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).Count(ctx, "id")
+	if err != nil || count > 0 {
+		return err
+	}
+	model, err := FindModelByAlias(ctx, before.Model)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	raw, err := sjson.SetBytes(before.raw, "model", model.Name)
+	if err != nil {
+		return err
+	}
+	slog.Debug("model resolved by alias", "requested", before.Model, "model", model.Name)
+	before.Model, before.raw = model.Name, raw
+	return nil
+}
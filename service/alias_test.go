@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestMatchAlias(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          int
+	}{
+		{"gpt-4o", "gpt-4o", aliasMatchExact},
+		{"gpt-4o", "gpt-4o-mini", 0},
+		{"gpt-4o*", "gpt-4o-2024-08-06", aliasMatchPrefix},
+		{"gpt-4o*", "gpt-4", 0},
+		{"claude-3-5-*-latest", "claude-3-5-sonnet-latest", aliasMatchGlob},
+		{"claude-3-5-*-latest", "claude-3-5-sonnet-20241022", 0},
+		{"*/llama-3?", "meta/llama-31", aliasMatchGlob},
+	}
+	for _, tt := range tests {
+		if got := matchAlias(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchAlias(%q, %q) = %d, want %d", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+	if err := ValidateAliasPattern("**"); err == nil {
+		t.Error("Expected wildcard-only alias to be rejected")
+	}
+}
+
+func TestResolveModelAlias(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	for name, aliases := range map[string][]string{
+		"glob":   {"gpt-4o*-mini*"},
+		"prefix": {"gpt-4o*"},
+		"exact":  {"gpt-4o-mini"},
+		"longer": {"gpt-4o-2024*"},
+	} {
+		model := testutil.SeedModel(t, name)
+		model.Aliases = aliases
+		if err := models.DB.Model(&model).Select("aliases").Updates(&model).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]string{
+		"gpt-4o-mini":            "exact",
+		"gpt-4o-mini-2024-07-18": "prefix",
+		"gpt-4o-2024-08-06":      "longer",
+		"glob":                   "glob",
+		"claude-3-opus":          "claude-3-opus",
+	}
+	for requested, want := range tests {
+		before := &Before{Model: requested, raw: []byte(`{"model":"` + requested + `"}`)}
+		if err := ResolveModelAlias(ctx, before); err != nil {
+			t.Fatalf("ResolveModelAlias(%s) failed: %v", requested, err)
+		}
+		if before.Model != want || string(before.raw) != `{"model":"`+want+`"}` {
+			t.Errorf("ResolveModelAlias(%s) = %s (%s), want %s", requested, before.Model, before.raw, want)
+		}
+	}
+}
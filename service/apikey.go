@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix      = "sk-llmio-"
+	apiKeyRandomBytes = 24
+	apiKeyShownLength = len(apiKeyPrefix) + 6
+)
+
+// GenerateAPIKey 生成新的 API Key，返回明文、哈希与用于展示的前缀
+func GenerateAPIKey() (plain, hash, prefix string, err error) {
+	buf := make([]byte, apiKeyRandomBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	plain = apiKeyPrefix + hex.EncodeToString(buf)
+	return plain, HashAPIKey(plain), plain[:apiKeyShownLength], nil
+}
+
+// HashAPIKey 计算 API Key 的哈希，数据库中只保存哈希
+func HashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// LookupAPIKey 按明文查找 API Key，不存在时返回 gorm.ErrRecordNotFound
+func LookupAPIKey(ctx context.Context, plain string) (*models.APIKey, error) {
+	key, err := gorm.G[models.APIKey](models.DB).Where("key_hash = ?", HashAPIKey(plain)).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// HasAPIKeys 是否创建过 API Key
+func HasAPIKeys(ctx context.Context) (bool, error) {
+	count, err := gorm.G[models.APIKey](models.DB).Count(ctx, "id")
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,764 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// 变更计划中的操作
+const (
+	ApplyCreate = "create"
+	ApplyUpdate = "update"
+	ApplyDelete = "delete"
+)
+
+// ErrInvalidDesiredState 期望状态文档校验失败
+var ErrInvalidDesiredState = errors.New("invalid desired state")
+
+// DesiredState 期望状态文档：列出的供应商、模型与关联即为全部配置，未列出的将被删除
+type DesiredState struct {
+	Providers    []DesiredProvider    `json:"providers"`
+	Models       []DesiredModel       `json:"models"`
+	Associations []DesiredAssociation `json:"associations"`
+}
+
+// DesiredProvider 按 name 匹配已有供应商
+type DesiredProvider struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Config  string `json:"config"` // 提交接口返回的脱敏配置时保留原密钥
+	Console string `json:"console"`
+	Proxy   string `json:"proxy"`
+
+	StatusPage     string `json:"status_page"`
+	StatusPagePath string `json:"status_page_path"`
+
+	ImageMaxDimension int `json:"image_max_dimension"`
+	ImageMaxBytes     int `json:"image_max_bytes"`
+
+	TPMLimit int `json:"tpm_limit"`
+}
+
+// DesiredModel 按 name 匹配已有模型
+type DesiredModel struct {
+	Name     string `json:"name"`
+	Remark   string `json:"remark"`
+	MaxRetry int    `json:"max_retry"`
+	TimeOut  int    `json:"time_out"`
+	IOLog    bool   `json:"io_log"`
+
+	ConnectTimeout    int `json:"connect_timeout"`
+	FirstTokenTimeout int `json:"first_token_timeout"`
+	StreamIdleTimeout int `json:"stream_idle_timeout"`
+
+	MaxOutputTokens int `json:"max_output_tokens"`
+	MaxOutputBytes  int `json:"max_output_bytes"`
+
+	ToolAuditWebhook string `json:"tool_audit_webhook"`
+
+	SLOFirstTokenMs int     `json:"slo_first_token_ms"`
+	SLOTarget       float64 `json:"slo_target"`
+	SLOWindowHours  int     `json:"slo_window_hours"`
+
+	ResponseRules *models.ResponseRules `json:"response_rules"`
+	LogLevel      string                `json:"log_level"`
+	LogSampleRate int                   `json:"log_sample_rate"`
+	Fallbacks     []string              `json:"fallbacks"`
+	Aliases       []string              `json:"aliases"`
+
+	RoutingStrategy string `json:"routing_strategy"`
+
+	StreamHeartbeatSeconds int `json:"stream_heartbeat_seconds"`
+
+	StickySession    bool `json:"sticky_session"`
+	StickySessionTTL int  `json:"sticky_session_ttl"`
+
+	RetryBackoffMs    int `json:"retry_backoff_ms"`
+	RetryBackoffMaxMs int `json:"retry_backoff_max_ms"`
+	RetryMaxElapsedMs int `json:"retry_max_elapsed_ms"`
+	RetryBudget       int `json:"retry_budget"`
+
+	SummarizeThreshold int    `json:"summarize_threshold"`
+	SummarizeModel     string `json:"summarize_model"`
+	SummarizeKeep      int    `json:"summarize_keep"`
+
+	MaxInputTokens       int  `json:"max_input_tokens"`
+	PreflightCountTokens bool `json:"preflight_count_tokens"`
+
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+
+	// 影子关联按 供应商名、供应商模型名 引用本模型的关联，ShadowPercent 为 0 表示不镜像
+	ShadowProvider      string `json:"shadow_provider"`
+	ShadowProviderModel string `json:"shadow_provider_model"`
+	ShadowPercent       int    `json:"shadow_percent"`
+}
+
+// DesiredAssociation 按 模型名、供应商名、供应商模型名 匹配已有关联
+type DesiredAssociation struct {
+	Model         string `json:"model"`
+	Provider      string `json:"provider"`
+	ProviderModel string `json:"provider_model"`
+
+	ToolCall         bool              `json:"tool_call"`
+	StructuredOutput bool              `json:"structured_output"`
+	Image            bool              `json:"image"`
+	PromptCache      bool              `json:"prompt_cache"`
+	WithHeader       bool              `json:"with_header"`
+	Status           *bool             `json:"status"` // 为空表示启用
+	CustomerHeaders  map[string]string `json:"customer_headers"`
+
+	Weight   int `json:"weight"`   // 0 表示创建时为 1、更新时保持当前值（不覆盖自动衰减的结果）
+	Priority int `json:"priority"` // 0 表示创建时使用默认优先级、更新时保持当前值
+
+	ContextLength   int                       `json:"context_length"`
+	RequestRewrites []models.RequestRewrite   `json:"request_rewrites"`
+	HealthCheck     *models.HealthCheckConfig `json:"health_check,omitempty"`
+}
+
+// ApplyChange 变更计划中的一项
+type ApplyChange struct {
+	Action string   `json:"action"`           // create、update、delete
+	Kind   string   `json:"kind"`             // provider、model、association
+	Name   string   `json:"name"`             // 关联为 模型/供应商/供应商模型
+	Fields []string `json:"fields,omitempty"` // 更新的字段
+}
+
+// ApplyPlan 期望状态与当前配置的差异
+type ApplyPlan struct {
+	Changes []ApplyChange `json:"changes"`
+	Applied bool          `json:"applied"`
+}
+
+func (a DesiredAssociation) key() string {
+	return a.Model + "/" + a.Provider + "/" + a.ProviderModel
+}
+
+// validate 校验文档内部一致性与各项配置
+func (s DesiredState) validate() error {
+	providerNames := make(map[string]bool, len(s.Providers))
+	for _, p := range s.Providers {
+		if p.Name == "" || providerNames[p.Name] {
+			return fmt.Errorf("%w: provider name %q is empty or duplicated", ErrInvalidDesiredState, p.Name)
+		}
+		providerNames[p.Name] = true
+		if p.ImageMaxDimension < 0 || p.ImageMaxBytes < 0 {
+			return fmt.Errorf("%w: provider %s: invalid image limits", ErrInvalidDesiredState, p.Name)
+		}
+		if p.TPMLimit < 0 {
+			return fmt.Errorf("%w: provider %s: invalid tpm limit", ErrInvalidDesiredState, p.Name)
+		}
+	}
+	modelNames := make(map[string]bool, len(s.Models))
+	for _, m := range s.Models {
+		if m.Name == "" || modelNames[m.Name] {
+			return fmt.Errorf("%w: model name %q is empty or duplicated", ErrInvalidDesiredState, m.Name)
+		}
+		modelNames[m.Name] = true
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("%w: model %s: %v", ErrInvalidDesiredState, m.Name, err)
+		}
+	}
+	// 别名不能与模型同名，也不能被多个模型使用
+	aliasOwners := make(map[string]string)
+	for _, m := range s.Models {
+		for _, alias := range m.Aliases {
+			if owner, ok := aliasOwners[alias]; modelNames[alias] || ok {
+				return fmt.Errorf("%w: model %s: alias %q conflicts with model %s", ErrInvalidDesiredState, m.Name, alias, lo.Ternary(ok, owner, alias))
+			}
+			aliasOwners[alias] = m.Name
+		}
+	}
+	keys := make(map[string]bool, len(s.Associations))
+	for _, a := range s.Associations {
+		if !modelNames[a.Model] || !providerNames[a.Provider] || a.ProviderModel == "" {
+			return fmt.Errorf("%w: association %s: model and provider must be declared and provider_model is required", ErrInvalidDesiredState, a.key())
+		}
+		if keys[a.key()] {
+			return fmt.Errorf("%w: association %s is duplicated", ErrInvalidDesiredState, a.key())
+		}
+		keys[a.key()] = true
+		if a.Weight < 0 || a.ContextLength < 0 {
+			return fmt.Errorf("%w: association %s: invalid weight or context length", ErrInvalidDesiredState, a.key())
+		}
+		if err := ValidateRequestRewrites(a.RequestRewrites); err != nil {
+			return fmt.Errorf("%w: association %s: %v", ErrInvalidDesiredState, a.key(), err)
+		}
+		if err := ValidateHealthCheckConfig(a.HealthCheck); err != nil {
+			return fmt.Errorf("%w: association %s: %v", ErrInvalidDesiredState, a.key(), err)
+		}
+	}
+	for _, m := range s.Models {
+		if m.ShadowPercent == 0 {
+			continue
+		}
+		if shadow := (DesiredAssociation{Model: m.Name, Provider: m.ShadowProvider, ProviderModel: m.ShadowProviderModel}); !keys[shadow.key()] {
+			return fmt.Errorf("%w: model %s: shadow association %s is not declared", ErrInvalidDesiredState, m.Name, shadow.key())
+		}
+	}
+	return nil
+}
+
+func (m DesiredModel) validate() error {
+	switch {
+	case m.MaxRetry < 0 || m.TimeOut < 0:
+		return errors.New("invalid max_retry or time_out")
+	case m.ConnectTimeout < 0 || m.FirstTokenTimeout < 0 || m.StreamIdleTimeout < 0:
+		return errors.New("invalid timeouts")
+	case !ValidLogLevel(m.LogLevel):
+		return errors.New("invalid log level")
+	case !ValidRoutingStrategy(m.RoutingStrategy):
+		return errors.New("invalid routing strategy")
+	case m.StreamHeartbeatSeconds < 0:
+		return errors.New("invalid stream heartbeat interval")
+	case m.LogSampleRate < 0 || m.StickySessionTTL < 0:
+		return errors.New("invalid log sample rate or sticky session ttl")
+	case m.RetryBackoffMs < 0 || m.RetryBackoffMaxMs < 0 || m.RetryMaxElapsedMs < 0 || m.RetryBudget < 0:
+		return errors.New("invalid retry policy")
+	case m.SummarizeThreshold < 0 || m.SummarizeKeep < 0 || (m.SummarizeModel != "" && m.SummarizeModel == m.Name):
+		return errors.New("invalid summarize settings")
+	case m.MaxInputTokens < 0:
+		return errors.New("invalid max input tokens")
+	case m.RPM < 0 || m.TPM < 0:
+		return errors.New("rpm and tpm must not be negative")
+	case m.ShadowPercent < 0 || m.ShadowPercent > 100 || (m.ShadowPercent == 0) != (m.ShadowProvider == "" && m.ShadowProviderModel == ""):
+		return errors.New("shadow_percent must be between 1 and 100 when a shadow association is set, and 0 otherwise")
+	}
+	for _, alias := range m.Aliases {
+		if err := ValidateAliasPattern(alias); err != nil {
+			return err
+		}
+	}
+	return ValidateResponseRules(m.ResponseRules)
+}
+
+func (p DesiredProvider) model() models.Provider {
+	return models.Provider{
+		Name:    p.Name,
+		Type:    p.Type,
+		Config:  p.Config,
+		Console: p.Console,
+		Proxy:   p.Proxy,
+
+		StatusPage:     p.StatusPage,
+		StatusPagePath: p.StatusPagePath,
+
+		ImageMaxDimension: p.ImageMaxDimension,
+		ImageMaxBytes:     p.ImageMaxBytes,
+
+		TPMLimit: p.TPMLimit,
+	}
+}
+
+func desiredProviderOf(p models.Provider) DesiredProvider {
+	return DesiredProvider{
+		Name:    p.Name,
+		Type:    p.Type,
+		Config:  p.Config,
+		Console: p.Console,
+		Proxy:   p.Proxy,
+
+		StatusPage:     p.StatusPage,
+		StatusPagePath: p.StatusPagePath,
+
+		ImageMaxDimension: p.ImageMaxDimension,
+		ImageMaxBytes:     p.ImageMaxBytes,
+
+		TPMLimit: p.TPMLimit,
+	}
+}
+
+func (m DesiredModel) model() models.Model {
+	return models.Model{
+		Name:     m.Name,
+		Remark:   m.Remark,
+		MaxRetry: m.MaxRetry,
+		TimeOut:  m.TimeOut,
+		IOLog:    lo.ToPtr(m.IOLog),
+
+		ConnectTimeout:    m.ConnectTimeout,
+		FirstTokenTimeout: m.FirstTokenTimeout,
+		StreamIdleTimeout: m.StreamIdleTimeout,
+
+		MaxOutputTokens: m.MaxOutputTokens,
+		MaxOutputBytes:  m.MaxOutputBytes,
+
+		ToolAuditWebhook: m.ToolAuditWebhook,
+
+		SLOFirstTokenMs: m.SLOFirstTokenMs,
+		SLOTarget:       m.SLOTarget,
+		SLOWindowHours:  m.SLOWindowHours,
+
+		ResponseRules: m.ResponseRules,
+		LogLevel:      m.LogLevel,
+		LogSampleRate: m.LogSampleRate,
+		Fallbacks:     m.Fallbacks,
+		Aliases:       m.Aliases,
+
+		RoutingStrategy: m.RoutingStrategy,
+
+		StreamHeartbeatSeconds: m.StreamHeartbeatSeconds,
+
+		StickySession:    lo.ToPtr(m.StickySession),
+		StickySessionTTL: m.StickySessionTTL,
+
+		RetryBackoffMs:    m.RetryBackoffMs,
+		RetryBackoffMaxMs: m.RetryBackoffMaxMs,
+		RetryMaxElapsedMs: m.RetryMaxElapsedMs,
+		RetryBudget:       m.RetryBudget,
+
+		SummarizeThreshold: m.SummarizeThreshold,
+		SummarizeModel:     m.SummarizeModel,
+		SummarizeKeep:      m.SummarizeKeep,
+
+		MaxInputTokens:       m.MaxInputTokens,
+		PreflightCountTokens: lo.ToPtr(m.PreflightCountTokens),
+
+		RPM: m.RPM,
+		TPM: m.TPM,
+
+		ShadowPercent: m.ShadowPercent,
+	}
+}
+
+// desiredModelOf 转换为期望状态，shadow 为影子关联的 供应商名、供应商模型名，未配置影子流量时为空
+func desiredModelOf(m models.Model, shadow [2]string) DesiredModel {
+	return DesiredModel{
+		Name:     m.Name,
+		Remark:   m.Remark,
+		MaxRetry: m.MaxRetry,
+		TimeOut:  m.TimeOut,
+		IOLog:    lo.FromPtr(m.IOLog),
+
+		ConnectTimeout:    m.ConnectTimeout,
+		FirstTokenTimeout: m.FirstTokenTimeout,
+		StreamIdleTimeout: m.StreamIdleTimeout,
+
+		MaxOutputTokens: m.MaxOutputTokens,
+		MaxOutputBytes:  m.MaxOutputBytes,
+
+		ToolAuditWebhook: m.ToolAuditWebhook,
+
+		SLOFirstTokenMs: m.SLOFirstTokenMs,
+		SLOTarget:       m.SLOTarget,
+		SLOWindowHours:  m.SLOWindowHours,
+
+		ResponseRules: m.ResponseRules,
+		LogLevel:      m.LogLevel,
+		LogSampleRate: m.LogSampleRate,
+		Fallbacks:     m.Fallbacks,
+		Aliases:       m.Aliases,
+
+		RoutingStrategy: m.RoutingStrategy,
+
+		StreamHeartbeatSeconds: m.StreamHeartbeatSeconds,
+
+		StickySession:    lo.FromPtr(m.StickySession),
+		StickySessionTTL: m.StickySessionTTL,
+
+		RetryBackoffMs:    m.RetryBackoffMs,
+		RetryBackoffMaxMs: m.RetryBackoffMaxMs,
+		RetryMaxElapsedMs: m.RetryMaxElapsedMs,
+		RetryBudget:       m.RetryBudget,
+
+		SummarizeThreshold: m.SummarizeThreshold,
+		SummarizeModel:     m.SummarizeModel,
+		SummarizeKeep:      m.SummarizeKeep,
+
+		MaxInputTokens:       m.MaxInputTokens,
+		PreflightCountTokens: lo.FromPtr(m.PreflightCountTokens),
+
+		RPM: m.RPM,
+		TPM: m.TPM,
+
+		ShadowProvider:      shadow[0],
+		ShadowProviderModel: shadow[1],
+		ShadowPercent:       m.ShadowPercent,
+	}
+}
+
+func (a DesiredAssociation) model(modelID, providerID uint) models.ModelWithProvider {
+	headers := a.CustomerHeaders
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	return models.ModelWithProvider{
+		ModelID:          modelID,
+		ProviderModel:    a.ProviderModel,
+		ProviderID:       providerID,
+		ToolCall:         lo.ToPtr(a.ToolCall),
+		StructuredOutput: lo.ToPtr(a.StructuredOutput),
+		Image:            lo.ToPtr(a.Image),
+		PromptCache:      lo.ToPtr(a.PromptCache),
+		WithHeader:       lo.ToPtr(a.WithHeader),
+		Status:           lo.ToPtr(a.Status == nil || *a.Status),
+		CustomerHeaders:  headers,
+		Weight:           a.Weight,
+		Priority:         a.Priority,
+		ContextLength:    a.ContextLength,
+		RequestRewrites:  a.RequestRewrites,
+		HealthCheck:      a.HealthCheck,
+	}
+}
+
+func desiredAssociationOf(mp models.ModelWithProvider, model, provider string) DesiredAssociation {
+	return DesiredAssociation{
+		Model:            model,
+		Provider:         provider,
+		ProviderModel:    mp.ProviderModel,
+		ToolCall:         lo.FromPtr(mp.ToolCall),
+		StructuredOutput: lo.FromPtr(mp.StructuredOutput),
+		Image:            lo.FromPtr(mp.Image),
+		PromptCache:      lo.FromPtr(mp.PromptCache),
+		WithHeader:       lo.FromPtr(mp.WithHeader),
+		Status:           lo.ToPtr(mp.Status == nil || *mp.Status),
+		CustomerHeaders:  mp.CustomerHeaders,
+		Weight:           mp.Weight,
+		Priority:         mp.Priority,
+		ContextLength:    mp.ContextLength,
+		RequestRewrites:  mp.RequestRewrites,
+		HealthCheck:      mp.HealthCheck,
+	}
+}
+
+// changedFields 按 JSON 字段名列出不同的字段，空数组、空对象与 null 视为相同
+func changedFields(current, desired any) []string {
+	cv, dv := reflect.ValueOf(current), reflect.ValueOf(desired)
+	var fields []string
+	for i := 0; i < cv.NumField(); i++ {
+		a, _ := json.Marshal(cv.Field(i).Interface())
+		b, _ := json.Marshal(dv.Field(i).Interface())
+		if !bytes.Equal(normalizeEmptyJSON(a), normalizeEmptyJSON(b)) {
+			name, _, _ := strings.Cut(cv.Type().Field(i).Tag.Get("json"), ",")
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func normalizeEmptyJSON(b []byte) []byte {
+	switch string(b) {
+	case "[]", "{}":
+		return []byte("null")
+	}
+	return b
+}
+
+// ApplyDesiredState 计算期望状态与当前配置的差异，dryRun 为假时在一个事务中执行全部变更；
+// 配置变更的供应商在提交后重置关联状态并执行健康检测
+func ApplyDesiredState(ctx context.Context, state DesiredState, dryRun bool) (*ApplyPlan, error) {
+	if err := state.validate(); err != nil {
+		return nil, err
+	}
+	// 事务外读取设置，事务内只使用 tx
+	defaultPriority := getIntSetting(ctx, models.SettingKeyAutoPriorityDecayDefault, 100)
+	plan := &ApplyPlan{Changes: []ApplyChange{}}
+	var configChanged []uint
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed, err := applyDesiredState(ctx, tx, state, defaultPriority, plan, dryRun)
+		configChanged = changed
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	finishApply(ctx, plan, configChanged, dryRun)
+	return plan, nil
+}
+
+// applyDesiredState 在事务中依次同步供应商、模型与关联，返回配置发生变更的供应商
+func applyDesiredState(ctx context.Context, tx *gorm.DB, state DesiredState, defaultPriority int, plan *ApplyPlan, dryRun bool) ([]uint, error) {
+	providerIDs, configChanged, err := applyProviders(ctx, tx, state.Providers, plan, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	modelIDs, err := applyModels(ctx, tx, state.Models, plan, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAssociations(ctx, tx, state.Associations, providerIDs, modelIDs, defaultPriority, plan, dryRun); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return configChanged, nil
+	}
+	if err := applyShadows(ctx, tx, state.Models, providerIDs, modelIDs); err != nil {
+		return nil, err
+	}
+	return configChanged, nil
+}
+
+// finishApply 提交后重置配置变更的供应商的关联状态
+func finishApply(ctx context.Context, plan *ApplyPlan, configChanged []uint, dryRun bool) {
+	if dryRun {
+		return
+	}
+	plan.Applied = true
+	for _, id := range configChanged {
+		if _, err := ResetProviderState(ctx, id); err != nil {
+			slog.Error("failed to reset provider state after apply", "provider_id", id, "error", err)
+		}
+	}
+}
+
+// applyProviders 返回 供应商名 -> ID（试运行时新建的供应商为 0）与配置发生变更的供应商
+func applyProviders(ctx context.Context, tx *gorm.DB, desired []DesiredProvider, plan *ApplyPlan, dryRun bool) (map[string]uint, []uint, error) {
+	existing, err := gorm.G[models.Provider](tx).Find(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := lo.KeyBy(existing, func(p models.Provider) string { return p.Name })
+	ids := make(map[string]uint, len(desired))
+	var configChanged []uint
+	for _, d := range desired {
+		current, ok := byName[d.Name]
+		if ok {
+			d.Config = models.RestoreConfigSecrets(d.Config, current.Config)
+		}
+		// 提前校验类型与配置，避免写入无法使用的供应商
+		if _, err := providers.New(d.Type, d.Config, d.Proxy); err != nil {
+			return nil, nil, fmt.Errorf("%w: provider %s: %v", ErrInvalidDesiredState, d.Name, err)
+		}
+		if !ok {
+			plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyCreate, Kind: "provider", Name: d.Name})
+			provider := d.model()
+			if !dryRun {
+				if err := gorm.G[models.Provider](tx).Create(ctx, &provider); err != nil {
+					return nil, nil, err
+				}
+			}
+			ids[d.Name] = provider.ID
+			continue
+		}
+		ids[d.Name] = current.ID
+		currentSpec := desiredProviderOf(current)
+		if !ProviderConfigChanged(current.Config, d.Config) {
+			currentSpec.Config = d.Config
+		}
+		fields := changedFields(currentSpec, d)
+		if len(fields) == 0 {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyUpdate, Kind: "provider", Name: d.Name, Fields: fields})
+		if lo.Contains(fields, "config") {
+			configChanged = append(configChanged, current.ID)
+		}
+		if !dryRun {
+			columns := []string{"name", "type", "config", "console", "proxy", "status_page", "status_page_path", "image_max_dimension", "image_max_bytes", "tpm_limit"}
+			if err := tx.Model(&models.Provider{}).Where("id = ?", current.ID).Select(columns).Updates(lo.ToPtr(d.model())).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, p := range existing {
+		if _, ok := ids[p.Name]; ok {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyDelete, Kind: "provider", Name: p.Name})
+		if !dryRun {
+			if _, err := gorm.G[models.Provider](tx).Where("id = ?", p.ID).Delete(ctx); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return ids, configChanged, nil
+}
+
+// applyModels 返回 模型名 -> ID（试运行时新建的模型为 0）
+func applyModels(ctx context.Context, tx *gorm.DB, desired []DesiredModel, plan *ApplyPlan, dryRun bool) (map[string]uint, error) {
+	existing, err := gorm.G[models.Model](tx).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := lo.KeyBy(existing, func(m models.Model) string { return m.Name })
+	shadows, err := shadowAssociations(ctx, tx, existing)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]uint, len(desired))
+	for _, d := range desired {
+		current, ok := byName[d.Name]
+		if ok {
+			d.ToolAuditWebhook = models.RestoreSecret(d.ToolAuditWebhook, current.ToolAuditWebhook)
+		}
+		if !ok {
+			plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyCreate, Kind: "model", Name: d.Name})
+			model := d.model()
+			if !dryRun {
+				if err := gorm.G[models.Model](tx).Create(ctx, &model); err != nil {
+					return nil, err
+				}
+			}
+			ids[d.Name] = model.ID
+			continue
+		}
+		ids[d.Name] = current.ID
+		fields := changedFields(desiredModelOf(current, shadows[current.ShadowModelProviderID]), d)
+		if len(fields) == 0 {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyUpdate, Kind: "model", Name: d.Name, Fields: fields})
+		if !dryRun {
+			// 影子关联在关联同步之后由 applyShadows 写入
+			columns := []string{"name", "remark", "max_retry", "time_out", "io_log", "connect_timeout", "first_token_timeout", "stream_idle_timeout", "max_output_tokens", "max_output_bytes", "tool_audit_webhook",
+				"slo_first_token_ms", "slo_target", "slo_window_hours", "response_rules", "log_level", "log_sample_rate", "fallbacks", "aliases", "routing_strategy", "stream_heartbeat_seconds",
+				"sticky_session", "sticky_session_ttl", "retry_backoff_ms", "retry_backoff_max_ms", "retry_max_elapsed_ms", "retry_budget",
+				"summarize_threshold", "summarize_model", "summarize_keep", "max_input_tokens", "preflight_count_tokens", "rpm", "tpm", "shadow_percent"}
+			if err := tx.Model(&models.Model{}).Where("id = ?", current.ID).Select(columns).Updates(lo.ToPtr(d.model())).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, m := range existing {
+		if _, ok := ids[m.Name]; ok {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyDelete, Kind: "model", Name: m.Name})
+		if !dryRun {
+			if _, err := gorm.G[models.Model](tx).Where("id = ?", m.ID).Delete(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ids, nil
+}
+
+// applyAssociations 按 模型 ID、供应商 ID、供应商模型名 匹配已有关联，引用已删除模型或供应商的遗留关联一并删除
+func applyAssociations(ctx context.Context, tx *gorm.DB, desired []DesiredAssociation, providerIDs, modelIDs map[string]uint, defaultPriority int, plan *ApplyPlan, dryRun bool) error {
+	existing, err := gorm.G[models.ModelWithProvider](tx).Find(ctx)
+	if err != nil {
+		return err
+	}
+	providerNames, modelNames, err := associationNames(ctx, tx)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]models.ModelWithProvider, len(existing))
+	for _, mp := range existing {
+		key := fmt.Sprintf("%d/%d/%s", mp.ModelID, mp.ProviderID, mp.ProviderModel)
+		if _, dup := byKey[key]; !dup {
+			byKey[key] = mp
+		}
+	}
+	kept := make(map[uint]bool, len(desired))
+	for _, d := range desired {
+		modelID, providerID := modelIDs[d.Model], providerIDs[d.Provider]
+		current, ok := byKey[fmt.Sprintf("%d/%d/%s", modelID, providerID, d.ProviderModel)]
+		if !ok || modelID == 0 || providerID == 0 {
+			plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyCreate, Kind: "association", Name: d.key()})
+			if dryRun {
+				continue
+			}
+			association := d.model(modelID, providerID)
+			if association.Weight == 0 {
+				association.Weight = 1
+			}
+			if association.Priority == 0 {
+				association.Priority = defaultPriority
+			}
+			if err := gorm.G[models.ModelWithProvider](tx).Create(ctx, &association); err != nil {
+				return err
+			}
+			continue
+		}
+		kept[current.ID] = true
+		if d.Weight == 0 {
+			d.Weight = current.Weight
+		}
+		if d.Priority == 0 {
+			d.Priority = current.Priority
+		}
+		if d.Status == nil {
+			d.Status = lo.ToPtr(true)
+		}
+		fields := changedFields(desiredAssociationOf(current, d.Model, d.Provider), d)
+		if len(fields) == 0 {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyUpdate, Kind: "association", Name: d.key(), Fields: fields})
+		if !dryRun {
+			columns := []string{"tool_call", "structured_output", "image", "prompt_cache", "with_header", "status", "customer_headers", "weight", "priority", "context_length", "request_rewrites", "health_check"}
+			if err := tx.Model(&models.ModelWithProvider{}).Where("id = ?", current.ID).Select(columns).Updates(lo.ToPtr(d.model(modelID, providerID))).Error; err != nil {
+				return err
+			}
+		}
+	}
+	for _, mp := range existing {
+		if kept[mp.ID] {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyDelete, Kind: "association", Name: modelNames[mp.ModelID] + "/" + providerNames[mp.ProviderID] + "/" + mp.ProviderModel})
+		if !dryRun {
+			if _, err := gorm.G[models.ModelWithProvider](tx).Where("id = ?", mp.ID).Delete(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shadowAssociations 返回模型引用的影子关联 ID -> 供应商名、供应商模型名
+func shadowAssociations(ctx context.Context, tx *gorm.DB, modelList []models.Model) (map[uint][2]string, error) {
+	ids := lo.Uniq(lo.FilterMap(modelList, func(m models.Model, _ int) (uint, bool) { return m.ShadowModelProviderID, m.ShadowModelProviderID != 0 }))
+	if len(ids) == 0 {
+		return map[uint][2]string{}, nil
+	}
+	associations, err := gorm.G[models.ModelWithProvider](tx).Where("id IN ?", ids).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providerNames, _, err := associationNames(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	return lo.SliceToMap(associations, func(mp models.ModelWithProvider) (uint, [2]string) {
+		return mp.ID, [2]string{providerNames[mp.ProviderID], mp.ProviderModel}
+	}), nil
+}
+
+// applyShadows 在关联同步之后按名称解析影子关联并写入模型，变更已由 applyModels 计入计划
+func applyShadows(ctx context.Context, tx *gorm.DB, desired []DesiredModel, providerIDs, modelIDs map[string]uint) error {
+	for _, d := range desired {
+		var shadowID uint
+		if d.ShadowPercent > 0 {
+			mp, err := gorm.G[models.ModelWithProvider](tx).
+				Where("model_id = ? AND provider_id = ? AND provider_model = ?", modelIDs[d.Name], providerIDs[d.ShadowProvider], d.ShadowProviderModel).
+				Order("id").First(ctx)
+			if err != nil {
+				return fmt.Errorf("model %s: shadow association: %w", d.Name, err)
+			}
+			shadowID = mp.ID
+		}
+		if err := tx.Model(&models.Model{}).Where("id = ? AND shadow_model_provider_id <> ?", modelIDs[d.Name], shadowID).
+			Update("shadow_model_provider_id", shadowID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// associationNames 包含已软删除的记录，使本次删除的供应商与模型的关联仍能显示名称
+func associationNames(ctx context.Context, tx *gorm.DB) (map[uint]string, map[uint]string, error) {
+	var providerList []models.Provider
+	if err := tx.WithContext(ctx).Unscoped().Select("id", "name").Find(&providerList).Error; err != nil {
+		return nil, nil, err
+	}
+	var modelList []models.Model
+	if err := tx.WithContext(ctx).Unscoped().Select("id", "name").Find(&modelList).Error; err != nil {
+		return nil, nil, err
+	}
+	providerNames := lo.SliceToMap(providerList, func(p models.Provider) (uint, string) { return p.ID, p.Name })
+	modelNames := lo.SliceToMap(modelList, func(m models.Model) (uint, string) { return m.ID, m.Name })
+	return providerNames, modelNames, nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// authFailureBodyLimit 通知中保留的上游响应长度
+const authFailureBodyLimit = 1024
+
+var authFailureClient = &http.Client{Timeout: 10 * time.Second}
+
+// KeyInvalidEvent 上游密钥失效时推送给 webhook 的事件
+type KeyInvalidEvent struct {
+	Event               string    `json:"event"` // key_invalid
+	Time                time.Time `json:"time"`
+	ModelWithProviderID uint      `json:"model_with_provider_id"`
+	Model               string    `json:"model"`
+	Provider            string    `json:"provider"`
+	ProviderModel       string    `json:"provider_model"`
+	StatusCode          int       `json:"status_code"`
+	Body                string    `json:"body"`
+}
+
+// AuthFailure 因 401/403 被隔离的关联，Active 为假表示配置已变更、隔离已失效
+type AuthFailure struct {
+	ModelWithProviderID uint      `json:"model_with_provider_id"`
+	Model               string    `json:"model"`
+	Provider            string    `json:"provider"`
+	ProviderModel       string    `json:"provider_model"`
+	FailedAt            time.Time `json:"failed_at"`
+	Active              bool      `json:"active"`
+}
+
+// isAuthFailure 401/403 表示密钥失效或无权限，重试与逐步衰减都没有意义
+func isAuthFailure(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// authConfigHash 供应商配置与关联自定义请求头的摘要，任一变更都视为更换了凭据
+func authConfigHash(provider models.Provider, mp models.ModelWithProvider) string {
+	headers, _ := json.Marshal(mp.CustomerHeaders)
+	sum := sha256.Sum256([]byte(provider.Config + "\x00" + string(headers)))
+	return hex.EncodeToString(sum[:])
+}
+
+// authQuarantined 关联是否因密钥失效被隔离且配置尚未变更
+func authQuarantined(provider models.Provider, mp models.ModelWithProvider) bool {
+	return mp.AuthFailedAt != nil && mp.AuthFailedConfig == authConfigHash(provider, mp)
+}
+
+// QuarantineAuthFailure 上游返回 401/403 时立即隔离关联，直到供应商配置或自定义请求头变更、手动解除或健康检测成功；
+// 新隔离时推送密钥失效通知
+func QuarantineAuthFailure(ctx context.Context, mp models.ModelWithProvider, provider models.Provider, modelName string, status int, body string) {
+	if authQuarantined(provider, mp) {
+		return
+	}
+	now := time.Now()
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", mp.ID).Updates(ctx, models.ModelWithProvider{
+		AuthFailedAt:     &now,
+		AuthFailedConfig: authConfigHash(provider, mp),
+	}); err != nil {
+		slog.Error("failed to quarantine association after auth failure", "model_provider_id", mp.ID, "error", err)
+		return
+	}
+	slog.Warn("upstream key invalid, association quarantined", "model_provider_id", mp.ID, "provider", provider.Name, "model", mp.ProviderModel, "status", status)
+
+	webhook := GetKeyInvalidWebhook(ctx)
+	if webhook == "" {
+		return
+	}
+	if len(body) > authFailureBodyLimit {
+		body = body[:authFailureBodyLimit]
+	}
+	event := KeyInvalidEvent{
+		Event:               "key_invalid",
+		Time:                now,
+		ModelWithProviderID: mp.ID,
+		Model:               modelName,
+		Provider:            provider.Name,
+		ProviderModel:       mp.ProviderModel,
+		StatusCode:          status,
+		Body:                body,
+	}
+	go func() {
+		if err := sendKeyInvalidEvent(webhook, event); err != nil {
+			slog.Error("failed to send key invalid notification", "model_provider_id", event.ModelWithProviderID, "error", err)
+		}
+	}()
+}
+
+func sendKeyInvalidEvent(webhook string, event KeyInvalidEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	res, err := authFailureClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// ClearAuthFailure 解除关联的密钥失效隔离，关联不存在或未被隔离时返回 false
+func ClearAuthFailure(ctx context.Context, id uint) (bool, error) {
+	result := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).
+		Where("id = ? AND auth_failed_at IS NOT NULL", id).
+		Updates(map[string]any{"auth_failed_at": nil, "auth_failed_config": ""})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListAuthFailures 列出因 401/403 被隔离过的关联
+func ListAuthFailures(ctx context.Context) ([]AuthFailure, error) {
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Where("auth_failed_at IS NOT NULL").Order("auth_failed_at DESC").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providerList, err := gorm.G[models.Provider](models.DB).
+		Where("id IN ?", lo.Map(associations, func(mp models.ModelWithProvider, _ int) uint { return mp.ProviderID })).
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modelList, err := gorm.G[models.Model](models.DB).
+		Where("id IN ?", lo.Map(associations, func(mp models.ModelWithProvider, _ int) uint { return mp.ModelID })).
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providerMap := lo.KeyBy(providerList, func(p models.Provider) uint { return p.ID })
+	modelMap := lo.KeyBy(modelList, func(m models.Model) uint { return m.ID })
+
+	failures := make([]AuthFailure, 0, len(associations))
+	for _, mp := range associations {
+		provider := providerMap[mp.ProviderID]
+		failures = append(failures, AuthFailure{
+			ModelWithProviderID: mp.ID,
+			Model:               modelMap[mp.ModelID].Name,
+			Provider:            provider.Name,
+			ProviderModel:       mp.ProviderModel,
+			FailedAt:            *mp.AuthFailedAt,
+			Active:              authQuarantined(provider, mp),
+		})
+	}
+	return failures, nil
+}
+
+// GetKeyInvalidWebhook 获取密钥失效通知 webhook，为空表示不通知
+func GetKeyInvalidWebhook(ctx context.Context) string {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyKeyInvalidWebhook)).First(ctx)
+	if err != nil {
+		return ""
+	}
+	return setting.Value
+}
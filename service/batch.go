@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// 批处理与单个请求的状态
+const (
+	BatchStatusInProgress = "in_progress"
+	BatchStatusCanceling  = "canceling"
+	BatchStatusEnded      = "ended"
+
+	BatchItemProcessing = "processing"
+	BatchItemSucceeded  = "succeeded"
+	BatchItemErrored    = "errored"
+	BatchItemCanceled   = "canceled"
+	BatchItemExpired    = "expired"
+)
+
+const (
+	batchConcurrency = 4              // 单个批处理同时执行的请求数
+	batchExpiry      = 24 * time.Hour // 未在此时间内完成的请求标记为 expired
+	batchPageSize    = 100
+)
+
+// BatchExecutor 执行批处理中的单个请求，返回 HTTP 状态码与响应体
+type BatchExecutor func(ctx context.Context, batch models.MessageBatch, params []byte) (int, []byte)
+
+// MessageBatchRequest 创建批处理时的单个请求
+type MessageBatchRequest struct {
+	CustomID string          `json:"custom_id"`
+	Params   json.RawMessage `json:"params"`
+}
+
+// runningBatches 执行中的批处理及其取消函数
+var runningBatches sync.Map
+
+// newBatchID 生成 msgbatch_ 前缀的批处理 ID
+func newBatchID() string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return "msgbatch_" + hex.EncodeToString(buf)
+}
+
+// CreateMessageBatch 保存批处理及其请求并开始异步执行
+func CreateMessageBatch(ctx context.Context, apiKeyID uint, header http.Header, requests []MessageBatchRequest, exec BatchExecutor) (*models.MessageBatch, error) {
+	batch := models.MessageBatch{
+		BatchID:    newBatchID(),
+		APIKeyID:   apiKeyID,
+		Header:     header,
+		Status:     BatchStatusInProgress,
+		Processing: len(requests),
+		ExpiresAt:  time.Now().Add(batchExpiry),
+	}
+	err := models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		items := make([]models.MessageBatchItem, 0, len(requests))
+		for _, request := range requests {
+			items = append(items, models.MessageBatchItem{
+				BatchID:  batch.ID,
+				CustomID: request.CustomID,
+				Params:   string(request.Params),
+				Status:   BatchItemProcessing,
+			})
+		}
+		return tx.CreateInBatches(items, batchPageSize).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	go RunMessageBatch(context.WithoutCancel(ctx), batch, exec)
+	return &batch, nil
+}
+
+// ResumeMessageBatches 启动时继续执行未完成的批处理
+func ResumeMessageBatches(ctx context.Context, exec BatchExecutor) {
+	batches, err := gorm.G[models.MessageBatch](models.DB).Where("status IN ?", []string{BatchStatusInProgress, BatchStatusCanceling}).Find(ctx)
+	if err != nil {
+		slog.Error("failed to load message batches", "error", err)
+		return
+	}
+	for _, batch := range batches {
+		go RunMessageBatch(ctx, batch, exec)
+	}
+}
+
+// RunMessageBatch 依次执行批处理中待处理的请求，取消或过期后将剩余请求标记为 canceled / expired
+func RunMessageBatch(ctx context.Context, batch models.MessageBatch, exec BatchExecutor) {
+	runCtx, cancel := context.WithDeadline(ctx, batch.ExpiresAt)
+	defer cancel()
+	if _, loaded := runningBatches.LoadOrStore(batch.ID, cancel); loaded {
+		return
+	}
+	defer runningBatches.Delete(batch.ID)
+
+	if batch.Status == BatchStatusInProgress {
+		runBatchItems(runCtx, batch, exec)
+	}
+	if err := finishMessageBatch(context.WithoutCancel(ctx), batch.ID); err != nil {
+		slog.Error("failed to finish message batch", "batch", batch.BatchID, "error", err)
+	}
+}
+
+// runBatchItems 按 ID 顺序分页取出待处理请求，并发执行
+func runBatchItems(ctx context.Context, batch models.MessageBatch, exec BatchExecutor) {
+	var lastID uint
+	for ctx.Err() == nil {
+		items, err := gorm.G[models.MessageBatchItem](models.DB).
+			Where("batch_id = ? AND status = ? AND id > ?", batch.ID, BatchItemProcessing, lastID).
+			Order("id ASC").Limit(batchPageSize).Find(ctx)
+		if err != nil {
+			slog.Error("failed to load message batch items", "batch", batch.BatchID, "error", err)
+			return
+		}
+		if len(items) == 0 {
+			return
+		}
+		lastID = items[len(items)-1].ID
+
+		sem := make(chan struct{}, batchConcurrency)
+		var wg sync.WaitGroup
+		for _, item := range items {
+			if ctx.Err() != nil {
+				break
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				runBatchItem(ctx, batch, item, exec)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// runBatchItem 执行单个请求并保存结果，批处理被取消或过期时不保存，由 finishMessageBatch 统一标记
+func runBatchItem(ctx context.Context, batch models.MessageBatch, item models.MessageBatchItem, exec BatchExecutor) {
+	status, body := exec(ctx, batch, []byte(item.Params))
+	if ctx.Err() != nil {
+		return
+	}
+	itemStatus, result := BatchItemSucceeded, string(body)
+	if status != http.StatusOK {
+		itemStatus, result = BatchItemErrored, batchErrorResult(status, body)
+	}
+	if err := completeBatchItems(context.WithoutCancel(ctx), batch.ID, []uint{item.ID}, itemStatus, result); err != nil {
+		slog.Error("failed to save message batch item", "batch", batch.BatchID, "custom_id", item.CustomID, "error", err)
+	}
+}
+
+// completeBatchItems 更新请求状态并同步批处理计数
+func completeBatchItems(ctx context.Context, batchID uint, ids []uint, status, result string) error {
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.MessageBatchItem{}).
+			Where("id IN ? AND status = ?", ids, BatchItemProcessing).
+			Updates(map[string]any{"status": status, "result": result})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return tx.Model(&models.MessageBatch{}).Where("id = ?", batchID).Updates(map[string]any{
+			"processing": gorm.Expr("processing - ?", res.RowsAffected),
+			status:       gorm.Expr(status+" + ?", res.RowsAffected),
+		}).Error
+	})
+}
+
+// finishMessageBatch 将剩余请求标记为取消或过期，并结束批处理
+func finishMessageBatch(ctx context.Context, id uint) error {
+	batch, err := gorm.G[models.MessageBatch](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return err
+	}
+	if batch.Status == BatchStatusEnded {
+		return nil
+	}
+	if batch.Processing > 0 {
+		remaining := BatchItemExpired
+		if batch.Status == BatchStatusCanceling {
+			remaining = BatchItemCanceled
+		} else if time.Now().Before(batch.ExpiresAt) {
+			// 进程退出导致的中断，等待下次启动继续执行
+			return nil
+		}
+		var ids []uint
+		if err := models.DB.WithContext(ctx).Model(&models.MessageBatchItem{}).
+			Where("batch_id = ? AND status = ?", id, BatchItemProcessing).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		for start := 0; start < len(ids); start += batchPageSize {
+			end := min(start+batchPageSize, len(ids))
+			if err := completeBatchItems(ctx, id, ids[start:end], remaining, ""); err != nil {
+				return err
+			}
+		}
+	}
+	now := time.Now()
+	_, err = gorm.G[models.MessageBatch](models.DB).Where("id = ?", id).Updates(ctx, models.MessageBatch{Status: BatchStatusEnded, EndedAt: &now})
+	return err
+}
+
+// CancelMessageBatch 取消批处理：尚未执行的请求标记为 canceled
+func CancelMessageBatch(ctx context.Context, batch *models.MessageBatch) error {
+	if batch.Status != BatchStatusInProgress {
+		return nil
+	}
+	now := time.Now()
+	if _, err := gorm.G[models.MessageBatch](models.DB).Where("id = ?", batch.ID).
+		Updates(ctx, models.MessageBatch{Status: BatchStatusCanceling, CancelInitiatedAt: &now}); err != nil {
+		return err
+	}
+	batch.Status, batch.CancelInitiatedAt = BatchStatusCanceling, &now
+	if cancel, ok := runningBatches.Load(batch.ID); ok {
+		cancel.(context.CancelFunc)()
+		return nil
+	}
+	// 没有执行中的任务（如刚重启），直接结束
+	return finishMessageBatch(ctx, batch.ID)
+}
+
+// DeleteMessageBatch 删除已结束的批处理及其结果
+func DeleteMessageBatch(ctx context.Context, batch models.MessageBatch) error {
+	if batch.Status != BatchStatusEnded {
+		return errors.New("batch is still in progress")
+	}
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("batch_id = ?", batch.ID).Delete(&models.MessageBatchItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.MessageBatch{}, batch.ID).Error
+	})
+}
+
+// batchErrorType 按 HTTP 状态码映射 Anthropic 错误类型
+func batchErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// batchErrorResult 将失败响应转换为 Anthropic 错误对象
+func batchErrorResult(status int, body []byte) string {
+	message := string(body)
+	for _, path := range []string{"error.message", "error", "message"} {
+		if value := gjson.GetBytes(body, path); value.Type == gjson.String && value.String() != "" {
+			message = value.String()
+			break
+		}
+	}
+	result, _ := json.Marshal(map[string]any{
+		"type":  "error",
+		"error": map[string]string{"type": batchErrorType(status), "message": message},
+	})
+	return string(result)
+}
+
+// BatchResultLine 结果文件中的一行
+func BatchResultLine(item models.MessageBatchItem) ([]byte, error) {
+	result := map[string]any{"type": item.Status}
+	switch item.Status {
+	case BatchItemSucceeded:
+		result["message"] = json.RawMessage(item.Result)
+	case BatchItemErrored:
+		result["error"] = json.RawMessage(item.Result)
+	}
+	return json.Marshal(map[string]any{"custom_id": item.CustomID, "result": result})
+}
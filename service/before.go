@@ -2,7 +2,9 @@ package service
 
 import (
 	"errors"
+	"log/slog"
 
+	"github.com/atopos31/llmio/models"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
@@ -13,7 +15,13 @@ type Before struct {
 	toolCall         bool
 	structuredOutput bool
 	image            bool
+	promptCache      bool   // 请求带有 cache_control 提示缓存标记
+	pinnedProvider   string // 客户端指定的供应商名称，由 PinProvider 写入
 	raw              []byte
+
+	contextTokens int // 估算的输入 token 数加请求的最大输出 token 数，用于按上下文窗口过滤关联
+
+	summary *models.ContextSummary // 上下文压缩记录，由 CompressContext 写入
 }
 
 type Beforer func(data []byte) (*Before, error)
@@ -65,7 +73,9 @@ func BeforerOpenAI(data []byte) (*Before, error) {
 		toolCall:         toolCall,
 		structuredOutput: structuredOutput,
 		image:            image,
+		promptCache:      hasCacheControl(data),
 		raw:              data,
+		contextTokens:    estimateContextTokens(model, data),
 	}, nil
 }
 
@@ -106,7 +116,9 @@ func BeforerOpenAIRes(data []byte) (*Before, error) {
 		toolCall:         toolCall,
 		structuredOutput: structuredOutput,
 		image:            image,
+		promptCache:      hasCacheControl(data),
 		raw:              data,
+		contextTokens:    estimateContextTokens(model, data),
 	}, nil
 }
 
@@ -141,9 +153,40 @@ func BeforerAnthropic(data []byte) (*Before, error) {
 		Model:            model,
 		Stream:           stream,
 		toolCall:         toolCall,
-		structuredOutput: toolCall,
+		structuredOutput: toolCall || gjson.GetBytes(data, "output_format").Exists(),
 		image:            image,
+		promptCache:      hasCacheControl(data),
 		raw:              data,
+		contextTokens:    estimateContextTokens(model, data),
+	}, nil
+}
+
+// BeforerEmbeddings embeddings 请求不支持流式，也不涉及工具调用等能力匹配
+func BeforerEmbeddings(data []byte) (*Before, error) {
+	model := gjson.GetBytes(data, "model").String()
+	if model == "" {
+		return nil, errors.New("model is empty")
+	}
+	if !gjson.GetBytes(data, "input").Exists() {
+		return nil, errors.New("input is empty")
+	}
+	return &Before{
+		Model: model,
+		raw:   data,
 	}, nil
 }
- 
+
+// estimateContextTokens 估算请求占用的上下文窗口：输入 token 估算值加请求的最大输出 token 数
+func estimateContextTokens(model string, data []byte) int {
+	tokens, err := EstimatePromptTokens(model, data)
+	if err != nil {
+		slog.Warn("estimate prompt tokens error", "error", err)
+		return 0
+	}
+	for _, key := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+		if value := gjson.GetBytes(data, key).Int(); value > 0 {
+			return tokens + int(value)
+		}
+	}
+	return tokens
+}
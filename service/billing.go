@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const billingMonthLayout = "2006-01"
+
+// billingColumnAliases 账单 CSV 列名别名，兼容各家导出格式
+var billingColumnAliases = map[string][]string{
+	"provider":          {"provider", "provider_name", "vendor"},
+	"month":             {"month", "period", "billing_month"},
+	"date":              {"date", "day", "usage_date", "timestamp"},
+	"model":             {"model", "provider_model", "model_name"},
+	"requests":          {"requests", "request_count", "num_requests", "calls"},
+	"prompt_tokens":     {"prompt_tokens", "input_tokens", "n_context_tokens_total"},
+	"completion_tokens": {"completion_tokens", "output_tokens", "n_generated_tokens_total"},
+	"total_tokens":      {"total_tokens", "tokens"},
+	"cost":              {"cost", "amount", "cost_usd", "spend"},
+}
+
+// ParseBillingCSV 解析供应商账单 CSV，按 供应商/月份/模型 聚合
+// defaultProvider 用于 CSV 中没有供应商列的情况
+func ParseBillingCSV(r io.Reader, defaultProvider, source string) ([]models.BillingRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		for field, aliases := range billingColumnAliases {
+			if _, ok := columns[field]; ok {
+				continue
+			}
+			for _, alias := range aliases {
+				if name == alias {
+					columns[field] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["month"]; !ok {
+		if _, ok := columns["date"]; !ok {
+			return nil, errors.New("csv must contain a month or date column")
+		}
+	}
+	if _, ok := columns["provider"]; !ok && defaultProvider == "" {
+		return nil, errors.New("csv has no provider column, provider parameter is required")
+	}
+
+	type recordKey struct{ provider, month, model string }
+	aggregated := make(map[recordKey]*models.BillingRecord)
+	var keys []recordKey
+
+	line := 1
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		get := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		month, err := parseBillingMonth(get("month"), get("date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		provider := get("provider")
+		if provider == "" {
+			provider = defaultProvider
+		}
+
+		key := recordKey{provider: provider, month: month, model: get("model")}
+		record, ok := aggregated[key]
+		if !ok {
+			record = &models.BillingRecord{ProviderName: provider, Month: month, ProviderModel: key.model, Source: source}
+			aggregated[key] = record
+			keys = append(keys, key)
+		}
+
+		requests, err := parseBillingInt(get("requests"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid requests: %w", line, err)
+		}
+		promptTokens, err := parseBillingInt(get("prompt_tokens"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid prompt tokens: %w", line, err)
+		}
+		completionTokens, err := parseBillingInt(get("completion_tokens"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid completion tokens: %w", line, err)
+		}
+		totalTokens, err := parseBillingInt(get("total_tokens"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid total tokens: %w", line, err)
+		}
+		if totalTokens == 0 {
+			totalTokens = promptTokens + completionTokens
+		}
+		cost, err := parseBillingFloat(get("cost"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid cost: %w", line, err)
+		}
+
+		record.Requests += requests
+		record.PromptTokens += promptTokens
+		record.CompletionTokens += completionTokens
+		record.TotalTokens += totalTokens
+		record.Cost += cost
+	}
+
+	records := make([]models.BillingRecord, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, *aggregated[key])
+	}
+	return records, nil
+}
+
+func parseBillingMonth(month, date string) (string, error) {
+	if month != "" {
+		if _, err := time.Parse(billingMonthLayout, month); err != nil {
+			return "", fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+		}
+		return month, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return t.Format(billingMonthLayout), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", date)
+}
+
+func parseBillingInt(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(f)), nil
+}
+
+func parseBillingFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(strings.TrimPrefix(strings.ReplaceAll(value, ",", ""), "$"), 64)
+}
+
+// ImportBillingRecords 导入账单记录，同一 供应商+月份 的旧记录会被整体替换，保证重复导入幂等
+func ImportBillingRecords(ctx context.Context, records []models.BillingRecord) error {
+	return models.DB.Transaction(func(tx *gorm.DB) error {
+		replaced := make(map[string]bool)
+		for _, record := range records {
+			key := record.ProviderName + "|" + record.Month
+			if replaced[key] {
+				continue
+			}
+			replaced[key] = true
+			if _, err := gorm.G[models.BillingRecord](tx).
+				Where("provider_name = ? AND month = ?", record.ProviderName, record.Month).
+				Delete(ctx); err != nil {
+				return err
+			}
+		}
+		for i := range records {
+			if err := gorm.G[models.BillingRecord](tx).Create(ctx, &records[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BillingUsage 对账用量汇总
+type BillingUsage struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"`
+}
+
+// BillingVariance 单个供应商单月的对账结果
+type BillingVariance struct {
+	ProviderName        string       `json:"provider_name"`
+	Month               string       `json:"month"`
+	Billed              BillingUsage `json:"billed"`
+	Logged              BillingUsage `json:"logged"`
+	RequestVariance     int64        `json:"request_variance"`       // 账单 - 日志
+	TokenVariance       int64        `json:"token_variance"`         // 账单 - 日志
+	TokenVariancePct    float64      `json:"token_variance_pct"`     // 相对账单的偏差百分比
+	PricePerMillion     float64      `json:"price_per_million"`      // 账单有效单价（每百万 token）
+	PrevPricePerMillion float64      `json:"prev_price_per_million"` // 上月有效单价
+	Flags               []string     `json:"flags"`
+}
+
+const (
+	BillingFlagUnloggedTraffic = "unlogged_traffic" // 账单用量明显高于本地记录
+	BillingFlagUnbilledTraffic = "unbilled_traffic" // 本地记录明显高于账单
+	BillingFlagRequestMismatch = "request_mismatch" // 请求数偏差超出容忍度
+	BillingFlagPriceDrift      = "price_drift"      // 有效单价相对上月变化超出容忍度
+)
+
+// ReconcileBilling 将导入的账单与 ChatLog 成功记录按 供应商/月份 对账
+// month、providerName 为空表示不过滤；tolerance 为允许的相对偏差（如 0.05）
+func ReconcileBilling(ctx context.Context, month, providerName string, tolerance float64) ([]BillingVariance, error) {
+	query := gorm.G[models.BillingRecord](models.DB).Where("1 = 1")
+	if month != "" {
+		query = query.Where("month = ?", month)
+	}
+	if providerName != "" {
+		query = query.Where("provider_name = ?", providerName)
+	}
+	records, err := query.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type groupKey struct{ provider, month string }
+	billed := make(map[groupKey]*BillingUsage)
+	var keys []groupKey
+	for _, record := range records {
+		key := groupKey{record.ProviderName, record.Month}
+		usage, ok := billed[key]
+		if !ok {
+			usage = &BillingUsage{}
+			billed[key] = usage
+			keys = append(keys, key)
+		}
+		usage.Requests += record.Requests
+		usage.PromptTokens += record.PromptTokens
+		usage.CompletionTokens += record.CompletionTokens
+		usage.TotalTokens += record.TotalTokens
+		usage.Cost += record.Cost
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].month != keys[j].month {
+			return keys[i].month < keys[j].month
+		}
+		return keys[i].provider < keys[j].provider
+	})
+
+	result := make([]BillingVariance, 0, len(keys))
+	for _, key := range keys {
+		logged, err := loggedUsage(ctx, key.provider, key.month)
+		if err != nil {
+			return nil, err
+		}
+		bill := billed[key]
+		variance := BillingVariance{
+			ProviderName:    key.provider,
+			Month:           key.month,
+			Billed:          *bill,
+			Logged:          *logged,
+			RequestVariance: bill.Requests - logged.Requests,
+			TokenVariance:   bill.TotalTokens - logged.TotalTokens,
+			PricePerMillion: pricePerMillion(bill.Cost, bill.TotalTokens),
+			Flags:           []string{},
+		}
+		if bill.TotalTokens > 0 {
+			variance.TokenVariancePct = float64(variance.TokenVariance) / float64(bill.TotalTokens) * 100
+		}
+		if exceeds(bill.TotalTokens, logged.TotalTokens, tolerance) {
+			variance.Flags = append(variance.Flags, BillingFlagUnloggedTraffic)
+		}
+		if exceeds(logged.TotalTokens, bill.TotalTokens, tolerance) {
+			variance.Flags = append(variance.Flags, BillingFlagUnbilledTraffic)
+		}
+		if bill.Requests > 0 && (exceeds(bill.Requests, logged.Requests, tolerance) || exceeds(logged.Requests, bill.Requests, tolerance)) {
+			variance.Flags = append(variance.Flags, BillingFlagRequestMismatch)
+		}
+
+		if prev, err := previousMonthPrice(ctx, key.provider, key.month); err == nil && prev > 0 && variance.PricePerMillion > 0 {
+			variance.PrevPricePerMillion = prev
+			if math.Abs(variance.PricePerMillion-prev)/prev > tolerance {
+				variance.Flags = append(variance.Flags, BillingFlagPriceDrift)
+			}
+		}
+		result = append(result, variance)
+	}
+	return result, nil
+}
+
+// exceeds 判断 a 是否超过 b 且超出相对容忍度
+func exceeds(a, b int64, tolerance float64) bool {
+	if a <= b {
+		return false
+	}
+	if b == 0 {
+		return true
+	}
+	return float64(a-b)/float64(b) > tolerance
+}
+
+func pricePerMillion(cost float64, tokens int64) float64 {
+	if tokens <= 0 {
+		return 0
+	}
+	return cost / float64(tokens) * 1e6
+}
+
+// loggedUsage 汇总指定供应商在某月的成功请求用量
+func loggedUsage(ctx context.Context, providerName, month string) (*BillingUsage, error) {
+	start, err := time.ParseInLocation(billingMonthLayout, month, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	var usage BillingUsage
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("COALESCE(SUM(sample_weight), 0) AS requests, COALESCE(SUM(prompt_tokens * sample_weight), 0) AS prompt_tokens, COALESCE(SUM(completion_tokens * sample_weight), 0) AS completion_tokens, COALESCE(SUM(total_tokens * sample_weight), 0) AS total_tokens").
+		Where("provider_name = ?", providerName).
+		Where("status = ?", "success").
+		Where("created_at >= ? AND created_at < ?", start, start.AddDate(0, 1, 0)).
+		Scan(&usage).Error; err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// previousMonthPrice 获取上月账单的有效单价
+func previousMonthPrice(ctx context.Context, providerName, month string) (float64, error) {
+	start, err := time.Parse(billingMonthLayout, month)
+	if err != nil {
+		return 0, err
+	}
+	records, err := gorm.G[models.BillingRecord](models.DB).
+		Where("provider_name = ? AND month = ?", providerName, start.AddDate(0, -1, 0).Format(billingMonthLayout)).
+		Find(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var cost float64
+	var tokens int64
+	for _, record := range records {
+		cost += record.Cost
+		tokens += record.TotalTokens
+	}
+	return pricePerMillion(cost, tokens), nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestParseBillingCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		provider string
+		want     []models.BillingRecord
+		wantErr  string
+	}{
+		{
+			name: "aliases and aggregation",
+			csv: "\ufeffVendor,usage_date,model_name,calls,input_tokens,output_tokens,amount\n" +
+				"openai,2026-03-01,gpt-4o,\"1,000\",500,200,$1.50\n" +
+				"openai,2026-03-15 10:00:00,gpt-4o,10,50,20,0.25\n" +
+				"openai,2026-04-01,gpt-4o,1,5,2,0.01\n",
+			want: []models.BillingRecord{
+				{ProviderName: "openai", Month: "2026-03", ProviderModel: "gpt-4o", Requests: 1010, PromptTokens: 550, CompletionTokens: 220, TotalTokens: 770, Cost: 1.75, Source: "bill.csv"},
+				{ProviderName: "openai", Month: "2026-04", ProviderModel: "gpt-4o", Requests: 1, PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7, Cost: 0.01, Source: "bill.csv"},
+			},
+		},
+		{
+			name:     "default provider and total tokens column",
+			csv:      "month,tokens,cost\n2026-03,900,2\n",
+			provider: "azure",
+			want:     []models.BillingRecord{{ProviderName: "azure", Month: "2026-03", TotalTokens: 900, Cost: 2, Source: "bill.csv"}},
+		},
+		{name: "missing month and date", csv: "provider,cost\nopenai,1\n", wantErr: "month or date"},
+		{name: "missing provider", csv: "month,cost\n2026-03,1\n", wantErr: "provider parameter is required"},
+		{name: "invalid month", csv: "provider,month\nopenai,March\n", wantErr: "line 2: invalid month"},
+		{name: "invalid cost", csv: "provider,month,cost\nopenai,2026-03,abc\n", wantErr: "line 2: invalid cost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := ParseBillingCSV(strings.NewReader(tt.csv), tt.provider, "bill.csv")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBillingCSV failed: %v", err)
+			}
+			if !slices.Equal(records, tt.want) {
+				t.Errorf("records = %+v, want %+v", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileBilling(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+
+	march := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	logs := []models.ChatLog{
+		{ProviderName: "openai", Status: "success", SampleWeight: 1},
+		{ProviderName: "openai", Status: "success", SampleWeight: 4}, // 采样记录代表 4 个请求
+		{ProviderName: "openai", Status: "error", SampleWeight: 1},
+	}
+	for i := range logs {
+		logs[i].CreatedAt = march
+		logs[i].TotalTokens = 100
+	}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	records := []models.BillingRecord{
+		{ProviderName: "openai", Month: "2026-02", TotalTokens: 1000, Cost: 1},
+		{ProviderName: "openai", Month: "2026-03", Requests: 5, TotalTokens: 400, Cost: 1},
+		{ProviderName: "openai", Month: "2026-03", Requests: 5, TotalTokens: 400, Cost: 1},
+	}
+	if err := ImportBillingRecords(ctx, records); err != nil {
+		t.Fatal(err)
+	}
+	// 重复导入同一账期时替换旧记录
+	if err := ImportBillingRecords(ctx, []models.BillingRecord{{ProviderName: "openai", Month: "2026-03", Requests: 10, TotalTokens: 800, Cost: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ReconcileBilling(ctx, "2026-03", "", 0.05)
+	if err != nil {
+		t.Fatalf("ReconcileBilling failed: %v", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	variance := result[0]
+	if variance.Billed.TotalTokens != 800 || variance.Logged.Requests != 5 || variance.Logged.TotalTokens != 500 {
+		t.Errorf("billed = %+v, logged = %+v", variance.Billed, variance.Logged)
+	}
+	if variance.RequestVariance != 5 || variance.TokenVariance != 300 || variance.TokenVariancePct != 37.5 {
+		t.Errorf("variance = %+v", variance)
+	}
+	if variance.PricePerMillion != 2500 || variance.PrevPricePerMillion != 1000 {
+		t.Errorf("price = %v, previous = %v", variance.PricePerMillion, variance.PrevPricePerMillion)
+	}
+	want := []string{BillingFlagUnloggedTraffic, BillingFlagRequestMismatch, BillingFlagPriceDrift}
+	if !slices.Equal(variance.Flags, want) {
+		t.Errorf("flags = %v, want %v", variance.Flags, want)
+	}
+}
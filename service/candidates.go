@@ -0,0 +1,60 @@
+package service
+
+import "maps"
+
+// candidateSet 单次请求的候选关联及其权重与优先级，重试循环中移除失败的关联、降低限流关联的权重；
+// 创建时复制 ProvidersWithMeta 中的数据，修改不影响备用模型切换等共享同一份元数据的调用，
+// 集合只由处理该请求的 goroutine 使用，不加锁
+type candidateSet struct {
+	weights    map[uint]int
+	priorities map[uint]int
+}
+
+// newCandidateSet 复制权重与优先级创建候选集合
+func newCandidateSet(weights, priorities map[uint]int) *candidateSet {
+	return &candidateSet{weights: maps.Clone(weights), priorities: maps.Clone(priorities)}
+}
+
+// candidatesOf 以加载得到的候选供应商创建本次请求的候选集合
+func candidatesOf(meta ProvidersWithMeta) *candidateSet {
+	return newCandidateSet(meta.WeightItems, meta.PriorityItems)
+}
+
+func (s *candidateSet) len() int {
+	return len(s.weights)
+}
+
+func (s *candidateSet) has(id uint) bool {
+	_, ok := s.weights[id]
+	return ok
+}
+
+// remove 将关联移出候选，本次请求不再选择
+func (s *candidateSet) remove(id uint) {
+	delete(s.weights, id)
+	delete(s.priorities, id)
+}
+
+// penalize 上游限流（429）时将关联权重降低三分之一，至少降低 1 且不低于 1，
+// 关联保留在候选中，退避后仍可能被选中
+func (s *candidateSet) penalize(id uint) {
+	weight, ok := s.weights[id]
+	if !ok {
+		return
+	}
+	s.weights[id] = max(weight-max(weight/3, 1), 1)
+}
+
+// pick 优先选择优先级最高的候选，优先级相同时按权重随机选择
+func (s *candidateSet) pick() (*uint, error) {
+	return selectByPriorityAndWeight(s.weights, s.priorities)
+}
+
+// without 返回排除 ids 后的新集合，原集合不变
+func (s *candidateSet) without(ids []uint) *candidateSet {
+	next := newCandidateSet(s.weights, s.priorities)
+	for _, id := range ids {
+		next.remove(id)
+	}
+	return next
+}
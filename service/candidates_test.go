@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestCandidateSet(t *testing.T) {
+	weights, priorities := map[uint]int{1: 9, 2: 1}, map[uint]int{1: 200, 2: 100}
+	candidates := newCandidateSet(weights, priorities)
+
+	// 优先选择高优先级
+	if id, err := candidates.pick(); err != nil || *id != 1 {
+		t.Fatalf("Expected highest priority candidate, got %v, %v", id, err)
+	}
+	// 限流只降低权重，不移出候选
+	candidates.penalize(1)
+	if candidates.weights[1] != 6 || !candidates.has(1) {
+		t.Errorf("Expected weight 9 to drop to 6, got %v", candidates.weights)
+	}
+	for range 10 {
+		candidates.penalize(2)
+	}
+	if candidates.weights[2] != 1 {
+		t.Errorf("Expected weight to stay at least 1, got %d", candidates.weights[2])
+	}
+
+	candidates.remove(1)
+	if id, err := candidates.pick(); err != nil || *id != 2 {
+		t.Fatalf("Expected remaining candidate, got %v, %v", id, err)
+	}
+	candidates.remove(2)
+	if _, err := candidates.pick(); err == nil || candidates.len() != 0 {
+		t.Errorf("Expected exhausted candidates to fail")
+	}
+	// 集合是副本，不修改加载得到的数据
+	if len(weights) != 2 || len(priorities) != 2 || weights[1] != 9 {
+		t.Errorf("Expected source maps to be unchanged, got %v %v", weights, priorities)
+	}
+}
+
+func TestBalanceModelRateLimited(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusTooManyRequests, `{"error":{"message":"slow down"}}`))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "limited", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 3)
+
+	before, meta := loadTestCandidates(t)
+	_, _, err := balanceModel(context.Background(), time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+	if err == nil {
+		t.Fatal("Expected rate limited provider to fail")
+	}
+	// 429 只降低权重，同一关联在每次重试中仍可被选择
+	if got := len(upstream.Requests()); got != meta.MaxRetry {
+		t.Errorf("Expected %d attempts on the rate limited provider, got %d", meta.MaxRetry, got)
+	}
+	for _, weight := range meta.WeightItems {
+		if weight != 3 {
+			t.Errorf("Expected loaded weights to be unchanged, got %v", meta.WeightItems)
+		}
+	}
+}
+
+func TestBalanceModelSharedMeta(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	failing := testutil.NewUpstream(t, testutil.JSON(http.StatusInternalServerError, `{"error":{"message":"boom"}}`))
+	healthy := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "ok", 1, 1)))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "failing", consts.StyleOpenAI, failing.URL), "upstream-model", 200, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "healthy", consts.StyleOpenAI, healthy.URL), "upstream-model", 100, 1)
+
+	before, meta := loadTestCandidates(t)
+	// 同一份元数据被多个请求并发使用时，各自的候选集合互不影响（配合 -race 检查）
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			res, _, err := balanceModel(context.Background(), time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+			if err != nil {
+				t.Errorf("Expected failover to the healthy provider, got %v", err)
+				return
+			}
+			res.Body.Close()
+		})
+	}
+	wg.Wait()
+	if len(meta.WeightItems) != 2 || len(meta.PriorityItems) != 2 {
+		t.Errorf("Expected shared candidates to be unchanged, got %v %v", meta.WeightItems, meta.PriorityItems)
+	}
+	if len(failing.Requests()) != 4 || len(healthy.Requests()) != 4 {
+		t.Errorf("Expected every request to try both providers once, got %d / %d", len(failing.Requests()), len(healthy.Requests()))
+	}
+}
+
+func TestPinnedProviderSkipsDraining(t *testing.T) {
+	testutil.SetupDB(t)
+	model := testutil.SeedModel(t, "test-model")
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "ok", 1, 1)))
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, upstream.URL), "upstream-model", 200, 1)
+	draining := testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "debug", consts.StyleOpenAI, upstream.URL), "upstream-model", 100, 1)
+
+	drainMu.Lock()
+	drainJobs[draining.ID] = &drainJob{status: DrainStatus{ModelWithProviderID: draining.ID, State: DrainStateDraining}, cancel: func() {}}
+	drainMu.Unlock()
+	t.Cleanup(func() {
+		drainMu.Lock()
+		delete(drainJobs, draining.ID)
+		drainMu.Unlock()
+	})
+
+	// 指定排空中的供应商时不能绕过排空，直接返回错误
+	for _, pin := range []struct{ body, header string }{
+		{`{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`, "debug"},
+		{`{"model":"test-model@debug","messages":[{"role":"user","content":"hi"}]}`, ""},
+	} {
+		before, err := BeforerOpenAI([]byte(pin.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PinProvider(context.Background(), before, pin.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadProvidersWithMeta(context.Background(), *before); err == nil || !strings.Contains(err.Error(), "draining") {
+			t.Errorf("pinned to draining provider: err = %v", err)
+		}
+	}
+}
+
+func loadTestCandidates(t *testing.T) (*Before, *ProvidersWithMeta) {
+	t.Helper()
+	before, err := BeforerOpenAI([]byte(`{"model":"test-model","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := loadProvidersWithMeta(context.Background(), *before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return before, meta
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultCatalogURL OpenRouter 模型目录
+const DefaultCatalogURL = "https://openrouter.ai/api/v1/models"
+
+// catalogMaxBody 目录响应体上限
+const catalogMaxBody = 32 << 20
+
+// catalogModel OpenRouter 风格目录中的单个模型
+type catalogModel struct {
+	ID            string `json:"id"`
+	CanonicalSlug string `json:"canonical_slug"`
+	ContextLength int    `json:"context_length"`
+	Architecture  struct {
+		InputModalities  []string `json:"input_modalities"`
+		OutputModalities []string `json:"output_modalities"`
+	} `json:"architecture"`
+	Pricing struct {
+		Prompt         string `json:"prompt"`           // 每 token 美元单价
+		Completion     string `json:"completion"`       // 每 token 美元单价
+		InputCacheRead string `json:"input_cache_read"` // 每 token 美元单价
+	} `json:"pricing"`
+	TopProvider struct {
+		ContextLength       int `json:"context_length"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"`
+}
+
+// CatalogImportOptions 目录导入选项
+type CatalogImportOptions struct {
+	URL            string // 目录地址，为空时使用 OpenRouter
+	ProviderID     uint   // 只处理该供应商的关联，0 表示全部
+	ImportPricing  bool   // 同时写入定价
+	OverwritePrice bool   // 覆盖已有定价，否则只为没有定价的关联写入
+}
+
+// CatalogImportResult 目录导入结果
+type CatalogImportResult struct {
+	CatalogModels  int      `json:"catalog_models"`  // 目录中的模型数
+	Matched        int      `json:"matched"`         // 写入元数据的关联数
+	PricingUpdated int      `json:"pricing_updated"` // 写入定价的关联数
+	Unmatched      []string `json:"unmatched"`       // 未在目录中找到的供应商模型
+}
+
+// fetchCatalog 拉取 OpenRouter 风格的模型目录
+func fetchCatalog(ctx context.Context, url string) ([]catalogModel, error) {
+	if url == "" {
+		url = DefaultCatalogURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := providers.GetClientWithProxy(30*time.Second, "").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	var catalog struct {
+		Data []catalogModel `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, catalogMaxBody)).Decode(&catalog); err != nil {
+		return nil, err
+	}
+	return catalog.Data, nil
+}
+
+// catalogIndex 按目录 ID 与去掉厂商前缀后的名称索引目录模型，不区分大小写
+func catalogIndex(catalog []catalogModel) map[string]catalogModel {
+	index := make(map[string]catalogModel, len(catalog)*2)
+	for _, model := range catalog {
+		for _, key := range []string{model.ID, model.CanonicalSlug} {
+			if key == "" {
+				continue
+			}
+			key = strings.ToLower(key)
+			index[key] = model
+			if _, name, ok := strings.Cut(key, "/"); ok {
+				// 去掉前缀后的名称可能重名，保留第一个
+				if _, exists := index[name]; !exists {
+					index[name] = model
+				}
+			}
+		}
+	}
+	return index
+}
+
+// matchCatalog 为供应商模型名查找目录条目
+func matchCatalog(index map[string]catalogModel, providerModel string) (catalogModel, bool) {
+	key := strings.ToLower(strings.TrimSpace(providerModel))
+	if model, ok := index[key]; ok {
+		return model, true
+	}
+	if _, name, ok := strings.Cut(key, "/"); ok {
+		model, ok := index[name]
+		return model, ok
+	}
+	return catalogModel{}, false
+}
+
+// perMillion 将每 token 美元单价转为每百万 token 单价
+func perMillion(price string) float64 {
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	// 消除浮点误差，保留到 1e-6
+	return math.Round(value*1e12) / 1e6
+}
+
+// metadata 转为关联上保存的元数据
+func (m catalogModel) metadata(source string) *models.ModelMetadata {
+	contextLength := m.ContextLength
+	if m.TopProvider.ContextLength > 0 {
+		contextLength = m.TopProvider.ContextLength
+	}
+	return &models.ModelMetadata{
+		CatalogID:        m.ID,
+		ContextLength:    contextLength,
+		MaxOutputTokens:  m.TopProvider.MaxCompletionTokens,
+		InputModalities:  m.Architecture.InputModalities,
+		OutputModalities: m.Architecture.OutputModalities,
+		InputPrice:       perMillion(m.Pricing.Prompt),
+		OutputPrice:      perMillion(m.Pricing.Completion),
+		CachedPrice:      perMillion(m.Pricing.InputCacheRead),
+		Source:           source,
+		ImportedAt:       time.Now(),
+	}
+}
+
+// ImportCatalog 拉取模型目录并按供应商模型名匹配关联，写入上下文长度、模态与定价等元数据
+func ImportCatalog(ctx context.Context, opts CatalogImportOptions) (*CatalogImportResult, error) {
+	source := opts.URL
+	if source == "" {
+		source = DefaultCatalogURL
+	}
+	catalog, err := fetchCatalog(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	index := catalogIndex(catalog)
+
+	query := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{})
+	if opts.ProviderID != 0 {
+		query = query.Where("provider_id = ?", opts.ProviderID)
+	}
+	var associations []models.ModelWithProvider
+	if err := query.Find(&associations).Error; err != nil {
+		return nil, err
+	}
+
+	result := &CatalogImportResult{CatalogModels: len(catalog), Unmatched: make([]string, 0)}
+	unmatched := make(map[string]struct{})
+	for _, association := range associations {
+		entry, ok := matchCatalog(index, association.ProviderModel)
+		if !ok {
+			if _, seen := unmatched[association.ProviderModel]; !seen {
+				unmatched[association.ProviderModel] = struct{}{}
+				result.Unmatched = append(result.Unmatched, association.ProviderModel)
+			}
+			continue
+		}
+		metadata := entry.metadata(source)
+		if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).
+			Updates(ctx, models.ModelWithProvider{Metadata: metadata}); err != nil {
+			return nil, err
+		}
+		result.Matched++
+
+		if !opts.ImportPricing || (metadata.InputPrice == 0 && metadata.OutputPrice == 0) {
+			continue
+		}
+		pricing := models.Pricing{
+			ModelWithProviderID: association.ID,
+			InputPrice:          metadata.InputPrice,
+			OutputPrice:         metadata.OutputPrice,
+			CachedPrice:         metadata.CachedPrice,
+		}
+		onConflict := clause.OnConflict{Columns: []clause.Column{{Name: "model_with_provider_id"}}, DoNothing: true}
+		if opts.OverwritePrice {
+			onConflict = clause.OnConflict{
+				Columns:   []clause.Column{{Name: "model_with_provider_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"input_price", "output_price", "cached_price", "updated_at"}),
+			}
+		}
+		res := models.DB.WithContext(ctx).Clauses(onConflict).Create(&pricing)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected > 0 {
+			result.PricingUpdated++
+		}
+	}
+	return result, nil
+}
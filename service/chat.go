@@ -13,18 +13,99 @@ import (
 	"time"
 
 	"github.com/atopos31/llmio/balancer"
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
 	"github.com/atopos31/llmio/providers"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
+// BalanceChat 在模型的供应商间负载均衡转发请求，全部失败或不可用时按顺序切换到备用模型的供应商
 func BalanceChat(ctx context.Context, start time.Time, style string, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta) (*http.Response, uint, error) {
-	slog.Info("request", "model", before.Model, "stream", before.Stream, "tool_call", before.toolCall, "structured_output", before.structuredOutput, "image", before.image)
+	// 被所有供应商反复拒绝的请求在冷却期内直接返回缓存的上游错误
+	if err := requestQuarantines.check(style, before); err != nil {
+		slog.Warn("request short-circuited by quarantine", "model", before.Model, "error", err)
+		return nil, 0, err
+	}
+	MirrorShadow(style, before, providersWithMeta, reqMeta.Header)
+	res, logId, err := balanceAllModels(ctx, start, style, before, providersWithMeta, reqMeta)
+	if ctx.Err() == nil {
+		requestQuarantines.record(ctx, style, before, err)
+	}
+	return res, logId, err
+}
+
+// balanceAllModels 先尝试主模型，失败后按顺序切换备用模型
+func balanceAllModels(ctx context.Context, start time.Time, style string, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta) (*http.Response, uint, error) {
+	res, logId, err := balanceModel(ctx, start, style, before, providersWithMeta, reqMeta)
+	if err == nil || ctx.Err() != nil {
+		return res, logId, err
+	}
+	// 只有所有模型的上游都拒绝了请求内容，才视为请求本身的问题
+	rejected := isRejected(err)
+	tried := map[string]bool{providersWithMeta.ServedModel: true}
+	for _, name := range providersWithMeta.Fallbacks {
+		if tried[name] {
+			continue
+		}
+		tried[name] = true
+
+		fallbackBefore := before
+		fallbackBefore.Model = name
+		fallback, loadErr := loadProvidersWithMeta(ctx, fallbackBefore)
+		if loadErr != nil {
+			slog.Warn("fallback model unavailable", "model", before.Model, "fallback", name, "error", loadErr)
+			continue
+		}
+		slog.Info("falling back to model", "model", before.Model, "fallback", name, "error", err)
+		// 日志级别、限流、响应规则等仍沿用主模型配置，只替换候选供应商与重试参数
+		meta := providersWithMeta
+		meta.ModelWithProviderMap = fallback.ModelWithProviderMap
+		meta.WeightItems = fallback.WeightItems
+		meta.PriorityItems = fallback.PriorityItems
+		meta.ProviderMap = fallback.ProviderMap
+		meta.MaxRetry = fallback.MaxRetry
+		meta.TimeOut = fallback.TimeOut
+		meta.Timeouts = fallback.Timeouts
+		meta.ServedModel = fallback.ServedModel
+		meta.StickySession, meta.StickySessionTTL = fallback.StickySession, fallback.StickySessionTTL
+		meta.RetryPolicy = fallback.RetryPolicy
+		res, logId, err = balanceModel(ctx, start, style, before, meta, reqMeta)
+		if err == nil || ctx.Err() != nil {
+			return res, logId, err
+		}
+		rejected = rejected && isRejected(err)
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		upstreamErr.Rejected = rejected
+	}
+	return nil, 0, err
+}
+
+// isRejected 错误是否表示上游拒绝了请求内容
+func isRejected(err error) bool {
+	var upstreamErr *UpstreamError
+	return errors.As(err, &upstreamErr) && upstreamErr.Rejected
+}
+
+// balanceModel 在单个模型的供应商间按优先级与权重选择并重试
+func balanceModel(ctx context.Context, start time.Time, style string, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta) (*http.Response, uint, error) {
+	slog.Info("request", "model", before.Model, "stream", before.Stream, "tool_call", before.toolCall, "structured_output", before.structuredOutput, "image", before.image, "prompt_cache", before.promptCache)
 
 	providerMap := providersWithMeta.ProviderMap
-	weightItems := providersWithMeta.WeightItems
-	priorityItems := providersWithMeta.PriorityItems
+	candidates := candidatesOf(providersWithMeta)
+
+	promptCacheRouting := PromptCacheRoutingOff
+	if before.promptCache {
+		promptCacheRouting = getPromptCacheRouting(ctx)
+	}
+	promptCacheAutoInject := getPromptCacheAutoInject(ctx)
+	var emptyStreamHandling string
+	if before.Stream {
+		emptyStreamHandling = getEmptyStreamHandling(ctx)
+	}
 
 	// 收集重试过程中的err日志
 	retryLog := make(chan models.ChatLog, providersWithMeta.MaxRetry)
@@ -35,8 +116,27 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 	// 注意：这里我们需要在循环中为每个provider创建带代理的client
 	// 所以先移除这行，在循环内部创建
 
+	// 会话粘滞：同一会话优先复用上次成功的供应商，提高上游提示缓存命中率
+	session := sessionID(style, before, reqMeta.Header)
+	var affinityKey string
+	if providersWithMeta.StickySession {
+		affinityKey = sessionAffinityKey(providersWithMeta.ServedModel, session)
+	}
+
 	timer := time.NewTimer(time.Second * time.Duration(providersWithMeta.TimeOut))
 	defer timer.Stop()
+	loopStart := time.Now()
+	// 记录上游失败，候选耗尽时随错误返回
+	var lastUpstream string
+	upstreamFailures, rejectedFailures := 0, 0
+	// 已返回响应头、输出内容前失败并切换的次数，记录到之后每次尝试的日志
+	headerFailovers := 0
+	upstreamError := func(err error) error {
+		if upstreamFailures == 0 {
+			return err
+		}
+		return &UpstreamError{Err: err, Last: lastUpstream, Rejected: rejectedFailures == upstreamFailures}
+	}
 	for retry := range providersWithMeta.MaxRetry {
 		select {
 		case <-ctx.Done():
@@ -44,16 +144,69 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 		case <-timer.C:
 			return nil, 0, errors.New("retry time out")
 		default:
+			// 重试前按模型的重试策略退避
+			var retryDelay time.Duration
+			if retry > 0 {
+				delay, policyErr := providersWithMeta.RetryPolicy.delay(providersWithMeta.ServedModel, retry, time.Since(loopStart))
+				if policyErr != nil {
+					return nil, 0, policyErr
+				}
+				if delay > 0 {
+					wait := time.NewTimer(delay)
+					select {
+					case <-ctx.Done():
+						wait.Stop()
+						return nil, 0, ctx.Err()
+					case <-timer.C:
+						wait.Stop()
+						return nil, 0, errors.New("retry time out")
+					case <-wait.C:
+					}
+				}
+				retryDelay = delay
+			}
+
+			// 按供应商 TPM 预测排除发送后会超限的关联，改由其他供应商承接；全部超限时延后到窗口用量回落再发送
+			available, tpmWait := tpmCandidates(candidates, providersWithMeta, before.contextTokens, time.Now())
+			for tpmWait > 0 {
+				slog.Info("deferring request for provider tpm", "model", before.Model, "wait", tpmWait)
+				wait := time.NewTimer(tpmWait)
+				select {
+				case <-ctx.Done():
+					wait.Stop()
+					return nil, 0, ctx.Err()
+				case <-timer.C:
+					wait.Stop()
+					return nil, 0, errors.New("retry time out")
+				case <-wait.C:
+				}
+				available, tpmWait = tpmCandidates(candidates, providersWithMeta, before.contextTokens, time.Now())
+			}
+
 			// 根据优先级和权重选择供应商
-			id, err := selectByPriorityAndWeight(weightItems, priorityItems)
+			_, selectSpan := StartSpan(ctx, "balancer.select", SpanKindInternal, "llmio.model", providersWithMeta.ServedModel, "llmio.retry", retry)
+			id, err := available.pick()
 			if err != nil {
-				return nil, 0, err
+				selectSpan.RecordError(err)
+				selectSpan.End()
+				return nil, 0, upstreamError(err)
 			}
+			// 首次尝试时命中粘滞缓存且该关联仍可用，则沿用之前的供应商
+			if retry == 0 && affinityKey != "" {
+				if sticky, ok := sessionAffinity.get(affinityKey); ok {
+					if available.has(sticky) {
+						id = &sticky
+						selectSpan.SetAttributes("llmio.sticky", true)
+					}
+				}
+			}
+			selectSpan.SetAttributes("llmio.model_provider_id", *id)
+			selectSpan.End()
 
 			modelWithProvider, ok := providersWithMeta.ModelWithProviderMap[*id]
 			if !ok {
 				// 数据不一致，移除该模型避免下次重复命中
-				delete(weightItems, *id)
+				candidates.remove(*id)
 				continue
 			}
 
@@ -65,23 +218,34 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 				return nil, 0, err
 			}
 
-			// 为当前provider创建带代理的client
-			// 使用完整的超时时间,特别是对于工具调用场景需要更长的等待时间
-			client := providers.GetClientWithProxy(time.Second*time.Duration(providersWithMeta.TimeOut), chatModel.GetProxy())
+			// 为当前provider创建带代理的client，连接与首字分别按模型配置的超时限制
+			client := providersWithMeta.Timeouts.client(chatModel.GetProxy())
 
 			slog.Info("using provider", "provider", provider.Name, "model", modelWithProvider.ProviderModel, "proxy", chatModel.GetProxy())
 
 			log := models.ChatLog{
-				Name:          before.Model,
-				ProviderModel: modelWithProvider.ProviderModel,
-				ProviderName:  provider.Name,
-				Status:        "success",
-				Style:         style,
-				UserAgent:     reqMeta.UserAgent,
-				RemoteIP:      reqMeta.RemoteIP,
-				ChatIO:        providersWithMeta.IOLog,
-				Retry:         retry,
-				ProxyTime:     time.Since(start),
+				Name:                before.Model,
+				ProviderModel:       modelWithProvider.ProviderModel,
+				ProviderName:        provider.Name,
+				Status:              "success",
+				Style:               style,
+				UserAgent:           reqMeta.UserAgent,
+				RemoteIP:            reqMeta.RemoteIP,
+				APIKeyID:            reqMeta.APIKeyID,
+				ModelWithProviderID: *id,
+				ServedModel:         providersWithMeta.ServedModel,
+				SessionID:           session,
+				RequestID:           RequestIDFromContext(ctx),
+				LogLevel:            providersWithMeta.LogLevel,
+				ChatIO:              LogLevelAtLeast(providersWithMeta.LogLevel, models.LogLevelPrompts),
+				Retry:               retry,
+				RetryDelay:          retryDelay,
+				HeaderFailovers:     headerFailovers,
+				ProxyTime:           time.Since(start),
+			}
+			// none 级别不保留可识别调用方的信息
+			if providersWithMeta.LogLevel == models.LogLevelNone {
+				log.UserAgent, log.RemoteIP, log.SessionID = "", "", ""
 			}
 			// 根据请求原始请求头 是否透传请求头 自定义请求头 构建新的请求头
 			withHeader := false
@@ -89,61 +253,154 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 				withHeader = *modelWithProvider.WithHeader
 			}
 			header := buildHeaders(reqMeta.Header, withHeader, modelWithProvider.CustomerHeaders, before.Stream)
+			setRequestIDHeader(ctx, header)
 
 			reqStart := time.Now()
-			trace := &httptrace.ClientTrace{
-				GotFirstResponseByte: func() {
-					slog.Debug("first response byte received", "response_time", time.Since(reqStart))
-				},
-			}
 
 			// 判断是否需要格式转换
-			// 当客户端格式与供应商类型一致时，直接透传原始请求体
+			// 当客户端格式与供应商请求格式一致时，直接透传原始请求体
+			providerStyle := providers.WireStyle(provider.Type)
+			passthrough := style == providerStyle || style == consts.StyleOpenAIEmbeddings
 			var requestBody []byte
-			if style == provider.Type {
+			if passthrough {
 				// 直接透传，不进行格式转换
 				slog.Debug("passthrough mode", "client_type", style, "provider_type", provider.Type)
 				requestBody = before.raw
 			} else {
 				// 需要格式转换
 				slog.Debug("transform mode", "client_type", style, "provider_type", provider.Type)
-				tm := NewTransformerManager(style, provider.Type)
+				tm := NewTransformerManager(style, providerStyle)
+				_, transformSpan := StartSpan(ctx, "transform.request", SpanKindInternal, "llmio.from", style, "llmio.to", providerStyle)
 				convertedBody, err := tm.ProcessRequest(ctx, before.raw)
+				transformSpan.RecordError(err)
+				transformSpan.End()
 				if err != nil {
 					retryLog <- log.WithError(fmt.Errorf("transform request error: %v", err))
-					delete(weightItems, *id)
+					candidates.remove(*id)
 					continue
 				}
 				requestBody = convertedBody
 			}
 
-			req, err := chatModel.BuildReq(httptrace.WithClientTrace(ctx, trace), header, modelWithProvider.ProviderModel, requestBody)
+			// 关联不支持提示缓存时移除 cache_control，避免上游因未知字段拒绝请求
+			if before.promptCache && !supportsPromptCache(modelWithProvider) && promptCacheRouting != PromptCacheRoutingOff {
+				stripped, count, err := StripCacheControl(requestBody)
+				if err != nil {
+					slog.Error("strip cache_control error", "error", err)
+				} else if count > 0 {
+					slog.Debug("stripped cache_control", "model_provider_id", modelWithProvider.ID, "count", count)
+					requestBody = stripped
+				}
+			}
+
+			// 为支持提示缓存的 Anthropic 关联自动添加缓存断点，提高长上下文的缓存命中率
+			if promptCacheAutoInject && providerStyle == consts.StyleAnthropic && supportsPromptCache(modelWithProvider) {
+				injected, count, err := InjectCacheBreakpoints(requestBody)
+				if err != nil {
+					slog.Error("inject cache breakpoints error", "error", err)
+				} else if count > 0 {
+					requestBody = injected
+				}
+			}
+
+			// 按供应商的图片上限缩放请求中的图片，避免 413/400 导致的故障转移
+			if limits := ProviderImageLimits(provider); before.image && limits.Enabled() {
+				scaled, count, err := DownscaleImages(providerStyle, requestBody, limits)
+				if err != nil {
+					slog.Error("downscale images error", "error", err)
+				} else if count > 0 {
+					slog.Info("downscaled images", "provider", provider.Name, "count", count)
+					requestBody = scaled
+				}
+			}
+
+			// 按关联配置改写请求体与请求头，兼容字段命名不同或需要额外参数的上游
+			if len(modelWithProvider.RequestRewrites) > 0 {
+				rewritten, err := ApplyRequestRewrites(requestBody, header, modelWithProvider.RequestRewrites)
+				if err != nil {
+					retryLog <- log.WithError(err)
+					candidates.remove(*id)
+					continue
+				}
+				requestBody = rewritten
+			}
+
+			// 已注册插件的请求钩子，返回错误时中止请求
+			plugins := registeredPlugins()
+			if len(plugins) > 0 {
+				pluginReq := &PluginRequest{
+					Style:         style,
+					ProviderStyle: providerStyle,
+					Model:         before.Model,
+					Provider:      provider.Name,
+					ProviderModel: modelWithProvider.ProviderModel,
+					Stream:        before.Stream,
+					Header:        header,
+					Body:          requestBody,
+				}
+				if err := runRequestPlugins(ctx, plugins, pluginReq); err != nil {
+					retryLog <- log.WithError(err)
+					return nil, 0, err
+				}
+				header, requestBody = pluginReq.Header, pluginReq.Body
+			}
+
+			// 上游调用 span 覆盖建连到收到响应头，httptrace 各阶段记录为事件
+			upstreamCtx, upstreamSpan := StartSpan(ctx, "upstream.request", SpanKindClient,
+				"llmio.provider", provider.Name,
+				"llmio.provider_model", modelWithProvider.ProviderModel,
+				"llmio.model_provider_id", *id,
+				"llmio.retry", retry,
+			)
+			setTraceparentHeader(upstreamCtx, header)
+			req, err := buildProviderReq(httptrace.WithClientTrace(upstreamCtx, upstreamClientTrace(upstreamSpan, reqStart)), chatModel, style, header, modelWithProvider.ProviderModel, requestBody)
 			if err != nil {
+				upstreamSpan.RecordError(err)
+				upstreamSpan.End()
 				retryLog <- log.WithError(err)
 				// 构建请求失败 移除待选
-				delete(weightItems, *id)
+				candidates.remove(*id)
 				continue
 			}
 
-			// 提前创建日志记录,确保所有请求都被记录
-			logId, err := SaveChatLog(ctx, log)
-			if err != nil {
+			// 提前创建日志记录,确保所有请求都被记录；未采样的请求只在失败时写入
+			var logId uint
+			if sample := providersWithMeta.LogSample; sample.deferred() {
+				sample.pending = log
+			} else if logId, err = SaveChatLog(ctx, log); err != nil {
 				slog.Error("failed to create log before request", "error", err)
+				upstreamSpan.RecordError(err)
+				upstreamSpan.End()
 				return nil, 0, err
 			}
 
+			// 记录进行中的请求，供排空关联时等待；同时按估算 token 数预占供应商 TPM，请求失败时归还
+			inflightDone := trackInflight(*id)
+			tpmReserved := providerTPM.reserve(provider, before.contextTokens, time.Now())
+			release := func() {
+				inflightDone()
+				providerTPM.settle(tpmReserved, 0)
+			}
 			res, err := client.Do(req)
 			if err != nil {
+				upstreamSpan.RecordError(err)
+				upstreamSpan.End()
+				release()
+				// 客户端已断开，不再重试
+				if ctx.Err() != nil {
+					if updateErr := updateLogStatus(context.WithoutCancel(ctx), logId, providersWithMeta.LogSample, "cancelled", ErrClientCancelled.Error()); updateErr != nil {
+						slog.Error("failed to update log status", "error", updateErr)
+					}
+					return nil, 0, ctx.Err()
+				}
 				// 更新日志状态为错误
-				if _, updateErr := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
-					Status: "error",
-					Error:  err.Error(),
-				}); updateErr != nil {
+				if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", err.Error()); updateErr != nil {
 					slog.Error("failed to update log status", "error", updateErr)
 				}
+				lastUpstream = err.Error()
+				upstreamFailures++
 				// 请求失败 移除待选
-				delete(weightItems, *id)
-				delete(priorityItems, *id)
+				candidates.remove(*id)
 				continue
 			}
 
@@ -153,35 +410,78 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 					slog.Error("read body error", "error", err)
 				}
 				// 更新日志状态为错误
-				if _, updateErr := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
-					Status: "error",
-					Error:  fmt.Sprintf("status: %d, body: %s", res.StatusCode, string(byteBody)),
-				}); updateErr != nil {
+				lastUpstream = fmt.Sprintf("status: %d, body: %s", res.StatusCode, string(byteBody))
+				upstreamSpan.SetAttributes("http.response.status_code", res.StatusCode)
+				upstreamSpan.RecordError(fmt.Errorf("status: %d", res.StatusCode))
+				upstreamSpan.End()
+				if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", lastUpstream); updateErr != nil {
 					slog.Error("failed to update log status", "error", updateErr)
 				}
+				upstreamFailures++
+				if rejectedStatus(res.StatusCode) {
+					rejectedFailures++
+				}
+				// 密钥失效立即隔离，直到配置变更
+				if isAuthFailure(res.StatusCode) {
+					QuarantineAuthFailure(context.WithoutCancel(ctx), modelWithProvider, provider, before.Model, res.StatusCode, string(byteBody))
+				}
 
 				if res.StatusCode == http.StatusTooManyRequests {
 					// 达到RPM限制 降低权重
-					weightItems[*id] -= weightItems[*id] / 3
+					candidates.penalize(*id)
 				} else {
 					// 非RPM限制 移除待选
-					delete(weightItems, *id)
-					delete(priorityItems, *id)
+					candidates.remove(*id)
 				}
 				res.Body.Close()
+				release()
 				continue
 			}
 
+			upstreamSpan.SetAttributes("http.response.status_code", res.StatusCode)
+			upstreamSpan.End()
+
+			// 流式响应按首字超时与空闲超时中断停滞的上游
+			if before.Stream {
+				res.Body = providersWithMeta.Timeouts.guardStream(res.Body, reqStart)
+			}
+			// raw 级别在格式转换前捕获上游原始响应
+			if providersWithMeta.RawCapture != nil {
+				res.Body = providersWithMeta.RawCapture.Wrap(req, res.Body)
+			}
+			if providersWithMeta.ResponseHasher != nil {
+				res.Body = providersWithMeta.ResponseHasher.Wrap(res.Body)
+			}
+			if provider.Type == consts.StyleOllama && style != consts.StyleOpenAIEmbeddings {
+				if res, err = normalizeOllamaResponse(res); err != nil {
+					// 本次尝试的日志已写入，更新其状态而不是再记录一条
+					lastUpstream = fmt.Sprintf("normalize ollama response error: %v", err)
+					if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", lastUpstream); updateErr != nil {
+						slog.Error("failed to update log status", "error", updateErr)
+					}
+					release()
+					candidates.remove(*id)
+					continue
+				}
+			}
+
 			// 判断是否需要响应格式转换
 			// 当客户端格式与供应商类型一致时，直接透传响应
-			if style != provider.Type {
+			if !passthrough {
 				// 需要格式转换
-				tm := NewTransformerManager(style, provider.Type)
+				tm := NewTransformerManager(style, providerStyle)
+				_, transformSpan := StartSpan(ctx, "transform.response", SpanKindInternal, "llmio.from", providerStyle, "llmio.to", style)
 				convertedRes, err := tm.ProcessResponse(res)
+				transformSpan.RecordError(err)
+				transformSpan.End()
 				if err != nil {
-					retryLog <- log.WithError(fmt.Errorf("transform response error: %v", err))
+					lastUpstream = fmt.Sprintf("transform response error: %v", err)
+					if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", lastUpstream); updateErr != nil {
+						slog.Error("failed to update log status", "error", updateErr)
+					}
 					res.Body.Close()
-					delete(weightItems, *id)
+					release()
+					candidates.remove(*id)
 					continue
 				}
 				res = convertedRes
@@ -190,12 +490,73 @@ func BalanceChat(ctx context.Context, start time.Time, style string, before Befo
 				slog.Debug("passthrough response", "client_type", style, "provider_type", provider.Type)
 			}
 
+			// 上游返回 200 后在输出内容前出错、停滞或按设置视为失败的空流时，趁尚未向客户端写入数据切换到其他关联
+			if before.Stream {
+				body, err := awaitStreamContent(res.Body)
+				// 不切换的空流原样转发已读取的数据，由日志处理按设置记录
+				if errors.Is(err, ErrEmptyStream) && emptyStreamHandling != EmptyStreamFailover {
+					err = nil
+				}
+				if err != nil {
+					res.Body.Close()
+					release()
+					if ctx.Err() != nil {
+						if updateErr := updateLogStatus(context.WithoutCancel(ctx), logId, providersWithMeta.LogSample, "cancelled", ErrClientCancelled.Error()); updateErr != nil {
+							slog.Error("failed to update log status", "error", updateErr)
+						}
+						return nil, 0, ctx.Err()
+					}
+					slog.Warn("stream failed before content, failing over", "provider", provider.Name, "model", modelWithProvider.ProviderModel, "error", err)
+					lastUpstream = err.Error()
+					if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", lastUpstream); updateErr != nil {
+						slog.Error("failed to update log status", "error", updateErr)
+					}
+					upstreamFailures++
+					headerFailovers++
+					candidates.remove(*id)
+					continue
+				}
+				res.Body = body
+			}
+
+			if err := applyResponsePlugins(ctx, plugins, res, &PluginResponse{
+				Style:         style,
+				Model:         before.Model,
+				Provider:      provider.Name,
+				ProviderModel: modelWithProvider.ProviderModel,
+				Stream:        before.Stream,
+			}); err != nil {
+				res.Body.Close()
+				release()
+				if updateErr := updateLogStatus(ctx, logId, providersWithMeta.LogSample, "error", err.Error()); updateErr != nil {
+					slog.Error("failed to update log status", "error", updateErr)
+				}
+				return nil, 0, err
+			}
+
+			res.Body = &inflightBody{ReadCloser: res.Body, release: inflightDone}
+			providersWithMeta.TPMReservation.hold(tpmReserved)
+			if affinityKey != "" {
+				sessionAffinity.set(affinityKey, *id, providersWithMeta.StickySessionTTL)
+			}
 			applySuccessAdjustments(ctx, *id)
 			return res, logId, nil
 		}
 	}
 
-	return nil, 0, errors.New("maximum retry attempts reached")
+	return nil, 0, upstreamError(errors.New("maximum retry attempts reached"))
+}
+
+// buildProviderReq 按客户端格式构建上游请求，embeddings 请求要求供应商实现 providers.Embedder
+func buildProviderReq(ctx context.Context, chatModel providers.Provider, style string, header http.Header, model string, body []byte) (*http.Request, error) {
+	if style != consts.StyleOpenAIEmbeddings {
+		return chatModel.BuildReq(ctx, header, model, body)
+	}
+	embedder, ok := chatModel.(providers.Embedder)
+	if !ok {
+		return nil, errors.New("provider does not support embeddings")
+	}
+	return embedder.BuildEmbeddingsReq(ctx, header, model, body)
 }
 
 // selectByPriorityAndWeight 根据优先级和权重选择供应商
@@ -267,7 +628,7 @@ func applyWeightDecay(ctx context.Context, log models.ChatLog, modelWithProvider
 // getAutoWeightDecay 获取自动权重衰减开关
 func getAutoWeightDecay(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecay).
+		Where(models.ByKey(models.SettingKeyAutoWeightDecay)).
 		First(ctx)
 	if err != nil {
 		return false // 默认关闭
@@ -278,7 +639,7 @@ func getAutoWeightDecay(ctx context.Context) bool {
 // getAutoWeightDecayStep 获取自动权重衰减步长
 func getAutoWeightDecayStep(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoWeightDecayStep).
+		Where(models.ByKey(models.SettingKeyAutoWeightDecayStep)).
 		First(ctx)
 	if err != nil {
 		return 1 // 默认步长1
@@ -314,7 +675,7 @@ func applyPriorityDecay(ctx context.Context, log models.ChatLog, modelWithProvid
 // getAutoPriorityDecay 获取自动优先级衰减开关
 func getAutoPriorityDecay(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecay).
+		Where(models.ByKey(models.SettingKeyAutoPriorityDecay)).
 		First(ctx)
 	if err != nil {
 		return false // 默认关闭
@@ -325,7 +686,7 @@ func getAutoPriorityDecay(ctx context.Context) bool {
 // getAutoPriorityDecayStep 获取自动优先级衰减步长
 func getAutoPriorityDecayStep(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayStep).
+		Where(models.ByKey(models.SettingKeyAutoPriorityDecayStep)).
 		First(ctx)
 	if err != nil {
 		return 1 // 默认步长1
@@ -340,7 +701,7 @@ func getAutoPriorityDecayStep(ctx context.Context) int {
 // getAutoPriorityDecayThreshold 获取自动优先级衰减阈值
 func getAutoPriorityDecayThreshold(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyAutoPriorityDecayThreshold).
+		Where(models.ByKey(models.SettingKeyAutoPriorityDecayThreshold)).
 		First(ctx)
 	if err != nil {
 		return 90 // 默认阈值90
@@ -352,36 +713,100 @@ func getAutoPriorityDecayThreshold(ctx context.Context) int {
 	return threshold
 }
 
-func RecordLog(ctx context.Context, reqStart time.Time, reader io.ReadCloser, processer Processer, logId uint, before Before, ioLog bool) {
+// ErrClientCancelled 客户端在响应完成前断开连接，对应日志状态 cancelled
+var ErrClientCancelled = errors.New("client disconnected")
+
+func RecordLog(ctx context.Context, reqStart time.Time, reader io.ReadCloser, processer Processer, logId uint, before Before, logLevel string, raw *RawCapture, hasher *ResponseHasher, sample *LogSample, toolAuditWebhook string, rateLimit RateLimitTarget, tpm *TPMReservation) {
+	// span 覆盖读取响应与写入日志，response_processed 事件之后为日志、用量与费用的持久化
+	ctx, span := StartSpan(ctx, "log.record", SpanKindInternal, "llmio.log_id", logId, "llmio.model", before.Model)
+	defer span.End()
 	recordFunc := func() error {
 		defer reader.Close()
 
 		log, output, err := processer(ctx, reader, before.Stream, reqStart)
+		span.AddEvent("response_processed")
+		if errors.Is(err, ErrClientCancelled) {
+			if updateErr := updateLogStatus(ctx, logId, sample, "cancelled", err.Error()); updateErr != nil {
+				slog.Error("failed to update log status on cancel", "log_id", logId, "error", updateErr)
+			}
+			return nil
+		}
 		if err != nil {
 			slog.Error("processer error", "log_id", logId, "error", err)
 			// 更新日志状态为错误
-			if _, updateErr := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
-				Status: "error",
-				Error:  fmt.Sprintf("processer error: %v", err),
-			}); updateErr != nil {
+			if updateErr := updateLogStatus(ctx, logId, sample, "error", fmt.Sprintf("processer error: %v", err)); updateErr != nil {
 				slog.Error("failed to update log status on processer error", "log_id", logId, "error", updateErr)
 			}
 			return err
 		}
+		// 流中没有任何内容时记为错误，计入失败统计
+		if before.Stream && !streamHasContent(output.OfStringArray) && getEmptyStreamHandling(ctx) != EmptyStreamOff {
+			tpm.settle(0)
+			if updateErr := updateLogStatus(ctx, logId, sample, "error", ErrEmptyStream.Error()); updateErr != nil {
+				slog.Error("failed to update log status on empty stream", "log_id", logId, "error", updateErr)
+			}
+			return nil
+		}
 
+		// 上游原始响应已读取完毕，记录摘要与大小
+		if hasher != nil {
+			log.ResponseHash, log.ResponseSize = hasher.Sum()
+		}
+		span.SetAttributes("llmio.total_tokens", log.TotalTokens, "llmio.first_chunk_time", log.FirstChunkTime)
+		GetRateLimiter().AddTokens(rateLimit, log.TotalTokens)
+		tpm.settle(log.TotalTokens)
+		// 未采样的成功请求不写日志，只计入用量统计与配额
+		if sample.deferred() {
+			chatLog := sample.merged(*log)
+			if err := ApplyLogCost(ctx, &chatLog); err != nil {
+				slog.Error("failed to calculate cost", "model", before.Model, "error", err)
+			}
+			if err := RecordUsage(ctx, chatLog); err != nil {
+				slog.Error("failed to record usage", "model", before.Model, "error", err)
+			}
+			adaptiveBalance.observe(chatLog)
+			AuditToolCalls(toolAuditWebhook, logId, before.Model, *output)
+			return nil
+		}
+		// 采样记录按采样率加权，保证聚合统计准确
+		if sample != nil {
+			log.SampleWeight = sample.Weight
+		}
 		// 更新日志记录
 		if _, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, *log); err != nil {
 			slog.Error("failed to update log", "log_id", logId, "error", err)
 			return err
 		}
 
-		// 只有在启用 IO 日志时才记录输入输出
-		if ioLog {
-			if err := gorm.G[models.ChatIO](models.DB).Create(ctx, &models.ChatIO{
-				Input:       string(before.raw),
-				LogId:       logId,
-				OutputUnion: *output,
-			}); err != nil {
+		// 计算费用并计入用量统计与 API Key 配额，需要完整日志中的关联、模型、供应商与 key 信息
+		if chatLog, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).First(ctx); err != nil {
+			slog.Error("failed to load log for usage", "log_id", logId, "error", err)
+		} else {
+			if err := ApplyLogCost(ctx, &chatLog); err != nil {
+				slog.Error("failed to calculate cost", "log_id", logId, "error", err)
+			}
+			if err := RecordUsage(ctx, chatLog); err != nil {
+				slog.Error("failed to record usage", "log_id", logId, "error", err)
+			}
+			adaptiveBalance.observe(chatLog)
+		}
+		AuditToolCalls(toolAuditWebhook, logId, before.Model, *output)
+
+		// 按日志级别记录输入输出：prompts 只记录输入，full 记录输入输出，raw 额外记录上游原始请求与响应
+		if LogLevelAtLeast(logLevel, models.LogLevelPrompts) {
+			chatIO := models.ChatIO{
+				Input:   string(before.raw),
+				LogId:   logId,
+				Summary: before.summary,
+			}
+			if LogLevelAtLeast(logLevel, models.LogLevelFull) {
+				chatIO.OutputUnion = *output
+			}
+			if raw != nil {
+				chatIO.RawRequest, chatIO.RawResponse = raw.Values()
+			}
+			RedactChatIO(ctx, &chatIO)
+			if err := gorm.G[models.ChatIO](models.DB).Create(ctx, &chatIO); err != nil {
 				slog.Error("failed to create chat io", "log_id", logId, "error", err)
 				return err
 			}
@@ -389,11 +814,15 @@ func RecordLog(ctx context.Context, reqStart time.Time, reader io.ReadCloser, pr
 		return nil
 	}
 	if err := recordFunc(); err != nil {
+		span.RecordError(err)
 		slog.Error("record log error", "log_id", logId, "error", err)
 	}
 }
 
 func SaveChatLog(ctx context.Context, log models.ChatLog) (uint, error) {
+	if log.ErrorCategory == "" {
+		log.ErrorCategory = ClassifyError(log.Status, log.Error)
+	}
 	if err := gorm.G[models.ChatLog](models.DB).Create(ctx, &log); err != nil {
 		return 0, err
 	}
@@ -461,7 +890,7 @@ func cleanupLogsIfNeeded() {
 // getLogRetentionCount 获取日志保留条数设置
 func getLogRetentionCount(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyLogRetentionCount).
+		Where(models.ByKey(models.SettingKeyLogRetentionCount)).
 		First(ctx)
 	if err != nil {
 		return 0 // 默认不限制
@@ -500,18 +929,39 @@ type ProvidersWithMeta struct {
 	ProviderMap          map[uint]models.Provider
 	MaxRetry             int
 	TimeOut              int
-	IOLog                bool
+	LogLevel             string
+	RawCapture           *RawCapture // raw 级别时由调用方创建，捕获最终命中的上游请求与响应
+	ResponseHasher       *ResponseHasher
+	LogSampleRate        int
+	LogSample            *LogSample // 由调用方按 LogSampleRate 创建，为空时全部记录
+	MaxOutputTokens      int
+	MaxOutputBytes       int
+	ToolAuditWebhook     string
+	RPM                  int
+	TPM                  int
+	ResponseRules        *models.ResponseRules
+	ServedModel          string   // 供应商所属的模型
+	Fallbacks            []string // 主模型的备用模型
+	StickySession        bool
+	StickySessionTTL     time.Duration
+	RetryPolicy          RetryPolicy
+	TPMReservation       *TPMReservation // 由调用方创建，记录最终命中供应商的 TPM 预占
+	ShadowTarget         uint            // 影子流量的目标关联
+	ShadowPercent        int             // 镜像到影子关联的请求百分比
+	Heartbeat            time.Duration   // 流式请求等待上游首个字节期间的心跳间隔，0 表示不发送
+	Timeouts             Timeouts
 }
 
 func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Before) (*ProvidersWithMeta, error) {
-	model, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx)
+	meta, err := loadProvidersWithMeta(ctx, before)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			if _, err := SaveChatLog(ctx, models.ChatLog{
-				Name:   before.Model,
-				Status: "error",
-				Style:  style,
-				Error:  err.Error(),
+				Name:      before.Model,
+				Status:    "error",
+				Style:     style,
+				Error:     err.Error(),
+				RequestID: RequestIDFromContext(ctx),
 			}); err != nil {
 				return nil, err
 			}
@@ -519,6 +969,15 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		}
 		return nil, err
 	}
+	return meta, nil
+}
+
+// loadProvidersWithMeta 按当前配置加载模型可用的供应商，不写日志，模型不存在时返回 gorm.ErrRecordNotFound
+func loadProvidersWithMeta(ctx context.Context, before Before) (*ProvidersWithMeta, error) {
+	model, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	modelWithProviderChain := gorm.G[models.ModelWithProvider](models.DB).Where("model_id = ?", model.ID).Where("status = ?", true)
 
@@ -544,7 +1003,8 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		return nil, err
 	}
 
-	if len(modelWithProviders) == 0 {
+	// 配置了备用模型时，没有可用供应商也交由 BalanceChat 切换
+	if len(modelWithProviders) == 0 && len(model.Fallbacks) == 0 {
 		return nil, errors.New("not provider for model " + before.Model)
 	}
 
@@ -561,16 +1021,65 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 
 	providerMap := lo.KeyBy(providers, func(p models.Provider) uint { return p.ID })
 
+	// 指定供应商时只保留该供应商的关联，能力匹配照常生效，不再切换到其他供应商或备用模型
+	pinned := before.pinnedProvider != ""
+	fallbacks := model.Fallbacks
+	if pinned {
+		if modelWithProviders, err = pinnedAssociations(modelWithProviders, providerMap, before); err != nil {
+			return nil, err
+		}
+		fallbacks = nil
+	}
+
 	weightItems := make(map[uint]int)
 	priorityItems := make(map[uint]int)
+	contextSkipped := 0
 	for _, mp := range modelWithProviders {
-		if _, ok := providerMap[mp.ProviderID]; !ok {
+		provider, ok := providerMap[mp.ProviderID]
+		if !ok {
+			continue
+		}
+		// 上游返回过 401/403 且配置未变更的关联不再分配请求，指定供应商时同样跳过
+		if authQuarantined(provider, mp) {
+			slog.Debug("skip provider with invalid key", "model_provider_id", mp.ID)
+			continue
+		}
+		// 排空中的关联不再分配新请求，指定供应商也不能绕过
+		if IsDraining(mp.ID) {
+			continue
+		}
+		// 请求超出上下文窗口的关联交给上游只会返回 400，直接跳过
+		if limit := mp.MaxContextLength(); limit > 0 && before.contextTokens > limit {
+			slog.Debug("skip provider exceeding context length", "model_provider_id", mp.ID, "context_length", limit, "tokens", before.contextTokens)
+			contextSkipped++
 			continue
 		}
 		weightItems[mp.ID] = mp.Weight
 		priorityItems[mp.ID] = mp.Priority
 	}
 
+	// 带有 cache_control 的请求优先路由到支持提示缓存的关联
+	if before.promptCache && getPromptCacheRouting(ctx) == PromptCacheRoutingPrefer {
+		preferPromptCache(weightItems, priorityItems, modelWithProviderMap)
+	}
+
+	// 按近期首字时延与 TPS 缩放权重，较慢的关联分到更少的请求
+	if err := applyAdaptiveBalancing(ctx, weightItems); err != nil {
+		slog.Error("apply adaptive balancing error", "model", before.Model, "error", err)
+	}
+
+	// 按模型的选择策略以定价或近期时延改写优先级，统计失败时沿用配置的优先级
+	if err := applyRoutingStrategy(ctx, model.RoutingStrategy, priorityItems); err != nil {
+		slog.Error("apply routing strategy error", "model", before.Model, "strategy", model.RoutingStrategy, "error", err)
+	}
+
+	if pinned && len(weightItems) == 0 && contextSkipped == 0 {
+		return nil, errors.New("provider " + before.pinnedProvider + " is draining or quarantined for model " + before.Model)
+	}
+	if len(weightItems) == 0 && contextSkipped > 0 && len(fallbacks) == 0 {
+		return nil, fmt.Errorf("request of about %d tokens exceeds the context length of all providers for model %s", before.contextTokens, before.Model)
+	}
+
 	// 按优先级排序供应商（用于日志输出）
 	type providerPriority struct {
 		ID       uint
@@ -585,10 +1094,6 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 	})
 	slog.Debug("providers sorted by priority", "order", sortedProviders)
 
-	if model.IOLog == nil {
-		model.IOLog = new(bool)
-	}
-
 	return &ProvidersWithMeta{
 		ModelWithProviderMap: modelWithProviderMap,
 		WeightItems:          weightItems,
@@ -596,17 +1101,44 @@ func ProvidersWithMetaBymodelsName(ctx context.Context, style string, before Bef
 		ProviderMap:          providerMap,
 		MaxRetry:             model.MaxRetry,
 		TimeOut:              model.TimeOut,
-		IOLog:                *model.IOLog,
+		LogLevel:             ModelLogLevel(model),
+		LogSampleRate:        model.LogSampleRate,
+		MaxOutputTokens:      model.MaxOutputTokens,
+		MaxOutputBytes:       model.MaxOutputBytes,
+		ToolAuditWebhook:     model.ToolAuditWebhook,
+		RPM:                  model.RPM,
+		TPM:                  model.TPM,
+		ResponseRules:        model.ResponseRules,
+		ServedModel:          model.Name,
+		Fallbacks:            fallbacks,
+		StickySession:        model.StickySession != nil && *model.StickySession,
+		StickySessionTTL:     stickySessionTTL(model.StickySessionTTL),
+		RetryPolicy:          ModelRetryPolicy(model),
+		ShadowTarget:         model.ShadowModelProviderID,
+		ShadowPercent:        model.ShadowPercent,
+		Heartbeat:            time.Duration(model.StreamHeartbeatSeconds) * time.Second,
+		Timeouts:             ModelTimeouts(model),
 	}, nil
 }
 
 // getStrictCapabilityMatch 获取严格能力匹配设置
 func getStrictCapabilityMatch(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyStrictCapabilityMatch).
+		Where(models.ByKey(models.SettingKeyStrictCapabilityMatch)).
 		First(ctx)
 	if err != nil {
 		return false // 默认关闭
 	}
 	return setting.Value == "true"
 }
+
+// GetAPIErrorLocale 获取面向客户端错误信息的语言设置
+func GetAPIErrorLocale(ctx context.Context) string {
+	setting, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyAPIErrorLocale)).
+		First(ctx)
+	if err != nil {
+		return common.LocaleAuto
+	}
+	return setting.Value
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// completionsPassthroughParams 旧版 completions 与 chat completions 语义一致、可直接透传的参数
+var completionsPassthroughParams = []string{
+	"model", "max_tokens", "temperature", "top_p", "n", "stream", "stream_options", "stop",
+	"presence_penalty", "frequency_penalty", "logit_bias", "seed", "user",
+}
+
+// CompletionsToChat 将旧版 /v1/completions 请求（prompt 字符串）转换为 chat completions 请求
+func CompletionsToChat(data []byte) ([]byte, error) {
+	prompt := gjson.GetBytes(data, "prompt")
+	var text string
+	switch {
+	case prompt.Type == gjson.String:
+		text = prompt.String()
+	case prompt.IsArray():
+		items := prompt.Array()
+		if len(items) != 1 || items[0].Type != gjson.String {
+			return nil, errors.New("only a single string prompt is supported")
+		}
+		text = items[0].String()
+	default:
+		return nil, errors.New("prompt is empty")
+	}
+
+	chat := []byte(`{}`)
+	var err error
+	for _, key := range completionsPassthroughParams {
+		value := gjson.GetBytes(data, key)
+		if !value.Exists() {
+			continue
+		}
+		if chat, err = sjson.SetRawBytes(chat, key, []byte(value.Raw)); err != nil {
+			return nil, err
+		}
+	}
+	return sjson.SetBytes(chat, "messages", []map[string]string{{"role": "user", "content": text}})
+}
+
+// ChatToCompletions 将非流式 chat completions 响应转换为 text_completion 响应
+func ChatToCompletions(body []byte) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("invalid chat completion response")
+	}
+	resp := map[string]any{
+		"id":      gjson.GetBytes(body, "id").String(),
+		"object":  "text_completion",
+		"created": gjson.GetBytes(body, "created").Int(),
+		"model":   gjson.GetBytes(body, "model").String(),
+		"choices": completionChoices(gjson.GetBytes(body, "choices"), "message"),
+	}
+	if usage := gjson.GetBytes(body, "usage"); usage.Exists() {
+		resp["usage"] = json.RawMessage(usage.Raw)
+	}
+	return json.Marshal(resp)
+}
+
+// ChatStreamToCompletions 将 chat completions SSE 流实时转换为 text_completion SSE 流
+func ChatStreamToCompletions(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				fmt.Fprint(pw, "data: [DONE]\n\n")
+				continue
+			}
+			if !gjson.Valid(data) {
+				continue
+			}
+			chunk := map[string]any{
+				"id":      gjson.Get(data, "id").String(),
+				"object":  "text_completion",
+				"created": gjson.Get(data, "created").Int(),
+				"model":   gjson.Get(data, "model").String(),
+				"choices": completionChoices(gjson.Get(data, "choices"), "delta"),
+			}
+			if usage := gjson.Get(data, "usage"); usage.Exists() && usage.Type != gjson.Null {
+				chunk["usage"] = json.RawMessage(usage.Raw)
+			}
+			out, err := json.Marshal(chunk)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			fmt.Fprintf(pw, "data: %s\n\n", out)
+		}
+		pw.CloseWithError(scanner.Err())
+	}()
+	return pr
+}
+
+// completionChoices 将 chat choices 中的 message/delta 内容映射为 text 字段
+func completionChoices(choices gjson.Result, field string) []map[string]any {
+	result := make([]map[string]any, 0)
+	choices.ForEach(func(_, choice gjson.Result) bool {
+		var finishReason any
+		if reason := choice.Get("finish_reason"); reason.Type == gjson.String {
+			finishReason = reason.String()
+		}
+		result = append(result, map[string]any{
+			"index":         choice.Get("index").Int(),
+			"text":          choice.Get(field + ".content").String(),
+			"logprobs":      nil,
+			"finish_reason": finishReason,
+		})
+		return true
+	})
+	return result
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCompletionsToChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string]string // gjson 路径 -> 期望的原始 JSON，空字符串表示字段不存在
+		wantErr bool
+	}{
+		{
+			name: "string prompt",
+			body: `{"model":"gpt-3.5-turbo-instruct","prompt":"Say hi","max_tokens":5,"stop":["\n"],"stream":true,"echo":true,"suffix":"!"}`,
+			want: map[string]string{
+				"model":      `"gpt-3.5-turbo-instruct"`,
+				"messages":   `[{"content":"Say hi","role":"user"}]`,
+				"max_tokens": `5`,
+				"stop":       `["\n"]`,
+				"stream":     `true`,
+				"echo":       ``,
+				"suffix":     ``,
+			},
+		},
+		{
+			name: "single item prompt array",
+			body: `{"model":"m","prompt":["Say hi"]}`,
+			want: map[string]string{"messages.0.content": `"Say hi"`},
+		},
+		{name: "batched prompts", body: `{"model":"m","prompt":["a","b"]}`, wantErr: true},
+		{name: "token prompt", body: `{"model":"m","prompt":[[1,2,3]]}`, wantErr: true},
+		{name: "missing prompt", body: `{"model":"m"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CompletionsToChat([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected error, got %s", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompletionsToChat failed: %v", err)
+			}
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(result, path).Raw; got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChatToCompletions(t *testing.T) {
+	result, err := ChatToCompletions([]byte(`{"id":"chatcmpl-1","created":1700000000,"model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":2,"completion_tokens":1,"total_tokens":3}}`))
+	if err != nil {
+		t.Fatalf("ChatToCompletions failed: %v", err)
+	}
+	resp := gjson.ParseBytes(result)
+	if resp.Get("object").String() != "text_completion" || resp.Get("id").String() != "chatcmpl-1" || resp.Get("usage.total_tokens").Int() != 3 {
+		t.Errorf("response = %s", result)
+	}
+	if choice := resp.Get("choices.0"); choice.Raw != `{"finish_reason":"stop","index":0,"logprobs":null,"text":"Hi"}` {
+		t.Errorf("choice = %s", choice.Raw)
+	}
+	if _, err := ChatToCompletions([]byte(`not json`)); err == nil {
+		t.Error("Expected invalid body to fail")
+	}
+
+	stream := "data: {\"id\":\"c1\",\"created\":1,\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"},\"finish_reason\":null}]}\n\n" +
+		"data: {\"id\":\"c1\",\"created\":1,\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"},\"finish_reason\":null}]}\n\n" +
+		": keep-alive\n\n" +
+		"data: {\"id\":\"c1\",\"created\":1,\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}],\"usage\":{\"total_tokens\":3}}\n\n" +
+		"data: [DONE]\n\n"
+	body, err := io.ReadAll(ChatStreamToCompletions(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	var chunks []gjson.Result
+	for _, line := range strings.Split(string(body), "\n") {
+		if payload, ok := strings.CutPrefix(line, "data: "); ok && payload != "[DONE]" {
+			chunks = append(chunks, gjson.Parse(payload))
+		}
+	}
+	if len(chunks) != 3 || !strings.HasSuffix(string(body), "data: [DONE]\n\n") {
+		t.Fatalf("stream = %s", body)
+	}
+	if chunks[1].Get("object").String() != "text_completion" || chunks[1].Get("choices.0.text").String() != "Hi" || chunks[1].Get("choices.0.finish_reason").Type != gjson.Null {
+		t.Errorf("text chunk = %s", chunks[1].Raw)
+	}
+	if chunks[2].Get("choices.0.finish_reason").String() != "stop" || chunks[2].Get("usage.total_tokens").Int() != 3 {
+		t.Errorf("final chunk = %s", chunks[2].Raw)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// ConfigBundleVersion 配置导出包的格式版本
+const ConfigBundleVersion = 1
+
+// ConfigBundle 配置导出包：期望状态文档加全局设置，导入时按期望状态同步，可用于实例迁移与备份恢复；
+// 供应商模板为内置数据，不随导出包迁移
+type ConfigBundle struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	DesiredState
+	Settings map[string]string `json:"settings"`
+}
+
+// ExportConfig 导出当前的供应商、模型、关联与设置，maskSecrets 为真时供应商配置中的密钥、工具调用审计 webhook 与密钥类设置替换为掩码
+func ExportConfig(ctx context.Context, maskSecrets bool) (*ConfigBundle, error) {
+	providerList, err := gorm.G[models.Provider](models.DB).Order("name").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modelList, err := gorm.G[models.Model](models.DB).Order("name").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := gorm.G[models.Setting](models.DB).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &ConfigBundle{
+		Version:    ConfigBundleVersion,
+		ExportedAt: time.Now(),
+		DesiredState: DesiredState{
+			Providers:    make([]DesiredProvider, 0, len(providerList)),
+			Models:       make([]DesiredModel, 0, len(modelList)),
+			Associations: make([]DesiredAssociation, 0, len(associations)),
+		},
+		Settings: make(map[string]string, len(settings)),
+	}
+	providerNames := make(map[uint]string, len(providerList))
+	for _, provider := range providerList {
+		providerNames[provider.ID] = provider.Name
+		desired := desiredProviderOf(provider)
+		if maskSecrets {
+			desired.Config = models.RedactConfigSecrets(desired.Config)
+		}
+		bundle.Providers = append(bundle.Providers, desired)
+	}
+	shadows, err := shadowAssociations(ctx, models.DB, modelList)
+	if err != nil {
+		return nil, err
+	}
+	modelNames := make(map[uint]string, len(modelList))
+	for _, model := range modelList {
+		modelNames[model.ID] = model.Name
+		desired := desiredModelOf(model, shadows[model.ShadowModelProviderID])
+		if maskSecrets {
+			desired.ToolAuditWebhook = models.RedactSecret(desired.ToolAuditWebhook)
+		}
+		bundle.Models = append(bundle.Models, desired)
+	}
+	// 引用已删除模型或供应商的遗留关联不导出
+	for _, mp := range associations {
+		model, provider := modelNames[mp.ModelID], providerNames[mp.ProviderID]
+		if model == "" || provider == "" {
+			continue
+		}
+		bundle.Associations = append(bundle.Associations, desiredAssociationOf(mp, model, provider))
+	}
+	for _, setting := range settings {
+		if maskSecrets {
+			setting.Value = models.RedactSetting(setting.Key, setting.Value)
+		}
+		bundle.Settings[setting.Key] = setting.Value
+	}
+	return bundle, nil
+}
+
+// ImportConfig 按导出包同步配置：未列出的供应商、模型与关联会被删除，设置只覆盖导出包中的键；
+// 所有变更在一个事务中执行，重复导入同一个包不会产生变更，dryRun 为真时只返回变更计划
+func ImportConfig(ctx context.Context, bundle ConfigBundle, dryRun bool) (*ApplyPlan, error) {
+	if bundle.Version != ConfigBundleVersion {
+		return nil, fmt.Errorf("%w: unsupported bundle version %d", ErrInvalidDesiredState, bundle.Version)
+	}
+	if err := bundle.DesiredState.validate(); err != nil {
+		return nil, err
+	}
+	current, settings, err := mergeSettings(ctx, bundle.Settings)
+	if err != nil {
+		return nil, err
+	}
+	// 新建关联时使用导入后的默认优先级
+	defaultPriority, _ := strconv.Atoi(settings[models.SettingKeyAutoPriorityDecayDefault])
+	plan := &ApplyPlan{Changes: []ApplyChange{}}
+	var configChanged []uint
+	err = models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed, err := applyDesiredState(ctx, tx, bundle.DesiredState, defaultPriority, plan, dryRun)
+		if err != nil {
+			return err
+		}
+		configChanged = changed
+		return applySettings(ctx, tx, current, settings, plan, dryRun)
+	})
+	if err != nil {
+		return nil, err
+	}
+	finishApply(ctx, plan, configChanged, dryRun)
+	if !dryRun {
+		// 请求调度器缓存了并发设置，导入后重新加载
+		GetRequestScheduler().ReloadSettings(ctx)
+	}
+	return plan, nil
+}
+
+// mergeSettings 将导出包中的设置合并到当前设置，并按各设置接口的规则规范化与校验合并结果；
+// 导出包中的未知键视为无效，避免版本不一致时静默丢弃，密钥类设置仍为脱敏导出的掩码时保留当前值
+func mergeSettings(ctx context.Context, desired map[string]string) (current, merged map[string]string, err error) {
+	existing, err := gorm.G[models.Setting](models.DB).Find(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current = lo.SliceToMap(existing, func(s models.Setting) (string, string) { return s.Key, s.Value })
+	keys := lo.Keys(desired)
+	slices.Sort(keys)
+	if unknown := lo.Filter(keys, func(key string, _ int) bool { _, ok := current[key]; return !ok }); len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("%w: unknown settings %s", ErrInvalidDesiredState, strings.Join(unknown, ", "))
+	}
+	merged = maps.Clone(current)
+	for _, key := range keys {
+		merged[key] = models.RestoreSetting(key, desired[key], current[key])
+	}
+	if err := NormalizeSettingValues(merged); err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid settings: %v", ErrInvalidDesiredState, err)
+	}
+	return current, merged, nil
+}
+
+// applySettings 更新合并后值发生变化的设置
+func applySettings(ctx context.Context, tx *gorm.DB, current, merged map[string]string, plan *ApplyPlan, dryRun bool) error {
+	keys := lo.Keys(merged)
+	slices.Sort(keys)
+	for _, key := range keys {
+		if current[key] == merged[key] {
+			continue
+		}
+		plan.Changes = append(plan.Changes, ApplyChange{Action: ApplyUpdate, Kind: "setting", Name: key, Fields: []string{"value"}})
+		if dryRun {
+			continue
+		}
+		if _, err := gorm.G[models.Setting](tx).Where(models.ByKey(key)).Update(ctx, "value", merged[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// ProviderConfigChanged 比较新旧供应商配置，忽略 JSON 格式差异
+func ProviderConfigChanged(before, after string) bool {
+	if after == "" || before == after {
+		return false
+	}
+	var a, b bytes.Buffer
+	if json.Compact(&a, []byte(before)) != nil || json.Compact(&b, []byte(after)) != nil {
+		return true
+	}
+	return !bytes.Equal(a.Bytes(), b.Bytes())
+}
+
+// ResetProviderState 供应商配置（密钥、地址）变更后，旧的失败记录不再反映新凭据：
+// 将其关联的权重与优先级恢复为默认值，解除密钥失效与请求隔离，并立即对各关联执行一次健康检测
+func ResetProviderState(ctx context.Context, providerID uint) (int, error) {
+	weight := getIntSetting(ctx, models.SettingKeyAutoWeightDecayDefault, 5)
+	priority := getIntSetting(ctx, models.SettingKeyAutoPriorityDecayDefault, 100)
+
+	associations, err := gorm.G[models.ModelWithProvider](models.DB).Where("provider_id = ?", providerID).Find(ctx)
+	if err != nil {
+		return 0, err
+	}
+	result := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).
+		Where("provider_id = ?", providerID).
+		Updates(map[string]any{
+			"weight":             weight,
+			"priority":           priority,
+			"auth_failed_at":     nil,
+			"auth_failed_config": "",
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	// 请求隔离不区分供应商，更换凭据后之前被拒绝的请求可能已可用
+	clearRequestQuarantines(ctx)
+
+	slog.Info("provider config changed, association state reset", "provider_id", providerID, "associations", len(associations), "weight", weight, "priority", priority)
+
+	go func() {
+		checker := GetHealthChecker()
+		for _, mp := range associations {
+			if _, err := checker.CheckSingle(context.Background(), mp.ID); err != nil {
+				slog.Error("health check after provider config change error", "model_provider_id", mp.ID, "error", err)
+			}
+		}
+	}()
+	return int(result.RowsAffected), nil
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigFileEnv 启动时读取的声明式配置文件路径环境变量，未设置时读取工作目录下的 llmio.yaml（不存在则跳过）
+	ConfigFileEnv = "LLMIO_CONFIG"
+
+	defaultConfigFile = "llmio.yaml"
+)
+
+// ConfigFile 声明式配置文件，字段与配置导出包相同；供应商 config 可写为对象，设置值可写为数字或布尔值
+type ConfigFile struct {
+	DesiredState
+	Settings map[string]string `json:"settings"`
+	// Prune 为真时删除文件中未列出的供应商、模型与关联，否则只创建与更新
+	Prune bool `json:"prune"`
+}
+
+// configEnvPattern 配置文件中 ${VAR} 形式的环境变量引用，用于避免将密钥写入文件
+var configEnvPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ReconcileConfigFile 读取声明式配置文件并将数据库同步到文件描述的状态，未配置且默认文件不存在时不做处理
+func ReconcileConfigFile(ctx context.Context) error {
+	path, explicit := os.LookupEnv(ConfigFileEnv)
+	if !explicit || path == "" {
+		path = defaultConfigFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		return fmt.Errorf("read config file: %w", err)
+	}
+	file, err := ParseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	plan, err := ApplyConfigFile(ctx, file, false)
+	if err != nil {
+		return fmt.Errorf("apply config file %s: %w", path, err)
+	}
+	for _, change := range plan.Changes {
+		slog.Info("config file change", "action", change.Action, "kind", change.Kind, "name", change.Name, "fields", change.Fields)
+	}
+	slog.Info("config file reconciled", "path", path, "changes", len(plan.Changes), "prune", file.Prune)
+	return nil
+}
+
+// ParseConfigFile 解析 YAML 配置文件，先展开 ${VAR} 环境变量引用
+func ParseConfigFile(data []byte) (*ConfigFile, error) {
+	data = configEnvPattern.ReplaceAllFunc(data, func(ref []byte) []byte {
+		return []byte(os.Getenv(string(configEnvPattern.FindSubmatch(ref)[1])))
+	})
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	// 供应商 config 在数据库中为 JSON 字符串，设置值均为字符串
+	if providers, ok := raw["providers"].([]any); ok {
+		for _, p := range providers {
+			if provider, ok := p.(map[string]any); ok {
+				if config, ok := provider["config"].(map[string]any); ok {
+					encoded, err := json.Marshal(config)
+					if err != nil {
+						return nil, err
+					}
+					provider["config"] = string(encoded)
+				}
+			}
+		}
+	}
+	if settings, ok := raw["settings"].(map[string]any); ok {
+		for key, value := range settings {
+			switch v := value.(type) {
+			case string:
+			case map[string]any, []any:
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				settings[key] = string(encoded)
+			default:
+				settings[key] = fmt.Sprint(v)
+			}
+		}
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var file ConfigFile
+	if err := json.Unmarshal(encoded, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// ApplyConfigFile 按配置文件同步配置；未开启 prune 时保留文件中未列出的供应商、模型与关联
+func ApplyConfigFile(ctx context.Context, file *ConfigFile, dryRun bool) (*ApplyPlan, error) {
+	state := file.DesiredState
+	if !file.Prune {
+		current, err := ExportConfig(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		state = mergeUnlisted(current.DesiredState, state)
+	}
+	return ImportConfig(ctx, ConfigBundle{Version: ConfigBundleVersion, DesiredState: state, Settings: file.Settings}, dryRun)
+}
+
+// mergeUnlisted 将当前配置中未被 desired 列出的项按原样追加，使同步时不删除它们
+func mergeUnlisted(current, desired DesiredState) DesiredState {
+	providers := make(map[string]bool, len(desired.Providers))
+	for _, p := range desired.Providers {
+		providers[p.Name] = true
+	}
+	for _, p := range current.Providers {
+		if !providers[p.Name] {
+			desired.Providers = append(desired.Providers, p)
+		}
+	}
+	modelNames := make(map[string]bool, len(desired.Models))
+	for _, m := range desired.Models {
+		modelNames[m.Name] = true
+	}
+	for _, m := range current.Models {
+		if !modelNames[m.Name] {
+			desired.Models = append(desired.Models, m)
+		}
+	}
+	associations := make(map[string]bool, len(desired.Associations))
+	for _, a := range desired.Associations {
+		associations[a.key()] = true
+	}
+	for _, a := range current.Associations {
+		if !associations[a.key()] {
+			desired.Associations = append(desired.Associations, a)
+		}
+	}
+	return desired
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const testConfigFile = `
+providers:
+  - name: openai
+    type: openai
+    config:
+      base_url: http://127.0.0.1
+      api_key: ${LLMIO_TEST_API_KEY}
+    tpm_limit: 1000
+models:
+  - name: chat
+    max_retry: 3
+    time_out: 30
+associations:
+  - model: chat
+    provider: openai
+    provider_model: gpt-4o
+    tool_call: true
+settings:
+  log_retention_count: 500
+  auto_weight_decay: true
+`
+
+func TestParseConfigFile(t *testing.T) {
+	t.Setenv("LLMIO_TEST_API_KEY", "sk-from-env")
+	file, err := ParseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatalf("ParseConfigFile failed: %v", err)
+	}
+	if len(file.Providers) != 1 || gjson.Get(file.Providers[0].Config, "api_key").String() != "sk-from-env" || file.Providers[0].TPMLimit != 1000 {
+		t.Errorf("Expected provider config object with expanded key, got %+v", file.Providers)
+	}
+	if file.Settings[models.SettingKeyLogRetentionCount] != "500" || file.Settings[models.SettingKeyAutoWeightDecay] != "true" {
+		t.Errorf("Expected scalar settings as strings, got %v", file.Settings)
+	}
+	if file.Prune {
+		t.Errorf("Expected prune to default to false")
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	manual := testutil.SeedProvider(t, "manual", consts.StyleOpenAI, "http://127.0.0.1")
+	other := testutil.SeedModel(t, "other")
+	testutil.SeedAssociation(t, other, manual, "gpt-4o-mini", 100, 1)
+
+	path := filepath.Join(t.TempDir(), "llmio.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ConfigFileEnv, path)
+	t.Setenv("LLMIO_TEST_API_KEY", testutil.TestAPIKey)
+	if err := ReconcileConfigFile(ctx); err != nil {
+		t.Fatalf("ReconcileConfigFile failed: %v", err)
+	}
+	// 未开启 prune 时保留文件外的配置
+	if count, _ := gorm.G[models.Provider](models.DB).Count(ctx, "id"); count != 2 {
+		t.Fatalf("Expected unlisted provider to be kept, providers = %d", count)
+	}
+	setting, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyLogRetentionCount).First(ctx)
+	if err != nil || setting.Value != "500" {
+		t.Fatalf("setting = %+v, %v", setting, err)
+	}
+
+	file, err := ParseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 再次同步不产生变更
+	if plan, err := ApplyConfigFile(ctx, file, true); err != nil || len(plan.Changes) != 0 {
+		t.Fatalf("Expected repeated apply to be a no-op, got %+v, %v", plan, err)
+	}
+
+	file.Prune = true
+	plan, err := ApplyConfigFile(ctx, file, false)
+	if err != nil {
+		t.Fatalf("ApplyConfigFile with prune failed: %v", err)
+	}
+	if len(plan.Changes) != 3 {
+		t.Errorf("Expected manual provider, model and association to be pruned, got %+v", plan.Changes)
+	}
+	if count, _ := gorm.G[models.Provider](models.DB).Count(ctx, "id"); count != 1 {
+		t.Errorf("Expected pruned providers, providers = %d", count)
+	}
+}
+
+func TestReconcileConfigFileMissing(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := ReconcileConfigFile(context.Background()); err != nil {
+		t.Errorf("Expected missing default config file to be skipped, got %v", err)
+	}
+	t.Setenv(ConfigFileEnv, "missing.yaml")
+	if err := ReconcileConfigFile(context.Background()); err == nil {
+		t.Error("Expected missing explicit config file to fail")
+	}
+}
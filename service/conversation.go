@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// ConversationSorts 会话列表支持的排序方式
+var ConversationSorts = []string{"last_seen", "turns", "tokens", "growth", "context"}
+
+// ConversationQuery 会话统计查询条件
+type ConversationQuery struct {
+	Days     int
+	MinTurns int64
+	Limit    int
+	Sort     string
+}
+
+// ConversationStat 单个会话的统计，会话按 X-Session-ID 或首条用户消息摘要识别
+type ConversationStat struct {
+	SessionID          string    `json:"session_id"`
+	Turns              int64     `json:"turns"`
+	FirstSeen          time.Time `json:"first_seen"`
+	LastSeen           time.Time `json:"last_seen"`
+	PromptTokens       int64     `json:"prompt_tokens"` // 累计输入 token
+	CompletionTokens   int64     `json:"completion_tokens"`
+	TotalTokens        int64     `json:"total_tokens"`
+	FirstContextTokens int64     `json:"first_context_tokens"` // 首轮输入 token
+	LastContextTokens  int64     `json:"last_context_tokens"`  // 最近一轮输入 token，近似当前上下文大小
+	ContextGrowth      float64   `json:"context_growth"`       // 平均每轮上下文增长的 token 数
+	ContextLength      int       `json:"context_length"`       // 最近一轮命中关联的上下文窗口，0 表示未知
+	ContextUsage       float64   `json:"context_usage"`        // 最近一轮上下文占窗口的比例
+	Models             []string  `json:"models"`
+	Providers          []string  `json:"providers"`
+
+	lastModelProviderID uint
+	loggedTurns         int
+}
+
+// ConversationTurn 会话中的一轮请求
+type ConversationTurn struct {
+	LogID            uint      `json:"log_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	Model            string    `json:"model"`
+	ProviderName     string    `json:"provider_name"`
+	ProviderModel    string    `json:"provider_model"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	CachedTokens     int64     `json:"cached_tokens"`
+}
+
+// GetConversationStats 汇总最近 days 天成功请求的会话统计
+func GetConversationStats(ctx context.Context, query ConversationQuery) ([]ConversationStat, error) {
+	since := time.Now().AddDate(0, 0, -query.Days)
+	logs, err := gorm.G[models.ChatLog](models.DB).
+		Where("created_at >= ? AND status = ? AND session_id <> ?", since, "success", "").
+		Order("created_at ASC").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*ConversationStat)
+	for _, log := range logs {
+		stat, ok := byID[log.SessionID]
+		if !ok {
+			stat = &ConversationStat{
+				SessionID:          log.SessionID,
+				FirstSeen:          log.CreatedAt,
+				FirstContextTokens: log.PromptTokens,
+				Models:             []string{},
+				Providers:          []string{},
+			}
+			byID[log.SessionID] = stat
+		}
+		// 采样记录按采样率加权
+		weight := int64(max(log.SampleWeight, 1))
+		stat.Turns += weight
+		stat.loggedTurns++
+		stat.PromptTokens += log.PromptTokens * weight
+		stat.CompletionTokens += log.CompletionTokens * weight
+		stat.TotalTokens += log.TotalTokens * weight
+		stat.LastSeen = log.CreatedAt
+		stat.LastContextTokens = log.PromptTokens
+		stat.lastModelProviderID = log.ModelWithProviderID
+		if !slices.Contains(stat.Models, log.Name) {
+			stat.Models = append(stat.Models, log.Name)
+		}
+		if !slices.Contains(stat.Providers, log.ProviderName) {
+			stat.Providers = append(stat.Providers, log.ProviderName)
+		}
+	}
+
+	stats := make([]ConversationStat, 0, len(byID))
+	associationIDs := make([]uint, 0, len(byID))
+	for _, stat := range byID {
+		if stat.Turns < query.MinTurns {
+			continue
+		}
+		if stat.loggedTurns > 1 {
+			stat.ContextGrowth = float64(stat.LastContextTokens-stat.FirstContextTokens) / float64(stat.loggedTurns-1)
+		}
+		stats = append(stats, *stat)
+		associationIDs = append(associationIDs, stat.lastModelProviderID)
+	}
+
+	// 按最近一轮命中的关联计算上下文占用，便于发现需要摘要或换用长上下文模型的会话
+	if len(associationIDs) > 0 {
+		associations, err := gorm.G[models.ModelWithProvider](models.DB).Where("id IN ?", associationIDs).Find(ctx)
+		if err != nil {
+			return nil, err
+		}
+		contextLengths := make(map[uint]int, len(associations))
+		for _, mp := range associations {
+			contextLengths[mp.ID] = mp.MaxContextLength()
+		}
+		for i := range stats {
+			if limit := contextLengths[stats[i].lastModelProviderID]; limit > 0 {
+				stats[i].ContextLength = limit
+				stats[i].ContextUsage = float64(stats[i].LastContextTokens) / float64(limit)
+			}
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		switch query.Sort {
+		case "turns":
+			return a.Turns > b.Turns
+		case "tokens":
+			return a.TotalTokens > b.TotalTokens
+		case "growth":
+			return a.ContextGrowth > b.ContextGrowth
+		case "context":
+			if a.ContextUsage != b.ContextUsage {
+				return a.ContextUsage > b.ContextUsage
+			}
+			return a.LastContextTokens > b.LastContextTokens
+		default:
+			return a.LastSeen.After(b.LastSeen)
+		}
+	})
+	if query.Limit > 0 && len(stats) > query.Limit {
+		stats = stats[:query.Limit]
+	}
+	return stats, nil
+}
+
+// GetConversationTurns 按时间顺序返回会话中成功的每轮请求
+func GetConversationTurns(ctx context.Context, sessionID string) ([]ConversationTurn, error) {
+	logs, err := gorm.G[models.ChatLog](models.DB).
+		Where("session_id = ? AND status = ?", sessionID, "success").
+		Order("created_at ASC").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	turns := make([]ConversationTurn, 0, len(logs))
+	for _, log := range logs {
+		turns = append(turns, ConversationTurn{
+			LogID:            log.ID,
+			CreatedAt:        log.CreatedAt,
+			Model:            log.Name,
+			ProviderName:     log.ProviderName,
+			ProviderModel:    log.ProviderModel,
+			PromptTokens:     log.PromptTokens,
+			CompletionTokens: log.CompletionTokens,
+			CachedTokens:     log.PromptTokensDetails.CachedTokens,
+		})
+	}
+	return turns, nil
+}
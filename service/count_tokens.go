@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	TokenSourceProvider = "provider"
+	TokenSourceEstimate = "estimate"
+)
+
+// countTokensRemovedFields count_tokens 接口不接受的生成参数
+var countTokensRemovedFields = []string{"max_tokens", "stream", "temperature", "top_p", "top_k", "stop_sequences", "metadata"}
+
+// CountTokens 统计 Anthropic 格式请求的输入 token 数：
+// 优先按路由顺序调用支持 count_tokens 的上游，全部不可用时回退到本地分词估算
+func CountTokens(ctx context.Context, raw []byte, header http.Header) (int64, string, error) {
+	before, err := BeforerAnthropic(raw)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if meta, err := loadProvidersWithMeta(ctx, *before); err == nil {
+		tokens, err := countTokensUpstream(ctx, meta, raw, header)
+		if err == nil {
+			return tokens, TokenSourceProvider, nil
+		}
+		slog.Debug("count tokens upstream unavailable, falling back to estimate", "model", before.Model, "error", err)
+	}
+
+	tokens, err := EstimatePromptTokens(before.Model, raw)
+	if err != nil {
+		return 0, "", err
+	}
+	return int64(tokens), TokenSourceEstimate, nil
+}
+
+// countTokensUpstream 按优先级与权重依次尝试支持 count_tokens 的供应商
+func countTokensUpstream(ctx context.Context, meta *ProvidersWithMeta, raw []byte, header http.Header) (int64, error) {
+	body := raw
+	for _, field := range countTokensRemovedFields {
+		body, _ = sjson.DeleteBytes(body, field)
+	}
+
+	candidates := candidatesOf(*meta)
+	var lastErr error = errors.New("no provider supports count_tokens")
+	for candidates.len() > 0 {
+		id, err := candidates.pick()
+		if err != nil {
+			break
+		}
+		candidates.remove(*id)
+
+		mp := meta.ModelWithProviderMap[*id]
+		provider := meta.ProviderMap[mp.ProviderID]
+		chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		counter, ok := chatModel.(providers.TokenCounter)
+		if !ok {
+			continue
+		}
+
+		withHeader := mp.WithHeader != nil && *mp.WithHeader
+		req, err := counter.BuildCountTokensReq(ctx, buildHeaders(header, withHeader, mp.CustomerHeaders, false), mp.ProviderModel, body)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		setRequestIDHeader(ctx, req.Header)
+		client := meta.Timeouts.client(chatModel.GetProxy())
+		tokens, err := doCountTokens(client, req)
+		if err != nil {
+			lastErr = fmt.Errorf("provider %s: %w", provider.Name, err)
+			continue
+		}
+		return tokens, nil
+	}
+	return 0, lastErr
+}
+
+func doCountTokens(client *http.Client, req *http.Request) (int64, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status: %d, body: %s", res.StatusCode, data)
+	}
+	tokens := gjson.GetBytes(data, "input_tokens")
+	if !tokens.Exists() {
+		return 0, errors.New("input_tokens missing in response")
+	}
+	return tokens.Int(), nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestCountTokens(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	status := http.StatusOK
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			testutil.JSON(http.StatusNotFound, `{"error":{"message":"not found"}}`)(w, r)
+			return
+		}
+		if status != http.StatusOK {
+			testutil.JSON(status, `{"error":{"message":"overloaded"}}`)(w, r)
+			return
+		}
+		testutil.JSON(http.StatusOK, `{"input_tokens":42}`)(w, r)
+	})
+	model := testutil.SeedModel(t, "claude-test")
+	// 不支持 count_tokens 的供应商排在前面时跳过
+	openai := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL+"/v1")
+	testutil.SeedAssociation(t, model, openai, "gpt-test", 200, 1)
+	anthropic := testutil.SeedProvider(t, "claude", consts.StyleAnthropic, upstream.URL+"/v1")
+	testutil.SeedAssociation(t, model, anthropic, "claude-upstream", 100, 1)
+
+	body := []byte(`{"model":"claude-test","max_tokens":100,"stream":true,"temperature":0.5,"messages":[{"role":"user","content":"Hello, how are you today?"}]}`)
+	tests := []struct {
+		name       string
+		body       []byte
+		status     int
+		wantSource string
+		wantTokens int64 // 0 表示只要求为正数
+	}{
+		{name: "provider", body: body, status: http.StatusOK, wantSource: TokenSourceProvider, wantTokens: 42},
+		{name: "provider error falls back", body: body, status: http.StatusServiceUnavailable, wantSource: TokenSourceEstimate},
+		{name: "unknown model estimates", body: []byte(`{"model":"claude-unknown","messages":[{"role":"user","content":"Hello"}]}`), status: http.StatusOK, wantSource: TokenSourceEstimate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			tokens, source, err := CountTokens(ctx, tt.body, http.Header{})
+			if err != nil {
+				t.Fatalf("CountTokens failed: %v", err)
+			}
+			if source != tt.wantSource || tokens <= 0 || (tt.wantTokens > 0 && tokens != tt.wantTokens) {
+				t.Errorf("CountTokens = %d %s, want %d %s", tokens, source, tt.wantTokens, tt.wantSource)
+			}
+		})
+	}
+
+	requests := upstream.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("upstream requests = %d, want 2", len(requests))
+	}
+	sent := gjson.ParseBytes(requests[0].Body)
+	if sent.Get("model").String() != "claude-upstream" || sent.Get("max_tokens").Exists() || sent.Get("stream").Exists() || sent.Get("temperature").Exists() {
+		t.Errorf("count_tokens body = %s", requests[0].Body)
+	}
+
+	if _, _, err := CountTokens(ctx, []byte(`{"messages":[]}`), http.Header{}); err == nil {
+		t.Error("Expected missing model to fail")
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultDrainTimeout = 30 * time.Second
+	MaxDrainTimeout     = time.Hour
+	drainPollInterval   = 500 * time.Millisecond
+)
+
+const (
+	DrainStateDraining  = "draining"  // 不再接受新请求，等待进行中的请求结束
+	DrainStateDisabled  = "disabled"  // 排空完成（或超时）并已禁用
+	DrainStateCancelled = "cancelled" // 排空被取消，关联保持启用
+	DrainStateFailed    = "failed"    // 禁用关联时出错
+)
+
+// inflight 各关联正在进行中的请求数（从发出上游请求到响应体关闭）
+var inflight sync.Map // map[uint]*atomic.Int64
+
+func inflightCounter(id uint) *atomic.Int64 {
+	counter, _ := inflight.LoadOrStore(id, new(atomic.Int64))
+	return counter.(*atomic.Int64)
+}
+
+// InflightCount 获取关联正在进行中的请求数
+func InflightCount(id uint) int64 {
+	return inflightCounter(id).Load()
+}
+
+// trackInflight 计数加一，返回只会生效一次的释放函数
+func trackInflight(id uint) func() {
+	counter := inflightCounter(id)
+	counter.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { counter.Add(-1) })
+	}
+}
+
+// inflightBody 响应体关闭时释放进行中计数，流式响应在客户端读完后才会关闭
+type inflightBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *inflightBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
+
+// DrainStatus 关联排空进度
+type DrainStatus struct {
+	ModelWithProviderID uint       `json:"model_provider_id"`
+	State               string     `json:"state"`
+	Inflight            int64      `json:"inflight"`
+	InflightAtStart     int64      `json:"inflight_at_start"`
+	Timeout             int        `json:"timeout_seconds"`
+	RemainingSeconds    int        `json:"remaining_seconds"`
+	TimedOut            bool       `json:"timed_out"` // 超时时仍有请求未结束
+	Error               string     `json:"error,omitempty"`
+	StartedAt           time.Time  `json:"started_at"`
+	Deadline            time.Time  `json:"deadline"`
+	FinishedAt          *time.Time `json:"finished_at,omitempty"`
+}
+
+type drainJob struct {
+	status DrainStatus
+	cancel context.CancelFunc
+}
+
+var (
+	drainMu   sync.Mutex
+	drainJobs = make(map[uint]*drainJob)
+)
+
+// IsDraining 关联是否处于排空中，排空中的关联不参与新请求的选择
+func IsDraining(id uint) bool {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	job, ok := drainJobs[id]
+	return ok && job.status.State == DrainStateDraining
+}
+
+// StartDrain 开始排空关联：立即停止分配新请求，最多等待 timeout 让进行中的请求结束，随后禁用关联
+func StartDrain(ctx context.Context, id uint, timeout time.Duration) (*DrainStatus, error) {
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	timeout = min(timeout, MaxDrainTimeout)
+
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx); err != nil {
+		return nil, err
+	}
+
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	if job, ok := drainJobs[id]; ok && job.status.State == DrainStateDraining {
+		return nil, errors.New("association is already draining")
+	}
+
+	now := time.Now()
+	// 排空需要在请求结束后继续执行
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := &drainJob{
+		status: DrainStatus{
+			ModelWithProviderID: id,
+			State:               DrainStateDraining,
+			InflightAtStart:     InflightCount(id),
+			Timeout:             int(timeout.Seconds()),
+			StartedAt:           now,
+			Deadline:            now.Add(timeout),
+		},
+		cancel: cancel,
+	}
+	drainJobs[id] = job
+	slog.Info("association drain started", "id", id, "inflight", job.status.InflightAtStart, "timeout", timeout)
+
+	go runDrain(jobCtx, id, job)
+	return job.snapshot(), nil
+}
+
+func runDrain(ctx context.Context, id uint, job *drainJob) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for InflightCount(id) > 0 && time.Now().Before(job.status.Deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	// 取消与完成之间可能存在竞争，以锁内的状态为准
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	if job.status.State != DrainStateDraining {
+		return
+	}
+	remaining := InflightCount(id)
+	job.status.TimedOut = remaining > 0
+	now := time.Now()
+	job.status.FinishedAt = &now
+
+	status := false
+	if _, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).Updates(context.Background(), models.ModelWithProvider{
+		Status: &status,
+	}); err != nil {
+		job.status.State = DrainStateFailed
+		job.status.Error = err.Error()
+		slog.Error("failed to disable drained association", "id", id, "error", err)
+		return
+	}
+	job.status.State = DrainStateDisabled
+	slog.Info("association drained and disabled", "id", id, "remaining_inflight", remaining)
+}
+
+// CancelDrain 取消排空，关联恢复参与选择；没有进行中的排空时返回 false
+func CancelDrain(id uint) bool {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	job, ok := drainJobs[id]
+	if !ok || job.status.State != DrainStateDraining {
+		return false
+	}
+	job.cancel()
+	now := time.Now()
+	job.status.State = DrainStateCancelled
+	job.status.FinishedAt = &now
+	slog.Info("association drain cancelled", "id", id)
+	return true
+}
+
+// GetDrainStatus 获取关联当前或最近一次排空的进度
+func GetDrainStatus(id uint) (*DrainStatus, bool) {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	job, ok := drainJobs[id]
+	if !ok {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+// ListDrains 获取所有关联的排空记录
+func ListDrains() []DrainStatus {
+	drainMu.Lock()
+	defer drainMu.Unlock()
+	statuses := make([]DrainStatus, 0, len(drainJobs))
+	for _, job := range drainJobs {
+		statuses = append(statuses, *job.snapshot())
+	}
+	slices.SortFunc(statuses, func(a, b DrainStatus) int { return b.StartedAt.Compare(a.StartedAt) })
+	return statuses
+}
+
+// snapshot 调用方需持有 drainMu
+func (j *drainJob) snapshot() *DrainStatus {
+	status := j.status
+	status.Inflight = InflightCount(status.ModelWithProviderID)
+	if status.State == DrainStateDraining {
+		status.RemainingSeconds = max(0, int(time.Until(status.Deadline).Seconds()))
+	}
+	return &status
+}
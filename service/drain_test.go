@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestDrain(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	model := testutil.SeedModel(t, "drain-model")
+	provider := testutil.SeedProvider(t, "drain", consts.StyleOpenAI, "http://127.0.0.1:1")
+	var ids []uint
+	for _, name := range []string{"completes", "times-out", "cancelled"} {
+		ids = append(ids, testutil.SeedAssociation(t, model, provider, name, 100, 1).ID)
+	}
+	t.Cleanup(func() {
+		drainMu.Lock()
+		defer drainMu.Unlock()
+		for _, id := range ids {
+			if job, ok := drainJobs[id]; ok {
+				job.cancel()
+				delete(drainJobs, id)
+			}
+		}
+	})
+	wait := func(id uint) *DrainStatus {
+		t.Helper()
+		for range 100 {
+			if status, _ := GetDrainStatus(id); status.State != DrainStateDraining {
+				return status
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("association %d still draining", id)
+		return nil
+	}
+	enabled := func(id uint) bool {
+		t.Helper()
+		association, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return association.Status != nil && *association.Status
+	}
+
+	t.Run("completes when inflight requests finish", func(t *testing.T) {
+		id := ids[0]
+		release := trackInflight(id)
+		status, err := StartDrain(ctx, id, 5*time.Second)
+		if err != nil {
+			t.Fatalf("StartDrain failed: %v", err)
+		}
+		if status.State != DrainStateDraining || status.InflightAtStart != 1 || status.Timeout != 5 || !IsDraining(id) {
+			t.Errorf("status = %+v", status)
+		}
+		if _, err := StartDrain(ctx, id, time.Second); err == nil {
+			t.Error("Expected a second drain to be rejected")
+		}
+		release()
+		release() // 重复释放不影响计数
+		if status := wait(id); status.State != DrainStateDisabled || status.TimedOut || status.Inflight != 0 || status.FinishedAt == nil {
+			t.Errorf("status = %+v", status)
+		}
+		if enabled(id) || IsDraining(id) {
+			t.Error("Expected drained association to be disabled")
+		}
+	})
+
+	t.Run("times out with requests still running", func(t *testing.T) {
+		id := ids[1]
+		release := trackInflight(id)
+		defer release()
+		if _, err := StartDrain(ctx, id, time.Second); err != nil {
+			t.Fatalf("StartDrain failed: %v", err)
+		}
+		if status := wait(id); status.State != DrainStateDisabled || !status.TimedOut || status.Inflight != 1 {
+			t.Errorf("status = %+v", status)
+		}
+		if enabled(id) {
+			t.Error("Expected timed out association to be disabled")
+		}
+	})
+
+	t.Run("cancel keeps association enabled", func(t *testing.T) {
+		id := ids[2]
+		release := trackInflight(id)
+		defer release()
+		if _, err := StartDrain(ctx, id, time.Minute); err != nil {
+			t.Fatalf("StartDrain failed: %v", err)
+		}
+		if !CancelDrain(id) || CancelDrain(id) {
+			t.Error("Expected only the first cancel to succeed")
+		}
+		if status, _ := GetDrainStatus(id); status.State != DrainStateCancelled || IsDraining(id) || !enabled(id) {
+			t.Errorf("status = %+v", status)
+		}
+	})
+
+	if _, err := StartDrain(ctx, 9999, time.Second); err == nil {
+		t.Error("Expected unknown association to fail")
+	}
+	if drains := ListDrains(); len(drains) != 3 {
+		t.Errorf("drains = %+v", drains)
+	}
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const smtpTimeout = 15 * time.Second
+
+// 未配置模板时使用的邮件标题与正文模板，数据为 NotificationEvent
+const (
+	defaultEmailSubjectTemplate = `[llmio] {{.Title}}`
+	defaultEmailBodyTemplate    = `{{.Message}}
+
+Event: {{.Event}}{{with .Status}} ({{.}}){{end}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{- with .Model}}
+Model: {{.}}{{end}}
+{{- with .Provider}}
+Provider: {{.}}{{end}}
+{{- with .ProviderModel}}
+Provider model: {{.}}{{end}}
+{{- with .APIKey}}
+API key: {{.}}{{end}}
+`
+)
+
+// EmailSettings SMTP 邮件告警设置，Host 为空或没有收件人时不发送
+type EmailSettings struct {
+	Host            string   `json:"host"`
+	Port            int      `json:"port"`
+	Username        string   `json:"username"`
+	Password        string   `json:"password,omitempty"` // 查询时不返回，更新时为空表示保留原密码
+	From            string   `json:"from"`
+	To              []string `json:"to"`
+	Events          []string `json:"events"`           // 为空表示全部事件
+	SubjectTemplate string   `json:"subject_template"` // text/template，为空使用内置模板
+	BodyTemplate    string   `json:"body_template"`    // text/template，为空使用内置模板
+	Cooldown        int      `json:"cooldown"`         // 同一告警两次发送的最小间隔（分钟）
+	MaxPerHour      int      `json:"max_per_hour"`     // 每小时最多发送的告警邮件数，0 表示不限制
+}
+
+func (s EmailSettings) enabled() bool {
+	return s.Host != "" && len(s.To) > 0
+}
+
+// GetEmailSettings 读取邮件告警设置
+func GetEmailSettings(ctx context.Context) (EmailSettings, error) {
+	records, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeySMTPHost, models.SettingKeySMTPPort, models.SettingKeySMTPUsername,
+			models.SettingKeySMTPPassword, models.SettingKeySMTPFrom, models.SettingKeySMTPTo, models.SettingKeySMTPEvents,
+			models.SettingKeySMTPSubjectTemplate, models.SettingKeySMTPBodyTemplate, models.SettingKeySMTPCooldown,
+			models.SettingKeySMTPMaxPerHour)).
+		Find(ctx)
+	if err != nil {
+		return EmailSettings{Port: 587, Cooldown: 30, MaxPerHour: 20}, err
+	}
+	return emailSettingsFrom(lo.SliceToMap(records, func(s models.Setting) (string, string) { return s.Key, s.Value })), nil
+}
+
+// emailSettingsFrom 从设置键值解析邮件告警设置，缺失或无法解析的数值使用默认值
+func emailSettingsFrom(values map[string]string) EmailSettings {
+	settings := EmailSettings{Port: 587, Cooldown: 30, MaxPerHour: 20}
+	for key, value := range values {
+		switch key {
+		case models.SettingKeySMTPHost:
+			settings.Host = value
+		case models.SettingKeySMTPPort:
+			if port, err := strconv.Atoi(value); err == nil {
+				settings.Port = port
+			}
+		case models.SettingKeySMTPUsername:
+			settings.Username = value
+		case models.SettingKeySMTPPassword:
+			settings.Password = value
+		case models.SettingKeySMTPFrom:
+			settings.From = value
+		case models.SettingKeySMTPTo:
+			settings.To = splitList(value)
+		case models.SettingKeySMTPEvents:
+			settings.Events = splitList(value)
+		case models.SettingKeySMTPSubjectTemplate:
+			settings.SubjectTemplate = value
+		case models.SettingKeySMTPBodyTemplate:
+			settings.BodyTemplate = value
+		case models.SettingKeySMTPCooldown:
+			if cooldown, err := strconv.Atoi(value); err == nil && cooldown >= 0 {
+				settings.Cooldown = cooldown
+			}
+		case models.SettingKeySMTPMaxPerHour:
+			if maxPerHour, err := strconv.Atoi(value); err == nil && maxPerHour >= 0 {
+				settings.MaxPerHour = maxPerHour
+			}
+		}
+	}
+	return settings
+}
+
+// SaveEmailSettings 写入邮件告警设置，Password 为空时保留原密码
+func SaveEmailSettings(ctx context.Context, s EmailSettings) error {
+	values := map[string]string{
+		models.SettingKeySMTPHost:            s.Host,
+		models.SettingKeySMTPPort:            strconv.Itoa(s.Port),
+		models.SettingKeySMTPUsername:        s.Username,
+		models.SettingKeySMTPFrom:            s.From,
+		models.SettingKeySMTPTo:              strings.Join(s.To, ","),
+		models.SettingKeySMTPEvents:          strings.Join(s.Events, ","),
+		models.SettingKeySMTPSubjectTemplate: s.SubjectTemplate,
+		models.SettingKeySMTPBodyTemplate:    s.BodyTemplate,
+		models.SettingKeySMTPCooldown:        strconv.Itoa(s.Cooldown),
+		models.SettingKeySMTPMaxPerHour:      strconv.Itoa(s.MaxPerHour),
+	}
+	if s.Password != "" {
+		values[models.SettingKeySMTPPassword] = s.Password
+	}
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for key, value := range values {
+			if _, err := gorm.G[models.Setting](tx).Where(models.ByKey(key)).Update(ctx, "value", value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ValidateEmailSettings 校验邮件告警设置，Host 为空表示关闭，此时只校验模板与限流参数
+func ValidateEmailSettings(s EmailSettings) error {
+	if s.Host != "" {
+		if s.Port < 1 || s.Port > 65535 {
+			return fmt.Errorf("invalid port %d", s.Port)
+		}
+		if _, err := mail.ParseAddress(s.From); err != nil {
+			return fmt.Errorf("invalid from address %q", s.From)
+		}
+		if len(s.To) == 0 {
+			return errors.New("at least one recipient is required")
+		}
+	}
+	for _, to := range s.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q", to)
+		}
+	}
+	for _, event := range s.Events {
+		if !slices.Contains(NotifyEvents, event) {
+			return fmt.Errorf("unknown notification event %q", event)
+		}
+	}
+	if _, _, err := renderEmail(s, NotificationEvent{Time: time.Now()}); err != nil {
+		return err
+	}
+	if s.Cooldown < 0 || s.MaxPerHour < 0 {
+		return errors.New("cooldown and max_per_hour must not be negative")
+	}
+	return nil
+}
+
+// renderEmail 按模板渲染邮件标题与正文
+func renderEmail(s EmailSettings, event NotificationEvent) (subject, body string, err error) {
+	subjectTemplate, bodyTemplate := s.SubjectTemplate, s.BodyTemplate
+	if subjectTemplate == "" {
+		subjectTemplate = defaultEmailSubjectTemplate
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = defaultEmailBodyTemplate
+	}
+	if subject, err = executeTemplate("subject", subjectTemplate, event); err != nil {
+		return "", "", err
+	}
+	if body, err = executeTemplate("body", bodyTemplate, event); err != nil {
+		return "", "", err
+	}
+	// 标题中的换行会破坏邮件头
+	return strings.Join(strings.Fields(subject), " "), body, nil
+}
+
+func executeTemplate(name, text string, event NotificationEvent) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// emailLimiter 邮件告警限流：同一告警在冷却时间内只发送一次，且每小时总数不超过上限
+type emailLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	sent []time.Time
+}
+
+var emailAlerts = &emailLimiter{last: make(map[string]time.Time)}
+
+func (l *emailLimiter) allow(key string, cooldown time.Duration, maxPerHour int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last[key]; ok && now.Sub(last) < cooldown {
+		return false
+	}
+	l.sent = slices.DeleteFunc(l.sent, func(t time.Time) bool { return now.Sub(t) >= time.Hour })
+	if maxPerHour > 0 && len(l.sent) >= maxPerHour {
+		return false
+	}
+	for k, last := range l.last {
+		if now.Sub(last) >= max(cooldown, time.Hour) {
+			delete(l.last, k)
+		}
+	}
+	l.last[key] = now
+	l.sent = append(l.sent, now)
+	return true
+}
+
+// notifyEmail 邮件告警已配置且订阅了事件时异步发送，被限流的告警只记录日志
+func notifyEmail(ctx context.Context, event NotificationEvent) {
+	if event.Event == NotifyEventTest {
+		return
+	}
+	settings, err := GetEmailSettings(ctx)
+	if err != nil {
+		slog.Error("failed to load email settings", "error", err)
+		return
+	}
+	if !settings.enabled() || (len(settings.Events) > 0 && !slices.Contains(settings.Events, event.Event)) {
+		return
+	}
+	key := strings.Join([]string{event.Event, event.Status, event.Model, event.Provider, event.ProviderModel, event.APIKey}, "|")
+	if !emailAlerts.allow(key, time.Duration(settings.Cooldown)*time.Minute, settings.MaxPerHour, time.Now()) {
+		slog.Info("email alert rate limited", "event", event.Event, "title", event.Title)
+		return
+	}
+	go func() {
+		if err := SendEmailAlert(context.Background(), settings, event); err != nil {
+			slog.Error("failed to send email alert", "event", event.Event, "error", err)
+		}
+	}()
+}
+
+// SendEmailAlert 渲染事件并同步发送邮件，不经过限流
+func SendEmailAlert(ctx context.Context, s EmailSettings, event NotificationEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	subject, body, err := renderEmail(s, event)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, s, subject, body)
+}
+
+// sendMail 通过 SMTP 发送纯文本邮件，465 端口使用隐式 TLS，其余端口在服务器支持时升级 STARTTLS
+func sendMail(ctx context.Context, s EmailSettings, subject, body string) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address %q", s.From)
+	}
+	recipients := make([]string, 0, len(s.To))
+	for _, to := range s.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q", to)
+		}
+		recipients = append(recipients, addr.Address)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+	var conn net.Conn
+	if s.Port == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range recipients {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(from, s.To, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func emailMessage(from *mail.Address, to []string, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return buf.Bytes()
+}
+
+// splitList 拆分逗号分隔的设置值，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+// fakeSMTP 只实现发送一封邮件所需命令的 SMTP 服务器，收到的邮件写入返回的 channel
+func fakeSMTP(t *testing.T) (host string, port int, messages <-chan *mail.Message) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan *mail.Message, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, received)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func serveSMTP(conn net.Conn, received chan<- *mail.Message) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			if msg, err := mail.ReadMessage(strings.NewReader(data.String())); err == nil {
+				received <- msg
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestRenderEmail(t *testing.T) {
+	event := NotificationEvent{
+		Event:    NotifyEventHealthCheckFailing,
+		Time:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Title:    "Health check failing",
+		Message:  "openai / gpt-4o failed 3 checks",
+		Model:    "gpt-4o",
+		Provider: "openai",
+	}
+	subject, body, err := renderEmail(EmailSettings{}, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "[llmio] Health check failing" {
+		t.Errorf("subject = %q", subject)
+	}
+	want := "openai / gpt-4o failed 3 checks\n\nEvent: health_check_failing\nTime: 2026-01-02 03:04:05 UTC\nModel: gpt-4o\nProvider: openai\n"
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	subject, _, err = renderEmail(EmailSettings{SubjectTemplate: "{{.Provider}}\n{{.Title}}"}, event)
+	if err != nil || subject != "openai Health check failing" {
+		t.Errorf("custom subject = %q, %v", subject, err)
+	}
+	if err := ValidateEmailSettings(EmailSettings{BodyTemplate: "{{.Unknown}}"}); err == nil {
+		t.Error("expected error for template referencing an unknown field")
+	}
+}
+
+func TestEmailLimiter(t *testing.T) {
+	l := &emailLimiter{last: make(map[string]time.Time)}
+	now := time.Now()
+	if !l.allow("a", 10*time.Minute, 2, now) {
+		t.Fatal("first alert should be sent")
+	}
+	if l.allow("a", 10*time.Minute, 2, now.Add(time.Minute)) {
+		t.Error("same alert within cooldown should be suppressed")
+	}
+	if !l.allow("b", 10*time.Minute, 2, now.Add(time.Minute)) {
+		t.Error("different alert should be sent")
+	}
+	if l.allow("c", 10*time.Minute, 2, now.Add(2*time.Minute)) {
+		t.Error("alerts over max_per_hour should be suppressed")
+	}
+	if !l.allow("a", 10*time.Minute, 2, now.Add(time.Hour)) {
+		t.Error("alert should be sent again after the hourly window")
+	}
+}
+
+func TestQuotaExhaustedEmail(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	emailAlerts = &emailLimiter{last: make(map[string]time.Time)}
+	host, port, messages := fakeSMTP(t)
+	settings := EmailSettings{
+		Host:       host,
+		Port:       port,
+		From:       "llmio <alerts@example.com>",
+		To:         []string{"ops@example.com"},
+		Events:     []string{NotifyEventQuotaExhausted},
+		Cooldown:   30,
+		MaxPerHour: 20,
+	}
+	if err := ValidateEmailSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveEmailSettings(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+	key := models.APIKey{Label: "team-a", KeyHash: "hash", TokenQuota: 100, QuotaWindow: QuotaWindow("", time.Now())}
+	if err := models.DB.Create(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	log := models.ChatLog{Name: "gpt-4o", ProviderName: "openai", APIKeyID: key.ID}
+	log.TotalTokens = 60
+	if err := RecordUsage(ctx, log); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected email %q before the quota is exhausted", msg.Header.Get("Subject"))
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := RecordUsage(ctx, log); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if subject := msg.Header.Get("Subject"); subject != "[llmio] API key quota exhausted: team-a" {
+			t.Errorf("subject = %q", subject)
+		}
+		body := new(strings.Builder)
+		if _, err := bufio.NewReader(quotedprintable.NewReader(msg.Body)).WriteTo(body); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(body.String(), "token quota 100") || !strings.Contains(body.String(), "API key: team-a") {
+			t.Errorf("body = %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for quota email")
+	}
+
+	// 配额已用尽后的请求不再通知；重置后再次用尽也在冷却时间内被限流
+	if err := RecordUsage(ctx, log); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResetQuota(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	log.TotalTokens = 150
+	if err := RecordUsage(ctx, log); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected email %q", msg.Header.Get("Subject"))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// 上游返回 200 但流式响应中没有任何内容时的处理方式
+const (
+	EmptyStreamOff      = "off"      // 不检测，按成功记录
+	EmptyStreamError    = "error"    // 原样转发，日志记为错误
+	EmptyStreamFailover = "failover" // 转发前等待首个内容事件，没有内容时记为错误并切换到其他关联
+)
+
+// ErrEmptyStream 上游流式响应结束时没有输出任何内容
+var ErrEmptyStream = errors.New("empty stream response")
+
+// ErrStreamErrorEvent 上游流式响应在输出内容前返回了错误事件
+var ErrStreamErrorEvent = errors.New("upstream stream error event")
+
+// ValidEmptyStreamHandling 判断空流式响应的处理方式是否有效
+func ValidEmptyStreamHandling(mode string) bool {
+	switch mode {
+	case EmptyStreamOff, EmptyStreamError, EmptyStreamFailover:
+		return true
+	}
+	return false
+}
+
+// getEmptyStreamHandling 获取空流式响应的处理方式，未设置或无效时按 failover 处理
+func getEmptyStreamHandling(ctx context.Context) string {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyEmptyStreamHandling)).First(ctx)
+	if err != nil || !ValidEmptyStreamHandling(setting.Value) {
+		return EmptyStreamFailover
+	}
+	return setting.Value
+}
+
+// streamChunkHasContent 判断一个 SSE data 数据块是否带有输出内容，兼容 OpenAI、Anthropic 与 Responses 格式；
+// 只有角色、用量或结束标记的数据块不算内容，上游错误视为有内容，交由日志处理记录
+func streamChunkHasContent(data string) bool {
+	data = strings.TrimSpace(data)
+	if data == "" || data == "[DONE]" || !gjson.Valid(data) {
+		return false
+	}
+	chunk := gjson.Parse(data)
+	if chunk.Get("error").Exists() {
+		return true
+	}
+	for _, choice := range chunk.Get("choices").Array() {
+		delta := choice.Get("delta")
+		for _, field := range []string{"content", "reasoning_content", "reasoning", "refusal"} {
+			if delta.Get(field).String() != "" {
+				return true
+			}
+		}
+		if len(delta.Get("tool_calls").Array()) > 0 || choice.Get("text").String() != "" {
+			return true
+		}
+	}
+	switch eventType := chunk.Get("type").String(); {
+	case eventType == "content_block_delta":
+		return true
+	case eventType == "content_block_start":
+		// 文本块开始时为空，工具调用块开始即带有工具名
+		return chunk.Get("content_block.type").String() != "text"
+	case strings.HasPrefix(eventType, "response.") && strings.HasSuffix(eventType, ".delta"):
+		return chunk.Get("delta").String() != ""
+	case eventType == "response.output_item.added":
+		return chunk.Get("item.type").String() == "function_call"
+	}
+	return false
+}
+
+// streamHasContent 判断记录的流式数据块中是否有输出内容
+func streamHasContent(chunks []string) bool {
+	for _, chunk := range chunks {
+		if streamChunkHasContent(chunk) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamChunkError 返回 SSE data 数据块中上游错误事件的错误信息，兼容 OpenAI 与 Anthropic 格式
+func streamChunkError(data string) (string, bool) {
+	data = strings.TrimSpace(data)
+	if !gjson.Valid(data) {
+		return "", false
+	}
+	errorValue := gjson.Get(data, "error")
+	if !errorValue.Exists() || errorValue.Type == gjson.Null {
+		return "", false
+	}
+	if message := errorValue.Get("message").String(); message != "" {
+		return message, true
+	}
+	return errorValue.Raw, true
+}
+
+// awaitStreamContent 读取流式响应直到出现首个内容事件，已读取的数据随返回的 body 一并转发；
+// 此前上游返回错误事件或读取失败时返回错误，此时尚未向客户端写入任何数据，可以切换到其他关联；
+// 流结束时仍没有内容则返回 ErrEmptyStream，同时返回已读取的数据，由调用方按空流处理方式决定是否转发
+func awaitStreamContent(body io.ReadCloser) (io.ReadCloser, error) {
+	var buffered bytes.Buffer
+	reader := bufio.NewReaderSize(body, InitScannerBufferSize)
+	for {
+		line, err := reader.ReadBytes('\n')
+		buffered.Write(line)
+		if data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:")); ok {
+			if message, failed := streamChunkError(string(data)); failed {
+				return nil, fmt.Errorf("%w: %s", ErrStreamErrorEvent, message)
+			}
+			if streamChunkHasContent(string(data)) {
+				return &streamReplay{Reader: io.MultiReader(&buffered, reader), body: body}, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return &streamReplay{Reader: &buffered, body: body}, ErrEmptyStream
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// streamReplay 先返回等待内容时已读取的数据，再继续读取上游响应
+type streamReplay struct {
+	io.Reader
+	body io.Closer
+}
+
+func (r *streamReplay) Close() error {
+	return r.body.Close()
+}
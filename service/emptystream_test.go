@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamChunkHasContent(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk string
+		want  bool
+	}{
+		{"openai role only", `{"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`, false},
+		{"openai usage", `{"choices":[],"usage":{"total_tokens":3}}`, false},
+		{"openai content", `{"choices":[{"index":0,"delta":{"content":"hi"}}]}`, true},
+		{"openai reasoning", `{"choices":[{"index":0,"delta":{"reasoning_content":"thinking"}}]}`, true},
+		{"openai tool call", `{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"f"}}]}}]}`, true},
+		{"done", `[DONE]`, false},
+		{"upstream error", `{"error":{"message":"boom"}}`, true},
+		{"anthropic message start", `{"type":"message_start","message":{"usage":{"input_tokens":3}}}`, false},
+		{"anthropic text start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`, false},
+		{"anthropic tool start", `{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","name":"f"}}`, true},
+		{"anthropic delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`, true},
+		{"responses created", `{"type":"response.created","response":{}}`, false},
+		{"responses text delta", `{"type":"response.output_text.delta","delta":"hi"}`, true},
+		{"responses function call", `{"type":"response.output_item.added","item":{"type":"function_call","name":"f"}}`, true},
+	}
+	for _, tt := range tests {
+		if got := streamChunkHasContent(tt.chunk); got != tt.want {
+			t.Errorf("%s: streamChunkHasContent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAwaitStreamContent(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"
+	body, err := awaitStreamContent(io.NopCloser(strings.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("awaitStreamContent failed: %v", err)
+	}
+	// 等待期间读取的数据原样转发
+	if replayed, _ := io.ReadAll(body); string(replayed) != stream {
+		t.Errorf("replayed = %q, want %q", replayed, stream)
+	}
+
+	empty := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\ndata: [DONE]\n\n"
+	body, err = awaitStreamContent(io.NopCloser(strings.NewReader(empty)))
+	if !errors.Is(err, ErrEmptyStream) {
+		t.Errorf("Expected ErrEmptyStream, got %v", err)
+	}
+	// 空流同样返回已读取的数据，供不切换时转发
+	if replayed, _ := io.ReadAll(body); string(replayed) != empty {
+		t.Errorf("replayed = %q, want %q", replayed, empty)
+	}
+
+	failed := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\nevent: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	if _, err := awaitStreamContent(io.NopCloser(strings.NewReader(failed))); !errors.Is(err, ErrStreamErrorEvent) || !strings.Contains(err.Error(), "Overloaded") {
+		t.Errorf("Expected ErrStreamErrorEvent, got %v", err)
+	}
+}
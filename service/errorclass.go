@@ -0,0 +1,207 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// 请求日志的错误分类
+const (
+	ErrorCategoryAuth          = "auth"
+	ErrorCategoryRateLimit     = "rate_limit"
+	ErrorCategoryTimeout       = "timeout"
+	ErrorCategoryContextLength = "context_length"
+	ErrorCategoryContentFilter = "content_filter"
+	ErrorCategoryNetwork       = "network"
+	ErrorCategoryServer        = "5xx"
+	ErrorCategoryOther         = "other"
+)
+
+// ErrorCategories 全部错误分类
+var ErrorCategories = []string{
+	ErrorCategoryAuth,
+	ErrorCategoryRateLimit,
+	ErrorCategoryTimeout,
+	ErrorCategoryContextLength,
+	ErrorCategoryContentFilter,
+	ErrorCategoryNetwork,
+	ErrorCategoryServer,
+	ErrorCategoryOther,
+}
+
+var (
+	// upstreamStatusPattern 匹配上游非 200 响应记录的 "status: 429, body: ..."
+	upstreamStatusPattern = regexp.MustCompile(`^status: (\d{3})\b`)
+	contextLengthPattern  = regexp.MustCompile(`context[ _]length|context[ _]window|maximum context|too many tokens|prompt is too long|input is too long|reduce the length|max_tokens.*(exceed|too large)|request too large`)
+	contentFilterPattern  = regexp.MustCompile(`content[ _]filter|content management policy|content_policy|safety|moderation|flagged|responsible ai|prohibited_content|recitation`)
+	authPattern           = regexp.MustCompile(`unauthorized|unauthenticated|invalid[ _]api[ _]key|incorrect api key|authentication|permission[ _]denied|forbidden|invalid x-api-key`)
+	rateLimitPattern      = regexp.MustCompile(`rate[ _]limit|too many requests|quota|resource[ _]exhausted|overloaded`)
+	timeoutPattern        = regexp.MustCompile(`timeout|timed out|time out|deadline exceeded|elapsed time exceeded`)
+	networkPattern        = regexp.MustCompile(`connection refused|connection reset|no such host|dial tcp|broken pipe|network is unreachable|\beof\b|tls:|x509:|proxyconnect|server closed|i/o`)
+)
+
+// ClassifyError 按错误信息与上游状态码归类，非错误状态返回空字符串；
+// 上下文超限与内容审核常以 400 返回，优先按错误内容判断
+func ClassifyError(status, errMsg string) string {
+	if status != "error" {
+		return ""
+	}
+	msg := strings.ToLower(errMsg)
+	code := 0
+	if match := upstreamStatusPattern.FindStringSubmatch(msg); match != nil {
+		code, _ = strconv.Atoi(match[1])
+	}
+	switch {
+	case contextLengthPattern.MatchString(msg) || code == 413:
+		return ErrorCategoryContextLength
+	case contentFilterPattern.MatchString(msg):
+		return ErrorCategoryContentFilter
+	case code == 401 || code == 403 || code < 500 && authPattern.MatchString(msg):
+		return ErrorCategoryAuth
+	case code == 429 || code < 500 && rateLimitPattern.MatchString(msg):
+		return ErrorCategoryRateLimit
+	case code == 408 || code == 504 || timeoutPattern.MatchString(msg):
+		return ErrorCategoryTimeout
+	case code >= 500:
+		return ErrorCategoryServer
+	case networkPattern.MatchString(msg):
+		return ErrorCategoryNetwork
+	default:
+		return ErrorCategoryOther
+	}
+}
+
+// BackfillErrorCategories 为新增分类字段之前写入的错误日志补充分类，分批处理直到没有遗漏
+func BackfillErrorCategories(ctx context.Context) {
+	const batchSize = 500
+	updated := 0
+	var lastID uint
+	for ctx.Err() == nil {
+		logs, err := gorm.G[models.ChatLog](models.DB).
+			Select("id", "status", "error").
+			Where("id > ? AND status = ? AND (error_category = '' OR error_category IS NULL)", lastID, "error").
+			Order("id").
+			Limit(batchSize).
+			Find(ctx)
+		if err != nil {
+			slog.Error("failed to load logs for error category backfill", "error", err)
+			return
+		}
+		if len(logs) == 0 {
+			break
+		}
+		for _, log := range logs {
+			if _, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", log.ID).Update(ctx, "error_category", ClassifyError(log.Status, log.Error)); err != nil {
+				slog.Error("failed to backfill error category", "log_id", log.ID, "error", err)
+				return
+			}
+		}
+		updated += len(logs)
+		lastID = logs[len(logs)-1].ID
+	}
+	if updated > 0 {
+		slog.Info("error categories backfilled", "logs", updated)
+	}
+}
+
+// ErrorQuery 错误分布查询条件，Model 与 Provider 为空表示不过滤
+type ErrorQuery struct {
+	Since    time.Time
+	Model    string
+	Provider string
+}
+
+// ErrorMetric 某供应商某类错误在窗口内的次数，Rate 为占该供应商请求数的百分比
+type ErrorMetric struct {
+	Category     string    `json:"category"`
+	ProviderName string    `json:"provider_name"`
+	Errors       int64     `json:"errors"`
+	Requests     int64     `json:"requests"`
+	Rate         float64   `json:"rate"`
+	LastError    string    `json:"last_error"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// ErrorBreakdown 窗口内的错误分布：按分类汇总，以及按分类与供应商的明细
+type ErrorBreakdown struct {
+	Requests   int64            `json:"requests"`
+	Errors     int64            `json:"errors"`
+	Categories map[string]int64 `json:"categories"`
+	Items      []ErrorMetric    `json:"items"`
+}
+
+// GetErrorBreakdown 按错误分类与供应商统计错误次数与错误率，按次数降序
+func GetErrorBreakdown(ctx context.Context, query ErrorQuery) (*ErrorBreakdown, error) {
+	var totals []struct {
+		ProviderName string
+		Requests     int64
+	}
+	chain := models.DB.WithContext(ctx).Model(&models.ChatLog{}).Where("created_at >= ? AND status <> ?", query.Since, "cancelled")
+	if query.Model != "" {
+		chain = chain.Where("name = ?", query.Model)
+	}
+	if query.Provider != "" {
+		chain = chain.Where("provider_name = ?", query.Provider)
+	}
+	if err := chain.Session(&gorm.Session{}).Select("provider_name, SUM(sample_weight) AS requests").Group("provider_name").Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	var logs []struct {
+		ProviderName  string
+		Error         string
+		ErrorCategory string
+		SampleWeight  int64
+		CreatedAt     time.Time
+	}
+	if err := chain.Session(&gorm.Session{}).Select("provider_name, error, error_category, sample_weight, created_at").Where("status = ?", "error").Order("id").Scan(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	breakdown := &ErrorBreakdown{Categories: make(map[string]int64, len(ErrorCategories)), Items: make([]ErrorMetric, 0)}
+	for _, category := range ErrorCategories {
+		breakdown.Categories[category] = 0
+	}
+	requests := make(map[string]int64, len(totals))
+	for _, total := range totals {
+		requests[total.ProviderName] = total.Requests
+		breakdown.Requests += total.Requests
+	}
+	index := make(map[[2]string]int)
+	for _, log := range logs {
+		category := log.ErrorCategory
+		// 尚未补充分类的旧日志即时归类
+		if category == "" {
+			category = ClassifyError("error", log.Error)
+		}
+		breakdown.Errors += log.SampleWeight
+		breakdown.Categories[category] += log.SampleWeight
+		key := [2]string{category, log.ProviderName}
+		i, ok := index[key]
+		if !ok {
+			i = len(breakdown.Items)
+			index[key] = i
+			breakdown.Items = append(breakdown.Items, ErrorMetric{Category: category, ProviderName: log.ProviderName, Requests: requests[log.ProviderName]})
+		}
+		item := &breakdown.Items[i]
+		item.Errors += log.SampleWeight
+		item.LastError, item.LastSeen = log.Error, log.CreatedAt
+	}
+	for i := range breakdown.Items {
+		if item := &breakdown.Items[i]; item.Requests > 0 {
+			item.Rate = float64(item.Errors) / float64(item.Requests) * 100
+		}
+	}
+	slices.SortStableFunc(breakdown.Items, func(a, b ErrorMetric) int {
+		return cmp.Or(cmp.Compare(b.Errors, a.Errors), cmp.Compare(a.Category, b.Category), cmp.Compare(a.ProviderName, b.ProviderName))
+	})
+	return breakdown, nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		status, msg, want string
+	}{
+		{"success", "", ""},
+		{"error", `status: 401, body: {"error":{"message":"Incorrect API key provided"}}`, ErrorCategoryAuth},
+		{"error", `status: 400, body: {"error":{"type":"authentication_error"}}`, ErrorCategoryAuth},
+		{"error", `status: 429, body: {"error":{"message":"Rate limit reached for gpt-4o"}}`, ErrorCategoryRateLimit},
+		{"error", `status: 400, body: {"error":{"message":"This model's maximum context length is 128000 tokens","code":"context_length_exceeded"}}`, ErrorCategoryContextLength},
+		{"error", `status: 413, body: request entity too large`, ErrorCategoryContextLength},
+		{"error", `status: 400, body: {"error":{"code":"content_filter","message":"The response was filtered"}}`, ErrorCategoryContentFilter},
+		{"error", `status: 504, body: gateway timeout`, ErrorCategoryTimeout},
+		{"error", ErrFirstTokenTimeout.Error(), ErrorCategoryTimeout},
+		{"error", "retry time out", ErrorCategoryTimeout},
+		{"error", `status: 503, body: {"error":{"message":"quota service unavailable"}}`, ErrorCategoryServer},
+		{"error", `Post "https://api.example.com/v1/chat/completions": dial tcp: lookup api.example.com: no such host`, ErrorCategoryNetwork},
+		{"error", "unexpected EOF", ErrorCategoryNetwork},
+		{"error", `status: 400, body: {"error":{"message":"invalid tool schema"}}`, ErrorCategoryOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.status, tt.msg); got != tt.want {
+			t.Errorf("ClassifyError(%q, %q) = %q, want %q", tt.status, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestGetErrorBreakdown(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	withError := func(log models.ChatLog, msg string) models.ChatLog {
+		log.Error = msg
+		return log
+	}
+	logs := []models.ChatLog{
+		rollupTestLog(now.Add(-time.Hour), "openai", "success", 1, time.Second),
+		rollupTestLog(now.Add(-time.Hour), "openai", "success", 1, time.Second),
+		withError(rollupTestLog(now.Add(-time.Hour), "openai", "error", 1, 0), "status: 429, body: rate limited"),
+		withError(rollupTestLog(now.Add(-time.Hour), "openai", "error", 1, 0), "status: 429, body: slow down"),
+		withError(rollupTestLog(now.Add(-time.Hour), "azure", "error", 3, 0), "status: 401, body: unauthorized"),
+		withError(rollupTestLog(now.Add(-48*time.Hour), "azure", "error", 1, 0), "status: 500, body: outside window"),
+	}
+	// 逐条写入以验证写入时分类
+	for _, log := range logs {
+		if _, err := SaveChatLog(ctx, log); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 模拟升级前未分类的历史日志
+	legacy := withError(rollupTestLog(now.Add(-time.Minute), "openai", "error", 1, 0), "upstream first token timeout")
+	if err := models.DB.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var saved models.ChatLog
+	if err := models.DB.Where("provider_name = ? AND status = ?", "azure", "error").Order("id").First(&saved).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.ErrorCategory != ErrorCategoryAuth {
+		t.Errorf("saved category = %q, want auth", saved.ErrorCategory)
+	}
+
+	breakdown, err := GetErrorBreakdown(ctx, ErrorQuery{Since: now.Add(-24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breakdown.Requests != 8 || breakdown.Errors != 6 || breakdown.Categories[ErrorCategoryTimeout] != 1 || breakdown.Categories[ErrorCategoryServer] != 0 {
+		t.Errorf("breakdown = %+v", breakdown)
+	}
+	if len(breakdown.Items) != 3 {
+		t.Fatalf("items = %+v, want 3", breakdown.Items)
+	}
+	auth, rateLimit := breakdown.Items[0], breakdown.Items[1]
+	if auth.Category != ErrorCategoryAuth || auth.ProviderName != "azure" || auth.Errors != 3 || auth.Requests != 3 || auth.Rate != 100 {
+		t.Errorf("auth item = %+v", auth)
+	}
+	if rateLimit.Category != ErrorCategoryRateLimit || rateLimit.Errors != 2 || rateLimit.Requests != 5 || rateLimit.Rate != 40 || rateLimit.LastError != "status: 429, body: slow down" {
+		t.Errorf("rate limit item = %+v", rateLimit)
+	}
+
+	BackfillErrorCategories(ctx)
+	var backfilled models.ChatLog
+	if err := models.DB.First(&backfilled, legacy.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if backfilled.ErrorCategory != ErrorCategoryTimeout {
+		t.Errorf("backfilled category = %q, want timeout", backfilled.ErrorCategory)
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// 格式转换保真度问题类型
+const (
+	FidelityDroppedField     = "dropped_field"     // 客户端请求中的字段在转换后丢失
+	FidelityUnparseableChunk = "unparseable_chunk" // 上游流式响应中无法解析而被跳过的 SSE 数据块
+	FidelityFallback         = "fallback"          // 未知格式按默认的 OpenAI 格式转换
+)
+
+// fidelityConsumedFields 各客户端格式转换为统一格式时会读取的顶层字段，其余字段在跨格式转换时丢失
+var fidelityConsumedFields = map[string]map[string]bool{
+	"openai": fieldSet("model", "stream", "max_tokens", "temperature", "top_p", "messages", "tools", "tool_choice", "parallel_tool_calls", "reasoning_effort", "response_format",
+		"stop", "frequency_penalty", "presence_penalty", "seed", "logprobs", "top_logprobs", "user",
+		// 转换为 OpenAI 格式时总会重新开启 include_usage
+		"stream_options"),
+	"anthropic": fieldSet("model", "stream", "system", "max_tokens", "temperature", "top_p", "messages", "tools", "tool_choice", "thinking", "output_format", "stop_sequences"),
+	"openai-res": fieldSet("model", "stream", "instructions", "max_output_tokens", "temperature", "top_p", "input", "tools", "tool_choice", "parallel_tool_calls", "reasoning", "text",
+		"user", "top_logprobs"),
+}
+
+// 转换器支持的客户端与上游格式，客户端侧不含 gemini
+var (
+	fidelityClientStyles   = fieldSet("openai", "anthropic", "openai-res")
+	fidelityProviderStyles = fieldSet("openai", "anthropic", "gemini", "openai-res")
+)
+
+func fieldSet(fields ...string) map[string]bool {
+	set := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		set[field] = true
+	}
+	return set
+}
+
+type fidelityKey struct {
+	Client   string
+	Provider string
+	Kind     string
+	Detail   string
+}
+
+// FidelityStat 某条转换路径上一类保真度问题的累计次数
+type FidelityStat struct {
+	Client   string    `json:"client"`
+	Provider string    `json:"provider"`
+	Kind     string    `json:"kind"`
+	Detail   string    `json:"detail"` // 丢失的字段名或回退的格式
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+var fidelityStats = struct {
+	sync.Mutex
+	since time.Time
+	stats map[fidelityKey]*FidelityStat
+}{since: time.Now(), stats: make(map[fidelityKey]*FidelityStat)}
+
+// RecordFidelityIssue 记录一次格式转换保真度问题
+func RecordFidelityIssue(client, provider, kind, detail string) {
+	key := fidelityKey{Client: client, Provider: provider, Kind: kind, Detail: detail}
+	fidelityStats.Lock()
+	defer fidelityStats.Unlock()
+	stat, ok := fidelityStats.stats[key]
+	if !ok {
+		stat = &FidelityStat{Client: client, Provider: provider, Kind: kind, Detail: detail}
+		fidelityStats.stats[key] = stat
+	}
+	stat.Count++
+	stat.LastSeen = time.Now()
+}
+
+// FidelityStats 返回进程启动或上次重置以来的保真度问题统计，按次数降序
+func FidelityStats() (since time.Time, stats []FidelityStat) {
+	fidelityStats.Lock()
+	defer fidelityStats.Unlock()
+	stats = make([]FidelityStat, 0, len(fidelityStats.stats))
+	for _, stat := range fidelityStats.stats {
+		stats = append(stats, *stat)
+	}
+	slices.SortFunc(stats, func(a, b FidelityStat) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Client, b.Client), strings.Compare(a.Provider, b.Provider),
+			strings.Compare(a.Kind, b.Kind), strings.Compare(a.Detail, b.Detail))
+	})
+	return fidelityStats.since, stats
+}
+
+// ResetFidelityStats 清空保真度问题统计
+func ResetFidelityStats() {
+	fidelityStats.Lock()
+	defer fidelityStats.Unlock()
+	fidelityStats.since = time.Now()
+	fidelityStats.stats = make(map[fidelityKey]*FidelityStat)
+}
+
+// recordRequestFidelity 统计请求转换中丢失的字段与格式回退
+func (tm *TransformerManager) recordRequestFidelity(rawBody []byte) {
+	if !fidelityClientStyles[tm.clientType] {
+		RecordFidelityIssue(tm.clientType, tm.providerType, FidelityFallback, "client:"+tm.clientType)
+	}
+	if !fidelityProviderStyles[tm.providerType] {
+		RecordFidelityIssue(tm.clientType, tm.providerType, FidelityFallback, "provider:"+tm.providerType)
+	}
+	consumed, ok := fidelityConsumedFields[tm.clientType]
+	if !ok {
+		consumed = fidelityConsumedFields["openai"]
+	}
+	req := gjson.ParseBytes(rawBody)
+	req.ForEach(func(key, _ gjson.Result) bool {
+		if !consumed[key.String()] {
+			RecordFidelityIssue(tm.clientType, tm.providerType, FidelityDroppedField, key.String())
+		}
+		return true
+	})
+	if tm.clientType != "openai-res" {
+		return
+	}
+	// Responses 的 reasoning 等输入项与 web_search 等内置工具无法在其他格式中表达
+	req.Get("input").ForEach(func(_, item gjson.Result) bool {
+		switch t := item.Get("type").String(); t {
+		case "function_call", "function_call_output", "message", "":
+		default:
+			RecordFidelityIssue(tm.clientType, tm.providerType, FidelityDroppedField, "input[].type="+t)
+		}
+		return true
+	})
+	req.Get("tools").ForEach(func(_, tool gjson.Result) bool {
+		if t := tool.Get("type").String(); t != "function" {
+			RecordFidelityIssue(tm.clientType, tm.providerType, FidelityDroppedField, "tools[].type="+t)
+		}
+		return true
+	})
+}
+
+// fidelityStreamReader 透传上游流式响应，同时统计转换器会跳过的无法解析的 SSE 数据块
+type fidelityStreamReader struct {
+	io.ReadCloser
+	client   string
+	provider string
+	line     []byte
+}
+
+func (r *fidelityStreamReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.line = append(r.line, data...)
+			break
+		}
+		r.line = append(r.line, data[:i]...)
+		r.inspect(r.line)
+		r.line = r.line[:0]
+		data = data[i+1:]
+	}
+	if err == io.EOF && len(r.line) > 0 {
+		r.inspect(r.line)
+		r.line = r.line[:0]
+	}
+	return n, err
+}
+
+func (r *fidelityStreamReader) inspect(line []byte) {
+	payload, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r"), []byte("data:"))
+	if !ok {
+		return
+	}
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 || string(payload) == "[DONE]" {
+		return
+	}
+	var chunk map[string]json.RawMessage
+	if json.Unmarshal(payload, &chunk) != nil {
+		RecordFidelityIssue(r.client, r.provider, FidelityUnparseableChunk, "")
+	}
+}
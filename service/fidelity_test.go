@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFidelityStats(t *testing.T) {
+	ResetFidelityStats()
+	t.Cleanup(ResetFidelityStats)
+
+	tm := NewTransformerManager("anthropic", "openai")
+	body := []byte(`{"model":"claude","max_tokens":100,"metadata":{"user_id":"u"},"messages":[{"role":"user","content":"hi"}]}`)
+	if _, err := tm.ProcessRequest(context.Background(), body); err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+
+	stream := "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+		"data: {not json\n\n" +
+		"data: [DONE]\n\n"
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(stream)),
+	}
+	converted, err := tm.ProcessResponse(response)
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	if _, err := io.ReadAll(converted.Body); err != nil {
+		t.Fatalf("read converted stream: %v", err)
+	}
+
+	_, stats := FidelityStats()
+	got := make(map[string]int64)
+	for _, stat := range stats {
+		if stat.Client != "anthropic" || stat.Provider != "openai" {
+			t.Errorf("unexpected path %s -> %s", stat.Client, stat.Provider)
+		}
+		got[stat.Kind+":"+stat.Detail] = stat.Count
+	}
+	if got[FidelityDroppedField+":metadata"] != 1 {
+		t.Errorf("expected dropped metadata once, got %v", got)
+	}
+	if got[FidelityUnparseableChunk+":"] != 1 {
+		t.Errorf("expected one unparseable chunk, got %v", got)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected exactly 2 stats, got %v", got)
+	}
+}
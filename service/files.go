@@ -0,0 +1,255 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFilesMaxUploadSize = 32              // MB
+	filesTimeout              = 5 * time.Minute // 等待上游响应头的时间，上传大文件时上游处理较慢
+	filesIDPrefix             = "file-llmio-"
+)
+
+// ErrFilesProviderUnavailable 指定的供应商不存在或不支持文件接口
+var ErrFilesProviderUnavailable = errors.New("no provider available for files")
+
+// FilesSettings 文件上传设置
+type FilesSettings struct {
+	MaxUploadSize int    `json:"max_upload_size"` // 单个上传请求的大小上限（MB）
+	Provider      string `json:"provider"`        // 默认供应商名称，为空表示第一个支持文件接口的供应商
+}
+
+// GetFilesSettings 获取文件上传设置
+func GetFilesSettings(ctx context.Context) FilesSettings {
+	settings := FilesSettings{MaxUploadSize: getIntSetting(ctx, models.SettingKeyFilesMaxUploadSize, defaultFilesMaxUploadSize)}
+	if setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyFilesProvider)).First(ctx); err == nil {
+		settings.Provider = setting.Value
+	}
+	return settings
+}
+
+// FileUpload 从 multipart 上传请求中解析出的文件信息
+type FileUpload struct {
+	Filename string
+	Purpose  string
+	Bytes    int64
+}
+
+// ParseFileUpload 校验 multipart 请求体包含 file 与 purpose 字段，并读取文件名与大小
+func ParseFileUpload(contentType string, body []byte) (FileUpload, error) {
+	var upload FileUpload
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return upload, errors.New("content type must be multipart/form-data")
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	hasFile := false
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return upload, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		switch part.FormName() {
+		case "file":
+			size, err := io.Copy(io.Discard, part)
+			if err != nil {
+				return upload, fmt.Errorf("invalid multipart body: %w", err)
+			}
+			hasFile, upload.Filename, upload.Bytes = true, part.FileName(), size
+		case "purpose":
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return upload, fmt.Errorf("invalid multipart body: %w", err)
+			}
+			upload.Purpose = string(value)
+		}
+	}
+	if !hasFile || upload.Purpose == "" {
+		return upload, errors.New("file and purpose are required")
+	}
+	return upload, nil
+}
+
+// newFileID 生成 file-llmio- 前缀的文件 ID
+func newFileID() string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return filesIDPrefix + hex.EncodeToString(buf)
+}
+
+// filesProvider 按名称选择供应商，名称为空时使用设置中的默认供应商，仍为空时取第一个支持文件接口的供应商
+func filesProvider(ctx context.Context, name string) (models.Provider, providers.Filer, error) {
+	if name == "" {
+		name = GetFilesSettings(ctx).Provider
+	}
+	query := gorm.G[models.Provider](models.DB).Order("id ASC")
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+	list, err := query.Find(ctx)
+	if err != nil {
+		return models.Provider{}, nil, err
+	}
+	for _, provider := range list {
+		chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+		if err != nil {
+			continue
+		}
+		if filer, ok := chatModel.(providers.Filer); ok {
+			return provider, filer, nil
+		}
+	}
+	return models.Provider{}, nil, ErrFilesProviderUnavailable
+}
+
+// filesProviderByID 获取已上传文件所在的供应商
+func filesProviderByID(ctx context.Context, id uint) (models.Provider, providers.Filer, error) {
+	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return provider, nil, err
+	}
+	chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+	if err != nil {
+		return provider, nil, err
+	}
+	filer, ok := chatModel.(providers.Filer)
+	if !ok {
+		return provider, nil, ErrFilesProviderUnavailable
+	}
+	return provider, filer, nil
+}
+
+// doFilesRequest 发送 files 接口请求，调用方负责关闭响应体
+func doFilesRequest(ctx context.Context, provider models.Provider, filer providers.Filer, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := filer.BuildFilesReq(ctx, method, path, header, body)
+	if err != nil {
+		return nil, err
+	}
+	setRequestIDHeader(ctx, req.Header)
+	return providers.GetClientWithProxy(filesTimeout, provider.Proxy).Do(req)
+}
+
+// UploadFile 将 multipart 请求体原样上传到选中的供应商，成功后保存 llmio 文件 ID 与上游文件 ID 的映射，
+// 返回上游状态码与响应体，成功时响应中的 id 替换为 llmio 文件 ID
+func UploadFile(ctx context.Context, apiKeyID uint, providerName, contentType string, body []byte, upload FileUpload) (int, []byte, error) {
+	provider, filer, err := filesProvider(ctx, providerName)
+	if err != nil {
+		return 0, nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	res, err := doFilesRequest(ctx, provider, filer, http.MethodPost, "", header, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, data, nil
+	}
+	upstreamID := gjson.GetBytes(data, "id").String()
+	if upstreamID == "" {
+		return 0, nil, fmt.Errorf("provider %s: id missing in upload response", provider.Name)
+	}
+	if size := gjson.GetBytes(data, "bytes"); size.Exists() {
+		upload.Bytes = size.Int()
+	}
+
+	file := models.UploadedFile{
+		FileID:         newFileID(),
+		UpstreamFileID: upstreamID,
+		ProviderID:     provider.ID,
+		APIKeyID:       apiKeyID,
+		Filename:       upload.Filename,
+		Purpose:        upload.Purpose,
+		Bytes:          upload.Bytes,
+	}
+	if err := gorm.G[models.UploadedFile](models.DB).Create(ctx, &file); err != nil {
+		return 0, nil, err
+	}
+	data, err = sjson.SetBytes(data, "id", file.FileID)
+	return res.StatusCode, data, err
+}
+
+// FileObject 以保存的映射构造 OpenAI 文件对象，用于列表接口
+func FileObject(file models.UploadedFile) map[string]any {
+	return map[string]any{
+		"id":         file.FileID,
+		"object":     "file",
+		"bytes":      file.Bytes,
+		"created_at": file.CreatedAt.Unix(),
+		"filename":   file.Filename,
+		"purpose":    file.Purpose,
+	}
+}
+
+// RetrieveFile 从上游查询文件信息，成功时响应中的 id 替换为 llmio 文件 ID
+func RetrieveFile(ctx context.Context, file models.UploadedFile) (int, []byte, error) {
+	status, data, err := fileRequest(ctx, file, http.MethodGet)
+	if err != nil || status != http.StatusOK {
+		return status, data, err
+	}
+	data, err = sjson.SetBytes(data, "id", file.FileID)
+	return status, data, err
+}
+
+// DeleteFile 删除上游文件与映射；上游已不存在该文件（404）时同样删除映射
+func DeleteFile(ctx context.Context, file models.UploadedFile) (int, []byte, error) {
+	status, data, err := fileRequest(ctx, file, http.MethodDelete)
+	if err != nil || (status != http.StatusOK && status != http.StatusNotFound) {
+		return status, data, err
+	}
+	if err := models.DB.WithContext(ctx).Unscoped().Delete(&models.UploadedFile{}, file.ID).Error; err != nil {
+		return 0, nil, err
+	}
+	data, err = sjson.SetBytes([]byte(`{"object":"file","deleted":true}`), "id", file.FileID)
+	return http.StatusOK, data, err
+}
+
+// fileRequest 对上游文件发送不带请求体的请求并读取响应
+func fileRequest(ctx context.Context, file models.UploadedFile, method string) (int, []byte, error) {
+	res, err := upstreamFileRequest(ctx, file, method, "")
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	return res.StatusCode, data, err
+}
+
+// FileContent 下载上游文件内容，调用方负责关闭响应体
+func FileContent(ctx context.Context, file models.UploadedFile) (*http.Response, error) {
+	return upstreamFileRequest(ctx, file, http.MethodGet, "/content")
+}
+
+// upstreamFileRequest 对上游文件发送不带请求体的请求，suffix 为文件 ID 之后的路径
+func upstreamFileRequest(ctx context.Context, file models.UploadedFile, method, suffix string) (*http.Response, error) {
+	provider, filer, err := filesProviderByID(ctx, file.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	return doFilesRequest(ctx, provider, filer, method, "/"+url.PathEscape(file.UpstreamFileID)+suffix, http.Header{}, nil)
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	HealthCheckTriggerSchedule = "schedule" // 定时检测
+	HealthCheckTriggerManual   = "manual"   // 手动检测全部
+
+	HealthCheckBatchRunning   = "running"
+	HealthCheckBatchCompleted = "completed"
+	HealthCheckBatchTimeout   = "timeout"
+	HealthCheckBatchCancelled = "cancelled"
+)
+
+var (
+	// ErrHealthCheckBatchRunning 已有一轮批量检测在运行
+	ErrHealthCheckBatchRunning = errors.New("a health check batch is already running")
+	// errNoHealthCheckDue 定时检测时没有到期的关联
+	errNoHealthCheckDue = errors.New("no health check is due")
+)
+
+// HealthCheckBatch 一轮批量健康检测的进度，completed 为已完成检测数，skipped 为超时或取消后未执行的检测数
+type HealthCheckBatch struct {
+	ID                  int64      `json:"id"`
+	Trigger             string     `json:"trigger"`
+	Status              string     `json:"status"`
+	Concurrency         int        `json:"concurrency"`
+	ProviderConcurrency int        `json:"provider_concurrency"`
+	Total               int        `json:"total"`
+	Completed           int        `json:"completed"`
+	Succeeded           int        `json:"succeeded"`
+	Failed              int        `json:"failed"`
+	Skipped             int        `json:"skipped"`
+	StartedAt           time.Time  `json:"started_at"`
+	FinishedAt          *time.Time `json:"finished_at,omitempty"`
+}
+
+// BatchStatus 返回当前或最近一轮批量检测的进度，尚未运行过时返回 false
+func (h *HealthChecker) BatchStatus() (HealthCheckBatch, bool) {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+	if h.batch == nil {
+		return HealthCheckBatch{}, false
+	}
+	return *h.batch, true
+}
+
+// StartBatch 在后台检测全部关联并立即返回进度，后台检测不随 ctx 取消；已有批量检测在运行时返回 ErrHealthCheckBatchRunning
+func (h *HealthChecker) StartBatch(ctx context.Context, trigger string) (HealthCheckBatch, error) {
+	batch, modelProviders, pacing, err := h.beginBatch(ctx, trigger)
+	if err != nil {
+		return HealthCheckBatch{}, err
+	}
+	status := *batch
+	go h.runBatch(context.WithoutCancel(ctx), batch, modelProviders, pacing)
+	return status, nil
+}
+
+// RunBatch 检测全部关联（定时触发时只检测到期的关联），直到完成、超时或 ctx 取消后返回
+func (h *HealthChecker) RunBatch(ctx context.Context, trigger string) error {
+	batch, modelProviders, pacing, err := h.beginBatch(ctx, trigger)
+	if err != nil {
+		return err
+	}
+	h.runBatch(ctx, batch, modelProviders, pacing)
+	return nil
+}
+
+// beginBatch 登记新一轮批量检测并加载待检测的关联
+func (h *HealthChecker) beginBatch(ctx context.Context, trigger string) (*HealthCheckBatch, []models.ModelWithProvider, HealthCheckPacing, error) {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+	if h.batch != nil && h.batch.Status == HealthCheckBatchRunning {
+		return nil, nil, HealthCheckPacing{}, ErrHealthCheckBatchRunning
+	}
+
+	modelProviders, err := gorm.G[models.ModelWithProvider](models.DB).Find(ctx)
+	if err != nil {
+		return nil, nil, HealthCheckPacing{}, err
+	}
+	pacing := GetHealthCheckPacing(ctx)
+	if trigger == HealthCheckTriggerSchedule {
+		if modelProviders, err = h.dueHealthChecks(ctx, modelProviders, pacing.ScheduleJitter); err != nil {
+			return nil, nil, HealthCheckPacing{}, err
+		}
+		if len(modelProviders) == 0 {
+			return nil, nil, HealthCheckPacing{}, errNoHealthCheckDue
+		}
+	}
+
+	h.batchSeq++
+	h.batch = &HealthCheckBatch{
+		ID:                  h.batchSeq,
+		Trigger:             trigger,
+		Status:              HealthCheckBatchRunning,
+		Concurrency:         max(pacing.Concurrency, 1),
+		ProviderConcurrency: pacing.ProviderConcurrency,
+		Total:               len(modelProviders),
+		StartedAt:           time.Now(),
+	}
+	return h.batch, modelProviders, pacing, nil
+}
+
+// runBatch 以工作池并发检测，同一供应商的检测数受 ProviderConcurrency 限制，整轮超时后未开始的检测计为跳过
+func (h *HealthChecker) runBatch(ctx context.Context, batch *HealthCheckBatch, modelProviders []models.ModelWithProvider, pacing HealthCheckPacing) {
+	if pacing.BatchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(pacing.BatchTimeout)*time.Second)
+		defer cancel()
+	}
+
+	slog.Info("starting health check", "trigger", batch.Trigger, "count", batch.Total, "concurrency", batch.Concurrency)
+
+	slots := newProviderSlots(pacing.ProviderConcurrency)
+	jobs := make(chan models.ModelWithProvider)
+	var wg sync.WaitGroup
+	for range min(batch.Concurrency, len(modelProviders)) {
+		wg.Go(func() {
+			for mp := range jobs {
+				release, err := slots.acquire(ctx, mp.ProviderID)
+				if err != nil {
+					h.recordBatchResult(batch, "")
+					continue
+				}
+				h.recordBatchResult(batch, h.checkOne(ctx, &mp))
+				release()
+			}
+		})
+	}
+	for _, mp := range interleaveByProvider(modelProviders) {
+		jobs <- mp
+	}
+	close(jobs)
+	wg.Wait()
+
+	h.batchMu.Lock()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		batch.Status = HealthCheckBatchTimeout
+	case ctx.Err() != nil:
+		batch.Status = HealthCheckBatchCancelled
+	default:
+		batch.Status = HealthCheckBatchCompleted
+	}
+	finishedAt := time.Now()
+	batch.FinishedAt = &finishedAt
+	status := *batch
+	h.batchMu.Unlock()
+
+	slog.Info("health check completed", "status", status.Status, "succeeded", status.Succeeded, "failed", status.Failed, "skipped", status.Skipped, "duration", finishedAt.Sub(status.StartedAt))
+}
+
+// recordBatchResult 按 checkOne 返回的状态累计进度，空状态表示检测未执行
+func (h *HealthChecker) recordBatchResult(batch *HealthCheckBatch, status string) {
+	h.batchMu.Lock()
+	defer h.batchMu.Unlock()
+	switch status {
+	case "success":
+		batch.Completed++
+		batch.Succeeded++
+	case "error":
+		batch.Completed++
+		batch.Failed++
+	default:
+		batch.Skipped++
+	}
+}
+
+// interleaveByProvider 按供应商轮流排列关联，避免同一供应商的检测集中在一起占满工作池
+func interleaveByProvider(modelProviders []models.ModelWithProvider) []models.ModelWithProvider {
+	var order []uint
+	groups := make(map[uint][]models.ModelWithProvider)
+	for _, mp := range modelProviders {
+		if _, ok := groups[mp.ProviderID]; !ok {
+			order = append(order, mp.ProviderID)
+		}
+		groups[mp.ProviderID] = append(groups[mp.ProviderID], mp)
+	}
+
+	result := make([]models.ModelWithProvider, 0, len(modelProviders))
+	for len(result) < len(modelProviders) {
+		for _, id := range order {
+			if group := groups[id]; len(group) > 0 {
+				result = append(result, group[0])
+				groups[id] = group[1:]
+			}
+		}
+	}
+	return result
+}
+
+// providerSlots 限制同一供应商同时进行的检测数，limit 不大于 0 表示不限制
+type providerSlots struct {
+	limit int
+	mu    sync.Mutex
+	slots map[uint]chan struct{}
+}
+
+func newProviderSlots(limit int) *providerSlots {
+	return &providerSlots{limit: limit, slots: make(map[uint]chan struct{})}
+}
+
+// acquire 等待供应商的空闲名额，返回释放函数；ctx 取消时返回错误
+func (s *providerSlots) acquire(ctx context.Context, providerID uint) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.limit <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	slot, ok := s.slots[providerID]
+	if !ok {
+		slot = make(chan struct{}, s.limit)
+		s.slots[providerID] = slot
+	}
+	s.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
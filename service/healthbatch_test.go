@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func setHealthCheckSettings(t *testing.T, values map[string]int) {
+	t.Helper()
+	for key, value := range values {
+		if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(key)).Update("value", fmt.Sprint(value)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// slowUpstream 每个请求等待 delay 后返回成功，记录同时进行的最大请求数
+func slowUpstream(t *testing.T, delay time.Duration, peak *atomic.Int32) *testutil.Upstream {
+	var inflight atomic.Int32
+	return testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			current := peak.Load()
+			if n <= current || peak.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-test", "pong", 1, 1))(w, r)
+	})
+}
+
+func TestHealthCheckBatch(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	setHealthCheckSettings(t, map[string]int{
+		models.SettingKeyHealthCheckMaxQPS:              0,
+		models.SettingKeyHealthCheckProviderSpacing:     0,
+		models.SettingKeyHealthCheckJitter:              0,
+		models.SettingKeyHealthCheckConcurrency:         10,
+		models.SettingKeyHealthCheckProviderConcurrency: 1,
+		models.SettingKeyHealthCheckBatchTimeout:        0,
+	})
+
+	var peakA, peakB atomic.Int32
+	upstreamA := slowUpstream(t, 50*time.Millisecond, &peakA)
+	upstreamB := slowUpstream(t, 50*time.Millisecond, &peakB)
+	model := testutil.SeedModel(t, "gpt-test")
+	providerA := testutil.SeedProvider(t, "a", consts.StyleOpenAI, upstreamA.URL+"/v1")
+	providerB := testutil.SeedProvider(t, "b", consts.StyleOpenAI, upstreamB.URL+"/v1")
+	for i := range 3 {
+		testutil.SeedAssociation(t, model, providerA, fmt.Sprintf("gpt-a-%d", i), 100, 1)
+		testutil.SeedAssociation(t, model, providerB, fmt.Sprintf("gpt-b-%d", i), 100, 1)
+	}
+
+	// 两个供应商并行检测，同一供应商同时只有一个检测
+	checker := &HealthChecker{}
+	if err := checker.RunBatch(ctx, HealthCheckTriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	batch, ok := checker.BatchStatus()
+	if !ok || batch.Status != HealthCheckBatchCompleted || batch.Total != 6 || batch.Completed != 6 || batch.Succeeded != 6 || batch.Skipped != 0 || batch.FinishedAt == nil {
+		t.Fatalf("batch = %+v", batch)
+	}
+	if peakA.Load() != 1 || peakB.Load() != 1 {
+		t.Errorf("peak concurrency = %d, %d, want 1", peakA.Load(), peakB.Load())
+	}
+	if len(upstreamA.Requests()) != 3 || len(upstreamB.Requests()) != 3 {
+		t.Errorf("requests = %d, %d", len(upstreamA.Requests()), len(upstreamB.Requests()))
+	}
+
+	// 整轮超时后未完成的检测计为跳过，不记录失败日志
+	models.DB.Where("1 = 1").Delete(&models.HealthCheckLog{})
+	setHealthCheckSettings(t, map[string]int{models.SettingKeyHealthCheckConcurrency: 1, models.SettingKeyHealthCheckBatchTimeout: 1})
+	slow := slowUpstream(t, 600*time.Millisecond, new(atomic.Int32))
+	if err := models.DB.Model(&models.Provider{}).Where("id IN ?", []uint{providerA.ID, providerB.ID}).
+		Update("config", fmt.Sprintf(`{"base_url":%q,"api_key":%q}`, slow.URL+"/v1", testutil.TestAPIKey)).Error; err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- checker.RunBatch(ctx, HealthCheckTriggerSchedule) }()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := checker.StartBatch(ctx, HealthCheckTriggerManual); err != ErrHealthCheckBatchRunning {
+		t.Errorf("start while running = %v", err)
+	}
+	if running, _ := checker.BatchStatus(); running.Status != HealthCheckBatchRunning || running.Trigger != HealthCheckTriggerSchedule {
+		t.Errorf("running batch = %+v", running)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	batch, _ = checker.BatchStatus()
+	if batch.Status != HealthCheckBatchTimeout || batch.Succeeded != 1 || batch.Failed != 0 || batch.Skipped != 5 {
+		t.Fatalf("timed out batch = %+v", batch)
+	}
+	var failures int64
+	models.DB.Model(&models.HealthCheckLog{}).Where("status = ?", "error").Count(&failures)
+	if failures != 0 {
+		t.Errorf("failure logs = %d, want 0", failures)
+	}
+}
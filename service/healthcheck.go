@@ -1,47 +1,29 @@
 package service
 
 import (
+	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/atopos31/llmio/consts"
 	"github.com/atopos31/llmio/models"
-	"github.com/atopos31/llmio/providers"
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
 	"gorm.io/gorm"
 )
 
 const (
-	testOpenAIBody = `{
-        "model": "gpt-4.1",
-        "messages": [
-            {
-                "role": "user",
-                "content": "Write a one-sentence bedtime story about a unicorn."
-            }
-        ]
-    }`
-
-	testOpenAIResBody = `{
-        "model": "gpt-5-nano",
-        "input": "Write a one-sentence bedtime story about a unicorn."
-    }`
-
-	testAnthropicBody = `{
-    	"model": "claude-sonnet-4-5",
-    	"max_tokens": 1000,
-    	"messages": [
-      		{
-        		"role": "user", 
-        		"content": "Write a one-sentence bedtime story about a unicorn."
-      		}
-    	]
- 	}`
+	defaultHealthCheckPrompt            = "ping"
+	defaultHealthCheckTimeout           = 30 * time.Second
+	defaultHealthCheckFirstChunkTimeout = 10 * time.Second
 )
 
 // HealthChecker 健康检测服务
@@ -51,8 +33,11 @@ type HealthChecker struct {
 	ticker     *time.Ticker
 	mu         sync.RWMutex
 	running    bool
-	interval   time.Duration
 	httpClient *http.Client
+
+	batchMu  sync.Mutex
+	batch    *HealthCheckBatch
+	batchSeq int64
 }
 
 var (
@@ -70,7 +55,7 @@ func GetHealthChecker() *HealthChecker {
 	return healthChecker
 }
 
-// Start 启动健康检测服务
+// Start 启动健康检测服务，定时检测只在主节点上运行
 func (h *HealthChecker) Start(ctx context.Context) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -79,6 +64,10 @@ func (h *HealthChecker) Start(ctx context.Context) {
 		slog.Info("health checker already running")
 		return
 	}
+	if !GetLeaderElector().IsLeader() {
+		slog.Info("health checker runs on the leader instance")
+		return
+	}
 
 	// 检查是否启用健康检测
 	enabled := h.isEnabled(ctx)
@@ -87,16 +76,12 @@ func (h *HealthChecker) Start(ctx context.Context) {
 		return
 	}
 
-	// 获取检测间隔
-	interval := h.getInterval(ctx)
-	h.interval = interval
-
 	h.ctx, h.cancel = context.WithCancel(ctx)
-	h.ticker = time.NewTicker(interval)
+	h.ticker = time.NewTicker(healthCheckScheduleTick)
 	h.running = true
 
 	go h.run()
-	slog.Info("health checker started", "interval", interval)
+	slog.Info("health checker started", "interval", h.getInterval(ctx))
 }
 
 // Stop 停止健康检测服务
@@ -124,6 +109,24 @@ func (h *HealthChecker) Restart(ctx context.Context) {
 	h.Start(ctx)
 }
 
+// Supervise 在主节点上运行健康检测：立即启动，之后定期检查设置以便响应在其他实例上开启的检测，ctx 取消时停止
+func (h *HealthChecker) Supervise(ctx context.Context) {
+	h.Start(ctx)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.Stop()
+			return
+		case <-ticker.C:
+			if !h.IsRunning() && h.isEnabled(ctx) {
+				h.Start(ctx)
+			}
+		}
+	}
+}
+
 // IsRunning 检查是否正在运行
 func (h *HealthChecker) IsRunning() bool {
 	h.mu.RLock()
@@ -131,9 +134,9 @@ func (h *HealthChecker) IsRunning() bool {
 	return h.running
 }
 
-// run 运行健康检测循环
+// run 运行健康检测循环：每分钟检测一次到期的关联，各关联按自身间隔与随机浮动错开检测
 func (h *HealthChecker) run() {
-	// 立即执行一次检测
+	// 立即检测到期的关联
 	h.checkAll()
 
 	for {
@@ -147,61 +150,49 @@ func (h *HealthChecker) run() {
 				h.Stop()
 				return
 			}
-			// 检查间隔是否变更
-			newInterval := h.getInterval(h.ctx)
-			if newInterval != h.interval {
-				h.mu.Lock()
-				h.interval = newInterval
-				h.ticker.Reset(newInterval)
-				h.mu.Unlock()
-				slog.Info("health check interval updated", "interval", newInterval)
-			}
 			h.checkAll()
 		}
 	}
 }
 
-// checkAll 检查所有启用的模型提供商
+// checkAll 定时检测到期的模型提供商关联，没有到期关联或上一轮仍在运行（如手动触发）时跳过
 func (h *HealthChecker) checkAll() {
-	ctx := context.Background()
-
-	// 获取所有模型提供商关联
-	modelProviders, err := gorm.G[models.ModelWithProvider](models.DB).Find(ctx)
-	if err != nil {
-		slog.Error("failed to get model providers for health check", "error", err)
-		return
-	}
-
-	slog.Info("starting health check", "count", len(modelProviders))
-
-	for _, mp := range modelProviders {
-		h.checkOne(ctx, &mp)
+	err := h.RunBatch(h.ctx, HealthCheckTriggerSchedule)
+	switch {
+	case err == nil, errors.Is(err, errNoHealthCheckDue):
+	case errors.Is(err, ErrHealthCheckBatchRunning):
+		slog.Debug("skip scheduled health check", "error", err)
+	default:
+		slog.Error("scheduled health check failed", "error", err)
 	}
-
-	slog.Info("health check completed")
 }
 
-// checkOne 检查单个模型提供商
-func (h *HealthChecker) checkOne(ctx context.Context, mp *models.ModelWithProvider) {
+// checkOne 检查单个模型提供商，返回检测状态；未能完成检测（加载失败或 ctx 已取消）时返回空字符串
+func (h *HealthChecker) checkOne(ctx context.Context, mp *models.ModelWithProvider) string {
 	start := time.Now()
 
 	// 获取提供商信息
 	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", mp.ProviderID).First(ctx)
 	if err != nil {
 		slog.Error("failed to get provider for health check", "provider_id", mp.ProviderID, "error", err)
-		return
+		return ""
 	}
 
 	// 获取模型信息
 	model, err := gorm.G[models.Model](models.DB).Where("id = ?", mp.ModelID).First(ctx)
 	if err != nil {
 		slog.Error("failed to get model for health check", "model_id", mp.ModelID, "error", err)
-		return
+		return ""
 	}
 
 	// 执行检测
 	checkErr := h.doCheck(ctx, &provider, mp)
 	responseTime := time.Since(start).Milliseconds()
+	// 批量检测超时或服务停止打断的检测不记录，避免计为失败
+	if ctx.Err() != nil {
+		return ""
+	}
+	ctx = context.WithoutCancel(ctx)
 
 	// 记录日志
 	log := models.HealthCheckLog{
@@ -229,60 +220,143 @@ func (h *HealthChecker) checkOne(ctx context.Context, mp *models.ModelWithProvid
 
 	go EnforceHealthCheckLogRetention(context.Background())
 
-	// 处理检测结果
-	h.handleCheckResult(ctx, mp, provider.Name, checkErr == nil)
+	// 处理检测结果，密钥失效直接隔离，不计入逐步衰减
+	if !handleAuthResult(ctx, mp, provider, model.Name, checkErr) {
+		h.handleCheckResult(ctx, mp, model.Name, provider.Name, checkErr == nil)
+	}
+	return log.Status
 }
 
-// doCheck 执行实际的检测请求
+// doCheck 执行实际的检测请求：按关联的检测配置向供应商模型发送最小请求，按供应商类型转换格式
 func (h *HealthChecker) doCheck(ctx context.Context, provider *models.Provider, mp *models.ModelWithProvider) error {
-	// 创建提供商实例
-	providerInstance, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+	config := lo.FromPtr(mp.HealthCheck)
+	timeout := defaultHealthCheckTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+	p, err := newProber(*provider, *mp, timeout)
 	if err != nil {
 		return err
 	}
 
-	// 根据类型选择测试请求体
-	var testBody []byte
-	switch provider.Type {
-	case consts.StyleOpenAI:
-		testBody = []byte(testOpenAIBody)
-	case consts.StyleAnthropic:
-		testBody = []byte(testAnthropicBody)
-	case consts.StyleOpenAIRes:
-		testBody = []byte(testOpenAIResBody)
-	default:
-		testBody = []byte(testOpenAIBody)
+	// 按全局 QPS 与供应商间隔排队，避免批量检测触发上游风控
+	if err := waitHealthCheckSlot(ctx, provider.ID); err != nil {
+		return err
 	}
 
-	// 构建请求
-	header := http.Header{}
-	if mp.WithHeader != nil && *mp.WithHeader {
-		for key, value := range mp.CustomerHeaders {
-			header.Set(key, value)
+	body := healthCheckBody(config)
+	if config.Stream {
+		return checkStream(ctx, p, body, config)
+	}
+	if config.Expect == "" {
+		res, err := p.send(ctx, body)
+		if err != nil {
+			return healthCheckErr(err)
 		}
+		res.Body.Close()
+		return nil
 	}
-
-	req, err := providerInstance.BuildReq(ctx, header, mp.ProviderModel, testBody)
+	message, err := p.complete(ctx, body)
 	if err != nil {
-		return err
+		return healthCheckErr(err)
+	}
+	return checkExpect(config, message.Get("content").String())
+}
+
+// checkStream 发送流式检测请求：响应须为事件流，首个数据块在 FirstChunkTimeout 内到达，并以 finish_reason 或 [DONE] 结束
+func checkStream(ctx context.Context, p *prober, body map[string]any, config models.HealthCheckConfig) error {
+	firstChunkTimeout := defaultHealthCheckFirstChunkTimeout
+	if config.FirstChunkTimeout > 0 {
+		firstChunkTimeout = time.Duration(config.FirstChunkTimeout) * time.Second
 	}
 
-	// 发送请求，优先使用提供商级别代理
-	client := providers.GetClientWithProxy(30*time.Second, providerInstance.GetProxy())
-	res, err := client.Do(req)
+	start := time.Now()
+	res, err := p.send(ctx, body)
 	if err != nil {
+		return healthCheckErr(err)
+	}
+	stream := Timeouts{FirstToken: firstChunkTimeout}.guardStream(res.Body, start)
+	defer stream.Close()
+	if contentType := res.Header.Get("Content-Type"); !strings.Contains(contentType, "text/event-stream") {
+		return fmt.Errorf("response is not an event stream: %s", contentType)
+	}
+
+	var content strings.Builder
+	finished := false
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		if data = strings.TrimSpace(data); data == "[DONE]" {
+			finished = true
+			break
+		}
+		content.WriteString(gjson.Get(data, "choices.0.delta.content").String())
+		if gjson.Get(data, "choices.0.finish_reason").String() != "" {
+			finished = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, ErrFirstTokenTimeout) {
+			return fmt.Errorf("%w: no chunk within %s", err, firstChunkTimeout)
+		}
 		return err
 	}
-	defer res.Body.Close()
+	if !finished {
+		return errors.New("stream ended without finish_reason or [DONE]")
+	}
+	return checkExpect(config, content.String())
+}
 
-	if res.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(res.Body)
-		return &HealthCheckError{StatusCode: res.StatusCode, Body: string(body)}
+// checkExpect 配置了期望子串时核对响应内容（不区分大小写）
+func checkExpect(config models.HealthCheckConfig, content string) error {
+	if config.Expect != "" && !strings.Contains(strings.ToLower(content), strings.ToLower(config.Expect)) {
+		return fmt.Errorf("response does not contain %q: %s", config.Expect, probeSnippet(content))
 	}
+	return nil
+}
 
+// ValidateHealthCheckConfig 校验关联的健康检测配置，为空表示使用默认值
+func ValidateHealthCheckConfig(config *models.HealthCheckConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.MaxTokens < 0 || config.Timeout < 0 || config.Interval < 0 || config.FirstChunkTimeout < 0 {
+		return errors.New("max_tokens, timeout, interval and first_chunk_timeout must not be negative")
+	}
 	return nil
 }
 
+// healthCheckBody 构造 OpenAI 格式的检测请求体，未配置时发送 "ping" 且只要求 1 个输出 token
+func healthCheckBody(config models.HealthCheckConfig) map[string]any {
+	prompt := cmp.Or(config.Prompt, defaultHealthCheckPrompt)
+	maxTokens := config.MaxTokens
+	if maxTokens <= 0 {
+		// 需要核对响应内容时留出足够的输出长度
+		maxTokens = lo.Ternary(config.Expect == "", 1, 16)
+	}
+	body := map[string]any{
+		"max_tokens": maxTokens,
+		"messages":   []map[string]any{{"role": "user", "content": prompt}},
+	}
+	if config.Stream {
+		body["stream"] = true
+	}
+	return body
+}
+
+// healthCheckErr 将上游非 200 响应转换为 HealthCheckError，供密钥失效判断使用
+func healthCheckErr(err error) error {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return &HealthCheckError{StatusCode: statusErr.StatusCode, Body: statusErr.Body}
+	}
+	return err
+}
+
 // HealthCheckError 健康检测错误
 type HealthCheckError struct {
 	StatusCode int
@@ -293,14 +367,35 @@ func (e *HealthCheckError) Error() string {
 	return "health check failed with status " + strconv.Itoa(e.StatusCode) + ": " + e.Body
 }
 
+// handleAuthResult 检测返回 401/403 时隔离关联并返回 true；检测成功时解除已有的密钥失效隔离
+func handleAuthResult(ctx context.Context, mp *models.ModelWithProvider, provider models.Provider, modelName string, checkErr error) bool {
+	if checkErr == nil {
+		if mp.AuthFailedAt != nil {
+			if _, err := ClearAuthFailure(ctx, mp.ID); err != nil {
+				slog.Error("failed to clear auth failure", "model_provider_id", mp.ID, "error", err)
+			}
+		}
+		return false
+	}
+	var healthErr *HealthCheckError
+	if !errors.As(checkErr, &healthErr) || !isAuthFailure(healthErr.StatusCode) {
+		return false
+	}
+	QuarantineAuthFailure(ctx, *mp, provider, modelName, healthErr.StatusCode, healthErr.Body)
+	return true
+}
+
 // handleCheckResult 处理检测结果
-func (h *HealthChecker) handleCheckResult(ctx context.Context, mp *models.ModelWithProvider, providerName string, success bool) {
+func (h *HealthChecker) handleCheckResult(ctx context.Context, mp *models.ModelWithProvider, modelName, providerName string, success bool) {
 	failureThreshold := h.getFailureThreshold(ctx)
 	autoEnable := h.getAutoEnable(ctx)
 	failureDisableEnabled := h.getFailureDisableEnabled(ctx)
 
 	if success {
-		// 检测成功
+		// 检测成功，先还原降权前的权重
+		if mp.QuarantinedWeight > 0 {
+			releaseHealthQuarantine(ctx, mp)
+		}
 		if shouldCountHealthCheckSuccess(ctx) {
 			applySuccessAdjustments(ctx, mp.ID)
 		}
@@ -324,13 +419,33 @@ func (h *HealthChecker) handleCheckResult(ctx context.Context, mp *models.ModelW
 			return
 		}
 
+		// 连续失败次数刚达到阈值时通知一次
+		if failCount == failureThreshold {
+			Notify(ctx, NotificationEvent{
+				Event:               NotifyEventHealthCheckFailing,
+				Title:               "Health check failing",
+				Message:             fmt.Sprintf("%s / %s failed %d consecutive health checks", providerName, mp.ProviderModel, failCount),
+				Model:               modelName,
+				Provider:            providerName,
+				ProviderModel:       mp.ProviderModel,
+				ModelWithProviderID: mp.ID,
+			})
+		}
+
 		if shouldCountHealthCheckFailure(ctx) {
 			applyWeightDecayByModelProviderID(ctx, mp.ID, providerName, mp.ProviderModel)
 			applyPriorityDecayByModelProviderID(ctx, mp.ID, providerName, mp.ProviderModel)
 		}
 
-		// 只有在启用失败自动禁用功能时，才执行自动禁用逻辑
-		if failureDisableEnabled && failCount >= failureThreshold && (mp.Status == nil || *mp.Status) {
+		// 只有在启用失败自动禁用功能时才处理达到阈值的关联，quarantine 模式降权而不禁用
+		if !failureDisableEnabled || failCount < failureThreshold {
+			return
+		}
+		if policy := GetHealthCheckFailurePolicy(ctx); policy.FailureAction == HealthCheckFailureQuarantine {
+			quarantineByHealthCheck(ctx, mp.ID, failCount, policy.QuarantineWeight)
+			return
+		}
+		if mp.Status == nil || *mp.Status {
 			// 超过阈值，自动禁用
 			falseVal := false
 			if _, err := gorm.G[models.ModelWithProvider](models.DB).
@@ -339,6 +454,7 @@ func (h *HealthChecker) handleCheckResult(ctx context.Context, mp *models.ModelW
 				slog.Error("failed to disable model provider after health check failures", "id", mp.ID, "error", err)
 			} else {
 				slog.Warn("model provider auto-disabled after health check failures", "id", mp.ID, "fail_count", failCount)
+				notifyAutoDisabled(ctx, mp.ID, modelName, providerName, mp.ProviderModel, fmt.Sprintf("%d consecutive health check failures", failCount))
 			}
 		}
 	}
@@ -372,7 +488,7 @@ func (h *HealthChecker) getConsecutiveFailures(ctx context.Context, mpID uint) (
 // isEnabled 检查健康检测是否启用
 func (h *HealthChecker) isEnabled(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckEnabled).
+		Where(models.ByKey(models.SettingKeyHealthCheckEnabled)).
 		First(ctx)
 	if err != nil {
 		return false
@@ -383,7 +499,7 @@ func (h *HealthChecker) isEnabled(ctx context.Context) bool {
 // getInterval 获取检测间隔
 func (h *HealthChecker) getInterval(ctx context.Context) time.Duration {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckInterval).
+		Where(models.ByKey(models.SettingKeyHealthCheckInterval)).
 		First(ctx)
 	if err != nil {
 		return 60 * time.Minute // 默认60分钟
@@ -398,7 +514,7 @@ func (h *HealthChecker) getInterval(ctx context.Context) time.Duration {
 // getFailureThreshold 获取失败次数阈值
 func (h *HealthChecker) getFailureThreshold(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckFailureThreshold).
+		Where(models.ByKey(models.SettingKeyHealthCheckFailureThreshold)).
 		First(ctx)
 	if err != nil {
 		return 3 // 默认3次
@@ -413,7 +529,7 @@ func (h *HealthChecker) getFailureThreshold(ctx context.Context) int {
 // getAutoEnable 获取是否自动启用
 func (h *HealthChecker) getAutoEnable(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckAutoEnable).
+		Where(models.ByKey(models.SettingKeyHealthCheckAutoEnable)).
 		First(ctx)
 	if err != nil {
 		return false
@@ -424,7 +540,7 @@ func (h *HealthChecker) getAutoEnable(ctx context.Context) bool {
 // getFailureDisableEnabled 获取是否启用失败自动禁用功能
 func (h *HealthChecker) getFailureDisableEnabled(ctx context.Context) bool {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckFailureDisableEnabled).
+		Where(models.ByKey(models.SettingKeyHealthCheckFailureDisableEnabled)).
 		First(ctx)
 	if err != nil {
 		return true // 默认启用
@@ -435,7 +551,7 @@ func (h *HealthChecker) getFailureDisableEnabled(ctx context.Context) bool {
 // getLogRetentionCount 获取健康检测日志保留条数
 func (h *HealthChecker) getLogRetentionCount(ctx context.Context) int {
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckLogRetentionCount).
+		Where(models.ByKey(models.SettingKeyHealthCheckLogRetentionCount)).
 		First(ctx)
 	if err != nil {
 		return 0
@@ -492,7 +608,9 @@ func (h *HealthChecker) CheckSingle(ctx context.Context, mpID uint) (*models.Hea
 	go EnforceHealthCheckLogRetention(context.Background())
 
 	// 处理检测结果
-	h.handleCheckResult(ctx, &mp, provider.Name, checkErr == nil)
+	if !handleAuthResult(ctx, &mp, provider, model.Name, checkErr) {
+		h.handleCheckResult(ctx, &mp, model.Name, provider.Name, checkErr == nil)
+	}
 
 	return &log, nil
 }
@@ -504,7 +622,7 @@ func GetHealthCheckSettings(ctx context.Context) (enabled bool, interval int, fa
 	enabled = checker.isEnabled(ctx)
 
 	setting, err := gorm.G[models.Setting](models.DB).
-		Where("key = ?", models.SettingKeyHealthCheckInterval).
+		Where(models.ByKey(models.SettingKeyHealthCheckInterval)).
 		First(ctx)
 	if err == nil {
 		interval, _ = strconv.Atoi(setting.Value)
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestHealthCheckUsesAssociationConfig(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	for _, key := range []string{models.SettingKeyHealthCheckMaxQPS, models.SettingKeyHealthCheckProviderSpacing, models.SettingKeyHealthCheckJitter} {
+		if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(key)).Update("value", "0").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5",`+
+		`"content":[{"type":"text","text":"pong"}],"stop_reason":"end_turn","usage":{"input_tokens":8,"output_tokens":1}}`))
+	model := testutil.SeedModel(t, "claude")
+	provider := testutil.SeedProvider(t, "anthropic", consts.StyleAnthropic, upstream.URL+"/v1")
+	association := testutil.SeedAssociation(t, model, provider, "claude-haiku-4-5", 100, 1)
+
+	// 默认发送 ping，max_tokens 为 1，模型为关联的供应商模型
+	log, err := GetHealthChecker().CheckSingle(ctx, association.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "success" {
+		t.Fatalf("default check = %+v", log)
+	}
+	requests := upstream.Requests()
+	if len(requests) != 1 || !strings.HasSuffix(requests[0].Path, "/messages") {
+		t.Fatalf("requests = %+v", requests)
+	}
+	body := gjson.ParseBytes(requests[0].Body)
+	if body.Get("model").String() != "claude-haiku-4-5" || body.Get("max_tokens").Int() != 1 || body.Get("messages.0.content").String() != "ping" {
+		t.Errorf("default check body = %s", requests[0].Body)
+	}
+
+	// 配置期望子串后核对响应内容
+	config := &models.HealthCheckConfig{Prompt: "Reply with OK", Expect: "ok"}
+	if err := models.DB.Model(&models.ModelWithProvider{}).Where("id = ?", association.ID).Select("health_check").Updates(models.ModelWithProvider{HealthCheck: config}).Error; err != nil {
+		t.Fatal(err)
+	}
+	log, err = GetHealthChecker().CheckSingle(ctx, association.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Status != "error" || !strings.Contains(log.Error, `does not contain "ok"`) {
+		t.Errorf("expect check = %+v", log)
+	}
+	requests = upstream.Requests()
+	body = gjson.ParseBytes(requests[len(requests)-1].Body)
+	if body.Get("max_tokens").Int() != 16 || body.Get("messages.0.content").String() != "Reply with OK" {
+		t.Errorf("expect check body = %s", requests[len(requests)-1].Body)
+	}
+}
+
+func TestStreamHealthCheck(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	setHealthCheckSettings(t, map[string]int{
+		models.SettingKeyHealthCheckMaxQPS:          0,
+		models.SettingKeyHealthCheckProviderSpacing: 0,
+		models.SettingKeyHealthCheckJitter:          0,
+	})
+
+	var mode atomic.Value
+	mode.Store("ok")
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		switch mode.Load() {
+		case "buffered":
+			// 模拟缓冲 SSE 的代理：响应头立即返回，数据块延迟到达
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+			}
+		case "truncated":
+			testutil.SSE(testutil.OpenAIChatStream("gpt-test", 1, 1, "po")[0])(w, r)
+		default:
+			testutil.SSE(testutil.OpenAIChatStream("gpt-test", 1, 1, "po", "ng")...)(w, r)
+		}
+	})
+	model := testutil.SeedModel(t, "gpt-test")
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL+"/v1")
+	association := testutil.SeedAssociation(t, model, provider, "gpt-test", 100, 1)
+	config := &models.HealthCheckConfig{Stream: true, Expect: "pong", FirstChunkTimeout: 1}
+	if err := models.DB.Model(&models.ModelWithProvider{}).Where("id = ?", association.ID).Select("health_check").Updates(models.ModelWithProvider{HealthCheck: config}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		mode, status, err string
+	}{
+		{"ok", "success", ""},
+		{"buffered", "error", "first token timeout"},
+		{"truncated", "error", "without finish_reason"},
+	} {
+		mode.Store(tc.mode)
+		log, err := GetHealthChecker().CheckSingle(ctx, association.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if log.Status != tc.status || !strings.Contains(log.Error, tc.err) {
+			t.Errorf("%s: log = %+v", tc.mode, log)
+		}
+	}
+	requests := upstream.Requests()
+	if body := gjson.ParseBytes(requests[0].Body); !body.Get("stream").Bool() {
+		t.Errorf("stream check body = %s", requests[0].Body)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+const (
+	defaultHealthCheckMaxQPS          = 2
+	defaultHealthCheckProviderSpacing = 1000
+	defaultHealthCheckJitter          = 500
+
+	defaultHealthCheckConcurrency         = 10
+	defaultHealthCheckProviderConcurrency = 2
+	defaultHealthCheckBatchTimeout        = 1800
+	defaultHealthCheckScheduleJitter      = 10
+)
+
+// HealthCheckPacing 健康检测限速设置：全局 QPS 上限、同一供应商最小间隔与随机抖动（毫秒），
+// 以及批量检测的并发数、同一供应商并发数、整轮超时（秒）与定时检测间隔的浮动比例（百分比）
+type HealthCheckPacing struct {
+	MaxQPS          int `json:"max_qps"`
+	ProviderSpacing int `json:"provider_spacing_ms"`
+	Jitter          int `json:"jitter_ms"`
+
+	Concurrency         int `json:"concurrency"`
+	ProviderConcurrency int `json:"provider_concurrency"`
+	BatchTimeout        int `json:"batch_timeout_seconds"`
+	ScheduleJitter      int `json:"schedule_jitter_percent"`
+}
+
+// GetHealthCheckPacing 读取健康检测限速设置
+func GetHealthCheckPacing(ctx context.Context) HealthCheckPacing {
+	return HealthCheckPacing{
+		MaxQPS:          getIntSetting(ctx, models.SettingKeyHealthCheckMaxQPS, defaultHealthCheckMaxQPS),
+		ProviderSpacing: getIntSetting(ctx, models.SettingKeyHealthCheckProviderSpacing, defaultHealthCheckProviderSpacing),
+		Jitter:          getIntSetting(ctx, models.SettingKeyHealthCheckJitter, defaultHealthCheckJitter),
+
+		Concurrency:         getIntSetting(ctx, models.SettingKeyHealthCheckConcurrency, defaultHealthCheckConcurrency),
+		ProviderConcurrency: getIntSetting(ctx, models.SettingKeyHealthCheckProviderConcurrency, defaultHealthCheckProviderConcurrency),
+		BatchTimeout:        getIntSetting(ctx, models.SettingKeyHealthCheckBatchTimeout, defaultHealthCheckBatchTimeout),
+		ScheduleJitter:      min(getIntSetting(ctx, models.SettingKeyHealthCheckScheduleJitter, defaultHealthCheckScheduleJitter), 100),
+	}
+}
+
+// healthCheckPacer 为每次检测预约发送时间，避免同一时刻向同一供应商发出大量探测请求
+type healthCheckPacer struct {
+	mu           sync.Mutex
+	globalNext   time.Time
+	providerNext map[uint]time.Time
+}
+
+var healthPacer = &healthCheckPacer{providerNext: make(map[uint]time.Time)}
+
+// reserve 返回本次检测最早可以发送的时间，并推迟后续检测的可用时间
+func (p *healthCheckPacer) reserve(now time.Time, providerID uint, pacing HealthCheckPacing) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := now
+	if p.globalNext.After(start) {
+		start = p.globalNext
+	}
+	if next, ok := p.providerNext[providerID]; ok && next.After(start) {
+		start = next
+	}
+
+	if pacing.MaxQPS > 0 {
+		p.globalNext = start.Add(time.Second / time.Duration(pacing.MaxQPS))
+	}
+	spacing := time.Duration(pacing.ProviderSpacing) * time.Millisecond
+	if pacing.Jitter > 0 {
+		spacing += time.Duration(rand.N(pacing.Jitter+1)) * time.Millisecond
+	}
+	if spacing > 0 {
+		p.providerNext[providerID] = start.Add(spacing)
+	} else {
+		delete(p.providerNext, providerID)
+	}
+	return start
+}
+
+// waitHealthCheckSlot 等待到本次检测的预约时间，ctx 取消时提前返回
+func waitHealthCheckSlot(ctx context.Context, providerID uint) error {
+	start := healthPacer.reserve(time.Now(), providerID, GetHealthCheckPacing(ctx))
+	wait := time.Until(start)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthCheckPacerReserve(t *testing.T) {
+	pacer := &healthCheckPacer{providerNext: make(map[uint]time.Time)}
+	pacing := HealthCheckPacing{MaxQPS: 4, ProviderSpacing: 1000}
+	now := time.Unix(1000, 0)
+
+	if got := pacer.reserve(now, 1, pacing); !got.Equal(now) {
+		t.Fatalf("first reservation = %v, want %v", got, now)
+	}
+	// 其它供应商只受全局 QPS 限制
+	if got := pacer.reserve(now, 2, pacing); !got.Equal(now.Add(250 * time.Millisecond)) {
+		t.Fatalf("other provider reservation = %v, want +250ms", got.Sub(now))
+	}
+	// 同一供应商需等待间隔
+	if got := pacer.reserve(now, 1, pacing); !got.Equal(now.Add(time.Second)) {
+		t.Fatalf("same provider reservation = %v, want +1s", got.Sub(now))
+	}
+
+	// 抖动只会在间隔之上追加，不超过上限
+	pacing = HealthCheckPacing{ProviderSpacing: 100, Jitter: 50}
+	start := pacer.reserve(now.Add(time.Minute), 3, pacing)
+	next := pacer.reserve(start, 3, pacing)
+	if gap := next.Sub(start); gap < 100*time.Millisecond || gap > 150*time.Millisecond {
+		t.Fatalf("gap with jitter = %v, want within [100ms, 150ms]", gap)
+	}
+
+	// 全部为 0 时不限速
+	pacing = HealthCheckPacing{}
+	later := now.Add(time.Hour)
+	if got := pacer.reserve(later, 4, pacing); !got.Equal(later) {
+		t.Fatalf("unlimited reservation = %v, want %v", got, later)
+	}
+	if got := pacer.reserve(later, 4, pacing); !got.Equal(later) {
+		t.Fatalf("unlimited second reservation = %v, want %v", got, later)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	HealthCheckFailureDisable    = "disable"    // 连续失败达到阈值后禁用关联
+	HealthCheckFailureQuarantine = "quarantine" // 连续失败达到阈值后降低权重，检测恢复后还原
+
+	defaultHealthCheckQuarantineWeight = 10
+)
+
+// HealthCheckFailurePolicy 连续失败达到阈值时的处理方式，quarantine 模式下权重降为原权重的 QuarantineWeight%
+type HealthCheckFailurePolicy struct {
+	FailureAction    string `json:"failure_action"`
+	QuarantineWeight int    `json:"quarantine_weight_percent"`
+}
+
+// GetHealthCheckFailurePolicy 读取连续失败的处理方式，未知值按 disable 处理
+func GetHealthCheckFailurePolicy(ctx context.Context) HealthCheckFailurePolicy {
+	policy := HealthCheckFailurePolicy{
+		FailureAction:    HealthCheckFailureDisable,
+		QuarantineWeight: getIntSetting(ctx, models.SettingKeyHealthCheckQuarantineWeight, defaultHealthCheckQuarantineWeight),
+	}
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyHealthCheckFailureAction)).First(ctx)
+	if err == nil && setting.Value == HealthCheckFailureQuarantine {
+		policy.FailureAction = HealthCheckFailureQuarantine
+	}
+	if policy.QuarantineWeight < 1 || policy.QuarantineWeight > 100 {
+		policy.QuarantineWeight = defaultHealthCheckQuarantineWeight
+	}
+	return policy
+}
+
+// ValidateHealthCheckFailurePolicy 校验连续失败的处理方式与降权比例
+func ValidateHealthCheckFailurePolicy(policy HealthCheckFailurePolicy) error {
+	if policy.FailureAction != HealthCheckFailureDisable && policy.FailureAction != HealthCheckFailureQuarantine {
+		return fmt.Errorf("failure_action must be %q or %q", HealthCheckFailureDisable, HealthCheckFailureQuarantine)
+	}
+	if policy.QuarantineWeight < 1 || policy.QuarantineWeight > 100 {
+		return errors.New("quarantine_weight_percent must be between 1 and 100")
+	}
+	return nil
+}
+
+// quarantineByHealthCheck 将连续检测失败的关联降权并记录原权重，已降权时不重复处理；达到阈值的通知由调用方发送
+func quarantineByHealthCheck(ctx context.Context, mpID uint, failCount, percent int) {
+	mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", mpID).First(ctx)
+	if err != nil || mp.QuarantinedWeight > 0 {
+		return
+	}
+	weight := max(mp.Weight*percent/100, 1)
+	if err := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).Where("id = ?", mpID).
+		Updates(map[string]any{"weight": weight, "quarantined_weight": max(mp.Weight, 1)}).Error; err != nil {
+		slog.Error("failed to quarantine model provider after health check failures", "id", mpID, "error", err)
+		return
+	}
+	slog.Warn("model provider quarantined after health check failures", "id", mpID, "fail_count", failCount, "old_weight", mp.Weight, "new_weight", weight)
+}
+
+// releaseHealthQuarantine 检测恢复后还原降权前的权重
+func releaseHealthQuarantine(ctx context.Context, mp *models.ModelWithProvider) {
+	if err := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).Where("id = ?", mp.ID).
+		Updates(map[string]any{"weight": mp.QuarantinedWeight, "quarantined_weight": 0}).Error; err != nil {
+		slog.Error("failed to release health check quarantine", "id", mp.ID, "error", err)
+		return
+	}
+	slog.Info("model provider weight restored after health check success", "id", mp.ID, "weight", mp.QuarantinedWeight)
+}
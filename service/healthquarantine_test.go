@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestHealthCheckQuarantineMode(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	setHealthCheckSettings(t, map[string]int{
+		models.SettingKeyHealthCheckMaxQPS:           0,
+		models.SettingKeyHealthCheckProviderSpacing:  0,
+		models.SettingKeyHealthCheckJitter:           0,
+		models.SettingKeyHealthCheckFailureThreshold: 2,
+		models.SettingKeyHealthCheckQuarantineWeight: 20,
+	})
+	for key, value := range map[string]string{
+		models.SettingKeyHealthCheckFailureAction:  HealthCheckFailureQuarantine,
+		models.SettingKeyHealthCheckCountAsSuccess: "false",
+	} {
+		if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(key)).Update("value", value).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var healthy atomic.Bool
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() {
+			testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-test", "pong", 1, 1))(w, r)
+			return
+		}
+		testutil.JSON(http.StatusInternalServerError, `{"error":{"message":"overloaded"}}`)(w, r)
+	})
+	model := testutil.SeedModel(t, "gpt-test")
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL+"/v1")
+	association := testutil.SeedAssociation(t, model, provider, "gpt-test", 100, 50)
+
+	load := func() models.ModelWithProvider {
+		t.Helper()
+		var mp models.ModelWithProvider
+		if err := models.DB.First(&mp, association.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return mp
+	}
+
+	// 连续失败达到阈值后降权而不禁用，重复失败不再继续降权
+	for range 3 {
+		if _, err := GetHealthChecker().CheckSingle(ctx, association.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mp := load()
+	if mp.Weight != 10 || mp.QuarantinedWeight != 50 || !*mp.Status {
+		t.Fatalf("quarantined association: weight %d, quarantined_weight %d, status %v", mp.Weight, mp.QuarantinedWeight, *mp.Status)
+	}
+
+	// 检测恢复后还原原权重
+	healthy.Store(true)
+	if _, err := GetHealthChecker().CheckSingle(ctx, association.ID); err != nil {
+		t.Fatal(err)
+	}
+	if mp := load(); mp.Weight != 50 || mp.QuarantinedWeight != 0 {
+		t.Errorf("released association: weight %d, quarantined_weight %d", mp.Weight, mp.QuarantinedWeight)
+	}
+
+	// disable 模式下达到阈值禁用关联
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeyHealthCheckFailureAction)).Update("value", HealthCheckFailureDisable).Error; err != nil {
+		t.Fatal(err)
+	}
+	healthy.Store(false)
+	for range 2 {
+		if _, err := GetHealthChecker().CheckSingle(ctx, association.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mp := load(); *mp.Status || mp.Weight != 50 {
+		t.Errorf("disabled association: weight %d, status %v", mp.Weight, *mp.Status)
+	}
+}
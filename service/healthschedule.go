@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// healthCheckScheduleTick 定时检测检查到期关联的周期，与检测间隔的最小单位（分钟）一致
+const healthCheckScheduleTick = time.Minute
+
+// healthCheckInterval 返回关联的定时检测间隔，未单独配置时使用全局间隔
+func healthCheckInterval(mp models.ModelWithProvider, global time.Duration) time.Duration {
+	if mp.HealthCheck != nil && mp.HealthCheck.Interval > 0 {
+		return time.Duration(mp.HealthCheck.Interval) * time.Minute
+	}
+	return global
+}
+
+// healthCheckJitter 返回到期时间的浮动量，范围为间隔的 ±percent%；
+// 由关联 ID 与上次检测时间决定，同一周期内各次判断结果一致，不同关联错开检测时间
+func healthCheckJitter(mpID uint, lastCheckedAt time.Time, interval time.Duration, percent int) time.Duration {
+	if percent <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%d", mpID, lastCheckedAt.UnixNano())
+	fraction := float64(h.Sum64()%2001)/1000 - 1 // [-1, 1]
+	return time.Duration(float64(interval) * float64(percent) / 100 * fraction)
+}
+
+// lastHealthCheckTimes 返回各关联最近一次检测的时间
+func lastHealthCheckTimes(ctx context.Context) (map[uint]time.Time, error) {
+	var logs []models.HealthCheckLog
+	latest := models.DB.Model(&models.HealthCheckLog{}).Select("MAX(id)").Group("model_provider_id")
+	if err := models.DB.WithContext(ctx).Select("model_provider_id", "checked_at").Where("id IN (?)", latest).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	times := make(map[uint]time.Time, len(logs))
+	for _, log := range logs {
+		times[log.ModelProviderID] = log.CheckedAt
+	}
+	return times, nil
+}
+
+// dueHealthChecks 筛选到期的关联：从未检测过，或距上次检测已超过各自的间隔加浮动量
+func (h *HealthChecker) dueHealthChecks(ctx context.Context, modelProviders []models.ModelWithProvider, jitter int) ([]models.ModelWithProvider, error) {
+	lastChecked, err := lastHealthCheckTimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	global := h.getInterval(ctx)
+	now := time.Now()
+
+	var due []models.ModelWithProvider
+	for _, mp := range modelProviders {
+		last, ok := lastChecked[mp.ID]
+		if !ok {
+			due = append(due, mp)
+			continue
+		}
+		interval := healthCheckInterval(mp, global)
+		if !now.Before(last.Add(interval + healthCheckJitter(mp.ID, last, interval, jitter))) {
+			due = append(due, mp)
+		}
+	}
+	return due, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestDueHealthChecks(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+
+	model := testutil.SeedModel(t, "gpt-test")
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, "http://127.0.0.1:0/v1")
+	never := testutil.SeedAssociation(t, model, provider, "never-checked", 100, 1)
+	flaky := testutil.SeedAssociation(t, model, provider, "flaky", 100, 1)
+	stable := testutil.SeedAssociation(t, model, provider, "stable", 100, 1)
+	if err := models.DB.Model(&models.ModelWithProvider{}).Where("id = ?", flaky.ID).Select("health_check").
+		Updates(models.ModelWithProvider{HealthCheck: &models.HealthCheckConfig{Interval: 5}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint{flaky.ID, stable.ID} {
+		for _, checkedAt := range []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-10 * time.Minute)} {
+			log := models.HealthCheckLog{ModelProviderID: id, Status: "success", CheckedAt: checkedAt}
+			if err := models.DB.Create(&log).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 单独配置 5 分钟间隔的关联与从未检测的关联到期，使用全局 60 分钟间隔的关联未到期
+	var all []models.ModelWithProvider
+	if err := models.DB.Find(&all).Error; err != nil {
+		t.Fatal(err)
+	}
+	due, err := GetHealthChecker().dueHealthChecks(ctx, all, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != never.ID || due[1].ID != flaky.ID {
+		t.Errorf("due = %+v", due)
+	}
+
+	// 浮动量不超过间隔的 ±percent%，且各关联不同
+	interval := time.Hour
+	last := time.Now()
+	offsets := make(map[time.Duration]bool)
+	for id := uint(1); id <= 20; id++ {
+		offset := healthCheckJitter(id, last, interval, 10)
+		if offset < -6*time.Minute || offset > 6*time.Minute {
+			t.Errorf("jitter(%d) = %v out of range", id, offset)
+		}
+		if offset != healthCheckJitter(id, last, interval, 10) {
+			t.Errorf("jitter(%d) is not stable", id)
+		}
+		offsets[offset] = true
+	}
+	if len(offsets) < 10 {
+		t.Errorf("jitter offsets are not spread: %v", offsets)
+	}
+	if healthCheckJitter(1, last, interval, 0) != 0 {
+		t.Error("jitter with 0 percent should be 0")
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ImageLimits 供应商可接受的图片上限，未配置的项不限制
+type ImageLimits struct {
+	MaxDimension int // 图片最长边像素
+	MaxBytes     int // 解码后的图片字节数
+}
+
+// ProviderImageLimits 读取供应商配置的图片上限
+func ProviderImageLimits(provider models.Provider) ImageLimits {
+	return ImageLimits{MaxDimension: provider.ImageMaxDimension, MaxBytes: provider.ImageMaxBytes}
+}
+
+// Enabled 是否配置了任意上限
+func (l ImageLimits) Enabled() bool {
+	return l.MaxDimension > 0 || l.MaxBytes > 0
+}
+
+// imageField 请求体中一张 base64 图片的位置
+type imageField struct {
+	dataPath  string // base64 数据或 data URL 的路径
+	mediaPath string // 单独存放 MIME 类型的路径，为空表示数据本身是 data URL
+}
+
+// imageFields 按供应商请求格式收集请求体中的 base64 图片
+func imageFields(providerType string, body []byte) []imageField {
+	var fields []imageField
+	collect := func(listPath, partsKey string, match func(part gjson.Result) (imageField, bool)) {
+		gjson.GetBytes(body, listPath).ForEach(func(i, item gjson.Result) bool {
+			item.Get(partsKey).ForEach(func(j, part gjson.Result) bool {
+				if field, ok := match(part); ok {
+					prefix := fmt.Sprintf("%s.%d.%s.%d.", listPath, i.Int(), partsKey, j.Int())
+					field.dataPath = prefix + field.dataPath
+					if field.mediaPath != "" {
+						field.mediaPath = prefix + field.mediaPath
+					}
+					fields = append(fields, field)
+				}
+				return true
+			})
+			return true
+		})
+	}
+	switch providerType {
+	case consts.StyleOpenAI:
+		collect("messages", "content", func(part gjson.Result) (imageField, bool) {
+			return imageField{dataPath: "image_url.url"}, part.Get("type").String() == "image_url" && strings.HasPrefix(part.Get("image_url.url").String(), "data:")
+		})
+	case consts.StyleOpenAIRes:
+		collect("input", "content", func(part gjson.Result) (imageField, bool) {
+			return imageField{dataPath: "image_url"}, part.Get("type").String() == "input_image" && strings.HasPrefix(part.Get("image_url").String(), "data:")
+		})
+	case consts.StyleAnthropic:
+		collect("messages", "content", func(part gjson.Result) (imageField, bool) {
+			return imageField{dataPath: "source.data", mediaPath: "source.media_type"}, part.Get("type").String() == "image" && part.Get("source.type").String() == "base64"
+		})
+	case consts.StyleGemini:
+		collect("contents", "parts", func(part gjson.Result) (imageField, bool) {
+			return imageField{dataPath: "inlineData.data", mediaPath: "inlineData.mimeType"}, strings.HasPrefix(part.Get("inlineData.mimeType").String(), "image/")
+		})
+	}
+	return fields
+}
+
+// DownscaleImages 将请求体中超出供应商上限的 base64 图片缩放并重新压缩，返回新的请求体与处理的图片数
+// 无法解码的格式（如 webp）保持原样，由上游自行处理
+func DownscaleImages(providerType string, body []byte, limits ImageLimits) ([]byte, int, error) {
+	if !limits.Enabled() {
+		return body, 0, nil
+	}
+	changed := 0
+	for _, field := range imageFields(providerType, body) {
+		raw := gjson.GetBytes(body, field.dataPath).String()
+		encoded := raw
+		if field.mediaPath == "" {
+			header, data, ok := strings.Cut(strings.TrimPrefix(raw, "data:"), ",")
+			if !ok || !strings.HasSuffix(header, ";base64") {
+				continue
+			}
+			encoded = data
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		scaled, scaledType, ok := downscaleImage(data, limits)
+		if !ok {
+			continue
+		}
+		value := base64.StdEncoding.EncodeToString(scaled)
+		if field.mediaPath == "" {
+			value = "data:" + scaledType + ";base64," + value
+		} else if body, err = sjson.SetBytes(body, field.mediaPath, scaledType); err != nil {
+			return nil, 0, err
+		}
+		if body, err = sjson.SetBytes(body, field.dataPath, value); err != nil {
+			return nil, 0, err
+		}
+		changed++
+	}
+	return body, changed, nil
+}
+
+// jpegQualities 超出字节上限时依次尝试的 JPEG 质量
+var jpegQualities = []int{85, 70, 55, 40}
+
+// downscaleImage 缩放并重新编码单张图片，未超出上限或无法处理时返回 false
+func downscaleImage(data []byte, limits ImageLimits) ([]byte, string, bool) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false
+	}
+	overDimension := limits.MaxDimension > 0 && max(config.Width, config.Height) > limits.MaxDimension
+	overBytes := limits.MaxBytes > 0 && len(data) > limits.MaxBytes
+	if !overDimension && !overBytes {
+		return nil, "", false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", false
+	}
+	if overDimension {
+		img = resizeImage(img, limits.MaxDimension)
+	}
+	// 带透明通道的 PNG 保持 PNG，其余统一压缩为 JPEG
+	keepPNG := format == "png" && !opaque(img)
+	for {
+		if keepPNG {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return nil, "", false
+			}
+			if limits.MaxBytes <= 0 || buf.Len() <= limits.MaxBytes {
+				return buf.Bytes(), "image/png", true
+			}
+		} else {
+			for _, quality := range jpegQualities {
+				var buf bytes.Buffer
+				if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality}); err != nil {
+					return nil, "", false
+				}
+				if limits.MaxBytes <= 0 || buf.Len() <= limits.MaxBytes {
+					return buf.Bytes(), "image/jpeg", true
+				}
+			}
+		}
+		// 降低质量仍超出字节上限时继续缩小尺寸
+		bounds := img.Bounds()
+		longest := max(bounds.Dx(), bounds.Dy())
+		if longest <= 64 {
+			return nil, "", false
+		}
+		img = resizeImage(img, longest*3/4)
+	}
+}
+
+// resizeImage 按区域平均缩放图片，使最长边不超过 maxDimension
+func resizeImage(src image.Image, maxDimension int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	longest := max(width, height)
+	if longest <= maxDimension {
+		return src
+	}
+	dstW := max(1, width*maxDimension/longest)
+	dstH := max(1, height*maxDimension/longest)
+	dst := image.NewNRGBA64(image.Rect(0, 0, dstW, dstH))
+	for y := range dstH {
+		y0 := bounds.Min.Y + y*height/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstH)
+		for x := range dstW {
+			x0 := bounds.Min.X + x*width/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstW)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(src.At(sx, sy)).(color.NRGBA64)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA64(x, y, color.NRGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// opaque 图片是否不含透明像素
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// flatten 将透明区域合成到白色背景上，供 JPEG 编码
+func flatten(img image.Image) image.Image {
+	if opaque(img) {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
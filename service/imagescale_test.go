@@ -0,0 +1,88 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func testPNG(t *testing.T, width, height int, alpha uint8) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * y), G: uint8((x ^ y) * 7), B: uint8(x*31 + y*17), A: alpha})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func decodedConfig(t *testing.T, encoded string) (image.Config, string) {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, format
+}
+
+func TestDownscaleImages(t *testing.T) {
+	opaque := testPNG(t, 400, 200, 255)
+	body := []byte(`{"messages":[{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"data:image/png;base64,` + opaque + `"}},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`)
+	scaled, count, err := DownscaleImages("openai", body, ImageLimits{MaxDimension: 100})
+	if err != nil || count != 1 {
+		t.Fatalf("Expected 1 image downscaled, got %d %v", count, err)
+	}
+	url := gjson.GetBytes(scaled, "messages.0.content.1.image_url.url").String()
+	const prefix = "data:image/jpeg;base64,"
+	if len(url) < len(prefix) || url[:len(prefix)] != prefix {
+		t.Fatalf("Expected opaque image to be recompressed as jpeg, got %.40s", url)
+	}
+	if config, _ := decodedConfig(t, url[len(prefix):]); config.Width != 100 || config.Height != 50 {
+		t.Errorf("Expected 100x50 image, got %dx%d", config.Width, config.Height)
+	}
+	if got := gjson.GetBytes(scaled, "messages.0.content.2.image_url.url").String(); got != "https://example.com/a.png" {
+		t.Errorf("Expected remote image url to be kept, got %s", got)
+	}
+
+	transparent := testPNG(t, 300, 300, 100)
+	anthropic := []byte(`{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + transparent + `"}}]}]}`)
+	scaled, count, err = DownscaleImages("anthropic", anthropic, ImageLimits{MaxDimension: 64})
+	if err != nil || count != 1 {
+		t.Fatalf("Expected 1 image downscaled, got %d %v", count, err)
+	}
+	if config, format := decodedConfig(t, gjson.GetBytes(scaled, "messages.0.content.0.source.data").String()); format != "png" || config.Width != 64 {
+		t.Errorf("Expected transparent image kept as 64px png, got %s %dpx", format, config.Width)
+	}
+	if got := gjson.GetBytes(scaled, "messages.0.content.0.source.media_type").String(); got != "image/png" {
+		t.Errorf("Expected media_type image/png, got %s", got)
+	}
+
+	limit := base64.StdEncoding.DecodedLen(len(opaque)) / 2
+	gemini := []byte(`{"contents":[{"role":"user","parts":[{"inlineData":{"mimeType":"image/png","data":"` + opaque + `"}}]}]}`)
+	scaled, count, err = DownscaleImages("gemini", gemini, ImageLimits{MaxBytes: limit})
+	if err != nil || count != 1 {
+		t.Fatalf("Expected 1 image recompressed, got %d %v", count, err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(gjson.GetBytes(scaled, "contents.0.parts.0.inlineData.data").String())
+	if len(data) > limit || gjson.GetBytes(scaled, "contents.0.parts.0.inlineData.mimeType").String() != "image/jpeg" {
+		t.Errorf("Expected jpeg within %d bytes, got %d bytes", limit, len(data))
+	}
+
+	if _, count, _ := DownscaleImages("openai", body, ImageLimits{MaxDimension: 1000}); count != 0 {
+		t.Errorf("Expected images within limits to be kept, got %d changed", count)
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIncidentOpen   = errors.New("provider already has an open incident")
+	ErrNoOpenIncident = errors.New("provider has no open incident")
+)
+
+// OpenIncident 确认供应商故障，关闭前不再对其关联应用自动衰减
+func OpenIncident(ctx context.Context, providerID uint, title, note string) (*models.ProviderIncident, error) {
+	if _, err := gorm.G[models.Provider](models.DB).Where("id = ?", providerID).First(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := openIncident(ctx, providerID); err == nil {
+		return nil, ErrIncidentOpen
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	incident := models.ProviderIncident{ProviderID: providerID, Title: title, Note: note}
+	if err := gorm.G[models.ProviderIncident](models.DB).Create(ctx, &incident); err != nil {
+		return nil, err
+	}
+	slog.Warn("provider incident opened, decay frozen", "provider_id", providerID, "title", title)
+	return &incident, nil
+}
+
+// CloseIncident 关闭供应商当前的故障，恢复自动衰减
+func CloseIncident(ctx context.Context, providerID uint) (*models.ProviderIncident, error) {
+	incident, err := openIncident(ctx, providerID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoOpenIncident
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if _, err := gorm.G[models.ProviderIncident](models.DB).Where("id = ?", incident.ID).Update(ctx, "closed_at", now); err != nil {
+		return nil, err
+	}
+	incident.ClosedAt = &now
+	slog.Info("provider incident closed, decay resumed", "provider_id", providerID, "title", incident.Title, "duration", now.Sub(incident.CreatedAt))
+	return &incident, nil
+}
+
+// ListIncidents 按时间倒序列出故障记录，openOnly 时只返回未关闭的
+func ListIncidents(ctx context.Context, providerID uint, openOnly bool) ([]models.ProviderIncident, error) {
+	query := gorm.G[models.ProviderIncident](models.DB).Order("id DESC")
+	if providerID != 0 {
+		query = query.Where("provider_id = ?", providerID)
+	}
+	if openOnly {
+		query = query.Where("closed_at IS NULL")
+	}
+	return query.Find(ctx)
+}
+
+func openIncident(ctx context.Context, providerID uint) (models.ProviderIncident, error) {
+	return gorm.G[models.ProviderIncident](models.DB).Where("provider_id = ? AND closed_at IS NULL", providerID).First(ctx)
+}
+
+// decayFrozen 供应商存在未关闭的故障时冻结自动衰减
+func decayFrozen(ctx context.Context, providerID uint) bool {
+	count, err := gorm.G[models.ProviderIncident](models.DB).Where("provider_id = ? AND closed_at IS NULL", providerID).Count(ctx, "id")
+	return err == nil && count > 0
+}
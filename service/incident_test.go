@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestIncidentFreezesDecay(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyAutoWeightDecay).Update(ctx, "value", "true"); err != nil {
+		t.Fatal(err)
+	}
+	provider := testutil.SeedProvider(t, "primary", consts.StyleOpenAI, "http://upstream")
+	association := testutil.SeedAssociation(t, testutil.SeedModel(t, "test-model"), provider, "upstream-model", 100, 10)
+	weight := func() int {
+		mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", association.ID).First(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mp.Weight
+	}
+
+	if _, err := OpenIncident(ctx, provider.ID, "upstream outage", ""); err != nil {
+		t.Fatalf("OpenIncident: %v", err)
+	}
+	if _, err := OpenIncident(ctx, provider.ID, "again", ""); !errors.Is(err, ErrIncidentOpen) {
+		t.Fatalf("second OpenIncident error = %v, want ErrIncidentOpen", err)
+	}
+	applyWeightDecayByModelProviderID(ctx, association.ID, provider.Name, "upstream-model")
+	if got := weight(); got != 10 {
+		t.Fatalf("weight during incident = %d, want 10", got)
+	}
+
+	if _, err := CloseIncident(ctx, provider.ID); err != nil {
+		t.Fatalf("CloseIncident: %v", err)
+	}
+	if _, err := CloseIncident(ctx, provider.ID); !errors.Is(err, ErrNoOpenIncident) {
+		t.Fatalf("second CloseIncident error = %v, want ErrNoOpenIncident", err)
+	}
+	applyWeightDecayByModelProviderID(ctx, association.ID, provider.Name, "upstream-model")
+	if got := weight(); got != 9 {
+		t.Fatalf("weight after incident = %d, want 9", got)
+	}
+	if incidents, err := ListIncidents(ctx, provider.ID, false); err != nil || len(incidents) != 1 || incidents[0].ClosedAt == nil {
+		t.Fatalf("incidents = %+v, err = %v", incidents, err)
+	}
+}
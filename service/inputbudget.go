@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// InputBudgetError 请求的输入 token 超过模型配置的上限，转发前直接拒绝
+type InputBudgetError struct {
+	Tokens int
+	Limit  int
+	Source string // provider 表示由上游 count_tokens 统计，estimate 表示本地估算
+}
+
+func (e *InputBudgetError) Error() string {
+	return fmt.Sprintf("prompt is too long: %d tokens > %d maximum", e.Tokens, e.Limit)
+}
+
+// CheckInputBudget 模型配置了 MaxInputTokens 时统计请求的输入 token，超出时返回错误；
+// 开启 PreflightCountTokens 的 Anthropic 格式请求优先使用上游 count_tokens，统计失败时放行
+func CheckInputBudget(ctx context.Context, style string, before *Before, header http.Header) *InputBudgetError {
+	if style != consts.StyleOpenAI && style != consts.StyleAnthropic && style != consts.StyleOpenAIRes {
+		return nil
+	}
+	model, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx)
+	if err != nil || model.MaxInputTokens <= 0 {
+		return nil
+	}
+
+	var tokens int
+	source := TokenSourceEstimate
+	if style == consts.StyleAnthropic && model.PreflightCountTokens != nil && *model.PreflightCountTokens {
+		count, countSource, err := CountTokens(ctx, before.raw, header)
+		if err != nil {
+			slog.Warn("preflight count tokens error", "model", before.Model, "error", err)
+			return nil
+		}
+		tokens, source = int(count), countSource
+	} else {
+		if tokens, err = EstimatePromptTokens(before.Model, before.raw); err != nil {
+			slog.Warn("estimate prompt tokens error", "model", before.Model, "error", err)
+			return nil
+		}
+	}
+	if tokens <= model.MaxInputTokens {
+		return nil
+	}
+	slog.Warn("request rejected by input token budget", "model", before.Model, "tokens", tokens, "limit", model.MaxInputTokens, "source", source)
+	return &InputBudgetError{Tokens: tokens, Limit: model.MaxInputTokens, Source: source}
+}
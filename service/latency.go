@@ -0,0 +1,142 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	LatencySourceLogs    = "logs"
+	LatencySourceRollups = "rollups"
+)
+
+// LatencyQuery 时延分布查询条件，Model 与 Provider 为空表示不过滤
+type LatencyQuery struct {
+	Since    time.Time
+	Source   string // logs 从请求日志精确计算；rollups 从小时级汇总近似合并，可查询日志已清理的范围
+	Model    string
+	Provider string
+}
+
+// LatencyMetric 模型-供应商在窗口内成功请求的首字时延与总耗时分布（毫秒）
+type LatencyMetric struct {
+	Model         string  `json:"model"`
+	ProviderName  string  `json:"provider_name"`
+	Samples       int64   `json:"samples"`
+	FirstChunkAvg float64 `json:"first_chunk_avg"`
+	FirstChunkP50 float64 `json:"first_chunk_p50"`
+	FirstChunkP90 float64 `json:"first_chunk_p90"`
+	FirstChunkP99 float64 `json:"first_chunk_p99"`
+	TotalAvg      float64 `json:"total_avg"`
+	TotalP50      float64 `json:"total_p50"`
+	TotalP90      float64 `json:"total_p90"`
+	TotalP99      float64 `json:"total_p99"`
+}
+
+// GetLatencyMetrics 按模型、供应商统计时延分布，同一模型内按首字时延 p50 升序
+func GetLatencyMetrics(ctx context.Context, query LatencyQuery) ([]LatencyMetric, error) {
+	var (
+		metrics []LatencyMetric
+		err     error
+	)
+	if query.Source == LatencySourceRollups {
+		metrics, err = latencyFromRollups(ctx, query)
+	} else {
+		metrics, err = latencyFromLogs(ctx, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(metrics, func(a, b LatencyMetric) int {
+		return cmp.Or(cmp.Compare(a.Model, b.Model), cmp.Compare(a.FirstChunkP50, b.FirstChunkP50), cmp.Compare(a.ProviderName, b.ProviderName))
+	})
+	return metrics, nil
+}
+
+func latencyFromLogs(ctx context.Context, query LatencyQuery) ([]LatencyMetric, error) {
+	var rows []struct {
+		Name           string
+		ProviderName   string
+		FirstChunkTime time.Duration
+		ChunkTime      time.Duration
+	}
+	chain := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("name, provider_name, first_chunk_time, chunk_time").
+		Where("created_at >= ? AND status = ? AND first_chunk_time > 0", query.Since, "success")
+	if query.Model != "" {
+		chain = chain.Where("name = ?", query.Model)
+	}
+	if query.Provider != "" {
+		chain = chain.Where("provider_name = ?", query.Provider)
+	}
+	if err := chain.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	type samples struct{ firstChunks, totals []time.Duration }
+	groups := make(map[[2]string]*samples)
+	for _, row := range rows {
+		key := [2]string{row.Name, row.ProviderName}
+		g, ok := groups[key]
+		if !ok {
+			g = &samples{}
+			groups[key] = g
+		}
+		g.firstChunks = append(g.firstChunks, row.FirstChunkTime)
+		g.totals = append(g.totals, row.FirstChunkTime+row.ChunkTime)
+	}
+	metrics := make([]LatencyMetric, 0, len(groups))
+	for key, g := range groups {
+		metric := LatencyMetric{Model: key[0], ProviderName: key[1], Samples: int64(len(g.firstChunks))}
+		metric.FirstChunkAvg, metric.FirstChunkP50, metric.FirstChunkP90, metric.FirstChunkP99 = latencySummary(g.firstChunks)
+		metric.TotalAvg, metric.TotalP50, metric.TotalP90, metric.TotalP99 = latencySummary(g.totals)
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func latencyFromRollups(ctx context.Context, query LatencyQuery) ([]LatencyMetric, error) {
+	chain := gorm.G[models.MetricsRollup](models.DB).Where("hour >= ? AND latency_samples > 0", query.Since.Truncate(time.Hour))
+	if query.Model != "" {
+		chain = chain.Where("model = ?", query.Model)
+	}
+	if query.Provider != "" {
+		chain = chain.Where("provider_name = ?", query.Provider)
+	}
+	rows, err := chain.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[[2]string]*models.RollupStats)
+	for _, row := range rows {
+		key := [2]string{row.Model, row.ProviderName}
+		stats, ok := groups[key]
+		if !ok {
+			stats = &models.RollupStats{}
+			groups[key] = stats
+		}
+		mergeRollupStats(stats, row.RollupStats)
+	}
+	metrics := make([]LatencyMetric, 0, len(groups))
+	for key, stats := range groups {
+		metrics = append(metrics, LatencyMetric{
+			Model:         key[0],
+			ProviderName:  key[1],
+			Samples:       stats.LatencySamples,
+			FirstChunkAvg: stats.FirstChunkAvg,
+			FirstChunkP50: stats.FirstChunkP50,
+			FirstChunkP90: stats.FirstChunkP90,
+			FirstChunkP99: stats.FirstChunkP99,
+			TotalAvg:      stats.TotalAvg,
+			TotalP50:      stats.TotalP50,
+			TotalP90:      stats.TotalP90,
+			TotalP99:      stats.TotalP99,
+		})
+	}
+	return metrics, nil
+}
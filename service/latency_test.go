@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestGetLatencyMetrics(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	var logs []models.ChatLog
+	for i := range 10 {
+		logs = append(logs, rollupTestLog(now.Add(-time.Minute), "fast", "success", 1, time.Duration(i+1)*10*time.Millisecond))
+		logs = append(logs, rollupTestLog(now.Add(-time.Minute), "slow", "success", 1, time.Duration(i+1)*100*time.Millisecond))
+	}
+	// 失败请求与窗口外的请求不计入
+	logs = append(logs,
+		rollupTestLog(now.Add(-time.Minute), "fast", "error", 1, 5*time.Second),
+		rollupTestLog(now.Add(-72*time.Hour), "fast", "success", 1, 9*time.Second),
+	)
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := GetLatencyMetrics(ctx, LatencyQuery{Since: now.Add(-24 * time.Hour), Source: LatencySourceLogs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 2 || metrics[0].ProviderName != "fast" || metrics[1].ProviderName != "slow" {
+		t.Fatalf("metrics = %+v, want fast before slow", metrics)
+	}
+	fast := metrics[0]
+	if fast.Samples != 10 || fast.FirstChunkP50 != 50 || fast.FirstChunkP90 != 90 || fast.FirstChunkP99 != 90 || fast.TotalP50 != 1050 || fast.FirstChunkAvg != 55 {
+		t.Errorf("fast = %+v", fast)
+	}
+
+	if err := RollupMetrics(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err = GetLatencyMetrics(ctx, LatencyQuery{Since: now.Add(-24 * time.Hour), Source: LatencySourceRollups, Provider: "slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Samples != 10 || metrics[0].FirstChunkP50 != 500 || metrics[0].FirstChunkP90 != 900 {
+		t.Errorf("rollup metrics = %+v", metrics)
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// leaderLeaseName 所有单例后台任务共用一个租约，由同一个实例执行
+	leaderLeaseName = "background-jobs"
+	leaderLeaseTTL  = 15 * time.Second
+	leaderRenewal   = 5 * time.Second
+)
+
+// LeaderElector 基于数据库租约的主节点选举，多个实例共用数据库时保证健康检测等单例后台任务只在一个实例上运行
+type LeaderElector struct {
+	id  string
+	ttl time.Duration
+
+	mu        sync.Mutex
+	leader    bool
+	expiresAt time.Time     // 本实例持有的租约到期时间
+	changed   chan struct{} // 角色变化时关闭并替换
+}
+
+// LeaderStatus 主节点选举状态
+type LeaderStatus struct {
+	InstanceID string     `json:"instance_id"`
+	Leader     bool       `json:"leader"`
+	Holder     string     `json:"holder"` // 当前持有租约的实例，租约过期时为空
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+var (
+	leaderElector     *LeaderElector
+	leaderElectorOnce sync.Once
+)
+
+// GetLeaderElector 获取主节点选举单例
+func GetLeaderElector() *LeaderElector {
+	leaderElectorOnce.Do(func() {
+		leaderElector = newLeaderElector(instanceID(), leaderLeaseTTL)
+	})
+	return leaderElector
+}
+
+func newLeaderElector(id string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{id: id, ttl: ttl, changed: make(chan struct{})}
+}
+
+// instanceID 以主机名、进程号与随机后缀标识实例
+func instanceID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Start 立即竞选一次，之后定期续约或尝试接管，ctx 取消时释放租约
+func (e *LeaderElector) Start(ctx context.Context) {
+	e.tryAcquire(ctx)
+	ticker := time.NewTicker(leaderRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.release(context.Background())
+			return
+		case <-ticker.C:
+			e.tryAcquire(ctx)
+		}
+	}
+}
+
+// tryAcquire 续约或接管过期的租约；数据库出错时保持当前角色直到租约到期
+func (e *LeaderElector) tryAcquire(ctx context.Context) {
+	now := time.Now()
+	expiresAt := now.Add(e.ttl)
+	acquired, err := e.acquire(ctx, now, expiresAt)
+	if err != nil {
+		slog.Error("leader election error", "instance", e.id, "error", err)
+		e.mu.Lock()
+		expired := now.After(e.expiresAt)
+		e.mu.Unlock()
+		if expired {
+			e.setLeader(false, time.Time{})
+		}
+		return
+	}
+	if !acquired {
+		expiresAt = time.Time{}
+	}
+	e.setLeader(acquired, expiresAt)
+}
+
+func (e *LeaderElector) acquire(ctx context.Context, now, expiresAt time.Time) (bool, error) {
+	lease := models.LeaderLease{Name: leaderLeaseName, Holder: e.id, ExpiresAt: expiresAt}
+	created := models.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&lease)
+	if created.Error != nil {
+		return false, created.Error
+	}
+	if created.RowsAffected == 1 {
+		return true, nil
+	}
+	updated := models.DB.WithContext(ctx).Model(&models.LeaderLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", leaderLeaseName, e.id, now).
+		Updates(map[string]any{"holder": e.id, "expires_at": expiresAt})
+	return updated.RowsAffected == 1, updated.Error
+}
+
+// release 主动让出租约，其他实例无需等待过期即可接管
+func (e *LeaderElector) release(ctx context.Context) {
+	if !e.IsLeader() {
+		return
+	}
+	e.setLeader(false, time.Time{})
+	if err := models.DB.WithContext(ctx).Model(&models.LeaderLease{}).
+		Where("name = ? AND holder = ?", leaderLeaseName, e.id).
+		Update("expires_at", time.Unix(0, 0)).Error; err != nil {
+		slog.Error("failed to release leader lease", "instance", e.id, "error", err)
+	}
+}
+
+func (e *LeaderElector) setLeader(leader bool, expiresAt time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expiresAt = expiresAt
+	if e.leader == leader {
+		return
+	}
+	e.leader = leader
+	close(e.changed)
+	e.changed = make(chan struct{})
+	slog.Info("leader election role changed", "instance", e.id, "leader", leader)
+}
+
+// IsLeader 本实例当前是否持有租约
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+func (e *LeaderElector) watch() (bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.changed
+}
+
+// Status 返回本实例角色与当前租约持有者
+func (e *LeaderElector) Status(ctx context.Context) (LeaderStatus, error) {
+	status := LeaderStatus{InstanceID: e.id, Leader: e.IsLeader()}
+	lease, err := gorm.G[models.LeaderLease](models.DB).Where("name = ?", leaderLeaseName).Find(ctx)
+	if err != nil {
+		return status, err
+	}
+	if len(lease) == 1 && lease[0].ExpiresAt.After(time.Now()) {
+		status.Holder = lease[0].Holder
+		status.ExpiresAt = &lease[0].ExpiresAt
+	}
+	return status, nil
+}
+
+// RunAsLeader 本实例成为主节点时执行 job，失去主节点身份时取消 job 的 ctx 并等待其退出，重新当选后再次执行；
+// job 自行返回后不再执行，ctx 取消时退出
+func (e *LeaderElector) RunAsLeader(ctx context.Context, name string, job func(ctx context.Context)) {
+	for {
+		leader, changed := e.watch()
+		if !leader {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		slog.Info("starting leader job", "job", name, "instance", e.id)
+		jobCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			job(jobCtx)
+		}()
+		select {
+		case <-ctx.Done():
+			cancel()
+			<-done
+			return
+		case <-done:
+			cancel()
+			return
+		case <-changed:
+			slog.Info("stopping leader job", "job", name, "instance", e.id)
+			cancel()
+			<-done
+		}
+	}
+}
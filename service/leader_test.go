@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestLeaderElection(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	a := newLeaderElector("a", time.Minute)
+	b := newLeaderElector("b", time.Minute)
+
+	a.tryAcquire(ctx)
+	b.tryAcquire(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to lead, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+	// 续约不改变角色
+	a.tryAcquire(ctx)
+	if !a.IsLeader() {
+		t.Fatal("expected a to keep the lease")
+	}
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	go a.RunAsLeader(ctx, "test", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+	})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("leader job did not start")
+	}
+
+	a.release(ctx)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("leader job was not cancelled after losing the lease")
+	}
+
+	b.tryAcquire(ctx)
+	if !b.IsLeader() {
+		t.Fatal("expected b to take over the released lease")
+	}
+	status, err := a.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.Leader || status.Holder != "b" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
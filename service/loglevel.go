@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// maxRawCaptureBytes raw 级别下单次请求最多保存的上游原始响应字节数，超出部分截断
+const maxRawCaptureBytes = 4 << 20
+
+var logLevelRank = map[string]int{
+	models.LogLevelNone:     0,
+	models.LogLevelMetadata: 1,
+	models.LogLevelPrompts:  2,
+	models.LogLevelFull:     3,
+	models.LogLevelRaw:      4,
+}
+
+// ValidLogLevel 是否为合法的日志详细级别，空字符串表示继承
+func ValidLogLevel(level string) bool {
+	if level == "" {
+		return true
+	}
+	_, ok := logLevelRank[level]
+	return ok
+}
+
+// LogLevelAtLeast 日志级别是否不低于 min
+func LogLevelAtLeast(level, min string) bool {
+	return logLevelRank[level] >= logLevelRank[min]
+}
+
+// ModelLogLevel 模型配置的日志级别，未设置时兼容旧的 IOLog 开关
+func ModelLogLevel(model models.Model) string {
+	if model.LogLevel != "" {
+		return model.LogLevel
+	}
+	if model.IOLog != nil && *model.IOLog {
+		return models.LogLevelFull
+	}
+	return models.LogLevelMetadata
+}
+
+// ResolveLogLevel 计算请求生效的日志级别，API Key 配置优先于模型配置
+func ResolveLogLevel(modelLevel string, key *models.APIKey) string {
+	if key != nil && key.LogLevel != "" {
+		return key.LogLevel
+	}
+	return modelLevel
+}
+
+// RawCapture 记录 raw 级别下发往上游的请求体与上游原始响应
+type RawCapture struct {
+	mu        sync.Mutex
+	request   []byte
+	response  bytes.Buffer
+	truncated bool
+}
+
+// NewRawCapture 日志级别为 raw 时返回捕获器，否则返回 nil
+func NewRawCapture(level string) *RawCapture {
+	if level != models.LogLevelRaw {
+		return nil
+	}
+	return &RawCapture{}
+}
+
+// Wrap 记录实际发往上游的请求体，并在读取响应体的同时复制一份，重试时覆盖上一次的内容
+func (r *RawCapture) Wrap(req *http.Request, body io.ReadCloser) io.ReadCloser {
+	var requestBody []byte
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			requestBody, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	r.mu.Lock()
+	r.request = requestBody
+	r.response.Reset()
+	r.truncated = false
+	r.mu.Unlock()
+	return &rawCaptureReader{ReadCloser: body, capture: r}
+}
+
+func (r *RawCapture) write(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if remain := maxRawCaptureBytes - r.response.Len(); remain < len(p) {
+		p = p[:max(remain, 0)]
+		r.truncated = true
+	}
+	r.response.Write(p)
+}
+
+// Values 返回捕获的请求体与响应
+func (r *RawCapture) Values() (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	response := r.response.String()
+	if r.truncated {
+		response += "\n[truncated]"
+	}
+	return string(r.request), response
+}
+
+type rawCaptureReader struct {
+	io.ReadCloser
+	capture *RawCapture
+}
+
+func (r *rawCaptureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.capture.write(p[:n])
+	}
+	return n, err
+}
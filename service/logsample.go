@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// logSampleCounters 按模型统计请求数，用于按 1/N 采样
+var logSampleCounters sync.Map
+
+// LogSample 单次请求的日志采样结果
+type LogSample struct {
+	Sampled bool // 是否完整记录日志
+	Weight  int  // 采样记录成功时代表的请求数
+
+	pending models.ChatLog // 未采样请求的日志，仅在失败时写入
+}
+
+// NewLogSample 按模型采样率决定本次请求是否完整记录，rate 不大于 1 时全部记录
+func NewLogSample(model string, rate int) *LogSample {
+	if rate <= 1 {
+		return &LogSample{Sampled: true, Weight: 1}
+	}
+	counter, _ := logSampleCounters.LoadOrStore(model, new(atomic.Uint64))
+	n := counter.(*atomic.Uint64).Add(1)
+	return &LogSample{Sampled: n%uint64(rate) == 1, Weight: rate}
+}
+
+// deferred 是否为延迟写入的未采样请求
+func (s *LogSample) deferred() bool {
+	return s != nil && !s.Sampled
+}
+
+// saveDeferred 未采样请求失败或取消时补写日志，失败请求按实际数量计入统计
+func (s *LogSample) saveDeferred(ctx context.Context, status, errMsg string) (uint, error) {
+	log := s.pending
+	log.Status, log.Error = status, errMsg
+	return SaveChatLog(ctx, log)
+}
+
+// merged 合并未采样请求的基础日志与处理结果，用于不落库的用量统计
+func (s *LogSample) merged(log models.ChatLog) models.ChatLog {
+	chatLog := s.pending
+	chatLog.FirstChunkTime = log.FirstChunkTime
+	chatLog.ChunkTime = log.ChunkTime
+	chatLog.Tps = log.Tps
+	chatLog.Usage = log.Usage
+	chatLog.ResponseHash, chatLog.ResponseSize = log.ResponseHash, log.ResponseSize
+	return chatLog
+}
+
+// updateLogStatus 更新请求日志状态，未采样的请求此时才写入日志
+func updateLogStatus(ctx context.Context, logId uint, sample *LogSample, status, errMsg string) error {
+	if logId == 0 && sample.deferred() {
+		_, err := sample.saveDeferred(ctx, status, errMsg)
+		return err
+	}
+	_, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", logId).Updates(ctx, models.ChatLog{
+		Status:        status,
+		Error:         errMsg,
+		ErrorCategory: ClassifyError(status, errMsg),
+	})
+	return err
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 下载远程图片的限制，超出大小或下载失败的图片保留原地址由上游自行处理
+const (
+	remoteImageMaxBytes = 20 << 20
+	remoteImageTimeout  = 10 * time.Second
+)
+
+var remoteImageClient = &http.Client{Timeout: remoteImageTimeout}
+
+// parseDataURL 解析 base64 编码的 data URL，返回 MIME 类型与数据
+func parseDataURL(url string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(rest, ",")
+	mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !found || !isBase64 {
+		return "", "", false
+	}
+	return mediaType, data, true
+}
+
+// imagePartURL 读取 OpenAI image_url 片段中的地址，兼容字符串与对象两种写法
+func imagePartURL(part map[string]interface{}) string {
+	if imageMap, ok := part["image_url"].(map[string]interface{}); ok {
+		return getString(imageMap, "url")
+	}
+	return getString(part, "image_url")
+}
+
+// anthropicImageBlock 将图片地址转换为 Anthropic image 内容块，data URL 使用 base64 来源，其他地址使用 url 来源
+func anthropicImageBlock(imageURL string) map[string]interface{} {
+	source := map[string]interface{}{"type": "url", "url": imageURL}
+	if mediaType, data, ok := parseDataURL(imageURL); ok {
+		source = map[string]interface{}{"type": "base64", "media_type": mediaType, "data": data}
+	}
+	return map[string]interface{}{"type": "image", "source": source}
+}
+
+// openAIImagePart 将 Anthropic image 内容块转换为 OpenAI image_url 片段，base64 来源转为 data URL
+func openAIImagePart(block map[string]interface{}) (map[string]interface{}, bool) {
+	source, ok := block["source"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	imageURL := getString(source, "url")
+	if getString(source, "type") == "base64" {
+		imageURL = fmt.Sprintf("data:%s;base64,%s", getString(source, "media_type"), getString(source, "data"))
+	}
+	if imageURL == "" {
+		return nil, false
+	}
+	return map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": imageURL}}, true
+}
+
+// withCacheControl 将原内容块上的提示缓存断点复制到转换后的内容块
+func withCacheControl(block, original map[string]interface{}) map[string]interface{} {
+	if cacheControl := original["cache_control"]; cacheControl != nil {
+		block["cache_control"] = cacheControl
+	}
+	return block
+}
+
+// anthropicContent 将 OpenAI 内容片段转换为 Anthropic 内容块，image_url 转为 image 块，其余内容块原样保留
+func anthropicContent(content interface{}) interface{} {
+	parts, ok := content.([]interface{})
+	if !ok {
+		return content
+	}
+	blocks := make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if ok && getString(partMap, "type") == "image_url" {
+			if imageURL := imagePartURL(partMap); imageURL != "" {
+				blocks = append(blocks, withCacheControl(anthropicImageBlock(imageURL), partMap))
+			}
+			continue
+		}
+		blocks = append(blocks, part)
+	}
+	return blocks
+}
+
+// openAIContent 将 Anthropic 内容块转换为 OpenAI 消息：image 块转为 image_url 片段，tool_use 已由 ToolCalls 转换而移除，
+// tool_result 块拆分为 tool 角色消息并放在前面，其中的图片留在用户消息中；
+// assistant 消息的文本片段合并为字符串，部分兼容接口的 assistant 消息只接受字符串
+func openAIContent(role string, content interface{}) (interface{}, []interface{}) {
+	blocks, ok := content.([]interface{})
+	if !ok {
+		return content, nil
+	}
+	var parts, toolMessages []interface{}
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch getString(blockMap, "type") {
+		case "image":
+			if part, ok := openAIImagePart(blockMap); ok {
+				parts = append(parts, withCacheControl(part, blockMap))
+			}
+		case "tool_use":
+		case "tool_result":
+			toolMessages = append(toolMessages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": getString(blockMap, "tool_use_id"),
+				"content":      geminiText(blockMap["content"]),
+			})
+			if results, ok := blockMap["content"].([]interface{}); ok {
+				for _, result := range results {
+					if resultMap, ok := result.(map[string]interface{}); ok && getString(resultMap, "type") == "image" {
+						if part, ok := openAIImagePart(resultMap); ok {
+							parts = append(parts, part)
+						}
+					}
+				}
+			}
+		default:
+			parts = append(parts, block)
+		}
+	}
+	if role == "assistant" {
+		if text := geminiText(parts); text != "" {
+			return text, toolMessages
+		}
+		return nil, toolMessages
+	}
+	if len(parts) == 0 {
+		return nil, toolMessages
+	}
+	return parts, toolMessages
+}
+
+// inlineRemoteImages 下载请求中的远程图片并改写为 base64 数据，返回改写的图片数；
+// 用于不能可靠读取任意图片地址的上游（Anthropic 兼容接口、Gemini），同一地址只下载一次
+func inlineRemoteImages(ctx context.Context, unified *UnifiedRequest) int {
+	type fetched struct {
+		mediaType, data string
+		ok              bool
+	}
+	cache := map[string]fetched{}
+	fetch := func(url string) (string, string, bool) {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return "", "", false
+		}
+		if result, ok := cache[url]; ok {
+			return result.mediaType, result.data, result.ok
+		}
+		mediaType, data, err := fetchRemoteImage(ctx, url)
+		if err != nil {
+			slog.Warn("fetch remote image error", "url", url, "error", err)
+		}
+		cache[url] = fetched{mediaType, data, err == nil}
+		return mediaType, data, err == nil
+	}
+
+	var inline func(content interface{}) int
+	inline = func(content interface{}) int {
+		blocks, ok := content.([]interface{})
+		if !ok {
+			return 0
+		}
+		count := 0
+		for _, block := range blocks {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch getString(blockMap, "type") {
+			case "image_url":
+				if mediaType, data, ok := fetch(imagePartURL(blockMap)); ok {
+					imageURL := map[string]interface{}{"url": "data:" + mediaType + ";base64," + data}
+					if imageMap, ok := blockMap["image_url"].(map[string]interface{}); ok && imageMap["detail"] != nil {
+						imageURL["detail"] = imageMap["detail"]
+					}
+					blockMap["image_url"] = imageURL
+					count++
+				}
+			case "image":
+				source, ok := blockMap["source"].(map[string]interface{})
+				if !ok || getString(source, "type") != "url" {
+					continue
+				}
+				if mediaType, data, ok := fetch(getString(source, "url")); ok {
+					blockMap["source"] = map[string]interface{}{"type": "base64", "media_type": mediaType, "data": data}
+					count++
+				}
+			case "tool_result":
+				count += inline(blockMap["content"])
+			}
+		}
+		return count
+	}
+
+	count := 0
+	for _, msg := range unified.Messages {
+		count += inline(msg.Content)
+	}
+	return count
+}
+
+// fetchRemoteImage 下载远程图片，返回 MIME 类型与 base64 数据，超过 remoteImageMaxBytes 或不是图片时返回错误
+func fetchRemoteImage(ctx context.Context, url string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	res, err := remoteImageClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	if res.ContentLength > remoteImageMaxBytes {
+		return "", "", fmt.Errorf("image too large: %d bytes", res.ContentLength)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, remoteImageMaxBytes+1))
+	if err != nil {
+		return "", "", err
+	}
+	if len(data) > remoteImageMaxBytes {
+		return "", "", fmt.Errorf("image exceeds %d bytes", remoteImageMaxBytes)
+	}
+	mediaType, _, _ := strings.Cut(res.Header.Get("Content-Type"), ";")
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", "", fmt.Errorf("not an image: %s", mediaType)
+	}
+	return strings.TrimSpace(mediaType), base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// 通知渠道类型
+const (
+	NotificationGeneric  = "generic"  // 原样推送 NotificationEvent JSON
+	NotificationSlack    = "slack"    // Slack Incoming Webhook
+	NotificationTelegram = "telegram" // Telegram Bot sendMessage
+	NotificationFeishu   = "feishu"   // 飞书自定义机器人
+	NotificationDingTalk = "dingtalk" // 钉钉自定义机器人
+)
+
+// 通知事件
+const (
+	NotifyEventAutoDisabled       = "provider_auto_disabled" // 关联因健康检测失败或优先级衰减被自动禁用
+	NotifyEventHealthCheckFailing = "health_check_failing"   // 健康检测连续失败次数达到阈值
+	NotifyEventErrorRateSpike     = "error_rate_spike"       // 模型近期错误率超过阈值，恢复时以 resolved 状态再次推送
+	NotifyEventQuotaExhausted     = "quota_exhausted"        // API Key 当前周期的 token 或费用配额已用尽
+	NotifyEventTest               = "test"                   // 手动测试，只发往指定的渠道
+)
+
+// NotifyEvents 可订阅的通知事件
+var NotifyEvents = []string{NotifyEventAutoDisabled, NotifyEventHealthCheckFailing, NotifyEventErrorRateSpike, NotifyEventQuotaExhausted}
+
+const (
+	errorRateWindow           = 5 * time.Minute
+	errorRateEvaluateInterval = time.Minute
+)
+
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotificationEvent 推送给通知渠道的事件，非 generic 渠道只发送由标题与内容组成的文本
+type NotificationEvent struct {
+	Event               string    `json:"event"`
+	Status              string    `json:"status,omitempty"` // error_rate_spike 的 firing 或 resolved
+	Time                time.Time `json:"time"`
+	Title               string    `json:"title"`
+	Message             string    `json:"message"`
+	Model               string    `json:"model,omitempty"`
+	Provider            string    `json:"provider,omitempty"`
+	ProviderModel       string    `json:"provider_model,omitempty"`
+	ModelWithProviderID uint      `json:"model_with_provider_id,omitempty"`
+	APIKey              string    `json:"api_key,omitempty"` // quota_exhausted 的 API Key 标签或前缀
+}
+
+func (e NotificationEvent) text() string {
+	return "[llmio] " + e.Title + "\n" + e.Message
+}
+
+// ValidateNotification 校验通知渠道：类型与事件有效、地址为 http(s)，telegram 需要 chat_id
+func ValidateNotification(n models.Notification) error {
+	switch n.Type {
+	case NotificationGeneric, NotificationSlack, NotificationTelegram, NotificationFeishu, NotificationDingTalk:
+	default:
+		return fmt.Errorf("unknown notification type %q", n.Type)
+	}
+	if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an http(s) address", n.URL)
+	}
+	if n.Type == NotificationTelegram && n.ChatID == "" {
+		return errors.New("telegram notification requires chat_id")
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(NotifyEvents, event) {
+			return fmt.Errorf("unknown notification event %q", event)
+		}
+	}
+	return nil
+}
+
+// subscribed 渠道是否订阅了事件，未指定事件时订阅全部
+func subscribed(n models.Notification, event string) bool {
+	return len(n.Events) == 0 || slices.Contains(n.Events, event)
+}
+
+// Notify 异步推送事件到所有启用且订阅了该事件的通知渠道与邮件告警，失败只记录日志
+func Notify(ctx context.Context, event NotificationEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	notifyEmail(ctx, event)
+	notifications, err := gorm.G[models.Notification](models.DB).Where("enabled = ?", true).Find(ctx)
+	if err != nil {
+		slog.Error("failed to load notifications", "error", err)
+		return
+	}
+	for _, n := range notifications {
+		if !subscribed(n, event.Event) {
+			continue
+		}
+		go func() {
+			if err := SendNotification(context.Background(), n, event); err != nil {
+				slog.Error("failed to send notification", "notification", n.Name, "event", event.Event, "error", err)
+			}
+		}()
+	}
+}
+
+// SendNotification 按渠道类型构造消息并同步推送
+func SendNotification(ctx context.Context, n models.Notification, event NotificationEvent) error {
+	body, err := json.Marshal(notificationPayload(n, event))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d: %s", res.StatusCode, data)
+	}
+	// 飞书与钉钉出错时仍返回 200，错误码在响应体中
+	switch n.Type {
+	case NotificationFeishu:
+		if code := gjson.GetBytes(data, "code").Int(); code != 0 {
+			return fmt.Errorf("feishu responded with code %d: %s", code, gjson.GetBytes(data, "msg").String())
+		}
+	case NotificationDingTalk:
+		if code := gjson.GetBytes(data, "errcode").Int(); code != 0 {
+			return fmt.Errorf("dingtalk responded with errcode %d: %s", code, gjson.GetBytes(data, "errmsg").String())
+		}
+	}
+	return nil
+}
+
+func notificationPayload(n models.Notification, event NotificationEvent) any {
+	switch n.Type {
+	case NotificationSlack:
+		return map[string]any{"text": event.text()}
+	case NotificationTelegram:
+		return map[string]any{"chat_id": n.ChatID, "text": event.text()}
+	case NotificationFeishu:
+		return map[string]any{"msg_type": "text", "content": map[string]any{"text": event.text()}}
+	case NotificationDingTalk:
+		return map[string]any{"msgtype": "text", "text": map[string]any{"content": event.text()}}
+	}
+	return event
+}
+
+// notifyAutoDisabled 关联被自动禁用时通知
+func notifyAutoDisabled(ctx context.Context, mpID uint, model, provider, providerModel, reason string) {
+	Notify(ctx, NotificationEvent{
+		Event:               NotifyEventAutoDisabled,
+		Title:               "Association auto-disabled",
+		Message:             fmt.Sprintf("%s / %s (association %d) was disabled: %s", provider, providerModel, mpID, reason),
+		Model:               model,
+		Provider:            provider,
+		ProviderModel:       providerModel,
+		ModelWithProviderID: mpID,
+	})
+}
+
+// ErrorRateMonitor 定期统计各模型最近 5 分钟的错误率，超过阈值时通知，恢复后推送 resolved
+type ErrorRateMonitor struct {
+	mu       sync.Mutex
+	alerting map[string]bool
+}
+
+var errorRateMonitor = &ErrorRateMonitor{alerting: make(map[string]bool)}
+
+// GetErrorRateMonitor 获取错误率监控单例
+func GetErrorRateMonitor() *ErrorRateMonitor {
+	return errorRateMonitor
+}
+
+// Start 启动错误率评估循环，ctx 取消时退出
+func (m *ErrorRateMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(errorRateEvaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.evaluate(ctx)
+		}
+	}
+}
+
+// modelErrorRate 窗口内模型的请求数与失败数
+type modelErrorRate struct {
+	Name   string
+	Total  int64
+	Errors int64
+}
+
+// evaluate 评估各模型错误率，仅在告警状态变化时通知
+func (m *ErrorRateMonitor) evaluate(ctx context.Context) {
+	threshold, minRequests := GetNotificationSettings(ctx)
+	if threshold <= 0 {
+		return
+	}
+	// 客户端主动取消的请求不计入，采样记录按 sample_weight 加权
+	var rates []modelErrorRate
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("name, COALESCE(SUM(sample_weight), 0) AS total, "+
+			"COALESCE(SUM(CASE WHEN status = 'error' THEN sample_weight ELSE 0 END), 0) AS errors").
+		Where("created_at >= ? AND status <> ? AND provider_name <> ''", time.Now().Add(-errorRateWindow), "cancelled").
+		Group("name").
+		Scan(&rates).Error; err != nil {
+		slog.Error("failed to evaluate error rates", "error", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool, len(rates))
+	for _, rate := range rates {
+		seen[rate.Name] = true
+		percent := float64(rate.Errors) / float64(max(rate.Total, 1)) * 100
+		alerting := rate.Total >= int64(minRequests) && percent >= threshold
+		if alerting == m.alerting[rate.Name] {
+			continue
+		}
+		m.alerting[rate.Name] = alerting
+		m.notify(ctx, rate.Name, alerting, fmt.Sprintf("%d of %d requests failed in the last %s (%.1f%%, threshold %.1f%%)",
+			rate.Errors, rate.Total, errorRateWindow, percent, threshold))
+	}
+	// 窗口内没有请求的模型视为已恢复
+	for name, alerting := range m.alerting {
+		if alerting && !seen[name] {
+			m.alerting[name] = false
+			m.notify(ctx, name, false, fmt.Sprintf("no requests in the last %s", errorRateWindow))
+		}
+	}
+}
+
+func (m *ErrorRateMonitor) notify(ctx context.Context, model string, alerting bool, message string) {
+	event := NotificationEvent{Event: NotifyEventErrorRateSpike, Status: "resolved", Title: "Error rate recovered: " + model, Message: message, Model: model}
+	if alerting {
+		event.Status, event.Title = "firing", "Error rate spike: "+model
+	}
+	slog.Warn("model error rate alert", "model", model, "status", event.Status, "message", message)
+	Notify(ctx, event)
+}
+
+// GetNotificationSettings 获取错误率通知阈值（百分比）与最少请求数
+func GetNotificationSettings(ctx context.Context) (errorRateThreshold float64, minRequests int) {
+	errorRateThreshold, minRequests = 50, 20
+	settings, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyNotifyErrorRateThreshold, models.SettingKeyNotifyErrorRateMinRequests)).
+		Find(ctx)
+	if err != nil {
+		return errorRateThreshold, minRequests
+	}
+	for _, setting := range settings {
+		switch setting.Key {
+		case models.SettingKeyNotifyErrorRateThreshold:
+			if value, err := strconv.ParseFloat(setting.Value, 64); err == nil && value >= 0 {
+				errorRateThreshold = value
+			}
+		case models.SettingKeyNotifyErrorRateMinRequests:
+			if value, err := strconv.Atoi(setting.Value); err == nil && value >= 0 {
+				minRequests = value
+			}
+		}
+	}
+	return errorRateThreshold, minRequests
+}
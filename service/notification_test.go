@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestNotificationPayload(t *testing.T) {
+	event := NotificationEvent{Event: NotifyEventAutoDisabled, Title: "Association auto-disabled", Message: "openai / gpt-4o was disabled"}
+	text := "[llmio] Association auto-disabled\nopenai / gpt-4o was disabled"
+	tests := []struct {
+		notification models.Notification
+		path         string
+	}{
+		{models.Notification{Type: NotificationSlack}, "text"},
+		{models.Notification{Type: NotificationTelegram, ChatID: "42"}, "text"},
+		{models.Notification{Type: NotificationFeishu}, "content.text"},
+		{models.Notification{Type: NotificationDingTalk}, "text.content"},
+	}
+	for _, tt := range tests {
+		body, err := json.Marshal(notificationPayload(tt.notification, event))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		var value any = payload
+		for _, key := range strings.Split(tt.path, ".") {
+			value = value.(map[string]any)[key]
+		}
+		if value != text {
+			t.Errorf("%s: %s = %v, want %q", tt.notification.Type, tt.path, value, text)
+		}
+	}
+	if body, _ := json.Marshal(notificationPayload(models.Notification{Type: NotificationGeneric}, event)); !strings.Contains(string(body), `"event":"provider_auto_disabled"`) {
+		t.Errorf("generic payload = %s, want the event JSON", body)
+	}
+}
+
+func TestErrorRateNotification(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	received := make(chan NotificationEvent, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event NotificationEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("invalid notification body %s: %v", body, err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	notifications := []models.Notification{
+		{Name: "errors", Type: NotificationGeneric, URL: server.URL, Events: []string{NotifyEventErrorRateSpike}, Enabled: true},
+		{Name: "disabled", Type: NotificationGeneric, URL: server.URL, Enabled: false},
+		{Name: "health", Type: NotificationGeneric, URL: server.URL, Events: []string{NotifyEventHealthCheckFailing}, Enabled: true},
+	}
+	if err := models.DB.Create(&notifications).Error; err != nil {
+		t.Fatal(err)
+	}
+	var logs []models.ChatLog
+	for i := range 20 {
+		status := "success"
+		if i < 12 {
+			status = "error"
+		}
+		logs = append(logs, models.ChatLog{Name: "gpt-4o", ProviderName: "openai", Status: status, SampleWeight: 1})
+	}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	monitor := &ErrorRateMonitor{alerting: make(map[string]bool)}
+	monitor.evaluate(ctx)
+	select {
+	case event := <-received:
+		if event.Event != NotifyEventErrorRateSpike || event.Status != "firing" || event.Model != "gpt-4o" {
+			t.Errorf("event = %+v, want firing error rate spike for gpt-4o", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for error rate notification")
+	}
+
+	// 状态未变化时不重复通知，请求数不足视为恢复
+	monitor.evaluate(ctx)
+	if err := models.DB.Where("status = ?", "success").Delete(&models.ChatLog{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	monitor.evaluate(ctx)
+	select {
+	case event := <-received:
+		if event.Status != "resolved" {
+			t.Errorf("event = %+v, want resolved", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for resolved notification")
+	}
+	select {
+	case event := <-received:
+		t.Errorf("unexpected notification %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
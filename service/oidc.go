@@ -0,0 +1,625 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCSessionCookie 登录成功后保存会话的 cookie 名称
+const OIDCSessionCookie = "llmio_session"
+
+// OIDCStateCookie 登录跳转期间保存 state、nonce 与 PKCE verifier 的 cookie 名称
+const OIDCStateCookie = "llmio_oidc_state"
+
+const (
+	oidcStateTTL          = 10 * time.Minute
+	oidcDefaultSessionTTL = time.Hour
+	oidcClockSkew         = time.Minute
+	oidcKeysRefreshDelay  = time.Minute
+)
+
+// OIDC 登录与令牌校验错误
+var (
+	ErrOIDCInvalidToken = errors.New("invalid oidc token")
+	ErrOIDCForbidden    = errors.New("user is not allowed to access llmio")
+	ErrOIDCInvalidState = errors.New("invalid or expired login state")
+)
+
+// OIDCConfig 通过环境变量配置的 OpenID Connect 登录
+type OIDCConfig struct {
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string        // 回调地址，如 https://llmio.example.com/auth/oidc/callback
+	Scopes         []string      // 默认 openid profile email
+	Audiences      []string      // Bearer JWT 允许的 aud，默认只接受 ClientID
+	AllowedEmails  []string      // 允许登录的邮箱，@example.com 表示整个域名，为空表示 IdP 的所有用户
+	ReadOnlyEmails []string      // 只能只读访问的邮箱，规则同 AllowedEmails
+	SessionTTL     time.Duration // 会话 cookie 有效期
+	SessionSecret  []byte        // 会话签名密钥
+}
+
+// OIDCConfigFromEnv 读取 OIDC_* 环境变量，未设置 OIDC_ISSUER 时返回的 Issuer 为空
+func OIDCConfigFromEnv() (OIDCConfig, error) {
+	config := OIDCConfig{
+		Issuer:         strings.TrimRight(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:       os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:   os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:         strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " ")),
+		Audiences:      splitList(os.Getenv("OIDC_AUDIENCES")),
+		AllowedEmails:  splitList(os.Getenv("OIDC_ALLOWED_EMAILS")),
+		ReadOnlyEmails: splitList(os.Getenv("OIDC_READONLY_EMAILS")),
+		SessionTTL:     oidcDefaultSessionTTL,
+	}
+	if config.Issuer == "" {
+		return config, nil
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return config, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+	}
+	if ttl := os.Getenv("OIDC_SESSION_TTL"); ttl != "" {
+		value, err := time.ParseDuration(ttl)
+		if err != nil || value <= 0 {
+			return config, fmt.Errorf("invalid OIDC_SESSION_TTL %q", ttl)
+		}
+		config.SessionTTL = value
+	}
+	// 未设置签名密钥时由 client secret 派生，多实例间共享；公共客户端则每次启动随机生成
+	switch secret := os.Getenv("OIDC_SESSION_SECRET"); {
+	case secret != "":
+		config.SessionSecret = []byte(secret)
+	case config.ClientSecret != "":
+		sum := sha256.Sum256([]byte("llmio-oidc-session:" + config.ClientSecret))
+		config.SessionSecret = sum[:]
+	default:
+		config.SessionSecret = make([]byte, 32)
+		rand.Read(config.SessionSecret)
+		slog.Warn("OIDC_SESSION_SECRET is not set, sessions will not survive restarts or be shared between instances")
+	}
+	return config, nil
+}
+
+// OIDCIdentity ID Token 或 Bearer JWT 中的用户信息
+type OIDCIdentity struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"` // IdP 是否已验证该邮箱，未验证的邮箱不能命中 OIDC_ALLOWED_EMAILS
+	Name          string `json:"name,omitempty"`
+}
+
+// OIDCSession 会话 cookie 中保存的登录信息
+type OIDCSession struct {
+	OIDCIdentity
+	ReadOnly  bool  `json:"ro,omitempty"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// oidcLoginState 登录跳转期间保存在 cookie 中的校验信息
+type oidcLoginState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	Redirect  string `json:"redirect"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// OIDCProvider 通过发现文档得到的 IdP 端点与签名公钥
+type OIDCProvider struct {
+	config        OIDCConfig
+	issuer        string
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+	client        *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+var oidcProvider *OIDCProvider
+
+// InitOIDC 设置了 OIDC_ISSUER 时读取发现文档并启用 OIDC 登录
+func InitOIDC(ctx context.Context) error {
+	config, err := OIDCConfigFromEnv()
+	if err != nil || config.Issuer == "" {
+		return err
+	}
+	provider, err := NewOIDCProvider(ctx, config)
+	if err != nil {
+		return err
+	}
+	oidcProvider = provider
+	slog.Info("oidc login enabled", "issuer", provider.issuer)
+	return nil
+}
+
+// GetOIDCProvider 获取已启用的 OIDC 登录，未启用时返回 nil
+func GetOIDCProvider() *OIDCProvider {
+	return oidcProvider
+}
+
+// NewOIDCProvider 读取 Issuer 的发现文档
+func NewOIDCProvider(ctx context.Context, config OIDCConfig) (*OIDCProvider, error) {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	} else if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if len(config.Audiences) == 0 {
+		config.Audiences = []string{config.ClientID}
+	}
+	if config.SessionTTL <= 0 {
+		config.SessionTTL = oidcDefaultSessionTTL
+	}
+	if len(config.SessionSecret) == 0 {
+		config.SessionSecret = []byte(randomToken())
+	}
+	p := &OIDCProvider{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, strings.TrimRight(config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to load oidc discovery document: %w", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != strings.TrimRight(config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery issuer %q does not match %q", discovery.Issuer, config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is missing required endpoints")
+	}
+	p.issuer = discovery.Issuer
+	p.authEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.jwksURI = discovery.JWKSURI
+	return p, nil
+}
+
+// SecureCookies 回调地址为 https 时 cookie 只通过 https 发送
+func (p *OIDCProvider) SecureCookies() bool {
+	return strings.HasPrefix(p.config.RedirectURL, "https://")
+}
+
+// SessionTTL 会话 cookie 有效期
+func (p *OIDCProvider) SessionTTL() time.Duration {
+	return p.config.SessionTTL
+}
+
+// BeginLogin 生成授权跳转地址与保存在 state cookie 中的校验信息，redirect 为登录后返回的页面
+func (p *OIDCProvider) BeginLogin(redirect string) (authURL, stateCookie string) {
+	state := oidcLoginState{
+		State:     randomToken(),
+		Nonce:     randomToken(),
+		Verifier:  randomToken(),
+		Redirect:  redirect,
+		ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authEndpoint + separator + query.Encode(), p.sign("state", state)
+}
+
+// FinishLogin 校验回调的 state，用授权码换取 ID Token 并签发会话，返回会话 cookie 与登录后跳转的页面
+func (p *OIDCProvider) FinishLogin(ctx context.Context, stateCookie, state, code string) (session OIDCSession, sessionCookie, redirect string, err error) {
+	var login oidcLoginState
+	if err := p.verify("state", stateCookie, &login); err != nil || time.Now().Unix() > login.ExpiresAt ||
+		subtle.ConstantTimeCompare([]byte(login.State), []byte(state)) != 1 {
+		return session, "", "", ErrOIDCInvalidState
+	}
+	idToken, err := p.exchange(ctx, code, login.Verifier)
+	if err != nil {
+		return session, "", "", err
+	}
+	identity, err := p.verifyJWT(ctx, idToken, []string{p.config.ClientID}, login.Nonce)
+	if err != nil {
+		return session, "", "", err
+	}
+	readOnly, err := p.Authorize(identity)
+	if err != nil {
+		return session, "", "", err
+	}
+	session = OIDCSession{OIDCIdentity: identity, ReadOnly: readOnly, ExpiresAt: time.Now().Add(p.config.SessionTTL).Unix()}
+	return session, p.sign("session", session), login.Redirect, nil
+}
+
+// ParseSession 校验会话 cookie
+func (p *OIDCProvider) ParseSession(cookie string) (OIDCSession, error) {
+	var session OIDCSession
+	if err := p.verify("session", cookie, &session); err != nil {
+		return session, err
+	}
+	if time.Now().Unix() > session.ExpiresAt {
+		return session, ErrOIDCInvalidToken
+	}
+	return session, nil
+}
+
+// VerifyBearer 校验 IdP 签发的 Bearer JWT 并检查用户是否允许访问，返回是否只读
+func (p *OIDCProvider) VerifyBearer(ctx context.Context, token string) (OIDCIdentity, bool, error) {
+	identity, err := p.verifyJWT(ctx, token, p.config.Audiences, "")
+	if err != nil {
+		return identity, false, err
+	}
+	readOnly, err := p.Authorize(identity)
+	return identity, readOnly, err
+}
+
+// Authorize 按 OIDC_ALLOWED_EMAILS 与 OIDC_READONLY_EMAILS 判断用户能否访问以及是否只读
+func (p *OIDCProvider) Authorize(identity OIDCIdentity) (readOnly bool, err error) {
+	email := strings.ToLower(identity.Email)
+	// 允许 IdP 中未验证邮箱的用户可以自行填写白名单中的地址，白名单只认已验证的邮箱
+	if len(p.config.AllowedEmails) > 0 && (!identity.EmailVerified || !matchEmail(p.config.AllowedEmails, email)) {
+		return false, ErrOIDCForbidden
+	}
+	return matchEmail(p.config.ReadOnlyEmails, email), nil
+}
+
+// matchEmail 邮箱是否命中列表，@example.com 匹配整个域名
+func matchEmail(patterns []string, email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "@") && strings.HasSuffix(email, pattern) || pattern == email {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange 用授权码与 PKCE verifier 换取 ID Token
+func (p *OIDCProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response (status %d): %w", res.StatusCode, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s", token.Error, token.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("token endpoint responded with status %d and no id_token", res.StatusCode)
+	}
+	return token.IDToken, nil
+}
+
+// audience JWT 的 aud 可以是字符串或字符串数组
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// verifyJWT 校验签名、issuer、audience、有效期与 nonce（非空时）
+func (p *OIDCProvider) verifyJWT(ctx context.Context, token string, audiences []string, nonce string) (OIDCIdentity, error) {
+	var identity OIDCIdentity
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return identity, ErrOIDCInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return identity, ErrOIDCInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return identity, ErrOIDCInvalidToken
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return identity, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return identity, fmt.Errorf("%w: %v", ErrOIDCInvalidToken, err)
+	}
+
+	var claims struct {
+		Issuer            string   `json:"iss"`
+		Subject           string   `json:"sub"`
+		Audience          audience `json:"aud"`
+		ExpiresAt         int64    `json:"exp"`
+		NotBefore         int64    `json:"nbf"`
+		Nonce             string   `json:"nonce"`
+		Email             string   `json:"email"`
+		EmailVerified     bool     `json:"email_verified"`
+		Name              string   `json:"name"`
+		PreferredUsername string   `json:"preferred_username"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return identity, ErrOIDCInvalidToken
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.issuer:
+		return identity, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCInvalidToken, claims.Issuer)
+	case !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(audiences, aud) }):
+		return identity, fmt.Errorf("%w: unexpected audience %v", ErrOIDCInvalidToken, []string(claims.Audience))
+	case claims.ExpiresAt == 0 || now.Add(-oidcClockSkew).Unix() > claims.ExpiresAt:
+		return identity, fmt.Errorf("%w: token expired", ErrOIDCInvalidToken)
+	case claims.NotBefore != 0 && now.Add(oidcClockSkew).Unix() < claims.NotBefore:
+		return identity, fmt.Errorf("%w: token not yet valid", ErrOIDCInvalidToken)
+	case nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return identity, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidToken)
+	}
+	identity = OIDCIdentity{Subject: claims.Subject, Email: claims.Email, EmailVerified: claims.EmailVerified, Name: claims.Name}
+	if identity.Name == "" {
+		identity.Name = claims.PreferredUsername
+	}
+	return identity, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		}
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(key, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+			return errors.New("ecdsa signature mismatch")
+		}
+	}
+	return fmt.Errorf("key type does not match alg %q", alg)
+}
+
+// publicKey 按 kid 查找签名公钥，未找到时重新拉取 JWKS（最多每分钟一次）
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lookup := func() crypto.PublicKey {
+		if key, ok := p.keys[kid]; ok {
+			return key
+		}
+		// 未携带 kid 且只有一个公钥时直接使用
+		if kid == "" && len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key
+			}
+		}
+		return nil
+	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeysRefreshDelay {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrOIDCInvalidToken, kid)
+	}
+	p.keysFetched = time.Now()
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to load oidc jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("skipping unsupported oidc signing key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys = keys
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrOIDCInvalidToken, kid)
+}
+
+// jwk JWKS 中的 RSA 或 EC 公钥
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(data) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// sign 生成 base64(payload).base64(hmac) 形式的签名值，purpose 区分会话与登录 state，避免互相替用
+func (p *OIDCProvider) sign(purpose string, v any) string {
+	payload, _ := json.Marshal(v)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(p.mac(purpose, encoded))
+}
+
+func (p *OIDCProvider) verify(purpose, value string, v any) error {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return ErrOIDCInvalidToken
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, p.mac(purpose, encoded)) {
+		return ErrOIDCInvalidToken
+	}
+	if err := decodeSegment(encoded, v); err != nil {
+		return ErrOIDCInvalidToken
+	}
+	return nil
+}
+
+func (p *OIDCProvider) mac(purpose, encoded string) []byte {
+	h := hmac.New(sha256.New, p.config.SessionSecret)
+	h.Write([]byte(purpose + "." + encoded))
+	return h.Sum(nil)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func randomToken() string {
+	data := make([]byte, 32)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeIdP 提供发现文档、JWKS 与令牌端点的 OIDC 服务，令牌端点返回 idToken 生成的 ID Token
+type fakeIdP struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken func(form url.Values) map[string]any
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "llmio" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		r.ParseForm()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.idToken(r.PostForm))})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *fakeIdP) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (idp *fakeIdP) claims(email string) map[string]any {
+	return map[string]any{
+		"iss":            idp.server.URL,
+		"sub":            "user-" + email,
+		"aud":            "llmio",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          email,
+		"email_verified": true,
+		"name":           "Test User",
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	provider, err := NewOIDCProvider(ctx, OIDCConfig{
+		Issuer:         idp.server.URL,
+		ClientID:       "llmio",
+		ClientSecret:   "secret",
+		RedirectURL:    "https://llmio.example.com/auth/oidc/callback",
+		AllowedEmails:  []string{"@example.com"},
+		ReadOnlyEmails: []string{"viewer@example.com"},
+		SessionSecret:  []byte("session-secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(email string) (OIDCSession, string, error) {
+		authURL, stateCookie := provider.BeginLogin("/login?sso=1")
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid profile email" {
+			t.Fatalf("unexpected authorization request %s", authURL)
+		}
+		idp.idToken = func(form url.Values) map[string]any {
+			// PKCE：verifier 的摘要必须与授权请求中的 challenge 一致
+			sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != query.Get("code_challenge") {
+				t.Error("code_verifier does not match code_challenge")
+			}
+			claims := idp.claims(email)
+			claims["nonce"] = query.Get("nonce")
+			return claims
+		}
+		session, cookie, redirect, err := provider.FinishLogin(ctx, stateCookie, query.Get("state"), "code")
+		if err == nil && redirect != "/login?sso=1" {
+			t.Errorf("redirect = %q", redirect)
+		}
+		return session, cookie, err
+	}
+
+	session, cookie, err := login("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Email != "admin@example.com" || session.ReadOnly {
+		t.Errorf("session = %+v, want admin session", session)
+	}
+	if parsed, err := provider.ParseSession(cookie); err != nil || parsed.Subject != "user-admin@example.com" {
+		t.Errorf("ParseSession = %+v, %v", parsed, err)
+	}
+	if _, err := provider.ParseSession(cookie + "x"); err == nil {
+		t.Error("tampered session cookie should be rejected")
+	}
+
+	if session, _, err := login("viewer@example.com"); err != nil || !session.ReadOnly {
+		t.Errorf("viewer session = %+v, %v, want read-only", session, err)
+	}
+	if _, _, err := login("someone@other.com"); !errors.Is(err, ErrOIDCForbidden) {
+		t.Errorf("err = %v, want ErrOIDCForbidden", err)
+	}
+
+	authURL, stateCookie := provider.BeginLogin("/")
+	u, _ := url.Parse(authURL)
+	if _, _, _, err := provider.FinishLogin(ctx, stateCookie, "forged", "code"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Errorf("err = %v, want ErrOIDCInvalidState", err)
+	}
+	// 会话 cookie 不能当作 state cookie 使用
+	if _, _, _, err := provider.FinishLogin(ctx, cookie, u.Query().Get("state"), "code"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Errorf("err = %v, want ErrOIDCInvalidState", err)
+	}
+}
+
+func TestOIDCVerifyBearer(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	provider, err := NewOIDCProvider(ctx, OIDCConfig{Issuer: idp.server.URL, ClientID: "llmio", RedirectURL: "http://localhost/cb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := idp.sign(t, idp.claims("ops@example.com"))
+	if identity, readOnly, err := provider.VerifyBearer(ctx, token); err != nil || identity.Email != "ops@example.com" || readOnly {
+		t.Errorf("VerifyBearer = %+v, %v, %v", identity, readOnly, err)
+	}
+
+	tests := map[string]func(map[string]any){
+		"wrong audience": func(c map[string]any) { c["aud"] = []string{"other"} },
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"expired":        func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"not yet valid":  func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+	}
+	for name, mutate := range tests {
+		claims := idp.claims("ops@example.com")
+		mutate(claims)
+		if _, _, err := provider.VerifyBearer(ctx, idp.sign(t, claims)); !errors.Is(err, ErrOIDCInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrOIDCInvalidToken", name, err)
+		}
+	}
+	// 命中白名单的邮箱必须已由 IdP 验证
+	allowlisted, err := NewOIDCProvider(ctx, OIDCConfig{Issuer: idp.server.URL, ClientID: "llmio", RedirectURL: "http://localhost/cb", AllowedEmails: []string{"@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := allowlisted.VerifyBearer(ctx, token); err != nil {
+		t.Errorf("verified email: err = %v", err)
+	}
+	unverified := idp.claims("ops@example.com")
+	unverified["email_verified"] = false
+	if _, _, err := allowlisted.VerifyBearer(ctx, idp.sign(t, unverified)); !errors.Is(err, ErrOIDCForbidden) {
+		t.Errorf("unverified email: err = %v, want ErrOIDCForbidden", err)
+	}
+	delete(unverified, "email_verified")
+	if _, _, err := allowlisted.VerifyBearer(ctx, idp.sign(t, unverified)); !errors.Is(err, ErrOIDCForbidden) {
+		t.Errorf("missing email_verified: err = %v, want ErrOIDCForbidden", err)
+	}
+	if _, _, err := provider.VerifyBearer(ctx, token[:len(token)-4]+"AAAA"); !errors.Is(err, ErrOIDCInvalidToken) {
+		t.Errorf("bad signature: err = %v, want ErrOIDCInvalidToken", err)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// normalizeOllamaResponse 将 Ollama OpenAI 兼容接口的非标准字段改写为标准 OpenAI 格式：
+// reasoning 改为 reasoning_content，补全工具调用的 index，调用工具后以 stop 结束时改为 tool_calls
+func normalizeOllamaResponse(res *http.Response) (*http.Response, error) {
+	if !strings.Contains(res.Header.Get("Content-Type"), "text/event-stream") {
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = normalizeOllamaChoices(body, "message", nil)
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		res.ContentLength = int64(len(body))
+		res.Header.Del("Content-Length")
+		return res, nil
+	}
+
+	body := res.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		toolCalls := make(map[int64]bool)
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				if data = bytes.TrimSpace(data); gjson.ValidBytes(data) {
+					line = append([]byte("data: "), normalizeOllamaChoices(data, "delta", toolCalls)...)
+				}
+			}
+			if _, err := fmt.Fprintf(pw, "%s\n", line); err != nil {
+				return
+			}
+		}
+		pw.CloseWithError(scanner.Err())
+	}()
+	res.Body = pr
+	return res, nil
+}
+
+// normalizeOllamaChoices 改写 choices 中 message / delta 的非标准字段，toolCalls 记录流式响应中已出现工具调用的 choice
+func normalizeOllamaChoices(data []byte, field string, toolCalls map[int64]bool) []byte {
+	for i, choice := range gjson.GetBytes(data, "choices").Array() {
+		prefix := fmt.Sprintf("choices.%d.", i)
+		message := choice.Get(field)
+		if reasoning := message.Get("reasoning"); reasoning.Exists() {
+			if !message.Get("reasoning_content").Exists() {
+				data, _ = sjson.SetBytes(data, prefix+field+".reasoning_content", reasoning.Value())
+			}
+			data, _ = sjson.DeleteBytes(data, prefix+field+".reasoning")
+		}
+
+		calls := message.Get("tool_calls").Array()
+		for j, call := range calls {
+			if !call.Get("index").Exists() {
+				data, _ = sjson.SetBytes(data, fmt.Sprintf("%s%s.tool_calls.%d.index", prefix, field, j), j)
+			}
+		}
+		index := choice.Get("index").Int()
+		hasCalls := len(calls) > 0
+		if toolCalls != nil {
+			toolCalls[index] = toolCalls[index] || hasCalls
+			hasCalls = toolCalls[index]
+		}
+		if hasCalls && choice.Get("finish_reason").String() == "stop" {
+			data, _ = sjson.SetBytes(data, prefix+"finish_reason", "tool_calls")
+		}
+	}
+	return data
+}
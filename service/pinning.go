@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// PinProviderHeader 客户端指定本次请求使用的供应商名称，用于单独调试某个上游
+const PinProviderHeader = "X-LLMIO-Provider"
+
+// PinProvider 读取请求头或模型名后缀（model@provider）指定的供应商，请求头优先；
+// 带后缀时去掉后缀作为模型名并改写请求体，模型名本身带 @ 且存在同名模型时不视为后缀
+func PinProvider(ctx context.Context, before *Before, header string) error {
+	pinned := strings.TrimSpace(header)
+	if name, provider, ok := cutProviderSuffix(before.Model); ok {
+		if _, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx); errors.Is(err, gorm.ErrRecordNotFound) {
+			raw, err := sjson.SetBytes(before.raw, "model", name)
+			if err != nil {
+				return err
+			}
+			before.Model, before.raw = name, raw
+			if pinned == "" {
+				pinned = provider
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	before.pinnedProvider = pinned
+	return nil
+}
+
+// cutProviderSuffix 按最后一个 @ 拆分模型名与供应商名
+func cutProviderSuffix(model string) (string, string, bool) {
+	i := strings.LastIndex(model, "@")
+	if i <= 0 || i == len(model)-1 {
+		return "", "", false
+	}
+	return model[:i], model[i+1:], true
+}
+
+// pinnedAssociations 只保留指定供应商的关联，供应商不存在或没有该模型的可用关联时返回错误
+func pinnedAssociations(modelWithProviders []models.ModelWithProvider, providerMap map[uint]models.Provider, before Before) ([]models.ModelWithProvider, error) {
+	var pinned []models.ModelWithProvider
+	for _, mp := range modelWithProviders {
+		if provider, ok := providerMap[mp.ProviderID]; ok && provider.Name == before.pinnedProvider {
+			pinned = append(pinned, mp)
+		}
+	}
+	if len(pinned) == 0 {
+		return nil, errors.New("provider " + before.pinnedProvider + " is not available for model " + before.Model)
+	}
+	return pinned, nil
+}
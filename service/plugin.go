@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Plugin 请求/响应中间件插件，用于在不修改 BalanceChat 的前提下编译进自定义改写。
+// 钩子按注册顺序执行；嵌入 BasePlugin 后只需实现关心的钩子
+type Plugin interface {
+	Name() string
+	// OnRequest 在请求发往上游前执行（格式转换与请求改写规则之后），可修改 Header 与 Body；返回错误时中止请求且不再重试
+	OnRequest(ctx context.Context, req *PluginRequest) error
+	// OnResponse 在上游返回 200 且完成格式转换后执行，可修改返回给客户端的 Header；返回错误时中止请求
+	OnResponse(ctx context.Context, res *PluginResponse) error
+	// OnStreamChunk 改写返回给客户端的内容：流式响应按 SSE 事件（含结尾空行）调用，非流式响应以完整响应体调用一次
+	OnStreamChunk(ctx context.Context, res *PluginResponse, chunk []byte) ([]byte, error)
+}
+
+// PluginRequest 发往上游的请求
+type PluginRequest struct {
+	Style         string // 客户端请求格式
+	ProviderStyle string // 上游请求格式
+	Model         string // 客户端请求的模型
+	Provider      string
+	ProviderModel string
+	Stream        bool
+	Header        http.Header
+	Body          []byte
+}
+
+// PluginResponse 返回给客户端的响应，Body 由 OnStreamChunk 处理
+type PluginResponse struct {
+	Style         string
+	Model         string
+	Provider      string
+	ProviderModel string
+	Stream        bool
+	StatusCode    int
+	Header        http.Header
+}
+
+// BasePlugin 所有钩子的空实现
+type BasePlugin struct{}
+
+func (BasePlugin) OnRequest(context.Context, *PluginRequest) error   { return nil }
+func (BasePlugin) OnResponse(context.Context, *PluginResponse) error { return nil }
+func (BasePlugin) OnStreamChunk(_ context.Context, _ *PluginResponse, chunk []byte) ([]byte, error) {
+	return chunk, nil
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   []Plugin
+)
+
+// RegisterPlugin 注册插件，通常在 main 启动服务前调用
+func RegisterPlugin(p Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins = append(plugins, p)
+	slog.Info("plugin registered", "plugin", p.Name())
+}
+
+// registeredPlugins 返回当前已注册插件的快照
+func registeredPlugins() []Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return plugins
+}
+
+// runRequestPlugins 依次执行 OnRequest 钩子
+func runRequestPlugins(ctx context.Context, list []Plugin, req *PluginRequest) error {
+	for _, p := range list {
+		if err := p.OnRequest(ctx, req); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
+	}
+	return nil
+}
+
+// applyResponsePlugins 执行 OnResponse 钩子，并在有插件时包装响应体以执行 OnStreamChunk
+func applyResponsePlugins(ctx context.Context, list []Plugin, res *http.Response, info *PluginResponse) error {
+	if len(list) == 0 {
+		return nil
+	}
+	if res.Header == nil {
+		res.Header = http.Header{}
+	}
+	info.StatusCode, info.Header = res.StatusCode, res.Header
+	for _, p := range list {
+		if err := p.OnResponse(ctx, info); err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
+	}
+	res.Header = info.Header
+	// 插件可能改变内容长度
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Body = newPluginBody(ctx, list, info, res.Body)
+	return nil
+}
+
+// pluginBody 按 SSE 事件或完整响应体调用 OnStreamChunk
+type pluginBody struct {
+	ctx     context.Context
+	plugins []Plugin
+	info    *PluginResponse
+	src     io.ReadCloser
+	reader  *bufio.Reader
+	buf     bytes.Buffer
+	err     error
+}
+
+func newPluginBody(ctx context.Context, list []Plugin, info *PluginResponse, src io.ReadCloser) *pluginBody {
+	return &pluginBody{ctx: ctx, plugins: list, info: info, src: src, reader: bufio.NewReaderSize(src, InitScannerBufferSize)}
+}
+
+func (b *pluginBody) Read(p []byte) (int, error) {
+	for b.buf.Len() == 0 && b.err == nil {
+		chunk, err := b.next()
+		if len(chunk) > 0 {
+			if chunk, err2 := b.apply(chunk); err2 != nil {
+				b.err = err2
+			} else {
+				b.buf.Write(chunk)
+			}
+		}
+		if err != nil && b.err == nil {
+			b.err = err
+		}
+	}
+	if b.buf.Len() > 0 {
+		return b.buf.Read(p)
+	}
+	return 0, b.err
+}
+
+// next 读取下一个 SSE 事件；非流式响应一次读取完整响应体
+func (b *pluginBody) next() ([]byte, error) {
+	if !b.info.Stream {
+		data, err := io.ReadAll(b.reader)
+		if err == nil {
+			err = io.EOF
+		}
+		return data, err
+	}
+	var event []byte
+	for {
+		line, err := b.reader.ReadBytes('\n')
+		event = append(event, line...)
+		if err != nil {
+			return event, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			return event, nil
+		}
+	}
+}
+
+func (b *pluginBody) apply(chunk []byte) ([]byte, error) {
+	var err error
+	for _, p := range b.plugins {
+		if chunk, err = p.OnStreamChunk(b.ctx, b.info, chunk); err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
+	}
+	return chunk, nil
+}
+
+func (b *pluginBody) Close() error {
+	return b.src.Close()
+}
+
+// LoggingPlugin 内置插件：以 Debug 级别记录发往上游的请求与返回的响应
+type LoggingPlugin struct {
+	BasePlugin
+	Body bool // 是否同时记录请求体与响应内容
+}
+
+func (LoggingPlugin) Name() string { return "logging" }
+
+func (p LoggingPlugin) OnRequest(ctx context.Context, req *PluginRequest) error {
+	attrs := []any{"model", req.Model, "provider", req.Provider, "provider_model", req.ProviderModel, "style", req.Style, "provider_style", req.ProviderStyle, "bytes", len(req.Body)}
+	if p.Body {
+		attrs = append(attrs, "body", string(req.Body))
+	}
+	slog.DebugContext(ctx, "plugin request", attrs...)
+	return nil
+}
+
+func (LoggingPlugin) OnResponse(ctx context.Context, res *PluginResponse) error {
+	slog.DebugContext(ctx, "plugin response", "model", res.Model, "provider", res.Provider, "status", res.StatusCode, "stream", res.Stream)
+	return nil
+}
+
+func (p LoggingPlugin) OnStreamChunk(ctx context.Context, res *PluginResponse, chunk []byte) ([]byte, error) {
+	if p.Body {
+		slog.DebugContext(ctx, "plugin response chunk", "model", res.Model, "provider", res.Provider, "chunk", string(chunk))
+	}
+	return chunk, nil
+}
+
+// HeaderPlugin 内置插件：设置或删除发往上游的请求头与返回给客户端的响应头
+type HeaderPlugin struct {
+	BasePlugin
+	SetRequest     map[string]string
+	RemoveRequest  []string
+	SetResponse    map[string]string
+	RemoveResponse []string
+}
+
+func (HeaderPlugin) Name() string { return "header" }
+
+func (p HeaderPlugin) OnRequest(_ context.Context, req *PluginRequest) error {
+	editHeader(req.Header, p.SetRequest, p.RemoveRequest)
+	return nil
+}
+
+func (p HeaderPlugin) OnResponse(_ context.Context, res *PluginResponse) error {
+	editHeader(res.Header, p.SetResponse, p.RemoveResponse)
+	return nil
+}
+
+func editHeader(header http.Header, set map[string]string, remove []string) {
+	for _, key := range remove {
+		header.Del(key)
+	}
+	for key, value := range set {
+		header.Set(key, value)
+	}
+}
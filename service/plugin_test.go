@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// upperPlugin 将响应内容转为大写，拒绝请求体中包含 forbidden 的请求
+type upperPlugin struct{ BasePlugin }
+
+func (upperPlugin) Name() string { return "upper" }
+
+func (upperPlugin) OnRequest(_ context.Context, req *PluginRequest) error {
+	if bytes.Contains(req.Body, []byte("forbidden")) {
+		return errors.New("forbidden content")
+	}
+	req.Body = bytes.ReplaceAll(req.Body, []byte("max_tokens"), []byte("max_completion_tokens"))
+	return nil
+}
+
+func (upperPlugin) OnStreamChunk(_ context.Context, _ *PluginResponse, chunk []byte) ([]byte, error) {
+	return bytes.ToUpper(chunk), nil
+}
+
+func TestPlugins(t *testing.T) {
+	ctx := context.Background()
+	list := []Plugin{upperPlugin{}, HeaderPlugin{
+		SetRequest:     map[string]string{"X-Tenant": "a"},
+		RemoveRequest:  []string{"X-Debug"},
+		SetResponse:    map[string]string{"X-Gateway": "llmio"},
+		RemoveResponse: []string{"X-Upstream-Id"},
+	}, LoggingPlugin{Body: true}}
+
+	req := &PluginRequest{Header: http.Header{"X-Debug": {"1"}}, Body: []byte(`{"max_tokens":1}`)}
+	if err := runRequestPlugins(ctx, list, req); err != nil {
+		t.Fatal(err)
+	}
+	if string(req.Body) != `{"max_completion_tokens":1}` || req.Header.Get("X-Tenant") != "a" || req.Header.Get("X-Debug") != "" {
+		t.Fatalf("request = %+v, body = %s", req, req.Body)
+	}
+	if err := runRequestPlugins(ctx, list, &PluginRequest{Body: []byte("forbidden")}); err == nil || !strings.Contains(err.Error(), "plugin upper") {
+		t.Fatalf("request error = %v", err)
+	}
+
+	for _, stream := range []bool{true, false} {
+		body := "data: {\"a\":\"x\"}\n\ndata: [done]\n\n"
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Upstream-Id": {"u"}, "Content-Length": {"10"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+		var chunks int
+		counting := append(list, countPlugin{chunks: &chunks})
+		if err := applyResponsePlugins(ctx, counting, res, &PluginResponse{Stream: stream}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != strings.ToUpper(body) || res.Header.Get("X-Gateway") != "llmio" || res.Header.Get("X-Upstream-Id") != "" || res.Header.Get("Content-Length") != "" {
+			t.Fatalf("stream=%v response = %q, header = %v", stream, got, res.Header)
+		}
+		if want := map[bool]int{true: 2, false: 1}[stream]; chunks != want {
+			t.Fatalf("stream=%v chunks = %d, want %d", stream, chunks, want)
+		}
+	}
+}
+
+type countPlugin struct {
+	BasePlugin
+	chunks *int
+}
+
+func (countPlugin) Name() string { return "count" }
+
+func (p countPlugin) OnStreamChunk(_ context.Context, _ *PluginResponse, chunk []byte) ([]byte, error) {
+	*p.chunks++
+	return chunk, nil
+}
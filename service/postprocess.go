@@ -0,0 +1,417 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+type compiledReplacement struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// ResponsePostProcessor 按模型配置的规则改写返回给客户端的响应文本，日志中保留上游原文
+type ResponsePostProcessor struct {
+	style        string
+	replacements []compiledReplacement
+	trim         bool
+	stops        []string
+	maxStop      int
+}
+
+// ValidateResponseRules 校验规则中的正则是否合法
+func ValidateResponseRules(rules *models.ResponseRules) error {
+	if rules == nil {
+		return nil
+	}
+	for _, r := range rules.Replacements {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", r.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// NewResponsePostProcessor 根据模型规则与客户端请求构建后处理器，没有需要执行的规则时返回 nil
+func NewResponsePostProcessor(rules *models.ResponseRules, style string, before Before) *ResponsePostProcessor {
+	if rules.Empty() || style == consts.StyleOpenAIEmbeddings {
+		return nil
+	}
+	p := &ResponsePostProcessor{style: style, trim: rules.TrimTrailingWhitespace}
+	for _, r := range rules.Replacements {
+		// 保存时已校验，这里跳过异常规则而不是中断请求
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			continue
+		}
+		p.replacements = append(p.replacements, compiledReplacement{re: re, replacement: r.Replacement})
+	}
+	if rules.EnforceStop {
+		p.stops = requestStopSequences(style, before.raw)
+		for _, stop := range p.stops {
+			p.maxStop = max(p.maxStop, len(stop))
+		}
+	}
+	if len(p.replacements) == 0 && !p.trim && len(p.stops) == 0 {
+		return nil
+	}
+	return p
+}
+
+// requestStopSequences 提取客户端请求中的停止序列，Responses 接口没有该参数
+func requestStopSequences(style string, raw []byte) []string {
+	var field gjson.Result
+	switch style {
+	case consts.StyleOpenAI:
+		field = gjson.GetBytes(raw, "stop")
+	case consts.StyleAnthropic:
+		field = gjson.GetBytes(raw, "stop_sequences")
+	default:
+		return nil
+	}
+	var stops []string
+	if field.Type == gjson.String {
+		field = gjson.Parse(fmt.Sprintf("[%s]", field.Raw))
+	}
+	for _, stop := range field.Array() {
+		if s := stop.String(); s != "" {
+			stops = append(stops, s)
+		}
+	}
+	return stops
+}
+
+// Apply 与 handler 的 bodyConverter 签名一致，对响应体做后处理
+func (p *ResponsePostProcessor) Apply(body io.Reader, stream bool) (io.Reader, error) {
+	if stream {
+		return p.stream(body), nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(p.rewriteBody(data)), nil
+}
+
+func (p *ResponsePostProcessor) replace(text string) string {
+	for _, r := range p.replacements {
+		text = r.re.ReplaceAllString(text, r.replacement)
+	}
+	return text
+}
+
+// findStop 返回最早出现的停止序列位置
+func (p *ResponsePostProcessor) findStop(text string) (int, string) {
+	idx, matched := -1, ""
+	for _, stop := range p.stops {
+		if i := strings.Index(text, stop); i >= 0 && (idx < 0 || i < idx) {
+			idx, matched = i, stop
+		}
+	}
+	return idx, matched
+}
+
+// processText 处理完整文本：先按停止序列截断，再执行替换与末尾空白去除
+func (p *ResponsePostProcessor) processText(text string) (string, string) {
+	idx, stop := p.findStop(text)
+	if idx >= 0 {
+		text = text[:idx]
+	}
+	text = p.replace(text)
+	if p.trim {
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+	}
+	return text, stop
+}
+
+// rewriteBody 改写非流式响应中的文本内容
+func (p *ResponsePostProcessor) rewriteBody(data []byte) string {
+	body := string(data)
+	set := func(path, value string) {
+		if updated, err := sjson.Set(body, path, value); err == nil {
+			body = updated
+		}
+	}
+	switch p.style {
+	case consts.StyleOpenAI:
+		gjson.Get(body, "choices").ForEach(func(i, choice gjson.Result) bool {
+			content := choice.Get("message.content")
+			if content.Type != gjson.String {
+				return true
+			}
+			text, stop := p.processText(content.String())
+			set(fmt.Sprintf("choices.%d.message.content", i.Int()), text)
+			if stop != "" {
+				set(fmt.Sprintf("choices.%d.finish_reason", i.Int()), "stop")
+			}
+			return true
+		})
+	case consts.StyleAnthropic:
+		stopped := ""
+		gjson.Get(body, "content").ForEach(func(i, block gjson.Result) bool {
+			if block.Get("type").String() != "text" {
+				return true
+			}
+			text, stop := p.processText(block.Get("text").String())
+			set(fmt.Sprintf("content.%d.text", i.Int()), text)
+			if stop != "" && stopped == "" {
+				stopped = stop
+			}
+			return true
+		})
+		if stopped != "" {
+			set("stop_reason", "stop_sequence")
+			set("stop_sequence", stopped)
+		}
+	case consts.StyleOpenAIRes:
+		body = p.rewriteResponsesOutput(body, "")
+	}
+	return body
+}
+
+// rewriteResponsesOutput 改写 Responses 响应对象中的 output_text，prefix 为响应对象在 JSON 中的路径
+func (p *ResponsePostProcessor) rewriteResponsesOutput(body, prefix string) string {
+	gjson.Get(body, prefix+"output").ForEach(func(i, item gjson.Result) bool {
+		item.Get("content").ForEach(func(j, part gjson.Result) bool {
+			if part.Get("type").String() != "output_text" {
+				return true
+			}
+			text, _ := p.processText(part.Get("text").String())
+			if updated, err := sjson.Set(body, fmt.Sprintf("%soutput.%d.content.%d.text", prefix, i.Int(), j.Int()), text); err == nil {
+				body = updated
+			}
+			return true
+		})
+		return true
+	})
+	return body
+}
+
+// textStream 流式文本的增量处理：保留可能构成停止序列的尾部、正则规则启用时按整行替换后输出、暂缓输出末尾空白
+type textStream struct {
+	p       *ResponsePostProcessor
+	pending string // 尚未处理的原文
+	held    string // 已处理但暂缓输出的末尾空白，后续有内容时再输出
+	stop    string // 命中的停止序列，非空后丢弃之后的内容
+}
+
+func (s *textStream) push(delta string) string {
+	if s.stop != "" {
+		return ""
+	}
+	s.pending += delta
+	if idx, stop := s.p.findStop(s.pending); idx >= 0 {
+		out := s.pending[:idx]
+		s.pending, s.stop = "", stop
+		return s.emit(s.p.replace(out), true)
+	}
+
+	safe := len(s.pending)
+	if s.p.maxStop > 0 {
+		safe = max(0, safe-(s.p.maxStop-1))
+	}
+	if len(s.p.replacements) > 0 {
+		safe = strings.LastIndexByte(s.pending[:safe], '\n') + 1
+	}
+	for safe > 0 && safe < len(s.pending) && !utf8.RuneStart(s.pending[safe]) {
+		safe--
+	}
+	out := s.pending[:safe]
+	s.pending = s.pending[safe:]
+	return s.emit(s.p.replace(out), false)
+}
+
+// flush 输出剩余内容，用于文本块结束时
+func (s *textStream) flush() string {
+	out := s.pending
+	s.pending = ""
+	return s.emit(s.p.replace(out), true)
+}
+
+func (s *textStream) emit(text string, final bool) string {
+	text = s.held + text
+	s.held = ""
+	if !s.p.trim {
+		return text
+	}
+	trimmed := strings.TrimRightFunc(text, unicode.IsSpace)
+	if !final {
+		s.held = text[len(trimmed):]
+	}
+	return trimmed
+}
+
+// stream 逐个 SSE 事件改写文本增量，event 行与其 data 行一起输出以便在前面插入补发的增量
+func (p *ResponsePostProcessor) stream(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		rw := &sseRewriter{p: p, w: pw, texts: make(map[int64]*textStream)}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, InitScannerBufferSize), MaxScannerBufferSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if event, ok := strings.CutPrefix(line, "event:"); ok {
+				rw.event = strings.TrimSpace(event)
+				continue
+			}
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				if line != "" {
+					fmt.Fprintf(pw, "%s\n", line)
+				}
+				continue
+			}
+			rw.handle(strings.TrimSpace(data))
+		}
+		rw.done()
+		pw.CloseWithError(scanner.Err())
+	}()
+	return pr
+}
+
+type sseRewriter struct {
+	p     *ResponsePostProcessor
+	w     io.Writer
+	event string
+	texts map[int64]*textStream // 按 choice / content block / output 下标区分
+	last  string                // 最近一个 OpenAI 增量 chunk，用于补发剩余内容
+}
+
+func (rw *sseRewriter) text(index int64) *textStream {
+	ts, ok := rw.texts[index]
+	if !ok {
+		ts = &textStream{p: rw.p}
+		rw.texts[index] = ts
+	}
+	return ts
+}
+
+func (rw *sseRewriter) write(event, data string) {
+	if event != "" {
+		fmt.Fprintf(rw.w, "event: %s\n", event)
+	}
+	fmt.Fprintf(rw.w, "data: %s\n\n", data)
+}
+
+func (rw *sseRewriter) handle(data string) {
+	event := rw.event
+	rw.event = ""
+	if data == "[DONE]" || !gjson.Valid(data) {
+		if data == "[DONE]" {
+			rw.done()
+		}
+		rw.write(event, data)
+		return
+	}
+	switch rw.p.style {
+	case consts.StyleOpenAI:
+		data = rw.openAI(data)
+	case consts.StyleAnthropic:
+		data = rw.anthropic(data)
+	case consts.StyleOpenAIRes:
+		data = rw.responses(event, data)
+	}
+	rw.write(event, data)
+}
+
+func (rw *sseRewriter) openAI(data string) string {
+	gjson.Get(data, "choices").ForEach(func(i, choice gjson.Result) bool {
+		index := choice.Get("index").Int()
+		ts := rw.text(index)
+		content := choice.Get("delta.content")
+		text := ""
+		if content.Type == gjson.String {
+			text = ts.push(content.String())
+			rw.last = data
+		}
+		finished := choice.Get("finish_reason").Type == gjson.String
+		if finished {
+			text += ts.flush()
+		}
+		if content.Type == gjson.String || text != "" {
+			data, _ = sjson.Set(data, fmt.Sprintf("choices.%d.delta.content", i.Int()), text)
+		}
+		if finished && ts.stop != "" {
+			data, _ = sjson.Set(data, fmt.Sprintf("choices.%d.finish_reason", i.Int()), "stop")
+		}
+		return true
+	})
+	return data
+}
+
+func (rw *sseRewriter) anthropic(data string) string {
+	switch gjson.Get(data, "type").String() {
+	case "content_block_delta":
+		if gjson.Get(data, "delta.type").String() != "text_delta" {
+			return data
+		}
+		text := rw.text(gjson.Get(data, "index").Int()).push(gjson.Get(data, "delta.text").String())
+		data, _ = sjson.Set(data, "delta.text", text)
+	case "content_block_stop":
+		index := gjson.Get(data, "index").Int()
+		if rest := rw.text(index).flush(); rest != "" {
+			delta, _ := sjson.Set(`{"type":"content_block_delta","delta":{"type":"text_delta"}}`, "index", index)
+			delta, _ = sjson.Set(delta, "delta.text", rest)
+			rw.write("content_block_delta", delta)
+		}
+	case "message_delta":
+		for _, ts := range rw.texts {
+			if ts.stop != "" {
+				data, _ = sjson.Set(data, "delta.stop_reason", "stop_sequence")
+				data, _ = sjson.Set(data, "delta.stop_sequence", ts.stop)
+				break
+			}
+		}
+	}
+	return data
+}
+
+func (rw *sseRewriter) responses(event, data string) string {
+	if event == "" {
+		event = gjson.Get(data, "type").String()
+	}
+	switch event {
+	case "response.output_text.delta":
+		text := rw.text(gjson.Get(data, "output_index").Int()).push(gjson.Get(data, "delta").String())
+		data, _ = sjson.Set(data, "delta", text)
+	case "response.output_text.done":
+		if rest := rw.text(gjson.Get(data, "output_index").Int()).flush(); rest != "" {
+			delta, _ := sjson.Set(data, "type", "response.output_text.delta")
+			delta, _ = sjson.Delete(delta, "text")
+			delta, _ = sjson.Set(delta, "delta", rest)
+			rw.write("response.output_text.delta", delta)
+		}
+		text, _ := rw.p.processText(gjson.Get(data, "text").String())
+		data, _ = sjson.Set(data, "text", text)
+	case "response.completed":
+		data = rw.p.rewriteResponsesOutput(data, "response.")
+	}
+	return data
+}
+
+// done 上游没有发送结束 chunk 时补发 OpenAI 格式的剩余内容
+func (rw *sseRewriter) done() {
+	if rw.p.style != consts.StyleOpenAI || rw.last == "" {
+		return
+	}
+	for index, ts := range rw.texts {
+		rest := ts.flush()
+		if rest == "" {
+			continue
+		}
+		chunk, _ := sjson.SetRaw(rw.last, "choices", "[]")
+		chunk, _ = sjson.Set(chunk, "choices.0.index", index)
+		chunk, _ = sjson.Set(chunk, "choices.0.delta.content", rest)
+		rw.write("", chunk)
+	}
+}
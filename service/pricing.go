@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// CalculateCost 按定价计算一次请求的费用：未命中缓存的输入、命中缓存的输入与输出分别计价
+func CalculateCost(pricing models.Pricing, usage models.Usage) float64 {
+	cached := min(usage.PromptTokensDetails.CachedTokens, usage.PromptTokens)
+	cachedPrice := pricing.CachedPrice
+	if cachedPrice == 0 {
+		cachedPrice = pricing.InputPrice
+	}
+	return (float64(usage.PromptTokens-cached)*pricing.InputPrice +
+		float64(cached)*cachedPrice +
+		float64(usage.CompletionTokens)*pricing.OutputPrice) / 1e6
+}
+
+// ApplyLogCost 按关联的定价计算日志费用并写回，未配置定价时不做处理
+func ApplyLogCost(ctx context.Context, log *models.ChatLog) error {
+	if log.ModelWithProviderID == 0 {
+		return nil
+	}
+	pricing, err := gorm.G[models.Pricing](models.DB).Where("model_with_provider_id = ?", log.ModelWithProviderID).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	log.Cost = CalculateCost(pricing, log.Usage)
+	// 未采样的请求没有日志记录，只计算费用
+	if log.ID == 0 {
+		return nil
+	}
+	_, err = gorm.G[models.ChatLog](models.DB).Where("id = ?", log.ID).Update(ctx, "cost", log.Cost)
+	return err
+}
+
+// SpendMetric 按天、供应商、模型汇总的花费
+type SpendMetric struct {
+	Date           string  `json:"date"`
+	ProviderName   string  `json:"provider_name"`
+	ProviderModel  string  `json:"provider_model"`
+	Model          string  `json:"model"`
+	Requests       int64   `json:"requests"`
+	TotalTokens    int64   `json:"total_tokens"`
+	Cost           float64 `json:"cost"`
+	CostPerMillion float64 `json:"cost_per_million"` // 实际每百万 token 花费，便于横向比较供应商
+}
+
+// GetSpendMetrics 汇总最近 days 天成功请求的花费
+func GetSpendMetrics(ctx context.Context, days int) ([]SpendMetric, error) {
+	now := time.Now()
+	year, month, day := now.Date()
+	since := time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days)
+
+	metrics := make([]SpendMetric, 0)
+	date := models.DateExpr(models.DB, "created_at")
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select(date+" AS date, provider_name, provider_model, name AS model, SUM(sample_weight) AS requests, "+
+			"COALESCE(SUM(total_tokens * sample_weight), 0) AS total_tokens, COALESCE(SUM(cost * sample_weight), 0) AS cost").
+		Where("created_at >= ? AND status = ?", since, "success").
+		Group(date + ", provider_name, provider_model, name").
+		Order("date DESC, cost DESC").
+		Scan(&metrics).Error; err != nil {
+		return nil, err
+	}
+	for i := range metrics {
+		metrics[i].CostPerMillion = pricePerMillion(metrics[i].Cost, metrics[i].TotalTokens)
+	}
+	return metrics, nil
+}
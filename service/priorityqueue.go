@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+const (
+	PriorityInteractive = "interactive" // 交互式请求，排队时优先放行
+	PriorityBatch       = "batch"       // 批量请求，只在没有交互式请求排队时放行
+
+	// PriorityHeader 客户端声明优先级的请求头，API Key 配置为 batch 时不能通过请求头提升
+	PriorityHeader = "X-LLMIO-Priority"
+
+	defaultRequestQueueTimeout = 30 // 秒
+)
+
+// ErrRequestQueueTimeout 并发名额已满且排队超时
+var ErrRequestQueueTimeout = errors.New("request queue timeout")
+
+// ValidPriorityClass 优先级是否合法，空值表示由请求头决定
+func ValidPriorityClass(class string) bool {
+	return class == "" || class == PriorityInteractive || class == PriorityBatch
+}
+
+// ResolvePriorityClass 确定请求的优先级：API Key 配置为 batch 时固定为 batch，否则请求头可声明为 batch，默认 interactive
+func ResolvePriorityClass(key *models.APIKey, header string) string {
+	if key != nil && key.PriorityClass == PriorityBatch {
+		return PriorityBatch
+	}
+	if header == PriorityBatch {
+		return PriorityBatch
+	}
+	return PriorityInteractive
+}
+
+// RequestQueueSettings 请求并发与排队设置，并发上限均为 0 时不排队
+type RequestQueueSettings struct {
+	MaxConcurrent      int `json:"max_concurrent"`       // 同时转发的请求数上限，0 表示不限制
+	BatchMaxConcurrent int `json:"batch_max_concurrent"` // batch 请求同时转发的上限，0 表示只受总上限限制
+	QueueTimeout       int `json:"queue_timeout"`        // 排队等待的最长时间（秒），0 表示名额已满时直接拒绝
+}
+
+// GetRequestQueueSettings 读取请求并发与排队设置
+func GetRequestQueueSettings(ctx context.Context) RequestQueueSettings {
+	return RequestQueueSettings{
+		MaxConcurrent:      getIntSetting(ctx, models.SettingKeyRequestMaxConcurrent, 0),
+		BatchMaxConcurrent: getIntSetting(ctx, models.SettingKeyRequestBatchMaxConcurrent, 0),
+		QueueTimeout:       getIntSetting(ctx, models.SettingKeyRequestQueueTimeout, defaultRequestQueueTimeout),
+	}
+}
+
+// RequestQueueStatus 各优先级进行中与排队中的请求数
+type RequestQueueStatus struct {
+	Running map[string]int `json:"running"`
+	Waiting map[string]int `json:"waiting"`
+}
+
+type queuedRequest struct {
+	admit   func() *RateLimitError
+	granted chan struct{}
+	limited *RateLimitError // 最近一次放行时被限流的原因
+	retry   *time.Timer     // 限流窗口重置后重新尝试放行
+}
+
+// RequestScheduler 按优先级分配转发名额：名额已满或被 RPM/TPM 限流时请求进入对应优先级的队列，
+// 名额归还或限流窗口重置后先放行排队的 interactive 请求，再放行 batch 请求
+type RequestScheduler struct {
+	mu       sync.Mutex
+	settings *RequestQueueSettings // 缓存的设置，nil 表示尚未从数据库加载
+	running  map[string]int
+	waiting  map[string][]*queuedRequest
+}
+
+var (
+	requestScheduler     *RequestScheduler
+	requestSchedulerOnce sync.Once
+)
+
+func newRequestScheduler() *RequestScheduler {
+	return &RequestScheduler{
+		running: make(map[string]int),
+		waiting: make(map[string][]*queuedRequest),
+	}
+}
+
+// GetRequestScheduler 获取请求调度器单例
+func GetRequestScheduler() *RequestScheduler {
+	requestSchedulerOnce.Do(func() {
+		requestScheduler = newRequestScheduler()
+	})
+	return requestScheduler
+}
+
+// Acquire 获取一个转发名额，名额已满时排队等待；admit 非空时在放行前校验并占用限流额度，
+// 被限流的请求同样按优先级排队，等到窗口重置后再次尝试。返回的 release 需在响应结束后调用，可重复调用。
+// 排队超时时，因限流未能放行的请求返回最近一次的 *RateLimitError，其余返回 ErrRequestQueueTimeout；ctx 结束时返回 ctx 的错误
+func (s *RequestScheduler) Acquire(ctx context.Context, class string, admit func() *RateLimitError) (release func(), err error) {
+	s.mu.Lock()
+	settings := s.loadSettings(ctx)
+	req := &queuedRequest{admit: admit, granted: make(chan struct{})}
+	// 先入队再统一分配，保证不会越过排在前面的同优先级请求或排队中的 interactive 请求
+	s.waiting[class] = append(s.waiting[class], req)
+	s.dispatch()
+	select {
+	case <-req.granted:
+		s.mu.Unlock()
+		return s.releaseFunc(class), nil
+	default:
+	}
+	if settings.QueueTimeout <= 0 {
+		defer s.mu.Unlock()
+		return nil, s.abandon(class, req, ErrRequestQueueTimeout)
+	}
+	s.mu.Unlock()
+
+	timer := time.NewTimer(time.Duration(settings.QueueTimeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-req.granted:
+		return s.releaseFunc(class), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrRequestQueueTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-req.granted:
+		// 超时的同时已被放行，按放行处理
+		return s.releaseFunc(class), nil
+	default:
+	}
+	return nil, s.abandon(class, req, err)
+}
+
+// abandon 将未放行的请求移出队列并返回应报告的错误，调用方需持有 mu
+func (s *RequestScheduler) abandon(class string, req *queuedRequest, err error) error {
+	if req.retry != nil {
+		req.retry.Stop()
+	}
+	queue := s.waiting[class]
+	for i, queued := range queue {
+		if queued == req {
+			s.waiting[class] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if errors.Is(err, ErrRequestQueueTimeout) && req.limited != nil {
+		return req.limited
+	}
+	return err
+}
+
+// loadSettings 返回缓存的设置，首次调用时从数据库加载，调用方需持有 mu
+func (s *RequestScheduler) loadSettings(ctx context.Context) RequestQueueSettings {
+	if s.settings == nil {
+		settings := GetRequestQueueSettings(ctx)
+		s.settings = &settings
+	}
+	return *s.settings
+}
+
+// UpdateSettings 应用新的并发设置并按新上限放行排队的请求；上限改为 0 时所有排队请求立即放行
+func (s *RequestScheduler) UpdateSettings(settings RequestQueueSettings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = &settings
+	s.dispatch()
+}
+
+// ReloadSettings 从数据库重新加载并应用并发设置，整体写入设置（如导入配置）后调用
+func (s *RequestScheduler) ReloadSettings(ctx context.Context) {
+	s.UpdateSettings(GetRequestQueueSettings(ctx))
+}
+
+// Status 返回各优先级进行中与排队中的请求数
+func (s *RequestScheduler) Status() RequestQueueStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := RequestQueueStatus{Running: make(map[string]int), Waiting: make(map[string]int)}
+	for _, class := range []string{PriorityInteractive, PriorityBatch} {
+		status.Running[class] = s.running[class]
+		status.Waiting[class] = len(s.waiting[class])
+	}
+	return status
+}
+
+// canRun 该优先级的请求当前是否有空闲名额，调用方需持有 mu
+func (s *RequestScheduler) canRun(class string) bool {
+	if s.settings.MaxConcurrent > 0 && s.running[PriorityInteractive]+s.running[PriorityBatch] >= s.settings.MaxConcurrent {
+		return false
+	}
+	return class != PriorityBatch || s.settings.BatchMaxConcurrent <= 0 || s.running[PriorityBatch] < s.settings.BatchMaxConcurrent
+}
+
+// dispatch 按优先级依次放行排队的请求，调用方需持有 mu；
+// 被限流的请求留在队列中，不阻塞同优先级中其他限流对象的请求，窗口重置时再次尝试
+func (s *RequestScheduler) dispatch() {
+	for _, class := range []string{PriorityInteractive, PriorityBatch} {
+		queue := s.waiting[class]
+		remaining := queue[:0:0]
+		for i, req := range queue {
+			if !s.canRun(class) {
+				remaining = append(remaining, queue[i:]...)
+				break
+			}
+			if req.admit != nil {
+				if limitErr := req.admit(); limitErr != nil {
+					req.limited = limitErr
+					s.scheduleRetry(req, limitErr.RetryAfter)
+					remaining = append(remaining, req)
+					continue
+				}
+			}
+			if req.retry != nil {
+				req.retry.Stop()
+			}
+			s.running[class]++
+			close(req.granted)
+		}
+		s.waiting[class] = remaining
+	}
+}
+
+// scheduleRetry 限流窗口重置后重新分配名额，每个请求同时只保留一个定时器，调用方需持有 mu
+func (s *RequestScheduler) scheduleRetry(req *queuedRequest, after time.Duration) {
+	if req.retry != nil {
+		req.retry.Reset(after)
+		return
+	}
+	req.retry = time.AfterFunc(after, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.dispatch()
+	})
+}
+
+func (s *RequestScheduler) releaseFunc(class string) func() {
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running[class]--
+		s.dispatch()
+	})
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestRequestScheduler(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	setRequestQueueSettings(t, map[string]int{
+		models.SettingKeyRequestMaxConcurrent:      1,
+		models.SettingKeyRequestBatchMaxConcurrent: 0,
+		models.SettingKeyRequestQueueTimeout:       5,
+	})
+	scheduler := newRequestScheduler()
+
+	release, err := scheduler.Acquire(ctx, PriorityBatch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 先排队的 batch 请求在后排队的 interactive 请求之后放行
+	order := make(chan string, 2)
+	acquire := func(class string) {
+		release, err := scheduler.Acquire(ctx, class, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		order <- class
+		release()
+	}
+	go acquire(PriorityBatch)
+	waitQueued(t, scheduler, PriorityBatch, 1)
+	go acquire(PriorityInteractive)
+	waitQueued(t, scheduler, PriorityInteractive, 1)
+
+	release()
+	release() // 重复调用不会多归还名额
+	if first, second := <-order, <-order; first != PriorityInteractive || second != PriorityBatch {
+		t.Fatalf("order = %s, %s", first, second)
+	}
+	if status := scheduler.Status(); status.Running[PriorityInteractive] != 0 || status.Running[PriorityBatch] != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	// 设置缓存在调度器中，写入数据库后需重新加载才生效
+	setRequestQueueSettings(t, map[string]int{
+		models.SettingKeyRequestMaxConcurrent:      2,
+		models.SettingKeyRequestBatchMaxConcurrent: 1,
+		models.SettingKeyRequestQueueTimeout:       0,
+	})
+	scheduler.ReloadSettings(ctx)
+	// batch 上限只限制 batch 请求，interactive 请求仍可使用剩余名额
+	releaseBatch, err := scheduler.Acquire(ctx, PriorityBatch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseBatch()
+	if _, err := scheduler.Acquire(ctx, PriorityBatch, nil); !errors.Is(err, ErrRequestQueueTimeout) {
+		t.Errorf("second batch = %v, want queue timeout", err)
+	}
+	releaseInteractive, err := scheduler.Acquire(ctx, PriorityInteractive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseInteractive()
+	if status := scheduler.Status(); status.Waiting[PriorityBatch] != 0 || status.Running[PriorityBatch] != 1 || status.Running[PriorityInteractive] != 1 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestRequestSchedulerDisableReleasesWaiters(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	setRequestQueueSettings(t, map[string]int{
+		models.SettingKeyRequestMaxConcurrent:      1,
+		models.SettingKeyRequestBatchMaxConcurrent: 0,
+		models.SettingKeyRequestQueueTimeout:       30,
+	})
+	scheduler := newRequestScheduler()
+	release, err := scheduler.Acquire(ctx, PriorityInteractive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		release, err := scheduler.Acquire(ctx, PriorityInteractive, nil)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	waitQueued(t, scheduler, PriorityInteractive, 1)
+
+	// 运行时将上限改为 0 即关闭排队，已在排队的请求立即放行
+	scheduler.UpdateSettings(RequestQueueSettings{})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued request was not released after disabling the cap")
+	}
+}
+
+func TestRequestSchedulerRateLimit(t *testing.T) {
+	ctx := context.Background()
+	scheduler := newRequestScheduler()
+	scheduler.UpdateSettings(RequestQueueSettings{QueueTimeout: 5})
+
+	var limited atomic.Bool
+	limited.Store(true)
+	admit := func() *RateLimitError {
+		if limited.Load() {
+			return &RateLimitError{Scope: rateLimitScopeModel, Kind: rateLimitKindRequests, Limit: 1, RetryAfter: 50 * time.Millisecond}
+		}
+		return nil
+	}
+
+	// 被限流的请求排队等待窗口重置，而不是直接返回 429
+	done := make(chan error, 1)
+	go func() {
+		release, err := scheduler.Acquire(ctx, PriorityInteractive, admit)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	waitQueued(t, scheduler, PriorityInteractive, 1)
+
+	// 排队中的限流请求不阻塞其他限流对象的请求
+	release, err := scheduler.Acquire(ctx, PriorityInteractive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	limited.Store(false)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rate limited request was not released after the window reset")
+	}
+
+	// 不排队时限流直接返回限流错误
+	limited.Store(true)
+	scheduler.UpdateSettings(RequestQueueSettings{})
+	var limitErr *RateLimitError
+	if _, err := scheduler.Acquire(ctx, PriorityBatch, admit); !errors.As(err, &limitErr) || limitErr.Limit != 1 {
+		t.Errorf("err = %v, want rate limit error", err)
+	}
+	// 排队超时后返回最近一次的限流错误
+	scheduler.UpdateSettings(RequestQueueSettings{QueueTimeout: 1})
+	if _, err := scheduler.Acquire(ctx, PriorityBatch, admit); !errors.As(err, &limitErr) {
+		t.Errorf("err = %v, want rate limit error", err)
+	}
+	if status := scheduler.Status(); status.Waiting[PriorityBatch] != 0 || status.Running[PriorityInteractive] != 0 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestResolvePriorityClass(t *testing.T) {
+	batchKey := &models.APIKey{PriorityClass: PriorityBatch}
+	interactiveKey := &models.APIKey{PriorityClass: PriorityInteractive}
+	tests := []struct {
+		key    *models.APIKey
+		header string
+		want   string
+	}{
+		{nil, "", PriorityInteractive},
+		{nil, PriorityBatch, PriorityBatch},
+		{nil, "urgent", PriorityInteractive},
+		{batchKey, PriorityInteractive, PriorityBatch},
+		{interactiveKey, PriorityBatch, PriorityBatch},
+	}
+	for _, tt := range tests {
+		if got := ResolvePriorityClass(tt.key, tt.header); got != tt.want {
+			t.Errorf("ResolvePriorityClass(%+v, %q) = %s, want %s", tt.key, tt.header, got, tt.want)
+		}
+	}
+}
+
+// setRequestQueueSettings 直接写入请求排队设置
+func setRequestQueueSettings(t *testing.T, values map[string]int) {
+	t.Helper()
+	for key, value := range values {
+		if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(key)).Update("value", strconv.Itoa(value)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitQueued(t *testing.T, scheduler *RequestScheduler, class string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for scheduler.Status().Waiting[class] != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s waiting = %d, want %d", class, scheduler.Status().Waiting[class], n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
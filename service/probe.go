@@ -0,0 +1,445 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// 能力探测项
+const (
+	ProbeStreaming        = "streaming"
+	ProbeToolCall         = "tool_call"
+	ProbeStructuredOutput = "structured_output"
+	ProbeImage            = "image"
+	ProbeLongContext      = "long_context"
+)
+
+// 探测结果
+const (
+	ProbePassed = "passed" // 上游支持该能力
+	ProbeFailed = "failed" // 上游拒绝请求或响应不符合预期
+	ProbeError  = "error"  // 网络错误、鉴权失败、限流或 5xx，无法判断是否支持
+)
+
+const (
+	probeTimeout = 60 * time.Second
+
+	defaultProbeLongContextTokens = 32000
+	probeCharsPerToken            = 4 // 英文填充文本按约 4 字符 / token 估算
+)
+
+// ProbeNames 全部探测项，按执行顺序排列
+var ProbeNames = []string{ProbeStreaming, ProbeToolCall, ProbeStructuredOutput, ProbeImage, ProbeLongContext}
+
+// probeFlags 探测项对应的关联能力字段，未列出的探测项只报告结果
+var probeFlags = map[string]string{
+	ProbeToolCall:         "tool_call",
+	ProbeStructuredOutput: "structured_output",
+	ProbeImage:            "image",
+}
+
+// ProbeOptions 能力探测选项
+type ProbeOptions struct {
+	Probes            []string // 为空表示全部
+	Apply             bool     // 按结果更新关联的 ToolCall / StructuredOutput / Image，结果为 error 的项保持不变
+	LongContextTokens int      // 长上下文探测的输入 token 数，0 时取 32000 与关联上下文窗口 90% 中的较小值
+}
+
+// ProbeResult 单项探测结果
+type ProbeResult struct {
+	Name       string `json:"name"`
+	Result     string `json:"result"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Detail     string `json:"detail,omitempty"` // 未通过的原因
+	LatencyMs  int64  `json:"latency_ms"`
+}
+
+// ProbeReport 一次能力探测的全部结果，Applied 为已写入关联的能力字段
+type ProbeReport struct {
+	ModelProviderID uint            `json:"model_provider_id"`
+	ProviderName    string          `json:"provider_name"`
+	ProviderModel   string          `json:"provider_model"`
+	Results         []ProbeResult   `json:"results"`
+	Applied         map[string]bool `json:"applied,omitempty"`
+}
+
+// ValidateProbeNames 校验探测项名称
+func ValidateProbeNames(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(ProbeNames, name) {
+			return fmt.Errorf("unknown probe %q", name)
+		}
+	}
+	return nil
+}
+
+// prober 向一个关联发送 OpenAI 格式的请求，按供应商类型转换请求与响应，供能力探测与健康检测使用
+type prober struct {
+	provider models.Provider
+	mp       models.ModelWithProvider
+	chat     providers.Provider
+	style    string
+	timeout  time.Duration
+}
+
+func newProber(provider models.Provider, mp models.ModelWithProvider, timeout time.Duration) (*prober, error) {
+	chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &prober{provider: provider, mp: mp, chat: chatModel, style: providers.WireStyle(provider.Type), timeout: timeout}, nil
+}
+
+// ProbeModelProvider 对关联依次执行探测项，Apply 时按通过与否更新能力字段
+func ProbeModelProvider(ctx context.Context, id uint, opts ProbeOptions) (*ProbeReport, error) {
+	mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", mp.ProviderID).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newProber(provider, mp, probeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	names := opts.Probes
+	if len(names) == 0 {
+		names = ProbeNames
+	}
+	report := &ProbeReport{ModelProviderID: mp.ID, ProviderName: provider.Name, ProviderModel: mp.ProviderModel, Results: make([]ProbeResult, 0, len(names))}
+	for _, name := range ProbeNames {
+		if !slices.Contains(names, name) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, p.run(ctx, name, opts))
+	}
+
+	if opts.Apply {
+		updates := make(map[string]any)
+		for _, result := range report.Results {
+			column, ok := probeFlags[result.Name]
+			if !ok || result.Result == ProbeError {
+				continue
+			}
+			updates[column] = result.Result == ProbePassed
+		}
+		if len(updates) > 0 {
+			if err := models.DB.WithContext(ctx).Model(&models.ModelWithProvider{}).Where("id = ?", mp.ID).Updates(updates).Error; err != nil {
+				return nil, err
+			}
+			report.Applied = make(map[string]bool, len(updates))
+			for column, value := range updates {
+				report.Applied[column] = value.(bool)
+			}
+		}
+	}
+	return report, nil
+}
+
+// run 执行单项探测，计时包含排队之后的完整请求
+func (p *prober) run(ctx context.Context, name string, opts ProbeOptions) ProbeResult {
+	result := ProbeResult{Name: name}
+	if err := waitHealthCheckSlot(ctx, p.provider.ID); err != nil {
+		result.Result, result.Detail = ProbeError, err.Error()
+		return result
+	}
+	start := time.Now()
+	var err error
+	switch name {
+	case ProbeStreaming:
+		err = p.probeStreaming(ctx, &result)
+	case ProbeToolCall:
+		err = p.probeToolCall(ctx, &result)
+	case ProbeStructuredOutput:
+		err = p.probeStructuredOutput(ctx, &result)
+	case ProbeImage:
+		err = p.probeImage(ctx, &result)
+	case ProbeLongContext:
+		err = p.probeLongContext(ctx, &result, opts.LongContextTokens)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		result.StatusCode = statusErr.StatusCode
+	}
+	switch {
+	case statusErr != nil && rejectedStatus(statusErr.StatusCode):
+		// 上游以 4xx 拒绝探测请求，视为不支持该能力
+		result.fail("%v", statusErr)
+	case err != nil:
+		result.Result, result.Detail = ProbeError, err.Error()
+	case result.Result == "":
+		result.Result = ProbePassed
+	}
+	return result
+}
+
+// upstreamStatusError 上游返回非 200 状态码
+type upstreamStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// send 发送 OpenAI chat 格式的请求体，返回转换回 OpenAI 格式的响应；非 200 时返回 *upstreamStatusError
+func (p *prober) send(ctx context.Context, body map[string]any) (*http.Response, error) {
+	body["model"] = p.mp.ProviderModel
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	tm := NewTransformerManager(consts.StyleOpenAI, p.style)
+	if p.style != consts.StyleOpenAI {
+		if raw, err = tm.ProcessRequest(ctx, raw); err != nil {
+			return nil, fmt.Errorf("transform request error: %v", err)
+		}
+	}
+	stream, _ := body["stream"].(bool)
+	header := buildHeaders(http.Header{}, false, p.mp.CustomerHeaders, stream)
+	// 与正式请求执行相同的改写规则，避免上游因缺少必需参数拒绝
+	if len(p.mp.RequestRewrites) > 0 {
+		if raw, err = ApplyRequestRewrites(raw, header, p.mp.RequestRewrites); err != nil {
+			return nil, err
+		}
+	}
+	req, err := buildProviderReq(ctx, p.chat, p.style, header, p.mp.ProviderModel, raw)
+	if err != nil {
+		return nil, err
+	}
+	res, err := providers.GetClientWithProxy(p.timeout, p.chat.GetProxy()).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, &upstreamStatusError{StatusCode: res.StatusCode, Body: string(data)}
+	}
+	if p.provider.Type == consts.StyleOllama {
+		if res, err = normalizeOllamaResponse(res); err != nil {
+			return nil, fmt.Errorf("normalize ollama response error: %v", err)
+		}
+	}
+	if p.style != consts.StyleOpenAI {
+		if res, err = tm.ProcessResponse(res); err != nil {
+			return nil, fmt.Errorf("transform response error: %v", err)
+		}
+	}
+	return res, nil
+}
+
+// complete 发送非流式请求并返回 OpenAI 格式的 choices.0.message
+func (p *prober) complete(ctx context.Context, body map[string]any) (gjson.Result, error) {
+	res, err := p.send(ctx, body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	message := gjson.GetBytes(data, "choices.0.message")
+	if !message.Exists() {
+		return gjson.Result{}, fmt.Errorf("unexpected response: %s", probeSnippet(string(data)))
+	}
+	return message, nil
+}
+
+// probeSnippet 截取响应片段写入探测结果
+func probeSnippet(s string) string {
+	if len(s) > 512 {
+		return s[:512] + "..."
+	}
+	return s
+}
+
+// fail 将结果标记为不支持
+func (r *ProbeResult) fail(format string, args ...any) {
+	r.Result, r.Detail = ProbeFailed, fmt.Sprintf(format, args...)
+}
+
+// probeStreaming 要求流式输出内容；各探测请求均不设置 max_tokens，推理模型不接受该参数，转换为 Anthropic 格式时使用默认值
+func (p *prober) probeStreaming(ctx context.Context, result *ProbeResult) error {
+	res, err := p.send(ctx, map[string]any{
+		"stream":   true,
+		"messages": []map[string]any{{"role": "user", "content": "Count from 1 to 5, separated by spaces."}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if contentType := res.Header.Get("Content-Type"); !strings.Contains(contentType, "text/event-stream") {
+		result.fail("response is not an event stream: %s", contentType)
+		return nil
+	}
+	chunks := 0
+	var content strings.Builder
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok || strings.TrimSpace(data) == "[DONE]" {
+			continue
+		}
+		chunks++
+		content.WriteString(gjson.Get(data, "choices.0.delta.content").String())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if chunks == 0 || content.Len() == 0 {
+		result.fail("no content chunks received (%d events)", chunks)
+	}
+	return nil
+}
+
+func (p *prober) probeToolCall(ctx context.Context, result *ProbeResult) error {
+	message, err := p.complete(ctx, map[string]any{
+		"messages": []map[string]any{{"role": "user", "content": "What is the weather in Paris? Use the get_weather tool."}},
+		"tools": []map[string]any{{
+			"type": "function",
+			"function": map[string]any{
+				"name":        "get_weather",
+				"description": "Get the current weather for a city",
+				"parameters": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"city": map[string]any{"type": "string"}},
+					"required":   []string{"city"},
+				},
+			},
+		}},
+		"tool_choice": "required",
+	})
+	if err != nil {
+		return err
+	}
+	call := message.Get("tool_calls.0.function")
+	if call.Get("name").String() != "get_weather" {
+		result.fail("no get_weather tool call in response: %s", probeSnippet(message.Raw))
+		return nil
+	}
+	if arguments := call.Get("arguments").String(); !gjson.Valid(arguments) || gjson.Get(arguments, "city").String() == "" {
+		result.fail("invalid tool call arguments: %s", probeSnippet(arguments))
+	}
+	return nil
+}
+
+func (p *prober) probeStructuredOutput(ctx context.Context, result *ProbeResult) error {
+	message, err := p.complete(ctx, map[string]any{
+		"messages": []map[string]any{{"role": "user", "content": "Give the capital of France and its country code."}},
+		"response_format": map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "capital",
+				"strict": true,
+				"schema": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"capital":      map[string]any{"type": "string"},
+						"country_code": map[string]any{"type": "string"},
+					},
+					"required":             []string{"capital", "country_code"},
+					"additionalProperties": false,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	content := strings.TrimSpace(message.Get("content").String())
+	parsed := gjson.Parse(content)
+	if !gjson.Valid(content) || !parsed.IsObject() || !parsed.Get("capital").Exists() || !parsed.Get("country_code").Exists() {
+		result.fail("response does not match the schema: %s", probeSnippet(content))
+	}
+	return nil
+}
+
+// probeImageURL 纯红色 PNG 的 data URL，用于视觉探测
+var probeImageURL = func() string {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := range 64 {
+		for y := range 64 {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}()
+
+func (p *prober) probeImage(ctx context.Context, result *ProbeResult) error {
+	message, err := p.complete(ctx, map[string]any{
+		"messages": []map[string]any{{
+			"role": "user",
+			"content": []map[string]any{
+				{"type": "text", "text": "What color is this image? Answer with one word."},
+				{"type": "image_url", "image_url": map[string]any{"url": probeImageURL}},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// 不支持视觉的模型可能忽略图片后随意作答
+	if content := message.Get("content").String(); !strings.Contains(strings.ToLower(content), "red") {
+		result.fail("image was not recognized: %s", probeSnippet(content))
+	}
+	return nil
+}
+
+func (p *prober) probeLongContext(ctx context.Context, result *ProbeResult, tokens int) error {
+	if tokens <= 0 {
+		tokens = defaultProbeLongContextTokens
+		if limit := p.mp.MaxContextLength() * 9 / 10; limit > 0 && limit < tokens {
+			tokens = limit
+		}
+	}
+	// 开头放置口令，其后以编号句子填充到目标长度，要求模型在末尾复述口令
+	const secret = "TANGERINE-4821"
+	var prompt strings.Builder
+	prompt.WriteString("Remember this passphrase: " + secret + ".\n")
+	for i := 1; prompt.Len() < tokens*probeCharsPerToken; i++ {
+		fmt.Fprintf(&prompt, "Line %d: the river flows past the old mill and the birds sing in the morning.\n", i)
+	}
+	prompt.WriteString("What is the passphrase given at the beginning? Reply with the passphrase only.")
+	message, err := p.complete(ctx, map[string]any{
+		"messages": []map[string]any{{"role": "user", "content": prompt.String()}},
+	})
+	if err != nil {
+		return err
+	}
+	if content := message.Get("content").String(); !strings.Contains(content, secret) {
+		result.fail("passphrase not recalled after ~%d tokens: %s", tokens, probeSnippet(content))
+	}
+	return nil
+}
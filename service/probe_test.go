@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestProbeModelProvider(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	for _, key := range []string{models.SettingKeyHealthCheckMaxQPS, models.SettingKeyHealthCheckProviderSpacing, models.SettingKeyHealthCheckJitter} {
+		if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(key)).Update("value", "0").Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 假上游：支持流式与工具调用，以 400 拒绝图片，结构化输出时返回普通文本
+	upstream := testutil.NewUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		raw := string(data)
+		switch {
+		case gjson.Get(raw, "stream").Bool():
+			testutil.SSE(testutil.OpenAIChatStream("gpt-test", 10, 5, "1 2 ", "3 4 5")...)(w, r)
+		case gjson.Get(raw, "tools").Exists():
+			testutil.JSON(http.StatusOK, `{"id":"chatcmpl-test","object":"chat.completion","model":"gpt-test","choices":[{"index":0,"message":{"role":"assistant","content":null,`+
+				`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`)(w, r)
+		case strings.Contains(raw, "image_url"):
+			testutil.JSON(http.StatusBadRequest, `{"error":{"message":"image input is not supported"}}`)(w, r)
+		case gjson.Get(raw, "response_format").Exists():
+			testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-test", "The capital of France is Paris.", 10, 5))(w, r)
+		default:
+			testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("gpt-test", "TANGERINE-4821", 10, 5))(w, r)
+		}
+	})
+	model := testutil.SeedModel(t, "gpt-test")
+	provider := testutil.SeedProvider(t, "openai", consts.StyleOpenAI, upstream.URL+"/v1")
+	association := testutil.SeedAssociation(t, model, provider, "gpt-test", 100, 1)
+
+	report, err := ProbeModelProvider(ctx, association.ID, ProbeOptions{Apply: true, LongContextTokens: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		ProbeStreaming:        ProbePassed,
+		ProbeToolCall:         ProbePassed,
+		ProbeStructuredOutput: ProbeFailed,
+		ProbeImage:            ProbeFailed,
+		ProbeLongContext:      ProbePassed,
+	}
+	if len(report.Results) != len(want) {
+		t.Fatalf("results = %+v", report.Results)
+	}
+	for _, result := range report.Results {
+		if result.Result != want[result.Name] {
+			t.Errorf("%s = %s (%s), want %s", result.Name, result.Result, result.Detail, want[result.Name])
+		}
+	}
+	if image := report.Results[3]; image.StatusCode != http.StatusBadRequest || !strings.Contains(image.Detail, "not supported") {
+		t.Errorf("image result = %+v", image)
+	}
+
+	var updated models.ModelWithProvider
+	if err := models.DB.First(&updated, association.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !*updated.ToolCall || *updated.StructuredOutput || *updated.Image {
+		t.Errorf("flags = tool_call %v, structured_output %v, image %v", *updated.ToolCall, *updated.StructuredOutput, *updated.Image)
+	}
+	if len(report.Applied) != 3 || report.Applied["tool_call"] != true || report.Applied["image"] != false {
+		t.Errorf("applied = %+v", report.Applied)
+	}
+
+	// 上游不可用时结果为 error，不修改能力字段
+	upstream.Close()
+	report, err = ProbeModelProvider(ctx, association.ID, ProbeOptions{Probes: []string{ProbeToolCall}, Apply: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 1 || report.Results[0].Result != ProbeError || report.Applied != nil {
+		t.Errorf("report with upstream down = %+v", report)
+	}
+	if err := models.DB.First(&updated, association.ID).Error; err != nil || !*updated.ToolCall {
+		t.Errorf("tool_call changed after failed probe: %v, %v", *updated.ToolCall, err)
+	}
+}
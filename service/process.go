@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"iter"
 	"strings"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/atopos31/llmio/models"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 const (
@@ -115,6 +117,30 @@ type AnthropicUsage struct {
 	CachedTokens     int64 `json:"cached_tokens"`
 }
 
+// merge 以 next 中的非零值覆盖当前用量
+func (u *AnthropicUsage) merge(next AnthropicUsage) {
+	for _, field := range []struct {
+		dst *int64
+		src int64
+	}{
+		{&u.InputTokens, next.InputTokens},
+		{&u.CacheCreationInputTokens, next.CacheCreationInputTokens},
+		{&u.CacheReadInputTokens, next.CacheReadInputTokens},
+		{&u.OutputTokens, next.OutputTokens},
+		{&u.PromptTokens, next.PromptTokens},
+		{&u.CompletionTokens, next.CompletionTokens},
+		{&u.TotalTokens, next.TotalTokens},
+		{&u.CachedTokens, next.CachedTokens},
+	} {
+		if field.src != 0 {
+			*field.dst = field.src
+		}
+	}
+	if next.ServiceTier != "" {
+		u.ServiceTier = next.ServiceTier
+	}
+}
+
 func ProcesserOpenAiRes(ctx context.Context, pr io.Reader, stream bool, start time.Time) (*models.ChatLog, *models.OutputUnion, error) {
 	// 首字时延
 	var firstChunkTime time.Duration
@@ -145,7 +171,8 @@ func ProcesserOpenAiRes(ctx context.Context, pr io.Reader, stream bool, start ti
 			continue
 		}
 		output.OfStringArray = append(output.OfStringArray, content)
-		if event == "response.completed" {
+		// 达到 max_output_tokens 时以 response.incomplete 结束，同样带有 usage
+		if event == "response.completed" || event == "response.incomplete" {
 			usageStr = gjson.Get(content, "response.usage").String()
 		}
 	}
@@ -189,7 +216,7 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 	var firstChunkTime time.Duration
 	var once sync.Once
 
-	var usageStr string
+	var usageStr, startUsageStr string
 
 	var output models.OutputUnion
 
@@ -217,7 +244,10 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 		}
 
 		output.OfStringArray = append(output.OfStringArray, after)
-		if event == "message_delta" {
+		switch event {
+		case "message_start":
+			startUsageStr = gjson.Get(after, "message.usage").String()
+		case "message_delta":
 			usageStr = gjson.Get(after, "usage").String()
 		}
 	}
@@ -225,16 +255,24 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 		return nil, nil, err
 	}
 
+	// 流式响应的输入与缓存用量在 message_start 中，message_delta 中的非零值覆盖
 	var athropicUsage AnthropicUsage
-	usage := []byte(usageStr)
-	if json.Valid(usage) {
-		if err := json.Unmarshal(usage, &athropicUsage); err != nil {
+	for _, raw := range []string{startUsageStr, usageStr} {
+		usage := []byte(raw)
+		if !json.Valid(usage) {
+			continue
+		}
+		var next AnthropicUsage
+		if err := json.Unmarshal(usage, &next); err != nil {
 			return nil, nil, err
 		}
+		athropicUsage.merge(next)
 	}
 
 	chunkTime := time.Since(start) - firstChunkTime
-	totalTokens := athropicUsage.InputTokens + athropicUsage.OutputTokens
+	// Anthropic 的 input_tokens 不含缓存读写部分，日志中的 prompt_tokens 与 OpenAI 一致包含缓存
+	promptTokens := athropicUsage.InputTokens + athropicUsage.CacheReadInputTokens + athropicUsage.CacheCreationInputTokens
+	totalTokens := promptTokens + athropicUsage.OutputTokens
 
 	// 计算 TPS，避免除零错误
 	var tps float64
@@ -246,17 +284,48 @@ func ProcesserAnthropic(ctx context.Context, pr io.Reader, stream bool, start ti
 		FirstChunkTime: firstChunkTime,
 		ChunkTime:      chunkTime,
 		Usage: models.Usage{
-			PromptTokens:     athropicUsage.InputTokens,
+			PromptTokens:     promptTokens,
 			CompletionTokens: athropicUsage.OutputTokens,
 			TotalTokens:      totalTokens,
 			PromptTokensDetails: models.PromptTokensDetails{
-				CachedTokens: athropicUsage.CacheReadInputTokens,
+				CachedTokens:        athropicUsage.CacheReadInputTokens,
+				CacheCreationTokens: athropicUsage.CacheCreationInputTokens,
 			},
 		},
 		Tps: tps,
 	}, &output, nil
 }
 
+// ProcesserEmbeddings 解析 embeddings 响应的用量，输出记录中去掉向量本身以免 IO 日志过大
+func ProcesserEmbeddings(ctx context.Context, pr io.Reader, stream bool, start time.Time) (*models.ChatLog, *models.OutputUnion, error) {
+	body, err := io.ReadAll(pr)
+	if err != nil {
+		return nil, nil, err
+	}
+	firstChunkTime := time.Since(start)
+
+	var usage models.Usage
+	if usageStr := gjson.GetBytes(body, "usage").Raw; json.Valid([]byte(usageStr)) {
+		if err := json.Unmarshal([]byte(usageStr), &usage); err != nil {
+			return nil, nil, err
+		}
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens
+	}
+
+	for i := range len(gjson.GetBytes(body, "data").Array()) {
+		if body, err = sjson.DeleteBytes(body, fmt.Sprintf("data.%d.embedding", i)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &models.ChatLog{
+		FirstChunkTime: firstChunkTime,
+		Usage:          usage,
+	}, &models.OutputUnion{OfString: string(body)}, nil
+}
+
 func ScannerToken(reader *bufio.Scanner) iter.Seq[string] {
 	return func(yield func(string) bool) {
 		for reader.Scan() {
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// 请求带有 cache_control 提示缓存标记时的路由方式
+const (
+	PromptCacheRoutingOff    = "off"    // 原样转发，不区分关联是否支持
+	PromptCacheRoutingStrip  = "strip"  // 发往不支持提示缓存的关联前移除 cache_control
+	PromptCacheRoutingPrefer = "prefer" // 优先选择支持提示缓存的关联，没有时退回 strip
+)
+
+// ValidPromptCacheRouting 判断提示缓存路由方式是否有效
+func ValidPromptCacheRouting(mode string) bool {
+	switch mode {
+	case PromptCacheRoutingOff, PromptCacheRoutingStrip, PromptCacheRoutingPrefer:
+		return true
+	}
+	return false
+}
+
+// getPromptCacheRouting 获取提示缓存路由方式，未设置或无效时关闭
+func getPromptCacheRouting(ctx context.Context) string {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyPromptCacheRouting)).First(ctx)
+	if err != nil || !ValidPromptCacheRouting(setting.Value) {
+		return PromptCacheRoutingOff
+	}
+	return setting.Value
+}
+
+// hasCacheControl 判断请求体中是否带有 cache_control 标记
+func hasCacheControl(data []byte) bool {
+	if !bytes.Contains(data, []byte(`"cache_control"`)) {
+		return false
+	}
+	return len(cacheControlPaths(gjson.ParseBytes(data), "")) > 0
+}
+
+// cacheControlPaths 递归查找所有 cache_control 字段的路径
+func cacheControlPaths(value gjson.Result, prefix string) []string {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	var paths []string
+	if value.IsArray() {
+		for i, item := range value.Array() {
+			paths = append(paths, cacheControlPaths(item, join(strconv.Itoa(i)))...)
+		}
+	} else if value.IsObject() {
+		value.ForEach(func(key, item gjson.Result) bool {
+			if key.String() == "cache_control" {
+				paths = append(paths, join("cache_control"))
+			} else {
+				paths = append(paths, cacheControlPaths(item, join(escapeRedactionKey(key.String())))...)
+			}
+			return true
+		})
+	}
+	return paths
+}
+
+// StripCacheControl 移除请求体中所有 cache_control 字段，返回移除的个数
+func StripCacheControl(body []byte) ([]byte, int, error) {
+	if !bytes.Contains(body, []byte(`"cache_control"`)) {
+		return body, 0, nil
+	}
+	paths := cacheControlPaths(gjson.ParseBytes(body), "")
+	for _, path := range paths {
+		var err error
+		if body, err = sjson.DeleteBytes(body, path); err != nil {
+			return nil, 0, err
+		}
+	}
+	return body, len(paths), nil
+}
+
+// getPromptCacheAutoInject 获取是否为 Anthropic 请求自动添加提示缓存断点
+func getPromptCacheAutoInject(ctx context.Context) bool {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyPromptCacheAutoInject)).First(ctx)
+	if err != nil {
+		return false
+	}
+	return setting.Value == "true"
+}
+
+// InjectCacheBreakpoints 在 Anthropic 请求的最后一个工具定义、system 与最后一条用户消息末尾添加提示缓存断点，
+// 请求已带有 cache_control 时保持原样，返回添加的断点数
+func InjectCacheBreakpoints(body []byte) ([]byte, int, error) {
+	if hasCacheControl(body) {
+		return body, 0, nil
+	}
+	textBlock := func(text string) []map[string]string {
+		return []map[string]string{{"type": "text", "text": text}}
+	}
+	var err error
+	var paths []string
+	if n := len(gjson.GetBytes(body, "tools").Array()); n > 0 {
+		paths = append(paths, fmt.Sprintf("tools.%d.cache_control", n-1))
+	}
+	// 字符串形式的 system 与消息内容改为内容块才能携带 cache_control
+	system := gjson.GetBytes(body, "system")
+	if system.Type == gjson.String && system.String() != "" {
+		if body, err = sjson.SetBytes(body, "system", textBlock(system.String())); err != nil {
+			return nil, 0, err
+		}
+		paths = append(paths, "system.0.cache_control")
+	} else if n := len(system.Array()); system.IsArray() && n > 0 {
+		paths = append(paths, fmt.Sprintf("system.%d.cache_control", n-1))
+	}
+	messages := gjson.GetBytes(body, "messages").Array()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Get("role").String() != "user" {
+			continue
+		}
+		content := messages[i].Get("content")
+		if content.Type == gjson.String && content.String() != "" {
+			if body, err = sjson.SetBytes(body, fmt.Sprintf("messages.%d.content", i), textBlock(content.String())); err != nil {
+				return nil, 0, err
+			}
+			paths = append(paths, fmt.Sprintf("messages.%d.content.0.cache_control", i))
+		} else if n := len(content.Array()); content.IsArray() && n > 0 {
+			paths = append(paths, fmt.Sprintf("messages.%d.content.%d.cache_control", i, n-1))
+		}
+		break
+	}
+	for _, path := range paths {
+		if body, err = sjson.SetBytes(body, path, map[string]string{"type": "ephemeral"}); err != nil {
+			return nil, 0, err
+		}
+	}
+	return body, len(paths), nil
+}
+
+// preferPromptCache 请求带有 cache_control 时只保留支持提示缓存的关联，均不支持时保持原候选
+func preferPromptCache(weightItems, priorityItems map[uint]int, modelWithProviderMap map[uint]models.ModelWithProvider) {
+	capable := 0
+	for id := range weightItems {
+		if supportsPromptCache(modelWithProviderMap[id]) {
+			capable++
+		}
+	}
+	if capable == 0 || capable == len(weightItems) {
+		return
+	}
+	for id := range weightItems {
+		if !supportsPromptCache(modelWithProviderMap[id]) {
+			delete(weightItems, id)
+			delete(priorityItems, id)
+		}
+	}
+}
+
+func supportsPromptCache(mp models.ModelWithProvider) bool {
+	return mp.PromptCache != nil && *mp.PromptCache
+}
+
+// CacheMetric 按供应商汇总的提示缓存用量
+type CacheMetric struct {
+	ProviderName        string  `json:"provider_name"`
+	Requests            int64   `json:"requests"`
+	CachedRequests      int64   `json:"cached_requests"` // 命中缓存（读取 token 大于 0）的请求数
+	PromptTokens        int64   `json:"prompt_tokens"`   // 输入 token，包含缓存读写部分
+	CacheReadTokens     int64   `json:"cache_read_tokens"`
+	CacheCreationTokens int64   `json:"cache_creation_tokens"`
+	HitRate             float64 `json:"hit_rate"` // 缓存读取 token 占输入 token 的比例
+}
+
+// GetCacheMetrics 汇总最近 days 天成功请求的提示缓存用量，按输入 token 从多到少排列
+func GetCacheMetrics(ctx context.Context, days int) ([]CacheMetric, error) {
+	now := time.Now()
+	year, month, day := now.Date()
+	since := time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days)
+
+	// 缓存用量保存在 JSON 列中，按供应商在内存中汇总
+	logs, err := gorm.G[models.ChatLog](models.DB).
+		Select("provider_name", "prompt_tokens", "prompt_tokens_details", "sample_weight").
+		Where("created_at >= ? AND status = ?", since, "success").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byProvider := make(map[string]*CacheMetric)
+	for _, log := range logs {
+		metric, ok := byProvider[log.ProviderName]
+		if !ok {
+			metric = &CacheMetric{ProviderName: log.ProviderName}
+			byProvider[log.ProviderName] = metric
+		}
+		weight := int64(max(log.SampleWeight, 1))
+		details := log.PromptTokensDetails
+		metric.Requests += weight
+		if details.CachedTokens > 0 {
+			metric.CachedRequests += weight
+		}
+		metric.PromptTokens += log.PromptTokens * weight
+		metric.CacheReadTokens += details.CachedTokens * weight
+		metric.CacheCreationTokens += details.CacheCreationTokens * weight
+	}
+	metrics := make([]CacheMetric, 0, len(byProvider))
+	for _, metric := range byProvider {
+		if metric.PromptTokens > 0 {
+			metric.HitRate = float64(metric.CacheReadTokens) / float64(metric.PromptTokens)
+		}
+		metrics = append(metrics, *metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].PromptTokens != metrics[j].PromptTokens {
+			return metrics[i].PromptTokens > metrics[j].PromptTokens
+		}
+		return metrics[i].ProviderName < metrics[j].ProviderName
+	})
+	return metrics, nil
+}
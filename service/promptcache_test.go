@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+)
+
+func TestStripCacheControl(t *testing.T) {
+	body := []byte(`{"system":[{"type":"text","text":"sys","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":[{"type":"text","text":"mentions \"cache_control\"","cache_control":{"type":"ephemeral"}}]}],"tools":[{"name":"a.b","input_schema":{},"cache_control":{"type":"ephemeral"}}]}`)
+	if !hasCacheControl(body) {
+		t.Fatal("Expected cache_control to be detected")
+	}
+	stripped, count, err := StripCacheControl(body)
+	if err != nil {
+		t.Fatalf("StripCacheControl failed: %v", err)
+	}
+	if count != 3 || hasCacheControl(stripped) {
+		t.Errorf("Expected all cache_control fields to be removed, count = %d, body = %s", count, stripped)
+	}
+	if gjson.GetBytes(stripped, "messages.0.content.0.text").String() != `mentions "cache_control"` || gjson.GetBytes(stripped, "tools.0.name").String() != "a.b" {
+		t.Errorf("Expected other fields to be kept, got %s", stripped)
+	}
+	// 仅在文本中出现的 cache_control 不算标记
+	if hasCacheControl([]byte(`{"messages":[{"role":"user","content":"\"cache_control\""}]}`)) {
+		t.Error("Expected cache_control inside text not to be detected")
+	}
+}
+
+func TestPreferPromptCache(t *testing.T) {
+	associations := map[uint]models.ModelWithProvider{
+		1: {PromptCache: lo.ToPtr(true)},
+		2: {PromptCache: lo.ToPtr(false)},
+		3: {},
+	}
+	weights, priorities := map[uint]int{1: 1, 2: 1, 3: 1}, map[uint]int{1: 100, 2: 200, 3: 300}
+	preferPromptCache(weights, priorities, associations)
+	if len(weights) != 1 || len(priorities) != 1 || weights[1] != 1 {
+		t.Errorf("Expected only the cache-capable association, got %v %v", weights, priorities)
+	}
+
+	weights, priorities = map[uint]int{2: 1, 3: 1}, map[uint]int{2: 200, 3: 300}
+	preferPromptCache(weights, priorities, associations)
+	if len(weights) != 2 || len(priorities) != 2 {
+		t.Errorf("Expected candidates to be kept when none supports prompt caching, got %v %v", weights, priorities)
+	}
+}
+
+func TestOpenAIToAnthropicKeepsCacheControl(t *testing.T) {
+	body := []byte(`{"model":"m","messages":[{"role":"system","content":[{"type":"text","text":"rules","cache_control":{"type":"ephemeral"}}]},` +
+		`{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}}]},` +
+		`{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},` +
+		`{"role":"tool","tool_call_id":"call_1","content":[{"type":"text","text":"result","cache_control":{"type":"ephemeral"}}]}],` +
+		`"tools":[{"type":"function","function":{"name":"f","parameters":{}},"cache_control":{"type":"ephemeral"}}]}`)
+	unified, err := TransformOpenAIToUnified(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := TransformUnifiedToAnthropic(unified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"system.0", "messages.0.content.0", "messages.2.content.0.content.0", "tools.0"} {
+		if gjson.GetBytes(converted, path+".cache_control.type").String() != "ephemeral" {
+			t.Errorf("Expected cache_control at %s, got %s", path, converted)
+		}
+	}
+	if gjson.GetBytes(converted, "system.0.text").String() != "rules" {
+		t.Errorf("Expected system text to be kept, got %s", converted)
+	}
+}
+
+func TestInjectCacheBreakpoints(t *testing.T) {
+	body := []byte(`{"system":"rules","tools":[{"name":"a"},{"name":"b"}],"messages":[{"role":"user","content":"first"},{"role":"assistant","content":"ok"},{"role":"user","content":[{"type":"text","text":"1"},{"type":"text","text":"2"}]}]}`)
+	injected, count, err := InjectCacheBreakpoints(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 breakpoints, got %d: %s", count, injected)
+	}
+	for _, path := range []string{"system.0", "tools.1", "messages.2.content.1"} {
+		if !gjson.GetBytes(injected, path+".cache_control").Exists() {
+			t.Errorf("Expected cache_control at %s, got %s", path, injected)
+		}
+	}
+	if gjson.GetBytes(injected, "system.0.text").String() != "rules" || gjson.GetBytes(injected, "messages.0.content").String() != "first" {
+		t.Errorf("Expected content to be kept, got %s", injected)
+	}
+	// 客户端已设置断点时不再添加
+	if again, count, _ := InjectCacheBreakpoints(injected); count != 0 || string(again) != string(injected) {
+		t.Errorf("Expected request with cache_control to be unchanged")
+	}
+}
+
+func TestAnthropicUsageRoundTrip(t *testing.T) {
+	usage := parseAnthropicUsage(map[string]interface{}{"input_tokens": 20.0, "cache_read_input_tokens": 70.0, "cache_creation_input_tokens": 10.0, "output_tokens": 5.0})
+	if usage.PromptTokens != 100 || usage.TotalTokens != 105 || usage.PromptTokensDetails.CachedTokens != 70 || usage.PromptTokensDetails.CacheCreationTokens != 10 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if formatted := formatAnthropicUsage(usage); formatted["input_tokens"] != int64(20) || formatted["cache_creation_input_tokens"] != int64(10) {
+		t.Errorf("formatted usage = %v", formatted)
+	}
+}
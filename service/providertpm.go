@@ -0,0 +1,157 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// providerTPMWindow 供应商 TPM 的滚动统计窗口
+const providerTPMWindow = time.Minute
+
+// tpmEntry 一次发往供应商的请求占用的 token 数，发送时按估算值预占，完成后改为实际用量
+type tpmEntry struct {
+	at     time.Time
+	tokens int
+}
+
+// providerTPMTracker 按供应商统计最近一分钟发送的 token 数，供发送前预测是否会超出供应商 TPM 上限
+type providerTPMTracker struct {
+	mu      sync.Mutex
+	entries map[uint][]*tpmEntry
+}
+
+var providerTPM = &providerTPMTracker{entries: make(map[uint][]*tpmEntry)}
+
+// reserve 请求发送前按估算 token 数预占额度，未配置上限的供应商不记录
+func (t *providerTPMTracker) reserve(provider models.Provider, tokens int, now time.Time) *tpmEntry {
+	if provider.TPMLimit <= 0 {
+		return nil
+	}
+	entry := &tpmEntry{at: now, tokens: tokens}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[provider.ID] = append(t.prune(provider.ID, now), entry)
+	return entry
+}
+
+// settle 以实际用量替换预占值，请求失败时传 0 归还额度
+func (t *providerTPMTracker) settle(entry *tpmEntry, tokens int) {
+	if entry == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry.tokens = tokens
+}
+
+// prune 移除窗口外的记录，调用方持有锁
+func (t *providerTPMTracker) prune(provider uint, now time.Time) []*tpmEntry {
+	entries := t.entries[provider]
+	i := 0
+	for i < len(entries) && now.Sub(entries[i].at) >= providerTPMWindow {
+		i++
+	}
+	entries = entries[i:]
+	if len(entries) == 0 {
+		delete(t.entries, provider)
+		return nil
+	}
+	t.entries[provider] = entries
+	return entries
+}
+
+// wait 返回供应商再接收 tokens 个 token 前需要等待的时长，0 表示可以立即发送；
+// 窗口为空时总是放行，避免单个超过上限的请求永远无法发送
+func (t *providerTPMTracker) wait(provider models.Provider, tokens int, now time.Time) time.Duration {
+	if provider.TPMLimit <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := t.prune(provider.ID, now)
+	used := 0
+	for _, entry := range entries {
+		used += entry.tokens
+	}
+	if used <= 0 || used+tokens <= provider.TPMLimit {
+		return 0
+	}
+	// 记录按发送时间排列，依次推算滑出窗口后的用量
+	for _, entry := range entries {
+		used -= entry.tokens
+		if used <= 0 || used+tokens <= provider.TPMLimit {
+			return entry.at.Add(providerTPMWindow).Sub(now)
+		}
+	}
+	return 0
+}
+
+// usage 各供应商最近一分钟的 token 用量，含进行中请求的预占
+func (t *providerTPMTracker) usage(now time.Time) map[uint]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	usage := make(map[uint]int, len(t.entries))
+	for provider := range t.entries {
+		for _, entry := range t.prune(provider, now) {
+			usage[provider] += entry.tokens
+		}
+	}
+	return usage
+}
+
+// ProviderTPMUsage 各供应商最近一分钟的 token 用量，含进行中请求的预占
+func ProviderTPMUsage() map[uint]int {
+	return providerTPM.usage(time.Now())
+}
+
+// tpmCandidates 排除发送后预计超出供应商 TPM 上限的关联，供负载均衡选择；
+// 全部候选均超限时返回空集合与最早可发送的等待时长
+func tpmCandidates(candidates *candidateSet, meta ProvidersWithMeta, tokens int, now time.Time) (*candidateSet, time.Duration) {
+	var throttled []uint
+	var minWait time.Duration
+	for id := range candidates.weights {
+		mp, ok := meta.ModelWithProviderMap[id]
+		if !ok {
+			continue
+		}
+		if wait := providerTPM.wait(meta.ProviderMap[mp.ProviderID], tokens, now); wait > 0 {
+			throttled = append(throttled, id)
+			if minWait == 0 || wait < minWait {
+				minWait = wait
+			}
+		}
+	}
+	if len(throttled) == 0 {
+		return candidates, 0
+	}
+	available := candidates.without(throttled)
+	if available.len() > 0 {
+		return available, 0
+	}
+	return available, minWait
+}
+
+// TPMReservation 请求最终命中的供应商 TPM 预占，由调用方创建，响应处理完成后按实际用量结算
+type TPMReservation struct {
+	entry *tpmEntry
+}
+
+// NewTPMReservation 创建供应商 TPM 预占记录
+func NewTPMReservation() *TPMReservation {
+	return &TPMReservation{}
+}
+
+func (r *TPMReservation) hold(entry *tpmEntry) {
+	if r != nil {
+		r.entry = entry
+	}
+}
+
+// settle 以实际 token 用量替换预占值，未命中限额供应商时不做处理
+func (r *TPMReservation) settle(tokens int64) {
+	if r != nil {
+		providerTPM.settle(r.entry, int(tokens))
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+func TestProviderTPMWait(t *testing.T) {
+	tracker := &providerTPMTracker{entries: make(map[uint][]*tpmEntry)}
+	provider := models.Provider{Model: gorm.Model{ID: 1}, TPMLimit: 1000}
+	now := time.Now()
+
+	if entry := tracker.reserve(models.Provider{Model: gorm.Model{ID: 2}}, 500, now); entry != nil {
+		t.Errorf("Expected provider without limit not to be tracked")
+	}
+
+	first := tracker.reserve(provider, 600, now.Add(-50*time.Second))
+	tracker.reserve(provider, 300, now.Add(-10*time.Second))
+	if wait := tracker.wait(provider, 100, now); wait != 0 {
+		t.Errorf("Expected request within limit to be sent immediately, got %v", wait)
+	}
+	// 大请求需等待第一条记录滑出窗口
+	if wait := tracker.wait(provider, 400, now); wait != 10*time.Second {
+		t.Errorf("Expected large request to wait 10s, got %v", wait)
+	}
+
+	// 实际用量低于预占时提前释放额度
+	tracker.settle(first, 200)
+	if wait := tracker.wait(provider, 400, now); wait != 0 {
+		t.Errorf("Expected settled usage to free capacity, got %v", wait)
+	}
+	if used := tracker.usage(now)[provider.ID]; used != 500 {
+		t.Errorf("Expected usage 500, got %d", used)
+	}
+	if used := tracker.usage(now.Add(55 * time.Second))[provider.ID]; used != 0 {
+		t.Errorf("Expected entries outside the window to be pruned, got %d", used)
+	}
+
+	// 窗口为空时超过上限的请求也放行
+	if wait := tracker.wait(provider, 5000, now.Add(time.Minute)); wait != 0 {
+		t.Errorf("Expected oversized request to pass on an idle provider, got %v", wait)
+	}
+}
+
+func TestTPMCandidates(t *testing.T) {
+	saved := providerTPM
+	providerTPM = &providerTPMTracker{entries: make(map[uint][]*tpmEntry)}
+	t.Cleanup(func() { providerTPM = saved })
+
+	busy := models.Provider{Model: gorm.Model{ID: 1}, TPMLimit: 1000}
+	idle := models.Provider{Model: gorm.Model{ID: 2}}
+	meta := ProvidersWithMeta{
+		ModelWithProviderMap: map[uint]models.ModelWithProvider{
+			10: {ProviderID: busy.ID},
+			20: {ProviderID: idle.ID},
+		},
+		ProviderMap: map[uint]models.Provider{busy.ID: busy, idle.ID: idle},
+	}
+	now := time.Now()
+	providerTPM.reserve(busy, 900, now.Add(-30*time.Second))
+
+	candidates := newCandidateSet(map[uint]int{10: 1, 20: 1}, map[uint]int{10: 1, 20: 1})
+	available, wait := tpmCandidates(candidates, meta, 50, now)
+	if available.len() != 2 || wait != 0 {
+		t.Errorf("Expected small request to keep both candidates, got %v %v", available.weights, wait)
+	}
+
+	available, wait = tpmCandidates(candidates, meta, 500, now)
+	if available.has(10) || available.len() != 1 || len(available.priorities) != 1 || wait != 0 {
+		t.Errorf("Expected large request to be routed away from the busy provider, got %v %v %v", available.weights, available.priorities, wait)
+	}
+	if candidates.len() != 2 {
+		t.Errorf("Expected throttled candidates to stay in the request's set, got %v", candidates.weights)
+	}
+
+	available, wait = tpmCandidates(newCandidateSet(map[uint]int{10: 1}, map[uint]int{10: 1}), meta, 500, now)
+	if available.len() != 0 || wait != 30*time.Second {
+		t.Errorf("Expected large request to be deferred 30s, got %v %v", available.weights, wait)
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRequestQuarantineThreshold = 0   // 默认关闭请求隔离
+	defaultRequestQuarantineCooldown  = 600 // 默认隔离 10 分钟
+	requestQuarantineMaxEntries       = 10000
+)
+
+// UpstreamError 所有候选供应商均失败时返回的错误，保留最后一次上游错误供请求隔离使用
+type UpstreamError struct {
+	Err      error
+	Last     string // 最后一次上游错误
+	Rejected bool   // 所有上游失败都是针对请求内容的拒绝（如内容审核、参数错误）
+}
+
+func (e *UpstreamError) Error() string { return e.Err.Error() }
+
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// rejectedStatus 上游状态码是否表示请求内容本身被拒绝，换供应商重试通常也会失败
+func rejectedStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
+
+// QuarantineError 请求因重复失败被隔离，冷却期内直接返回缓存的上游错误
+type QuarantineError struct {
+	Until time.Time
+	Last  string
+}
+
+func (e *QuarantineError) Error() string {
+	return "request quarantined after repeated failures: " + e.Last
+}
+
+// QuarantineEntry 单个请求指纹的失败记录
+type QuarantineEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	Model       string    `json:"model"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error"`
+	LastFailure time.Time `json:"last_failure"`
+	Until       time.Time `json:"until"` // 隔离截止时间，零值表示尚未隔离
+	Hits        int       `json:"hits"`  // 隔离期间被拦截的请求数
+}
+
+// requestQuarantine 记录所有供应商都拒绝的请求，重复失败达到阈值后在冷却期内直接拦截相同请求体；
+// 配置 Redis 时失败记录保存在 Redis 中，由各实例共享
+type requestQuarantine struct {
+	mu      sync.Mutex
+	entries map[string]*QuarantineEntry
+}
+
+var requestQuarantines = &requestQuarantine{entries: make(map[string]*QuarantineEntry)}
+
+// requestFingerprint 以客户端格式、模型与原始请求体计算指纹
+func requestFingerprint(style string, before Before) string {
+	hash := sha256.New()
+	hash.Write([]byte(style + "\x00" + before.Model + "\x00"))
+	hash.Write(before.raw)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// check 请求处于隔离期时返回 QuarantineError
+func (q *requestQuarantine) check(style string, before Before) error {
+	if redisClient != nil {
+		return checkSharedQuarantine(requestFingerprint(style, before))
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// 没有失败记录时跳过指纹计算
+	if len(q.entries) == 0 {
+		return nil
+	}
+	entry, ok := q.entries[requestFingerprint(style, before)]
+	if !ok || time.Now().After(entry.Until) {
+		return nil
+	}
+	entry.Hits++
+	return &QuarantineError{Until: entry.Until, Last: entry.LastError}
+}
+
+// record 根据本次结果更新失败记录：内容被拒绝时累计失败次数，达到阈值后开始隔离；成功或其他失败清除记录
+func (q *requestQuarantine) record(ctx context.Context, style string, before Before, err error) {
+	var upstreamErr *UpstreamError
+	rejected := errors.As(err, &upstreamErr) && upstreamErr.Rejected
+	if redisClient != nil {
+		recordSharedQuarantine(ctx, requestFingerprint(style, before), before.Model, rejected, upstreamErr)
+		return
+	}
+	q.mu.Lock()
+	empty := len(q.entries) == 0
+	q.mu.Unlock()
+	if !rejected && empty {
+		return
+	}
+
+	fingerprint := requestFingerprint(style, before)
+	if !rejected {
+		q.mu.Lock()
+		delete(q.entries, fingerprint)
+		q.mu.Unlock()
+		return
+	}
+
+	threshold, cooldown := GetRequestQuarantineSettings(ctx)
+	if threshold <= 0 {
+		return
+	}
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.entries[fingerprint]
+	// 距上次失败超过冷却时间的记录重新计数
+	if !ok || now.Sub(entry.LastFailure) > cooldown {
+		if len(q.entries) >= requestQuarantineMaxEntries {
+			q.pruneLocked(now, cooldown)
+		}
+		entry = &QuarantineEntry{Fingerprint: fingerprint, Model: before.Model}
+		q.entries[fingerprint] = entry
+	}
+	entry.Failures++
+	entry.LastError = upstreamErr.Last
+	entry.LastFailure = now
+	if entry.Failures >= threshold {
+		entry.Until = now.Add(cooldown)
+		slog.Warn("request quarantined", "model", before.Model, "fingerprint", fingerprint, "failures", entry.Failures, "until", entry.Until)
+	}
+}
+
+// pruneLocked 清理已过期的记录，仍然超出上限时清空，调用方需持有锁
+func (q *requestQuarantine) pruneLocked(now time.Time, cooldown time.Duration) {
+	for fingerprint, entry := range q.entries {
+		if now.After(entry.Until) && now.Sub(entry.LastFailure) > cooldown {
+			delete(q.entries, fingerprint)
+		}
+	}
+	if len(q.entries) >= requestQuarantineMaxEntries {
+		clear(q.entries)
+	}
+}
+
+// QuarantineEntries 返回当前的失败记录，按最近失败时间倒序
+func QuarantineEntries() []QuarantineEntry {
+	if redisClient != nil {
+		return sharedQuarantineEntries()
+	}
+	requestQuarantines.mu.Lock()
+	defer requestQuarantines.mu.Unlock()
+	entries := make([]QuarantineEntry, 0, len(requestQuarantines.entries))
+	for _, entry := range requestQuarantines.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastFailure.After(entries[j].LastFailure) })
+	return entries
+}
+
+// ReleaseQuarantine 解除单个请求指纹的隔离，返回是否存在该记录
+func ReleaseQuarantine(fingerprint string) bool {
+	if redisClient != nil {
+		deleted, err := redisClient.Del(context.Background(), redisKey("quarantine", fingerprint)).Result()
+		if err != nil {
+			slog.Error("redis quarantine release failed", "error", err)
+		}
+		return deleted > 0
+	}
+	requestQuarantines.mu.Lock()
+	defer requestQuarantines.mu.Unlock()
+	_, ok := requestQuarantines.entries[fingerprint]
+	delete(requestQuarantines.entries, fingerprint)
+	return ok
+}
+
+// clearRequestQuarantines 清空所有失败记录
+func clearRequestQuarantines(ctx context.Context) {
+	if redisClient != nil {
+		keys, err := scanRedisKeys(ctx, redisKey("quarantine", "*"))
+		if err == nil && len(keys) > 0 {
+			err = redisClient.Del(ctx, keys...).Err()
+		}
+		if err != nil {
+			slog.Error("redis quarantine clear failed", "error", err)
+		}
+		return
+	}
+	requestQuarantines.mu.Lock()
+	clear(requestQuarantines.entries)
+	requestQuarantines.mu.Unlock()
+}
+
+// loadSharedQuarantine 读取 Redis 中的失败记录，不存在时返回 nil
+func loadSharedQuarantine(ctx context.Context, key string) (*QuarantineEntry, error) {
+	data, err := redisClient.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry QuarantineEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// checkSharedQuarantine Redis 不可用时放行请求
+func checkSharedQuarantine(fingerprint string) error {
+	ctx := context.Background()
+	key := redisKey("quarantine", fingerprint)
+	entry, err := loadSharedQuarantine(ctx, key)
+	if err != nil {
+		slog.Error("redis quarantine lookup failed", "error", err)
+		return nil
+	}
+	if entry == nil || time.Now().After(entry.Until) {
+		return nil
+	}
+	entry.Hits++
+	if data, err := json.Marshal(entry); err == nil {
+		redisClient.SetArgs(ctx, key, data, redis.SetArgs{KeepTTL: true})
+	}
+	return &QuarantineError{Until: entry.Until, Last: entry.LastError}
+}
+
+// recordSharedQuarantine 与 record 相同的计数规则，记录在冷却时间后自动过期
+func recordSharedQuarantine(ctx context.Context, fingerprint, model string, rejected bool, upstreamErr *UpstreamError) {
+	key := redisKey("quarantine", fingerprint)
+	if !rejected {
+		if err := redisClient.Del(ctx, key).Err(); err != nil {
+			slog.Error("redis quarantine clear failed", "error", err)
+		}
+		return
+	}
+	threshold, cooldown := GetRequestQuarantineSettings(ctx)
+	if threshold <= 0 || cooldown <= 0 {
+		return
+	}
+	entry, err := loadSharedQuarantine(ctx, key)
+	if err != nil {
+		slog.Error("redis quarantine lookup failed", "error", err)
+		return
+	}
+	now := time.Now()
+	if entry == nil || now.Sub(entry.LastFailure) > cooldown {
+		entry = &QuarantineEntry{Fingerprint: fingerprint, Model: model}
+	}
+	entry.Failures++
+	entry.LastError = upstreamErr.Last
+	entry.LastFailure = now
+	if entry.Failures >= threshold {
+		entry.Until = now.Add(cooldown)
+		slog.Warn("request quarantined", "model", model, "fingerprint", fingerprint, "failures", entry.Failures, "until", entry.Until)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := redisClient.Set(ctx, key, data, cooldown).Err(); err != nil {
+		slog.Error("redis quarantine update failed", "error", err)
+	}
+}
+
+func sharedQuarantineEntries() []QuarantineEntry {
+	ctx := context.Background()
+	entries := make([]QuarantineEntry, 0)
+	keys, err := scanRedisKeys(ctx, redisKey("quarantine", "*"))
+	if err != nil {
+		slog.Error("redis quarantine scan failed", "error", err)
+		return entries
+	}
+	for _, key := range keys {
+		if entry, err := loadSharedQuarantine(ctx, key); err == nil && entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastFailure.After(entries[j].LastFailure) })
+	return entries
+}
+
+// GetRequestQuarantineSettings 获取请求隔离的失败次数阈值与冷却时间，阈值为 0 表示关闭
+func GetRequestQuarantineSettings(ctx context.Context) (int, time.Duration) {
+	threshold := getIntSetting(ctx, models.SettingKeyRequestQuarantineThreshold, defaultRequestQuarantineThreshold)
+	cooldown := getIntSetting(ctx, models.SettingKeyRequestQuarantineCooldown, defaultRequestQuarantineCooldown)
+	return threshold, time.Duration(cooldown) * time.Second
+}
+
+// getIntSetting 读取非负整数设置，不存在或无效时返回默认值
+func getIntSetting(ctx context.Context, key string, defaultValue int) int {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(key)).First(ctx)
+	if err != nil {
+		return defaultValue
+	}
+	val, err := strconv.Atoi(setting.Value)
+	if err != nil || val < 0 {
+		return defaultValue
+	}
+	return val
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultRateLimitSnapshotInterval = 30 // 秒
+	rateLimitCheckInterval           = 5 * time.Second
+)
+
+// RateLimitError 超出限流时返回，RetryAfter 为距离窗口重置的时间
+type RateLimitError struct {
+	Scope      string // key / model
+	Kind       string // requests / tokens
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s %s per minute limit %d exceeded", e.Scope, e.Kind, e.Limit)
+}
+
+// Message 面向客户端的错误信息（可翻译）
+func (e *RateLimitError) Message() string {
+	switch {
+	case e.Scope == rateLimitScopeKey && e.Kind == rateLimitKindRequests:
+		return "API key request rate limit exceeded"
+	case e.Scope == rateLimitScopeKey:
+		return "API key token rate limit exceeded"
+	case e.Kind == rateLimitKindRequests:
+		return "Model request rate limit exceeded"
+	default:
+		return "Model token rate limit exceeded"
+	}
+}
+
+const (
+	rateLimitScopeKey     = "key"
+	rateLimitScopeModel   = "model"
+	rateLimitKindRequests = "requests"
+	rateLimitKindTokens   = "tokens"
+)
+
+// RateLimitTarget 一次请求需要校验的限流对象
+type RateLimitTarget struct {
+	APIKeyID uint
+	KeyRPM   int
+	KeyTPM   int
+	Model    string
+	ModelRPM int
+	ModelTPM int
+}
+
+// RateLimitUsage 限流窗口当前用量
+type RateLimitUsage struct {
+	Key       string    `json:"key"`
+	Requests  int64     `json:"requests"`
+	Tokens    int64     `json:"tokens"`
+	WindowEnd time.Time `json:"window_end"`
+}
+
+// rateLimitCheck 一次请求需要校验的单个限流维度
+type rateLimitCheck struct {
+	key   string
+	scope string
+	rpm   int
+	tpm   int
+}
+
+type rateWindow struct {
+	minute   int64
+	requests int64
+	tokens   int64
+}
+
+// RateLimiter 按分钟固定窗口统计请求数与 token 数，计数保存在内存中并定期写入数据库；配置 Redis 时计数保存在 Redis 中，由各实例共享
+type RateLimiter struct {
+	mu           sync.Mutex
+	windows      map[string]*rateWindow
+	dirty        bool
+	lastSnapshot time.Time
+	now          func() time.Time
+}
+
+var (
+	rateLimiter     *RateLimiter
+	rateLimiterOnce sync.Once
+)
+
+// GetRateLimiter 获取限流器单例
+func GetRateLimiter() *RateLimiter {
+	rateLimiterOnce.Do(func() {
+		rateLimiter = &RateLimiter{
+			windows: make(map[string]*rateWindow),
+			now:     time.Now,
+		}
+	})
+	return rateLimiter
+}
+
+func rateLimitKeyOf(scope string, id string) string {
+	return scope + ":" + id
+}
+
+// window 返回当前分钟的窗口，跨分钟时重置计数，调用方需持有 mu
+func (l *RateLimiter) window(key string, minute int64) *rateWindow {
+	w, ok := l.windows[key]
+	if !ok {
+		w = &rateWindow{minute: minute}
+		l.windows[key] = w
+	}
+	if w.minute != minute {
+		*w = rateWindow{minute: minute}
+	}
+	return w
+}
+
+// Allow 校验并占用一次请求额度；TPM 以窗口内已完成请求的 token 数判断，任一维度超限时不计数
+func (l *RateLimiter) Allow(target RateLimitTarget) *RateLimitError {
+	now := l.now()
+	minute := now.Unix() / 60
+	retryAfter := time.Unix((minute+1)*60, 0).Sub(now)
+
+	var checks []rateLimitCheck
+	if target.APIKeyID != 0 && (target.KeyRPM > 0 || target.KeyTPM > 0) {
+		checks = append(checks, rateLimitCheck{rateLimitKeyOf(rateLimitScopeKey, strconv.FormatUint(uint64(target.APIKeyID), 10)), rateLimitScopeKey, target.KeyRPM, target.KeyTPM})
+	}
+	if target.ModelRPM > 0 || target.ModelTPM > 0 {
+		checks = append(checks, rateLimitCheck{rateLimitKeyOf(rateLimitScopeModel, target.Model), rateLimitScopeModel, target.ModelRPM, target.ModelTPM})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	if redisClient != nil {
+		return allowShared(checks, minute, retryAfter)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range checks {
+		w := l.window(c.key, minute)
+		if c.rpm > 0 && w.requests >= int64(c.rpm) {
+			return &RateLimitError{Scope: c.scope, Kind: rateLimitKindRequests, Limit: c.rpm, RetryAfter: retryAfter}
+		}
+		if c.tpm > 0 && w.tokens >= int64(c.tpm) {
+			return &RateLimitError{Scope: c.scope, Kind: rateLimitKindTokens, Limit: c.tpm, RetryAfter: retryAfter}
+		}
+	}
+	for _, c := range checks {
+		l.window(c.key, minute).requests++
+	}
+	l.dirty = true
+	return nil
+}
+
+// AddTokens 请求完成后计入 token 用量，只统计配置了限流的对象
+func (l *RateLimiter) AddTokens(target RateLimitTarget, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	var keys []string
+	if target.APIKeyID != 0 && target.KeyTPM > 0 {
+		keys = append(keys, rateLimitKeyOf(rateLimitScopeKey, strconv.FormatUint(uint64(target.APIKeyID), 10)))
+	}
+	if target.ModelTPM > 0 {
+		keys = append(keys, rateLimitKeyOf(rateLimitScopeModel, target.Model))
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	minute := l.now().Unix() / 60
+	if redisClient != nil {
+		addSharedTokens(keys, minute, tokens)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.window(key, minute).tokens += tokens
+	}
+	l.dirty = true
+}
+
+// Usage 返回当前分钟窗口内有用量的计数，按 key 排序
+func (l *RateLimiter) Usage() []RateLimitUsage {
+	minute := l.now().Unix() / 60
+	if redisClient != nil {
+		return sharedUsage(minute)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := make([]RateLimitUsage, 0, len(l.windows))
+	for key, w := range l.windows {
+		if w.minute != minute {
+			continue
+		}
+		usage = append(usage, RateLimitUsage{
+			Key:       key,
+			Requests:  w.requests,
+			Tokens:    w.tokens,
+			WindowEnd: time.Unix((minute+1)*60, 0),
+		})
+	}
+	slices.SortFunc(usage, func(a, b RateLimitUsage) int { return strings.Compare(a.Key, b.Key) })
+	return usage
+}
+
+// Restore 从数据库恢复当前分钟窗口内的计数，过期的快照直接丢弃
+func (l *RateLimiter) Restore(ctx context.Context) error {
+	minute := l.now().Unix() / 60
+	counters, err := gorm.G[models.RateLimitCounter](models.DB).Where("minute = ?", minute).Find(ctx)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 启动后可能已有请求计数，快照累加到内存窗口上
+	for _, counter := range counters {
+		w := l.window(counter.Key, minute)
+		w.requests += counter.Requests
+		w.tokens += counter.Tokens
+	}
+	return nil
+}
+
+// Snapshot 将当前窗口计数写入数据库，并清理过期窗口
+func (l *RateLimiter) Snapshot(ctx context.Context) error {
+	minute := l.now().Unix() / 60
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	counters := make([]models.RateLimitCounter, 0, len(l.windows))
+	for key, w := range l.windows {
+		if w.minute != minute {
+			delete(l.windows, key)
+			continue
+		}
+		counters = append(counters, models.RateLimitCounter{Key: key, Minute: w.minute, Requests: w.requests, Tokens: w.tokens})
+	}
+	l.dirty = false
+	l.mu.Unlock()
+
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("minute < ?", minute).Delete(&models.RateLimitCounter{}).Error; err != nil {
+			return err
+		}
+		if len(counters) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&counters).Error
+	})
+}
+
+// Start 恢复计数并按设置的间隔定期写入快照，ctx 取消时写入最后一次快照后退出
+func (l *RateLimiter) Start(ctx context.Context) {
+	// 计数直接保存在 Redis 中，无需恢复与快照
+	if redisClient != nil {
+		return
+	}
+	if err := l.Restore(ctx); err != nil {
+		slog.Error("failed to restore rate limit counters", "error", err)
+	}
+	ticker := time.NewTicker(rateLimitCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.Snapshot(context.Background()); err != nil {
+				slog.Error("failed to snapshot rate limit counters", "error", err)
+			}
+			return
+		case <-ticker.C:
+			interval := GetRateLimitSnapshotInterval(ctx)
+			if interval <= 0 || time.Since(l.lastSnapshot) < time.Duration(interval)*time.Second {
+				continue
+			}
+			l.lastSnapshot = time.Now()
+			if err := l.Snapshot(ctx); err != nil {
+				slog.Error("failed to snapshot rate limit counters", "error", err)
+			}
+		}
+	}
+}
+
+// rateLimitAllowScript 原子地校验所有维度并占用一次请求额度，返回超限维度的序号（从 1 开始）与类型（1 请求数，2 token 数），未超限时返回 0
+var rateLimitAllowScript = redis.NewScript(`
+for i, key in ipairs(KEYS) do
+	local rpm, tpm = tonumber(ARGV[2 * i - 1]), tonumber(ARGV[2 * i])
+	if rpm > 0 and tonumber(redis.call('HGET', key, 'requests') or '0') >= rpm then
+		return {i, 1}
+	end
+	if tpm > 0 and tonumber(redis.call('HGET', key, 'tokens') or '0') >= tpm then
+		return {i, 2}
+	end
+end
+for _, key in ipairs(KEYS) do
+	redis.call('HINCRBY', key, 'requests', 1)
+	redis.call('EXPIRE', key, ARGV[#ARGV])
+end
+return {0, 0}
+`)
+
+// rateLimitRedisTTL 窗口键的过期时间，覆盖当前分钟并留出时钟偏差余量
+const rateLimitRedisTTL = 2 * time.Minute
+
+func rateLimitRedisKey(minute int64, key string) string {
+	return redisKey("ratelimit", strconv.FormatInt(minute, 10), key)
+}
+
+// allowShared 在 Redis 中校验并计数，Redis 不可用时放行请求
+func allowShared(checks []rateLimitCheck, minute int64, retryAfter time.Duration) *RateLimitError {
+	keys := make([]string, len(checks))
+	args := make([]any, 0, len(checks)*2+1)
+	for i, c := range checks {
+		keys[i] = rateLimitRedisKey(minute, c.key)
+		args = append(args, c.rpm, c.tpm)
+	}
+	args = append(args, int(rateLimitRedisTTL.Seconds()))
+	result, err := rateLimitAllowScript.Run(context.Background(), redisClient, keys, args...).Int64Slice()
+	if err != nil || len(result) != 2 {
+		slog.Error("redis rate limit check failed, allowing request", "error", err)
+		return nil
+	}
+	if result[0] == 0 {
+		return nil
+	}
+	c := checks[result[0]-1]
+	if result[1] == 1 {
+		return &RateLimitError{Scope: c.scope, Kind: rateLimitKindRequests, Limit: c.rpm, RetryAfter: retryAfter}
+	}
+	return &RateLimitError{Scope: c.scope, Kind: rateLimitKindTokens, Limit: c.tpm, RetryAfter: retryAfter}
+}
+
+func addSharedTokens(keys []string, minute int64, tokens int64) {
+	ctx := context.Background()
+	if _, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.HIncrBy(ctx, rateLimitRedisKey(minute, key), "tokens", tokens)
+			pipe.Expire(ctx, rateLimitRedisKey(minute, key), rateLimitRedisTTL)
+		}
+		return nil
+	}); err != nil {
+		slog.Error("redis rate limit token update failed", "error", err)
+	}
+}
+
+func sharedUsage(minute int64) []RateLimitUsage {
+	ctx := context.Background()
+	usage := make([]RateLimitUsage, 0)
+	prefix := rateLimitRedisKey(minute, "")
+	keys, err := scanRedisKeys(ctx, prefix+"*")
+	if err != nil {
+		slog.Error("redis rate limit usage scan failed", "error", err)
+		return usage
+	}
+	for _, key := range keys {
+		values, err := redisClient.HGetAll(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		requests, _ := strconv.ParseInt(values["requests"], 10, 64)
+		tokens, _ := strconv.ParseInt(values["tokens"], 10, 64)
+		usage = append(usage, RateLimitUsage{
+			Key:       strings.TrimPrefix(key, prefix),
+			Requests:  requests,
+			Tokens:    tokens,
+			WindowEnd: time.Unix((minute+1)*60, 0),
+		})
+	}
+	slices.SortFunc(usage, func(a, b RateLimitUsage) int { return strings.Compare(a.Key, b.Key) })
+	return usage
+}
+
+// GetRateLimitSnapshotInterval 获取限流计数快照间隔（秒）
+func GetRateLimitSnapshotInterval(ctx context.Context) int {
+	setting, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyRateLimitSnapshotInterval)).
+		First(ctx)
+	if err != nil {
+		return defaultRateLimitSnapshotInterval
+	}
+	val, err := strconv.Atoi(setting.Value)
+	if err != nil || val < 0 {
+		return defaultRateLimitSnapshotInterval
+	}
+	return val
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestRateLimiter(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	current := time.Unix(1_700_000_010, 0) // 距离窗口重置 30 秒
+	limiter := &RateLimiter{windows: make(map[string]*rateWindow), now: func() time.Time { return current }}
+
+	tests := []struct {
+		name      string
+		target    RateLimitTarget
+		tokens    int64 // 请求通过后计入的 token 数
+		wantScope string
+		wantKind  string
+	}{
+		{name: "unlimited", target: RateLimitTarget{APIKeyID: 1, Model: "m"}},
+		{name: "key rpm 1", target: RateLimitTarget{APIKeyID: 1, KeyRPM: 2, Model: "m"}},
+		{name: "key rpm 2", target: RateLimitTarget{APIKeyID: 1, KeyRPM: 2, Model: "m"}},
+		{name: "key rpm exceeded", target: RateLimitTarget{APIKeyID: 1, KeyRPM: 2, Model: "m"}, wantScope: rateLimitScopeKey, wantKind: rateLimitKindRequests},
+		{name: "other key unaffected", target: RateLimitTarget{APIKeyID: 2, KeyRPM: 2, Model: "m"}},
+		{name: "model tpm under limit", target: RateLimitTarget{Model: "m", ModelTPM: 100}, tokens: 120},
+		{name: "model tpm exceeded", target: RateLimitTarget{Model: "m", ModelTPM: 100}, wantScope: rateLimitScopeModel, wantKind: rateLimitKindTokens},
+		{name: "model rpm checked after key", target: RateLimitTarget{APIKeyID: 3, KeyRPM: 5, Model: "n", ModelRPM: 1}},
+		{name: "model rpm exceeded", target: RateLimitTarget{APIKeyID: 3, KeyRPM: 5, Model: "n", ModelRPM: 1}, wantScope: rateLimitScopeModel, wantKind: rateLimitKindRequests},
+	}
+	for _, tt := range tests {
+		err := limiter.Allow(tt.target)
+		if tt.wantScope == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected %v", tt.name, err)
+			}
+			limiter.AddTokens(tt.target, tt.tokens)
+			continue
+		}
+		if err == nil || err.Scope != tt.wantScope || err.Kind != tt.wantKind || err.RetryAfter != 30*time.Second {
+			t.Fatalf("%s: err = %+v", tt.name, err)
+		}
+	}
+	// 超限的请求不占用其他维度的额度
+	if usage := limiter.Usage(); len(usage) != 5 || usage[0].Key != "key:1" || usage[0].Requests != 2 ||
+		usage[2].Key != "key:3" || usage[2].Requests != 1 || usage[3].Tokens != 120 || usage[4].Key != "model:n" || usage[4].Requests != 1 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// 快照写入数据库后由新实例恢复
+	if err := limiter.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	restored := &RateLimiter{windows: make(map[string]*rateWindow), now: func() time.Time { return current }}
+	if err := restored.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Allow(RateLimitTarget{APIKeyID: 1, KeyRPM: 2}); err == nil {
+		t.Error("Expected restored counters to keep key 1 limited")
+	}
+
+	// 进入下一分钟后计数重置，过期快照被清理
+	current = current.Add(time.Minute)
+	if err := limiter.Allow(RateLimitTarget{APIKeyID: 1, KeyRPM: 2}); err != nil {
+		t.Fatalf("new window rejected: %v", err)
+	}
+	if err := limiter.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	counters, err := gorm.G[models.RateLimitCounter](models.DB).Find(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counters) != 1 || counters[0].Key != "key:1" || counters[0].Requests != 1 {
+		t.Errorf("counters = %+v", counters)
+	}
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// realtimeMaxMessage 解析用量时缓存的单条文本消息上限，超出的消息（如大段音频）只转发不解析
+const realtimeMaxMessage = 1 << 20
+
+// RealtimeSession 已与上游完成 WebSocket 握手的 Realtime 会话
+type RealtimeSession struct {
+	Protocol string // 上游选定的子协议
+
+	conn      io.ReadWriteCloser
+	logId     uint
+	start     time.Time
+	rateLimit RateLimitTarget
+	release   func()
+}
+
+// DialRealtime 按优先级与权重选择支持 Realtime API 的供应商并完成 WebSocket 握手，握手失败时切换下一个供应商
+func DialRealtime(ctx context.Context, before Before, providersWithMeta ProvidersWithMeta, reqMeta models.ReqMeta, rateLimit RateLimitTarget) (*RealtimeSession, error) {
+	providerMap := providersWithMeta.ProviderMap
+	candidates := candidatesOf(providersWithMeta)
+
+	retryLog := make(chan models.ChatLog, providersWithMeta.MaxRetry)
+	defer close(retryLog)
+	go RecordRetryLog(context.Background(), retryLog, providersWithMeta.ModelWithProviderMap)
+
+	start := time.Now()
+	var lastUpstream string
+	for retry := range providersWithMeta.MaxRetry {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		id, err := candidates.pick()
+		if err != nil {
+			if lastUpstream != "" {
+				return nil, &UpstreamError{Err: err, Last: lastUpstream}
+			}
+			return nil, err
+		}
+		modelWithProvider, ok := providersWithMeta.ModelWithProviderMap[*id]
+		if !ok {
+			candidates.remove(*id)
+			continue
+		}
+		provider := providerMap[modelWithProvider.ProviderID]
+
+		chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		// 不支持 Realtime API 的供应商直接跳过，不计入失败
+		realtimer, ok := chatModel.(providers.Realtimer)
+		if !ok {
+			candidates.remove(*id)
+			continue
+		}
+
+		slog.Info("using realtime provider", "provider", provider.Name, "model", modelWithProvider.ProviderModel, "proxy", chatModel.GetProxy())
+
+		log := models.ChatLog{
+			Name:                before.Model,
+			ProviderModel:       modelWithProvider.ProviderModel,
+			ProviderName:        provider.Name,
+			Status:              "success",
+			Style:               consts.StyleOpenAIRealtime,
+			UserAgent:           reqMeta.UserAgent,
+			RemoteIP:            reqMeta.RemoteIP,
+			APIKeyID:            reqMeta.APIKeyID,
+			ModelWithProviderID: *id,
+			ServedModel:         providersWithMeta.ServedModel,
+			LogLevel:            providersWithMeta.LogLevel,
+			RequestID:           RequestIDFromContext(ctx),
+			Retry:               retry,
+			ProxyTime:           time.Since(start),
+		}
+		if providersWithMeta.LogLevel == models.LogLevelNone {
+			log.UserAgent, log.RemoteIP = "", ""
+		}
+
+		withHeader := false
+		if modelWithProvider.WithHeader != nil {
+			withHeader = *modelWithProvider.WithHeader
+		}
+		header := buildHeaders(reqMeta.Header, withHeader, modelWithProvider.CustomerHeaders, false)
+		setRequestIDHeader(ctx, header)
+		req, err := realtimer.BuildRealtimeReq(ctx, header, modelWithProvider.ProviderModel)
+		if err != nil {
+			retryLog <- log.WithError(err)
+			candidates.remove(*id)
+			continue
+		}
+		key := setRealtimeHandshake(req.Header, reqMeta.Header.Get("Sec-WebSocket-Protocol"))
+
+		reqStart := time.Now()
+		client := providersWithMeta.Timeouts.client(chatModel.GetProxy())
+		res, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastUpstream = err.Error()
+			retryLog <- log.WithError(err)
+			candidates.remove(*id)
+			continue
+		}
+		conn, ok := res.Body.(io.ReadWriteCloser)
+		if res.StatusCode != http.StatusSwitchingProtocols || !ok || res.Header.Get("Sec-WebSocket-Accept") != WSAcceptKey(key) {
+			body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+			res.Body.Close()
+			lastUpstream = fmt.Sprintf("status: %d, body: %s", res.StatusCode, string(body))
+			if isAuthFailure(res.StatusCode) {
+				QuarantineAuthFailure(context.WithoutCancel(ctx), modelWithProvider, provider, before.Model, res.StatusCode, string(body))
+			}
+			retryLog <- log.WithError(errors.New("realtime handshake failed, " + lastUpstream))
+			candidates.remove(*id)
+			continue
+		}
+
+		log.FirstChunkTime = time.Since(reqStart)
+		logId, err := SaveChatLog(ctx, log)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		applySuccessAdjustments(ctx, *id)
+		return &RealtimeSession{
+			Protocol:  res.Header.Get("Sec-WebSocket-Protocol"),
+			conn:      conn,
+			logId:     logId,
+			start:     time.Now(),
+			rateLimit: rateLimit,
+			release:   trackInflight(*id),
+		}, nil
+	}
+	return nil, errors.New("maximum retry attempts reached")
+}
+
+// setRealtimeHandshake 写入上游 WebSocket 握手头并返回 Sec-WebSocket-Key；
+// 不协商压缩扩展以便解析帧内容，浏览器用于携带密钥的子协议不转发给上游
+func setRealtimeHandshake(header http.Header, protocols string) string {
+	for key := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(key), "Sec-Websocket-") {
+			header.Del(key)
+		}
+	}
+	key := wsNewKey()
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", "websocket")
+	header.Set("Sec-WebSocket-Version", "13")
+	header.Set("Sec-WebSocket-Key", key)
+
+	var forwarded []string
+	for protocol := range strings.SplitSeq(protocols, ",") {
+		protocol = strings.TrimSpace(protocol)
+		if protocol != "" && !strings.HasPrefix(protocol, "openai-insecure-api-key.") {
+			forwarded = append(forwarded, protocol)
+		}
+	}
+	if len(forwarded) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(forwarded, ", "))
+	}
+	return key
+}
+
+// Relay 在客户端与上游之间双向转发帧，任一方断开后结束会话并记录时长与用量。
+// 调用方负责完成与客户端的握手，clientReader 为客户端连接上可能已缓冲数据的读取端
+func (s *RealtimeSession) Relay(client io.WriteCloser, clientReader io.Reader) {
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			client.Close()
+			s.conn.Close()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 客户端帧已带掩码，原样转发给上游
+		if _, err := io.Copy(s.conn, clientReader); err != nil {
+			slog.Debug("realtime client stream closed", "log_id", s.logId, "error", err)
+		}
+		closeAll()
+	}()
+
+	usage := s.relayUpstream(client)
+	closeAll()
+	<-done
+	s.finish(usage)
+}
+
+// Close 未能与客户端建立会话时关闭上游连接并结束日志
+func (s *RealtimeSession) Close() {
+	s.conn.Close()
+	s.finish(models.Usage{})
+}
+
+// relayUpstream 将上游帧原样转发给客户端，并从 response.done 事件累计用量
+func (s *RealtimeSession) relayUpstream(client io.Writer) models.Usage {
+	var usage models.Usage
+	reader := bufio.NewReader(s.conn)
+	var message []byte
+	collecting := false
+	for {
+		frame, err := readWSFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Debug("realtime upstream stream closed", "log_id", s.logId, "error", err)
+			}
+			return usage
+		}
+		if _, err := client.Write(frame.raw); err != nil {
+			return usage
+		}
+
+		switch frame.opcode {
+		case wsOpText:
+			message = append(message[:0], frame.payload...)
+			collecting = len(message) <= realtimeMaxMessage
+		case wsOpContinuation:
+			if collecting {
+				message = append(message, frame.payload...)
+				collecting = len(message) <= realtimeMaxMessage
+			}
+		default:
+			continue
+		}
+		if frame.fin && collecting {
+			addRealtimeUsage(&usage, message)
+			collecting = false
+		}
+	}
+}
+
+// addRealtimeUsage 累加 response.done 事件中的 token 用量
+func addRealtimeUsage(usage *models.Usage, message []byte) {
+	if !bytes.Contains(message, []byte(`"response.done"`)) || gjson.GetBytes(message, "type").String() != "response.done" {
+		return
+	}
+	u := gjson.GetBytes(message, "response.usage")
+	usage.PromptTokens += u.Get("input_tokens").Int()
+	usage.CompletionTokens += u.Get("output_tokens").Int()
+	usage.TotalTokens += u.Get("total_tokens").Int()
+	usage.PromptTokensDetails.CachedTokens += u.Get("input_token_details.cached_tokens").Int()
+	usage.PromptTokensDetails.AudioTokens += u.Get("input_token_details.audio_tokens").Int()
+}
+
+// finish 会话结束后写入时长与用量，并计入费用、用量统计与限流
+func (s *RealtimeSession) finish(usage models.Usage) {
+	defer s.release()
+	ctx := context.Background()
+	duration := time.Since(s.start)
+	slog.Info("realtime session closed", "log_id", s.logId, "duration", duration, "total_tokens", usage.TotalTokens)
+
+	GetRateLimiter().AddTokens(s.rateLimit, usage.TotalTokens)
+	update := models.ChatLog{ChunkTime: duration, Usage: usage}
+	if duration > 0 {
+		update.Tps = float64(usage.CompletionTokens) / duration.Seconds()
+	}
+	if _, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", s.logId).Updates(ctx, update); err != nil {
+		slog.Error("failed to update realtime log", "log_id", s.logId, "error", err)
+		return
+	}
+	chatLog, err := gorm.G[models.ChatLog](models.DB).Where("id = ?", s.logId).First(ctx)
+	if err != nil {
+		slog.Error("failed to load log for usage", "log_id", s.logId, "error", err)
+		return
+	}
+	if err := ApplyLogCost(ctx, &chatLog); err != nil {
+		slog.Error("failed to calculate cost", "log_id", s.logId, "error", err)
+	}
+	if err := RecordUsage(ctx, chatLog); err != nil {
+		slog.Error("failed to record usage", "log_id", s.logId, "error", err)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+// 推理强度与思考 token 预算的换算表，与各家默认档位大致对应
+var reasoningEffortBudgets = []struct {
+	effort string
+	budget int
+}{
+	{"minimal", 1024},
+	{"low", 2048},
+	{"medium", 8192},
+	{"high", 24576},
+}
+
+// reasoningBudget 返回思考 token 预算，只有推理强度时按档位换算，none 表示关闭思考返回 0
+func reasoningBudget(reasoning *UnifiedReasoning) int {
+	if reasoning.BudgetTokens > 0 {
+		return reasoning.BudgetTokens
+	}
+	for _, item := range reasoningEffortBudgets {
+		if item.effort == reasoning.Effort {
+			return item.budget
+		}
+	}
+	return 0
+}
+
+// reasoningEffort 返回推理强度，只有思考预算时取不低于预算的最小档位
+func reasoningEffort(reasoning *UnifiedReasoning) string {
+	if reasoning.Effort != "" {
+		return reasoning.Effort
+	}
+	for _, item := range reasoningEffortBudgets {
+		if reasoning.BudgetTokens <= item.budget {
+			return item.effort
+		}
+	}
+	return "high"
+}
+
+// reasoningDisabled 判断是否显式关闭了思考
+func reasoningDisabled(reasoning *UnifiedReasoning) bool {
+	return reasoning.Effort == "none"
+}
+
+// openAIReasoningContent 读取 OpenAI 兼容接口消息或增量中的思考内容，DeepSeek 等使用 reasoning_content，部分上游使用 reasoning
+func openAIReasoningContent(msg map[string]interface{}) string {
+	if text := getString(msg, "reasoning_content"); text != "" {
+		return text
+	}
+	return getString(msg, "reasoning")
+}
+
+// splitAnthropicThinking 从 Anthropic 内容块中取出 thinking 块的文本与签名，返回其余内容块；
+// redacted_thinking 无法在其他格式中表达，一并移除
+func splitAnthropicThinking(content []interface{}) ([]interface{}, string, string) {
+	var thinking, signature string
+	rest := make([]interface{}, 0, len(content))
+	for _, item := range content {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			rest = append(rest, item)
+			continue
+		}
+		switch getString(itemMap, "type") {
+		case "thinking":
+			thinking += getString(itemMap, "thinking")
+			if sig := getString(itemMap, "signature"); sig != "" {
+				signature = sig
+			}
+		case "redacted_thinking":
+		default:
+			rest = append(rest, item)
+		}
+	}
+	return rest, thinking, signature
+}
+
+// anthropicThinkingBlock 生成 Anthropic thinking 内容块
+func anthropicThinkingBlock(thinking, signature string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "thinking",
+		"thinking":  thinking,
+		"signature": signature,
+	}
+}
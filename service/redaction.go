@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// defaultRedactionReplacement 规则未配置替换文本时使用的占位符
+const defaultRedactionReplacement = "[REDACTED]"
+
+// Redactor 按规则对写入日志的文本脱敏
+type Redactor struct {
+	patterns []compiledReplacement
+	paths    []redactionPath
+}
+
+type redactionPath struct {
+	segments    []string
+	replacement string
+}
+
+var redactorCache struct {
+	sync.Mutex
+	value    string
+	redactor *Redactor
+}
+
+// ValidateRedactionRules 校验规则：每条规则需要且只能配置正则或路径中的一个
+func ValidateRedactionRules(rules []models.RedactionRule) error {
+	for i, rule := range rules {
+		switch {
+		case rule.Pattern == "" && rule.Path == "":
+			return fmt.Errorf("rule %d: pattern or path is required", i)
+		case rule.Pattern != "" && rule.Path != "":
+			return fmt.Errorf("rule %d: pattern and path are mutually exclusive", i)
+		case rule.Pattern != "":
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern %q: %v", i, rule.Pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NewRedactor 编译脱敏规则，没有规则时返回 nil
+func NewRedactor(rules []models.RedactionRule) (*Redactor, error) {
+	if err := ValidateRedactionRules(rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for _, rule := range rules {
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = defaultRedactionReplacement
+		}
+		if rule.Path != "" {
+			r.paths = append(r.paths, redactionPath{segments: strings.Split(rule.Path, "."), replacement: replacement})
+			continue
+		}
+		r.patterns = append(r.patterns, compiledReplacement{re: regexp.MustCompile(rule.Pattern), replacement: replacement})
+	}
+	return r, nil
+}
+
+// GetRedactionRules 获取日志脱敏规则，设置缺失或无法解析时使用默认规则
+func GetRedactionRules(ctx context.Context) []models.RedactionRule {
+	setting, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyLogRedactionRules)).First(ctx)
+	if err != nil {
+		return models.DefaultRedactionRules
+	}
+	var rules []models.RedactionRule
+	if err := json.Unmarshal([]byte(setting.Value), &rules); err != nil {
+		slog.Error("invalid log redaction rules, using defaults", "error", err)
+		return models.DefaultRedactionRules
+	}
+	return rules
+}
+
+// getRedactor 按当前设置获取编译好的脱敏器，设置未变化时复用
+func getRedactor(ctx context.Context) *Redactor {
+	rules := GetRedactionRules(ctx)
+	value, _ := json.Marshal(rules)
+
+	redactorCache.Lock()
+	defer redactorCache.Unlock()
+	if redactorCache.value == string(value) {
+		return redactorCache.redactor
+	}
+	redactor, err := NewRedactor(rules)
+	if err != nil {
+		slog.Error("invalid log redaction rules, using defaults", "error", err)
+		redactor, _ = NewRedactor(models.DefaultRedactionRules)
+	}
+	redactorCache.value, redactorCache.redactor = string(value), redactor
+	return redactor
+}
+
+// RedactChatIO 持久化前对记录的输入、输出、上游原始请求响应与上下文摘要脱敏
+func RedactChatIO(ctx context.Context, chatIO *models.ChatIO) {
+	r := getRedactor(ctx)
+	if r == nil {
+		return
+	}
+	chatIO.Input = r.Redact(chatIO.Input)
+	chatIO.OfString = r.Redact(chatIO.OfString)
+	if chatIO.OfStringArray != nil {
+		redacted := make([]string, len(chatIO.OfStringArray))
+		for i, s := range chatIO.OfStringArray {
+			redacted[i] = r.Redact(s)
+		}
+		chatIO.OfStringArray = redacted
+	}
+	chatIO.RawRequest = r.Redact(chatIO.RawRequest)
+	chatIO.RawResponse = r.Redact(chatIO.RawResponse)
+	if chatIO.Summary != nil {
+		summary := *chatIO.Summary
+		summary.Replaced, summary.Summary = r.Redact(summary.Replaced), r.Redact(summary.Summary)
+		chatIO.Summary = &summary
+	}
+}
+
+// Redact 先按路径替换 JSON 字段（非 JSON 文本跳过），再执行正则替换
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	if len(r.paths) > 0 && gjson.Valid(text) {
+		for _, p := range r.paths {
+			for _, concrete := range expandRedactionPath(gjson.Parse(text), p.segments, "") {
+				if redacted, err := sjson.Set(text, concrete, p.replacement); err == nil {
+					text = redacted
+				}
+			}
+		}
+	}
+	for _, p := range r.patterns {
+		text = p.re.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
+
+// expandRedactionPath 将含 * 的路径展开为 JSON 中实际存在的路径
+func expandRedactionPath(value gjson.Result, segments []string, prefix string) []string {
+	if len(segments) == 0 {
+		if prefix == "" {
+			return nil
+		}
+		return []string{prefix}
+	}
+	join := func(key string) string {
+		key = escapeRedactionKey(key)
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	segment := segments[0]
+	if segment != "*" {
+		child := value.Get(escapeRedactionKey(segment))
+		if !child.Exists() {
+			return nil
+		}
+		return expandRedactionPath(child, segments[1:], join(segment))
+	}
+	var paths []string
+	if value.IsArray() {
+		for i, item := range value.Array() {
+			paths = append(paths, expandRedactionPath(item, segments[1:], join(strconv.Itoa(i)))...)
+		}
+	} else if value.IsObject() {
+		value.ForEach(func(key, item gjson.Result) bool {
+			paths = append(paths, expandRedactionPath(item, segments[1:], join(key.String()))...)
+			return true
+		})
+	}
+	return paths
+}
+
+// escapeRedactionKey 转义 gjson/sjson 路径中的特殊字符
+func escapeRedactionKey(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		if strings.ContainsRune(`.*?|#@\!=<>%`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestRedactorDefaults(t *testing.T) {
+	r, err := NewRedactor(models.DefaultRedactionRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := `{"messages":[{"role":"user","content":"curl -H 'Authorization: Bearer abcdefgh12345678' key sk-abcdefghijklmnopqrstuv"}],"api_key":"secret-value"}`
+	out := r.Redact(in)
+	for _, leaked := range []string{"abcdefgh12345678", "sk-abcdefghijklmnopqrstuv", "secret-value"} {
+		if strings.Contains(out, leaked) {
+			t.Fatalf("%q leaked in %s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, "Bearer [REDACTED]") || !strings.Contains(out, `"api_key":"[REDACTED]"`) {
+		t.Fatalf("redacted = %s", out)
+	}
+}
+
+func TestRedactorPath(t *testing.T) {
+	r, err := NewRedactor([]models.RedactionRule{
+		{Name: "content", Path: "messages.*.content"},
+		{Name: "email", Pattern: `[\w.+-]+@[\w-]+\.[\w.]+`, Replacement: "<email>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := r.Redact(`{"model":"m","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"yo"}],"user":"a.b@example.com"}`)
+	if out != `{"model":"m","messages":[{"role":"user","content":"[REDACTED]"},{"role":"assistant","content":"[REDACTED]"}],"user":"<email>"}` {
+		t.Fatalf("redacted = %s", out)
+	}
+	// 非 JSON 文本仅应用正则规则
+	if out := r.Redact("mail me at a@b.io"); out != "mail me at <email>" {
+		t.Fatalf("redacted = %s", out)
+	}
+}
+
+func TestValidateRedactionRules(t *testing.T) {
+	for _, rules := range [][]models.RedactionRule{
+		{{Name: "empty"}},
+		{{Pattern: "a", Path: "b"}},
+		{{Pattern: "("}},
+	} {
+		if err := ValidateRedactionRules(rules); err == nil {
+			t.Fatalf("rules %+v should be invalid", rules)
+		}
+	}
+}
+
+func TestRedactChatIOUsesSetting(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	chatIO := models.ChatIO{Input: `{"authorization":"Bearer abcdefgh12345678"}`, RawRequest: "sk-abcdefghijklmnopqrstuv"}
+	chatIO.OfStringArray = []string{"token sk-abcdefghijklmnopqrstuv"}
+	RedactChatIO(ctx, &chatIO)
+	if strings.Contains(chatIO.Input, "abcdefgh") || strings.Contains(chatIO.RawRequest, "sk-") || strings.Contains(chatIO.OfStringArray[0], "sk-") {
+		t.Fatalf("chat io = %+v", chatIO)
+	}
+
+	// 清空规则后不再脱敏
+	if _, err := gorm.G[models.Setting](models.DB).Where("key = ?", models.SettingKeyLogRedactionRules).Update(ctx, "value", "[]"); err != nil {
+		t.Fatal(err)
+	}
+	chatIO = models.ChatIO{Input: "sk-abcdefghijklmnopqrstuv"}
+	RedactChatIO(ctx, &chatIO)
+	if chatIO.Input != "sk-abcdefghijklmnopqrstuv" {
+		t.Fatalf("input = %s", chatIO.Input)
+	}
+}
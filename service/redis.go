@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// RedisURLEnv Redis 连接串环境变量，如 redis://:password@host:6379/0；设置后多个实例共享限流计数、会话粘滞、请求隔离与重试预算
+	RedisURLEnv = "REDIS_URL"
+	// RedisPrefixEnv Redis 键名前缀环境变量，多套部署共用一个 Redis 时用于隔离
+	RedisPrefixEnv = "REDIS_PREFIX"
+
+	defaultRedisPrefix = "llmio"
+)
+
+var (
+	// redisClient 共享状态后端，为 nil 时状态保存在进程内存中
+	redisClient *redis.Client
+	redisPrefix = defaultRedisPrefix
+)
+
+// InitRedis 设置 REDIS_URL 时连接 Redis，连接失败时返回错误；未设置时保持单实例内存模式
+func InitRedis(ctx context.Context) error {
+	raw := strings.TrimSpace(os.Getenv(RedisURLEnv))
+	if raw == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", RedisURLEnv, err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("connect redis: %w", err)
+	}
+	if prefix := strings.TrimSpace(os.Getenv(RedisPrefixEnv)); prefix != "" {
+		redisPrefix = prefix
+	}
+	redisClient = client
+	slog.Info("shared state backed by redis", "addr", opts.Addr, "db", opts.DB, "prefix", redisPrefix)
+	return nil
+}
+
+// redisKey 拼接带前缀的键名
+func redisKey(parts ...string) string {
+	return redisPrefix + ":" + strings.Join(parts, ":")
+}
+
+// scanRedisKeys 列出匹配 pattern 的全部键
+func scanRedisKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := redisClient.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
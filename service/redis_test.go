@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+func setupRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		redisClient.Close()
+		redisClient = nil
+	})
+	return server
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	setupRedis(t)
+	now := func() time.Time { return time.Unix(1_700_000_000, 0) }
+	// 两个限流器模拟两个实例
+	a := &RateLimiter{windows: make(map[string]*rateWindow), now: now}
+	b := &RateLimiter{windows: make(map[string]*rateWindow), now: now}
+	target := RateLimitTarget{APIKeyID: 1, KeyRPM: 2, Model: "m", ModelTPM: 100}
+
+	if err := a.Allow(target); err != nil {
+		t.Fatalf("first request rejected: %v", err)
+	}
+	if err := b.Allow(target); err != nil {
+		t.Fatalf("second request rejected: %v", err)
+	}
+	err := a.Allow(target)
+	if err == nil || err.Scope != rateLimitScopeKey || err.Kind != rateLimitKindRequests {
+		t.Fatalf("expected key rpm limit, got %v", err)
+	}
+
+	b.AddTokens(RateLimitTarget{Model: "m", ModelTPM: 100}, 150)
+	err = a.Allow(RateLimitTarget{Model: "m", ModelTPM: 100})
+	if err == nil || err.Scope != rateLimitScopeModel || err.Kind != rateLimitKindTokens {
+		t.Fatalf("expected model tpm limit, got %v", err)
+	}
+
+	usage := a.Usage()
+	if len(usage) != 2 || usage[0].Key != "key:1" || usage[0].Requests != 2 || usage[1].Key != "model:m" || usage[1].Tokens != 150 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if len(a.windows) != 0 || len(b.windows) != 0 {
+		t.Error("expected no in-memory windows with redis")
+	}
+}
+
+func TestSharedAffinityAndRetryBudget(t *testing.T) {
+	server := setupRedis(t)
+
+	cache := &affinityCache{entries: make(map[string]affinityEntry)}
+	cache.set("m\x00s", 42, time.Minute)
+	if id, ok := (&affinityCache{entries: make(map[string]affinityEntry)}).get("m\x00s"); !ok || id != 42 {
+		t.Fatalf("expected shared affinity 42, got %d %v", id, ok)
+	}
+	server.FastForward(2 * time.Minute)
+	if _, ok := cache.get("m\x00s"); ok {
+		t.Error("expected affinity to expire")
+	}
+
+	budget := &retryBudget{windows: make(map[string]*retryWindow)}
+	other := &retryBudget{windows: make(map[string]*retryWindow)}
+	if !budget.take("m", 2) || !other.take("m", 2) {
+		t.Fatal("expected budget to allow two retries")
+	}
+	if budget.take("m", 2) {
+		t.Error("expected shared budget to be exhausted")
+	}
+}
+
+func TestSharedRequestQuarantine(t *testing.T) {
+	testutil.SetupDB(t)
+	setupRedis(t)
+	ctx := context.Background()
+	if _, err := gorm.G[models.Setting](models.DB).Where(models.ByKey(models.SettingKeyRequestQuarantineThreshold)).Update(ctx, "value", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := Before{Model: "m", raw: []byte(`{"model":"m"}`)}
+	rejected := &UpstreamError{Last: "content rejected", Rejected: true}
+	q := &requestQuarantine{entries: make(map[string]*QuarantineEntry)}
+
+	q.record(ctx, "openai", before, rejected)
+	if err := q.check("openai", before); err != nil {
+		t.Fatalf("quarantined before threshold: %v", err)
+	}
+	q.record(ctx, "openai", before, rejected)
+	if err := (&requestQuarantine{entries: make(map[string]*QuarantineEntry)}).check("openai", before); err == nil {
+		t.Fatal("expected request to be quarantined on another instance")
+	}
+
+	entries := QuarantineEntries()
+	if len(entries) != 1 || entries[0].Failures != 2 || entries[0].Hits != 1 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if !ReleaseQuarantine(entries[0].Fingerprint) {
+		t.Fatal("expected release to find the entry")
+	}
+	if err := q.check("openai", before); err != nil {
+		t.Errorf("expected released request to pass, got %v", err)
+	}
+}
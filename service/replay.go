@@ -0,0 +1,457 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultReplaySpeed       = 60
+	defaultReplayConcurrency = 256
+	defaultReplayMaxRequests = 10000
+	maxReplayErrors          = 20
+	maxReplayPromptWords     = 8000
+)
+
+// ReplayOptions 流量回放参数
+type ReplayOptions struct {
+	Date           string  `json:"date"`            // 回放日期 2006-01-02，默认昨天
+	SampleRate     float64 `json:"sample_rate"`     // 采样比例 (0,1]，默认 1
+	Speed          float64 `json:"speed"`           // 时间压缩倍数，默认 60（一天压缩到 24 分钟）
+	MaxRequests    int     `json:"max_requests"`    // 最大回放请求数，默认 10000
+	MaxConcurrency int     `json:"max_concurrency"` // 最大并发，默认 256
+}
+
+// ReplayModelStats 单个模型的回放结果
+type ReplayModelStats struct {
+	Requests   int `json:"requests"`
+	Succeeded  int `json:"succeeded"`
+	Unroutable int `json:"unroutable"` // 当前配置下没有可用供应商
+	Failed     int `json:"failed"`
+}
+
+// ReplayReport 流量回放报告
+type ReplayReport struct {
+	Status       string                       `json:"status"` // running, completed, canceled, failed
+	Options      ReplayOptions                `json:"options"`
+	StartedAt    time.Time                    `json:"started_at"`
+	FinishedAt   *time.Time                   `json:"finished_at,omitempty"`
+	Scheduled    int                          `json:"scheduled"`
+	Completed    int                          `json:"completed"`
+	Succeeded    int                          `json:"succeeded"`
+	Unroutable   int                          `json:"unroutable"`
+	Failed       int                          `json:"failed"`
+	PeakInFlight int64                        `json:"peak_in_flight"`
+	RPS          float64                      `json:"rps"`
+	LatencyP50   float64                      `json:"latency_p50_ms"`
+	LatencyP95   float64                      `json:"latency_p95_ms"`
+	LatencyP99   float64                      `json:"latency_p99_ms"`
+	Models       map[string]*ReplayModelStats `json:"models"`
+	Errors       []string                     `json:"errors"`
+}
+
+// replayStyle 各客户端格式的预处理、后处理与 mock 路径
+type replayStyle struct {
+	beforer   Beforer
+	processer Processer
+	path      string
+}
+
+var replayStyles = map[string]replayStyle{
+	consts.StyleOpenAI:    {BeforerOpenAI, ProcesserOpenAI, "/chat/completions"},
+	consts.StyleOpenAIRes: {BeforerOpenAIRes, ProcesserOpenAiRes, "/responses"},
+	consts.StyleAnthropic: {BeforerAnthropic, ProcesserAnthropic, "/messages"},
+}
+
+type replayJob struct {
+	mu        sync.Mutex
+	report    ReplayReport
+	latencies []time.Duration
+	cancel    context.CancelFunc
+	inFlight  atomic.Int64
+}
+
+var (
+	replayMu      sync.Mutex
+	currentReplay *replayJob
+)
+
+// StartReplay 启动流量回放，同一时间只允许一个回放任务
+func StartReplay(ctx context.Context, opts ReplayOptions) (*ReplayReport, error) {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	if currentReplay != nil && currentReplay.snapshot().Status == "running" {
+		return nil, errors.New("a replay is already running")
+	}
+
+	opts, day, err := normalizeReplayOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := sampleReplayLogs(ctx, day, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("no logs found on %s", opts.Date)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	job := &replayJob{
+		cancel: cancel,
+		report: ReplayReport{
+			Status:    "running",
+			Options:   opts,
+			StartedAt: time.Now(),
+			Scheduled: len(logs),
+			Models:    make(map[string]*ReplayModelStats),
+			Errors:    []string{},
+		},
+	}
+	currentReplay = job
+	go job.run(runCtx, logs, opts)
+
+	report := job.snapshot()
+	return &report, nil
+}
+
+// GetReplay 获取当前或最近一次回放的报告
+func GetReplay() (*ReplayReport, bool) {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	if currentReplay == nil {
+		return nil, false
+	}
+	report := currentReplay.snapshot()
+	return &report, true
+}
+
+// CancelReplay 取消正在运行的回放
+func CancelReplay() bool {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	if currentReplay == nil || currentReplay.snapshot().Status != "running" {
+		return false
+	}
+	currentReplay.cancel()
+	return true
+}
+
+func normalizeReplayOptions(opts ReplayOptions) (ReplayOptions, time.Time, error) {
+	if opts.Date == "" {
+		opts.Date = time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	day, err := time.ParseInLocation(time.DateOnly, opts.Date, time.Local)
+	if err != nil {
+		return opts, day, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", opts.Date)
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = defaultReplaySpeed
+	}
+	if opts.MaxRequests <= 0 {
+		opts.MaxRequests = defaultReplayMaxRequests
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = defaultReplayConcurrency
+	}
+	return opts, day, nil
+}
+
+// sampleReplayLogs 读取指定日期已路由到供应商的请求日志并按比例采样，重试记录作为独立的上游请求回放
+func sampleReplayLogs(ctx context.Context, day time.Time, opts ReplayOptions) ([]models.ChatLog, error) {
+	logs, err := gorm.G[models.ChatLog](models.DB).
+		Where("created_at >= ? AND created_at < ?", day, day.AddDate(0, 0, 1)).
+		Where("provider_name <> ''").
+		Order("created_at").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sampled := make([]models.ChatLog, 0, min(len(logs), opts.MaxRequests))
+	for _, log := range logs {
+		if _, ok := replayStyles[log.Style]; !ok {
+			continue
+		}
+		if opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+			continue
+		}
+		sampled = append(sampled, log)
+		if len(sampled) >= opts.MaxRequests {
+			break
+		}
+	}
+	return sampled, nil
+}
+
+func (j *replayJob) snapshot() ReplayReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	report := j.report
+	report.Models = make(map[string]*ReplayModelStats, len(j.report.Models))
+	for name, stats := range j.report.Models {
+		copied := *stats
+		report.Models[name] = &copied
+	}
+	report.Errors = slices.Clone(j.report.Errors)
+	report.PeakInFlight = j.report.PeakInFlight
+
+	latencies := slices.Clone(j.latencies)
+	slices.Sort(latencies)
+	report.LatencyP50 = percentileMs(latencies, 0.50)
+	report.LatencyP95 = percentileMs(latencies, 0.95)
+	report.LatencyP99 = percentileMs(latencies, 0.99)
+
+	end := time.Now()
+	if report.FinishedAt != nil {
+		end = *report.FinishedAt
+	}
+	if elapsed := end.Sub(report.StartedAt).Seconds(); elapsed > 0 {
+		report.RPS = float64(report.Completed) / elapsed
+	}
+	return report
+}
+
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return float64(sorted[idx]) / float64(time.Millisecond)
+}
+
+// run 启动 mock 上游，按压缩后的时间间隔并发回放日志
+func (j *replayJob) run(ctx context.Context, logs []models.ChatLog, opts ReplayOptions) {
+	defer j.cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		j.finish("failed", err)
+		return
+	}
+	server := &http.Server{Handler: newMockUpstream()}
+	go server.Serve(listener)
+	defer server.Close()
+	baseURL := "http://" + listener.Addr().String()
+
+	inputs := loadReplayInputs(ctx, logs)
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.MaxConcurrency}}
+	sem := make(chan struct{}, opts.MaxConcurrency)
+	var wg sync.WaitGroup
+
+	origin := logs[0].CreatedAt
+	start := time.Now()
+	for _, log := range logs {
+		offset := time.Duration(float64(log.CreatedAt.Sub(origin)) / opts.Speed)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(start.Add(offset))):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			j.replayOne(ctx, client, baseURL, log, inputs[log.ID])
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		j.finish("canceled", nil)
+		return
+	}
+	j.finish("completed", nil)
+}
+
+func (j *replayJob) finish(status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.report.Status = status
+	j.report.FinishedAt = &now
+	if err != nil {
+		j.report.Errors = append(j.report.Errors, err.Error())
+	}
+	slog.Info("replay finished", "status", status, "completed", j.report.Completed, "succeeded", j.report.Succeeded)
+}
+
+// loadReplayInputs 读取开启了 IO 记录的原始请求体
+func loadReplayInputs(ctx context.Context, logs []models.ChatLog) map[uint]string {
+	ids := make([]uint, 0)
+	for _, log := range logs {
+		if log.ChatIO {
+			ids = append(ids, log.ID)
+		}
+	}
+	inputs := make(map[uint]string)
+	for chunk := range slices.Chunk(ids, 500) {
+		ios, err := gorm.G[models.ChatIO](models.DB).Select("log_id", "input").Where("log_id IN ?", chunk).Find(ctx)
+		if err != nil {
+			slog.Error("failed to load replay inputs", "error", err)
+			continue
+		}
+		for _, chatIO := range ios {
+			inputs[chatIO.LogId] = chatIO.Input
+		}
+	}
+	return inputs
+}
+
+// syntheticReplayBody 没有原始请求体时按日志的 token 数构造等量请求
+func syntheticReplayBody(log models.ChatLog) []byte {
+	prompt := strings.TrimSpace(strings.Repeat("replay ", min(max(int(log.PromptTokens), 1), maxReplayPromptWords)))
+	messages := []map[string]string{{"role": "user", "content": prompt}}
+	body := []byte(`{}`)
+	body, _ = sjson.SetBytes(body, "model", log.Name)
+	body, _ = sjson.SetBytes(body, "stream", true)
+	switch log.Style {
+	case consts.StyleOpenAIRes:
+		body, _ = sjson.SetBytes(body, "input", prompt)
+	case consts.StyleAnthropic:
+		body, _ = sjson.SetBytes(body, "max_tokens", max(log.CompletionTokens, 1))
+		body, _ = sjson.SetBytes(body, "messages", messages)
+	default:
+		body, _ = sjson.SetBytes(body, "messages", messages)
+		body, _ = sjson.SetBytes(body, "stream_options.include_usage", true)
+	}
+	return body
+}
+
+// replayOne 回放单个请求：按当前配置选择供应商、执行格式转换，发送到 mock 上游并解析响应
+func (j *replayJob) replayOne(ctx context.Context, client *http.Client, baseURL string, log models.ChatLog, input string) {
+	inFlight := j.inFlight.Add(1)
+	defer j.inFlight.Add(-1)
+	j.mu.Lock()
+	j.report.PeakInFlight = max(j.report.PeakInFlight, inFlight)
+	j.mu.Unlock()
+
+	start := time.Now()
+	unroutable, err := j.send(ctx, client, baseURL, log, input)
+	if ctx.Err() != nil {
+		return
+	}
+	latency := time.Since(start)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stats, ok := j.report.Models[log.Name]
+	if !ok {
+		stats = &ReplayModelStats{}
+		j.report.Models[log.Name] = stats
+	}
+	stats.Requests++
+	j.report.Completed++
+	switch {
+	case unroutable:
+		stats.Unroutable++
+		j.report.Unroutable++
+	case err != nil:
+		stats.Failed++
+		j.report.Failed++
+	default:
+		stats.Succeeded++
+		j.report.Succeeded++
+		j.latencies = append(j.latencies, latency)
+	}
+	if err != nil && len(j.report.Errors) < maxReplayErrors {
+		j.report.Errors = append(j.report.Errors, fmt.Sprintf("log %d (%s): %v", log.ID, log.Name, err))
+	}
+}
+
+func (j *replayJob) send(ctx context.Context, client *http.Client, baseURL string, log models.ChatLog, input string) (bool, error) {
+	style := replayStyles[log.Style]
+	body := []byte(input)
+	if input == "" {
+		body = syntheticReplayBody(log)
+	}
+	before, err := style.beforer(body)
+	if err != nil {
+		return false, err
+	}
+
+	meta, err := loadProvidersWithMeta(ctx, *before)
+	if err != nil {
+		return true, err
+	}
+	id, err := candidatesOf(*meta).pick()
+	if err != nil {
+		return true, err
+	}
+	provider := meta.ProviderMap[meta.ModelWithProviderMap[*id].ProviderID]
+	wireStyle := providers.WireStyle(provider.Type)
+	providerStyle, ok := replayStyles[wireStyle]
+	if !ok {
+		return false, fmt.Errorf("unsupported provider type %s", provider.Type)
+	}
+
+	requestBody := before.raw
+	tm := NewTransformerManager(log.Style, wireStyle)
+	if log.Style != wireStyle {
+		if requestBody, err = tm.ProcessRequest(ctx, before.raw); err != nil {
+			return false, fmt.Errorf("transform request error: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+providerStyle.path, strings.NewReader(string(requestBody)))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replayHeaderFirstChunk, strconv.FormatInt(log.FirstChunkTime.Milliseconds(), 10))
+	req.Header.Set(replayHeaderDuration, strconv.FormatInt(log.ChunkTime.Milliseconds(), 10))
+	req.Header.Set(replayHeaderPrompt, strconv.FormatInt(log.PromptTokens, 10))
+	req.Header.Set(replayHeaderTokens, strconv.FormatInt(log.CompletionTokens, 10))
+
+	reqStart := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	if res.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return false, fmt.Errorf("status: %d, body: %s", res.StatusCode, data)
+	}
+	if log.Style != wireStyle {
+		if res, err = tm.ProcessResponse(res); err != nil {
+			return false, fmt.Errorf("transform response error: %w", err)
+		}
+	}
+	defer res.Body.Close()
+
+	if _, _, err := style.processer(ctx, res.Body, before.Stream, reqStart); err != nil {
+		return false, fmt.Errorf("processer error: %w", err)
+	}
+	return false, nil
+}
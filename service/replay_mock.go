@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+)
+
+// 回放请求通过请求头告诉 mock 上游需要模拟的响应形态
+const (
+	replayHeaderFirstChunk = "X-Replay-First-Chunk-Ms"
+	replayHeaderDuration   = "X-Replay-Duration-Ms"
+	replayHeaderTokens     = "X-Replay-Completion-Tokens"
+	replayHeaderPrompt     = "X-Replay-Prompt-Tokens"
+
+	maxReplayChunks = 50
+)
+
+// mockProfile mock 上游的响应形态，取自原始日志
+type mockProfile struct {
+	firstChunk       time.Duration
+	duration         time.Duration
+	promptTokens     int
+	completionTokens int
+}
+
+func parseMockProfile(header http.Header) mockProfile {
+	ms := func(key string) time.Duration {
+		v, _ := strconv.Atoi(header.Get(key))
+		return time.Duration(v) * time.Millisecond
+	}
+	prompt, _ := strconv.Atoi(header.Get(replayHeaderPrompt))
+	completion, _ := strconv.Atoi(header.Get(replayHeaderTokens))
+	if completion <= 0 {
+		completion = 1
+	}
+	return mockProfile{
+		firstChunk:       ms(replayHeaderFirstChunk),
+		duration:         ms(replayHeaderDuration),
+		promptTokens:     prompt,
+		completionTokens: completion,
+	}
+}
+
+// newMockUpstream 创建模拟上游，按路径区分 OpenAI / Responses / Anthropic 格式，按 profile 模拟首字与生成耗时
+func newMockUpstream() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		serveMock(w, r, consts.StyleOpenAI)
+	})
+	mux.HandleFunc("POST /responses", func(w http.ResponseWriter, r *http.Request) {
+		serveMock(w, r, consts.StyleOpenAIRes)
+	})
+	mux.HandleFunc("POST /messages", func(w http.ResponseWriter, r *http.Request) {
+		serveMock(w, r, consts.StyleAnthropic)
+	})
+	return mux
+}
+
+func serveMock(w http.ResponseWriter, r *http.Request, style string) {
+	var body struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile := parseMockProfile(r.Header)
+
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(profile.firstChunk):
+	}
+
+	if !body.Stream {
+		// 非流式：剩余生成耗时同样需要等待
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(profile.duration):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockBody(style, body.Model, profile)))
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	chunks := min(profile.completionTokens, maxReplayChunks)
+	interval := profile.duration / time.Duration(chunks)
+	events := mockStream(style, body.Model, profile, chunks)
+	for i, event := range events {
+		// 首尾事件立即发送，内容事件均匀分布在生成耗时内
+		if i > 0 && i <= chunks && interval > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+		w.Write([]byte(event))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func mockText(tokens int) string {
+	return strings.Repeat("replay ", tokens)
+}
+
+func mustJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func mockBody(style, model string, p mockProfile) string {
+	text := mockText(p.completionTokens)
+	switch style {
+	case consts.StyleOpenAIRes:
+		return mustJSON(map[string]any{
+			"id": "resp_replay", "object": "response", "status": "completed", "model": model,
+			"output": []any{map[string]any{
+				"type": "message", "role": "assistant",
+				"content": []any{map[string]any{"type": "output_text", "text": text}},
+			}},
+			"usage": map[string]any{"input_tokens": p.promptTokens, "output_tokens": p.completionTokens, "total_tokens": p.promptTokens + p.completionTokens},
+		})
+	case consts.StyleAnthropic:
+		return mustJSON(map[string]any{
+			"id": "msg_replay", "type": "message", "role": "assistant", "model": model,
+			"content":     []any{map[string]any{"type": "text", "text": text}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]any{"input_tokens": p.promptTokens, "output_tokens": p.completionTokens},
+		})
+	default:
+		return mustJSON(map[string]any{
+			"id": "chatcmpl-replay", "object": "chat.completion", "created": time.Now().Unix(), "model": model,
+			"choices": []any{map[string]any{
+				"index": 0, "finish_reason": "stop",
+				"message": map[string]any{"role": "assistant", "content": text},
+			}},
+			"usage": map[string]any{"prompt_tokens": p.promptTokens, "completion_tokens": p.completionTokens, "total_tokens": p.promptTokens + p.completionTokens},
+		})
+	}
+}
+
+// mockStream 生成 SSE 事件：首个事件为开始标记，随后 chunks 个内容事件，最后为结束事件
+func mockStream(style, model string, p mockProfile, chunks int) []string {
+	piece := mockText(max(p.completionTokens/chunks, 1))
+	events := make([]string, 0, chunks+3)
+	switch style {
+	case consts.StyleOpenAIRes:
+		sse := func(event string, data map[string]any) string {
+			data["type"] = event
+			return fmt.Sprintf("event: %s\ndata: %s\n\n", event, mustJSON(data))
+		}
+		events = append(events, sse("response.created", map[string]any{"response": map[string]any{"id": "resp_replay", "model": model, "status": "in_progress"}}))
+		for range chunks {
+			events = append(events, sse("response.output_text.delta", map[string]any{"output_index": 0, "content_index": 0, "delta": piece}))
+		}
+		events = append(events, sse("response.completed", map[string]any{"response": map[string]any{
+			"id": "resp_replay", "model": model, "status": "completed",
+			"usage": map[string]any{"input_tokens": p.promptTokens, "output_tokens": p.completionTokens, "total_tokens": p.promptTokens + p.completionTokens},
+		}}))
+	case consts.StyleAnthropic:
+		sse := func(event string, data map[string]any) string {
+			data["type"] = event
+			return fmt.Sprintf("event: %s\ndata: %s\n\n", event, mustJSON(data))
+		}
+		events = append(events, sse("message_start", map[string]any{"message": map[string]any{
+			"id": "msg_replay", "type": "message", "role": "assistant", "model": model, "content": []any{},
+			"usage": map[string]any{"input_tokens": p.promptTokens, "output_tokens": 0},
+		}})+sse("content_block_start", map[string]any{"index": 0, "content_block": map[string]any{"type": "text", "text": ""}}))
+		for range chunks {
+			events = append(events, sse("content_block_delta", map[string]any{"index": 0, "delta": map[string]any{"type": "text_delta", "text": piece}}))
+		}
+		events = append(events, sse("content_block_stop", map[string]any{"index": 0})+
+			sse("message_delta", map[string]any{"delta": map[string]any{"stop_reason": "end_turn"}, "usage": map[string]any{"input_tokens": p.promptTokens, "output_tokens": p.completionTokens}})+
+			sse("message_stop", map[string]any{}))
+	default:
+		chunk := func(delta map[string]any, finish any) string {
+			return "data: " + mustJSON(map[string]any{
+				"id": "chatcmpl-replay", "object": "chat.completion.chunk", "created": time.Now().Unix(), "model": model,
+				"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+			}) + "\n\n"
+		}
+		events = append(events, chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+		for range chunks {
+			events = append(events, chunk(map[string]any{"content": piece}, nil))
+		}
+		usage := "data: " + mustJSON(map[string]any{
+			"id": "chatcmpl-replay", "object": "chat.completion.chunk", "created": time.Now().Unix(), "model": model, "choices": []any{},
+			"usage": map[string]any{"prompt_tokens": p.promptTokens, "completion_tokens": p.completionTokens, "total_tokens": p.promptTokens + p.completionTokens},
+		}) + "\n\n"
+		events = append(events, chunk(map[string]any{}, "stop")+usage+"data: [DONE]\n\n")
+	}
+	return events
+}
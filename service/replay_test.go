@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+func TestSyntheticReplayBody(t *testing.T) {
+	tests := []struct {
+		name string
+		log  models.ChatLog
+		want map[string]string // gjson 路径 -> 期望的原始 JSON，空字符串表示字段不存在
+	}{
+		{
+			name: "openai",
+			log:  models.ChatLog{Name: "m", Style: consts.StyleOpenAI, Usage: models.Usage{PromptTokens: 2}},
+			want: map[string]string{
+				"model":                        `"m"`,
+				"stream":                       `true`,
+				"messages":                     `[{"content":"replay replay","role":"user"}]`,
+				"stream_options.include_usage": `true`,
+				"max_tokens":                   ``,
+			},
+		},
+		{
+			name: "anthropic",
+			log:  models.ChatLog{Name: "m", Style: consts.StyleAnthropic, Usage: models.Usage{CompletionTokens: 7}},
+			want: map[string]string{
+				"messages":       `[{"content":"replay","role":"user"}]`,
+				"max_tokens":     `7`,
+				"stream_options": ``,
+			},
+		},
+		{
+			name: "openai responses",
+			log:  models.ChatLog{Name: "m", Style: consts.StyleOpenAIRes, Usage: models.Usage{PromptTokens: 1}},
+			want: map[string]string{"input": `"replay"`, "messages": ``},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := syntheticReplayBody(tt.log)
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(body, path).Raw; got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	model := testutil.SeedModel(t, "replay-model")
+	provider := testutil.SeedProvider(t, "replay", consts.StyleOpenAI, "http://127.0.0.1:1")
+	testutil.SeedAssociation(t, model, provider, "upstream-model", 100, 1)
+
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)
+	at := func(seconds int) time.Time { return day.Add(10*time.Hour + time.Duration(seconds)*time.Second) }
+	logs := []models.ChatLog{
+		{Model: gormModel(at(0)), Name: model.Name, ProviderName: provider.Name, Style: consts.StyleOpenAI, ChatIO: true},
+		{Model: gormModel(at(1)), Name: model.Name, ProviderName: provider.Name, Style: consts.StyleAnthropic, Usage: models.Usage{PromptTokens: 3, CompletionTokens: 2}},
+		{Model: gormModel(at(2)), Name: model.Name, ProviderName: provider.Name, Style: consts.StyleOpenAIRes},
+		{Model: gormModel(at(3)), Name: "deleted-model", ProviderName: provider.Name, Style: consts.StyleOpenAI},
+		// 以下记录不参与回放：未路由到供应商、不支持的格式、不在回放日期内
+		{Model: gormModel(at(4)), Name: model.Name, Style: consts.StyleOpenAI},
+		{Model: gormModel(at(5)), Name: model.Name, ProviderName: provider.Name, Style: "gemini"},
+		{Model: gormModel(day.Add(-time.Hour)), Name: model.Name, ProviderName: provider.Name, Style: consts.StyleOpenAI},
+	}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatalf("seed logs: %v", err)
+	}
+	chatIO := models.ChatIO{LogId: logs[0].ID, Input: `{"model":"replay-model","messages":[{"role":"user","content":"hi"}]}`}
+	if err := models.DB.Create(&chatIO).Error; err != nil {
+		t.Fatalf("seed chat io: %v", err)
+	}
+
+	if _, err := StartReplay(ctx, ReplayOptions{Date: "2026-01-03"}); err == nil || err.Error() != "no logs found on 2026-01-03" {
+		t.Errorf("empty day err = %v", err)
+	}
+	if _, err := StartReplay(ctx, ReplayOptions{Date: "01/02/2026"}); err == nil {
+		t.Error("Expected invalid date to fail")
+	}
+
+	report, err := StartReplay(ctx, ReplayOptions{Date: "2026-01-02", Speed: 1000})
+	if err != nil {
+		t.Fatalf("StartReplay failed: %v", err)
+	}
+	if report.Status != "running" || report.Scheduled != 4 || report.Options.SampleRate != 1 || report.Options.MaxConcurrency != defaultReplayConcurrency {
+		t.Errorf("start report = %+v", report)
+	}
+	if _, err := StartReplay(ctx, ReplayOptions{Date: "2026-01-02"}); err == nil {
+		t.Error("Expected concurrent replay to fail")
+	}
+
+	for range 100 {
+		if report, _ = GetReplay(); report.Status != "running" {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if report.Status != "completed" || report.Completed != 4 || report.Succeeded != 3 || report.Unroutable != 1 || report.Failed != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if stats := report.Models[model.Name]; stats == nil || *stats != (ReplayModelStats{Requests: 3, Succeeded: 3}) {
+		t.Errorf("model stats = %+v", stats)
+	}
+	if stats := report.Models["deleted-model"]; stats == nil || stats.Unroutable != 1 || len(report.Errors) != 1 {
+		t.Errorf("unroutable stats = %+v, errors = %v", stats, report.Errors)
+	}
+	if CancelReplay() {
+		t.Error("Expected cancel of finished replay to be a no-op")
+	}
+}
+
+func gormModel(createdAt time.Time) gorm.Model {
+	return gorm.Model{CreatedAt: createdAt}
+}
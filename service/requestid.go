@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader 请求 ID 的请求头与响应头
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID 将请求 ID 保存到 context，后续写入日志并透传给上游
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 获取 context 中的请求 ID，没有时返回空字符串
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID 生成 32 位十六进制的请求 ID
+func NewRequestID() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// setRequestIDHeader 在发往上游的请求头中携带请求 ID
+func setRequestIDHeader(ctx context.Context, header http.Header) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		header.Set(RequestIDHeader, id)
+	}
+}
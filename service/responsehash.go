@@ -0,0 +1,53 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+)
+
+// ResponseHasher 计算上游原始响应（格式转换前）的 SHA-256 与字节数，用于与供应商核对账单
+type ResponseHasher struct {
+	mu   sync.Mutex
+	hash hash.Hash
+	size int64
+}
+
+// NewResponseHasher 创建响应摘要计算器
+func NewResponseHasher() *ResponseHasher {
+	return &ResponseHasher{hash: sha256.New()}
+}
+
+// Wrap 在读取响应体的同时计算摘要，重试时覆盖上一次的内容
+func (h *ResponseHasher) Wrap(body io.ReadCloser) io.ReadCloser {
+	h.mu.Lock()
+	h.hash.Reset()
+	h.size = 0
+	h.mu.Unlock()
+	return &hashingReader{ReadCloser: body, hasher: h}
+}
+
+// Sum 返回十六进制摘要与响应字节数
+func (h *ResponseHasher) Sum() (string, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return hex.EncodeToString(h.hash.Sum(nil)), h.size
+}
+
+type hashingReader struct {
+	io.ReadCloser
+	hasher *ResponseHasher
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.hasher.mu.Lock()
+		r.hasher.hash.Write(p[:n])
+		r.hasher.size += int64(n)
+		r.hasher.mu.Unlock()
+	}
+	return n, err
+}
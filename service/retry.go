@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// 重试策略终止重试时返回的错误
+var (
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+	ErrRetryElapsedExceeded = errors.New("retry max elapsed time exceeded")
+)
+
+// RetryPolicy 模型的重试退避策略，零值表示立即重试且不限制
+type RetryPolicy struct {
+	Backoff    time.Duration // 首次重试前的退避时间
+	MaxBackoff time.Duration // 单次退避上限，0 表示不限制
+	MaxElapsed time.Duration // 自首次尝试起允许重试的最长时间，0 表示不限制
+	Budget     int           // 每分钟允许的重试次数，0 表示不限制
+}
+
+// ModelRetryPolicy 读取模型配置的重试策略
+func ModelRetryPolicy(model models.Model) RetryPolicy {
+	return RetryPolicy{
+		Backoff:    time.Duration(model.RetryBackoffMs) * time.Millisecond,
+		MaxBackoff: time.Duration(model.RetryBackoffMaxMs) * time.Millisecond,
+		MaxElapsed: time.Duration(model.RetryMaxElapsedMs) * time.Millisecond,
+		Budget:     model.RetryBudget,
+	}
+}
+
+// delay 计算第 retry 次重试前的等待时间：指数退避加全抖动，超出时长或预算时返回错误
+func (p RetryPolicy) delay(model string, retry int, elapsed time.Duration) (time.Duration, error) {
+	if p.MaxElapsed > 0 && elapsed >= p.MaxElapsed {
+		return 0, ErrRetryElapsedExceeded
+	}
+	if p.Budget > 0 && !retryBudgets.take(model, p.Budget) {
+		return 0, ErrRetryBudgetExhausted
+	}
+	if p.Backoff <= 0 {
+		return 0, nil
+	}
+	backoff := p.Backoff << min(retry-1, 20)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	wait := rand.N(backoff) + 1
+	// 退避不能超过剩余的重试时长
+	if p.MaxElapsed > 0 && elapsed+wait > p.MaxElapsed {
+		wait = p.MaxElapsed - elapsed
+	}
+	return wait, nil
+}
+
+// retryBudget 按模型统计每分钟的重试次数
+type retryBudget struct {
+	mu      sync.Mutex
+	windows map[string]*retryWindow
+}
+
+type retryWindow struct {
+	minute int64
+	count  int
+}
+
+var retryBudgets = &retryBudget{windows: make(map[string]*retryWindow)}
+
+// take 消耗一次重试预算，当前分钟已用尽时返回 false；配置 Redis 时预算由各实例共享，Redis 不可用时放行
+func (b *retryBudget) take(model string, budget int) bool {
+	minute := time.Now().Unix() / 60
+	if redisClient != nil {
+		key := redisKey("retry", strconv.FormatInt(minute, 10), model)
+		ctx := context.Background()
+		count, err := redisClient.Incr(ctx, key).Result()
+		if err != nil {
+			slog.Error("redis retry budget update failed", "error", err)
+			return true
+		}
+		if count == 1 {
+			redisClient.Expire(ctx, key, 2*time.Minute)
+		}
+		return count <= int64(budget)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	window, ok := b.windows[model]
+	if !ok || window.minute != minute {
+		window = &retryWindow{minute: minute}
+		b.windows[model] = window
+	}
+	if window.count >= budget {
+		return false
+	}
+	window.count++
+	return true
+}
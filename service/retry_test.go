@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for retry, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		delay, err := policy.delay("retry-delay", retry, 0)
+		if err != nil {
+			t.Fatalf("retry %d: unexpected error %v", retry, err)
+		}
+		if delay <= 0 || delay > limit {
+			t.Errorf("retry %d: delay %v outside (0, %v]", retry, delay, limit)
+		}
+	}
+
+	if delay, _ := (RetryPolicy{}).delay("retry-zero", 3, 0); delay != 0 {
+		t.Errorf("Expected zero policy to retry immediately, got %v", delay)
+	}
+
+	elapsed := RetryPolicy{Backoff: time.Second, MaxElapsed: 500 * time.Millisecond}
+	if delay, err := elapsed.delay("retry-elapsed", 1, 400*time.Millisecond); err != nil || delay > 100*time.Millisecond {
+		t.Errorf("Expected delay clamped to remaining time, got %v %v", delay, err)
+	}
+	if _, err := elapsed.delay("retry-elapsed", 2, 500*time.Millisecond); !errors.Is(err, ErrRetryElapsedExceeded) {
+		t.Errorf("Expected ErrRetryElapsedExceeded, got %v", err)
+	}
+
+	budget := RetryPolicy{Budget: 2}
+	for i := range 2 {
+		if _, err := budget.delay("retry-budget", i+1, 0); err != nil {
+			t.Fatalf("retry %d: unexpected error %v", i+1, err)
+		}
+	}
+	if _, err := budget.delay("retry-budget", 3, 0); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("Expected ErrRetryBudgetExhausted, got %v", err)
+	}
+}
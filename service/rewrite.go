@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 请求改写操作
+const (
+	RewriteSet          = "set"
+	RewriteDelete       = "delete"
+	RewriteRename       = "rename"
+	RewriteSetHeader    = "set_header"
+	RewriteDeleteHeader = "delete_header"
+)
+
+// ValidateRequestRewrites 校验改写规则的操作、路径与值
+func ValidateRequestRewrites(rules []models.RequestRewrite) error {
+	for i, rule := range rules {
+		if rule.Path == "" {
+			return fmt.Errorf("rule %d: path is required", i)
+		}
+		switch rule.Op {
+		case RewriteSet:
+			if !json.Valid(rule.Value) {
+				return fmt.Errorf("rule %d: value must be valid JSON", i)
+			}
+		case RewriteSetHeader:
+			var value string
+			if err := json.Unmarshal(rule.Value, &value); err != nil {
+				return fmt.Errorf("rule %d: header value must be a string", i)
+			}
+		case RewriteRename:
+			if rule.To == "" {
+				return fmt.Errorf("rule %d: rename requires to", i)
+			}
+		case RewriteDelete, RewriteDeleteHeader:
+		default:
+			return fmt.Errorf("rule %d: unknown op %q", i, rule.Op)
+		}
+	}
+	return nil
+}
+
+// ApplyRequestRewrites 按顺序对发往上游的请求体与请求头执行改写，路径不存在的 delete/rename 直接跳过
+func ApplyRequestRewrites(body []byte, header http.Header, rules []models.RequestRewrite) ([]byte, error) {
+	var err error
+	for _, rule := range rules {
+		switch rule.Op {
+		case RewriteSet:
+			body, err = sjson.SetRawBytes(body, rule.Path, rule.Value)
+		case RewriteDelete:
+			body, err = sjson.DeleteBytes(body, rule.Path)
+		case RewriteRename:
+			value := gjson.GetBytes(body, rule.Path)
+			if !value.Exists() {
+				continue
+			}
+			if body, err = sjson.DeleteBytes(body, rule.Path); err == nil {
+				body, err = sjson.SetRawBytes(body, rule.To, []byte(value.Raw))
+			}
+		case RewriteSetHeader:
+			var value string
+			if err = json.Unmarshal(rule.Value, &value); err == nil {
+				header.Set(rule.Path, value)
+			}
+		case RewriteDeleteHeader:
+			header.Del(rule.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %s %s: %w", rule.Op, rule.Path, err)
+		}
+	}
+	return body, nil
+}
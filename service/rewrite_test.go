@@ -0,0 +1,50 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+func TestApplyRequestRewrites(t *testing.T) {
+	rules := []models.RequestRewrite{
+		{Op: RewriteRename, Path: "max_tokens", To: "max_completion_tokens"},
+		{Op: RewriteRename, Path: "missing", To: "ignored"},
+		{Op: RewriteSet, Path: "enable_thinking", Value: json.RawMessage(`false`)},
+		{Op: RewriteSet, Path: "extra.repetition_penalty", Value: json.RawMessage(`1.05`)},
+		{Op: RewriteDelete, Path: "user"},
+		{Op: RewriteSetHeader, Path: "X-Region", Value: json.RawMessage(`"eu"`)},
+		{Op: RewriteDeleteHeader, Path: "X-Trace"},
+	}
+	if err := ValidateRequestRewrites(rules); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"X-Trace": {"1"}}
+	body, err := ApplyRequestRewrites([]byte(`{"model":"m","max_tokens":100,"user":"u"}`), header, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gjson.ParseBytes(body)
+	if got.Get("max_tokens").Exists() || got.Get("max_completion_tokens").Int() != 100 || got.Get("ignored").Exists() ||
+		got.Get("enable_thinking").Type != gjson.False || got.Get("extra.repetition_penalty").Float() != 1.05 || got.Get("user").Exists() {
+		t.Fatalf("body = %s", body)
+	}
+	if header.Get("X-Region") != "eu" || header.Get("X-Trace") != "" {
+		t.Fatalf("header = %v", header)
+	}
+
+	for _, invalid := range [][]models.RequestRewrite{
+		{{Op: "copy", Path: "a"}},
+		{{Op: RewriteSet, Path: "a", Value: json.RawMessage(`{`)}},
+		{{Op: RewriteRename, Path: "a"}},
+		{{Op: RewriteSetHeader, Path: "X-A", Value: json.RawMessage(`1`)}},
+		{{Op: RewriteDelete}},
+	} {
+		if ValidateRequestRewrites(invalid) == nil {
+			t.Fatalf("rules %+v should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	metricsRollupInterval = time.Minute
+
+	RollupIntervalHour = "hour"
+	RollupIntervalDay  = "day"
+)
+
+// rollupLog 汇总所需的日志字段
+type rollupLog struct {
+	Name             string
+	ProviderName     string
+	ProviderModel    string
+	Status           string
+	SampleWeight     int64
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+	Cost             float64
+	FirstChunkTime   time.Duration
+	ChunkTime        time.Duration
+}
+
+// StartMetricsRollup 启动时补齐历史小时，之后每分钟重算最近的小时
+func StartMetricsRollup(ctx context.Context) {
+	ticker := time.NewTicker(metricsRollupInterval)
+	defer ticker.Stop()
+	for {
+		if err := RollupMetrics(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("metrics rollup error", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollupMetrics 从已有汇总的最后一小时前一小时（覆盖跨小时结束的流式请求）重算到 now 所在小时；
+// 首次运行时从最早的日志开始补齐
+func RollupMetrics(ctx context.Context, now time.Time) error {
+	var from time.Time
+	latest, err := gorm.G[models.MetricsRollup](models.DB).Order("hour DESC").Limit(1).Find(ctx)
+	if err != nil {
+		return err
+	}
+	if len(latest) > 0 {
+		from = latest[0].Hour.Add(-time.Hour)
+	} else {
+		oldest, err := gorm.G[models.ChatLog](models.DB).Order("id").Limit(1).Find(ctx)
+		if err != nil || len(oldest) == 0 {
+			return err
+		}
+		from = oldest[0].CreatedAt
+	}
+
+	current := now.Truncate(time.Hour)
+	for hour := from.In(now.Location()).Truncate(time.Hour); !hour.After(current); hour = hour.Add(time.Hour) {
+		if err := rollupHour(ctx, hour); err != nil {
+			return fmt.Errorf("rollup %s: %w", hour.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// rollupHour 重新汇总一个小时内的日志，替换该小时已有的汇总
+func rollupHour(ctx context.Context, hour time.Time) error {
+	var logs []rollupLog
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("name, provider_name, provider_model, status, sample_weight, prompt_tokens, completion_tokens, total_tokens, cost, first_chunk_time, chunk_time").
+		Where("created_at >= ? AND created_at < ?", hour, hour.Add(time.Hour)).
+		Scan(&logs).Error; err != nil {
+		return err
+	}
+	rollups := aggregateRollups(hour, logs)
+	return models.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hour = ?", hour).Delete(&models.MetricsRollup{}).Error; err != nil {
+			return err
+		}
+		if len(rollups) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rollups, 100).Error
+	})
+}
+
+func aggregateRollups(hour time.Time, logs []rollupLog) []models.MetricsRollup {
+	type group struct {
+		rollup      models.MetricsRollup
+		firstChunks []time.Duration
+		totals      []time.Duration
+	}
+	groups := make(map[[3]string]*group)
+	for _, log := range logs {
+		key := [3]string{log.Name, log.ProviderName, log.ProviderModel}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{rollup: models.MetricsRollup{Hour: hour, Model: log.Name, ProviderName: log.ProviderName, ProviderModel: log.ProviderModel}}
+			groups[key] = g
+		}
+		stats := &g.rollup.RollupStats
+		weight := log.SampleWeight
+		stats.Requests += weight
+		switch log.Status {
+		case "error":
+			stats.Errors += weight
+		case "cancelled":
+			stats.Cancelled += weight
+		}
+		stats.PromptTokens += log.PromptTokens * weight
+		stats.CompletionTokens += log.CompletionTokens * weight
+		stats.TotalTokens += log.TotalTokens * weight
+		stats.Cost += log.Cost * float64(weight)
+		if log.Status == "success" && log.FirstChunkTime > 0 {
+			g.firstChunks = append(g.firstChunks, log.FirstChunkTime)
+			g.totals = append(g.totals, log.FirstChunkTime+log.ChunkTime)
+		}
+	}
+
+	rollups := make([]models.MetricsRollup, 0, len(groups))
+	for _, g := range groups {
+		stats := &g.rollup.RollupStats
+		stats.LatencySamples = int64(len(g.firstChunks))
+		stats.FirstChunkAvg, stats.FirstChunkP50, stats.FirstChunkP90, stats.FirstChunkP99 = latencySummary(g.firstChunks)
+		stats.TotalAvg, stats.TotalP50, stats.TotalP90, stats.TotalP99 = latencySummary(g.totals)
+		rollups = append(rollups, g.rollup)
+	}
+	slices.SortFunc(rollups, func(a, b models.MetricsRollup) int {
+		return cmp.Or(cmp.Compare(a.Model, b.Model), cmp.Compare(a.ProviderName, b.ProviderName), cmp.Compare(a.ProviderModel, b.ProviderModel))
+	})
+	return rollups
+}
+
+// latencySummary 返回平均值与 p50/p90/p99（毫秒）
+func latencySummary(samples []time.Duration) (avg, p50, p90, p99 float64) {
+	if len(samples) == 0 {
+		return 0, 0, 0, 0
+	}
+	slices.Sort(samples)
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	avg = float64(total) / float64(len(samples)) / float64(time.Millisecond)
+	return avg, percentileMs(samples, 0.50), percentileMs(samples, 0.90), percentileMs(samples, 0.99)
+}
+
+// mergeRollupStats 合并两组汇总；小时级百分位无法精确合并，按时延样本数加权平均近似
+func mergeRollupStats(dst *models.RollupStats, src models.RollupStats) {
+	samples := dst.LatencySamples + src.LatencySamples
+	if samples > 0 {
+		weighted := func(a, b float64) float64 {
+			return (a*float64(dst.LatencySamples) + b*float64(src.LatencySamples)) / float64(samples)
+		}
+		dst.FirstChunkAvg = weighted(dst.FirstChunkAvg, src.FirstChunkAvg)
+		dst.FirstChunkP50 = weighted(dst.FirstChunkP50, src.FirstChunkP50)
+		dst.FirstChunkP90 = weighted(dst.FirstChunkP90, src.FirstChunkP90)
+		dst.FirstChunkP99 = weighted(dst.FirstChunkP99, src.FirstChunkP99)
+		dst.TotalAvg = weighted(dst.TotalAvg, src.TotalAvg)
+		dst.TotalP50 = weighted(dst.TotalP50, src.TotalP50)
+		dst.TotalP90 = weighted(dst.TotalP90, src.TotalP90)
+		dst.TotalP99 = weighted(dst.TotalP99, src.TotalP99)
+	}
+	dst.LatencySamples = samples
+	dst.Requests += src.Requests
+	dst.Errors += src.Errors
+	dst.Cancelled += src.Cancelled
+	dst.PromptTokens += src.PromptTokens
+	dst.CompletionTokens += src.CompletionTokens
+	dst.TotalTokens += src.TotalTokens
+	dst.Cost += src.Cost
+}
+
+// RollupQuery 聚合指标查询条件，Model 与 Provider 为空表示不过滤
+type RollupQuery struct {
+	Since    time.Time
+	Interval string // hour / day
+	Model    string
+	Provider string
+}
+
+// RollupPoint 一个时间桶内某模型-供应商-供应商模型的聚合指标
+type RollupPoint struct {
+	Time          time.Time `json:"time"`
+	Model         string    `json:"model"`
+	ProviderName  string    `json:"provider_name"`
+	ProviderModel string    `json:"provider_model"`
+	models.RollupStats
+}
+
+// QueryRollups 按小时或按天（本地时区）返回时间序列，按时间、模型、供应商排序
+func QueryRollups(ctx context.Context, query RollupQuery) ([]RollupPoint, error) {
+	chain := gorm.G[models.MetricsRollup](models.DB).Where("hour >= ?", query.Since)
+	if query.Model != "" {
+		chain = chain.Where("model = ?", query.Model)
+	}
+	if query.Provider != "" {
+		chain = chain.Where("provider_name = ?", query.Provider)
+	}
+	rows, err := chain.Order("hour").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct {
+		time                           int64
+		model, provider, providerModel string
+	}
+	points := make([]RollupPoint, 0)
+	index := make(map[bucketKey]int)
+	for _, row := range rows {
+		bucket := row.Hour.In(query.Since.Location())
+		if query.Interval == RollupIntervalDay {
+			year, month, day := bucket.Date()
+			bucket = time.Date(year, month, day, 0, 0, 0, 0, bucket.Location())
+		}
+		key := bucketKey{bucket.Unix(), row.Model, row.ProviderName, row.ProviderModel}
+		if i, ok := index[key]; ok {
+			mergeRollupStats(&points[i].RollupStats, row.RollupStats)
+			continue
+		}
+		index[key] = len(points)
+		points = append(points, RollupPoint{
+			Time:          bucket,
+			Model:         row.Model,
+			ProviderName:  row.ProviderName,
+			ProviderModel: row.ProviderModel,
+			RollupStats:   row.RollupStats,
+		})
+	}
+	slices.SortStableFunc(points, func(a, b RollupPoint) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(a.Model, b.Model), cmp.Compare(a.ProviderName, b.ProviderName), cmp.Compare(a.ProviderModel, b.ProviderModel))
+	})
+	return points, nil
+}
+
+// RollupTotals since 之后的汇总请求数、token 数与取消数
+func RollupTotals(ctx context.Context, since time.Time) (models.RollupStats, error) {
+	var totals models.RollupStats
+	err := models.DB.WithContext(ctx).Model(&models.MetricsRollup{}).
+		Select("COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(total_tokens), 0) AS total_tokens, COALESCE(SUM(cancelled), 0) AS cancelled").
+		Where("hour >= ?", since).
+		Scan(&totals).Error
+	return totals, err
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func rollupTestLog(createdAt time.Time, provider, status string, weight int, firstChunk time.Duration) models.ChatLog {
+	log := models.ChatLog{
+		Name:           "gpt-4o",
+		ProviderName:   provider,
+		ProviderModel:  "gpt-4o-2024",
+		Status:         status,
+		SampleWeight:   weight,
+		FirstChunkTime: firstChunk,
+		ChunkTime:      time.Second,
+		Cost:           0.5,
+	}
+	log.CreatedAt = createdAt
+	log.TotalTokens = 100
+	return log
+}
+
+func TestRollupMetrics(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 3, 10, 15, 20, 0, 0, time.Local)
+	earlier := now.Add(-26 * time.Hour).Truncate(time.Hour)
+
+	logs := []models.ChatLog{
+		rollupTestLog(earlier.Add(5*time.Minute), "openai", "success", 1, 100*time.Millisecond),
+		rollupTestLog(earlier.Add(10*time.Minute), "openai", "success", 1, 300*time.Millisecond),
+		rollupTestLog(earlier.Add(15*time.Minute), "openai", "error", 1, 0),
+		rollupTestLog(earlier.Add(20*time.Minute), "azure", "success", 4, 200*time.Millisecond), // 采样记录代表 4 个请求
+		rollupTestLog(now.Add(-10*time.Minute), "openai", "cancelled", 1, 0),
+	}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := RollupMetrics(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+
+	rollups := loadRollups(t)
+	if len(rollups) != 3 {
+		t.Fatalf("rollups = %+v, want 3 rows", rollups)
+	}
+	azure, openai := rollups[0], rollups[1]
+	if !azure.Hour.Equal(earlier) || azure.ProviderName != "azure" || azure.Requests != 4 || azure.TotalTokens != 400 || azure.Cost != 2 {
+		t.Errorf("azure rollup = %+v", azure)
+	}
+	if openai.Requests != 3 || openai.Errors != 1 || openai.LatencySamples != 2 ||
+		openai.FirstChunkAvg != 200 || openai.FirstChunkP50 != 100 || openai.TotalAvg != 1200 || openai.TotalP50 != 1100 {
+		t.Errorf("openai rollup = %+v", openai)
+	}
+	if current := rollups[2]; !current.Hour.Equal(now.Truncate(time.Hour)) || current.Cancelled != 1 {
+		t.Errorf("current hour rollup = %+v", current)
+	}
+
+	// 之后的运行只重算最近的小时：新日志计入当前小时，已清理的旧日志不影响历史汇总
+	late := rollupTestLog(now.Add(-5*time.Minute), "openai", "success", 1, 50*time.Millisecond)
+	if err := models.DB.Create(&late).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := models.DB.Where("created_at < ?", now.Add(-time.Hour)).Delete(&models.ChatLog{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := RollupMetrics(ctx, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	rollups = loadRollups(t)
+	if len(rollups) != 3 || rollups[0].Requests != 4 || rollups[1].Requests != 3 || rollups[2].Requests != 2 || rollups[2].LatencySamples != 1 {
+		t.Fatalf("rollups after rerun = %+v", rollups)
+	}
+
+	totals, err := RollupTotals(ctx, earlier)
+	if err != nil || totals.Requests != 9 || totals.Cancelled != 1 {
+		t.Errorf("totals = %+v, %v", totals, err)
+	}
+	points, err := QueryRollups(ctx, RollupQuery{Since: earlier.Add(-24 * time.Hour), Interval: RollupIntervalDay, Provider: "openai"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Requests != 3 || points[0].FirstChunkP50 != 100 || points[1].Requests != 2 {
+		t.Errorf("daily points = %+v", points)
+	}
+}
+
+func TestMergeRollupStats(t *testing.T) {
+	merged := models.RollupStats{Requests: 10, LatencySamples: 1, FirstChunkP90: 100}
+	mergeRollupStats(&merged, models.RollupStats{Requests: 5, Errors: 2, LatencySamples: 3, FirstChunkP90: 200})
+	if merged.Requests != 15 || merged.Errors != 2 || merged.LatencySamples != 4 || merged.FirstChunkP90 != 175 {
+		t.Errorf("merged = %+v", merged)
+	}
+	// 没有时延样本的汇总不改变百分位
+	mergeRollupStats(&merged, models.RollupStats{Requests: 1})
+	if merged.FirstChunkP90 != 175 || merged.Requests != 16 {
+		t.Errorf("merged = %+v", merged)
+	}
+}
+
+func loadRollups(t *testing.T) []models.MetricsRollup {
+	t.Helper()
+	var rollups []models.MetricsRollup
+	if err := models.DB.Order("hour, model, provider_name").Find(&rollups).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rollups
+}
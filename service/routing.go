@@ -0,0 +1,174 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+// 模型的供应商选择策略
+const (
+	RoutingStrategyWeight  = "weight"  // 按优先级与权重选择，默认策略
+	RoutingStrategyCost    = "cost"    // 优先选择定价最低的关联
+	RoutingStrategyLatency = "latency" // 优先选择近期首字时延 p95 最低的关联
+)
+
+const (
+	routingLatencyWindow     = time.Hour   // 统计首字时延的日志窗口
+	routingLatencyMinSamples = 5           // 样本少于该数量的关联视为没有时延数据
+	routingLatencyTTL        = time.Minute // 时延统计的缓存时间，避免每个请求都查询日志
+)
+
+// ValidRoutingStrategy 是否为合法的供应商选择策略，空字符串表示按优先级与权重
+func ValidRoutingStrategy(strategy string) bool {
+	switch strategy {
+	case "", RoutingStrategyWeight, RoutingStrategyCost, RoutingStrategyLatency:
+		return true
+	}
+	return false
+}
+
+// applyRoutingStrategy 按策略的评分改写候选的优先级，评分越低优先级越高
+func applyRoutingStrategy(ctx context.Context, strategy string, priorityItems map[uint]int) error {
+	if len(priorityItems) == 0 {
+		return nil
+	}
+	ids := slices.Collect(maps.Keys(priorityItems))
+	var scores map[uint]float64
+	var err error
+	switch strategy {
+	case RoutingStrategyCost:
+		scores, err = costScores(ctx, ids)
+	case RoutingStrategyLatency:
+		scores, err = routingLatency.scores(ctx, ids, time.Now())
+	default:
+		return nil
+	}
+	if err != nil || len(scores) == 0 {
+		return err
+	}
+	rankByScore(priorityItems, scores)
+	return nil
+}
+
+// rankByScore 按评分从低到高重新分配优先级，评分相同的关联优先级相同、按权重随机选择；
+// 没有评分的关联排在所有有评分的关联之后，彼此之间保持原优先级顺序
+func rankByScore(priorityItems map[uint]int, scores map[uint]float64) {
+	original := maps.Clone(priorityItems)
+	compare := func(a, b uint) int {
+		scoreA, okA := scores[a]
+		scoreB, okB := scores[b]
+		switch {
+		case okA && okB:
+			return cmp.Compare(scoreA, scoreB)
+		case okA:
+			return -1
+		case okB:
+			return 1
+		}
+		return cmp.Compare(original[b], original[a])
+	}
+	ids := slices.Collect(maps.Keys(priorityItems))
+	slices.SortFunc(ids, compare)
+	priority := len(ids)
+	for i, id := range ids {
+		if i > 0 && compare(ids[i-1], id) != 0 {
+			priority--
+		}
+		priorityItems[id] = priority
+	}
+}
+
+// costScores 按关联的定价计算混合单价，按 3:1 的输入输出 token 比例计算；未配置定价的关联没有评分
+func costScores(ctx context.Context, ids []uint) (map[uint]float64, error) {
+	pricings, err := gorm.G[models.Pricing](models.DB).Where("model_with_provider_id IN ?", ids).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[uint]float64, len(pricings))
+	for _, pricing := range pricings {
+		scores[pricing.ModelWithProviderID] = (pricing.InputPrice*3 + pricing.OutputPrice) / 4
+	}
+	return scores, nil
+}
+
+type latencyStat struct {
+	p95       float64
+	ok        bool // 样本是否足够
+	expiresAt time.Time
+}
+
+// latencyCache 缓存各关联最近的首字时延 p95，过期的关联在下次使用时批量重新统计
+type latencyCache struct {
+	mu    sync.Mutex
+	stats map[uint]latencyStat
+}
+
+var routingLatency = &latencyCache{stats: make(map[uint]latencyStat)}
+
+// scores 返回样本足够的关联的首字时延 p95（毫秒）
+func (c *latencyCache) scores(ctx context.Context, ids []uint, now time.Time) (map[uint]float64, error) {
+	c.mu.Lock()
+	var stale []uint
+	for _, id := range ids {
+		if stat, ok := c.stats[id]; !ok || now.After(stat.expiresAt) {
+			stale = append(stale, id)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(stale) > 0 {
+		fresh, err := latencyStats(ctx, stale, now)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		maps.Copy(c.stats, fresh)
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scores := make(map[uint]float64, len(ids))
+	for _, id := range ids {
+		if stat := c.stats[id]; stat.ok {
+			scores[id] = stat.p95
+		}
+	}
+	return scores, nil
+}
+
+// latencyStats 统计窗口内成功请求的首字时延 p95
+func latencyStats(ctx context.Context, ids []uint, now time.Time) (map[uint]latencyStat, error) {
+	var rows []struct {
+		ModelWithProviderID uint
+		FirstChunkTime      time.Duration
+	}
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("model_with_provider_id, first_chunk_time").
+		Where("model_with_provider_id IN ? AND status = ? AND created_at >= ? AND first_chunk_time > 0", ids, "success", now.Add(-routingLatencyWindow)).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	latencies := make(map[uint][]time.Duration, len(ids))
+	for _, row := range rows {
+		latencies[row.ModelWithProviderID] = append(latencies[row.ModelWithProviderID], row.FirstChunkTime)
+	}
+	stats := make(map[uint]latencyStat, len(ids))
+	for _, id := range ids {
+		samples := latencies[id]
+		slices.Sort(samples)
+		stats[id] = latencyStat{
+			p95:       percentileMs(samples, 0.95),
+			ok:        len(samples) >= routingLatencyMinSamples,
+			expiresAt: now.Add(routingLatencyTTL),
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+func TestRoutingStrategy(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	routingLatency = &latencyCache{stats: make(map[uint]latencyStat)}
+
+	model := testutil.SeedModel(t, "gpt-4o")
+	var ids []uint
+	for _, name := range []string{"cheap-slow", "pricey-fast", "unknown"} {
+		provider := testutil.SeedProvider(t, name, consts.StyleOpenAI, "http://127.0.0.1")
+		ids = append(ids, testutil.SeedAssociation(t, model, provider, "gpt-4o", 10, 1).ID)
+	}
+	pricings := []models.Pricing{
+		{ModelWithProviderID: ids[0], InputPrice: 1, OutputPrice: 4},
+		{ModelWithProviderID: ids[1], InputPrice: 5, OutputPrice: 15},
+	}
+	if err := models.DB.Create(&pricings).Error; err != nil {
+		t.Fatal(err)
+	}
+	var logs []models.ChatLog
+	for i := range routingLatencyMinSamples {
+		logs = append(logs,
+			models.ChatLog{Name: "gpt-4o", Status: "success", ModelWithProviderID: ids[0], FirstChunkTime: time.Duration(2000+i) * time.Millisecond},
+			models.ChatLog{Name: "gpt-4o", Status: "success", ModelWithProviderID: ids[1], FirstChunkTime: time.Duration(300+i) * time.Millisecond},
+		)
+	}
+	// 样本不足的关联没有时延评分
+	logs = append(logs, models.ChatLog{Name: "gpt-4o", Status: "success", ModelWithProviderID: ids[2], FirstChunkTime: time.Millisecond})
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		strategy string
+		want     []uint // 优先级由高到低
+	}{
+		{RoutingStrategyCost, []uint{ids[0], ids[1], ids[2]}},
+		{RoutingStrategyLatency, []uint{ids[1], ids[0], ids[2]}},
+	}
+	for _, tt := range tests {
+		if err := models.DB.Model(&model).Update("routing_strategy", tt.strategy).Error; err != nil {
+			t.Fatal(err)
+		}
+		meta, err := loadProvidersWithMeta(ctx, Before{Model: "gpt-4o"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(tt.want); i++ {
+			if meta.PriorityItems[tt.want[i-1]] <= meta.PriorityItems[tt.want[i]] {
+				t.Errorf("%s: priorities = %v, want order %v", tt.strategy, meta.PriorityItems, tt.want)
+				break
+			}
+		}
+		if id, err := candidatesOf(*meta).pick(); err != nil || *id != tt.want[0] {
+			t.Errorf("%s: picked %v (%v), want %d", tt.strategy, id, err, tt.want[0])
+		}
+	}
+}
+
+func TestRankByScoreTies(t *testing.T) {
+	priorities := map[uint]int{1: 5, 2: 5, 3: 9, 4: 1}
+	rankByScore(priorities, map[uint]float64{1: 2.5, 2: 2.5})
+	if priorities[1] != priorities[2] || priorities[2] <= priorities[3] || priorities[3] <= priorities[4] {
+		t.Errorf("priorities = %v", priorities)
+	}
+}
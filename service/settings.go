@@ -0,0 +1,337 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/atopos31/llmio/common"
+	"github.com/atopos31/llmio/models"
+)
+
+// GeneralSettings 全局设置接口管理的权重、优先级、日志保留与提示缓存等设置
+type GeneralSettings struct {
+	StrictCapabilityMatch           bool `json:"strict_capability_match"`
+	AutoWeightDecay                 bool `json:"auto_weight_decay"`
+	AutoWeightDecayDefault          int  `json:"auto_weight_decay_default"`
+	AutoWeightDecayStep             int  `json:"auto_weight_decay_step"`
+	AutoSuccessIncrease             bool `json:"auto_success_increase"`
+	AutoWeightIncreaseStep          int  `json:"auto_weight_increase_step"`
+	AutoWeightIncreaseMax           int  `json:"auto_weight_increase_max"`
+	AutoPriorityDecay               bool `json:"auto_priority_decay"`
+	AutoPriorityDecayDefault        int  `json:"auto_priority_decay_default"`
+	AutoPriorityDecayStep           int  `json:"auto_priority_decay_step"`
+	AutoPriorityDecayThreshold      int  `json:"auto_priority_decay_threshold"`
+	AutoPriorityDecayDisableEnabled bool `json:"auto_priority_decay_disable_enabled"`
+	AutoPriorityIncreaseStep        int  `json:"auto_priority_increase_step"`
+	AutoPriorityIncreaseMax         int  `json:"auto_priority_increase_max"`
+	LogRetentionCount               int  `json:"log_retention_count"`
+	CountHealthCheckAsSuccess       bool `json:"count_health_check_as_success"`
+	CountHealthCheckAsFailure       bool `json:"count_health_check_as_failure"`
+
+	PromptCacheRouting    string `json:"prompt_cache_routing"`     // 请求带有 cache_control 时的路由方式：off、strip、prefer
+	PromptCacheAutoInject bool   `json:"prompt_cache_auto_inject"` // 为发往支持提示缓存的 Anthropic 关联的请求自动添加缓存断点
+	EmptyStreamHandling   string `json:"empty_stream_handling"`    // 流式响应没有任何内容时的处理方式：off、error、failover
+
+	AdaptiveBalancing bool `json:"adaptive_balancing"` // 按近期首字时延与 TPS 自动缩放关联权重
+}
+
+// Values 转换为设置表中的键值
+func (s GeneralSettings) Values() map[string]string {
+	return map[string]string{
+		models.SettingKeyStrictCapabilityMatch:           strconv.FormatBool(s.StrictCapabilityMatch),
+		models.SettingKeyAutoWeightDecay:                 strconv.FormatBool(s.AutoWeightDecay),
+		models.SettingKeyAutoWeightDecayDefault:          strconv.Itoa(s.AutoWeightDecayDefault),
+		models.SettingKeyAutoWeightDecayStep:             strconv.Itoa(s.AutoWeightDecayStep),
+		models.SettingKeyAutoSuccessIncrease:             strconv.FormatBool(s.AutoSuccessIncrease),
+		models.SettingKeyAutoWeightIncreaseStep:          strconv.Itoa(s.AutoWeightIncreaseStep),
+		models.SettingKeyAutoWeightIncreaseMax:           strconv.Itoa(s.AutoWeightIncreaseMax),
+		models.SettingKeyAutoPriorityDecay:               strconv.FormatBool(s.AutoPriorityDecay),
+		models.SettingKeyAutoPriorityDecayDefault:        strconv.Itoa(s.AutoPriorityDecayDefault),
+		models.SettingKeyAutoPriorityDecayStep:           strconv.Itoa(s.AutoPriorityDecayStep),
+		models.SettingKeyAutoPriorityDecayThreshold:      strconv.Itoa(s.AutoPriorityDecayThreshold),
+		models.SettingKeyAutoPriorityDecayDisableEnabled: strconv.FormatBool(s.AutoPriorityDecayDisableEnabled),
+		models.SettingKeyAutoPriorityIncreaseStep:        strconv.Itoa(s.AutoPriorityIncreaseStep),
+		models.SettingKeyAutoPriorityIncreaseMax:         strconv.Itoa(s.AutoPriorityIncreaseMax),
+		models.SettingKeyHealthCheckCountAsSuccess:       strconv.FormatBool(s.CountHealthCheckAsSuccess),
+		models.SettingKeyHealthCheckCountAsFailure:       strconv.FormatBool(s.CountHealthCheckAsFailure),
+		models.SettingKeyLogRetentionCount:               strconv.Itoa(s.LogRetentionCount),
+		models.SettingKeyPromptCacheRouting:              s.PromptCacheRouting,
+		models.SettingKeyPromptCacheAutoInject:           strconv.FormatBool(s.PromptCacheAutoInject),
+		models.SettingKeyEmptyStreamHandling:             s.EmptyStreamHandling,
+		models.SettingKeyAdaptiveBalancing:               strconv.FormatBool(s.AdaptiveBalancing),
+	}
+}
+
+// generalSettingsFrom 从设置键值解析全局设置，缺失的键使用与设置接口一致的默认值，取值无法解析时返回错误
+func generalSettingsFrom(values map[string]string) (GeneralSettings, error) {
+	s := GeneralSettings{AutoPriorityDecayDisableEnabled: true}
+	bools := map[string]*bool{
+		models.SettingKeyStrictCapabilityMatch:           &s.StrictCapabilityMatch,
+		models.SettingKeyAutoWeightDecay:                 &s.AutoWeightDecay,
+		models.SettingKeyAutoSuccessIncrease:             &s.AutoSuccessIncrease,
+		models.SettingKeyAutoPriorityDecay:               &s.AutoPriorityDecay,
+		models.SettingKeyAutoPriorityDecayDisableEnabled: &s.AutoPriorityDecayDisableEnabled,
+		models.SettingKeyHealthCheckCountAsSuccess:       &s.CountHealthCheckAsSuccess,
+		models.SettingKeyHealthCheckCountAsFailure:       &s.CountHealthCheckAsFailure,
+		models.SettingKeyPromptCacheAutoInject:           &s.PromptCacheAutoInject,
+		models.SettingKeyAdaptiveBalancing:               &s.AdaptiveBalancing,
+	}
+	for key, dst := range bools {
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return s, fmt.Errorf("%s must be true or false", key)
+		}
+		*dst = value
+	}
+	ints := map[string]*int{
+		models.SettingKeyAutoWeightDecayDefault:     &s.AutoWeightDecayDefault,
+		models.SettingKeyAutoWeightDecayStep:        &s.AutoWeightDecayStep,
+		models.SettingKeyAutoWeightIncreaseStep:     &s.AutoWeightIncreaseStep,
+		models.SettingKeyAutoWeightIncreaseMax:      &s.AutoWeightIncreaseMax,
+		models.SettingKeyAutoPriorityDecayDefault:   &s.AutoPriorityDecayDefault,
+		models.SettingKeyAutoPriorityDecayStep:      &s.AutoPriorityDecayStep,
+		models.SettingKeyAutoPriorityDecayThreshold: &s.AutoPriorityDecayThreshold,
+		models.SettingKeyAutoPriorityIncreaseStep:   &s.AutoPriorityIncreaseStep,
+		models.SettingKeyAutoPriorityIncreaseMax:    &s.AutoPriorityIncreaseMax,
+		models.SettingKeyLogRetentionCount:          &s.LogRetentionCount,
+	}
+	for key, dst := range ints {
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return s, fmt.Errorf("%s must be an integer", key)
+		}
+		*dst = value
+	}
+	s.PromptCacheRouting = values[models.SettingKeyPromptCacheRouting]
+	s.EmptyStreamHandling = values[models.SettingKeyEmptyStreamHandling]
+	return s, nil
+}
+
+// SettingsIssue 设置校验发现的问题
+type SettingsIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SettingsValidation 设置校验结果，存在 errors 时拒绝写入，warnings 仅作提示
+type SettingsValidation struct {
+	Valid    bool            `json:"valid"`
+	Errors   []SettingsIssue `json:"errors"`
+	Warnings []SettingsIssue `json:"warnings"`
+}
+
+func (v *SettingsValidation) error(field, format string, args ...any) {
+	v.Errors = append(v.Errors, SettingsIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *SettingsValidation) warn(field, format string, args ...any) {
+	v.Warnings = append(v.Warnings, SettingsIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// NormalizeSettings 为未填写的自增步长、上限、提示缓存路由与空流式响应处理方式补默认值，与历史行为保持一致
+func NormalizeSettings(s *GeneralSettings) {
+	if s.AutoSuccessIncrease {
+		if s.AutoWeightIncreaseStep < 1 {
+			s.AutoWeightIncreaseStep = 1
+		}
+		if s.AutoWeightIncreaseMax < 1 {
+			s.AutoWeightIncreaseMax = 100
+		}
+	}
+	if s.AutoPriorityIncreaseStep < 1 {
+		s.AutoPriorityIncreaseStep = 1
+	}
+	if s.AutoPriorityIncreaseMax < 0 {
+		s.AutoPriorityIncreaseMax = 100
+	}
+	// 旧版客户端不发送该字段时保持关闭
+	if s.PromptCacheRouting == "" {
+		s.PromptCacheRouting = PromptCacheRoutingOff
+	}
+	// 旧版客户端不发送该字段时使用默认的 failover
+	if s.EmptyStreamHandling == "" {
+		s.EmptyStreamHandling = EmptyStreamFailover
+	}
+}
+
+// ValidateSettings 检查设置取值与相互之间的组合是否合理
+func ValidateSettings(s GeneralSettings) SettingsValidation {
+	v := SettingsValidation{Errors: []SettingsIssue{}, Warnings: []SettingsIssue{}}
+
+	if s.AutoWeightDecayDefault < 1 {
+		v.error("auto_weight_decay_default", "auto_weight_decay_default must be at least 1")
+	}
+	if s.AutoPriorityDecayDefault < 0 {
+		v.error("auto_priority_decay_default", "auto_priority_decay_default must not be negative")
+	}
+	if s.AutoPriorityDecayThreshold < 0 {
+		v.error("auto_priority_decay_threshold", "auto_priority_decay_threshold must not be negative")
+	}
+	if s.LogRetentionCount < 0 {
+		v.error("log_retention_count", "log_retention_count must not be negative")
+	}
+	if !ValidPromptCacheRouting(s.PromptCacheRouting) {
+		v.error("prompt_cache_routing", "prompt_cache_routing must be one of off, strip, prefer")
+	}
+	if !ValidEmptyStreamHandling(s.EmptyStreamHandling) {
+		v.error("empty_stream_handling", "empty_stream_handling must be one of off, error, failover")
+	}
+
+	if s.AutoWeightDecay {
+		if s.AutoWeightDecayStep < 1 {
+			v.error("auto_weight_decay_step", "auto_weight_decay_step must be at least 1 when weight decay is enabled")
+		} else if s.AutoWeightDecayStep >= s.AutoWeightDecayDefault {
+			v.warn("auto_weight_decay_step", "a single failure drops the weight from %d to the minimum", s.AutoWeightDecayDefault)
+		}
+	}
+	if s.AutoSuccessIncrease {
+		if s.AutoWeightIncreaseMax < s.AutoWeightDecayDefault {
+			v.warn("auto_weight_increase_max", "auto_weight_increase_max (%d) is below auto_weight_decay_default (%d), successes can never restore the default weight", s.AutoWeightIncreaseMax, s.AutoWeightDecayDefault)
+		}
+		if s.AutoPriorityIncreaseMax < s.AutoPriorityDecayDefault {
+			v.warn("auto_priority_increase_max", "auto_priority_increase_max (%d) is below auto_priority_decay_default (%d), successes can never restore the default priority", s.AutoPriorityIncreaseMax, s.AutoPriorityDecayDefault)
+		}
+	}
+
+	if s.AutoPriorityDecay {
+		if s.AutoPriorityDecayStep < 1 {
+			v.error("auto_priority_decay_step", "auto_priority_decay_step must be at least 1 when priority decay is enabled")
+		}
+		if s.AutoPriorityDecayDisableEnabled {
+			if s.AutoPriorityDecayThreshold >= s.AutoPriorityDecayDefault {
+				v.error("auto_priority_decay_threshold", "auto_priority_decay_threshold (%d) must be below auto_priority_decay_default (%d), otherwise every association is disabled on its first failure", s.AutoPriorityDecayThreshold, s.AutoPriorityDecayDefault)
+			} else if s.AutoPriorityDecayStep >= s.AutoPriorityDecayDefault-s.AutoPriorityDecayThreshold {
+				v.warn("auto_priority_decay_step", "a single failure drops the default priority to the auto-disable threshold")
+			}
+		}
+	}
+
+	if s.CountHealthCheckAsFailure && !s.AutoWeightDecay && !s.AutoPriorityDecay {
+		v.warn("count_health_check_as_failure", "health check failures are counted but both weight and priority decay are disabled")
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// settingMinimums 整数设置项的最小值，与各设置接口的校验一致
+var settingMinimums = map[string]int{
+	models.SettingKeyHealthCheckMaxQPS:              0,
+	models.SettingKeyHealthCheckProviderSpacing:     0,
+	models.SettingKeyHealthCheckJitter:              0,
+	models.SettingKeyHealthCheckConcurrency:         0,
+	models.SettingKeyHealthCheckProviderConcurrency: 0,
+	models.SettingKeyHealthCheckBatchTimeout:        0,
+	models.SettingKeyHealthCheckScheduleJitter:      0,
+	models.SettingKeyHealthCheckLogRetentionCount:   0,
+	models.SettingKeyHealthCheckQuarantineWeight:    1,
+	models.SettingKeyWeightAdvisorInterval:          1,
+	models.SettingKeyStatusPagePollInterval:         0,
+	models.SettingKeyRateLimitSnapshotInterval:      0,
+	models.SettingKeyRequestMaxConcurrent:           0,
+	models.SettingKeyRequestBatchMaxConcurrent:      0,
+	models.SettingKeyRequestQueueTimeout:            0,
+	models.SettingKeyFilesMaxUploadSize:             1,
+	models.SettingKeyRequestQuarantineThreshold:     0,
+	models.SettingKeyRequestQuarantineCooldown:      1,
+	models.SettingKeyNotifyErrorRateMinRequests:     0,
+	models.SettingKeySMTPPort:                       0,
+	models.SettingKeySMTPCooldown:                   0,
+	models.SettingKeySMTPMaxPerHour:                 0,
+}
+
+func init() {
+	// 环境变量中的设置写入前执行相同的校验
+	models.SettingValidator = NormalizeSettingValues
+}
+
+// NormalizeSettingValues 对完整的设置键值执行与各设置接口一致的规范化与校验，用于导入配置等整体写入设置的场景；
+// values 原地更新为规范化后的值，任一设置不合法时返回错误
+func NormalizeSettingValues(values map[string]string) error {
+	general, err := generalSettingsFrom(values)
+	if err != nil {
+		return err
+	}
+	NormalizeSettings(&general)
+	if validation := ValidateSettings(general); !validation.Valid {
+		return fmt.Errorf("%s", validation.Errors[0].Message)
+	}
+	for key, value := range general.Values() {
+		if _, ok := values[key]; ok {
+			values[key] = value
+		}
+	}
+
+	for key, minimum := range settingMinimums {
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+		if value < minimum {
+			return fmt.Errorf("%s must be at least %d", key, minimum)
+		}
+	}
+	// 健康检测接口对未填写的间隔与阈值使用默认值
+	for key, fallback := range map[string]string{
+		models.SettingKeyHealthCheckInterval:         "60",
+		models.SettingKeyHealthCheckFailureThreshold: "3",
+	} {
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+		if value < 1 {
+			values[key] = fallback
+		}
+	}
+	if jitter, _ := strconv.Atoi(values[models.SettingKeyHealthCheckScheduleJitter]); jitter > 100 {
+		return fmt.Errorf("%s must not exceed 100", models.SettingKeyHealthCheckScheduleJitter)
+	}
+	quarantineWeight, _ := strconv.Atoi(values[models.SettingKeyHealthCheckQuarantineWeight])
+	if err := ValidateHealthCheckFailurePolicy(HealthCheckFailurePolicy{
+		FailureAction:    values[models.SettingKeyHealthCheckFailureAction],
+		QuarantineWeight: quarantineWeight,
+	}); err != nil {
+		return err
+	}
+
+	if threshold, err := strconv.ParseFloat(values[models.SettingKeySLOBurnRateThreshold], 64); err != nil || threshold <= 0 {
+		return fmt.Errorf("%s must be greater than 0", models.SettingKeySLOBurnRateThreshold)
+	}
+	if threshold, err := strconv.ParseFloat(values[models.SettingKeyNotifyErrorRateThreshold], 64); err != nil || threshold < 0 || threshold > 100 {
+		return fmt.Errorf("%s must be between 0 and 100", models.SettingKeyNotifyErrorRateThreshold)
+	}
+	switch values[models.SettingKeyAPIErrorLocale] {
+	case common.LocaleAuto, common.LocaleEN, common.LocaleZH:
+	default:
+		return fmt.Errorf("%s must be one of auto, en, zh", models.SettingKeyAPIErrorLocale)
+	}
+	var rules []models.RedactionRule
+	if err := json.Unmarshal([]byte(values[models.SettingKeyLogRedactionRules]), &rules); err != nil || rules == nil {
+		return fmt.Errorf("%s must be a JSON array", models.SettingKeyLogRedactionRules)
+	}
+	if err := ValidateRedactionRules(rules); err != nil {
+		return fmt.Errorf("%s: %w", models.SettingKeyLogRedactionRules, err)
+	}
+	if err := ValidateEmailSettings(emailSettingsFrom(values)); err != nil {
+		return fmt.Errorf("email settings: %w", err)
+	}
+	return nil
+}
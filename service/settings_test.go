@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestNormalizeSettingValues(t *testing.T) {
+	testutil.SetupDB(t)
+	settings, err := gorm.G[models.Setting](models.DB).Find(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		defaults[setting.Key] = setting.Value
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    string // 规范化后的值
+		wantErr bool
+	}{
+		{name: "defaults are valid", key: models.SettingKeyLogRetentionCount, value: defaults[models.SettingKeyLogRetentionCount], want: defaults[models.SettingKeyLogRetentionCount]},
+		{name: "boolean", key: models.SettingKeyAdaptiveBalancing, value: "true", want: "true"},
+		{name: "invalid boolean", key: models.SettingKeyAdaptiveBalancing, value: "yes please", wantErr: true},
+		{name: "invalid integer", key: models.SettingKeyRequestMaxConcurrent, value: "many", wantErr: true},
+		{name: "below minimum", key: models.SettingKeyRequestQueueTimeout, value: "-1", wantErr: true},
+		{name: "interval falls back to default", key: models.SettingKeyHealthCheckInterval, value: "0", want: "60"},
+		{name: "invalid locale", key: models.SettingKeyAPIErrorLocale, value: "fr", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := make(map[string]string, len(defaults))
+			for key, value := range defaults {
+				values[key] = value
+			}
+			values[tt.key] = tt.value
+			err := NormalizeSettingValues(values)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected %s=%s to fail", tt.key, tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeSettingValues failed: %v", err)
+			}
+			if values[tt.key] != tt.want {
+				t.Errorf("%s = %s, want %s", tt.key, values[tt.key], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// shadowLogRetention 影子流量日志保留条数，超出后删除最旧的记录
+const shadowLogRetention = 10000
+
+// shadowProcessers 按客户端格式统计影子请求的首字时延、TPS 与用量
+var shadowProcessers = map[string]Processer{
+	consts.StyleOpenAI:           ProcesserOpenAI,
+	consts.StyleOpenAIRes:        ProcesserOpenAiRes,
+	consts.StyleAnthropic:        ProcesserAnthropic,
+	consts.StyleOpenAIEmbeddings: ProcesserEmbeddings,
+}
+
+// MirrorShadow 按模型配置的比例将请求异步镜像到影子关联，不影响主请求；指定供应商的调试请求不镜像
+func MirrorShadow(style string, before Before, meta ProvidersWithMeta, header http.Header) {
+	if meta.ShadowTarget == 0 || meta.ShadowPercent <= 0 || before.pinnedProvider != "" {
+		return
+	}
+	if rand.IntN(100) >= meta.ShadowPercent {
+		return
+	}
+	timeout := time.Duration(meta.TimeOut) * time.Second
+	go recordShadow(context.Background(), style, before, meta.ShadowTarget, header.Clone(), timeout, meta.Timeouts)
+}
+
+// recordShadow 发送影子请求并写入 ShadowLog
+func recordShadow(ctx context.Context, style string, before Before, modelProviderID uint, header http.Header, timeout time.Duration, timeouts Timeouts) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	log := models.ShadowLog{
+		ModelName:       before.Model,
+		ModelProviderID: modelProviderID,
+		Style:           style,
+		Stream:          before.Stream,
+		Status:          "success",
+	}
+	start := time.Now()
+	if err := sendShadow(ctx, style, before, header, timeouts, &log); err != nil {
+		log.Status, log.Error = "error", err.Error()
+	}
+	log.Duration = time.Since(start)
+	if err := gorm.G[models.ShadowLog](models.DB).Create(context.WithoutCancel(ctx), &log); err != nil {
+		slog.Error("save shadow log error", "model", before.Model, "error", err)
+		return
+	}
+	if log.ID > shadowLogRetention {
+		if _, err := gorm.G[models.ShadowLog](models.DB).Where("id <= ?", log.ID-shadowLogRetention).Delete(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("cleanup shadow logs error", "error", err)
+		}
+	}
+}
+
+// sendShadow 按主请求的转换流程构造发往影子关联的请求，读取完整响应后丢弃，结果写入 log
+func sendShadow(ctx context.Context, style string, before Before, source http.Header, timeouts Timeouts, log *models.ShadowLog) error {
+	mp, err := gorm.G[models.ModelWithProvider](models.DB).Where("id = ?", log.ModelProviderID).First(ctx)
+	if err != nil {
+		return fmt.Errorf("load shadow association: %w", err)
+	}
+	provider, err := gorm.G[models.Provider](models.DB).Where("id = ?", mp.ProviderID).First(ctx)
+	if err != nil {
+		return fmt.Errorf("load shadow provider: %w", err)
+	}
+	log.ProviderName, log.ProviderModel = provider.Name, mp.ProviderModel
+
+	chatModel, err := providers.New(provider.Type, provider.Config, provider.Proxy)
+	if err != nil {
+		return err
+	}
+	providerStyle := providers.WireStyle(provider.Type)
+	passthrough := style == providerStyle || style == consts.StyleOpenAIEmbeddings
+	tm := NewTransformerManager(style, providerStyle)
+	body := before.raw
+	if !passthrough {
+		if body, err = tm.ProcessRequest(ctx, before.raw); err != nil {
+			return fmt.Errorf("transform request error: %v", err)
+		}
+	}
+	header := buildHeaders(source, lo.FromPtr(mp.WithHeader), mp.CustomerHeaders, before.Stream)
+	if len(mp.RequestRewrites) > 0 {
+		if body, err = ApplyRequestRewrites(body, header, mp.RequestRewrites); err != nil {
+			return err
+		}
+	}
+	req, err := buildProviderReq(ctx, chatModel, style, header, mp.ProviderModel, body)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	res, err := timeouts.client(chatModel.GetProxy()).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	log.StatusCode = res.StatusCode
+	if res.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("status: %d, body: %s", res.StatusCode, data)
+	}
+	if before.Stream {
+		res.Body = timeouts.guardStream(res.Body, start)
+	}
+	if provider.Type == consts.StyleOllama && style != consts.StyleOpenAIEmbeddings {
+		if res, err = normalizeOllamaResponse(res); err != nil {
+			return fmt.Errorf("normalize ollama response error: %v", err)
+		}
+		defer res.Body.Close()
+	}
+	if !passthrough {
+		if res, err = tm.ProcessResponse(res); err != nil {
+			return fmt.Errorf("transform response error: %v", err)
+		}
+		defer res.Body.Close()
+	}
+
+	processer, ok := shadowProcessers[style]
+	if !ok {
+		_, err := io.Copy(io.Discard, res.Body)
+		return err
+	}
+	chatLog, _, err := processer(ctx, res.Body, before.Stream, start)
+	if err != nil {
+		return err
+	}
+	log.FirstChunkTime, log.Tps, log.Usage = chatLog.FirstChunkTime, chatLog.Tps, chatLog.Usage
+	return nil
+}
+
+// ShadowMetric 一组请求的成功率、首字时延与 TPS
+type ShadowMetric struct {
+	Requests        int64   `json:"requests"`
+	SuccessRate     float64 `json:"success_rate"`       // 百分比
+	AvgFirstChunkMs float64 `json:"avg_first_chunk_ms"` // 成功请求的平均首字时延
+	P95FirstChunkMs float64 `json:"p95_first_chunk_ms"`
+	AvgTps          float64 `json:"avg_tps"`
+}
+
+// ShadowStats 影子关联与主请求在同一窗口内的指标对比
+type ShadowStats struct {
+	Since   time.Time    `json:"since"`
+	Shadow  ShadowMetric `json:"shadow"`
+	Primary ShadowMetric `json:"primary"`
+}
+
+type shadowSample struct {
+	Status         string
+	FirstChunkTime time.Duration
+	Tps            float64
+}
+
+// GetShadowStats 统计 since 之后模型的影子请求与主请求（不含影子关联自身）的指标
+func GetShadowStats(ctx context.Context, model string, modelProviderID uint, since time.Time) (*ShadowStats, error) {
+	stats := &ShadowStats{Since: since}
+	var shadow, primary []shadowSample
+	if err := models.DB.WithContext(ctx).Model(&models.ShadowLog{}).
+		Select("status, first_chunk_time, tps").
+		Where("model_name = ? AND model_provider_id = ? AND created_at >= ?", model, modelProviderID, since).
+		Scan(&shadow).Error; err != nil {
+		return nil, err
+	}
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("status, first_chunk_time, tps").
+		Where("name = ? AND model_with_provider_id != ? AND status IN ? AND created_at >= ?", model, modelProviderID, []string{"success", "error"}, since).
+		Scan(&primary).Error; err != nil {
+		return nil, err
+	}
+	stats.Shadow, stats.Primary = shadowMetricOf(shadow), shadowMetricOf(primary)
+	return stats, nil
+}
+
+func shadowMetricOf(samples []shadowSample) ShadowMetric {
+	metric := ShadowMetric{Requests: int64(len(samples))}
+	if len(samples) == 0 {
+		return metric
+	}
+	var latencies []time.Duration
+	var total time.Duration
+	var tps float64
+	succeeded := 0
+	for _, sample := range samples {
+		if sample.Status != "success" {
+			continue
+		}
+		succeeded++
+		tps += sample.Tps
+		if sample.FirstChunkTime > 0 {
+			latencies = append(latencies, sample.FirstChunkTime)
+			total += sample.FirstChunkTime
+		}
+	}
+	metric.SuccessRate = float64(succeeded) / float64(len(samples)) * 100
+	if succeeded > 0 {
+		metric.AvgTps = tps / float64(succeeded)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		metric.AvgFirstChunkMs = float64(total) / float64(len(latencies)) / float64(time.Millisecond)
+		metric.P95FirstChunkMs = percentileMs(latencies, 0.95)
+	}
+	return metric
+}
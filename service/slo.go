@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSLOWindowHours       = 24
+	defaultSLOBurnRateThreshold = 14.4
+	sloEvaluateInterval         = time.Minute
+	// 多窗口燃烧率告警：长窗口确认趋势，短窗口确认问题仍在持续
+	sloLongBurnWindow  = time.Hour
+	sloShortBurnWindow = 5 * time.Minute
+)
+
+// SLOStatus 单个模型的 SLO 达标情况
+type SLOStatus struct {
+	Model                string  `json:"model"`
+	FirstTokenMs         int     `json:"first_token_ms"`
+	Target               float64 `json:"target"`
+	WindowHours          int     `json:"window_hours"`
+	Total                int64   `json:"total"`
+	Good                 int64   `json:"good"`
+	Compliance           float64 `json:"compliance"`             // 窗口内达标率（百分比）
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining"` // 剩余错误预算（百分比，可为负）
+	BurnRateLong         float64 `json:"burn_rate_long"`         // 1 小时燃烧率
+	BurnRateShort        float64 `json:"burn_rate_short"`        // 5 分钟燃烧率
+	BurnRateThreshold    float64 `json:"burn_rate_threshold"`
+	Alerting             bool    `json:"alerting"`
+}
+
+// SLOAlert 推送给告警 webhook 的事件
+type SLOAlert struct {
+	Status string    `json:"status"` // firing or resolved
+	Time   time.Time `json:"time"`
+	SLOStatus
+}
+
+// SLOMonitor 定期评估各模型 SLO，燃烧率超阈值时发送告警
+type SLOMonitor struct {
+	mu         sync.Mutex
+	alerting   map[string]bool
+	httpClient *http.Client
+}
+
+var (
+	sloMonitor     *SLOMonitor
+	sloMonitorOnce sync.Once
+)
+
+// GetSLOMonitor 获取 SLO 监控单例
+func GetSLOMonitor() *SLOMonitor {
+	sloMonitorOnce.Do(func() {
+		sloMonitor = &SLOMonitor{
+			alerting:   make(map[string]bool),
+			httpClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	})
+	return sloMonitor
+}
+
+// Start 启动 SLO 评估循环，ctx 取消时退出
+func (m *SLOMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(sloEvaluateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.evaluate(ctx)
+		}
+	}
+}
+
+// evaluate 评估所有配置了 SLO 的模型，仅在告警状态变化时推送
+func (m *SLOMonitor) evaluate(ctx context.Context) {
+	statuses, err := GetSLOStatuses(ctx)
+	if err != nil {
+		slog.Error("failed to evaluate slo", "error", err)
+		return
+	}
+	webhook := getSLOAlertWebhook(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, status := range statuses {
+		if m.alerting[status.Model] == status.Alerting {
+			continue
+		}
+		m.alerting[status.Model] = status.Alerting
+
+		alert := SLOAlert{Status: "resolved", Time: time.Now(), SLOStatus: status}
+		if status.Alerting {
+			alert.Status = "firing"
+		}
+		slog.Warn("slo burn rate alert", "model", status.Model, "status", alert.Status,
+			"burn_rate_long", status.BurnRateLong, "burn_rate_short", status.BurnRateShort)
+		if webhook != "" {
+			go func() {
+				if err := m.sendAlert(webhook, alert); err != nil {
+					slog.Error("failed to send slo alert", "model", alert.Model, "error", err)
+				}
+			}()
+		}
+	}
+}
+
+func (m *SLOMonitor) sendAlert(webhook string, alert SLOAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	res, err := m.httpClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetSLOStatuses 计算所有配置了 SLO 的模型的当前状态
+func GetSLOStatuses(ctx context.Context) ([]SLOStatus, error) {
+	llmModels, err := gorm.G[models.Model](models.DB).
+		Where("slo_first_token_ms > 0 AND slo_target > 0").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	threshold := getSLOBurnRateThreshold(ctx)
+	now := time.Now()
+
+	statuses := make([]SLOStatus, 0, len(llmModels))
+	for _, model := range llmModels {
+		status, err := computeSLOStatus(ctx, model, threshold, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, nil
+}
+
+func computeSLOStatus(ctx context.Context, model models.Model, threshold float64, now time.Time) (*SLOStatus, error) {
+	windowHours := model.SLOWindowHours
+	if windowHours <= 0 {
+		windowHours = defaultSLOWindowHours
+	}
+	limit := time.Duration(model.SLOFirstTokenMs) * time.Millisecond
+	budget := 1 - model.SLOTarget/100
+
+	total, good, err := countSLOEvents(ctx, model.Name, limit, now.Add(-time.Duration(windowHours)*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	longTotal, longGood, err := countSLOEvents(ctx, model.Name, limit, now.Add(-sloLongBurnWindow))
+	if err != nil {
+		return nil, err
+	}
+	shortTotal, shortGood, err := countSLOEvents(ctx, model.Name, limit, now.Add(-sloShortBurnWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &SLOStatus{
+		Model:                model.Name,
+		FirstTokenMs:         model.SLOFirstTokenMs,
+		Target:               model.SLOTarget,
+		WindowHours:          windowHours,
+		Total:                total,
+		Good:                 good,
+		Compliance:           100,
+		ErrorBudgetRemaining: 100,
+		BurnRateLong:         burnRate(longTotal, longGood, budget),
+		BurnRateShort:        burnRate(shortTotal, shortGood, budget),
+		BurnRateThreshold:    threshold,
+	}
+	if total > 0 {
+		status.Compliance = float64(good) / float64(total) * 100
+		status.ErrorBudgetRemaining = (1 - burnRate(total, good, budget)) * 100
+	}
+	status.Alerting = status.BurnRateLong >= threshold && status.BurnRateShort >= threshold
+	return status, nil
+}
+
+// countSLOEvents 统计窗口内请求总数与达标数（成功且首字时延不超过阈值）
+func countSLOEvents(ctx context.Context, model string, limit time.Duration, since time.Time) (int64, int64, error) {
+	// 客户端主动取消的请求不计入 SLO
+	// 采样记录按 sample_weight 加权
+	var row struct {
+		Total int64
+		Good  int64
+	}
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Select("COALESCE(SUM(sample_weight), 0) AS total, "+
+			"COALESCE(SUM(CASE WHEN status = 'success' AND first_chunk_time <= ? THEN sample_weight ELSE 0 END), 0) AS good", int64(limit)).
+		Where("name = ? AND created_at >= ?", model, since).
+		Where("status <> ?", "cancelled").
+		Scan(&row).Error; err != nil {
+		return 0, 0, err
+	}
+	return row.Total, row.Good, nil
+}
+
+// burnRate 错误预算燃烧率：实际失败率 / 允许失败率
+func burnRate(total, good int64, budget float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	badRatio := float64(total-good) / float64(total)
+	// 目标为 100% 时没有错误预算，按失败率放大处理，任意失败都会快速触发告警
+	if budget <= 0 {
+		if badRatio > 0 {
+			return badRatio * 100
+		}
+		return 0
+	}
+	return badRatio / budget
+}
+
+// getSLOAlertWebhook 获取 SLO 告警 webhook
+func getSLOAlertWebhook(ctx context.Context) string {
+	setting, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeySLOAlertWebhook)).
+		First(ctx)
+	if err != nil {
+		return ""
+	}
+	return setting.Value
+}
+
+// getSLOBurnRateThreshold 获取燃烧率告警阈值
+func getSLOBurnRateThreshold(ctx context.Context) float64 {
+	setting, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeySLOBurnRateThreshold)).
+		First(ctx)
+	if err != nil {
+		return defaultSLOBurnRateThreshold
+	}
+	threshold, err := strconv.ParseFloat(setting.Value, 64)
+	if err != nil || threshold <= 0 {
+		return defaultSLOBurnRateThreshold
+	}
+	return threshold
+}
+
+// GetSLOSettings 获取 SLO 告警设置
+func GetSLOSettings(ctx context.Context) (webhook string, burnRateThreshold float64) {
+	return getSLOAlertWebhook(ctx), getSLOBurnRateThreshold(ctx)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestBurnRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		total, good int64
+		budget      float64
+		want        float64
+	}{
+		{name: "no traffic", total: 0, good: 0, budget: 0.01, want: 0},
+		{name: "within budget", total: 1000, good: 995, budget: 0.01, want: 0.5},
+		{name: "burning fast", total: 100, good: 80, budget: 0.01, want: 20},
+		{name: "zero budget without failures", total: 10, good: 10, budget: 0, want: 0},
+		{name: "zero budget with failures", total: 10, good: 9, budget: 0, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := burnRate(tt.total, tt.good, tt.budget); got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("burnRate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSLOMonitor(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	model := testutil.SeedModel(t, "slo-model", func(m *models.Model) {
+		m.SLOFirstTokenMs = 500
+		m.SLOTarget = 99
+	})
+	testutil.SeedModel(t, "no-slo-model")
+
+	now := time.Now()
+	seed := func(status string, firstChunk time.Duration, age time.Duration) models.ChatLog {
+		log := models.ChatLog{Name: model.Name, Status: status, SampleWeight: 1, FirstChunkTime: firstChunk}
+		log.CreatedAt = now.Add(-age)
+		return log
+	}
+	var logs []models.ChatLog
+	for range 5 {
+		logs = append(logs, seed("success", 100*time.Millisecond, time.Minute))
+	}
+	for range 3 {
+		logs = append(logs, seed("success", time.Second, time.Minute))
+	}
+	logs = append(logs,
+		seed("error", 0, time.Minute),
+		seed("cancelled", 0, time.Minute),
+		// 只计入 24 小时窗口，不计入燃烧率窗口
+		seed("success", 100*time.Millisecond, 2*time.Hour),
+		seed("success", 100*time.Millisecond, 48*time.Hour),
+	)
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatalf("seed logs: %v", err)
+	}
+
+	statuses, err := GetSLOStatuses(ctx)
+	if err != nil {
+		t.Fatalf("GetSLOStatuses failed: %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	status := statuses[0]
+	if status.Model != model.Name || status.WindowHours != defaultSLOWindowHours || status.Total != 10 || status.Good != 6 || status.Compliance != 60 {
+		t.Errorf("status = %+v", status)
+	}
+	if status.BurnRateThreshold != defaultSLOBurnRateThreshold || !status.Alerting || status.BurnRateLong < 44 || status.BurnRateShort < 44 || status.ErrorBudgetRemaining > -3899 {
+		t.Errorf("burn rate = %+v", status)
+	}
+
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, `{}`))
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeySLOAlertWebhook)).Update("value", upstream.URL).Error; err != nil {
+		t.Fatal(err)
+	}
+	monitor := &SLOMonitor{alerting: make(map[string]bool), httpClient: http.DefaultClient}
+	monitor.evaluate(ctx)
+	// 告警状态未变化时不重复推送
+	monitor.evaluate(ctx)
+	for range 100 {
+		if len(upstream.Requests()) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	requests := upstream.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected one alert, got %d", len(requests))
+	}
+	alert := gjson.ParseBytes(requests[0].Body)
+	if alert.Get("status").String() != "firing" || alert.Get("model").String() != model.Name || alert.Get("good").Int() != 6 {
+		t.Errorf("alert = %s", requests[0].Body)
+	}
+
+	// 提高阈值后告警恢复
+	if err := models.DB.Model(&models.Setting{}).Where(models.ByKey(models.SettingKeySLOBurnRateThreshold)).Update("value", "100").Error; err != nil {
+		t.Fatal(err)
+	}
+	monitor.evaluate(ctx)
+	for range 100 {
+		if len(upstream.Requests()) > 1 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if requests := upstream.Requests(); len(requests) != 2 || gjson.GetBytes(requests[1].Body, "status").String() != "resolved" {
+		t.Errorf("requests = %d", len(requests))
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultStatusPagePollInterval = 5 // 分钟
+	statusPageCheckInterval       = time.Minute
+	statusPageMaxBody             = 1 << 20
+)
+
+// healthyStatusValues 自定义 JSON 状态字段中视为正常的取值
+var healthyStatusValues = []string{"none", "ok", "operational", "up", "healthy", "green", "true"}
+
+// ProviderStatusPage 供应商状态页的最近一次轮询结果
+type ProviderStatusPage struct {
+	ProviderID   uint      `json:"provider_id"`
+	ProviderName string    `json:"provider_name"`
+	Console      string    `json:"console,omitempty"`
+	StatusPage   string    `json:"status_page"`
+	Indicator    string    `json:"indicator"` // statuspage.io: none / minor / major / critical；自定义 JSON 为原始取值
+	Description  string    `json:"description,omitempty"`
+	Incidents    []string  `json:"incidents"` // 未解决的事件标题
+	Degraded     bool      `json:"degraded"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// StatusPageMonitor 定期轮询供应商公开的状态页，根据已发布的事件标记供应商降级
+type StatusPageMonitor struct {
+	mu         sync.RWMutex
+	statuses   map[uint]ProviderStatusPage
+	lastPoll   time.Time
+	httpClient *http.Client
+}
+
+var (
+	statusPageMonitor     *StatusPageMonitor
+	statusPageMonitorOnce sync.Once
+)
+
+// GetStatusPageMonitor 获取状态页轮询单例
+func GetStatusPageMonitor() *StatusPageMonitor {
+	statusPageMonitorOnce.Do(func() {
+		statusPageMonitor = &StatusPageMonitor{
+			statuses:   make(map[uint]ProviderStatusPage),
+			httpClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	})
+	return statusPageMonitor
+}
+
+// Start 启动轮询循环，按设置的间隔轮询，ctx 取消时退出
+func (m *StatusPageMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(statusPageCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			interval := GetStatusPagePollInterval(ctx)
+			if interval <= 0 || time.Since(m.lastPoll) < time.Duration(interval)*time.Minute {
+				continue
+			}
+			if err := m.Poll(ctx); err != nil {
+				slog.Error("status page poll error", "error", err)
+			}
+		}
+	}
+}
+
+// Poll 立即轮询所有配置了状态页的供应商
+func (m *StatusPageMonitor) Poll(ctx context.Context) error {
+	providerList, err := gorm.G[models.Provider](models.DB).Where("status_page != ''").Find(ctx)
+	if err != nil {
+		return err
+	}
+
+	statuses := make(map[uint]ProviderStatusPage, len(providerList))
+	for _, provider := range providerList {
+		status := m.check(ctx, provider)
+		m.mu.RLock()
+		previous, ok := m.statuses[provider.ID]
+		m.mu.RUnlock()
+		if !ok || previous.Degraded != status.Degraded {
+			slog.Info("provider status page changed", "provider", provider.Name, "degraded", status.Degraded,
+				"indicator", status.Indicator, "incidents", strings.Join(status.Incidents, "; "))
+		}
+		statuses[provider.ID] = status
+	}
+
+	m.mu.Lock()
+	m.statuses = statuses
+	m.lastPoll = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+// check 拉取并解析单个供应商的状态页，请求失败时不标记降级，只记录错误
+func (m *StatusPageMonitor) check(ctx context.Context, provider models.Provider) ProviderStatusPage {
+	status := ProviderStatusPage{
+		ProviderID:   provider.ID,
+		ProviderName: provider.Name,
+		Console:      provider.Console,
+		StatusPage:   provider.StatusPage,
+		Incidents:    []string{},
+		CheckedAt:    time.Now(),
+	}
+
+	endpoint := provider.StatusPage
+	if provider.StatusPagePath == "" && !strings.HasSuffix(endpoint, ".json") {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/api/v2/summary.json"
+	}
+	body, err := m.fetch(ctx, endpoint)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if !gjson.ValidBytes(body) {
+		status.Error = "invalid status page response"
+		return status
+	}
+
+	if provider.StatusPagePath != "" {
+		value := gjson.GetBytes(body, provider.StatusPagePath)
+		if !value.Exists() {
+			status.Error = "status path not found: " + provider.StatusPagePath
+			return status
+		}
+		status.Indicator = value.String()
+		status.Degraded = !slices.Contains(healthyStatusValues, strings.ToLower(status.Indicator))
+		return status
+	}
+
+	// statuspage.io summary.json / status.json
+	status.Indicator = gjson.GetBytes(body, "status.indicator").String()
+	status.Description = gjson.GetBytes(body, "status.description").String()
+	gjson.GetBytes(body, "incidents").ForEach(func(_, incident gjson.Result) bool {
+		if s := incident.Get("status").String(); s != "resolved" && s != "postmortem" {
+			status.Incidents = append(status.Incidents, incident.Get("name").String())
+		}
+		return true
+	})
+	status.Degraded = (status.Indicator != "" && status.Indicator != "none") || len(status.Incidents) > 0
+	return status
+}
+
+func (m *StatusPageMonitor) fetch(ctx context.Context, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, statusPageMaxBody))
+}
+
+// Statuses 返回最近一次轮询结果，按供应商 ID 排序
+func (m *StatusPageMonitor) Statuses() []ProviderStatusPage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make([]ProviderStatusPage, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b ProviderStatusPage) int { return int(a.ProviderID) - int(b.ProviderID) })
+	return statuses
+}
+
+// IsDegraded 供应商状态页是否报告降级
+func (m *StatusPageMonitor) IsDegraded(providerID uint) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.statuses[providerID].Degraded
+}
+
+// GetStatusPagePollInterval 获取状态页轮询间隔（分钟）
+func GetStatusPagePollInterval(ctx context.Context) int {
+	setting, err := gorm.G[models.Setting](models.DB).
+		Where(models.ByKey(models.SettingKeyStatusPagePollInterval)).
+		First(ctx)
+	if err != nil {
+		return defaultStatusPagePollInterval
+	}
+	val, err := strconv.Atoi(setting.Value)
+	if err != nil || val < 0 {
+		return defaultStatusPagePollInterval
+	}
+	return val
+}
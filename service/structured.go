@@ -0,0 +1,193 @@
+package service
+
+import "github.com/atopos31/llmio/models"
+
+// 统一结构化输出类型
+const (
+	ResponseFormatText       = "text"        // 普通文本，不约束输出
+	ResponseFormatJSONObject = "json_object" // 输出任意 JSON 对象
+	ResponseFormatJSONSchema = "json_schema" // 输出符合指定 JSON Schema 的对象
+)
+
+// structuredOutputTool 转换为 Anthropic 请求时承载结构化输出的工具名，响应中该工具的参数还原为文本内容
+const structuredOutputTool = "llmio_structured_output"
+
+// structuredOutputPrompt 无法强制调用结构化输出工具时追加到 system 的提示
+const structuredOutputPrompt = "When you give your final answer, call the " + structuredOutputTool + " tool with the answer as its input instead of replying with text."
+
+// UnifiedResponseFormat 统一结构化输出格式，对应 OpenAI response_format、Responses text.format 与 Anthropic output_format
+type UnifiedResponseFormat struct {
+	Type        string      `json:"type"`
+	Name        string      `json:"name,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Schema      interface{} `json:"schema,omitempty"`
+	Strict      *bool       `json:"strict,omitempty"`
+}
+
+// parseOpenAIResponseFormat 解析 OpenAI response_format，json_schema 的定义位于 json_schema 字段中
+func parseOpenAIResponseFormat(value interface{}) *UnifiedResponseFormat {
+	formatMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	format := &UnifiedResponseFormat{Type: getString(formatMap, "type")}
+	if schema, ok := formatMap["json_schema"].(map[string]interface{}); ok && format.Type == ResponseFormatJSONSchema {
+		fillResponseFormat(format, schema)
+	}
+	return format
+}
+
+// parseResponsesTextFormat 解析 Responses text.format，json_schema 的定义与 type 位于同一层
+func parseResponsesTextFormat(value interface{}) *UnifiedResponseFormat {
+	text, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	formatMap, ok := text["format"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	format := &UnifiedResponseFormat{Type: getString(formatMap, "type")}
+	if format.Type == ResponseFormatJSONSchema {
+		fillResponseFormat(format, formatMap)
+	}
+	return format
+}
+
+// parseAnthropicOutputFormat 解析 Anthropic output_format，只支持 json_schema
+func parseAnthropicOutputFormat(value interface{}) *UnifiedResponseFormat {
+	formatMap, ok := value.(map[string]interface{})
+	if !ok || getString(formatMap, "type") != ResponseFormatJSONSchema {
+		return nil
+	}
+	return &UnifiedResponseFormat{Type: ResponseFormatJSONSchema, Schema: formatMap["schema"]}
+}
+
+func fillResponseFormat(format *UnifiedResponseFormat, schema map[string]interface{}) {
+	format.Name = getString(schema, "name")
+	format.Description = getString(schema, "description")
+	format.Schema = schema["schema"]
+	if strict, ok := schema["strict"].(bool); ok {
+		format.Strict = &strict
+	}
+}
+
+// structured 是否要求输出 JSON
+func (f *UnifiedResponseFormat) structured() bool {
+	return f != nil && (f.Type == ResponseFormatJSONObject || f.Type == ResponseFormatJSONSchema)
+}
+
+// schemaOrObject 返回 JSON Schema，json_object 或未给出 Schema 时返回任意对象
+func (f *UnifiedResponseFormat) schemaOrObject() interface{} {
+	if f.Type == ResponseFormatJSONSchema && f.Schema != nil {
+		return f.Schema
+	}
+	return map[string]interface{}{"type": "object"}
+}
+
+// schemaFields 返回 json_schema 的名称、描述、Schema 与 strict 字段，名称为空时使用 response
+func (f *UnifiedResponseFormat) schemaFields() map[string]interface{} {
+	name := f.Name
+	if name == "" {
+		name = "response"
+	}
+	fields := map[string]interface{}{"name": name, "schema": f.schemaOrObject()}
+	if f.Description != "" {
+		fields["description"] = f.Description
+	}
+	if f.Strict != nil {
+		fields["strict"] = *f.Strict
+	}
+	return fields
+}
+
+// formatOpenAIResponseFormat 生成 OpenAI response_format
+func formatOpenAIResponseFormat(format *UnifiedResponseFormat) map[string]interface{} {
+	if format.Type != ResponseFormatJSONSchema {
+		return map[string]interface{}{"type": format.Type}
+	}
+	return map[string]interface{}{"type": ResponseFormatJSONSchema, "json_schema": format.schemaFields()}
+}
+
+// formatResponsesTextFormat 生成 Responses text 参数
+func formatResponsesTextFormat(format *UnifiedResponseFormat) map[string]interface{} {
+	formatMap := map[string]interface{}{"type": format.Type}
+	if format.Type == ResponseFormatJSONSchema {
+		fields := format.schemaFields()
+		fields["type"] = ResponseFormatJSONSchema
+		formatMap = fields
+	}
+	return map[string]interface{}{"format": formatMap}
+}
+
+// anthropicStructuredOutput 以工具调用实现 Anthropic 的结构化输出：添加以 Schema 为参数的工具，
+// 没有其他工具且未开启思考时强制调用该工具，否则只在 system 中提示模型调用，避免影响原有工具与思考
+func anthropicStructuredOutput(req map[string]interface{}, format *UnifiedResponseFormat) {
+	description := format.Description
+	if description == "" {
+		description = "Respond with a JSON object that follows the input schema."
+	}
+	tool := map[string]interface{}{
+		"name":         structuredOutputTool,
+		"description":  description,
+		"input_schema": format.schemaOrObject(),
+	}
+	tools, _ := req["tools"].([]interface{})
+	thinking, _ := req["thinking"].(map[string]interface{})
+	if len(tools) == 0 && getString(thinking, "type") != "enabled" {
+		req["tool_choice"] = map[string]interface{}{"type": "tool", "name": structuredOutputTool}
+	} else {
+		switch system := req["system"].(type) {
+		case string:
+			if system != "" {
+				req["system"] = system + "\n\n" + structuredOutputPrompt
+			} else {
+				req["system"] = structuredOutputPrompt
+			}
+		case []interface{}:
+			req["system"] = append(system, map[string]interface{}{"type": "text", "text": structuredOutputPrompt})
+		default:
+			req["system"] = structuredOutputPrompt
+		}
+	}
+	req["tools"] = append(tools, tool)
+}
+
+// structuredOutputSink 将上游流中的结构化输出工具调用还原为文本增量，其余工具调用重新从 0 编号
+type structuredOutputSink struct {
+	streamSink
+	structured map[int]bool
+	tools      map[int]int // 上游工具调用序号 -> 客户端工具调用序号
+}
+
+func newStructuredOutputSink(sink streamSink) *structuredOutputSink {
+	return &structuredOutputSink{streamSink: sink, structured: map[int]bool{}, tools: map[int]int{}}
+}
+
+func (s *structuredOutputSink) toolCall(index int, id, name string) {
+	if name == structuredOutputTool {
+		s.structured[index] = true
+		return
+	}
+	s.tools[index] = len(s.tools)
+	s.streamSink.toolCall(s.tools[index], id, name)
+}
+
+func (s *structuredOutputSink) toolArgs(index int, delta string) {
+	if s.structured[index] {
+		if delta != "" {
+			s.streamSink.text(delta)
+		}
+		return
+	}
+	if clientIndex, ok := s.tools[index]; ok {
+		s.streamSink.toolArgs(clientIndex, delta)
+	}
+}
+
+func (s *structuredOutputSink) finish(finishReason string, usage *models.Usage) {
+	if finishReason == "tool_calls" && len(s.structured) > 0 && len(s.tools) == 0 {
+		finishReason = "stop"
+	}
+	s.streamSink.finish(finishReason, usage)
+}
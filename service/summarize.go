@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gorm.io/gorm"
+)
+
+// defaultSummarizeKeep 模型未配置时压缩保留的最近消息数
+const defaultSummarizeKeep = 4
+
+const summarizePrompt = "You compress chat history. Summarize the conversation below so that an assistant can continue it without the original messages. " +
+	"Keep facts, decisions, names, numbers, code identifiers, open questions and any instructions from the user. Reply with the summary only."
+
+// Summarizer 调用摘要模型：body 为 OpenAI chat 请求体，返回摘要文本
+type Summarizer func(ctx context.Context, body []byte) (string, error)
+
+type summarizingKey struct{}
+
+// WithSummarizing 标记 ctx 为摘要请求，避免摘要请求再次触发压缩
+func WithSummarizing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, summarizingKey{}, true)
+}
+
+// CompressContext 估算输入 token 超过模型配置的阈值时，将较早的消息交给摘要模型压缩并替换为一条摘要，
+// 保留开头的 system 消息与最近的对话；压缩结果记录在 ChatIO 中。摘要失败时保留原始请求
+func CompressContext(ctx context.Context, style string, before *Before, summarize Summarizer) *models.ContextSummary {
+	if ctx.Value(summarizingKey{}) != nil {
+		return nil
+	}
+	if style != consts.StyleOpenAI && style != consts.StyleAnthropic && style != consts.StyleOpenAIRes {
+		return nil
+	}
+	model, err := gorm.G[models.Model](models.DB).Where("name = ?", before.Model).First(ctx)
+	if err != nil || model.SummarizeThreshold <= 0 || model.SummarizeModel == "" {
+		return nil
+	}
+	tokens, err := EstimatePromptTokens(before.Model, before.raw)
+	if err != nil || tokens <= model.SummarizeThreshold {
+		return nil
+	}
+
+	key := "messages"
+	if style == consts.StyleOpenAIRes {
+		key = "input"
+	}
+	messages := gjson.GetBytes(before.raw, key)
+	if !messages.IsArray() {
+		return nil
+	}
+	items := messages.Array()
+	start, end := summarizeRange(style, items, model.SummarizeKeep)
+	if end-start < 1 {
+		return nil
+	}
+
+	replaced := make([]string, 0, end-start)
+	var transcript strings.Builder
+	for _, item := range items[start:end] {
+		replaced = append(replaced, item.Raw)
+		writeTranscript(&transcript, item)
+	}
+	body, err := json.Marshal(map[string]any{
+		"model": model.SummarizeModel,
+		"messages": []map[string]string{
+			{"role": "system", "content": summarizePrompt},
+			{"role": "user", "content": transcript.String()},
+		},
+	})
+	if err != nil {
+		return nil
+	}
+	summary, err := summarize(WithSummarizing(ctx), body)
+	if err == nil && strings.TrimSpace(summary) == "" {
+		err = errors.New("empty summary")
+	}
+	if err != nil {
+		slog.Warn("summarize context error, forwarding original request", "model", before.Model, "summarize_model", model.SummarizeModel, "error", err)
+		return nil
+	}
+
+	raw, err := replaceWithSummary(style, before.raw, key, items, start, end, summary)
+	if err != nil {
+		slog.Warn("replace summarized messages error", "model", before.Model, "error", err)
+		return nil
+	}
+	slog.Info("context summarized", "model", before.Model, "summarize_model", model.SummarizeModel, "messages", end-start, "tokens", tokens)
+	before.raw = raw
+	before.contextTokens = estimateContextTokens(before.Model, raw)
+	before.summary = &models.ContextSummary{
+		Model:    model.SummarizeModel,
+		Messages: end - start,
+		Replaced: "[" + strings.Join(replaced, ",") + "]",
+		Summary:  summary,
+	}
+	return before.summary
+}
+
+// summarizeRange 返回需要摘要的消息区间 [start, end)：跳过开头的 system 消息，
+// 保留至少 keep 条最近消息，并让保留部分从普通用户消息开始，避免拆开工具调用与结果
+func summarizeRange(style string, items []gjson.Result, keep int) (int, int) {
+	if keep <= 0 {
+		keep = defaultSummarizeKeep
+	}
+	start := 0
+	for start < len(items) && isSystemMessage(items[start]) {
+		start++
+	}
+	end := len(items) - keep
+	for end > start && !isPlainUserMessage(style, items[end]) {
+		end--
+	}
+	if end <= start {
+		return 0, 0
+	}
+	return start, end
+}
+
+func isSystemMessage(item gjson.Result) bool {
+	role := item.Get("role").String()
+	return role == "system" || role == "developer"
+}
+
+// isPlainUserMessage 是否为不含工具结果的用户消息
+func isPlainUserMessage(style string, item gjson.Result) bool {
+	if item.Get("role").String() != "user" {
+		return false
+	}
+	if style == consts.StyleAnthropic {
+		return !item.Get(`content.#(type=="tool_result")`).Exists()
+	}
+	return true
+}
+
+// writeTranscript 将一条消息写为 "role: 文本" 形式，非文本内容保留原始 JSON
+func writeTranscript(b *strings.Builder, item gjson.Result) {
+	role := item.Get("role").String()
+	if role == "" {
+		role = item.Get("type").String()
+	}
+	b.WriteString(role)
+	b.WriteString(": ")
+	content := item.Get("content")
+	switch {
+	case content.Type == gjson.String:
+		b.WriteString(content.String())
+	case content.IsArray():
+		for _, part := range content.Array() {
+			if text := part.Get("text"); text.Exists() {
+				b.WriteString(text.String())
+			} else {
+				b.WriteString(part.Raw)
+			}
+			b.WriteString(" ")
+		}
+	case !content.Exists():
+		b.WriteString(item.Raw)
+	}
+	if calls := item.Get("tool_calls"); calls.Exists() {
+		b.WriteString(" ")
+		b.WriteString(calls.Raw)
+	}
+	b.WriteString("\n\n")
+}
+
+// replaceWithSummary 用摘要替换 [start, end) 的消息；Anthropic 的摘要追加到 system，其余格式插入一条 system 消息
+func replaceWithSummary(style string, raw []byte, key string, items []gjson.Result, start, end int, summary string) ([]byte, error) {
+	text := "Summary of the earlier conversation:\n" + summary
+	kept := make([]string, 0, len(items)-(end-start)+1)
+	for _, item := range items[:start] {
+		kept = append(kept, item.Raw)
+	}
+	if style == consts.StyleAnthropic {
+		var err error
+		if raw, err = appendAnthropicSystem(raw, text); err != nil {
+			return nil, err
+		}
+	} else {
+		message, err := json.Marshal(map[string]string{"role": "system", "content": text})
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, string(message))
+	}
+	for _, item := range items[end:] {
+		kept = append(kept, item.Raw)
+	}
+	return sjson.SetRawBytes(raw, key, []byte("["+strings.Join(kept, ",")+"]"))
+}
+
+// appendAnthropicSystem 将文本追加到 Anthropic 请求的 system，兼容字符串与内容块数组
+func appendAnthropicSystem(raw []byte, text string) ([]byte, error) {
+	system := gjson.GetBytes(raw, "system")
+	switch {
+	case !system.Exists():
+		return sjson.SetBytes(raw, "system", text)
+	case system.Type == gjson.String:
+		return sjson.SetBytes(raw, "system", system.String()+"\n\n"+text)
+	case system.IsArray():
+		return sjson.SetBytes(raw, "system.-1", map[string]string{"type": "text", "text": text})
+	}
+	return nil, fmt.Errorf("unsupported system type: %s", system.Type)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/tidwall/gjson"
+)
+
+func TestSummarizeRangeKeepsToolResults(t *testing.T) {
+	raw := []byte(`{"system":"sys","messages":[
+		{"role":"user","content":"q1"},
+		{"role":"assistant","content":"a1"},
+		{"role":"user","content":"q2"},
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"f","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"r"}]},
+		{"role":"assistant","content":"a2"}]}`)
+	items := gjson.GetBytes(raw, "messages").Array()
+
+	// 保留 2 条时截断点落在工具结果上，需回退到 q2 之前
+	start, end := summarizeRange(consts.StyleAnthropic, items, 2)
+	if start != 0 || end != 2 {
+		t.Fatalf("range = [%d, %d), want [0, 2)", start, end)
+	}
+	out, err := replaceWithSummary(consts.StyleAnthropic, raw, "messages", items, start, end, "S")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gjson.GetBytes(out, "messages.#").Int(); got != 4 {
+		t.Fatalf("messages = %d, want 4", got)
+	}
+	if got := gjson.GetBytes(out, "system").String(); got != "sys\n\nSummary of the earlier conversation:\nS" {
+		t.Fatalf("system = %q", got)
+	}
+
+	// 没有可压缩的较早消息
+	if start, end := summarizeRange(consts.StyleAnthropic, items[:2], 4); end-start != 0 {
+		t.Fatalf("short range = [%d, %d), want empty", start, end)
+	}
+}
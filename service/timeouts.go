@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/providers"
+)
+
+// 模型未配置时使用的默认超时
+const (
+	defaultConnectTimeout    = 10 * time.Second
+	defaultStreamIdleTimeout = 300 * time.Second
+)
+
+// 流式响应超时中断时返回的错误
+var (
+	ErrFirstTokenTimeout = errors.New("upstream first token timeout")
+	ErrStreamIdleTimeout = errors.New("upstream stream idle timeout")
+)
+
+// Timeouts 单次上游请求的超时，TimeOut 仍作为整个重试过程的时长上限
+type Timeouts struct {
+	Connect    time.Duration // 建立 TCP 连接与 TLS 握手各自的时长上限
+	FirstToken time.Duration // 自发送请求起等待响应头与流式首个数据块的时长上限，0 表示不限制
+	StreamIdle time.Duration // 流式响应两个数据块之间的最长间隔，超出后中断流
+}
+
+// ModelTimeouts 读取模型配置的超时，未配置时连接超时为 10 秒、首字超时沿用 TimeOut、流空闲超时为 300 秒
+func ModelTimeouts(model models.Model) Timeouts {
+	timeouts := Timeouts{
+		Connect:    time.Duration(model.ConnectTimeout) * time.Second,
+		FirstToken: time.Duration(model.FirstTokenTimeout) * time.Second,
+		StreamIdle: time.Duration(model.StreamIdleTimeout) * time.Second,
+	}
+	if timeouts.Connect <= 0 {
+		timeouts.Connect = defaultConnectTimeout
+	}
+	if timeouts.FirstToken <= 0 {
+		timeouts.FirstToken = time.Duration(model.TimeOut) * time.Second
+	}
+	if timeouts.StreamIdle <= 0 {
+		timeouts.StreamIdle = defaultStreamIdleTimeout
+	}
+	return timeouts
+}
+
+// client 按连接超时与首字超时创建发往上游的 client
+func (t Timeouts) client(proxy string) *http.Client {
+	return providers.GetClientWithTimeouts(t.Connect, t.FirstToken, proxy)
+}
+
+// guardStream 为流式响应体加上首字超时与空闲超时，首字超时自 start 起计算；超时后关闭响应体，读取返回对应错误
+func (t Timeouts) guardStream(body io.ReadCloser, start time.Time) io.ReadCloser {
+	if t.FirstToken <= 0 && t.StreamIdle <= 0 {
+		return body
+	}
+	g := &streamGuard{body: body, idle: t.StreamIdle}
+	first := t.FirstToken
+	if first <= 0 {
+		first = t.StreamIdle
+	} else {
+		first -= time.Since(start)
+	}
+	g.timer = time.AfterFunc(max(first, 0), func() {
+		if g.started.Load() {
+			g.abort(ErrStreamIdleTimeout)
+		} else {
+			g.abort(ErrFirstTokenTimeout)
+		}
+	})
+	return g
+}
+
+// streamGuard 每次读到数据后重置计时器，计时器触发时关闭上游响应体以中断阻塞的读取
+type streamGuard struct {
+	body    io.ReadCloser
+	idle    time.Duration
+	timer   *time.Timer
+	started atomic.Bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func (g *streamGuard) Read(p []byte) (int, error) {
+	n, err := g.body.Read(p)
+	if timeoutErr := g.timeoutErr(); timeoutErr != nil {
+		return n, timeoutErr
+	}
+	if n > 0 {
+		g.started.Store(true)
+		if g.idle > 0 {
+			g.timer.Reset(g.idle)
+		} else {
+			g.timer.Stop()
+		}
+	}
+	if err != nil {
+		g.timer.Stop()
+	}
+	return n, err
+}
+
+func (g *streamGuard) Close() error {
+	g.timer.Stop()
+	return g.body.Close()
+}
+
+func (g *streamGuard) abort(err error) {
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = err
+	}
+	g.mu.Unlock()
+	g.body.Close()
+}
+
+func (g *streamGuard) timeoutErr() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
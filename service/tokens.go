@@ -0,0 +1,71 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/atopos31/llmio/tokenizer"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	messageTokenOverhead = 4   // 每条消息角色与分隔符的额外开销
+	imageTokenEstimate   = 765 // 单张图片的估算 token（按 OpenAI high detail 1024x1024 计）
+)
+
+// promptTextSkipKeys 不计入 token 的结构性字段
+var promptTextSkipKeys = map[string]bool{
+	"type": true, "role": true, "id": true, "tool_use_id": true, "tool_call_id": true,
+	"url": true, "data": true, "media_type": true, "detail": true, "file_id": true,
+}
+
+// EstimatePromptTokens 估算请求体的输入 token 数，兼容 OpenAI / Responses / Anthropic 三种请求格式
+func EstimatePromptTokens(model string, raw []byte) (int, error) {
+	var builder strings.Builder
+	overhead := 0
+
+	for _, key := range []string{"system", "instructions"} {
+		collectPromptText(&builder, gjson.GetBytes(raw, key), &overhead)
+	}
+	for _, key := range []string{"messages", "input"} {
+		value := gjson.GetBytes(raw, key)
+		if value.IsArray() {
+			overhead += len(value.Array()) * messageTokenOverhead
+		}
+		collectPromptText(&builder, value, &overhead)
+	}
+	if tools := gjson.GetBytes(raw, "tools"); tools.Exists() {
+		builder.WriteString(tools.Raw)
+	}
+
+	count, _, err := tokenizer.Count(model, "", builder.String())
+	if err != nil {
+		return 0, err
+	}
+	return count + overhead, nil
+}
+
+// collectPromptText 递归收集请求中的文本内容，图片按固定值计入 overhead
+func collectPromptText(builder *strings.Builder, value gjson.Result, overhead *int) {
+	switch {
+	case value.Type == gjson.String:
+		builder.WriteString(value.String())
+		builder.WriteByte('\n')
+	case value.IsArray():
+		value.ForEach(func(_, item gjson.Result) bool {
+			collectPromptText(builder, item, overhead)
+			return true
+		})
+	case value.IsObject():
+		switch value.Get("type").String() {
+		case "image", "image_url", "input_image":
+			*overhead += imageTokenEstimate
+			return
+		}
+		value.ForEach(func(key, item gjson.Result) bool {
+			if !promptTextSkipKeys[key.String()] {
+				collectPromptText(builder, item, overhead)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	toolAuditQueueSize = 1024
+	toolAuditTimeout   = 10 * time.Second
+	redactedValue      = "[REDACTED]"
+)
+
+// sensitiveArgumentKey 工具参数中需要脱敏的字段名
+var sensitiveArgumentKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|api[_-]?key|authorization|credential|cookie|private[_-]?key)`)
+
+// ToolCall 响应中观测到的一次工具调用
+type ToolCall struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments any    `json:"arguments"`
+}
+
+// ToolAuditEvent 推送给审计 webhook 的事件
+type ToolAuditEvent struct {
+	LogID     uint       `json:"log_id"`
+	Model     string     `json:"model"`
+	Time      time.Time  `json:"time"`
+	ToolCalls []ToolCall `json:"tool_calls"`
+}
+
+type toolAuditJob struct {
+	url   string
+	event ToolAuditEvent
+}
+
+var (
+	toolAuditQueue  = make(chan toolAuditJob, toolAuditQueueSize)
+	toolAuditClient = &http.Client{Timeout: toolAuditTimeout}
+)
+
+func init() {
+	go runToolAuditExporter()
+}
+
+// runToolAuditExporter 串行消费审计队列，推送失败只记录日志不重试
+func runToolAuditExporter() {
+	for job := range toolAuditQueue {
+		if err := postToolAuditEvent(job); err != nil {
+			slog.Error("tool audit webhook error", "log_id", job.event.LogID, "error", err)
+		}
+	}
+}
+
+func postToolAuditEvent(job toolAuditJob) error {
+	body, err := json.Marshal(job.event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), toolAuditTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := toolAuditClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// AuditToolCalls 提取响应中的工具调用并异步推送到 webhook，队列满时丢弃
+func AuditToolCalls(webhook string, logId uint, model string, output models.OutputUnion) {
+	if webhook == "" {
+		return
+	}
+	calls := ExtractToolCalls(output)
+	if len(calls) == 0 {
+		return
+	}
+	job := toolAuditJob{
+		url: webhook,
+		event: ToolAuditEvent{
+			LogID:     logId,
+			Model:     model,
+			Time:      time.Now(),
+			ToolCalls: calls,
+		},
+	}
+	select {
+	case toolAuditQueue <- job:
+	default:
+		slog.Warn("tool audit queue full, event dropped", "log_id", logId, "model", model)
+	}
+}
+
+// ExtractToolCalls 从 OpenAI / Responses / Anthropic 格式的响应（含流式）中提取工具调用，参数已脱敏
+func ExtractToolCalls(output models.OutputUnion) []ToolCall {
+	var calls []ToolCall
+	if output.OfString != "" {
+		calls = extractToolCallsFromBody(gjson.Parse(output.OfString))
+	} else {
+		calls = extractToolCallsFromStream(output.OfStringArray)
+	}
+	for i := range calls {
+		calls[i].Arguments = redactArguments(calls[i].Arguments)
+	}
+	return calls
+}
+
+// extractToolCallsFromBody 解析非流式响应体
+func extractToolCallsFromBody(body gjson.Result) []ToolCall {
+	var calls []ToolCall
+	// OpenAI chat completions
+	body.Get("choices.#.message.tool_calls|@flatten").ForEach(func(_, call gjson.Result) bool {
+		calls = append(calls, ToolCall{
+			ID:        call.Get("id").String(),
+			Name:      call.Get("function.name").String(),
+			Arguments: call.Get("function.arguments").String(),
+		})
+		return true
+	})
+	// OpenAI Responses
+	body.Get("output").ForEach(func(_, item gjson.Result) bool {
+		if call, ok := responsesToolCall(item); ok {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	// Anthropic messages
+	body.Get("content").ForEach(func(_, block gjson.Result) bool {
+		if block.Get("type").String() == "tool_use" {
+			calls = append(calls, ToolCall{
+				ID:        block.Get("id").String(),
+				Name:      block.Get("name").String(),
+				Arguments: block.Get("input").Raw,
+			})
+		}
+		return true
+	})
+	return calls
+}
+
+func responsesToolCall(item gjson.Result) (ToolCall, bool) {
+	if item.Get("type").String() != "function_call" {
+		return ToolCall{}, false
+	}
+	return ToolCall{
+		ID:        item.Get("call_id").String(),
+		Name:      item.Get("name").String(),
+		Arguments: item.Get("arguments").String(),
+	}, true
+}
+
+// streamToolCall 流式响应中按 index 拼接的工具调用
+type streamToolCall struct {
+	id, name string
+	args     bytes.Buffer
+}
+
+// extractToolCallsFromStream 解析流式 chunk，按 index 拼接参数片段
+func extractToolCallsFromStream(chunks []string) []ToolCall {
+	pending := make(map[int64]*streamToolCall)
+	get := func(index int64) *streamToolCall {
+		call, ok := pending[index]
+		if !ok {
+			call = &streamToolCall{}
+			pending[index] = call
+		}
+		return call
+	}
+
+	var calls []ToolCall
+	for _, chunk := range chunks {
+		data := gjson.Parse(chunk)
+		// OpenAI chat completions
+		data.Get("choices.0.delta.tool_calls").ForEach(func(_, delta gjson.Result) bool {
+			call := get(delta.Get("index").Int())
+			if id := delta.Get("id").String(); id != "" {
+				call.id = id
+			}
+			if name := delta.Get("function.name").String(); name != "" {
+				call.name = name
+			}
+			call.args.WriteString(delta.Get("function.arguments").String())
+			return true
+		})
+
+		switch data.Get("type").String() {
+		// OpenAI Responses：完成事件携带完整参数
+		case "response.output_item.done":
+			if call, ok := responsesToolCall(data.Get("item")); ok {
+				calls = append(calls, call)
+			}
+		// Anthropic messages
+		case "content_block_start":
+			if block := data.Get("content_block"); block.Get("type").String() == "tool_use" {
+				call := get(data.Get("index").Int())
+				call.id = block.Get("id").String()
+				call.name = block.Get("name").String()
+			}
+		case "content_block_delta":
+			if delta := data.Get("delta"); delta.Get("type").String() == "input_json_delta" {
+				get(data.Get("index").Int()).args.WriteString(delta.Get("partial_json").String())
+			}
+		}
+	}
+
+	indexes := make([]int64, 0, len(pending))
+	for index, call := range pending {
+		if call.name != "" {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, index := range indexes {
+		call := pending[index]
+		calls = append(calls, ToolCall{ID: call.id, Name: call.name, Arguments: call.args.String()})
+	}
+	return calls
+}
+
+// redactArguments 解析 JSON 参数并脱敏敏感字段，无法解析时整体脱敏
+func redactArguments(arguments any) any {
+	raw, ok := arguments.(string)
+	if !ok || raw == "" {
+		return map[string]any{}
+	}
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return redactedValue
+	}
+	return redactValue(value)
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if sensitiveArgumentKey.MatchString(key) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactValue(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
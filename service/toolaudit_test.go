@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"github.com/tidwall/gjson"
+)
+
+func TestExtractToolCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		output models.OutputUnion
+		want   string // 序列化后的工具调用
+	}{
+		{
+			name:   "openai body",
+			output: models.OutputUnion{OfString: `{"choices":[{"message":{"tool_calls":[{"id":"c1","type":"function","function":{"name":"login","arguments":"{\"user\":\"bob\",\"password\":\"hunter2\",\"headers\":{\"Authorization\":\"Bearer x\"}}"}}]}}]}`},
+			want:   `[{"id":"c1","name":"login","arguments":{"headers":{"Authorization":"[REDACTED]"},"password":"[REDACTED]","user":"bob"}}]`,
+		},
+		{
+			name:   "responses body",
+			output: models.OutputUnion{OfString: `{"output":[{"type":"message"},{"type":"function_call","call_id":"fc1","name":"search","arguments":"{\"items\":[{\"api_key\":\"k\"}]}"}]}`},
+			want:   `[{"id":"fc1","name":"search","arguments":{"items":[{"api_key":"[REDACTED]"}]}}]`,
+		},
+		{
+			name:   "anthropic body",
+			output: models.OutputUnion{OfString: `{"content":[{"type":"text","text":"hi"},{"type":"tool_use","id":"tu1","name":"weather","input":{"city":"Paris"}}]}`},
+			want:   `[{"id":"tu1","name":"weather","arguments":{"city":"Paris"}}]`,
+		},
+		{
+			name:   "unparseable arguments",
+			output: models.OutputUnion{OfString: `{"choices":[{"message":{"tool_calls":[{"id":"c1","function":{"name":"f","arguments":"{\"token\":"}}]}}]}`},
+			want:   `[{"id":"c1","name":"f","arguments":"[REDACTED]"}]`,
+		},
+		{
+			name:   "empty arguments",
+			output: models.OutputUnion{OfString: `{"choices":[{"message":{"tool_calls":[{"id":"c1","function":{"name":"f","arguments":""}}]}}]}`},
+			want:   `[{"id":"c1","name":"f","arguments":{}}]`,
+		},
+		{
+			name: "openai stream",
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"c2","function":{"name":"b","arguments":""}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"c1","function":{"name":"a","arguments":"{\"x\":"}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}},{"index":1,"function":{"arguments":"{}"}}]}}]}`,
+			}},
+			want: `[{"id":"c1","name":"a","arguments":{"x":1}},{"id":"c2","name":"b","arguments":{}}]`,
+		},
+		{
+			name: "responses stream",
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"type":"response.function_call_arguments.delta","delta":"{\"q\""}`,
+				`{"type":"response.output_item.done","item":{"type":"function_call","call_id":"fc1","name":"search","arguments":"{\"q\":\"go\"}"}}`,
+			}},
+			want: `[{"id":"fc1","name":"search","arguments":{"q":"go"}}]`,
+		},
+		{
+			name: "anthropic stream",
+			output: models.OutputUnion{OfStringArray: []string{
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"tu1","name":"weather","input":{}}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+				`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\",\"secret\":\"s\"}"}}`,
+			}},
+			want: `[{"id":"tu1","name":"weather","arguments":{"city":"Paris","secret":"[REDACTED]"}}]`,
+		},
+		{
+			name:   "no tool calls",
+			output: models.OutputUnion{OfString: `{"choices":[{"message":{"content":"hi"}}]}`},
+			want:   `null`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(ExtractToolCalls(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("tool calls = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditToolCalls(t *testing.T) {
+	upstream := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, `{}`))
+	// 没有工具调用或未配置 webhook 时不推送
+	AuditToolCalls(upstream.URL, 1, "m", models.OutputUnion{OfString: `{"choices":[{"message":{"content":"hi"}}]}`})
+	AuditToolCalls("", 2, "m", models.OutputUnion{OfString: `{"content":[{"type":"tool_use","id":"tu1","name":"f","input":{}}]}`})
+	AuditToolCalls(upstream.URL, 3, "m", models.OutputUnion{OfString: `{"content":[{"type":"tool_use","id":"tu1","name":"f","input":{"password":"p"}}]}`})
+
+	var requests []testutil.RecordedRequest
+	for range 100 {
+		if requests = upstream.Requests(); len(requests) > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected one audit event, got %d", len(requests))
+	}
+	event := gjson.ParseBytes(requests[0].Body)
+	if event.Get("log_id").Int() != 3 || event.Get("model").String() != "m" || event.Get("tool_calls").Raw != `[{"id":"tu1","name":"f","arguments":{"password":"[REDACTED]"}}]` {
+		t.Errorf("event = %s", requests[0].Body)
+	}
+}
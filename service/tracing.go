@@ -0,0 +1,541 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceparentHeader W3C Trace Context 的传播请求头
+const TraceparentHeader = "traceparent"
+
+// SpanKind OTLP span 类型
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+const (
+	defaultTraceServiceName   = "llmio"
+	defaultTraceBatchSize     = 512
+	defaultTraceFlushInterval = 5 * time.Second
+	traceQueueSize            = 4096
+	traceExportTimeout        = 10 * time.Second
+)
+
+// TraceConfig OTLP 链路追踪导出配置
+type TraceConfig struct {
+	Endpoint      string            // OTLP/HTTP traces 地址，如 http://tempo:4318/v1/traces
+	Headers       map[string]string // 导出请求附带的请求头，如鉴权
+	ServiceName   string
+	SampleRatio   float64 // 根 span 的采样比例，有上游 traceparent 时沿用其采样标记
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// TraceConfigFromEnv 从标准 OTEL_* 环境变量读取配置，未设置导出地址时返回 false
+func TraceConfigFromEnv() (TraceConfig, bool, error) {
+	cfg := TraceConfig{
+		Endpoint:      strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")),
+		Headers:       map[string]string{},
+		ServiceName:   strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+		SampleRatio:   1,
+		BatchSize:     defaultTraceBatchSize,
+		FlushInterval: defaultTraceFlushInterval,
+	}
+	if cfg.Endpoint == "" {
+		if base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+			cfg.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	if cfg.Endpoint == "" {
+		return cfg, false, nil
+	}
+	if _, err := url.ParseRequestURI(cfg.Endpoint); err != nil {
+		return cfg, false, fmt.Errorf("invalid otlp endpoint %q: %w", cfg.Endpoint, err)
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/json" {
+		return cfg, false, fmt.Errorf("unsupported otlp protocol %q, only http/json is supported", protocol)
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTraceServiceName
+	}
+	for _, pair := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return cfg, false, fmt.Errorf("invalid otlp header %q", pair)
+		}
+		if decoded, err := url.QueryUnescape(value); err == nil {
+			value = decoded
+		}
+		cfg.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return cfg, false, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q, want a ratio between 0 and 1", arg)
+		}
+		cfg.SampleRatio = ratio
+	}
+	return cfg, true, nil
+}
+
+var tracer atomic.Pointer[Tracer]
+
+// InitTracing 设置 OTEL_EXPORTER_OTLP_ENDPOINT 时启用链路追踪，导出配置无效时返回错误
+func InitTracing(ctx context.Context) error {
+	cfg, enabled, err := TraceConfigFromEnv()
+	if err != nil || !enabled {
+		return err
+	}
+	tracer.Store(NewTracer(cfg))
+	slog.Info("otlp tracing enabled", "endpoint", cfg.Endpoint, "service", cfg.ServiceName, "sample_ratio", cfg.SampleRatio)
+	return nil
+}
+
+// Tracer 批量导出 span 到 OTLP/HTTP（JSON 编码）收集端
+type Tracer struct {
+	cfg     TraceConfig
+	client  *http.Client
+	queue   chan otlpSpan
+	flushes chan chan struct{}
+	dropped atomic.Int64
+}
+
+// NewTracer 创建并启动导出协程
+func NewTracer(cfg TraceConfig) *Tracer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultTraceBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultTraceFlushInterval
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultTraceServiceName
+	}
+	t := &Tracer{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: traceExportTimeout},
+		queue:   make(chan otlpSpan, traceQueueSize),
+		flushes: make(chan chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Flush 立即导出已结束的 span
+func (t *Tracer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case t.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.cfg.BatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		case done := <-t.flushes:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			t.export(batch)
+			batch = nil
+			close(done)
+		}
+	}
+}
+
+func (t *Tracer) export(spans []otlpSpan) {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		slog.Warn("dropped spans, trace export queue is full", "dropped", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	payload := map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttributes([]any{"service.name", t.cfg.ServiceName})},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]string{"name": "github.com/atopos31/llmio"},
+			"spans": spans,
+		}},
+	}}}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to marshal traces", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.cfg.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		slog.Error("failed to build trace export request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		slog.Warn("failed to export traces", "spans", len(spans), "error", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		slog.Warn("failed to export traces", "spans", len(spans), "status", res.StatusCode, "body", string(body))
+	}
+}
+
+// enqueue 队列已满时丢弃 span，不阻塞请求
+func (t *Tracer) enqueue(span otlpSpan) {
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) sample(traceID [16]byte) bool {
+	if t.cfg.SampleRatio >= 1 {
+		return true
+	}
+	// 按 trace ID 低 8 字节决定，同一 trace 在各处的采样结果一致
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < t.cfg.SampleRatio
+}
+
+// spanContext 跨进程传播的 trace 标识
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanKey struct{}
+
+type remoteSpanKey struct{}
+
+// Span 一个计时阶段，未启用追踪时为 nil，所有方法均可在 nil 上调用
+type Span struct {
+	tracer   *Tracer
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     SpanKind
+	start    time.Time
+
+	mu     sync.Mutex
+	attrs  []any
+	events []otlpEvent
+	err    string
+	ended  bool
+}
+
+// StartSpan 在 ctx 中的 span（或上游 traceparent）之下开始子 span，attrs 为 key、value 交替的属性；
+// 每个 span 都带上请求 ID，便于从请求日志定位 trace
+func StartSpan(ctx context.Context, name string, kind SpanKind, attrs ...any) (context.Context, *Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.sc.traceID, span.parentID, span.sc.sampled = parent.sc.traceID, parent.sc.spanID, parent.sc.sampled
+	} else if remote, ok := ctx.Value(remoteSpanKey{}).(spanContext); ok {
+		span.sc.traceID, span.parentID, span.sc.sampled = remote.traceID, remote.spanID, remote.sampled
+	} else {
+		rand.Read(span.sc.traceID[:])
+		span.sc.sampled = t.sample(span.sc.traceID)
+	}
+	rand.Read(span.sc.spanID[:])
+	if id := RequestIDFromContext(ctx); id != "" {
+		span.attrs = append(span.attrs, "llmio.request_id", id)
+	}
+	span.attrs = append(span.attrs, attrs...)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SetAttributes 追加 key、value 交替的属性
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// AddEvent 记录带时间戳的事件
+func (s *Span) AddEvent(name string, attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, otlpEvent{
+		TimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		Name:         name,
+		Attributes:   otlpAttributes(attrs),
+	})
+	s.mu.Unlock()
+}
+
+// RecordError 将 span 状态标记为错误
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// TraceID 返回十六进制 trace ID，未启用追踪时为空
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sc.traceID[:])
+}
+
+// End 结束 span 并交给导出协程，重复调用只生效一次
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if !s.sc.sampled {
+		return
+	}
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attrs),
+		Events:            s.events,
+	}
+	if s.parentID != ([8]byte{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		span.Status = &otlpStatus{Code: 2, Message: s.err}
+	}
+	s.tracer.enqueue(span)
+}
+
+// SpanFromContext 获取 ctx 中当前的 span
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// TraceIDFromContext 获取 ctx 中的 trace ID，未启用追踪时为空
+func TraceIDFromContext(ctx context.Context) string {
+	return SpanFromContext(ctx).TraceID()
+}
+
+// ExtractTraceparent 解析上游传入的 traceparent，之后的 span 作为其子 span
+func ExtractTraceparent(ctx context.Context, value string) context.Context {
+	sc, ok := parseTraceparent(value)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteSpanKey{}, sc)
+}
+
+// parseTraceparent 解析 00-<trace-id>-<parent-id>-<flags>，全零 ID 视为无效
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// setTraceparentHeader 在发往上游的请求头中传播当前 span
+func setTraceparentHeader(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	flags := "00"
+	if span.sc.sampled {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, "00-"+hex.EncodeToString(span.sc.traceID[:])+"-"+hex.EncodeToString(span.sc.spanID[:])+"-"+flags)
+}
+
+// upstreamClientTrace 将 DNS、建连、TLS、写请求与首字节等 httptrace 阶段记录为 span 事件
+func upstreamClientTrace(span *Span, reqStart time.Time) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) { span.AddEvent("get_conn", "net.peer", hostPort) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.AddEvent("got_conn", "reused", info.Reused, "was_idle", info.WasIdle)
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) { span.AddEvent("dns_start", "host", info.Host) },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			span.AddEvent("dns_done", "error", errorString(info.Err))
+		},
+		ConnectStart: func(network, addr string) { span.AddEvent("connect_start", "addr", addr) },
+		ConnectDone: func(network, addr string, err error) {
+			span.AddEvent("connect_done", "addr", addr, "error", errorString(err))
+		},
+		TLSHandshakeStart: func() { span.AddEvent("tls_handshake_start") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			span.AddEvent("tls_handshake_done", "error", errorString(err))
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			span.AddEvent("wrote_request", "error", errorString(info.Err))
+		},
+		GotFirstResponseByte: func() {
+			slog.Debug("first response byte received", "response_time", time.Since(reqStart))
+			span.AddEvent("first_response_byte")
+		},
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// otlpSpan OTLP/JSON 编码的 span，ID 为十六进制，时间为字符串形式的纳秒
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// otlpAttributes 将 key、value 交替的参数转为 OTLP 属性，空字符串值省略
+func otlpAttributes(attrs []any) []otlpKeyValue {
+	var values []otlpKeyValue
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		var value map[string]any
+		switch v := attrs[i+1].(type) {
+		case nil:
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case uint:
+			value = map[string]any{"intValue": strconv.FormatUint(uint64(v), 10)}
+		case uint64:
+			value = map[string]any{"intValue": strconv.FormatUint(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case time.Duration:
+			value = map[string]any{"doubleValue": float64(v.Microseconds()) / 1000}
+		case error:
+			value = map[string]any{"stringValue": v.Error()}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		values = append(values, otlpKeyValue{Key: key, Value: value})
+	}
+	return values
+}
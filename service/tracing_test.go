@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/consts"
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+)
+
+// fakeCollector 接收 OTLP/JSON 导出请求并保存其中的 span
+type fakeCollector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+func newFakeCollector(t *testing.T) (*fakeCollector, *httptest.Server) {
+	t.Helper()
+	collector := &fakeCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Tenant") != "team-a" {
+			t.Errorf("unexpected export request %s %s %v", r.Method, r.URL.Path, r.Header)
+		}
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid otlp payload: %v", err)
+		}
+		collector.mu.Lock()
+		for _, resource := range payload.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				collector.spans = append(collector.spans, scope.Spans...)
+			}
+		}
+		collector.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return collector, server
+}
+
+func (c *fakeCollector) named(name string) []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []otlpSpan
+	for _, span := range c.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func spanAttr(span otlpSpan, key string) any {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			for _, value := range attr.Value {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+func hasEvent(span otlpSpan, name string) bool {
+	for _, event := range span.Events {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func useTracer(t *testing.T, cfg TraceConfig) *Tracer {
+	t.Helper()
+	tr := NewTracer(cfg)
+	tracer.Store(tr)
+	t.Cleanup(func() { tracer.Store(nil) })
+	return tr
+}
+
+func TestTracingPipeline(t *testing.T) {
+	testutil.SetupDB(t)
+	primary := testutil.NewUpstream(t, testutil.JSON(http.StatusInternalServerError, `{"error":"boom"}`))
+	backup := testutil.NewUpstream(t, testutil.JSON(http.StatusOK, testutil.OpenAIChatResponse("upstream-model", "hello", 10, 5)))
+	model := testutil.SeedModel(t, "test-model")
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "primary", consts.StyleOpenAI, primary.URL), "upstream-model", 200, 1)
+	testutil.SeedAssociation(t, model, testutil.SeedProvider(t, "backup", consts.StyleOpenAI, backup.URL), "upstream-model", 100, 1)
+	collector, server := newFakeCollector(t)
+	tr := useTracer(t, TraceConfig{Endpoint: server.URL + "/v1/traces", Headers: map[string]string{"X-Tenant": "team-a"}, SampleRatio: 1})
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	ctx := WithRequestID(ExtractTraceparent(context.Background(), "00-"+traceID+"-"+parentID+"-01"), "req-1")
+	ctx, root := StartSpan(ctx, "POST /v1/chat/completions", SpanKindServer)
+	before, meta := loadTestCandidates(t)
+	res, _, err := balanceModel(ctx, time.Now(), consts.StyleOpenAI, *before, *meta, models.ReqMeta{Header: http.Header{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	root.End()
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := collector.named("POST /v1/chat/completions")
+	if len(roots) != 1 || roots[0].ParentSpanID != parentID || roots[0].Kind != int(SpanKindServer) {
+		t.Fatalf("root spans = %+v, want child of incoming traceparent", roots)
+	}
+	if got := collector.named("balancer.select"); len(got) != 2 {
+		t.Errorf("balancer.select spans = %d, want one per attempt", len(got))
+	}
+	upstreams := collector.named("upstream.request")
+	if len(upstreams) != 2 {
+		t.Fatalf("upstream.request spans = %d, want 2", len(upstreams))
+	}
+	if upstreams[0].Status == nil || upstreams[0].Status.Code != 2 || spanAttr(upstreams[0], "http.response.status_code") != "500" {
+		t.Errorf("failed attempt span = %+v, want error status", upstreams[0])
+	}
+	if upstreams[1].Status != nil || !hasEvent(upstreams[1], "wrote_request") || !hasEvent(upstreams[1], "first_response_byte") {
+		t.Errorf("served attempt span = %+v, want ok with httptrace events", upstreams[1])
+	}
+
+	collector.mu.Lock()
+	for _, span := range collector.spans {
+		if span.TraceID != traceID {
+			t.Errorf("%s trace id = %s, want %s", span.Name, span.TraceID, traceID)
+		}
+		if span.Name != roots[0].Name && span.ParentSpanID != roots[0].SpanID {
+			t.Errorf("%s parent = %s, want root span %s", span.Name, span.ParentSpanID, roots[0].SpanID)
+		}
+		if spanAttr(span, "llmio.request_id") != "req-1" {
+			t.Errorf("%s missing request id", span.Name)
+		}
+	}
+	collector.mu.Unlock()
+
+	// 上游收到以对应上游调用 span 为父级的 traceparent
+	if got := backup.Requests(); len(got) != 1 || got[0].Header.Get(TraceparentHeader) != "00-"+traceID+"-"+upstreams[1].SpanID+"-01" {
+		t.Errorf("upstream traceparent = %+v", got)
+	}
+}
+
+func TestTracingSampling(t *testing.T) {
+	collector, server := newFakeCollector(t)
+	tr := useTracer(t, TraceConfig{Endpoint: server.URL + "/v1/traces", Headers: map[string]string{"X-Tenant": "team-a"}, SampleRatio: 0})
+
+	// 未采样的 trace 不导出，但仍向上游传播 sampled=0
+	ctx, span := StartSpan(context.Background(), "root", SpanKindServer)
+	header := http.Header{}
+	setTraceparentHeader(ctx, header)
+	if got := header.Get(TraceparentHeader); !strings.HasPrefix(got, "00-"+span.TraceID()+"-") || !strings.HasSuffix(got, "-00") {
+		t.Errorf("traceparent = %q", got)
+	}
+	span.End()
+	// 上游已采样时沿用其决定
+	_, sampled := StartSpan(ExtractTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), "sampled", SpanKindServer)
+	sampled.End()
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.named("root")) != 0 || len(collector.named("sampled")) != 1 {
+		t.Errorf("exported spans = %+v", collector.spans)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(value); ok {
+			t.Errorf("parseTraceparent(%q) should fail", value)
+		}
+	}
+}
@@ -30,6 +30,7 @@ func TransformAnthropicToUnified(rawBody []byte) (*UnifiedRequest, error) {
 	if topP, ok := req["top_p"].(float64); ok {
 		unified.TopP = &topP
 	}
+	unified.Reasoning = parseAnthropicThinking(req["thinking"])
 
 	// 转换消息
 	if messages, ok := req["messages"].([]interface{}); ok {
@@ -40,6 +41,10 @@ func TransformAnthropicToUnified(rawBody []byte) (*UnifiedRequest, error) {
 				Content:   msgMap["content"],
 				ToolCalls: parseAnthropicToolCalls(msgMap),
 			}
+			// 历史回复中的 thinking 块单独保存，其他格式的上游不接受该内容块
+			if content, ok := msgMap["content"].([]interface{}); ok && unifiedMsg.Role == "assistant" {
+				unifiedMsg.Content, unifiedMsg.ReasoningContent, unifiedMsg.ReasoningSignature = splitAnthropicThinking(content)
+			}
 			
 			// 解析 tool_result 类型的内容
 			if content, ok := msgMap["content"].([]interface{}); ok {
@@ -71,10 +76,77 @@ func TransformAnthropicToUnified(rawBody []byte) (*UnifiedRequest, error) {
 			})
 		}
 	}
+	unified.ToolChoice, unified.ParallelToolCalls = parseAnthropicToolChoice(req["tool_choice"])
+	unified.ResponseFormat = parseAnthropicOutputFormat(req["output_format"])
+	unified.Stop = parseStopSequences(req["stop_sequences"])
 
 	return unified, nil
 }
 
+// parseAnthropicThinking 解析 Anthropic thinking 参数：enabled 带思考 token 预算，disabled 表示关闭思考
+func parseAnthropicThinking(value interface{}) *UnifiedReasoning {
+	thinking, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	switch getString(thinking, "type") {
+	case "enabled":
+		return &UnifiedReasoning{BudgetTokens: int(getFloat(thinking, "budget_tokens"))}
+	case "disabled":
+		return &UnifiedReasoning{Effort: "none"}
+	}
+	return nil
+}
+
+// parseAnthropicToolChoice 解析 Anthropic tool_choice 对象：auto / any / tool / none，
+// disable_parallel_tool_use 对应并行工具调用开关
+func parseAnthropicToolChoice(value interface{}) (*UnifiedToolChoice, *bool) {
+	choiceMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	var parallel *bool
+	if disabled, ok := choiceMap["disable_parallel_tool_use"].(bool); ok {
+		enabled := !disabled
+		parallel = &enabled
+	}
+	switch getString(choiceMap, "type") {
+	case "auto":
+		return &UnifiedToolChoice{Type: ToolChoiceAuto}, parallel
+	case "none":
+		return &UnifiedToolChoice{Type: ToolChoiceNone}, parallel
+	case "any":
+		return &UnifiedToolChoice{Type: ToolChoiceRequired}, parallel
+	case "tool":
+		return &UnifiedToolChoice{Type: ToolChoiceFunction, Name: getString(choiceMap, "name")}, parallel
+	}
+	return nil, parallel
+}
+
+// formatAnthropicToolChoice 生成 Anthropic tool_choice，只设置并行开关时使用 auto
+func formatAnthropicToolChoice(choice *UnifiedToolChoice, parallel *bool) map[string]interface{} {
+	if choice == nil && parallel == nil {
+		return nil
+	}
+	choiceMap := map[string]interface{}{"type": "auto"}
+	if choice != nil {
+		switch choice.Type {
+		case ToolChoiceNone:
+			choiceMap["type"] = "none"
+		case ToolChoiceRequired:
+			choiceMap["type"] = "any"
+		case ToolChoiceFunction:
+			choiceMap["type"] = "tool"
+			choiceMap["name"] = choice.Name
+		}
+	}
+	// none 不接受 disable_parallel_tool_use
+	if parallel != nil && choiceMap["type"] != "none" {
+		choiceMap["disable_parallel_tool_use"] = !*parallel
+	}
+	return choiceMap
+}
+
 // extractSystem 支持 Anthropic system 为字符串或数组的情况，确保 system 不被静默丢失
 func extractSystem(value interface{}) string {
 	switch v := value.(type) {
@@ -121,6 +193,33 @@ func TransformUnifiedToAnthropic(unified *UnifiedRequest) ([]byte, error) {
 	if unified.System != "" {
 		req["system"] = unified.System
 	}
+	// Anthropic 不接受只有空白字符的停止序列；惩罚系数、随机种子与 logprobs 没有对应参数
+	var stopSequences []string
+	for _, stop := range unified.Stop {
+		if strings.TrimSpace(stop) != "" {
+			stopSequences = append(stopSequences, stop)
+		}
+	}
+	if len(stopSequences) > 0 {
+		req["stop_sequences"] = stopSequences
+	}
+	if unified.User != "" {
+		req["metadata"] = map[string]interface{}{"user_id": unified.User}
+	}
+	if reasoning := unified.Reasoning; reasoning != nil {
+		if reasoningDisabled(reasoning) {
+			req["thinking"] = map[string]interface{}{"type": "disabled"}
+		} else if budget := reasoningBudget(reasoning); budget > 0 {
+			// 思考预算最少 1024 且必须小于 max_tokens，开启思考时不接受自定义 temperature 与 top_p
+			budget = max(budget, 1024)
+			req["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+			if maxTokens := req["max_tokens"].(int); maxTokens <= budget {
+				req["max_tokens"] = budget + maxTokens
+			}
+			delete(req, "temperature")
+			delete(req, "top_p")
+		}
+	}
 
 	// 转换消息
 	messages := []interface{}{}
@@ -156,16 +255,18 @@ func TransformUnifiedToAnthropic(unified *UnifiedRequest) ([]byte, error) {
 		// 处理 tool 角色消息，转换为 Anthropic 的 tool_result 格式
 		if msg.Role == "tool" {
 			contentArray := []interface{}{}
-			var contentStr string
-			if msg.Content != nil {
-				if str, ok := msg.Content.(string); ok {
-					contentStr = str
-				}
+			var toolContent interface{} = ""
+			switch content := msg.Content.(type) {
+			case string:
+				toolContent = content
+			case []interface{}:
+				// 内容块数组中的图片转为 image 块，其余原样保留，其中的 cache_control 随之转发
+				toolContent = anthropicContent(content)
 			}
 			contentArray = append(contentArray, map[string]interface{}{
 				"type":        "tool_result",
 				"tool_use_id": msg.ToolCallID,
-				"content":     contentStr,
+				"content":     toolContent,
 			})
 			messages = append(messages, map[string]interface{}{
 				"role":    "user",
@@ -178,20 +279,23 @@ func TransformUnifiedToAnthropic(unified *UnifiedRequest) ([]byte, error) {
 			"role": msg.Role,
 		}
 		if msg.Content != nil {
-			msgMap["content"] = msg.Content
+			msgMap["content"] = anthropicContent(msg.Content)
 		}
 		if len(msg.ToolCalls) > 0 {
 			// 如果有工具调用，需要构建包含文本和工具调用的内容数组
 			contentArray := []interface{}{}
 
 			// 如果有文本内容，先添加文本块
-			if msg.Content != nil {
-				if contentStr, ok := msg.Content.(string); ok && contentStr != "" {
+			switch content := msgMap["content"].(type) {
+			case string:
+				if content != "" {
 					contentArray = append(contentArray, map[string]interface{}{
 						"type": "text",
-						"text": contentStr,
+						"text": content,
 					})
 				}
+			case []interface{}:
+				contentArray = append(contentArray, content...)
 			}
 
 			// 添加工具调用块
@@ -213,21 +317,54 @@ func TransformUnifiedToAnthropic(unified *UnifiedRequest) ([]byte, error) {
 			}
 			msgMap["content"] = contentArray
 		}
+		// 带签名的思考内容还原为 thinking 块，必须位于回复内容之前
+		if msg.ReasoningSignature != "" {
+			contentArray := []interface{}{anthropicThinkingBlock(msg.ReasoningContent, msg.ReasoningSignature)}
+			switch content := msgMap["content"].(type) {
+			case string:
+				if content != "" {
+					contentArray = append(contentArray, map[string]interface{}{"type": "text", "text": content})
+				}
+			case []interface{}:
+				contentArray = append(contentArray, content...)
+			}
+			msgMap["content"] = contentArray
+		}
 		messages = append(messages, msgMap)
 	}
 	req["messages"] = messages
 
+	// system 带有提示缓存断点时改用内容块形式
+	if system, ok := req["system"].(string); ok && system != "" && unified.SystemCacheControl != nil {
+		req["system"] = []interface{}{map[string]interface{}{
+			"type":          "text",
+			"text":          system,
+			"cache_control": unified.SystemCacheControl,
+		}}
+	}
+
 	// 转换工具
 	if len(unified.Tools) > 0 {
 		tools := []interface{}{}
 		for _, tool := range unified.Tools {
-			tools = append(tools, map[string]interface{}{
+			anthropicTool := map[string]interface{}{
 				"name":         tool.Function.Name,
 				"description":  tool.Function.Description,
 				"input_schema": tool.Function.Parameters,
-			})
+			}
+			if tool.CacheControl != nil {
+				anthropicTool["cache_control"] = tool.CacheControl
+			}
+			tools = append(tools, anthropicTool)
 		}
 		req["tools"] = tools
+		// Anthropic 只允许在提供工具时设置 tool_choice
+		if toolChoice := formatAnthropicToolChoice(unified.ToolChoice, unified.ParallelToolCalls); toolChoice != nil {
+			req["tool_choice"] = toolChoice
+		}
+	}
+	if unified.ResponseFormat.structured() {
+		anthropicStructuredOutput(req, unified.ResponseFormat)
 	}
 
 	return json.Marshal(req)
@@ -247,7 +384,7 @@ func parseAnthropicResponse(body []byte) (*UnifiedResponse, error) {
 	}
 
 	// 解析内容
-	var textContent string
+	var textContent, thinking, signature string
 	var toolCalls []UnifiedToolCall
 
 	if content, ok := resp["content"].([]interface{}); ok {
@@ -257,8 +394,16 @@ func parseAnthropicResponse(body []byte) (*UnifiedResponse, error) {
 
 			if itemType == "text" {
 				textContent += getString(itemMap, "text")
+			} else if itemType == "thinking" {
+				thinking += getString(itemMap, "thinking")
+				signature = getString(itemMap, "signature")
 			} else if itemType == "tool_use" {
 				args, _ := json.Marshal(itemMap["input"])
+				// 结构化输出工具的参数即为回复内容
+				if getString(itemMap, "name") == structuredOutputTool {
+					textContent += string(args)
+					continue
+				}
 				toolCalls = append(toolCalls, UnifiedToolCall{
 					ID:   getString(itemMap, "id"),
 					Type: "function",
@@ -276,24 +421,25 @@ func parseAnthropicResponse(body []byte) (*UnifiedResponse, error) {
 		finishReason = "stop"
 	} else if finishReason == "tool_use" {
 		finishReason = "tool_calls"
+		if len(toolCalls) == 0 {
+			finishReason = "stop"
+		}
 	}
 
 	unified.Choices = []UnifiedChoice{{
 		Index: 0,
 		Message: &UnifiedMessage{
-			Role:      "assistant",
-			Content:   textContent,
-			ToolCalls: toolCalls,
+			Role:               "assistant",
+			Content:            textContent,
+			ToolCalls:          toolCalls,
+			ReasoningContent:   thinking,
+			ReasoningSignature: signature,
 		},
 		FinishReason: finishReason,
 	}}
 
 	if usage, ok := resp["usage"].(map[string]interface{}); ok {
-		unified.Usage = &models.Usage{
-			PromptTokens:     int64(getFloat(usage, "input_tokens")),
-			CompletionTokens: int64(getFloat(usage, "output_tokens")),
-			TotalTokens:      int64(getFloat(usage, "input_tokens") + getFloat(usage, "output_tokens")),
-		}
+		unified.Usage = parseAnthropicUsage(usage)
 	}
 
 	return unified, nil
@@ -312,6 +458,11 @@ func formatAnthropicResponse(unified *UnifiedResponse) ([]byte, error) {
 		choice := unified.Choices[0]
 		content := []interface{}{}
 
+		// 思考内容位于回复内容之前
+		if choice.Message.ReasoningContent != "" {
+			content = append(content, anthropicThinkingBlock(choice.Message.ReasoningContent, choice.Message.ReasoningSignature))
+		}
+
 		// 添加文本内容
 		if choice.Message.Content != nil {
 			if textStr, ok := choice.Message.Content.(string); ok && textStr != "" {
@@ -339,25 +490,30 @@ func formatAnthropicResponse(unified *UnifiedResponse) ([]byte, error) {
 		resp["content"] = content
 
 		// 转换结束原因
-		stopReason := choice.FinishReason
-		if stopReason == "stop" {
-			stopReason = "end_turn"
-		} else if stopReason == "tool_calls" {
-			stopReason = "tool_use"
-		}
-		resp["stop_reason"] = stopReason
+		resp["stop_reason"] = anthropicStopReason(choice.FinishReason)
 	}
 
 	if unified.Usage != nil {
-		resp["usage"] = map[string]interface{}{
-			"input_tokens":  unified.Usage.PromptTokens,
-			"output_tokens": unified.Usage.CompletionTokens,
-		}
+		resp["usage"] = formatAnthropicUsage(unified.Usage)
 	}
 
 	return json.Marshal(resp)
 }
 
+// anthropicStopReason 将 OpenAI finish_reason 转换为 Anthropic stop_reason
+func anthropicStopReason(reason string) string {
+	switch reason {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
 func parseAnthropicToolCalls(msgMap map[string]interface{}) []UnifiedToolCall {
 	var toolCalls []UnifiedToolCall
 	if content, ok := msgMap["content"].([]interface{}); ok {
@@ -389,3 +545,43 @@ func parseAnthropicToolCalls(msgMap map[string]interface{}) []UnifiedToolCall {
 	}
 	return toolCalls
 }
+
+// parseAnthropicUsage 将 Anthropic usage 转为统一用量；Anthropic 的 input_tokens 不含缓存读写部分，
+// 统一用量与 OpenAI 一致，prompt_tokens 包含缓存读写的 token
+func parseAnthropicUsage(usage map[string]interface{}) *models.Usage {
+	read := int64(getFloat(usage, "cache_read_input_tokens"))
+	creation := int64(getFloat(usage, "cache_creation_input_tokens"))
+	prompt := int64(getFloat(usage, "input_tokens")) + read + creation
+	completion := int64(getFloat(usage, "output_tokens"))
+	return &models.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+		PromptTokensDetails: models.PromptTokensDetails{
+			CachedTokens:        read,
+			CacheCreationTokens: creation,
+		},
+	}
+}
+
+// formatAnthropicUsage 将统一用量转为 Anthropic usage，input_tokens 扣除缓存读写部分
+func formatAnthropicUsage(usage *models.Usage) map[string]interface{} {
+	details := usage.PromptTokensDetails
+	return map[string]interface{}{
+		"input_tokens":                max(usage.PromptTokens-details.CachedTokens-details.CacheCreationTokens, 0),
+		"output_tokens":               usage.CompletionTokens,
+		"cache_read_input_tokens":     details.CachedTokens,
+		"cache_creation_input_tokens": details.CacheCreationTokens,
+	}
+}
+
+// mergeAnthropicUsage 合并流式事件中的 usage：message_start 带输入与缓存用量，message_delta 带输出用量，
+// 后者的零值不覆盖已有字段
+func mergeAnthropicUsage(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if _, exists := dst[key]; exists && getFloat(src, key) == 0 {
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,757 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// TransformUnifiedToGemini 将统一格式转换为 Gemini generateContent 格式
+// stream 与 model 字段保留在请求体中，由 providers.Gemini 用于拼接 URL 后移除
+func TransformUnifiedToGemini(unified *UnifiedRequest) ([]byte, error) {
+	req := map[string]interface{}{
+		"model":  unified.Model,
+		"stream": unified.Stream,
+	}
+
+	system := unified.System
+	contents := []map[string]interface{}{}
+	// Gemini 的 functionResponse 需要函数名，按 tool_call_id 记录之前的调用
+	toolNames := map[string]string{}
+
+	for _, msg := range unified.Messages {
+		var role string
+		var parts []interface{}
+		switch msg.Role {
+		case "system":
+			if text := geminiText(msg.Content); text != "" {
+				if system != "" {
+					system += "\n\n" + text
+				} else {
+					system = text
+				}
+			}
+			continue
+		case "tool":
+			role = "user"
+			parts = append(parts, geminiFunctionResponse(toolNames[msg.ToolCallID], geminiText(msg.Content)))
+		case "assistant":
+			role = "model"
+			parts = geminiParts(msg.Content, toolNames)
+		default:
+			role = "user"
+			parts = geminiParts(msg.Content, toolNames)
+		}
+
+		for _, tc := range msg.ToolCalls {
+			toolNames[tc.ID] = tc.Function.Name
+			args := map[string]interface{}{}
+			if tc.Function.Arguments != "" {
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+					args = map[string]interface{}{}
+				}
+			}
+			parts = append(parts, map[string]interface{}{
+				"functionCall": map[string]interface{}{
+					"name": tc.Function.Name,
+					"args": args,
+				},
+			})
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		// Gemini 要求角色交替出现，相邻的同角色消息（如连续的工具结果）合并为一条
+		if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+			contents[n-1]["parts"] = append(contents[n-1]["parts"].([]interface{}), parts...)
+			continue
+		}
+		contents = append(contents, map[string]interface{}{
+			"role":  role,
+			"parts": parts,
+		})
+	}
+	req["contents"] = contents
+
+	if system != "" {
+		req["systemInstruction"] = map[string]interface{}{
+			"parts": []interface{}{map[string]interface{}{"text": system}},
+		}
+	}
+
+	generationConfig := map[string]interface{}{}
+	if unified.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = unified.MaxTokens
+	}
+	if unified.Temperature != nil {
+		generationConfig["temperature"] = *unified.Temperature
+	}
+	if unified.TopP != nil {
+		generationConfig["topP"] = *unified.TopP
+	}
+	// 思考预算为 0 时关闭思考，开启时要求返回思考摘要以便转换为其他格式输出
+	if reasoning := unified.Reasoning; reasoning != nil {
+		if reasoningDisabled(reasoning) {
+			generationConfig["thinkingConfig"] = map[string]interface{}{"thinkingBudget": 0}
+		} else if budget := reasoningBudget(reasoning); budget > 0 {
+			generationConfig["thinkingConfig"] = map[string]interface{}{"thinkingBudget": budget, "includeThoughts": true}
+		}
+	}
+	if len(unified.Stop) > 0 {
+		generationConfig["stopSequences"] = unified.Stop
+	}
+	if unified.FrequencyPenalty != nil {
+		generationConfig["frequencyPenalty"] = *unified.FrequencyPenalty
+	}
+	if unified.PresencePenalty != nil {
+		generationConfig["presencePenalty"] = *unified.PresencePenalty
+	}
+	if unified.Seed != nil {
+		generationConfig["seed"] = *unified.Seed
+	}
+	if unified.Logprobs != nil && *unified.Logprobs {
+		generationConfig["responseLogprobs"] = true
+		if unified.TopLogprobs != nil {
+			generationConfig["logprobs"] = *unified.TopLogprobs
+		}
+	}
+	if format := unified.ResponseFormat; format.structured() {
+		generationConfig["responseMimeType"] = "application/json"
+		if format.Type == ResponseFormatJSONSchema && format.Schema != nil {
+			generationConfig["responseSchema"] = cleanGeminiSchema(format.Schema)
+		}
+	}
+	if len(generationConfig) > 0 {
+		req["generationConfig"] = generationConfig
+	}
+
+	// 转换工具
+	if len(unified.Tools) > 0 {
+		declarations := []interface{}{}
+		for _, tool := range unified.Tools {
+			declaration := map[string]interface{}{
+				"name":        tool.Function.Name,
+				"description": tool.Function.Description,
+			}
+			if tool.Function.Parameters != nil {
+				declaration["parameters"] = cleanGeminiSchema(tool.Function.Parameters)
+			}
+			declarations = append(declarations, declaration)
+		}
+		req["tools"] = []interface{}{
+			map[string]interface{}{"functionDeclarations": declarations},
+		}
+		// Gemini 不支持关闭并行调用，只转换工具选择策略
+		if choice := unified.ToolChoice; choice != nil {
+			config := map[string]interface{}{}
+			switch choice.Type {
+			case ToolChoiceAuto:
+				config["mode"] = "AUTO"
+			case ToolChoiceNone:
+				config["mode"] = "NONE"
+			case ToolChoiceRequired:
+				config["mode"] = "ANY"
+			case ToolChoiceFunction:
+				config["mode"] = "ANY"
+				config["allowedFunctionNames"] = []string{choice.Name}
+			}
+			req["toolConfig"] = map[string]interface{}{"functionCallingConfig": config}
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+// geminiParts 将 OpenAI / Anthropic 的消息内容转换为 Gemini parts
+func geminiParts(content interface{}, toolNames map[string]string) []interface{} {
+	var parts []interface{}
+	switch v := content.(type) {
+	case string:
+		if v != "" {
+			parts = append(parts, map[string]interface{}{"text": v})
+		}
+	case []interface{}:
+		for _, item := range v {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch getString(itemMap, "type") {
+			case "text":
+				if text := getString(itemMap, "text"); text != "" {
+					parts = append(parts, map[string]interface{}{"text": text})
+				}
+			case "image_url":
+				imageURL := getString(itemMap, "image_url")
+				if imageMap, ok := itemMap["image_url"].(map[string]interface{}); ok {
+					imageURL = getString(imageMap, "url")
+				}
+				if part := geminiImagePart(imageURL, ""); part != nil {
+					parts = append(parts, part)
+				}
+			case "image":
+				source, ok := itemMap["source"].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if getString(source, "type") == "base64" {
+					parts = append(parts, map[string]interface{}{
+						"inlineData": map[string]interface{}{
+							"mimeType": getString(source, "media_type"),
+							"data":     getString(source, "data"),
+						},
+					})
+				} else if part := geminiImagePart(getString(source, "url"), getString(source, "media_type")); part != nil {
+					parts = append(parts, part)
+				}
+			case "tool_result":
+				id := getString(itemMap, "tool_use_id")
+				parts = append(parts, geminiFunctionResponse(toolNames[id], geminiText(itemMap["content"])))
+			}
+			// tool_use 已由 UnifiedMessage.ToolCalls 转换
+		}
+	}
+	return parts
+}
+
+// geminiImagePart data URL 转为 inlineData，其他 URL 转为 fileData
+func geminiImagePart(imageURL, mimeType string) map[string]interface{} {
+	if imageURL == "" {
+		return nil
+	}
+	if rest, ok := strings.CutPrefix(imageURL, "data:"); ok {
+		meta, data, found := strings.Cut(rest, ",")
+		if !found {
+			return nil
+		}
+		return map[string]interface{}{
+			"inlineData": map[string]interface{}{
+				"mimeType": strings.TrimSuffix(meta, ";base64"),
+				"data":     data,
+			},
+		}
+	}
+	fileData := map[string]interface{}{"fileUri": imageURL}
+	if mimeType != "" {
+		fileData["mimeType"] = mimeType
+	}
+	return map[string]interface{}{"fileData": fileData}
+}
+
+func geminiFunctionResponse(name, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"functionResponse": map[string]interface{}{
+			"name":     name,
+			"response": map[string]interface{}{"content": content},
+		},
+	}
+}
+
+// geminiText 提取字符串或内容块数组中的文本
+func geminiText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var texts []string
+		for _, item := range v {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if text := getString(itemMap, "text"); text != "" {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	default:
+		return ""
+	}
+}
+
+// cleanGeminiSchema 移除 Gemini 函数声明不接受的 JSON Schema 字段
+func cleanGeminiSchema(schema interface{}) interface{} {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		cleaned := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if key == "$schema" || key == "additionalProperties" {
+				continue
+			}
+			cleaned[key] = cleanGeminiSchema(value)
+		}
+		return cleaned
+	case []interface{}:
+		cleaned := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			cleaned = append(cleaned, cleanGeminiSchema(value))
+		}
+		return cleaned
+	default:
+		return v
+	}
+}
+
+// parseGeminiResponse 解析 generateContent 响应（流式响应的每个 chunk 结构相同）
+func parseGeminiResponse(body []byte) (*UnifiedResponse, error) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	unified := &UnifiedResponse{
+		ID:      getString(resp, "responseId"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   getString(resp, "modelVersion"),
+	}
+	if unified.ID == "" {
+		unified.ID = fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	}
+
+	var textContent, reasoning string
+	var toolCalls []UnifiedToolCall
+	var finishReason string
+	if candidates, ok := resp["candidates"].([]interface{}); ok && len(candidates) > 0 {
+		candidate, _ := candidates[0].(map[string]interface{})
+		if content, ok := candidate["content"].(map[string]interface{}); ok {
+			parts, _ := content["parts"].([]interface{})
+			for _, part := range parts {
+				partMap, ok := part.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if getBool(partMap, "thought") {
+					reasoning += getString(partMap, "text")
+					continue
+				}
+				textContent += getString(partMap, "text")
+				if call, ok := partMap["functionCall"].(map[string]interface{}); ok {
+					id := getString(call, "id")
+					if id == "" {
+						id = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), len(toolCalls))
+					}
+					args, _ := json.Marshal(call["args"])
+					if call["args"] == nil {
+						args = []byte("{}")
+					}
+					toolCalls = append(toolCalls, UnifiedToolCall{
+						ID:   id,
+						Type: "function",
+						Function: UnifiedToolCallFunction{
+							Name:      getString(call, "name"),
+							Arguments: string(args),
+						},
+					})
+				}
+			}
+		}
+		finishReason = geminiFinishReason(getString(candidate, "finishReason"), len(toolCalls) > 0)
+	}
+
+	unified.Choices = []UnifiedChoice{{
+		Index: 0,
+		Message: &UnifiedMessage{
+			Role:             "assistant",
+			Content:          textContent,
+			ToolCalls:        toolCalls,
+			ReasoningContent: reasoning,
+		},
+		FinishReason: finishReason,
+	}}
+
+	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
+		unified.Usage = &models.Usage{
+			PromptTokens:     int64(getFloat(usage, "promptTokenCount")),
+			CompletionTokens: int64(getFloat(usage, "candidatesTokenCount") + getFloat(usage, "thoughtsTokenCount")),
+			TotalTokens:      int64(getFloat(usage, "totalTokenCount")),
+			PromptTokensDetails: models.PromptTokensDetails{
+				CachedTokens: int64(getFloat(usage, "cachedContentTokenCount")),
+			},
+		}
+	}
+
+	return unified, nil
+}
+
+// geminiFinishReason 将 Gemini finishReason 映射为 OpenAI finish_reason
+func geminiFinishReason(reason string, hasToolCalls bool) string {
+	switch reason {
+	case "":
+		return ""
+	case "STOP":
+		if hasToolCalls {
+			return "tool_calls"
+		}
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// transformGeminiStreamRealtime 将 streamGenerateContent?alt=sse 的响应实时转换为 OpenAI 或 Anthropic 流式格式
+func transformGeminiStreamRealtime(response *http.Response, clientType string) (*http.Response, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+		defer response.Body.Close()
+
+		var w geminiStreamWriter
+		switch clientType {
+		case "anthropic":
+			w = &geminiAnthropicWriter{w: pw}
+		case "openai-res":
+			w = &geminiSinkWriter{sink: newStreamSink(pw, clientType)}
+		default:
+			w = &geminiOpenAIWriter{w: pw}
+		}
+
+		scanner := bufio.NewScanner(response.Body)
+		scanner.Buffer(make([]byte, 0, 8192), 1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "" {
+				continue
+			}
+
+			var chunk map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				continue
+			}
+			if errMap, ok := chunk["error"].(map[string]interface{}); ok {
+				w.writeError(errMap)
+				return
+			}
+
+			unified, err := parseGeminiResponse([]byte(data))
+			if err != nil {
+				continue
+			}
+			w.writeChunk(unified)
+		}
+		if err := scanner.Err(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		w.finish()
+	}()
+
+	newResponse := &http.Response{
+		Status:        response.Status,
+		StatusCode:    response.StatusCode,
+		Proto:         response.Proto,
+		ProtoMajor:    response.ProtoMajor,
+		ProtoMinor:    response.ProtoMinor,
+		Header:        response.Header.Clone(),
+		Body:          pr,
+		ContentLength: -1,
+	}
+
+	return newResponse, nil
+}
+
+// geminiStreamWriter 将解析后的 Gemini chunk 写为客户端格式的 SSE
+type geminiStreamWriter interface {
+	writeChunk(unified *UnifiedResponse)
+	writeError(errMap map[string]interface{})
+	finish()
+}
+
+// geminiOpenAIWriter 输出 chat.completion.chunk，usage 只在结束块中输出一次
+type geminiOpenAIWriter struct {
+	w         io.Writer
+	id        string
+	model     string
+	started   bool
+	toolIndex int
+	sawTools  bool
+	finished  bool
+}
+
+func (g *geminiOpenAIWriter) send(delta map[string]interface{}, finishReason interface{}, usage *models.Usage) {
+	chunk := map[string]interface{}{
+		"id":      g.id,
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   g.model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	if usage != nil {
+		chunk["usage"] = map[string]interface{}{
+			"prompt_tokens":     usage.PromptTokens,
+			"completion_tokens": usage.CompletionTokens,
+			"total_tokens":      usage.TotalTokens,
+			"prompt_tokens_details": map[string]interface{}{
+				"cached_tokens": usage.PromptTokensDetails.CachedTokens,
+			},
+		}
+	}
+	chunkData, _ := json.Marshal(chunk)
+	fmt.Fprintf(g.w, "data: %s\n\n", string(chunkData))
+}
+
+func (g *geminiOpenAIWriter) writeChunk(unified *UnifiedResponse) {
+	delta := map[string]interface{}{}
+	if !g.started {
+		g.started = true
+		g.id = unified.ID
+		g.model = unified.Model
+		delta["role"] = "assistant"
+	}
+	choice := unified.Choices[0]
+	if reasoning := choice.Message.ReasoningContent; reasoning != "" {
+		delta["reasoning_content"] = reasoning
+	}
+	if text, _ := choice.Message.Content.(string); text != "" {
+		delta["content"] = text
+	}
+	if len(choice.Message.ToolCalls) > 0 {
+		g.sawTools = true
+		toolCalls := []map[string]interface{}{}
+		for _, tc := range choice.Message.ToolCalls {
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"index": g.toolIndex,
+				"id":    tc.ID,
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      tc.Function.Name,
+					"arguments": tc.Function.Arguments,
+				},
+			})
+			g.toolIndex++
+		}
+		delta["tool_calls"] = toolCalls
+	}
+	if len(delta) > 0 {
+		g.send(delta, nil, nil)
+	}
+
+	if choice.FinishReason != "" && !g.finished {
+		g.finished = true
+		finishReason := choice.FinishReason
+		if g.sawTools && finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
+		g.send(map[string]interface{}{}, finishReason, unified.Usage)
+	}
+}
+
+func (g *geminiOpenAIWriter) writeError(errMap map[string]interface{}) {
+	errData, _ := json.Marshal(map[string]interface{}{"error": errMap})
+	fmt.Fprintf(g.w, "data: %s\n\n", string(errData))
+}
+
+func (g *geminiOpenAIWriter) finish() {
+	fmt.Fprintf(g.w, "data: [DONE]\n\n")
+}
+
+// geminiAnthropicWriter 输出 Anthropic messages 事件流，思考内容、文本与工具调用分别占用独立的 content block
+type geminiAnthropicWriter struct {
+	w          io.Writer
+	started    bool
+	blockIndex int
+	openType   string // 当前打开的 thinking 或 text 块类型，为空表示没有打开的块
+	sawTools   bool
+	stopped    bool
+}
+
+func (g *geminiAnthropicWriter) send(event string, data map[string]interface{}) {
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(g.w, "event: %s\ndata: %s\n\n", event, string(payload))
+}
+
+func (g *geminiAnthropicWriter) closeBlock() {
+	if g.openType == "" {
+		return
+	}
+	g.send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": g.blockIndex})
+	g.blockIndex++
+	g.openType = ""
+}
+
+// writeDelta 向 blockType 类型的块写入增量，当前打开的块类型不同时先关闭再新开
+func (g *geminiAnthropicWriter) writeDelta(blockType, field, text string) {
+	if g.openType != blockType {
+		g.closeBlock()
+		block := map[string]interface{}{"type": blockType, field: ""}
+		if blockType == "thinking" {
+			block = anthropicThinkingBlock("", "")
+		}
+		g.send("content_block_start", map[string]interface{}{
+			"type":          "content_block_start",
+			"index":         g.blockIndex,
+			"content_block": block,
+		})
+		g.openType = blockType
+	}
+	g.send("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": g.blockIndex,
+		"delta": map[string]interface{}{"type": field + "_delta", field: text},
+	})
+}
+
+func (g *geminiAnthropicWriter) writeChunk(unified *UnifiedResponse) {
+	if !g.started {
+		g.started = true
+		inputTokens := int64(0)
+		if unified.Usage != nil {
+			inputTokens = unified.Usage.PromptTokens
+		}
+		g.send("message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":      unified.ID,
+				"type":    "message",
+				"role":    "assistant",
+				"content": []interface{}{},
+				"model":   unified.Model,
+				"usage": map[string]interface{}{
+					"input_tokens":  inputTokens,
+					"output_tokens": 0,
+				},
+			},
+		})
+	}
+
+	choice := unified.Choices[0]
+	if reasoning := choice.Message.ReasoningContent; reasoning != "" {
+		g.writeDelta("thinking", "thinking", reasoning)
+	}
+	if text, _ := choice.Message.Content.(string); text != "" {
+		g.writeDelta("text", "text", text)
+	}
+
+	for _, tc := range choice.Message.ToolCalls {
+		g.closeBlock()
+		g.sawTools = true
+		g.send("content_block_start", map[string]interface{}{
+			"type":  "content_block_start",
+			"index": g.blockIndex,
+			"content_block": map[string]interface{}{
+				"type":  "tool_use",
+				"id":    tc.ID,
+				"name":  tc.Function.Name,
+				"input": map[string]interface{}{},
+			},
+		})
+		g.send("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": g.blockIndex,
+			"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": tc.Function.Arguments},
+		})
+		g.send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": g.blockIndex})
+		g.blockIndex++
+	}
+
+	if choice.FinishReason != "" && !g.stopped {
+		g.stopped = true
+		g.closeBlock()
+
+		stopReason := "end_turn"
+		switch {
+		case g.sawTools || choice.FinishReason == "tool_calls":
+			stopReason = "tool_use"
+		case choice.FinishReason == "length":
+			stopReason = "max_tokens"
+		case choice.FinishReason == "content_filter":
+			stopReason = "refusal"
+		}
+		messageDelta := map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": stopReason},
+		}
+		if unified.Usage != nil {
+			messageDelta["usage"] = formatAnthropicUsage(unified.Usage)
+		}
+		g.send("message_delta", messageDelta)
+	}
+}
+
+func (g *geminiAnthropicWriter) writeError(errMap map[string]interface{}) {
+	g.send("error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "api_error",
+			"message": getString(errMap, "message"),
+		},
+	})
+}
+
+func (g *geminiAnthropicWriter) finish() {
+	if !g.started {
+		return
+	}
+	g.closeBlock()
+	g.send("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+// geminiSinkWriter 将 Gemini chunk 转为归一化流式事件，用于输出 Responses 事件流
+type geminiSinkWriter struct {
+	sink      streamSink
+	started   bool
+	toolIndex int
+	sawTools  bool
+	finished  bool
+}
+
+func (g *geminiSinkWriter) writeChunk(unified *UnifiedResponse) {
+	if !g.started {
+		g.started = true
+		g.sink.start(unified.ID, unified.Model)
+	}
+	choice := unified.Choices[0]
+	if reasoning := choice.Message.ReasoningContent; reasoning != "" {
+		g.sink.reasoning(reasoning)
+	}
+	if text, _ := choice.Message.Content.(string); text != "" {
+		g.sink.text(text)
+	}
+	for _, tc := range choice.Message.ToolCalls {
+		g.sawTools = true
+		g.sink.toolCall(g.toolIndex, tc.ID, tc.Function.Name)
+		g.sink.toolArgs(g.toolIndex, tc.Function.Arguments)
+		g.toolIndex++
+	}
+	if choice.FinishReason != "" && !g.finished {
+		g.finished = true
+		finishReason := choice.FinishReason
+		if g.sawTools && finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
+		g.sink.finish(finishReason, unified.Usage)
+	}
+}
+
+func (g *geminiSinkWriter) writeError(errMap map[string]interface{}) {
+	g.finished = true
+	g.sink.fail(getString(errMap, "message"))
+}
+
+func (g *geminiSinkWriter) finish() {
+	if !g.finished {
+		g.finished = true
+		g.sink.finish("", nil)
+	}
+	g.sink.close()
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTransformUnifiedToGemini(t *testing.T) {
+	weatherTool := `"tools":[{"type":"function","function":{"name":"get_weather","description":"Weather","parameters":{"$schema":"http://json-schema.org/draft-07/schema#","type":"object","additionalProperties":false,"properties":{"city":{"type":"string"}}}}}]`
+	tests := []struct {
+		name   string
+		client string
+		body   string
+		want   map[string]string // gjson 路径 -> 期望的原始 JSON，空字符串表示字段不存在
+	}{
+		{
+			name: "openai messages", client: "openai",
+			body: `{"model":"gemini-2.5-flash","stream":true,"max_tokens":100,"temperature":0.5,"messages":[
+				{"role":"system","content":"Be brief"},
+				{"role":"user","content":"Hi"},
+				{"role":"assistant","content":"Hello"}
+			]}`,
+			want: map[string]string{
+				"model":             `"gemini-2.5-flash"`,
+				"stream":            `true`,
+				"systemInstruction": `{"parts":[{"text":"Be brief"}]}`,
+				"contents":          `[{"parts":[{"text":"Hi"}],"role":"user"},{"parts":[{"text":"Hello"}],"role":"model"}]`,
+				"generationConfig":  `{"maxOutputTokens":100,"temperature":0.5}`,
+			},
+		},
+		{
+			name: "openai tool calls and results", client: "openai",
+			body: `{"model":"m","messages":[
+				{"role":"user","content":"Weather?"},
+				{"role":"assistant","content":null,"tool_calls":[
+					{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+					{"id":"call_2","type":"function","function":{"name":"get_time","arguments":""}}
+				]},
+				{"role":"tool","tool_call_id":"call_1","content":"sunny"},
+				{"role":"tool","tool_call_id":"call_2","content":"noon"}
+			],` + weatherTool + `}`,
+			want: map[string]string{
+				"contents.#":       `3`,
+				"contents.1":       `{"parts":[{"functionCall":{"args":{"city":"Paris"},"name":"get_weather"}},{"functionCall":{"args":{},"name":"get_time"}}],"role":"model"}`,
+				"contents.2":       `{"parts":[{"functionResponse":{"name":"get_weather","response":{"content":"sunny"}}},{"functionResponse":{"name":"get_time","response":{"content":"noon"}}}],"role":"user"}`,
+				"tools":            `[{"functionDeclarations":[{"description":"Weather","name":"get_weather","parameters":{"properties":{"city":{"type":"string"}},"type":"object"}}]}]`,
+				"generationConfig": ``,
+			},
+		},
+		{
+			name: "anthropic tool_use and tool_result blocks", client: "anthropic",
+			body: `{"model":"m","max_tokens":10,"system":[{"type":"text","text":"Be brief"}],"messages":[
+				{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}}]},
+				{"role":"assistant","content":[{"type":"text","text":"Checking"},{"type":"tool_use","id":"toolu_1","name":"lookup","input":{"q":"x"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"found"}]}]}
+			]}`,
+			want: map[string]string{
+				"systemInstruction": `{"parts":[{"text":"Be brief"}]}`,
+				"contents.0.parts":  `[{"text":"What is this?"},{"inlineData":{"data":"AAAA","mimeType":"image/png"}}]`,
+				"contents.1.parts":  `[{"text":"Checking"},{"functionCall":{"args":{"q":"x"},"name":"lookup"}}]`,
+				"contents.2":        `{"parts":[{"functionResponse":{"name":"lookup","response":{"content":"found"}}}],"role":"user"}`,
+			},
+		},
+		{
+			name: "data url image", client: "openai",
+			body: `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"Look"},{"type":"image_url","image_url":{"url":"data:image/jpeg;base64,BBBB"}}]}]}`,
+			want: map[string]string{
+				"contents.0.parts": `[{"text":"Look"},{"inlineData":{"data":"BBBB","mimeType":"image/jpeg"}}]`,
+			},
+		},
+		{
+			name: "reasoning effort enables thoughts", client: "openai",
+			body: `{"model":"m","reasoning_effort":"low","messages":[{"role":"user","content":"Hi"}]}`,
+			want: map[string]string{"generationConfig.thinkingConfig": `{"includeThoughts":true,"thinkingBudget":2048}`},
+		},
+		{
+			name: "reasoning none disables thinking", client: "openai",
+			body: `{"model":"m","reasoning_effort":"none","messages":[{"role":"user","content":"Hi"}]}`,
+			want: map[string]string{"generationConfig.thinkingConfig": `{"thinkingBudget":0}`},
+		},
+		{
+			name: "json schema response format", client: "openai",
+			body: `{"model":"m","messages":[{"role":"user","content":"Hi"}],"response_format":{"type":"json_schema","json_schema":{"name":"w","schema":{"type":"object","additionalProperties":false,"properties":{"t":{"type":"number"}}}}}}`,
+			want: map[string]string{
+				"generationConfig.responseMimeType": `"application/json"`,
+				"generationConfig.responseSchema":   `{"properties":{"t":{"type":"number"}},"type":"object"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewTransformerManager(tt.client, "gemini").ProcessRequest(context.Background(), []byte(tt.body))
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(result, path).Raw; got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeminiResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		client string
+		body   string
+		want   map[string]string // gjson 路径 -> 期望的原始 JSON，空字符串表示字段不存在
+	}{
+		{
+			name: "text with thoughts and usage", client: "openai",
+			body: `{"responseId":"r1","modelVersion":"gemini-2.5-pro","candidates":[{"content":{"role":"model","parts":[{"text":"Think","thought":true},{"text":"Hel"},{"text":"lo"}]},"finishReason":"STOP"}],
+				"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":4,"thoughtsTokenCount":6,"totalTokenCount":20,"cachedContentTokenCount":3}}`,
+			want: map[string]string{
+				"id":                                  `"r1"`,
+				"model":                               `"gemini-2.5-pro"`,
+				"choices.0.message.content":           `"Hello"`,
+				"choices.0.message.reasoning_content": `"Think"`,
+				"choices.0.finish_reason":             `"stop"`,
+				"usage.prompt_tokens":                 `10`,
+				"usage.completion_tokens":             `10`,
+				"usage.total_tokens":                  `20`,
+				"usage.prompt_tokens_details.cached_tokens": `3`,
+			},
+		},
+		{
+			name: "function calls", client: "openai",
+			body: `{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"id":"fc_1","name":"get_weather","args":{"city":"Paris"}}},{"functionCall":{"name":"get_time"}}]},"finishReason":"STOP"}]}`,
+			want: map[string]string{
+				"choices.0.message.tool_calls.#":                    `2`,
+				"choices.0.message.tool_calls.0.id":                 `"fc_1"`,
+				"choices.0.message.tool_calls.0.function.name":      `"get_weather"`,
+				"choices.0.message.tool_calls.0.function.arguments": `"{\"city\":\"Paris\"}"`,
+				"choices.0.message.tool_calls.1.function.arguments": `"{}"`,
+				"choices.0.finish_reason":                           `"tool_calls"`,
+			},
+		},
+		{
+			name: "max tokens", client: "openai",
+			body: `{"candidates":[{"content":{"parts":[{"text":"cut"}]},"finishReason":"MAX_TOKENS"}]}`,
+			want: map[string]string{"choices.0.finish_reason": `"length"`},
+		},
+		{
+			name: "safety block", client: "openai",
+			body: `{"candidates":[{"finishReason":"SAFETY"}]}`,
+			want: map[string]string{"choices.0.finish_reason": `"content_filter"`, "choices.0.message.content": `""`},
+		},
+		{
+			name: "anthropic client", client: "anthropic",
+			body: `{"responseId":"r2","candidates":[{"content":{"parts":[{"text":"Think","thought":true},{"text":"Checking"},{"functionCall":{"id":"fc_1","name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],
+				"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":2,"totalTokenCount":7}}`,
+			want: map[string]string{
+				"content.0":          `{"signature":"","thinking":"Think","type":"thinking"}`,
+				"content.1":          `{"text":"Checking","type":"text"}`,
+				"content.2":          `{"id":"fc_1","input":{"city":"Paris"},"name":"get_weather","type":"tool_use"}`,
+				"stop_reason":        `"tool_use"`,
+				"usage.input_tokens": `5`,
+			},
+		},
+		{
+			name: "responses client", client: "openai-res",
+			body: `{"candidates":[{"content":{"parts":[{"functionCall":{"id":"fc_1","name":"get_weather","args":{}}}]},"finishReason":"STOP"}]}`,
+			want: map[string]string{
+				`output.#(type=="function_call").call_id`: `"fc_1"`,
+				`output.#(type=="function_call").name`:    `"get_weather"`,
+				"status":                                  `"completed"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewTransformerManager(tt.client, "gemini").ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(tt.body))})
+			if err != nil {
+				t.Fatalf("ProcessResponse failed: %v", err)
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(body, path).Raw; got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+
+	// 无法解析的响应体返回错误，由调用方按转换失败处理
+	_, err := NewTransformerManager("openai", "gemini").ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(`<html>bad gateway</html>`))})
+	if err == nil {
+		t.Error("Expected invalid body to fail")
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	// Gemini SSE 使用 \r\n 分隔
+	sse := func(chunks ...string) string {
+		var b strings.Builder
+		for _, chunk := range chunks {
+			b.WriteString("data: " + chunk + "\r\n\r\n")
+		}
+		return b.String()
+	}
+	reply := sse(
+		`{"responseId":"r1","modelVersion":"gemini-2.5-pro","candidates":[{"content":{"role":"model","parts":[{"text":"Think","thought":true}]}}],"usageMetadata":{"promptTokenCount":8}}`,
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"text":"Checking"}]}}]}`,
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"functionCall":{"id":"fc_1","name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":5,"totalTokenCount":13}}`,
+	)
+	failure := sse(
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"text":"Par"}]}}]}`,
+		`{"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}`,
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"text":"never sent"}]}}]}`,
+	)
+
+	tests := []struct {
+		name   string
+		client string
+		stream string
+		check  func(t *testing.T, events []gjson.Result, raw string)
+	}{
+		{
+			name: "openai chunks", client: "openai", stream: reply,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				var reasoning, content, finish string
+				var calls []gjson.Result
+				for _, event := range events {
+					delta := event.Get("choices.0.delta")
+					reasoning += delta.Get("reasoning_content").String()
+					content += delta.Get("content").String()
+					calls = append(calls, delta.Get("tool_calls").Array()...)
+					if reason := event.Get("choices.0.finish_reason").String(); reason != "" {
+						if finish != "" {
+							t.Errorf("Expected a single finish chunk, got %s", raw)
+						}
+						finish = reason
+						if event.Get("usage.total_tokens").Int() != 13 {
+							t.Errorf("Expected usage on finish chunk, got %s", event.Raw)
+						}
+					}
+				}
+				if events[0].Get("id").String() != "r1" || events[0].Get("choices.0.delta.role").String() != "assistant" {
+					t.Errorf("Expected first chunk to carry id and role, got %s", events[0].Raw)
+				}
+				if reasoning != "Think" || content != "Checking" || finish != "tool_calls" {
+					t.Errorf("reasoning = %q, content = %q, finish_reason = %q", reasoning, content, finish)
+				}
+				if len(calls) != 1 || calls[0].Get("index").Int() != 0 || calls[0].Get("id").String() != "fc_1" ||
+					calls[0].Get("function.name").String() != "get_weather" || calls[0].Get("function.arguments").String() != `{"city":"Paris"}` {
+					t.Errorf("tool calls = %v", calls)
+				}
+				if !strings.HasSuffix(raw, "data: [DONE]\n\n") {
+					t.Errorf("Expected [DONE] terminator, got %s", raw)
+				}
+			},
+		},
+		{
+			name: "anthropic events", client: "anthropic", stream: reply,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				var types []string
+				for _, event := range events {
+					types = append(types, event.Get("type").String())
+				}
+				want := "message_start," +
+					"content_block_start,content_block_delta,content_block_stop," +
+					"content_block_start,content_block_delta,content_block_stop," +
+					"content_block_start,content_block_delta,content_block_stop," +
+					"message_delta,message_stop"
+				if strings.Join(types, ",") != want {
+					t.Fatalf("event types = %v, want %s", types, want)
+				}
+				if usage := events[0].Get("message.usage.input_tokens").Int(); usage != 8 {
+					t.Errorf("Expected input tokens on message_start, got %d", usage)
+				}
+				if block := events[1].Get("content_block"); block.Raw != `{"signature":"","thinking":"","type":"thinking"}` {
+					t.Errorf("thinking block = %s", block.Raw)
+				}
+				if delta := events[2].Get("delta"); delta.Get("type").String() != "thinking_delta" || delta.Get("thinking").String() != "Think" {
+					t.Errorf("thinking delta = %s", delta.Raw)
+				}
+				if delta := events[5].Get("delta"); events[5].Get("index").Int() != 1 || delta.Get("text").String() != "Checking" {
+					t.Errorf("text delta = %s", events[5].Raw)
+				}
+				if block := events[7].Get("content_block"); events[7].Get("index").Int() != 2 || block.Get("type").String() != "tool_use" ||
+					block.Get("id").String() != "fc_1" || block.Get("name").String() != "get_weather" {
+					t.Errorf("tool_use block = %s", events[7].Raw)
+				}
+				if delta := events[8].Get("delta"); delta.Get("type").String() != "input_json_delta" || delta.Get("partial_json").String() != `{"city":"Paris"}` {
+					t.Errorf("input_json_delta = %s", delta.Raw)
+				}
+				if events[10].Get("delta.stop_reason").String() != "tool_use" || events[10].Get("usage.output_tokens").Int() != 5 {
+					t.Errorf("message_delta = %s", events[10].Raw)
+				}
+			},
+		},
+		{
+			name: "responses events", client: "openai-res", stream: reply,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				last := events[len(events)-1]
+				if last.Get("type").String() != "response.completed" {
+					t.Fatalf("Expected response.completed last, got %s", last.Raw)
+				}
+				output := last.Get("response.output")
+				if types := output.Get("#.type").Raw; types != `["reasoning","message","function_call"]` {
+					t.Errorf("output types = %s", types)
+				}
+				if call := output.Get(`#(type=="function_call")`); call.Get("call_id").String() != "fc_1" || call.Get("arguments").String() != `{"city":"Paris"}` {
+					t.Errorf("function_call = %s", call.Raw)
+				}
+				if last.Get("response.usage.total_tokens").Int() != 13 {
+					t.Errorf("usage = %s", last.Get("response.usage").Raw)
+				}
+			},
+		},
+		{
+			name: "openai error", client: "openai", stream: failure,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				last := events[len(events)-1]
+				if last.Get("error.message").String() != "The model is overloaded." || strings.Contains(raw, "never sent") || strings.Contains(raw, "[DONE]") {
+					t.Errorf("Expected stream to end with the upstream error, got %s", raw)
+				}
+			},
+		},
+		{
+			name: "anthropic error", client: "anthropic", stream: failure,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				last := events[len(events)-1]
+				if last.Get("type").String() != "error" || last.Get("error.message").String() != "The model is overloaded." ||
+					strings.Contains(raw, "message_stop") || !strings.Contains(raw, "event: error\n") {
+					t.Errorf("Expected error event without message_stop, got %s", raw)
+				}
+			},
+		},
+		{
+			name: "responses error", client: "openai-res", stream: failure,
+			check: func(t *testing.T, events []gjson.Result, raw string) {
+				last := events[len(events)-1]
+				if last.Get("type").String() != "response.failed" || last.Get("response.error.message").String() != "The model is overloaded." ||
+					strings.Contains(raw, "response.completed") {
+					t.Errorf("Expected response.failed, got %s", raw)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewTransformerManager(tt.client, "gemini").ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/event-stream"}}, Body: io.NopCloser(strings.NewReader(tt.stream))})
+			if err != nil {
+				t.Fatalf("ProcessResponse failed: %v", err)
+			}
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			var events []gjson.Result
+			for _, line := range strings.Split(string(body), "\n") {
+				if payload, ok := strings.CutPrefix(line, "data: "); ok && payload != "[DONE]" {
+					events = append(events, gjson.Parse(payload))
+				}
+			}
+			if len(events) == 0 {
+				t.Fatalf("Expected events, got %q", body)
+			}
+			tt.check(t, events, string(body))
+		})
+	}
+}
@@ -1,13 +1,10 @@
 package service
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/atopos31/llmio/models"
 )
@@ -33,6 +30,9 @@ func TransformOpenAIToUnified(rawBody []byte) (*UnifiedRequest, error) {
 	if topP, ok := req["top_p"].(float64); ok {
 		unified.TopP = &topP
 	}
+	if effort := getString(req, "reasoning_effort"); effort != "" {
+		unified.Reasoning = &UnifiedReasoning{Effort: effort}
+	}
 
 	// 转换消息
 	if messages, ok := req["messages"].([]interface{}); ok {
@@ -55,20 +55,26 @@ func TransformOpenAIToUnified(rawBody []byte) (*UnifiedRequest, error) {
 
 			// 只在有其他消息时才提取 system 消息
 			if role == "system" && extractSystem {
-				if content, ok := msgMap["content"].(string); ok && content != "" {
+				content, cacheControl := openAISystemContent(msgMap["content"])
+				if content != "" {
 					if unified.System != "" {
 						unified.System += "\n\n" + content
 					} else {
 						unified.System = content
 					}
 				}
+				if cacheControl != nil {
+					unified.SystemCacheControl = cacheControl
+				}
 				continue // 不将 system 消息添加到 messages 数组
 			}
 
 			msg := UnifiedMessage{
-				Role:      role,
-				Content:   msgMap["content"],
-				ToolCalls: parseOpenAIToolCalls(msgMap),
+				Role:               role,
+				Content:            msgMap["content"],
+				ToolCalls:          parseOpenAIToolCalls(msgMap),
+				ReasoningContent:   openAIReasoningContent(msgMap),
+				ReasoningSignature: getString(msgMap, "reasoning_signature"), // 客户端回传的 Anthropic 思考块签名
 			}
 			
 			// 处理 tool 角色消息的 tool_call_id
@@ -94,14 +100,126 @@ func TransformOpenAIToUnified(rawBody []byte) (*UnifiedRequest, error) {
 						Description: getString(funcMap, "description"),
 						Parameters:  funcMap["parameters"],
 					},
+					CacheControl: toolMap["cache_control"],
 				})
 			}
 		}
 	}
+	unified.ToolChoice = parseOpenAIToolChoice(req["tool_choice"])
+	if parallel, ok := req["parallel_tool_calls"].(bool); ok {
+		unified.ParallelToolCalls = &parallel
+	}
+	unified.ResponseFormat = parseOpenAIResponseFormat(req["response_format"])
+	parseOpenAISampling(req, unified)
 
 	return unified, nil
 }
 
+// openAISystemContent 提取 system 消息文本，内容块数组按换行拼接，并返回其中的 cache_control
+func openAISystemContent(content interface{}) (string, interface{}) {
+	switch v := content.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		var texts []string
+		var cacheControl interface{}
+		for _, part := range v {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text := getString(partMap, "text"); text != "" {
+				texts = append(texts, text)
+			}
+			if partMap["cache_control"] != nil {
+				cacheControl = partMap["cache_control"]
+			}
+		}
+		return strings.Join(texts, "\n"), cacheControl
+	}
+	return "", nil
+}
+
+// parseOpenAISampling 解析 OpenAI 的停止序列、惩罚系数、随机种子、logprobs 与 user 参数
+func parseOpenAISampling(req map[string]interface{}, unified *UnifiedRequest) {
+	unified.Stop = parseStopSequences(req["stop"])
+	if penalty, ok := req["frequency_penalty"].(float64); ok {
+		unified.FrequencyPenalty = &penalty
+	}
+	if penalty, ok := req["presence_penalty"].(float64); ok {
+		unified.PresencePenalty = &penalty
+	}
+	if seed, ok := req["seed"].(float64); ok {
+		value := int64(seed)
+		unified.Seed = &value
+	}
+	if logprobs, ok := req["logprobs"].(bool); ok {
+		unified.Logprobs = &logprobs
+	}
+	if topLogprobs, ok := req["top_logprobs"].(float64); ok {
+		value := int(topLogprobs)
+		unified.TopLogprobs = &value
+	}
+	unified.User = getString(req, "user")
+}
+
+// parseStopSequences 解析停止序列，兼容单个字符串与字符串数组
+func parseStopSequences(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var stop []string
+		for _, item := range v {
+			if text, ok := item.(string); ok && text != "" {
+				stop = append(stop, text)
+			}
+		}
+		return stop
+	}
+	return nil
+}
+
+// openAIMaxStop OpenAI 最多接受的停止序列个数
+const openAIMaxStop = 4
+
+// parseOpenAIToolChoice 解析 OpenAI chat 与 Responses 的 tool_choice：
+// 字符串 auto / none / required，或指定函数的对象（chat 为 function.name，Responses 为 name）
+func parseOpenAIToolChoice(value interface{}) *UnifiedToolChoice {
+	switch choice := value.(type) {
+	case string:
+		switch choice {
+		case ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+			return &UnifiedToolChoice{Type: choice}
+		}
+	case map[string]interface{}:
+		if getString(choice, "type") != "function" {
+			return nil
+		}
+		name := getString(choice, "name")
+		if funcMap, ok := choice["function"].(map[string]interface{}); ok {
+			name = getString(funcMap, "name")
+		}
+		if name != "" {
+			return &UnifiedToolChoice{Type: ToolChoiceFunction, Name: name}
+		}
+	}
+	return nil
+}
+
+// formatOpenAIToolChoice 生成 OpenAI chat 格式的 tool_choice
+func formatOpenAIToolChoice(choice *UnifiedToolChoice) interface{} {
+	if choice.Type == ToolChoiceFunction {
+		return map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": choice.Name},
+		}
+	}
+	return choice.Type
+}
+
 // TransformUnifiedToOpenAI 将统一格式转换为 OpenAI 格式
 func TransformUnifiedToOpenAI(unified *UnifiedRequest) ([]byte, error) {
 	req := map[string]interface{}{
@@ -119,6 +237,10 @@ func TransformUnifiedToOpenAI(unified *UnifiedRequest) ([]byte, error) {
 	if unified.TopP != nil {
 		req["top_p"] = *unified.TopP
 	}
+	// 关闭思考在 OpenAI 兼容接口中没有通用写法，沿用上游默认
+	if reasoning := unified.Reasoning; reasoning != nil && !reasoningDisabled(reasoning) {
+		req["reasoning_effort"] = reasoningEffort(reasoning)
+	}
 
 	// 转换消息
 	messages := []interface{}{}
@@ -131,12 +253,19 @@ func TransformUnifiedToOpenAI(unified *UnifiedRequest) ([]byte, error) {
 		})
 	}
 
+	// 历史回复中的思考内容不回传，DeepSeek 等上游会拒绝带有 reasoning_content 的请求
 	for _, msg := range unified.Messages {
+		// Anthropic 的 tool_result 块拆分为 tool 消息，只有工具结果的用户消息不再保留
+		content, toolMessages := openAIContent(msg.Role, msg.Content)
+		messages = append(messages, toolMessages...)
+		if len(toolMessages) > 0 && content == nil {
+			continue
+		}
 		msgMap := map[string]interface{}{
 			"role": msg.Role,
 		}
-		if msg.Content != nil {
-			msgMap["content"] = msg.Content
+		if content != nil {
+			msgMap["content"] = content
 		}
 		if len(msg.ToolCalls) > 0 {
 			toolCalls := []interface{}{}
@@ -174,6 +303,38 @@ func TransformUnifiedToOpenAI(unified *UnifiedRequest) ([]byte, error) {
 			})
 		}
 		req["tools"] = tools
+		if unified.ParallelToolCalls != nil {
+			req["parallel_tool_calls"] = *unified.ParallelToolCalls
+		}
+		if unified.ToolChoice != nil {
+			req["tool_choice"] = formatOpenAIToolChoice(unified.ToolChoice)
+		}
+	}
+
+	if unified.ResponseFormat != nil {
+		req["response_format"] = formatOpenAIResponseFormat(unified.ResponseFormat)
+	}
+	// Anthropic 允许更多停止序列，超出 OpenAI 上限的部分丢弃
+	if len(unified.Stop) > 0 {
+		req["stop"] = unified.Stop[:min(len(unified.Stop), openAIMaxStop)]
+	}
+	if unified.FrequencyPenalty != nil {
+		req["frequency_penalty"] = *unified.FrequencyPenalty
+	}
+	if unified.PresencePenalty != nil {
+		req["presence_penalty"] = *unified.PresencePenalty
+	}
+	if unified.Seed != nil {
+		req["seed"] = *unified.Seed
+	}
+	if unified.Logprobs != nil {
+		req["logprobs"] = *unified.Logprobs
+	}
+	if unified.TopLogprobs != nil {
+		req["top_logprobs"] = *unified.TopLogprobs
+	}
+	if unified.User != "" {
+		req["user"] = unified.User
 	}
 
 	if unified.Stream {
@@ -194,8 +355,11 @@ func TransformProviderResponse(response *http.Response, providerType, clientType
 	isStream := strings.Contains(contentType, "text/event-stream")
 
 	if isStream {
+		if providerType == "gemini" {
+			return transformGeminiStreamRealtime(response, clientType)
+		}
 		// 流式响应：直接从 Body 读取器进行实时转换
-		return transformStreamResponseRealtime(response, providerType, clientType)
+		return transformStreamRealtime(response, providerType, clientType)
 	}
 
 	// 非流式响应：读取完整响应体后转换
@@ -218,6 +382,10 @@ func transformNonStreamResponse(response *http.Response, body []byte, providerTy
 		unified, err = parseOpenAIResponse(body)
 	case "anthropic":
 		unified, err = parseAnthropicResponse(body)
+	case "gemini":
+		unified, err = parseGeminiResponse(body)
+	case "openai-res":
+		unified, err = parseOpenAIResResponse(body)
 	default:
 		unified, err = parseOpenAIResponse(body)
 	}
@@ -233,6 +401,8 @@ func transformNonStreamResponse(response *http.Response, body []byte, providerTy
 		newBody, err = formatOpenAIResponse(unified)
 	case "anthropic":
 		newBody, err = formatAnthropicResponse(unified)
+	case "openai-res":
+		newBody, err = formatOpenAIResResponse(unified)
 	default:
 		newBody, err = formatOpenAIResponse(unified)
 	}
@@ -256,598 +426,6 @@ func transformNonStreamResponse(response *http.Response, body []byte, providerTy
 	return newResponse, nil
 }
 
-// transformStreamResponseRealtime 实时流式响应转换（直接从 Body 读取器转换）
-func transformStreamResponseRealtime(response *http.Response, providerType, clientType string) (*http.Response, error) {
-	pr, pw := io.Pipe()
-
-	go func() {
-		defer pw.Close()
-		defer response.Body.Close()
-
-		scanner := bufio.NewScanner(response.Body)
-		scanner.Buffer(make([]byte, 0, 8192), 1024*1024)
-
-		var currentEvent string
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				// 空行是 SSE 消息分隔符
-				currentEvent = "" // 重置事件类型
-				continue
-			}
-
-			// 处理 event 行（记录事件类型）- 兼容带空格和不带空格两种格式
-			if strings.HasPrefix(line, "event:") {
-				currentEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-				continue
-			}
-
-			// 处理 data 行 - 兼容带空格和不带空格两种格式
-			if !strings.HasPrefix(line, "data:") {
-				continue
-			}
-
-			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-			if data == "" {
-				continue
-			}
-
-			// Anthropic → OpenAI 转换
-			if providerType == "anthropic" && clientType == "openai" {
-				if data == "[DONE]" {
-					fmt.Fprintf(pw, "data: [DONE]\n\n")
-					continue
-				}
-
-				var chunk map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-					continue
-				}
-
-				// 优先使用 event 行的事件类型,如果没有则从 JSON 中获取
-				eventType := currentEvent
-				if eventType == "" {
-					eventType = getString(chunk, "type")
-				}
-
-				switch eventType {
-				case "message_start", "ping":
-					// 忽略这些事件
-					continue
-
-				case "content_block_start":
-					// 处理工具调用开始
-					if contentBlock, ok := chunk["content_block"].(map[string]interface{}); ok {
-						blockType := getString(contentBlock, "type")
-						if blockType == "tool_use" {
-							// 工具调用开始，发送角色信息
-							openaiChunk := map[string]interface{}{
-								"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-								"object":  "chat.completion.chunk",
-								"created": time.Now().Unix(),
-								"model":   "claude",
-								"choices": []map[string]interface{}{
-									{
-										"index": 0,
-										"delta": map[string]interface{}{
-											"role": "assistant",
-											"tool_calls": []map[string]interface{}{
-												{
-													"index": getFloat(chunk, "index"),
-													"id":    getString(contentBlock, "id"),
-													"type":  "function",
-													"function": map[string]interface{}{
-														"name":      getString(contentBlock, "name"),
-														"arguments": "",
-													},
-												},
-											},
-										},
-										"finish_reason": nil,
-									},
-								},
-							}
-							chunkData, _ := json.Marshal(openaiChunk)
-							fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-						}
-					}
-					continue
-
-				case "content_block_delta":
-					// 提取文本内容或工具调用参数并转换为 OpenAI 格式
-					if delta, ok := chunk["delta"].(map[string]interface{}); ok {
-						deltaType := getString(delta, "type")
-
-						if deltaType == "text_delta" {
-							if text := getString(delta, "text"); text != "" {
-								openaiChunk := map[string]interface{}{
-									"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-									"object":  "chat.completion.chunk",
-									"created": time.Now().Unix(),
-									"model":   "claude",
-									"choices": []map[string]interface{}{
-										{
-											"index": 0,
-											"delta": map[string]interface{}{
-												"content": text,
-											},
-											"finish_reason": nil,
-										},
-									},
-								}
-								chunkData, _ := json.Marshal(openaiChunk)
-								fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-							}
-						} else if deltaType == "input_json_delta" {
-							// 工具调用参数增量
-							if partialJson := getString(delta, "partial_json"); partialJson != "" {
-								openaiChunk := map[string]interface{}{
-									"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-									"object":  "chat.completion.chunk",
-									"created": time.Now().Unix(),
-									"model":   "claude",
-									"choices": []map[string]interface{}{
-										{
-											"index": 0,
-											"delta": map[string]interface{}{
-												"tool_calls": []map[string]interface{}{
-													{
-														"index": getFloat(chunk, "index"),
-														"function": map[string]interface{}{
-															"arguments": partialJson,
-														},
-													},
-												},
-											},
-											"finish_reason": nil,
-										},
-									},
-								}
-								chunkData, _ := json.Marshal(openaiChunk)
-								fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-							}
-						}
-					}
-
-				case "content_block_stop":
-					// 忽略内容块停止事件
-					continue
-
-				case "message_delta":
-					// 发送结束块
-					stopReason := "stop"
-					if delta, ok := chunk["delta"].(map[string]interface{}); ok {
-						if reason := getString(delta, "stop_reason"); reason != "" {
-							if reason == "end_turn" {
-								stopReason = "stop"
-							} else if reason == "tool_use" {
-								stopReason = "tool_calls"
-							}
-						}
-					}
-
-					finalChunk := map[string]interface{}{
-						"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-						"object":  "chat.completion.chunk",
-						"created": time.Now().Unix(),
-						"model":   "claude",
-						"choices": []map[string]interface{}{
-							{
-								"index":         0,
-								"delta":         map[string]interface{}{},
-								"finish_reason": stopReason,
-							},
-						},
-					}
-
-					// 添加 usage 信息
-					if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-						finalChunk["usage"] = map[string]interface{}{
-							"prompt_tokens":     int(getFloat(usage, "input_tokens")),
-							"completion_tokens": int(getFloat(usage, "output_tokens")),
-							"total_tokens":      int(getFloat(usage, "input_tokens") + getFloat(usage, "output_tokens")),
-						}
-					}
-
-					chunkData, _ := json.Marshal(finalChunk)
-					fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-
-				case "message_stop":
-					// 发送 [DONE]
-					fmt.Fprintf(pw, "data: [DONE]\n\n")
-				}
-			} else if providerType == "openai" && clientType == "anthropic" {
-				// OpenAI → Anthropic 转换
-				if data == "[DONE]" {
-					messageStop := map[string]interface{}{"type": "message_stop"}
-					stopData, _ := json.Marshal(messageStop)
-					fmt.Fprintf(pw, "event: message_stop\ndata: %s\n\n", string(stopData))
-					continue
-				}
-
-				var chunk map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-					continue
-				}
-
-				if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-					choice := choices[0].(map[string]interface{})
-
-					if delta, ok := choice["delta"].(map[string]interface{}); ok {
-						// 处理角色信息（第一个chunk）
-						if role := getString(delta, "role"); role != "" {
-							messageStart := map[string]interface{}{
-								"type": "message_start",
-								"message": map[string]interface{}{
-									"id":      getString(chunk, "id"),
-									"type":    "message",
-									"role":    "assistant",
-									"content": []interface{}{},
-									"model":   getString(chunk, "model"),
-									"usage": map[string]interface{}{
-										"input_tokens":  0,
-										"output_tokens": 0,
-									},
-								},
-							}
-							startData, _ := json.Marshal(messageStart)
-							fmt.Fprintf(pw, "event: message_start\ndata: %s\n\n", string(startData))
-
-							blockStart := map[string]interface{}{
-								"type":  "content_block_start",
-								"index": 0,
-								"content_block": map[string]interface{}{
-									"type": "text",
-									"text": "",
-								},
-							}
-							blockData, _ := json.Marshal(blockStart)
-							fmt.Fprintf(pw, "event: content_block_start\ndata: %s\n\n", string(blockData))
-						}
-
-						// 处理内容
-						if content := getString(delta, "content"); content != "" {
-							contentDelta := map[string]interface{}{
-								"type":  "content_block_delta",
-								"index": 0,
-								"delta": map[string]interface{}{
-									"type": "text_delta",
-									"text": content,
-								},
-							}
-							contentDeltaData, _ := json.Marshal(contentDelta)
-							fmt.Fprintf(pw, "event: content_block_delta\ndata: %s\n\n", string(contentDeltaData))
-						}
-
-						// 处理结束
-						if finishReason := getString(choice, "finish_reason"); finishReason != "" {
-							blockStop := map[string]interface{}{
-								"type":  "content_block_stop",
-								"index": 0,
-							}
-							stopData, _ := json.Marshal(blockStop)
-							fmt.Fprintf(pw, "event: content_block_stop\ndata: %s\n\n", string(stopData))
-
-							stopReason := "end_turn"
-							if finishReason == "tool_calls" {
-								stopReason = "tool_use"
-							} else if finishReason == "length" {
-								stopReason = "max_tokens"
-							}
-
-							messageDelta := map[string]interface{}{
-								"type": "message_delta",
-								"delta": map[string]interface{}{
-									"stop_reason": stopReason,
-								},
-							}
-
-							if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-								messageDelta["usage"] = map[string]interface{}{
-									"input_tokens":  int(getFloat(usage, "prompt_tokens")),
-									"output_tokens": int(getFloat(usage, "completion_tokens")),
-								}
-							}
-
-							messageDeltaData, _ := json.Marshal(messageDelta)
-							fmt.Fprintf(pw, "event: message_delta\ndata: %s\n\n", string(messageDeltaData))
-
-							messageStop := map[string]interface{}{"type": "message_stop"}
-							stopMsgData, _ := json.Marshal(messageStop)
-							fmt.Fprintf(pw, "event: message_stop\ndata: %s\n\n", string(stopMsgData))
-						}
-					}
-				}
-			} else {
-				// 其他场景：直接透传
-				fmt.Fprintf(pw, "data: %s\n\n", data)
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			pw.CloseWithError(err)
-		}
-	}()
-
-	newResponse := &http.Response{
-		Status:        response.Status,
-		StatusCode:    response.StatusCode,
-		Proto:         response.Proto,
-		ProtoMajor:    response.ProtoMajor,
-		ProtoMinor:    response.ProtoMinor,
-		Header:        response.Header.Clone(),
-		Body:          pr,
-		ContentLength: -1,
-	}
-
-	return newResponse, nil
-}
-
-// transformStreamResponse 流式响应转换（从完整 body 转换，用于兼容旧代码）
-func transformStreamResponse(response *http.Response, body []byte, providerType, clientType string) (*http.Response, error) {
-	pr, pw := io.Pipe()
-
-	go func() {
-		defer pw.Close()
-
-		// 如果格式相同，逐行透传以保持流式特性
-		if providerType == clientType {
-			lines := strings.Split(string(body), "\n")
-			for _, line := range lines {
-				if line != "" {
-					fmt.Fprintf(pw, "%s\n", line)
-				}
-			}
-			return
-		}
-
-		scanner := strings.Split(string(body), "\n")
-		var textBuffer strings.Builder
-
-		for _, line := range scanner {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-
-			// 处理 SSE 格式
-			if !strings.HasPrefix(line, "data: ") {
-				if strings.HasPrefix(line, "event:") {
-					continue
-				}
-				continue
-			}
-
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "" || data == "[DONE]" {
-				// 发送最终的 [DONE]
-				fmt.Fprintf(pw, "data: [DONE]\n\n")
-				continue
-			}
-
-			// 解析 JSON 数据
-			var chunk map[string]interface{}
-			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-				continue
-			}
-
-			// Anthropic → OpenAI 转换
-			if providerType == "anthropic" && clientType == "openai" {
-				eventType := getString(chunk, "type")
-
-				switch eventType {
-				case "message_start":
-					// 忽略 message_start
-					continue
-
-				case "content_block_start":
-					// 忽略 content_block_start
-					continue
-
-				case "ping":
-					// 忽略 ping
-					continue
-
-				case "content_block_delta":
-					// 提取文本内容
-					if delta, ok := chunk["delta"].(map[string]interface{}); ok {
-						if text := getString(delta, "text"); text != "" {
-							textBuffer.WriteString(text)
-
-							// 构造 OpenAI 流式响应
-							openaiChunk := map[string]interface{}{
-								"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-								"object":  "chat.completion.chunk",
-								"created": time.Now().Unix(),
-								"model":   "claude",
-								"choices": []map[string]interface{}{
-									{
-										"index": 0,
-										"delta": map[string]interface{}{
-											"content": text,
-										},
-										"finish_reason": nil,
-									},
-								},
-							}
-
-							chunkData, _ := json.Marshal(openaiChunk)
-							fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-						}
-					}
-
-				case "content_block_stop":
-					// 忽略 content_block_stop
-					continue
-
-				case "message_delta":
-					// 发送结束块
-					stopReason := "stop"
-					if delta, ok := chunk["delta"].(map[string]interface{}); ok {
-						if reason := getString(delta, "stop_reason"); reason != "" {
-							if reason == "end_turn" {
-								stopReason = "stop"
-							} else if reason == "tool_use" {
-								stopReason = "tool_calls"
-							}
-						}
-					}
-
-					// 构造最终的 OpenAI 响应
-					finalChunk := map[string]interface{}{
-						"id":      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
-						"object":  "chat.completion.chunk",
-						"created": time.Now().Unix(),
-						"model":   "claude",
-						"choices": []map[string]interface{}{
-							{
-								"index":         0,
-								"delta":         map[string]interface{}{},
-								"finish_reason": stopReason,
-							},
-						},
-					}
-
-					// 添加 usage 信息
-					if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-						finalChunk["usage"] = map[string]interface{}{
-							"prompt_tokens":     int(getFloat(usage, "input_tokens")),
-							"completion_tokens": int(getFloat(usage, "output_tokens")),
-							"total_tokens":      int(getFloat(usage, "input_tokens") + getFloat(usage, "output_tokens")),
-						}
-					}
-
-					chunkData, _ := json.Marshal(finalChunk)
-					fmt.Fprintf(pw, "data: %s\n\n", string(chunkData))
-
-				case "message_stop":
-					// 发送 [DONE]
-					fmt.Fprintf(pw, "data: [DONE]\n\n")
-				}
-			} else if providerType == "openai" && clientType == "anthropic" {
-				// OpenAI → Anthropic 转换
-				if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
-					choice := choices[0].(map[string]interface{})
-
-					// 检查是否有 delta
-					if delta, ok := choice["delta"].(map[string]interface{}); ok {
-						// 处理角色信息（第一个chunk）
-						if role := getString(delta, "role"); role != "" {
-							// 发送 message_start 事件
-							messageStart := map[string]interface{}{
-								"type": "message_start",
-								"message": map[string]interface{}{
-									"id":      getString(chunk, "id"),
-									"type":    "message",
-									"role":    "assistant",
-									"content": []interface{}{},
-									"model":   getString(chunk, "model"),
-									"usage": map[string]interface{}{
-										"input_tokens":  0,
-										"output_tokens": 0,
-									},
-								},
-							}
-							startData, _ := json.Marshal(messageStart)
-							fmt.Fprintf(pw, "event: message_start\ndata: %s\n\n", string(startData))
-
-							// 发送 content_block_start
-							blockStart := map[string]interface{}{
-								"type":  "content_block_start",
-								"index": 0,
-								"content_block": map[string]interface{}{
-									"type": "text",
-									"text": "",
-								},
-							}
-							blockData, _ := json.Marshal(blockStart)
-							fmt.Fprintf(pw, "event: content_block_start\ndata: %s\n\n", string(blockData))
-						}
-
-						// 处理内容
-						if content := getString(delta, "content"); content != "" {
-							contentDelta := map[string]interface{}{
-								"type":  "content_block_delta",
-								"index": 0,
-								"delta": map[string]interface{}{
-									"type": "text_delta",
-									"text": content,
-								},
-							}
-							deltaData, _ := json.Marshal(contentDelta)
-							fmt.Fprintf(pw, "event: content_block_delta\ndata: %s\n\n", string(deltaData))
-						}
-
-						// 处理结束
-						if finishReason := getString(choice, "finish_reason"); finishReason != "" {
-							// 发送 content_block_stop
-							blockStop := map[string]interface{}{
-								"type":  "content_block_stop",
-								"index": 0,
-							}
-							stopData, _ := json.Marshal(blockStop)
-							fmt.Fprintf(pw, "event: content_block_stop\ndata: %s\n\n", string(stopData))
-
-							// 转换 finish_reason
-							stopReason := "end_turn"
-							if finishReason == "tool_calls" {
-								stopReason = "tool_use"
-							} else if finishReason == "length" {
-								stopReason = "max_tokens"
-							}
-
-							// 发送 message_delta
-							messageDelta := map[string]interface{}{
-								"type": "message_delta",
-								"delta": map[string]interface{}{
-									"stop_reason": stopReason,
-								},
-							}
-
-							// 添加 usage 信息（Anthropic 格式）
-							if usage, ok := chunk["usage"].(map[string]interface{}); ok {
-								inputTokens := int(getFloat(usage, "prompt_tokens"))
-								outputTokens := int(getFloat(usage, "completion_tokens"))
-								messageDelta["usage"] = map[string]interface{}{
-									"input_tokens":  inputTokens,
-									"output_tokens": outputTokens,
-								}
-							}
-
-							deltaData, _ := json.Marshal(messageDelta)
-							fmt.Fprintf(pw, "event: message_delta\ndata: %s\n\n", string(deltaData))
-
-							// 发送 message_stop
-							messageStop := map[string]interface{}{
-								"type": "message_stop",
-							}
-							stopMsgData, _ := json.Marshal(messageStop)
-							fmt.Fprintf(pw, "event: message_stop\ndata: %s\n\n", string(stopMsgData))
-						}
-					}
-				}
-			} else {
-				// 其他转换场景，暂时直接透传
-				newData, _ := json.Marshal(chunk)
-				fmt.Fprintf(pw, "data: %s\n\n", string(newData))
-			}
-		}
-	}()
-
-	newResponse := &http.Response{
-		Status:        response.Status,
-		StatusCode:    response.StatusCode,
-		Proto:         response.Proto,
-		ProtoMajor:    response.ProtoMajor,
-		ProtoMinor:    response.ProtoMinor,
-		Header:        response.Header.Clone(),
-		Body:          pr,
-		ContentLength: -1,
-	}
-
-	return newResponse, nil
-}
-
 func parseOpenAIResponse(body []byte) (*UnifiedResponse, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(body, &resp); err != nil {
@@ -868,9 +446,11 @@ func parseOpenAIResponse(body []byte) (*UnifiedResponse, error) {
 		unified.Choices = []UnifiedChoice{{
 			Index: 0,
 			Message: &UnifiedMessage{
-				Role:      getString(msg, "role"),
-				Content:   msg["content"],
-				ToolCalls: parseOpenAIToolCalls(msg),
+				Role:               getString(msg, "role"),
+				Content:            msg["content"],
+				ToolCalls:          parseOpenAIToolCalls(msg),
+				ReasoningContent:   openAIReasoningContent(msg),
+				ReasoningSignature: getString(msg, "reasoning_signature"),
 			},
 			FinishReason: getString(choice, "finish_reason"),
 		}}
@@ -882,6 +462,10 @@ func parseOpenAIResponse(body []byte) (*UnifiedResponse, error) {
 			CompletionTokens: int64(getFloat(usage, "completion_tokens")),
 			TotalTokens:      int64(getFloat(usage, "total_tokens")),
 		}
+		if details, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
+			unified.Usage.PromptTokensDetails.CachedTokens = int64(getFloat(details, "cached_tokens"))
+			unified.Usage.PromptTokensDetails.CacheCreationTokens = int64(getFloat(details, "cache_creation_tokens"))
+		}
 	}
 
 	return unified, nil
@@ -904,6 +488,12 @@ func formatOpenAIResponse(unified *UnifiedResponse) ([]byte, error) {
 		if choice.Message.Content != nil {
 			msg["content"] = choice.Message.Content
 		}
+		if choice.Message.ReasoningContent != "" {
+			msg["reasoning_content"] = choice.Message.ReasoningContent
+		}
+		if choice.Message.ReasoningSignature != "" {
+			msg["reasoning_signature"] = choice.Message.ReasoningSignature
+		}
 		if len(choice.Message.ToolCalls) > 0 {
 			toolCalls := []interface{}{}
 			for _, tc := range choice.Message.ToolCalls {
@@ -929,16 +519,28 @@ func formatOpenAIResponse(unified *UnifiedResponse) ([]byte, error) {
 	}
 
 	if unified.Usage != nil {
-		resp["usage"] = map[string]interface{}{
-			"prompt_tokens":     unified.Usage.PromptTokens,
-			"completion_tokens": unified.Usage.CompletionTokens,
-			"total_tokens":      unified.Usage.TotalTokens,
-		}
+		resp["usage"] = formatOpenAIUsage(unified.Usage)
 	}
 
 	return json.Marshal(resp)
 }
 
+// formatOpenAIUsage 将统一用量转为 OpenAI usage，有缓存用量时附加 prompt_tokens_details
+func formatOpenAIUsage(usage *models.Usage) map[string]interface{} {
+	result := map[string]interface{}{
+		"prompt_tokens":     usage.PromptTokens,
+		"completion_tokens": usage.CompletionTokens,
+		"total_tokens":      usage.TotalTokens,
+	}
+	if details := usage.PromptTokensDetails; details.CachedTokens > 0 || details.CacheCreationTokens > 0 {
+		result["prompt_tokens_details"] = map[string]interface{}{
+			"cached_tokens":         details.CachedTokens,
+			"cache_creation_tokens": details.CacheCreationTokens,
+		}
+	}
+	return result
+}
+
 func parseOpenAIToolCalls(msgMap map[string]interface{}) []UnifiedToolCall {
 	var toolCalls []UnifiedToolCall
 	if tcs, ok := msgMap["tool_calls"].([]interface{}); ok {
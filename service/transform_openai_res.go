@@ -0,0 +1,1360 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+)
+
+// TransformOpenAIResToUnified 将 OpenAI Responses 格式转换为统一格式
+func TransformOpenAIResToUnified(rawBody []byte) (*UnifiedRequest, error) {
+	var req map[string]interface{}
+	if err := json.Unmarshal(rawBody, &req); err != nil {
+		return nil, err
+	}
+
+	unified := &UnifiedRequest{
+		Model:  getString(req, "model"),
+		Stream: getBool(req, "stream"),
+		System: getString(req, "instructions"),
+	}
+
+	if maxTokens, ok := req["max_output_tokens"].(float64); ok {
+		unified.MaxTokens = int(maxTokens)
+	}
+	if temp, ok := req["temperature"].(float64); ok {
+		unified.Temperature = &temp
+	}
+	if topP, ok := req["top_p"].(float64); ok {
+		unified.TopP = &topP
+	}
+	if reasoning, ok := req["reasoning"].(map[string]interface{}); ok && getString(reasoning, "effort") != "" {
+		unified.Reasoning = &UnifiedReasoning{Effort: getString(reasoning, "effort")}
+	}
+	unified.ResponseFormat = parseResponsesTextFormat(req["text"])
+	unified.User = getString(req, "user")
+	if topLogprobs, ok := req["top_logprobs"].(float64); ok {
+		value := int(topLogprobs)
+		unified.TopLogprobs = &value
+	}
+
+	// 转换输入，input 可以是字符串或输入项数组
+	switch input := req["input"].(type) {
+	case string:
+		unified.Messages = append(unified.Messages, UnifiedMessage{Role: "user", Content: input})
+	case []interface{}:
+		for _, item := range input {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch getString(itemMap, "type") {
+			case "function_call":
+				call := UnifiedToolCall{
+					ID:   getString(itemMap, "call_id"),
+					Type: "function",
+					Function: UnifiedToolCallFunction{
+						Name:      getString(itemMap, "name"),
+						Arguments: getString(itemMap, "arguments"),
+					},
+				}
+				// 紧跟在 assistant 消息后的调用归入同一条消息
+				if n := len(unified.Messages); n > 0 && unified.Messages[n-1].Role == "assistant" {
+					unified.Messages[n-1].ToolCalls = append(unified.Messages[n-1].ToolCalls, call)
+				} else {
+					unified.Messages = append(unified.Messages, UnifiedMessage{Role: "assistant", ToolCalls: []UnifiedToolCall{call}})
+				}
+			case "function_call_output":
+				unified.Messages = append(unified.Messages, UnifiedMessage{
+					Role:       "tool",
+					Content:    responsesOutputText(itemMap["output"]),
+					ToolCallID: getString(itemMap, "call_id"),
+				})
+			case "message", "":
+				role := getString(itemMap, "role")
+				content := responsesContentToOpenAI(itemMap["content"])
+				if role == "system" || role == "developer" {
+					if text := geminiText(content); text != "" {
+						if unified.System != "" {
+							unified.System += "\n\n" + text
+						} else {
+							unified.System = text
+						}
+					}
+					continue
+				}
+				unified.Messages = append(unified.Messages, UnifiedMessage{Role: role, Content: content})
+			}
+			// reasoning 等其他输入项无法在其他格式中表达，直接忽略
+		}
+	}
+
+	// 转换工具，web_search 等内置工具无法转换
+	if tools, ok := req["tools"].([]interface{}); ok {
+		for _, tool := range tools {
+			toolMap, ok := tool.(map[string]interface{})
+			if !ok || getString(toolMap, "type") != "function" {
+				continue
+			}
+			unified.Tools = append(unified.Tools, UnifiedTool{
+				Type: "function",
+				Function: UnifiedFunc{
+					Name:        getString(toolMap, "name"),
+					Description: getString(toolMap, "description"),
+					Parameters:  toolMap["parameters"],
+				},
+			})
+		}
+	}
+	unified.ToolChoice = parseOpenAIToolChoice(req["tool_choice"])
+	if parallel, ok := req["parallel_tool_calls"].(bool); ok {
+		unified.ParallelToolCalls = &parallel
+	}
+
+	return unified, nil
+}
+
+// responsesContentToOpenAI 将 Responses 消息内容转换为 chat 格式，纯文本内容合并为字符串
+func responsesContentToOpenAI(content interface{}) interface{} {
+	items, ok := content.([]interface{})
+	if !ok {
+		if text, ok := content.(string); ok {
+			return text
+		}
+		return ""
+	}
+
+	parts := []interface{}{}
+	var texts []string
+	onlyText := true
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch getString(itemMap, "type") {
+		case "input_text", "output_text", "text":
+			text := getString(itemMap, "text")
+			texts = append(texts, text)
+			parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+		case "input_image":
+			onlyText = false
+			imageURL := map[string]interface{}{"url": getString(itemMap, "image_url")}
+			if detail := getString(itemMap, "detail"); detail != "" {
+				imageURL["detail"] = detail
+			}
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": imageURL})
+		}
+	}
+	if onlyText {
+		return strings.Join(texts, "")
+	}
+	return parts
+}
+
+// responsesOutputText function_call_output 的 output 可以是字符串或内容数组
+func responsesOutputText(output interface{}) string {
+	if text, ok := output.(string); ok {
+		return text
+	}
+	if text := geminiText(output); text != "" {
+		return text
+	}
+	if output == nil {
+		return ""
+	}
+	data, _ := json.Marshal(output)
+	return string(data)
+}
+
+// TransformUnifiedToOpenAIRes 将统一格式转换为 OpenAI Responses 格式
+// responsesMinOutputTokens Responses 接口允许的最小 max_output_tokens
+const responsesMinOutputTokens = 16
+
+func TransformUnifiedToOpenAIRes(unified *UnifiedRequest) ([]byte, error) {
+	req := map[string]interface{}{
+		"model":  unified.Model,
+		"stream": unified.Stream,
+	}
+
+	if unified.MaxTokens > 0 {
+		// Responses 拒绝低于 16 的 max_output_tokens，Anthropic 客户端探测时常传 1
+		req["max_output_tokens"] = max(unified.MaxTokens, responsesMinOutputTokens)
+	}
+	if unified.Temperature != nil {
+		req["temperature"] = *unified.Temperature
+	}
+	if unified.TopP != nil {
+		req["top_p"] = *unified.TopP
+	}
+	// 请求推理摘要，使思考内容可以转换为其他格式输出
+	if reasoning := unified.Reasoning; reasoning != nil && !reasoningDisabled(reasoning) {
+		req["reasoning"] = map[string]interface{}{"effort": reasoningEffort(reasoning), "summary": "auto"}
+	}
+	if unified.ResponseFormat != nil {
+		req["text"] = formatResponsesTextFormat(unified.ResponseFormat)
+	}
+	// Responses 不支持停止序列、惩罚系数与随机种子，只转换 user 与 top_logprobs
+	if unified.User != "" {
+		req["user"] = unified.User
+	}
+	if unified.TopLogprobs != nil {
+		req["top_logprobs"] = *unified.TopLogprobs
+	}
+
+	instructions := unified.System
+	input := []interface{}{}
+	for _, msg := range unified.Messages {
+		switch msg.Role {
+		case "system":
+			if text := geminiText(msg.Content); text != "" {
+				if instructions != "" {
+					instructions += "\n\n" + text
+				} else {
+					instructions = text
+				}
+			}
+			continue
+		case "tool":
+			input = append(input, map[string]interface{}{
+				"type":    "function_call_output",
+				"call_id": msg.ToolCallID,
+				"output":  geminiText(msg.Content),
+			})
+			continue
+		}
+
+		// tool_result 需紧跟上一轮的 function_call，先于同一条消息中的文本输出
+		content, outputs := unifiedContentToResponses(msg.Role, msg.Content)
+		input = append(input, outputs...)
+		if content != nil {
+			input = append(input, map[string]interface{}{
+				"type":    "message",
+				"role":    msg.Role,
+				"content": content,
+			})
+		}
+		for _, tc := range msg.ToolCalls {
+			input = append(input, map[string]interface{}{
+				"type":      "function_call",
+				"call_id":   tc.ID,
+				"name":      tc.Function.Name,
+				"arguments": tc.Function.Arguments,
+			})
+		}
+	}
+	req["input"] = input
+	if instructions != "" {
+		req["instructions"] = instructions
+	}
+
+	// 转换工具，Responses 的函数定义没有 function 包装层
+	if len(unified.Tools) > 0 {
+		tools := []interface{}{}
+		for _, tool := range unified.Tools {
+			tools = append(tools, map[string]interface{}{
+				"type":        "function",
+				"name":        tool.Function.Name,
+				"description": tool.Function.Description,
+				"parameters":  tool.Function.Parameters,
+			})
+		}
+		req["tools"] = tools
+		if unified.ParallelToolCalls != nil {
+			req["parallel_tool_calls"] = *unified.ParallelToolCalls
+		}
+		if choice := unified.ToolChoice; choice != nil {
+			if choice.Type == ToolChoiceFunction {
+				req["tool_choice"] = map[string]interface{}{"type": "function", "name": choice.Name}
+			} else {
+				req["tool_choice"] = choice.Type
+			}
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+// unifiedContentToResponses 将 OpenAI / Anthropic 的消息内容转换为 Responses 内容块
+// Anthropic 的 tool_result 块单独转换为 function_call_output 输入项
+func unifiedContentToResponses(role string, content interface{}) (interface{}, []interface{}) {
+	textType := "input_text"
+	if role == "assistant" {
+		textType = "output_text"
+	}
+
+	switch v := content.(type) {
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return v, nil
+	case []interface{}:
+		parts := []interface{}{}
+		var outputs []interface{}
+		for _, item := range v {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch getString(itemMap, "type") {
+			case "text":
+				parts = append(parts, map[string]interface{}{"type": textType, "text": getString(itemMap, "text")})
+			case "image_url":
+				imageURL := getString(itemMap, "image_url")
+				if imageMap, ok := itemMap["image_url"].(map[string]interface{}); ok {
+					imageURL = getString(imageMap, "url")
+				}
+				parts = append(parts, map[string]interface{}{"type": "input_image", "image_url": imageURL})
+			case "image":
+				source, ok := itemMap["source"].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				imageURL := getString(source, "url")
+				if getString(source, "type") == "base64" {
+					imageURL = fmt.Sprintf("data:%s;base64,%s", getString(source, "media_type"), getString(source, "data"))
+				}
+				parts = append(parts, map[string]interface{}{"type": "input_image", "image_url": imageURL})
+			case "tool_result":
+				outputs = append(outputs, map[string]interface{}{
+					"type":    "function_call_output",
+					"call_id": getString(itemMap, "tool_use_id"),
+					"output":  geminiText(itemMap["content"]),
+				})
+			}
+			// tool_use 已由 UnifiedMessage.ToolCalls 转换
+		}
+		if len(parts) == 0 {
+			return nil, outputs
+		}
+		return parts, outputs
+	default:
+		return nil, nil
+	}
+}
+
+// parseOpenAIResResponse 解析 Responses 响应对象
+func parseOpenAIResResponse(body []byte) (*UnifiedResponse, error) {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	unified := &UnifiedResponse{
+		ID:      getString(resp, "id"),
+		Object:  "chat.completion",
+		Created: int64(getFloat(resp, "created_at")),
+		Model:   getString(resp, "model"),
+	}
+
+	var textContent, reasoning string
+	var toolCalls []UnifiedToolCall
+	output, _ := resp["output"].([]interface{})
+	for _, item := range output {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch getString(itemMap, "type") {
+		case "message":
+			content, _ := itemMap["content"].([]interface{})
+			for _, part := range content {
+				if partMap, ok := part.(map[string]interface{}); ok && getString(partMap, "type") == "output_text" {
+					textContent += getString(partMap, "text")
+				}
+			}
+		case "reasoning":
+			// 推理摘要优先，没有摘要时使用完整推理文本
+			for _, field := range []string{"summary", "content"} {
+				parts, _ := itemMap[field].([]interface{})
+				var texts []string
+				for _, part := range parts {
+					if partMap, ok := part.(map[string]interface{}); ok && getString(partMap, "text") != "" {
+						texts = append(texts, getString(partMap, "text"))
+					}
+				}
+				if len(texts) > 0 {
+					reasoning = strings.Join(texts, "\n\n")
+					break
+				}
+			}
+		case "function_call":
+			args := getString(itemMap, "arguments")
+			if args == "" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, UnifiedToolCall{
+				ID:   getString(itemMap, "call_id"),
+				Type: "function",
+				Function: UnifiedToolCallFunction{
+					Name:      getString(itemMap, "name"),
+					Arguments: args,
+				},
+			})
+		}
+	}
+
+	unified.Choices = []UnifiedChoice{{
+		Index: 0,
+		Message: &UnifiedMessage{
+			Role:             "assistant",
+			Content:          textContent,
+			ToolCalls:        toolCalls,
+			ReasoningContent: reasoning,
+		},
+		FinishReason: responsesFinishReason(resp, len(toolCalls) > 0),
+	}}
+
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		unified.Usage = parseResponsesUsage(usage)
+	}
+
+	return unified, nil
+}
+
+// formatOpenAIResResponse 将统一格式输出为 Responses 响应对象
+func formatOpenAIResResponse(unified *UnifiedResponse) ([]byte, error) {
+	created := unified.Created
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+	resp := map[string]interface{}{
+		"id":         responsesID("resp", unified.ID),
+		"object":     "response",
+		"created_at": created,
+		"status":     "completed",
+		"model":      unified.Model,
+		"output":     []interface{}{},
+	}
+
+	if len(unified.Choices) > 0 {
+		choice := unified.Choices[0]
+		output := []interface{}{}
+		if reasoning := choice.Message.ReasoningContent; reasoning != "" {
+			output = append(output, map[string]interface{}{
+				"id":      responsesID("rs", unified.ID),
+				"type":    "reasoning",
+				"summary": []interface{}{map[string]interface{}{"type": "summary_text", "text": reasoning}},
+			})
+		}
+		if text, ok := choice.Message.Content.(string); ok && text != "" {
+			output = append(output, map[string]interface{}{
+				"id":     responsesID("msg", unified.ID),
+				"type":   "message",
+				"status": "completed",
+				"role":   "assistant",
+				"content": []interface{}{
+					map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}},
+				},
+			})
+		}
+		for _, tc := range choice.Message.ToolCalls {
+			output = append(output, map[string]interface{}{
+				"id":        responsesID("fc", tc.ID),
+				"type":      "function_call",
+				"status":    "completed",
+				"call_id":   tc.ID,
+				"name":      tc.Function.Name,
+				"arguments": tc.Function.Arguments,
+			})
+		}
+		resp["output"] = output
+		setResponsesStatus(resp, choice.FinishReason)
+	}
+
+	if unified.Usage != nil {
+		resp["usage"] = formatResponsesUsage(unified.Usage)
+	}
+
+	return json.Marshal(resp)
+}
+
+// responsesFinishReason 将 Responses 的 status / incomplete_details 映射为 OpenAI finish_reason
+func responsesFinishReason(resp map[string]interface{}, hasToolCalls bool) string {
+	if getString(resp, "status") == "incomplete" {
+		details, _ := resp["incomplete_details"].(map[string]interface{})
+		if getString(details, "reason") == "content_filter" {
+			return "content_filter"
+		}
+		return "length"
+	}
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// setResponsesStatus 按 finish_reason 设置响应对象的 status 与 incomplete_details
+func setResponsesStatus(resp map[string]interface{}, finishReason string) {
+	switch finishReason {
+	case "length":
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]interface{}{"reason": "max_output_tokens"}
+	case "content_filter":
+		resp["status"] = "incomplete"
+		resp["incomplete_details"] = map[string]interface{}{"reason": "content_filter"}
+	default:
+		resp["status"] = "completed"
+	}
+}
+
+func parseResponsesUsage(usage map[string]interface{}) *models.Usage {
+	result := &models.Usage{
+		PromptTokens:     int64(getFloat(usage, "input_tokens")),
+		CompletionTokens: int64(getFloat(usage, "output_tokens")),
+		TotalTokens:      int64(getFloat(usage, "total_tokens")),
+	}
+	if details, ok := usage["input_tokens_details"].(map[string]interface{}); ok {
+		result.PromptTokensDetails.CachedTokens = int64(getFloat(details, "cached_tokens"))
+	}
+	if result.TotalTokens == 0 {
+		result.TotalTokens = result.PromptTokens + result.CompletionTokens
+	}
+	return result
+}
+
+func formatResponsesUsage(usage *models.Usage) map[string]interface{} {
+	return map[string]interface{}{
+		"input_tokens":          usage.PromptTokens,
+		"output_tokens":         usage.CompletionTokens,
+		"total_tokens":          usage.TotalTokens,
+		"input_tokens_details":  map[string]interface{}{"cached_tokens": usage.PromptTokensDetails.CachedTokens},
+		"output_tokens_details": map[string]interface{}{"reasoning_tokens": 0},
+	}
+}
+
+// responsesID 为 Responses 对象补齐 resp_ / msg_ / fc_ / rs_ 前缀
+func responsesID(prefix, id string) string {
+	if id == "" {
+		id = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	if strings.HasPrefix(id, prefix+"_") {
+		return id
+	}
+	return prefix + "_" + id
+}
+
+// transformStreamRealtime 在 chat、messages 与 Responses 事件流之间实时转换
+// 上游事件先归一化为文本、思考内容、工具调用与结束事件，再由 streamSink 写为客户端格式
+func transformStreamRealtime(response *http.Response, providerType, clientType string) (*http.Response, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer response.Body.Close()
+
+		sink := newStreamSink(pw, clientType)
+		var err error
+		switch providerType {
+		case "anthropic":
+			// 结构化输出以工具调用实现，流中的工具参数还原为文本
+			err = readAnthropicStream(response.Body, newStructuredOutputSink(sink))
+		case "openai-res":
+			err = readOpenAIResStream(response.Body, sink)
+		default:
+			err = readOpenAIChatStream(response.Body, sink)
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		sink.close()
+		pw.Close()
+	}()
+
+	newResponse := &http.Response{
+		Status:        response.Status,
+		StatusCode:    response.StatusCode,
+		Proto:         response.Proto,
+		ProtoMajor:    response.ProtoMajor,
+		ProtoMinor:    response.ProtoMinor,
+		Header:        response.Header.Clone(),
+		Body:          pr,
+		ContentLength: -1,
+	}
+
+	return newResponse, nil
+}
+
+// streamSink 接收归一化的流式事件并写为客户端格式，工具调用按出现顺序从 0 编号
+type streamSink interface {
+	start(id, model string)
+	text(delta string)
+	reasoning(delta string)
+	// reasoningSignature 思考内容的签名（Anthropic signature_delta），在思考内容之后、其他内容之前到达
+	reasoningSignature(signature string)
+	toolCall(index int, id, name string)
+	toolArgs(index int, delta string)
+	// finish reason 使用 OpenAI finish_reason 取值，usage 可能为空
+	finish(reason string, usage *models.Usage)
+	fail(message string)
+	close()
+}
+
+func newStreamSink(w io.Writer, clientType string) streamSink {
+	switch clientType {
+	case "anthropic":
+		return &anthropicStreamSink{w: w, toolBlocks: map[int]int{}}
+	case "openai-res":
+		return &openAIResStreamSink{w: w, open: -1, toolItems: map[int]int{}, buffers: map[int]*strings.Builder{}}
+	default:
+		return &openAIStreamSink{w: w}
+	}
+}
+
+// scanSSE 逐条解析 SSE 消息，回调返回 false 时停止读取
+func scanSSE(r io.Reader, fn func(event, data string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 8192), 1024*1024)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			event = ""
+			continue
+		}
+		if after, ok := strings.CutPrefix(line, "event:"); ok {
+			event = strings.TrimSpace(after)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		if data = strings.TrimSpace(data); data == "" {
+			continue
+		}
+		if !fn(event, data) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// readOpenAIChatStream 解析 chat.completion.chunk 流，finish_reason 与 usage 可能位于不同 chunk，在流结束时一并提交
+func readOpenAIChatStream(r io.Reader, sink streamSink) error {
+	var started, failed bool
+	var finishReason string
+	var usage *models.Usage
+	err := scanSSE(r, func(_, data string) bool {
+		if data == "[DONE]" {
+			return false
+		}
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return true
+		}
+		if errMap, ok := chunk["error"].(map[string]interface{}); ok {
+			sink.fail(getString(errMap, "message"))
+			failed = true
+			return false
+		}
+		if !started {
+			started = true
+			sink.start(getString(chunk, "id"), getString(chunk, "model"))
+		}
+		if usageMap, ok := chunk["usage"].(map[string]interface{}); ok && getFloat(usageMap, "total_tokens") > 0 {
+			usage = &models.Usage{
+				PromptTokens:     int64(getFloat(usageMap, "prompt_tokens")),
+				CompletionTokens: int64(getFloat(usageMap, "completion_tokens")),
+				TotalTokens:      int64(getFloat(usageMap, "total_tokens")),
+			}
+			if details, ok := usageMap["prompt_tokens_details"].(map[string]interface{}); ok {
+				usage.PromptTokensDetails.CachedTokens = int64(getFloat(details, "cached_tokens"))
+			}
+		}
+
+		choices, _ := chunk["choices"].([]interface{})
+		if len(choices) == 0 {
+			return true
+		}
+		choice, _ := choices[0].(map[string]interface{})
+		if delta, ok := choice["delta"].(map[string]interface{}); ok {
+			if reasoning := openAIReasoningContent(delta); reasoning != "" {
+				sink.reasoning(reasoning)
+			}
+			if signature := getString(delta, "reasoning_signature"); signature != "" {
+				sink.reasoningSignature(signature)
+			}
+			if text := getString(delta, "content"); text != "" {
+				sink.text(text)
+			}
+			toolCalls, _ := delta["tool_calls"].([]interface{})
+			for _, tc := range toolCalls {
+				tcMap, ok := tc.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				index := int(getFloat(tcMap, "index"))
+				funcMap, _ := tcMap["function"].(map[string]interface{})
+				if id := getString(tcMap, "id"); id != "" {
+					sink.toolCall(index, id, getString(funcMap, "name"))
+				}
+				if args := getString(funcMap, "arguments"); args != "" {
+					sink.toolArgs(index, args)
+				}
+			}
+		}
+		if reason := getString(choice, "finish_reason"); reason != "" {
+			finishReason = reason
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !failed {
+		sink.finish(finishReason, usage)
+	}
+	return nil
+}
+
+// readAnthropicStream 解析 Anthropic messages 事件流
+func readAnthropicStream(r io.Reader, sink streamSink) error {
+	var failed bool
+	var finishReason string
+	usage := map[string]interface{}{}
+	toolIndex := map[int]int{} // content block 下标 -> 工具调用序号
+	err := scanSSE(r, func(event, data string) bool {
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return true
+		}
+		if event == "" {
+			event = getString(chunk, "type")
+		}
+
+		switch event {
+		case "message_start":
+			msg, _ := chunk["message"].(map[string]interface{})
+			sink.start(getString(msg, "id"), getString(msg, "model"))
+			if usageMap, ok := msg["usage"].(map[string]interface{}); ok {
+				mergeAnthropicUsage(usage, usageMap)
+			}
+		case "content_block_start":
+			block, _ := chunk["content_block"].(map[string]interface{})
+			switch getString(block, "type") {
+			case "tool_use":
+				index := len(toolIndex)
+				toolIndex[int(getFloat(chunk, "index"))] = index
+				sink.toolCall(index, getString(block, "id"), getString(block, "name"))
+			case "thinking":
+				if thinking := getString(block, "thinking"); thinking != "" {
+					sink.reasoning(thinking)
+				}
+				if signature := getString(block, "signature"); signature != "" {
+					sink.reasoningSignature(signature)
+				}
+			}
+		case "content_block_delta":
+			delta, _ := chunk["delta"].(map[string]interface{})
+			switch getString(delta, "type") {
+			case "text_delta":
+				if text := getString(delta, "text"); text != "" {
+					sink.text(text)
+				}
+			case "thinking_delta":
+				if thinking := getString(delta, "thinking"); thinking != "" {
+					sink.reasoning(thinking)
+				}
+			case "signature_delta":
+				if signature := getString(delta, "signature"); signature != "" {
+					sink.reasoningSignature(signature)
+				}
+			case "input_json_delta":
+				index, ok := toolIndex[int(getFloat(chunk, "index"))]
+				if partial := getString(delta, "partial_json"); ok && partial != "" {
+					sink.toolArgs(index, partial)
+				}
+			}
+		case "message_delta":
+			if delta, ok := chunk["delta"].(map[string]interface{}); ok {
+				finishReason = anthropicFinishReason(getString(delta, "stop_reason"))
+			}
+			if usageMap, ok := chunk["usage"].(map[string]interface{}); ok {
+				mergeAnthropicUsage(usage, usageMap)
+			}
+		case "message_stop":
+			return false
+		case "error":
+			errMap, _ := chunk["error"].(map[string]interface{})
+			sink.fail(getString(errMap, "message"))
+			failed = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !failed {
+		sink.finish(finishReason, parseAnthropicUsage(usage))
+	}
+	return nil
+}
+
+// anthropicFinishReason 将 Anthropic stop_reason 映射为 OpenAI finish_reason
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// readOpenAIResStream 解析 Responses 事件流（response.output_text.delta 等）
+func readOpenAIResStream(r io.Reader, sink streamSink) error {
+	var failed, finished bool
+	var usage *models.Usage
+	finishReason := "stop"
+	toolIndex := map[int]int{} // output_index -> 工具调用序号
+	streamed := map[int]bool{} // 已收到参数增量的工具调用
+	// 部分上游不发送参数增量，只在 done 事件中给出完整参数
+	completeArgs := func(outputIndex int, args string) {
+		index, ok := toolIndex[outputIndex]
+		if ok && !streamed[index] && args != "" {
+			streamed[index] = true
+			sink.toolArgs(index, args)
+		}
+	}
+	err := scanSSE(r, func(event, data string) bool {
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return true
+		}
+		if event == "" {
+			event = getString(chunk, "type")
+		}
+
+		switch event {
+		case "response.created":
+			resp, _ := chunk["response"].(map[string]interface{})
+			sink.start(getString(resp, "id"), getString(resp, "model"))
+		case "response.output_item.added":
+			item, _ := chunk["item"].(map[string]interface{})
+			if getString(item, "type") == "function_call" {
+				index := len(toolIndex)
+				toolIndex[int(getFloat(chunk, "output_index"))] = index
+				sink.toolCall(index, getString(item, "call_id"), getString(item, "name"))
+			}
+		case "response.output_text.delta":
+			if delta := getString(chunk, "delta"); delta != "" {
+				sink.text(delta)
+			}
+		case "response.reasoning_summary_part.added":
+			// 多段推理摘要之间以空行分隔
+			if getFloat(chunk, "summary_index") > 0 {
+				sink.reasoning("\n\n")
+			}
+		case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+			if delta := getString(chunk, "delta"); delta != "" {
+				sink.reasoning(delta)
+			}
+		case "response.function_call_arguments.delta":
+			index, ok := toolIndex[int(getFloat(chunk, "output_index"))]
+			if delta := getString(chunk, "delta"); ok && delta != "" {
+				streamed[index] = true
+				sink.toolArgs(index, delta)
+			}
+		case "response.function_call_arguments.done":
+			completeArgs(int(getFloat(chunk, "output_index")), getString(chunk, "arguments"))
+		case "response.output_item.done":
+			if item, _ := chunk["item"].(map[string]interface{}); getString(item, "type") == "function_call" {
+				completeArgs(int(getFloat(chunk, "output_index")), getString(item, "arguments"))
+			}
+		case "response.completed", "response.incomplete":
+			resp, _ := chunk["response"].(map[string]interface{})
+			if usageMap, ok := resp["usage"].(map[string]interface{}); ok {
+				usage = parseResponsesUsage(usageMap)
+			}
+			finishReason = responsesFinishReason(resp, len(toolIndex) > 0)
+			finished = true
+			return false
+		case "response.failed":
+			resp, _ := chunk["response"].(map[string]interface{})
+			errMap, _ := resp["error"].(map[string]interface{})
+			sink.fail(getString(errMap, "message"))
+			failed = true
+			return false
+		case "error":
+			sink.fail(getString(chunk, "message"))
+			failed = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !failed {
+		if !finished && len(toolIndex) > 0 {
+			finishReason = "tool_calls"
+		}
+		sink.finish(finishReason, usage)
+	}
+	return nil
+}
+
+// openAIStreamSink 输出 chat.completion.chunk，usage 随结束块输出
+type openAIStreamSink struct {
+	w       io.Writer
+	id      string
+	model   string
+	started bool
+	failed  bool
+}
+
+func (s *openAIStreamSink) send(delta map[string]interface{}, finishReason interface{}, usage *models.Usage) {
+	chunk := map[string]interface{}{
+		"id":      s.id,
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   s.model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	if usage != nil {
+		chunk["usage"] = map[string]interface{}{
+			"prompt_tokens":     usage.PromptTokens,
+			"completion_tokens": usage.CompletionTokens,
+			"total_tokens":      usage.TotalTokens,
+			"prompt_tokens_details": map[string]interface{}{
+				"cached_tokens": usage.PromptTokensDetails.CachedTokens,
+			},
+		}
+	}
+	chunkData, _ := json.Marshal(chunk)
+	fmt.Fprintf(s.w, "data: %s\n\n", string(chunkData))
+}
+
+func (s *openAIStreamSink) start(id, model string) {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.id = id
+	if s.id == "" {
+		s.id = fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	}
+	s.model = model
+	s.send(map[string]interface{}{"role": "assistant", "content": ""}, nil, nil)
+}
+
+func (s *openAIStreamSink) text(delta string) {
+	s.start("", "")
+	s.send(map[string]interface{}{"content": delta}, nil, nil)
+}
+
+func (s *openAIStreamSink) reasoning(delta string) {
+	s.start("", "")
+	s.send(map[string]interface{}{"reasoning_content": delta}, nil, nil)
+}
+
+// reasoningSignature 签名以 reasoning_signature 增量输出，客户端回传后可还原为带签名的 thinking 块
+func (s *openAIStreamSink) reasoningSignature(signature string) {
+	s.start("", "")
+	s.send(map[string]interface{}{"reasoning_signature": signature}, nil, nil)
+}
+
+func (s *openAIStreamSink) toolCall(index int, id, name string) {
+	s.start("", "")
+	s.send(map[string]interface{}{
+		"tool_calls": []map[string]interface{}{
+			{
+				"index":    index,
+				"id":       id,
+				"type":     "function",
+				"function": map[string]interface{}{"name": name, "arguments": ""},
+			},
+		},
+	}, nil, nil)
+}
+
+func (s *openAIStreamSink) toolArgs(index int, delta string) {
+	s.send(map[string]interface{}{
+		"tool_calls": []map[string]interface{}{
+			{
+				"index":    index,
+				"function": map[string]interface{}{"arguments": delta},
+			},
+		},
+	}, nil, nil)
+}
+
+func (s *openAIStreamSink) finish(reason string, usage *models.Usage) {
+	s.start("", "")
+	if reason == "" {
+		reason = "stop"
+	}
+	s.send(map[string]interface{}{}, reason, usage)
+}
+
+func (s *openAIStreamSink) fail(message string) {
+	s.failed = true
+	errData, _ := json.Marshal(map[string]interface{}{"error": map[string]interface{}{"message": message, "type": "upstream_error"}})
+	fmt.Fprintf(s.w, "data: %s\n\n", string(errData))
+}
+
+func (s *openAIStreamSink) close() {
+	if !s.failed {
+		fmt.Fprintf(s.w, "data: [DONE]\n\n")
+	}
+}
+
+// anthropicStreamSink 输出 Anthropic messages 事件流，思考内容、文本与每个工具调用各占一个 content block
+type anthropicStreamSink struct {
+	w          io.Writer
+	started    bool
+	failed     bool
+	nextBlock  int
+	openBlock  int
+	open       bool
+	openType   string      // 当前打开的 content block 类型
+	toolBlocks map[int]int // 工具调用序号 -> content block 下标
+}
+
+func (s *anthropicStreamSink) send(event string, data map[string]interface{}) {
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, string(payload))
+}
+
+func (s *anthropicStreamSink) start(id, model string) {
+	if s.started {
+		return
+	}
+	s.started = true
+	if id == "" {
+		id = fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	}
+	s.send("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":      id,
+			"type":    "message",
+			"role":    "assistant",
+			"content": []interface{}{},
+			"model":   model,
+			"usage": map[string]interface{}{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+func (s *anthropicStreamSink) startBlock(block map[string]interface{}) {
+	s.closeBlock()
+	s.openBlock = s.nextBlock
+	s.nextBlock++
+	s.open = true
+	s.openType = getString(block, "type")
+	s.send("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.openBlock,
+		"content_block": block,
+	})
+}
+
+func (s *anthropicStreamSink) closeBlock() {
+	if !s.open {
+		return
+	}
+	s.send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": s.openBlock})
+	s.open = false
+}
+
+func (s *anthropicStreamSink) text(delta string) {
+	s.start("", "")
+	if !s.open || s.openType != "text" {
+		s.startBlock(map[string]interface{}{"type": "text", "text": ""})
+	}
+	s.send("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": s.openBlock,
+		"delta": map[string]interface{}{"type": "text_delta", "text": delta},
+	})
+}
+
+func (s *anthropicStreamSink) reasoning(delta string) {
+	s.start("", "")
+	if !s.open || s.openType != "thinking" {
+		s.startBlock(anthropicThinkingBlock("", ""))
+	}
+	s.send("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": s.openBlock,
+		"delta": map[string]interface{}{"type": "thinking_delta", "thinking": delta},
+	})
+}
+
+// reasoningSignature 签名以 signature_delta 写入当前的 thinking 块，没有打开的 thinking 块时丢弃
+func (s *anthropicStreamSink) reasoningSignature(signature string) {
+	if !s.open || s.openType != "thinking" {
+		return
+	}
+	s.send("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": s.openBlock,
+		"delta": map[string]interface{}{"type": "signature_delta", "signature": signature},
+	})
+}
+
+func (s *anthropicStreamSink) toolCall(index int, id, name string) {
+	s.start("", "")
+	s.startBlock(map[string]interface{}{
+		"type":  "tool_use",
+		"id":    id,
+		"name":  name,
+		"input": map[string]interface{}{},
+	})
+	s.toolBlocks[index] = s.openBlock
+}
+
+func (s *anthropicStreamSink) toolArgs(index int, delta string) {
+	block, ok := s.toolBlocks[index]
+	if !ok {
+		return
+	}
+	s.send("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": block,
+		"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": delta},
+	})
+}
+
+func (s *anthropicStreamSink) finish(reason string, usage *models.Usage) {
+	s.start("", "")
+	s.closeBlock()
+
+	messageDelta := map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": anthropicStopReason(reason)},
+	}
+	if usage != nil {
+		messageDelta["usage"] = formatAnthropicUsage(usage)
+	}
+	s.send("message_delta", messageDelta)
+}
+
+func (s *anthropicStreamSink) fail(message string) {
+	s.failed = true
+	s.send("error", map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    "api_error",
+			"message": message,
+		},
+	})
+}
+
+func (s *anthropicStreamSink) close() {
+	if !s.started || s.failed {
+		return
+	}
+	s.closeBlock()
+	s.send("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+// openAIResStreamSink 输出 Responses 事件流，结束时发送带完整 output 与 usage 的 response.completed
+type openAIResStreamSink struct {
+	w         io.Writer
+	seq       int
+	id        string
+	model     string
+	createdAt int64
+	started   bool
+	failed    bool
+	output    []map[string]interface{}
+	open      int                      // 当前未结束的 output 下标，-1 表示没有
+	toolItems map[int]int              // 工具调用序号 -> output 下标
+	buffers   map[int]*strings.Builder // output 下标 -> 已输出的文本或参数
+}
+
+func (s *openAIResStreamSink) send(event string, data map[string]interface{}) {
+	data["type"] = event
+	data["sequence_number"] = s.seq
+	s.seq++
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, string(payload))
+}
+
+func (s *openAIResStreamSink) response(status string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         s.id,
+		"object":     "response",
+		"created_at": s.createdAt,
+		"status":     status,
+		"model":      s.model,
+		"output":     s.output,
+	}
+}
+
+func (s *openAIResStreamSink) start(id, model string) {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.id = responsesID("resp", id)
+	s.model = model
+	s.createdAt = time.Now().Unix()
+	s.output = []map[string]interface{}{}
+	s.send("response.created", map[string]interface{}{"response": s.response("in_progress")})
+	s.send("response.in_progress", map[string]interface{}{"response": s.response("in_progress")})
+}
+
+// addItem 追加新的 output 项并结束上一项
+func (s *openAIResStreamSink) addItem(item map[string]interface{}) int {
+	s.closeItem()
+	s.output = append(s.output, item)
+	s.open = len(s.output) - 1
+	s.buffers[s.open] = &strings.Builder{}
+	s.send("response.output_item.added", map[string]interface{}{"output_index": s.open, "item": item})
+	return s.open
+}
+
+func (s *openAIResStreamSink) closeItem() {
+	if s.open < 0 {
+		return
+	}
+	index, item := s.open, s.output[s.open]
+	s.open = -1
+	content := s.buffers[index].String()
+	itemID := getString(item, "id")
+	switch getString(item, "type") {
+	case "message":
+		part := map[string]interface{}{"type": "output_text", "text": content, "annotations": []interface{}{}}
+		s.send("response.output_text.done", map[string]interface{}{"item_id": itemID, "output_index": index, "content_index": 0, "text": content})
+		s.send("response.content_part.done", map[string]interface{}{"item_id": itemID, "output_index": index, "content_index": 0, "part": part})
+		item["content"] = []interface{}{part}
+	case "reasoning":
+		part := map[string]interface{}{"type": "summary_text", "text": content}
+		s.send("response.reasoning_summary_text.done", map[string]interface{}{"item_id": itemID, "output_index": index, "summary_index": 0, "text": content})
+		s.send("response.reasoning_summary_part.done", map[string]interface{}{"item_id": itemID, "output_index": index, "summary_index": 0, "part": part})
+		item["summary"] = []interface{}{part}
+	default:
+		s.send("response.function_call_arguments.done", map[string]interface{}{"item_id": itemID, "output_index": index, "arguments": content})
+		item["arguments"] = content
+	}
+	item["status"] = "completed"
+	s.send("response.output_item.done", map[string]interface{}{"output_index": index, "item": item})
+}
+
+func (s *openAIResStreamSink) text(delta string) {
+	s.start("", "")
+	if s.open < 0 || getString(s.output[s.open], "type") != "message" {
+		index := s.addItem(map[string]interface{}{
+			"id":      responsesID("msg", fmt.Sprintf("%d_%d", time.Now().UnixNano(), len(s.output))),
+			"type":    "message",
+			"status":  "in_progress",
+			"role":    "assistant",
+			"content": []interface{}{},
+		})
+		s.send("response.content_part.added", map[string]interface{}{
+			"item_id":       getString(s.output[index], "id"),
+			"output_index":  index,
+			"content_index": 0,
+			"part":          map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}},
+		})
+	}
+	s.buffers[s.open].WriteString(delta)
+	s.send("response.output_text.delta", map[string]interface{}{
+		"item_id":       getString(s.output[s.open], "id"),
+		"output_index":  s.open,
+		"content_index": 0,
+		"delta":         delta,
+	})
+}
+
+// reasoning 思考内容输出为 reasoning 项的推理摘要
+func (s *openAIResStreamSink) reasoning(delta string) {
+	s.start("", "")
+	if s.open < 0 || getString(s.output[s.open], "type") != "reasoning" {
+		index := s.addItem(map[string]interface{}{
+			"id":      responsesID("rs", fmt.Sprintf("%d_%d", time.Now().UnixNano(), len(s.output))),
+			"type":    "reasoning",
+			"summary": []interface{}{},
+		})
+		s.send("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id":       getString(s.output[index], "id"),
+			"output_index":  index,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": ""},
+		})
+	}
+	s.buffers[s.open].WriteString(delta)
+	s.send("response.reasoning_summary_text.delta", map[string]interface{}{
+		"item_id":       getString(s.output[s.open], "id"),
+		"output_index":  s.open,
+		"summary_index": 0,
+		"delta":         delta,
+	})
+}
+
+// reasoningSignature Responses 推理项没有对应的签名字段，签名丢弃
+func (s *openAIResStreamSink) reasoningSignature(string) {}
+
+func (s *openAIResStreamSink) toolCall(index int, id, name string) {
+	s.start("", "")
+	s.toolItems[index] = s.addItem(map[string]interface{}{
+		"id":        responsesID("fc", id),
+		"type":      "function_call",
+		"status":    "in_progress",
+		"call_id":   id,
+		"name":      name,
+		"arguments": "",
+	})
+}
+
+func (s *openAIResStreamSink) toolArgs(index int, delta string) {
+	item, ok := s.toolItems[index]
+	if !ok {
+		return
+	}
+	s.buffers[item].WriteString(delta)
+	s.send("response.function_call_arguments.delta", map[string]interface{}{
+		"item_id":      getString(s.output[item], "id"),
+		"output_index": item,
+		"delta":        delta,
+	})
+}
+
+func (s *openAIResStreamSink) finish(reason string, usage *models.Usage) {
+	s.start("", "")
+	s.closeItem()
+	resp := s.response("completed")
+	setResponsesStatus(resp, reason)
+	if usage != nil {
+		resp["usage"] = formatResponsesUsage(usage)
+	}
+	event := "response.completed"
+	if resp["status"] == "incomplete" {
+		event = "response.incomplete"
+	}
+	s.send(event, map[string]interface{}{"response": resp})
+}
+
+func (s *openAIResStreamSink) fail(message string) {
+	s.start("", "")
+	s.failed = true
+	resp := s.response("failed")
+	resp["error"] = map[string]interface{}{"code": "server_error", "message": message}
+	s.send("response.failed", map[string]interface{}{"response": resp})
+}
+
+func (s *openAIResStreamSink) close() {}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/atopos31/llmio/models"
 )
@@ -13,6 +15,10 @@ type UnifiedMessage struct {
 	Content    interface{}       `json:"content,omitempty"`
 	ToolCalls  []UnifiedToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string            `json:"tool_call_id,omitempty"` // OpenAI tool 角色消息的 tool_call_id
+
+	// 推理模型的思考内容：OpenAI 兼容接口的 reasoning_content、Anthropic thinking 块、Responses reasoning 摘要与 Gemini thought
+	ReasoningContent   string `json:"reasoning_content,omitempty"`
+	ReasoningSignature string `json:"reasoning_signature,omitempty"` // Anthropic thinking 块的签名，多轮对话回传时必需
 }
 
 // UnifiedToolCall 统一工具调用格式
@@ -32,6 +38,8 @@ type UnifiedToolCallFunction struct {
 type UnifiedTool struct {
 	Type     string      `json:"type"`
 	Function UnifiedFunc `json:"function"`
+
+	CacheControl interface{} `json:"cache_control,omitempty"` // 提示缓存断点，转换为 Anthropic 时保留
 }
 
 // UnifiedFunc 统一函数定义格式
@@ -51,6 +59,44 @@ type UnifiedRequest struct {
 	Stream      bool             `json:"stream,omitempty"`
 	Tools       []UnifiedTool    `json:"tools,omitempty"`
 	System      string           `json:"system,omitempty"`
+
+	Stop             []string `json:"stop,omitempty"` // 停止序列，对应 Anthropic stop_sequences 与 Gemini stopSequences
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	Logprobs         *bool    `json:"logprobs,omitempty"`
+	TopLogprobs      *int     `json:"top_logprobs,omitempty"`
+	User             string   `json:"user,omitempty"` // 终端用户标识，对应 Anthropic metadata.user_id
+
+	SystemCacheControl interface{} `json:"system_cache_control,omitempty"` // system 内容块上的提示缓存断点
+
+	ToolChoice        *UnifiedToolChoice `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool              `json:"parallel_tool_calls,omitempty"` // 为空表示沿用上游默认（允许并行）
+
+	Reasoning *UnifiedReasoning `json:"reasoning,omitempty"` // 为空表示沿用上游默认
+
+	ResponseFormat *UnifiedResponseFormat `json:"response_format,omitempty"` // 结构化输出，为空表示普通文本
+}
+
+// UnifiedReasoning 统一推理配置：OpenAI 与 Responses 使用推理强度，Anthropic 与 Gemini 使用思考 token 预算，
+// 只给出其中一项时按 reasoningBudget / reasoningEffort 换算
+type UnifiedReasoning struct {
+	Effort       string `json:"effort,omitempty"` // minimal、low、medium、high
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// 统一工具选择策略类型
+const (
+	ToolChoiceAuto     = "auto"     // 由模型决定是否调用工具
+	ToolChoiceNone     = "none"     // 禁止调用工具
+	ToolChoiceRequired = "required" // 必须调用任一工具，对应 Anthropic any、Gemini ANY
+	ToolChoiceFunction = "function" // 必须调用指定工具
+)
+
+// UnifiedToolChoice 统一工具选择格式
+type UnifiedToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"` // Type 为 function 时的工具名
 }
 
 // UnifiedChoice 统一响应选择格式
@@ -99,6 +145,8 @@ func NewTransformerManager(clientType, providerType string) *TransformerManager
 
 // ProcessRequest 处理请求转换
 func (tm *TransformerManager) ProcessRequest(ctx context.Context, rawBody []byte) ([]byte, error) {
+	tm.recordRequestFidelity(rawBody)
+
 	// 1. 客户端格式 -> 统一格式
 	var unified *UnifiedRequest
 	var err error
@@ -108,6 +156,8 @@ func (tm *TransformerManager) ProcessRequest(ctx context.Context, rawBody []byte
 		unified, err = TransformOpenAIToUnified(rawBody)
 	case "anthropic":
 		unified, err = TransformAnthropicToUnified(rawBody)
+	case "openai-res":
+		unified, err = TransformOpenAIResToUnified(rawBody)
 	default:
 		unified, err = TransformOpenAIToUnified(rawBody)
 	}
@@ -116,12 +166,23 @@ func (tm *TransformerManager) ProcessRequest(ctx context.Context, rawBody []byte
 		return nil, err
 	}
 
+	// Anthropic 兼容接口与 Gemini 不能可靠读取任意图片地址，转换前下载为 base64
+	if tm.providerType == "anthropic" || tm.providerType == "gemini" {
+		if count := inlineRemoteImages(ctx, unified); count > 0 {
+			slog.Info("inlined remote images", "provider_type", tm.providerType, "count", count)
+		}
+	}
+
 	// 2. 统一格式 -> 上游供应商格式
 	switch tm.providerType {
 	case "openai":
 		return TransformUnifiedToOpenAI(unified)
 	case "anthropic":
 		return TransformUnifiedToAnthropic(unified)
+	case "gemini":
+		return TransformUnifiedToGemini(unified)
+	case "openai-res":
+		return TransformUnifiedToOpenAIRes(unified)
 	default:
 		return TransformUnifiedToOpenAI(unified)
 	}
@@ -129,6 +190,9 @@ func (tm *TransformerManager) ProcessRequest(ctx context.Context, rawBody []byte
 
 // ProcessResponse 处理响应转换
 func (tm *TransformerManager) ProcessResponse(response *http.Response) (*http.Response, error) {
+	if tm.providerType != tm.clientType && strings.Contains(response.Header.Get("Content-Type"), "text/event-stream") {
+		response.Body = &fidelityStreamReader{ReadCloser: response.Body, client: tm.clientType, provider: tm.providerType}
+	}
 	// 上游供应商格式 -> 统一格式 -> 客户端格式
 	return TransformProviderResponse(response, tm.providerType, tm.clientType)
 }
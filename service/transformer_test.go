@@ -1,7 +1,15 @@
 package service
 
 import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/tidwall/gjson"
 )
 
 func TestTransformOpenAIToUnified(t *testing.T) {
@@ -121,3 +129,520 @@ func TestTransformerManager(t *testing.T) {
 		t.Error("Expected non-empty result")
 	}
 }
+
+func TestTransformOpenAIResToUnified(t *testing.T) {
+	responsesRequest := []byte(`{
+		"model": "gpt-4.1",
+		"instructions": "Be brief",
+		"max_output_tokens": 100,
+		"input": [
+			{"role": "user", "content": [{"type": "input_text", "text": "Weather?"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"}
+		],
+		"tools": [{"type": "function", "name": "get_weather", "parameters": {"type": "object"}}, {"type": "web_search"}]
+	}`)
+
+	unified, err := TransformOpenAIResToUnified(responsesRequest)
+	if err != nil {
+		t.Fatalf("TransformOpenAIResToUnified failed: %v", err)
+	}
+
+	if unified.System != "Be brief" || unified.MaxTokens != 100 {
+		t.Errorf("Expected system and max_tokens to be converted, got %q %d", unified.System, unified.MaxTokens)
+	}
+	if len(unified.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(unified.Messages))
+	}
+	if unified.Messages[0].Content != "Weather?" {
+		t.Errorf("Expected text content to be flattened, got %v", unified.Messages[0].Content)
+	}
+	if calls := unified.Messages[1].ToolCalls; unified.Messages[1].Role != "assistant" || len(calls) != 1 || calls[0].ID != "call_1" {
+		t.Errorf("Expected assistant tool call, got %+v", unified.Messages[1])
+	}
+	if msg := unified.Messages[2]; msg.Role != "tool" || msg.ToolCallID != "call_1" || msg.Content != "sunny" {
+		t.Errorf("Expected tool output, got %+v", msg)
+	}
+	if len(unified.Tools) != 1 {
+		t.Errorf("Expected built-in tools to be dropped, got %d tools", len(unified.Tools))
+	}
+}
+
+func TestToolChoiceMapping(t *testing.T) {
+	openAITools := `"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}]`
+	anthropicTools := `"tools":[{"name":"get_weather","input_schema":{"type":"object"}}]`
+	tests := []struct {
+		name     string
+		client   string
+		provider string
+		body     string
+		want     map[string]string // gjson 路径 -> 期望的原始 JSON
+	}{
+		{
+			name: "openai function choice to anthropic tool", client: "openai", provider: "anthropic",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],` + openAITools + `,"tool_choice":{"type":"function","function":{"name":"get_weather"}},"parallel_tool_calls":false}`,
+			want: map[string]string{"tool_choice": `{"disable_parallel_tool_use":true,"name":"get_weather","type":"tool"}`},
+		},
+		{
+			name: "openai required to anthropic any", client: "openai", provider: "anthropic",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],` + openAITools + `,"tool_choice":"required"}`,
+			want: map[string]string{"tool_choice": `{"type":"any"}`},
+		},
+		{
+			name: "openai none to anthropic none", client: "openai", provider: "anthropic",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],` + openAITools + `,"tool_choice":"none","parallel_tool_calls":false}`,
+			want: map[string]string{"tool_choice": `{"type":"none"}`},
+		},
+		{
+			name: "parallel flag alone becomes anthropic auto", client: "openai", provider: "anthropic",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],` + openAITools + `,"parallel_tool_calls":false}`,
+			want: map[string]string{"tool_choice": `{"disable_parallel_tool_use":true,"type":"auto"}`},
+		},
+		{
+			name: "anthropic tool to openai function", client: "anthropic", provider: "openai",
+			body: `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}],` + anthropicTools + `,"tool_choice":{"type":"tool","name":"get_weather","disable_parallel_tool_use":true}}`,
+			want: map[string]string{"tool_choice": `{"function":{"name":"get_weather"},"type":"function"}`, "parallel_tool_calls": `false`},
+		},
+		{
+			name: "anthropic any to responses required", client: "anthropic", provider: "openai-res",
+			body: `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":"hi"}],` + anthropicTools + `,"tool_choice":{"type":"any"}}`,
+			want: map[string]string{"tool_choice": `"required"`},
+		},
+		{
+			name: "responses function to anthropic tool", client: "openai-res", provider: "anthropic",
+			body: `{"model":"m","input":"hi","tools":[{"type":"function","name":"get_weather","parameters":{"type":"object"}}],"tool_choice":{"type":"function","name":"get_weather"}}`,
+			want: map[string]string{"tool_choice": `{"name":"get_weather","type":"tool"}`},
+		},
+		{
+			name: "openai function to gemini allowed names", client: "openai", provider: "gemini",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],` + openAITools + `,"tool_choice":{"type":"function","function":{"name":"get_weather"}}}`,
+			want: map[string]string{"toolConfig": `{"functionCallingConfig":{"allowedFunctionNames":["get_weather"],"mode":"ANY"}}`},
+		},
+		{
+			name: "tool choice dropped without tools", client: "openai", provider: "anthropic",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],"tool_choice":"auto"}`,
+			want: map[string]string{"tool_choice": ``},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewTransformerManager(tt.client, tt.provider).ProcessRequest(nil, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("ProcessRequest failed: %v", err)
+			}
+			for path, want := range tt.want {
+				if got := gjson.GetBytes(result, path).Raw; got != want {
+					t.Errorf("%s = %s, want %s", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnthropicToOpenAIRes(t *testing.T) {
+	tm := NewTransformerManager("anthropic", "openai-res")
+	result, err := tm.ProcessRequest(nil, []byte(`{"model":"m","max_tokens":1,"system":[{"type":"text","text":"Be brief"}],"messages":[
+		{"role":"user","content":"Weather?"},
+		{"role":"assistant","content":[{"type":"text","text":"Checking"},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"sunny"}]},{"type":"text","text":"Thanks"}]}
+	],"tools":[{"name":"get_weather","input_schema":{"type":"object"}}]}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	req := gjson.ParseBytes(result)
+	if req.Get("instructions").String() != "Be brief" || req.Get("max_output_tokens").Int() != responsesMinOutputTokens {
+		t.Errorf("Expected instructions and clamped max_output_tokens, got %s", result)
+	}
+	var types []string
+	for _, item := range req.Get("input").Array() {
+		types = append(types, item.Get("type").String())
+	}
+	if want := "message,message,function_call,function_call_output,message"; strings.Join(types, ",") != want {
+		t.Errorf("input types = %v, want %s", types, want)
+	}
+	if output := req.Get(`input.#(type=="function_call_output")`); output.Get("call_id").String() != "toolu_1" || output.Get("output").String() != "sunny" {
+		t.Errorf("Expected tool result to become function_call_output, got %s", output.Raw)
+	}
+
+	// 上游只在 done 事件中给出完整参数
+	stream := "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"model\":\"gpt-4.1\"}}\n\n" +
+		"event: response.output_item.added\ndata: {\"type\":\"response.output_item.added\",\"output_index\":0,\"item\":{\"type\":\"function_call\",\"call_id\":\"call_1\",\"name\":\"get_weather\"}}\n\n" +
+		"event: response.function_call_arguments.done\ndata: {\"type\":\"response.function_call_arguments.done\",\"output_index\":0,\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\"}\n\n" +
+		"event: response.output_item.done\ndata: {\"type\":\"response.output_item.done\",\"output_index\":0,\"item\":{\"type\":\"function_call\",\"call_id\":\"call_1\",\"name\":\"get_weather\",\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\"}}\n\n" +
+		"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"status\":\"completed\",\"output\":[{\"type\":\"function_call\"}],\"usage\":{\"input_tokens\":5,\"output_tokens\":3,\"total_tokens\":8}}}\n\n"
+	res, err := tm.ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/event-stream"}}, Body: io.NopCloser(strings.NewReader(stream))})
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	events := string(body)
+	if strings.Count(events, "input_json_delta") != 1 || !strings.Contains(events, `"partial_json":"{\"city\":\"Paris\"}"`) {
+		t.Errorf("Expected complete arguments to be streamed once, got %s", events)
+	}
+	if !strings.Contains(events, `"stop_reason":"tool_use"`) || !strings.HasSuffix(strings.TrimSpace(events), `data: {"type":"message_stop"}`) {
+		t.Errorf("Expected tool_use stop and message_stop, got %s", events)
+	}
+}
+
+func TestReasoningConversion(t *testing.T) {
+	respond := func(tm *TransformerManager, contentType, body string) string {
+		t.Helper()
+		res, err := tm.ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {contentType}}, Body: io.NopCloser(strings.NewReader(body))})
+		if err != nil {
+			t.Fatalf("ProcessResponse failed: %v", err)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		return string(data)
+	}
+
+	t.Run("request", func(t *testing.T) {
+		result, err := NewTransformerManager("openai", "anthropic").ProcessRequest(nil, []byte(`{"model":"m","max_tokens":1000,"temperature":0.5,"reasoning_effort":"medium","messages":[{"role":"user","content":"Hi"}]}`))
+		if err != nil {
+			t.Fatalf("ProcessRequest failed: %v", err)
+		}
+		req := gjson.ParseBytes(result)
+		if req.Get("thinking.type").String() != "enabled" || req.Get("thinking.budget_tokens").Int() != 8192 {
+			t.Errorf("Expected medium effort to become an 8192 token budget, got %s", result)
+		}
+		if req.Get("max_tokens").Int() <= req.Get("thinking.budget_tokens").Int() || req.Get("temperature").Exists() {
+			t.Errorf("Expected max_tokens above budget and no temperature, got %s", result)
+		}
+
+		result, err = NewTransformerManager("anthropic", "openai").ProcessRequest(nil, []byte(`{"model":"m","max_tokens":8000,"thinking":{"type":"enabled","budget_tokens":4000},"messages":[{"role":"user","content":"Hi"}]}`))
+		if err != nil {
+			t.Fatalf("ProcessRequest failed: %v", err)
+		}
+		if effort := gjson.GetBytes(result, "reasoning_effort").String(); effort != "medium" {
+			t.Errorf("Expected budget 4000 to become medium effort, got %q", effort)
+		}
+	})
+
+	t.Run("non-stream", func(t *testing.T) {
+		body := respond(NewTransformerManager("anthropic", "openai"), "application/json",
+			`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[{"index":0,"message":{"role":"assistant","reasoning_content":"Think","content":"Answer"},"finish_reason":"stop"}]}`)
+		content := gjson.Get(body, "content").Array()
+		if len(content) != 2 || content[0].Get("type").String() != "thinking" || content[0].Get("thinking").String() != "Think" || content[1].Get("text").String() != "Answer" {
+			t.Errorf("Expected thinking block before text, got %s", body)
+		}
+
+		body = respond(NewTransformerManager("openai", "anthropic"), "application/json",
+			`{"id":"msg_1","model":"claude","role":"assistant","content":[{"type":"thinking","thinking":"Think","signature":"sig"},{"type":"text","text":"Answer"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":2}}`)
+		if message := gjson.Get(body, "choices.0.message"); message.Get("reasoning_content").String() != "Think" || message.Get("content").String() != "Answer" ||
+			message.Get("reasoning_signature").String() != "sig" {
+			t.Errorf("Expected reasoning_content and signature from thinking block, got %s", body)
+		}
+	})
+
+	t.Run("anthropic stream to openai", func(t *testing.T) {
+		stream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude\",\"usage\":{\"input_tokens\":3}}}\n\n" +
+			"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Think\"}}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n" +
+			"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+			"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"Answer\"}}\n\n" +
+			"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n" +
+			"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\n\n" +
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+		events := respond(NewTransformerManager("openai", "anthropic"), "text/event-stream", stream)
+		if !strings.Contains(events, `"reasoning_content":"Think"`) || !strings.Contains(events, `"content":"Answer"`) {
+			t.Errorf("Expected reasoning_content and content deltas, got %s", events)
+		}
+	})
+
+	t.Run("openai stream to anthropic", func(t *testing.T) {
+		stream := "data: {\"id\":\"c1\",\"model\":\"deepseek-reasoner\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"reasoning_content\":\"Think\"}}]}\n\n" +
+			"data: {\"id\":\"c1\",\"model\":\"deepseek-reasoner\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Answer\"}}]}\n\n" +
+			"data: {\"id\":\"c1\",\"model\":\"deepseek-reasoner\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+			"data: [DONE]\n\n"
+		events := respond(NewTransformerManager("anthropic", "openai"), "text/event-stream", stream)
+		thinking := strings.Index(events, `"content_block":{"signature":"","thinking":"","type":"thinking"}`)
+		text := strings.Index(events, `"content_block":{"text":"","type":"text"}`)
+		if thinking < 0 || text < thinking || !strings.Contains(events, `"thinking":"Think","type":"thinking_delta"`) {
+			t.Errorf("Expected thinking block before text block, got %s", events)
+		}
+	})
+}
+
+func TestReasoningStreamRoundTrip(t *testing.T) {
+	stream := func(tm *TransformerManager, body string) []gjson.Result {
+		t.Helper()
+		res, err := tm.ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/event-stream"}}, Body: io.NopCloser(strings.NewReader(body))})
+		if err != nil {
+			t.Fatalf("ProcessResponse failed: %v", err)
+		}
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		var events []gjson.Result
+		for _, line := range strings.Split(string(data), "\n") {
+			if payload, ok := strings.CutPrefix(line, "data: "); ok && payload != "[DONE]" {
+				events = append(events, gjson.Parse(payload))
+			}
+		}
+		return events
+	}
+
+	// Anthropic 上游：思考内容带签名，随后是分段输出参数的工具调用
+	upstream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude\",\"usage\":{\"input_tokens\":3}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\",\"signature\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Need weather\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig-1\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\",\"input\":{}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Paris\\\"}\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":1}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":9}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	chunks := stream(NewTransformerManager("openai", "anthropic"), upstream)
+	var reasoning, signature, arguments, toolID, toolName, finishReason string
+	for _, chunk := range chunks {
+		delta := chunk.Get("choices.0.delta")
+		reasoning += delta.Get("reasoning_content").String()
+		signature += delta.Get("reasoning_signature").String()
+		if call := delta.Get("tool_calls.0"); call.Exists() {
+			if call.Get("index").Int() != 0 {
+				t.Errorf("Expected tool call index 0, got %s", call.Raw)
+			}
+			if id := call.Get("id").String(); id != "" {
+				toolID, toolName = id, call.Get("function.name").String()
+			}
+			arguments += call.Get("function.arguments").String()
+		}
+		if reason := chunk.Get("choices.0.finish_reason").String(); reason != "" {
+			finishReason = reason
+		}
+	}
+	if reasoning != "Need weather" || signature != "sig-1" {
+		t.Errorf("reasoning = %q, signature = %q", reasoning, signature)
+	}
+	if toolID != "toolu_1" || toolName != "get_weather" || arguments != `{"city":"Paris"}` || finishReason != "tool_calls" {
+		t.Errorf("tool call = %s %s %s, finish_reason = %s", toolID, toolName, arguments, finishReason)
+	}
+
+	// 把转换得到的 chat 流作为 OpenAI 兼容上游的输出，再转换回 Anthropic 事件流
+	var chat strings.Builder
+	for _, chunk := range chunks {
+		chat.WriteString("data: " + chunk.Raw + "\n\n")
+	}
+	chat.WriteString("data: [DONE]\n\n")
+	events := stream(NewTransformerManager("anthropic", "openai"), chat.String())
+
+	var types []string
+	blocks := map[int64]string{}
+	var thinking, blockSignature, input, stopReason string
+	for _, event := range events {
+		types = append(types, event.Get("type").String())
+		switch event.Get("type").String() {
+		case "content_block_start":
+			block := event.Get("content_block")
+			blocks[event.Get("index").Int()] = block.Get("type").String()
+			if block.Get("type").String() == "thinking" && !block.Get("signature").Exists() {
+				t.Errorf("Expected thinking block to carry a signature field, got %s", block.Raw)
+			}
+			if block.Get("type").String() == "tool_use" && (block.Get("id").String() != "toolu_1" || block.Get("name").String() != "get_weather") {
+				t.Errorf("Expected tool_use block for get_weather, got %s", block.Raw)
+			}
+		case "content_block_delta":
+			delta := event.Get("delta")
+			switch blocks[event.Get("index").Int()] + "/" + delta.Get("type").String() {
+			case "thinking/thinking_delta":
+				thinking += delta.Get("thinking").String()
+			case "thinking/signature_delta":
+				blockSignature += delta.Get("signature").String()
+			case "tool_use/input_json_delta":
+				input += delta.Get("partial_json").String()
+			default:
+				t.Errorf("Unexpected delta %s for block %d", delta.Raw, event.Get("index").Int())
+			}
+		case "message_delta":
+			stopReason = event.Get("delta.stop_reason").String()
+		}
+	}
+	if blocks[0] != "thinking" || blocks[1] != "tool_use" || len(blocks) != 2 {
+		t.Errorf("Expected thinking block then tool_use block, got %v", blocks)
+	}
+	if thinking != "Need weather" || blockSignature != "sig-1" || input != `{"city":"Paris"}` || stopReason != "tool_use" {
+		t.Errorf("thinking = %q, signature = %q, input = %q, stop_reason = %q", thinking, blockSignature, input, stopReason)
+	}
+	if want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_delta,content_block_stop,message_delta,message_stop"; strings.Join(types, ",") != want {
+		t.Errorf("event types = %v, want %s", types, want)
+	}
+
+	// 客户端回传签名后还原为带签名的 thinking 块
+	result, err := NewTransformerManager("openai", "anthropic").ProcessRequest(context.Background(), []byte(`{"model":"m","max_tokens":100,"messages":[
+		{"role":"user","content":"Weather?"},
+		{"role":"assistant","reasoning_content":"Need weather","reasoning_signature":"sig-1","tool_calls":[{"id":"toolu_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+		{"role":"tool","tool_call_id":"toolu_1","content":"sunny"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	content := gjson.GetBytes(result, "messages.1.content").Array()
+	if len(content) != 2 || content[0].Get("type").String() != "thinking" || content[0].Get("signature").String() != "sig-1" || content[1].Get("type").String() != "tool_use" {
+		t.Errorf("Expected signed thinking block before tool_use, got %s", result)
+	}
+}
+
+func TestImageConversion(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page.html" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	result, err := NewTransformerManager("openai", "anthropic").ProcessRequest(context.Background(), []byte(`{"model":"m","messages":[{"role":"user","content":[
+		{"type":"text","text":"Compare"},
+		{"type":"image_url","image_url":{"url":"data:image/jpeg;base64,AAAA"}},
+		{"type":"image_url","image_url":{"url":"`+server.URL+`/cat.png"},"cache_control":{"type":"ephemeral"}},
+		{"type":"image_url","image_url":{"url":"`+server.URL+`/page.html"}}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	content := gjson.GetBytes(result, "messages.0.content").Array()
+	if len(content) != 4 || content[0].Get("text").String() != "Compare" {
+		t.Fatalf("Expected text and three image blocks, got %s", result)
+	}
+	if source := content[1].Get("source"); source.Get("type").String() != "base64" || source.Get("media_type").String() != "image/jpeg" || source.Get("data").String() != "AAAA" {
+		t.Errorf("Expected data URL to become base64 source, got %s", content[1].Raw)
+	}
+	if source := content[2].Get("source"); source.Get("media_type").String() != "image/png" || source.Get("data").String() != base64.StdEncoding.EncodeToString(png) || !content[2].Get("cache_control").Exists() {
+		t.Errorf("Expected remote image to be inlined with cache_control, got %s", content[2].Raw)
+	}
+	// 不是图片的地址保留为 url 来源
+	if source := content[3].Get("source"); source.Get("type").String() != "url" || source.Get("url").String() != server.URL+"/page.html" {
+		t.Errorf("Expected non-image URL to be kept, got %s", content[3].Raw)
+	}
+
+	result, err = NewTransformerManager("anthropic", "openai").ProcessRequest(context.Background(), []byte(`{"model":"m","max_tokens":100,"messages":[
+		{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"BBBB"}}]},
+		{"role":"assistant","content":[{"type":"text","text":"Let me zoom"},{"type":"tool_use","id":"toolu_1","name":"zoom","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"zoomed"},{"type":"image","source":{"type":"url","url":"https://example.com/z.png"}}]}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	messages := gjson.GetBytes(result, "messages").Array()
+	if len(messages) != 4 {
+		t.Fatalf("Expected user, assistant, tool and user messages, got %s", result)
+	}
+	if url := messages[0].Get("content.1.image_url.url").String(); url != "data:image/png;base64,BBBB" {
+		t.Errorf("Expected base64 image to become data URL, got %s", messages[0].Raw)
+	}
+	if messages[1].Get("content").String() != "Let me zoom" || messages[1].Get("tool_calls.0.id").String() != "toolu_1" {
+		t.Errorf("Expected assistant text without tool_use block, got %s", messages[1].Raw)
+	}
+	if messages[2].Get("role").String() != "tool" || messages[2].Get("tool_call_id").String() != "toolu_1" || messages[2].Get("content").String() != "zoomed" {
+		t.Errorf("Expected tool_result to become tool message, got %s", messages[2].Raw)
+	}
+	if messages[3].Get("content.0.image_url.url").String() != "https://example.com/z.png" {
+		t.Errorf("Expected tool_result image in following user message, got %s", messages[3].Raw)
+	}
+}
+
+func TestStructuredOutputConversion(t *testing.T) {
+	request := []byte(`{"model":"m","messages":[{"role":"user","content":"Weather?"}],
+		"response_format":{"type":"json_schema","json_schema":{"name":"weather","strict":true,"schema":{"type":"object","properties":{"temp":{"type":"number"}}}}}}`)
+
+	result, err := NewTransformerManager("openai", "anthropic").ProcessRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	req := gjson.ParseBytes(result)
+	if req.Get("tool_choice.name").String() != structuredOutputTool || req.Get("tools.0.input_schema.properties.temp.type").String() != "number" {
+		t.Errorf("Expected forced structured output tool, got %s", result)
+	}
+
+	result, err = NewTransformerManager("openai", "openai-res").ProcessRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if format := gjson.GetBytes(result, "text.format"); format.Get("type").String() != "json_schema" || format.Get("name").String() != "weather" || !format.Get("strict").Bool() {
+		t.Errorf("Expected json_schema text.format, got %s", result)
+	}
+
+	result, err = NewTransformerManager("openai-res", "gemini").ProcessRequest(context.Background(), []byte(`{"model":"m","input":"Hi","text":{"format":{"type":"json_object"}}}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if config := gjson.GetBytes(result, "generationConfig"); config.Get("responseMimeType").String() != "application/json" || config.Get("responseSchema").Exists() {
+		t.Errorf("Expected json_object to become JSON mime type, got %s", result)
+	}
+
+	tm := NewTransformerManager("openai", "anthropic")
+	res, err := tm.ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: io.NopCloser(strings.NewReader(
+		`{"id":"msg_1","model":"claude","role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"` + structuredOutputTool + `","input":{"temp":21}}],"stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":2}}`))})
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	if choice := gjson.GetBytes(body, "choices.0"); choice.Get("message.content").String() != `{"temp":21}` || choice.Get("finish_reason").String() != "stop" || choice.Get("message.tool_calls").Exists() {
+		t.Errorf("Expected structured output tool input as content, got %s", body)
+	}
+
+	stream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude\",\"usage\":{\"input_tokens\":3}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"" + structuredOutputTool + "\",\"input\":{}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"temp\\\":\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"21}\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":2}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	res, err = tm.ProcessResponse(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/event-stream"}}, Body: io.NopCloser(strings.NewReader(stream))})
+	if err != nil {
+		t.Fatalf("ProcessResponse failed: %v", err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	events := string(body)
+	if !strings.Contains(events, `"content":"{\"temp\":"`) || !strings.Contains(events, `"content":"21}"`) || strings.Contains(events, "tool_calls") {
+		t.Errorf("Expected structured output streamed as content, got %s", events)
+	}
+}
+
+func TestSamplingParameters(t *testing.T) {
+	request := []byte(`{"model":"m","messages":[{"role":"user","content":"Hi"}],"stop":["END"," "],"frequency_penalty":0.5,"presence_penalty":0.1,"seed":42,"logprobs":true,"top_logprobs":3,"user":"u-1"}`)
+
+	result, err := NewTransformerManager("openai", "anthropic").ProcessRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	req := gjson.ParseBytes(result)
+	if stop := req.Get("stop_sequences").Array(); len(stop) != 1 || stop[0].String() != "END" || req.Get("metadata.user_id").String() != "u-1" {
+		t.Errorf("Expected stop_sequences without blank entries and metadata.user_id, got %s", result)
+	}
+	if req.Get("seed").Exists() || req.Get("frequency_penalty").Exists() {
+		t.Errorf("Expected unsupported parameters to be omitted, got %s", result)
+	}
+
+	result, err = NewTransformerManager("openai", "gemini").ProcessRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	config := gjson.GetBytes(result, "generationConfig")
+	if config.Get("seed").Int() != 42 || config.Get("frequencyPenalty").Float() != 0.5 || config.Get("logprobs").Int() != 3 || len(config.Get("stopSequences").Array()) != 2 {
+		t.Errorf("Expected sampling parameters in generationConfig, got %s", result)
+	}
+
+	result, err = NewTransformerManager("anthropic", "openai").ProcessRequest(context.Background(), []byte(`{"model":"m","max_tokens":10,"stop_sequences":["a","b","c","d","e"],"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("ProcessRequest failed: %v", err)
+	}
+	if stop := gjson.GetBytes(result, "stop").Array(); len(stop) != openAIMaxStop || stop[0].String() != "a" {
+		t.Errorf("Expected stop truncated to %d sequences, got %s", openAIMaxStop, result)
+	}
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+
+	usageDateLayout = "2006-01-02"
+)
+
+// ValidQuotaPeriod 配额周期是否合法，空字符串表示累计到手动重置
+func ValidQuotaPeriod(period string) bool {
+	return period == "" || period == QuotaPeriodDaily || period == QuotaPeriodMonthly
+}
+
+// QuotaWindow 返回配额周期在 t 时刻对应的窗口标识，跨窗口时已用量重新计算
+func QuotaWindow(period string, t time.Time) string {
+	switch period {
+	case QuotaPeriodDaily:
+		return t.Format(usageDateLayout)
+	case QuotaPeriodMonthly:
+		return t.Format("2006-01")
+	default:
+		return ""
+	}
+}
+
+// quotaWindowEnd 返回当前窗口的结束时间，累计配额没有结束时间
+func quotaWindowEnd(period string, t time.Time) (time.Time, bool) {
+	year, month, day := t.Date()
+	switch period {
+	case QuotaPeriodDaily:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, t.Location()), true
+	case QuotaPeriodMonthly:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, t.Location()), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// QuotaError API Key 配额用尽时返回，RetryAfter 为距离配额周期重置的时间，累计配额为 0
+type QuotaError struct {
+	Kind       string // tokens / cost
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return "api key " + e.Kind + " quota exceeded"
+}
+
+// Message 面向客户端的错误信息（可翻译）
+func (e *QuotaError) Message() string {
+	if e.Kind == "cost" {
+		return "API key cost quota exceeded"
+	}
+	return "API key token quota exceeded"
+}
+
+// CheckQuota 校验 API Key 在当前周期的已用量是否超出配额
+func CheckQuota(key *models.APIKey) *QuotaError {
+	if key.TokenQuota <= 0 && key.CostQuota <= 0 {
+		return nil
+	}
+	now := time.Now()
+	// 已用量属于之前的周期，本周期尚未产生用量
+	if key.QuotaWindow != QuotaWindow(key.QuotaPeriod, now) {
+		return nil
+	}
+	var retryAfter time.Duration
+	if end, ok := quotaWindowEnd(key.QuotaPeriod, now); ok {
+		retryAfter = end.Sub(now)
+	}
+	if key.TokenQuota > 0 && key.UsedTokens >= key.TokenQuota {
+		return &QuotaError{Kind: "tokens", RetryAfter: retryAfter}
+	}
+	if key.CostQuota > 0 && key.UsedCost >= key.CostQuota {
+		return &QuotaError{Kind: "cost", RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordUsage 将一次成功请求的用量计入按天聚合的用量记录，并累加到 API Key 的配额已用量
+func RecordUsage(ctx context.Context, log models.ChatLog) error {
+	now := time.Now()
+	// 优先使用定价表计算的费用，未配置定价时按账单单价估算
+	cost := log.Cost
+	if cost == 0 {
+		cost = estimateCost(ctx, log.ProviderName, log.TotalTokens)
+	}
+
+	record := models.UsageRecord{
+		Date:             now.Format(usageDateLayout),
+		APIKeyID:         log.APIKeyID,
+		Model:            log.Name,
+		ProviderName:     log.ProviderName,
+		Requests:         1,
+		PromptTokens:     log.PromptTokens,
+		CompletionTokens: log.CompletionTokens,
+		TotalTokens:      log.TotalTokens,
+		Cost:             cost,
+	}
+	if err := models.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "date"}, {Name: "api_key_id"}, {Name: "model"}, {Name: "provider_name"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"requests":          gorm.Expr("requests + 1"),
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", record.PromptTokens),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", record.CompletionTokens),
+			"total_tokens":      gorm.Expr("total_tokens + ?", record.TotalTokens),
+			"cost":              gorm.Expr("cost + ?", record.Cost),
+		}),
+	}).Create(&record).Error; err != nil {
+		return err
+	}
+
+	if log.APIKeyID == 0 {
+		return nil
+	}
+	key, err := gorm.G[models.APIKey](models.DB).Where("id = ?", log.APIKeyID).First(ctx)
+	if err != nil {
+		// 请求过程中 key 被删除
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	window := QuotaWindow(key.QuotaPeriod, now)
+	// 周期切换时已用量从本次用量重新开始，用 CASE 保证并发累加的原子性
+	if err := models.DB.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", key.ID).Updates(map[string]any{
+		"used_tokens":  gorm.Expr("CASE WHEN quota_window = ? THEN used_tokens + ? ELSE ? END", window, log.TotalTokens, log.TotalTokens),
+		"used_cost":    gorm.Expr("CASE WHEN quota_window = ? THEN used_cost + ? ELSE ? END", window, cost, cost),
+		"quota_window": window,
+	}).Error; err != nil {
+		return err
+	}
+
+	// 本次用量使配额用尽时通知一次
+	var usedTokens int64
+	var usedCost float64
+	if key.QuotaWindow == window {
+		usedTokens, usedCost = key.UsedTokens, key.UsedCost
+	}
+	switch {
+	case key.TokenQuota > 0 && usedTokens < key.TokenQuota && usedTokens+log.TotalTokens >= key.TokenQuota:
+		notifyQuotaExhausted(ctx, key, fmt.Sprintf("token quota %d", key.TokenQuota))
+	case key.CostQuota > 0 && usedCost < key.CostQuota && usedCost+cost >= key.CostQuota:
+		notifyQuotaExhausted(ctx, key, fmt.Sprintf("cost quota %.4f", key.CostQuota))
+	}
+	return nil
+}
+
+// notifyQuotaExhausted API Key 配额用尽时通知
+func notifyQuotaExhausted(ctx context.Context, key models.APIKey, quota string) {
+	name := key.Label
+	if name == "" {
+		name = key.KeyPrefix
+	}
+	period := key.QuotaPeriod
+	if period == "" {
+		period = "until reset"
+	}
+	Notify(ctx, NotificationEvent{
+		Event:   NotifyEventQuotaExhausted,
+		Title:   "API key quota exhausted: " + name,
+		Message: fmt.Sprintf("API key %s (id %d) used up its %s (%s), further requests are rejected", name, key.ID, quota, period),
+		APIKey:  name,
+	})
+}
+
+// ResetQuota 清零 API Key 当前周期的已用量
+func ResetQuota(ctx context.Context, id uint) (int64, error) {
+	key, err := gorm.G[models.APIKey](models.DB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	result := models.DB.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
+		"used_tokens":  0,
+		"used_cost":    0,
+		"quota_window": QuotaWindow(key.QuotaPeriod, time.Now()),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// estimateCost 按供应商最近一期账单的有效单价估算费用，未导入账单时为 0
+func estimateCost(ctx context.Context, providerName string, tokens int64) float64 {
+	if tokens <= 0 {
+		return 0
+	}
+	records, err := gorm.G[models.BillingRecord](models.DB).Where("provider_name = ?", providerName).Order("month DESC").Find(ctx)
+	if err != nil || len(records) == 0 {
+		return 0
+	}
+	var cost float64
+	var billed int64
+	for _, record := range records {
+		if record.Month != records[0].Month {
+			break
+		}
+		cost += record.Cost
+		billed += record.TotalTokens
+	}
+	return pricePerMillion(cost, billed) * float64(tokens) / 1e6
+}
+
+// UsageFilter 用量查询条件，日期格式为 2006-01-02，为空表示不限制
+type UsageFilter struct {
+	Start        string
+	End          string
+	APIKeyID     uint
+	Model        string
+	ProviderName string
+	GroupBy      []string // date / api_key_id / model / provider_name，为空时返回明细
+}
+
+// UsageGroupColumns 允许分组的维度
+var UsageGroupColumns = []string{"date", "api_key_id", "model", "provider_name"}
+
+// UsageSummary 用量汇总，未参与分组的维度为零值
+type UsageSummary struct {
+	Date             string  `json:"date,omitempty"`
+	APIKeyID         uint    `json:"api_key_id,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	ProviderName     string  `json:"provider_name,omitempty"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// QueryUsage 按条件查询用量，返回分组结果与总计
+func QueryUsage(ctx context.Context, filter UsageFilter) ([]UsageSummary, UsageSummary, error) {
+	query := func() *gorm.DB {
+		q := models.DB.WithContext(ctx).Model(&models.UsageRecord{})
+		if filter.Start != "" {
+			q = q.Where("date >= ?", filter.Start)
+		}
+		if filter.End != "" {
+			q = q.Where("date <= ?", filter.End)
+		}
+		if filter.APIKeyID != 0 {
+			q = q.Where("api_key_id = ?", filter.APIKeyID)
+		}
+		if filter.Model != "" {
+			q = q.Where("model = ?", filter.Model)
+		}
+		if filter.ProviderName != "" {
+			q = q.Where("provider_name = ?", filter.ProviderName)
+		}
+		return q
+	}
+	const sums = "COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+		"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, COALESCE(SUM(total_tokens), 0) AS total_tokens, COALESCE(SUM(cost), 0) AS cost"
+
+	groupBy := filter.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = UsageGroupColumns
+	}
+	for _, column := range groupBy {
+		if !slices.Contains(UsageGroupColumns, column) {
+			return nil, UsageSummary{}, fmt.Errorf("invalid group_by: %s", column)
+		}
+	}
+	columns := strings.Join(groupBy, ", ")
+
+	rows := make([]UsageSummary, 0)
+	if err := query().Select(fmt.Sprintf("%s, %s", columns, sums)).Group(columns).Order("SUM(total_tokens) DESC").Scan(&rows).Error; err != nil {
+		return nil, UsageSummary{}, err
+	}
+	var total UsageSummary
+	if err := query().Select(sums).Scan(&total).Error; err != nil {
+		return nil, UsageSummary{}, err
+	}
+	return rows, total, nil
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"gorm.io/gorm"
+)
+
+type compiledUARule struct {
+	re    *regexp.Regexp
+	label string
+}
+
+// uaRules 已编译的归一化规则缓存，nil 表示尚未加载
+var uaRules atomic.Pointer[[]compiledUARule]
+
+// ReloadUserAgentRules 从数据库重新加载用户代理归一化规则，规则增删改后调用
+func ReloadUserAgentRules(ctx context.Context) error {
+	rules, err := gorm.G[models.UserAgentRule](models.DB).Order("sort ASC, id ASC").Find(ctx)
+	if err != nil {
+		return err
+	}
+	compiled := make([]compiledUARule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			slog.Warn("invalid user agent rule", "id", rule.ID, "pattern", rule.Pattern, "error", err)
+			continue
+		}
+		compiled = append(compiled, compiledUARule{re: re, label: rule.Label})
+	}
+	uaRules.Store(&compiled)
+	return nil
+}
+
+// NormalizeUserAgent 按归一化规则将用户代理替换为标签，未命中任何规则时原样返回
+func NormalizeUserAgent(ctx context.Context, userAgent string) string {
+	rules := uaRules.Load()
+	if rules == nil {
+		if err := ReloadUserAgentRules(ctx); err != nil {
+			slog.Error("load user agent rules error", "error", err)
+			return userAgent
+		}
+		rules = uaRules.Load()
+	}
+	return applyUARules(*rules, userAgent)
+}
+
+func applyUARules(rules []compiledUARule, userAgent string) string {
+	for _, rule := range rules {
+		match := rule.re.FindStringSubmatchIndex(userAgent)
+		if match == nil {
+			continue
+		}
+		return string(rule.re.ExpandString(nil, rule.label, userAgent, match))
+	}
+	return userAgent
+}
+
+// UARelabelStatus 历史日志重新归一化任务的进度
+type UARelabelStatus struct {
+	Status     string     `json:"status"`  // running / completed / failed
+	Scanned    int        `json:"scanned"` // 已处理的不重复用户代理数
+	Updated    int64      `json:"updated"` // 已更新的日志条数
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+var (
+	uaRelabelMu     sync.Mutex
+	uaRelabelStatus *UARelabelStatus
+)
+
+// StartUserAgentRelabel 在后台按当前规则重新归一化历史日志中的用户代理，同一时间只允许一个任务
+func StartUserAgentRelabel(ctx context.Context) (*UARelabelStatus, error) {
+	if err := ReloadUserAgentRules(ctx); err != nil {
+		return nil, err
+	}
+
+	uaRelabelMu.Lock()
+	defer uaRelabelMu.Unlock()
+	if uaRelabelStatus != nil && uaRelabelStatus.Status == "running" {
+		return nil, errors.New("a relabel job is already running")
+	}
+	uaRelabelStatus = &UARelabelStatus{Status: "running", StartedAt: time.Now()}
+	status := *uaRelabelStatus
+
+	// 任务需要在请求结束后继续执行
+	go runUserAgentRelabel(context.Background())
+	return &status, nil
+}
+
+// GetUserAgentRelabel 获取当前或最近一次重新归一化任务的进度
+func GetUserAgentRelabel() (*UARelabelStatus, bool) {
+	uaRelabelMu.Lock()
+	defer uaRelabelMu.Unlock()
+	if uaRelabelStatus == nil {
+		return nil, false
+	}
+	status := *uaRelabelStatus
+	return &status, true
+}
+
+func runUserAgentRelabel(ctx context.Context) {
+	err := relabelUserAgents(ctx)
+
+	uaRelabelMu.Lock()
+	defer uaRelabelMu.Unlock()
+	now := time.Now()
+	uaRelabelStatus.FinishedAt = &now
+	if err != nil {
+		uaRelabelStatus.Status = "failed"
+		uaRelabelStatus.Error = err.Error()
+		slog.Error("user agent relabel error", "error", err)
+		return
+	}
+	uaRelabelStatus.Status = "completed"
+	slog.Info("user agent relabel completed", "scanned", uaRelabelStatus.Scanned, "updated", uaRelabelStatus.Updated)
+}
+
+// relabelUserAgents 按不重复的用户代理逐个更新，避免全表加载
+func relabelUserAgents(ctx context.Context) error {
+	var userAgents []string
+	if err := models.DB.WithContext(ctx).Model(&models.ChatLog{}).
+		Where("user_agent IS NOT NULL AND user_agent != ''").
+		Distinct("user_agent").
+		Pluck("user_agent", &userAgents).Error; err != nil {
+		return err
+	}
+
+	rules := *uaRules.Load()
+	for _, userAgent := range userAgents {
+		label := applyUARules(rules, userAgent)
+		var updated int64
+		if label != userAgent {
+			rows, err := gorm.G[models.ChatLog](models.DB).Where("user_agent = ?", userAgent).Update(ctx, "user_agent", label)
+			if err != nil {
+				return err
+			}
+			updated = int64(rows)
+		}
+
+		uaRelabelMu.Lock()
+		uaRelabelStatus.Scanned++
+		uaRelabelStatus.Updated += updated
+		uaRelabelMu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/atopos31/llmio/testutil"
+	"gorm.io/gorm"
+)
+
+func TestNormalizeUserAgent(t *testing.T) {
+	testutil.SetupDB(t)
+	ctx := context.Background()
+	t.Cleanup(func() { uaRules.Store(nil) })
+
+	rules := []models.UserAgentRule{
+		{Pattern: `^claude-cli/(\d+)\.`, Label: "claude-cli/$1", Sort: 2},
+		{Pattern: `^OpenAI/Python`, Label: "openai-python", Sort: 1},
+		{Pattern: `^claude-cli/0\.`, Label: "claude-cli-legacy", Sort: 1},
+		{Pattern: `(`, Label: "invalid", Sort: 0}, // 无效正则被跳过
+	}
+	if err := gorm.G[models.UserAgentRule](models.DB).CreateInBatches(ctx, &rules, len(rules)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadUserAgentRules(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"claude-cli/1.0.30 (external, cli)": "claude-cli/1",
+		"claude-cli/0.2.9 (external, cli)":  "claude-cli-legacy", // Sort 较小的规则先匹配
+		"OpenAI/Python 1.82.0":              "openai-python",
+		"curl/8.5.0":                        "curl/8.5.0",
+		"":                                  "",
+	}
+	for userAgent, want := range cases {
+		if got := NormalizeUserAgent(ctx, userAgent); got != want {
+			t.Errorf("NormalizeUserAgent(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+
+	logs := []models.ChatLog{{UserAgent: "OpenAI/Python 1.82.0"}, {UserAgent: "OpenAI/Python 1.82.0"}, {UserAgent: "curl/8.5.0"}, {UserAgent: "openai-python"}}
+	if err := models.DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartUserAgentRelabel(ctx); err != nil {
+		t.Fatalf("StartUserAgentRelabel failed: %v", err)
+	}
+	var status *UARelabelStatus
+	for range 100 {
+		if status, _ = GetUserAgentRelabel(); status.Status != "running" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != "completed" || status.Scanned != 3 || status.Updated != 2 || status.FinishedAt == nil {
+		t.Fatalf("status = %+v", status)
+	}
+	var relabeled []string
+	if err := models.DB.Model(&models.ChatLog{}).Order("id").Pluck("user_agent", &relabeled).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"openai-python", "openai-python", "curl/8.5.0", "openai-python"}; !slices.Equal(relabeled, want) {
+		t.Errorf("user agents = %v, want %v", relabeled, want)
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/atopos31/llmio/tokenizer"
+	"github.com/tidwall/gjson"
+)
+
+// streamTextPaths 各格式流式 chunk 中承载生成文本的字段
+var streamTextPaths = []string{
+	"choices.0.delta.content",
+	"choices.0.delta.reasoning_content",
+	"delta.text",
+	"delta.thinking",
+	"delta.partial_json",
+	"choices.0.delta.tool_calls.0.function.arguments",
+}
+
+// OutputLimitError 输出超出模型上限
+type OutputLimitError struct {
+	Kind  string // tokens or bytes
+	Limit int64
+	Value int64
+}
+
+func (e *OutputLimitError) Error() string {
+	return fmt.Sprintf("output watchdog aborted response: %s %d exceeds limit %d", e.Kind, e.Value, e.Limit)
+}
+
+// outputWatchdog 统计上游输出的字节数与估算 token 数，超出上限时中断读取。
+// token 数先按每 4 字节 1 个 token 粗略估算，估算值达到上限一半后才对已输出的文本精确分词，避免每个 chunk 都经过 BPE
+type outputWatchdog struct {
+	reader    io.Reader
+	model     string
+	maxTokens int64
+	maxBytes  int64
+
+	bytes   int64
+	tokens  int64
+	partial []byte
+	err     error // 超出上限后的错误，之后的读取直接返回
+
+	pending []byte             // 估算阶段累积的文本，转为精确计数时一次性分词
+	counter *tokenizer.Counter // 非 nil 表示已转为精确计数
+}
+
+// NewOutputWatchdog 为上游响应包装输出看门狗，maxTokens/maxBytes 为 0 表示不限制
+func NewOutputWatchdog(reader io.Reader, model string, maxTokens, maxBytes int64) io.Reader {
+	if maxTokens <= 0 && maxBytes <= 0 {
+		return reader
+	}
+	return &outputWatchdog{
+		reader:    reader,
+		model:     model,
+		maxTokens: maxTokens,
+		maxBytes:  maxBytes,
+	}
+}
+
+// Read 超出上限时仍返回本次读取的数据（字节上限截断到上限处），让客户端收到中断前的最后一段输出
+func (w *outputWatchdog) Read(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	w.bytes += int64(n)
+	if w.maxBytes > 0 && w.bytes > w.maxBytes {
+		w.err = &OutputLimitError{Kind: "bytes", Limit: w.maxBytes, Value: w.bytes}
+		return n - int(w.bytes-w.maxBytes), w.err
+	}
+	if w.maxTokens > 0 {
+		w.countTokens(p[:n])
+		if w.tokens > w.maxTokens {
+			w.err = &OutputLimitError{Kind: "tokens", Limit: w.maxTokens, Value: w.tokens}
+			return n, w.err
+		}
+	}
+	return n, err
+}
+
+// countTokens 按行解析 SSE data，累计生成文本的 token 数
+func (w *outputWatchdog) countTokens(chunk []byte) {
+	w.partial = append(w.partial, chunk...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx < 0 {
+			return
+		}
+		line := bytes.TrimSpace(w.partial[:idx])
+		w.partial = w.partial[idx+1:]
+
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if !gjson.ValidBytes(data) {
+			continue
+		}
+		for _, path := range streamTextPaths {
+			if text := gjson.GetBytes(data, path); text.Type == gjson.String {
+				w.addText(text.Str)
+			}
+		}
+		// Responses API 的增量文本位于顶层 delta 字段
+		if delta := gjson.GetBytes(data, "delta"); delta.Type == gjson.String {
+			w.addText(delta.Str)
+		}
+	}
+}
+
+// addText 累计一段生成文本的 token 数
+func (w *outputWatchdog) addText(text string) {
+	if text == "" {
+		return
+	}
+	if w.counter != nil {
+		if count, err := w.counter.Count(text); err == nil {
+			w.tokens += int64(count)
+		}
+		return
+	}
+	w.pending = append(w.pending, text...)
+	w.tokens += int64(len(text)+3) / 4
+	if w.tokens*2 < w.maxTokens {
+		return
+	}
+	// 接近上限，改为精确计数；分词器不可用时沿用估算值
+	counter, err := tokenizer.NewCounter(w.model)
+	if err != nil {
+		return
+	}
+	if count, err := counter.Count(string(w.pending)); err == nil {
+		w.tokens = int64(count)
+	}
+	w.counter, w.pending = counter, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestOutputWatchdog(t *testing.T) {
+	chunk := func(path, text string) string {
+		switch path {
+		case "anthropic":
+			return "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"" + text + "\"}}\n\n"
+		case "responses":
+			return "event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"" + text + "\"}\n\n"
+		default:
+			return "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"" + text + "\"}}]}\n\n"
+		}
+	}
+	repeat := func(path string, n int) string {
+		return strings.Repeat(chunk(path, "hello world"), n) // 每个 chunk 2 个 token
+	}
+
+	tests := []struct {
+		name      string
+		stream    string
+		maxTokens int64
+		maxBytes  int64
+		wantKind  string // 为空表示完整读取
+	}{
+		{name: "no limits", stream: repeat("openai", 100)},
+		{name: "under token limit", stream: repeat("openai", 5), maxTokens: 10},
+		{name: "openai tokens", stream: repeat("openai", 6), maxTokens: 10, wantKind: "tokens"},
+		{name: "anthropic tokens", stream: repeat("anthropic", 6), maxTokens: 10, wantKind: "tokens"},
+		{name: "responses tokens", stream: repeat("responses", 6), maxTokens: 10, wantKind: "tokens"},
+		{name: "bytes", stream: repeat("openai", 10), maxBytes: 100, wantKind: "bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := io.ReadAll(NewOutputWatchdog(strings.NewReader(tt.stream), "gpt-4", tt.maxTokens, tt.maxBytes))
+			if tt.wantKind == "" {
+				if err != nil || string(data) != tt.stream {
+					t.Fatalf("Expected stream to pass through, got %d bytes, err = %v", len(data), err)
+				}
+				return
+			}
+			var limitErr *OutputLimitError
+			if !errors.As(err, &limitErr) || limitErr.Kind != tt.wantKind || limitErr.Value <= limitErr.Limit {
+				t.Fatalf("Expected %s limit error, got %v", tt.wantKind, err)
+			}
+			// 中断前已读取的数据仍交给客户端，字节上限截断到上限处
+			if len(data) == 0 || !strings.HasPrefix(tt.stream, string(data)) {
+				t.Errorf("data before abort = %q", data)
+			}
+			if tt.maxBytes > 0 && int64(len(data)) != tt.maxBytes {
+				t.Errorf("data = %d bytes, want %d", len(data), tt.maxBytes)
+			}
+		})
+	}
+}
+
+func TestOutputWatchdogEstimate(t *testing.T) {
+	// 每个 chunk 11 字节文本，估算 3 个 token，实际 2 个
+	stream := strings.Repeat("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello world\"}}]}\n\n", 10)
+	tests := []struct {
+		name      string
+		maxTokens int64
+		exact     bool  // 是否已转为精确计数
+		tokens    int64 // 读完后的 token 数
+	}{
+		{name: "far below limit", maxTokens: 1000, exact: false, tokens: 30},
+		{name: "near limit", maxTokens: 50, exact: true, tokens: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewOutputWatchdog(strings.NewReader(stream), "gpt-4", tt.maxTokens, 0).(*outputWatchdog)
+			if _, err := io.ReadAll(w); err != nil {
+				t.Fatal(err)
+			}
+			if (w.counter != nil) != tt.exact || w.tokens != tt.tokens {
+				t.Errorf("exact = %v, tokens = %d, want %v, %d", w.counter != nil, w.tokens, tt.exact, tt.tokens)
+			}
+		})
+	}
+}
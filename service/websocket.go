@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpClose        = 0x8
+)
+
+// wsFrame 一个 WebSocket 帧，raw 为帧的原始字节，用于原样转发
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte // 已去除掩码
+	raw     []byte
+}
+
+// readWSFrame 读取一个完整的 WebSocket 帧
+func readWSFrame(r *bufio.Reader) (*wsFrame, error) {
+	head := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	frame := &wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		head = append(head, ext...)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		head = append(head, ext...)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > 1<<31 {
+		return nil, errors.New("websocket frame too large")
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+		head = append(head, mask...)
+	}
+
+	frame.raw = make([]byte, len(head)+int(length))
+	copy(frame.raw, head)
+	if _, err := io.ReadFull(r, frame.raw[len(head):]); err != nil {
+		return nil, err
+	}
+	frame.payload = frame.raw[len(head):]
+	if masked {
+		frame.payload = make([]byte, length)
+		for i, b := range frame.raw[len(head):] {
+			frame.payload[i] = b ^ mask[i%4]
+		}
+	}
+	return frame, nil
+}
+
+// wsNewKey 生成握手使用的 Sec-WebSocket-Key
+func wsNewKey() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// WSAcceptKey 按 RFC 6455 计算握手响应的 Sec-WebSocket-Accept
+func WSAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,148 @@
+// Package testutil 提供测试用的内存数据库、数据填充与假上游服务
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atopos31/llmio/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+const TestAPIKey = "test-upstream-key"
+
+var (
+	dbOnce          sync.Once
+	defaultSettings []models.Setting
+)
+
+// SetupDB 准备干净的内存 SQLite：首次调用时完成迁移，之后每个测试清空全部表并恢复默认设置。
+// 复用同一个 models.DB，避免上一个测试遗留的异步日志协程与替换全局变量产生竞争
+func SetupDB(t testing.TB) {
+	t.Helper()
+	ctx := context.Background()
+	dbOnce.Do(func() {
+		// 共享缓存让连接池中的连接都访问同一个内存库
+		models.InitDB(ctx, sqlite.Open("file:llmio-test?mode=memory&cache=shared"))
+		if sqlDB, err := models.DB.DB(); err == nil {
+			// 共享缓存下并发写入会返回 table is locked，限制为单连接串行执行
+			sqlDB.SetMaxOpenConns(1)
+		}
+		defaultSettings, _ = gorm.G[models.Setting](models.DB).Find(ctx)
+	})
+
+	tables, err := models.DB.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	for _, table := range tables {
+		if err := models.DB.Exec("DELETE FROM " + table).Error; err != nil {
+			t.Fatalf("truncate %s: %v", table, err)
+		}
+	}
+	if len(defaultSettings) > 0 {
+		settings := append([]models.Setting(nil), defaultSettings...)
+		if err := models.DB.Create(&settings).Error; err != nil {
+			t.Fatalf("restore default settings: %v", err)
+		}
+	}
+}
+
+// ModelOption 调整填充的模型配置
+type ModelOption func(*models.Model)
+
+// SeedModel 创建模型，默认重试 3 次、超时 10 秒、不记录 IO
+func SeedModel(t testing.TB, name string, opts ...ModelOption) models.Model {
+	t.Helper()
+	ioLog := false
+	model := models.Model{Name: name, MaxRetry: 3, TimeOut: 10, IOLog: &ioLog}
+	for _, opt := range opts {
+		opt(&model)
+	}
+	if err := gorm.G[models.Model](models.DB).Create(context.Background(), &model); err != nil {
+		t.Fatalf("seed model %s: %v", name, err)
+	}
+	return model
+}
+
+// SeedProvider 创建指向 baseURL 的供应商，providerType 为 consts.Style* 之一
+func SeedProvider(t testing.TB, name, providerType, baseURL string) models.Provider {
+	t.Helper()
+	config, err := json.Marshal(map[string]string{"base_url": baseURL, "api_key": TestAPIKey})
+	if err != nil {
+		t.Fatalf("marshal provider config: %v", err)
+	}
+	provider := models.Provider{Name: name, Type: providerType, Config: string(config)}
+	if err := gorm.G[models.Provider](models.DB).Create(context.Background(), &provider); err != nil {
+		t.Fatalf("seed provider %s: %v", name, err)
+	}
+	return provider
+}
+
+// SeedAssociation 创建启用状态的模型-供应商关联
+func SeedAssociation(t testing.TB, model models.Model, provider models.Provider, providerModel string, priority, weight int) models.ModelWithProvider {
+	t.Helper()
+	enabled, disabled := true, false
+	association := models.ModelWithProvider{
+		ModelID:          model.ID,
+		ProviderID:       provider.ID,
+		ProviderModel:    providerModel,
+		ToolCall:         &enabled,
+		StructuredOutput: &enabled,
+		Image:            &enabled,
+		WithHeader:       &disabled,
+		Status:           &enabled,
+		CustomerHeaders:  map[string]string{},
+		Weight:           weight,
+		Priority:         priority,
+	}
+	if err := gorm.G[models.ModelWithProvider](models.DB).Create(context.Background(), &association); err != nil {
+		t.Fatalf("seed association %s -> %s: %v", model.Name, provider.Name, err)
+	}
+	return association
+}
+
+// WaitForLogs 等待至少 n 条请求日志完成异步写入（processer 写入耗时后视为完成），超时则测试失败
+func WaitForLogs(t testing.TB, n int) []models.ChatLog {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logs, err := gorm.G[models.ChatLog](models.DB).Order("id ASC").Find(context.Background())
+		if err != nil {
+			t.Fatalf("query chat logs: %v", err)
+		}
+		done := 0
+		for _, log := range logs {
+			if log.Status == "error" || log.Status == "cancelled" || log.ChunkTime > 0 {
+				done++
+			}
+		}
+		if done >= n {
+			return logs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d chat logs, got %d of %d", n, done, len(logs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WaitForChatIO 等待指定日志的输入输出记录写入，超时返回 nil
+func WaitForChatIO(t testing.TB, logID uint) *models.ChatIO {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		chatIO, err := gorm.G[models.ChatIO](models.DB).Where("log_id = ?", logID).First(context.Background())
+		if err == nil {
+			return &chatIO
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
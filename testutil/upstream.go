@@ -0,0 +1,100 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordedRequest 假上游收到的请求
+type RecordedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Upstream 假上游服务，记录收到的请求并交给 handler 处理
+type Upstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []RecordedRequest
+}
+
+// NewUpstream 启动假上游，测试结束时关闭
+func NewUpstream(t testing.TB, handler http.HandlerFunc) *Upstream {
+	t.Helper()
+	u := &Upstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		u.mu.Lock()
+		u.requests = append(u.requests, RecordedRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		u.mu.Unlock()
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		handler(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// Requests 返回已收到的请求
+func (u *Upstream) Requests() []RecordedRequest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]RecordedRequest(nil), u.requests...)
+}
+
+// JSON 以固定状态码返回 JSON 响应体
+func JSON(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// SSE 按顺序输出 data 事件，events 为已序列化的 JSON 或 [DONE]
+func SSE(events ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+}
+
+// OpenAIChatResponse 构造非流式 chat.completion 响应
+func OpenAIChatResponse(model, content string, promptTokens, completionTokens int) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion","created":1700000000,"model":%q,`+
+		`"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],`+
+		`"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		model, content, promptTokens, completionTokens, promptTokens+completionTokens)
+}
+
+// OpenAIChatStream 构造流式 chat.completion.chunk 事件，最后附带 usage 与 [DONE]
+func OpenAIChatStream(model string, promptTokens, completionTokens int, deltas ...string) []string {
+	events := make([]string, 0, len(deltas)+3)
+	for i, delta := range deltas {
+		role := ""
+		if i == 0 {
+			role = `"role":"assistant",`
+		}
+		events = append(events, fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion.chunk","created":1700000000,"model":%q,`+
+			`"choices":[{"index":0,"delta":{%s"content":%q},"finish_reason":null}]}`, model, role, delta))
+	}
+	events = append(events,
+		fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion.chunk","created":1700000000,"model":%q,"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`, model),
+		fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion.chunk","created":1700000000,"model":%q,"choices":[],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+			model, promptTokens, completionTokens, promptTokens+completionTokens),
+		"[DONE]",
+	)
+	return events
+}
@@ -0,0 +1,106 @@
+package tokenizer
+
+import (
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+const (
+	EncodingO200k  = "o200k_base"
+	EncodingCl100k = "cl100k_base"
+	// EncodingClaude Anthropic 未公开分词器，以 cl100k 结果按系数放大近似
+	EncodingClaude = "claude_approx"
+
+	claudeFactor = 1.1
+)
+
+var (
+	codecs   = map[string]tokenizer.Codec{}
+	codecsMu sync.Mutex
+)
+
+// EncodingForModel 根据模型名选择分词方式
+func EncodingForModel(model string) string {
+	m := strings.ToLower(model)
+	switch {
+	case strings.HasPrefix(m, "claude"):
+		return EncodingClaude
+	case strings.HasPrefix(m, "gpt-4o"), strings.HasPrefix(m, "gpt-4.1"), strings.HasPrefix(m, "gpt-5"),
+		strings.HasPrefix(m, "chatgpt-4o"), strings.HasPrefix(m, "o1"), strings.HasPrefix(m, "o3"), strings.HasPrefix(m, "o4"):
+		return EncodingO200k
+	default:
+		return EncodingCl100k
+	}
+}
+
+// Count 统计文本 token 数，encoding 为空时按模型自动选择；返回实际使用的分词方式
+func Count(model, encoding, text string) (int, string, error) {
+	if encoding == "" {
+		encoding = EncodingForModel(model)
+	}
+	if text == "" {
+		return 0, encoding, nil
+	}
+	counter, err := newCounter(encoding)
+	if err != nil {
+		return 0, encoding, err
+	}
+	count, err := counter.Count(text)
+	return count, encoding, err
+}
+
+// Counter 绑定分词方式的计数器，需要反复计数的调用方（如流式输出）持有同一个实例，避免每次查找缓存的分词器
+type Counter struct {
+	codec  tokenizer.Codec
+	claude bool
+}
+
+// NewCounter 按模型选择分词方式创建计数器
+func NewCounter(model string) (*Counter, error) {
+	return newCounter(EncodingForModel(model))
+}
+
+func newCounter(encoding string) (*Counter, error) {
+	base := encoding
+	if encoding == EncodingClaude {
+		base = EncodingCl100k
+	}
+	codec, err := getCodec(base)
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{codec: codec, claude: encoding == EncodingClaude}, nil
+}
+
+// Count 统计文本 token 数
+func (c *Counter) Count(text string) (int, error) {
+	if text == "" {
+		return 0, nil
+	}
+	count, err := c.codec.Count(text)
+	if err != nil {
+		return 0, err
+	}
+	if c.claude {
+		count = int(math.Ceil(float64(count) * claudeFactor))
+	}
+	return count, nil
+}
+
+// getCodec 分词器初始化开销较大，按编码缓存复用
+func getCodec(encoding string) (tokenizer.Codec, error) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if codec, ok := codecs[encoding]; ok {
+		return codec, nil
+	}
+	codec, err := tokenizer.Get(tokenizer.Encoding(encoding))
+	if err != nil {
+		return nil, err
+	}
+	codecs[encoding] = codec
+	return codec, nil
+}
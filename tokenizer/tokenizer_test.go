@@ -0,0 +1,75 @@
+package tokenizer
+
+import "testing"
+
+func TestEncodingForModel(t *testing.T) {
+	cases := map[string]string{
+		"claude-sonnet-4": EncodingClaude,
+		"GPT-4o-mini":     EncodingO200k,
+		"gpt-4.1":         EncodingO200k,
+		"o3-mini":         EncodingO200k,
+		"gpt-4-turbo":     EncodingCl100k,
+		"deepseek-chat":   EncodingCl100k,
+	}
+	for model, want := range cases {
+		if got := EncodingForModel(model); got != want {
+			t.Errorf("EncodingForModel(%q) = %s, want %s", model, got, want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		encoding     string
+		text         string
+		tokens       int
+		wantEncoding string
+	}{
+		{name: "cl100k", model: "gpt-4", text: "hello world", tokens: 2, wantEncoding: EncodingCl100k},
+		{name: "o200k", model: "gpt-4o", text: "hello world", tokens: 2, wantEncoding: EncodingO200k},
+		{name: "claude approximation rounds up", model: "claude-3-opus", text: "hello world", tokens: 3, wantEncoding: EncodingClaude},
+		{name: "explicit encoding wins", model: "claude-3-opus", encoding: EncodingCl100k, text: "hello world", tokens: 2, wantEncoding: EncodingCl100k},
+		{name: "empty text", model: "gpt-4o", text: "", tokens: 0, wantEncoding: EncodingO200k},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, encoding, err := Count(tt.model, tt.encoding, tt.text)
+			if err != nil {
+				t.Fatalf("Count failed: %v", err)
+			}
+			if tokens != tt.tokens || encoding != tt.wantEncoding {
+				t.Errorf("Count = %d %s, want %d %s", tokens, encoding, tt.tokens, tt.wantEncoding)
+			}
+		})
+	}
+
+	if _, _, err := Count("m", "p50k_unknown", "hi"); err == nil {
+		t.Error("Expected unknown encoding to fail")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	tests := []struct {
+		model  string
+		text   string
+		tokens int
+	}{
+		{model: "gpt-4", text: "hello world", tokens: 2},
+		{model: "claude-3-opus", text: "hello world", tokens: 3},
+		{model: "gpt-4o", text: "", tokens: 0},
+	}
+	for _, tt := range tests {
+		counter, err := NewCounter(tt.model)
+		if err != nil {
+			t.Fatalf("NewCounter(%q) failed: %v", tt.model, err)
+		}
+		// 计数器可重复使用
+		for range 2 {
+			if tokens, err := counter.Count(tt.text); err != nil || tokens != tt.tokens {
+				t.Errorf("%s Count(%q) = %d, %v, want %d", tt.model, tt.text, tokens, err, tt.tokens)
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ import { Suspense, lazy } from 'react';
 import { ThemeProvider } from "@/components/theme-provider"
 import Loading from "@/components/loading"
 import { Toaster } from './components/ui/sonner';
+import { BASE_PATH } from './lib/base-path';
 
 // 懒加载路由组件
 const Layout = lazy(() => import('./routes/layout'));
@@ -26,7 +27,7 @@ const PageLoader = () => (
 function App() {
   return (
     <ThemeProvider defaultTheme="system" storageKey="vite-ui-theme">
-      <Router>
+      <Router basename={BASE_PATH || undefined}>
         <Suspense fallback={<PageLoader />}>
           <Routes>
             <Route path="/login" element={<LoginPage />} />
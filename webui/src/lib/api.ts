@@ -1,6 +1,8 @@
 // API client for interacting with the backend
 
-const API_BASE = '/api';
+import { BASE_PATH } from './base-path';
+
+const API_BASE = `${BASE_PATH}/api`;
 
 export interface Provider {
   ID: number;
@@ -80,7 +82,7 @@ async function apiRequest<T>(endpoint: string, options: RequestInit = {}): Promi
   // Handle 401 Unauthorized response
   if (response.status === 401) {
     // Redirect to login page
-    window.location.href = '/login';
+    window.location.href = `${BASE_PATH}/login`;
     throw new Error('Unauthorized');
   }
 
@@ -95,6 +97,41 @@ async function apiRequest<T>(endpoint: string, options: RequestInit = {}): Promi
   return data.data as T;
 }
 
+// 当前浏览器的 OIDC 登录状态
+export interface AuthSession {
+  oidc_enabled: boolean;
+  authenticated: boolean;
+  email?: string;
+  name?: string;
+  read_only: boolean;
+  expires_at?: string;
+}
+
+// OIDC 登录接口不在 /api 下，也不要求认证
+async function authRequest<T>(endpoint: string, options: RequestInit = {}): Promise<T> {
+  const response = await fetch(`${BASE_PATH}/auth${endpoint}`, options);
+  if (!response.ok) {
+    throw new Error(`Auth request failed: ${response.status} ${response.statusText}`);
+  }
+  const data = await response.json();
+  if (data.code !== 200) {
+    throw new Error(`${data.message}`);
+  }
+  return data.data as T;
+}
+
+export async function getAuthSession(): Promise<AuthSession> {
+  return authRequest<AuthSession>('/session');
+}
+
+export async function logoutSession(): Promise<void> {
+  await authRequest<null>('/logout', { method: 'POST' });
+}
+
+export function oidcLoginURL(redirect: string): string {
+  return `${BASE_PATH}/auth/oidc/login?redirect=${encodeURIComponent(redirect)}`;
+}
+
 // Provider API functions
 export async function getProviders(filters: {
   name?: string;
@@ -583,8 +620,27 @@ export async function runHealthCheck(id: number): Promise<HealthCheckLog> {
   });
 }
 
-export async function runHealthCheckAll(): Promise<{ message: string }> {
-  return apiRequest<{ message: string }>('/health-check/run-all', {
+export interface HealthCheckBatch {
+  id: number;
+  trigger: string;
+  status: string;
+  concurrency: number;
+  provider_concurrency: number;
+  total: number;
+  completed: number;
+  succeeded: number;
+  failed: number;
+  skipped: number;
+  started_at: string;
+  finished_at?: string;
+}
+
+export async function runHealthCheckAll(): Promise<HealthCheckBatch> {
+  return apiRequest<HealthCheckBatch>('/health-check/run-all', {
     method: 'POST',
   });
 }
+
+export async function getHealthCheckBatch(): Promise<HealthCheckBatch> {
+  return apiRequest<HealthCheckBatch>('/health-check/batch');
+}
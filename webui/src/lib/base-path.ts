@@ -0,0 +1,8 @@
+declare global {
+  interface Window {
+    __LLMIO_BASE_PATH__?: string;
+  }
+}
+
+// 后端以 BASE_PATH 部署在子路径下时注入的前缀，如 /llmio；根路径部署时为空
+export const BASE_PATH = window.__LLMIO_BASE_PATH__ ?? '';
@@ -2,10 +2,12 @@ import { StrictMode } from 'react'
 import { createRoot } from 'react-dom/client'
 import './index.css'
 import App from './App.tsx'
+import { BASE_PATH } from './lib/base-path'
 
 // Check if user is authenticated
 const isAuthenticated = () => {
-  return !!localStorage.getItem('authToken');
+  // 通过 OIDC 登录时令牌保存在 HttpOnly 会话 cookie 中，这里只记录登录方式
+  return !!localStorage.getItem('authToken') || !!localStorage.getItem('authSession');
 };
 
 // Redirect to login if not authenticated (except for login page)
@@ -13,13 +15,13 @@ const ProtectedApp = () => {
   const path = window.location.pathname;
 
   // Allow access to login page without authentication
-  if (path === '/login') {
+  if (path === `${BASE_PATH}/login`) {
     return <App />;
   }
 
   // Redirect to login if not authenticated
   if (!isAuthenticated()) {
-    window.location.href = '/login';
+    window.location.href = `${BASE_PATH}/login`;
     return null;
   }
 
@@ -14,6 +14,7 @@ import {
   FaHeartbeat
 } from "react-icons/fa";
 import { useTheme } from "@/components/theme-provider";
+import { logoutSession } from "@/lib/api";
 
 export default function Layout() {
   const [sidebarOpen, setSidebarOpen] = useState(false);
@@ -25,8 +26,12 @@ export default function Layout() {
     setSidebarOpen(!sidebarOpen);
   };
 
-  const handleLogout = () => {
+  const handleLogout = async () => {
     localStorage.removeItem("authToken");
+    if (localStorage.getItem("authSession")) {
+      localStorage.removeItem("authSession");
+      await logoutSession().catch(() => undefined);
+    }
     navigate("/login");
   };
 
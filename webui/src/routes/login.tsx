@@ -1,13 +1,30 @@
-import { useState } from "react";
-import { useNavigate } from "react-router-dom";
+import { useEffect, useState } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Card, CardContent, CardDescription, CardFooter, CardHeader, CardTitle } from "@/components/ui/card";
 import { Label } from "@/components/ui/label";
+import { getAuthSession, oidcLoginURL } from "@/lib/api";
+import { BASE_PATH } from "@/lib/base-path";
 
 export default function LoginPage() {
   const [token, setToken] = useState("");
   const navigate = useNavigate();
+  const [searchParams] = useSearchParams();
+  const [oidcEnabled, setOidcEnabled] = useState(false);
+
+  useEffect(() => {
+    getAuthSession()
+      .then((session) => {
+        setOidcEnabled(session.oidc_enabled);
+        // SSO 登录回调后回到此页，会话 cookie 有效时直接进入系统
+        if (session.authenticated && searchParams.get("sso") === "1") {
+          localStorage.setItem("authSession", "oidc");
+          navigate("/");
+        }
+      })
+      .catch(() => setOidcEnabled(false));
+  }, [navigate, searchParams]);
 
   const handleLogin = (e: React.FormEvent) => {
     e.preventDefault();
@@ -42,8 +59,13 @@ export default function LoginPage() {
               />
             </div>
           </CardContent>
-          <CardFooter>
+          <CardFooter className="flex flex-col gap-2">
             <Button className="w-full mt-5" type="submit">登录</Button>
+            {oidcEnabled && (
+              <Button asChild className="w-full" variant="outline">
+                <a href={oidcLoginURL(`${BASE_PATH}/login?sso=1`)}>使用 SSO 登录</a>
+              </Button>
+            )}
           </CardFooter>
         </form>
       </Card>
@@ -67,6 +67,7 @@ import { toast } from "sonner";
 import { RefreshCw, ChevronDown, ChevronRight } from "lucide-react";
 import { Spinner } from "@/components/ui/spinner";
 import { parseAllModelsFromConfig, toProviderModelList } from "@/lib/provider-models";
+import { BASE_PATH } from "@/lib/base-path";
 
 type MobileInfoItemProps = {
   label: string;
@@ -559,7 +560,7 @@ export default function ModelProvidersPage() {
       const token = localStorage.getItem("authToken");
       const controller = new AbortController();
       currentControllerRef.current = controller;
-      await fetchEventSource(`/api/test/react/${id}`, {
+      await fetchEventSource(`${BASE_PATH}/api/test/react/${id}`, {
         method: "GET",
         headers: {
           "Authorization": `Bearer ${token}`,